@@ -1,19 +1,104 @@
 package mirageecs
 
 import (
+	"fmt"
+	"path"
+	"sort"
 	"sync"
 	"time"
 )
 
+const (
+	// DefaultAccessCounterResolution is a unit of access counters. It is 10s in local mode.
+	DefaultAccessCounterResolution = time.Minute
+	// DefaultAccessCounterRetention is a time to keep statistics of responses in memory.
+	DefaultAccessCounterRetention = 24 * time.Hour
+
+	localAccessCounterResolution = 10 * time.Second
+)
+
+// AccessCounterCfg configures counters of access to environments.
+type AccessCounterCfg struct {
+	// Resolution is a unit of counters, and an interval to put counts to CloudWatch. Default is 1m.
+	Resolution time.Duration `yaml:"resolution"`
+	// Retention is a time to keep statistics of responses in memory (for /api/access and error_alert). Default is 24h.
+	Retention time.Duration `yaml:"retention"`
+}
+
+func (c *AccessCounterCfg) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Resolution != 0 && (c.Resolution < time.Second || c.Resolution > time.Hour) {
+		return fmt.Errorf("network.access_counter.resolution must be between 1s and 1h: %s", c.Resolution)
+	}
+	if c.Retention == 0 {
+		c.Retention = DefaultAccessCounterRetention
+	}
+	if c.Retention < max(c.Resolution, time.Minute) {
+		return fmt.Errorf("network.access_counter.retention must be at least resolution and 1m: %s", c.Retention)
+	}
+	return nil
+}
+
+// resolution returns the unit of access counters.
+func (c *AccessCounterCfg) resolution(localMode bool) time.Duration {
+	switch {
+	case c != nil && c.Resolution > 0:
+		return c.Resolution
+	case localMode:
+		return localAccessCounterResolution
+	default:
+		return DefaultAccessCounterResolution
+	}
+}
+
+// retention returns the time to keep statistics of responses.
+func (c *AccessCounterCfg) retention() time.Duration {
+	if c == nil {
+		return DefaultAccessCounterRetention
+	}
+	return c.Retention
+}
+
+// latencyBounds are upper bounds of buckets of latency histograms in milliseconds.
+var latencyBounds = []int64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000}
+
 // accessCount is a map for access count
 // key is a time truncated by accessCounter.unit
 type accessCount map[time.Time]int64
 
 // accessCounter is a thread-safe counter for access
 type AccessCounter struct {
-	mu    *sync.Mutex
-	unit  time.Duration
-	count accessCount
+	mu        *sync.Mutex
+	unit      time.Duration
+	retention time.Duration
+	count     accessCount
+	last      time.Time
+	stats     map[time.Time]*AccessStats
+	dirty     map[time.Time]struct{} // times of statistics which are changed since the last drain
+}
+
+// AccessStats is statistics of responses in a time bucket.
+type AccessStats struct {
+	Time time.Time `json:"time"`
+	// Count is the number of responses including health checks.
+	Count int64 `json:"count"`
+	// HealthChecks is the number of responses to health check paths, which are not counted as access.
+	HealthChecks int64 `json:"health_checks"`
+	// Status is the number of responses by status classes. e.g. 2xx, 5xx. "error" is failures of roundtrips.
+	Status  map[string]int64   `json:"status"`
+	Latency LatencyPercentiles `json:"latency_ms"`
+
+	histogram []int64 // counts by latencyBounds, and the last is of the overflow
+	max       int64
+}
+
+// LatencyPercentiles are percentiles of latency in milliseconds, by upper bounds of histogram buckets.
+type LatencyPercentiles struct {
+	P50 int64 `json:"p50"`
+	P90 int64 `json:"p90"`
+	P99 int64 `json:"p99"`
 }
 
 // NewAccessCounter returns a new access counter
@@ -23,9 +108,12 @@ func NewAccessCounter(unit time.Duration) *AccessCounter {
 		unit = time.Minute
 	}
 	c := &AccessCounter{
-		mu:    new(sync.Mutex),
-		count: make(accessCount, 2), // 2 is enough for most cases
-		unit:  unit,
+		mu:        new(sync.Mutex),
+		count:     make(accessCount, 2), // 2 is enough for most cases
+		unit:      unit,
+		retention: DefaultAccessCounterRetention,
+		stats:     make(map[time.Time]*AccessStats),
+		dirty:     make(map[time.Time]struct{}),
 	}
 	c.fill()
 	return c
@@ -35,8 +123,15 @@ func NewAccessCounter(unit time.Duration) *AccessCounter {
 func (c *AccessCounter) Add() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	now := time.Now().Truncate(c.unit)
-	c.count[now]++
+	c.last = time.Now()
+	c.count[c.last.Truncate(c.unit)]++
+}
+
+// Last returns the time of the last access. It is zero when no access is counted.
+func (c *AccessCounter) Last() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
 }
 
 // Collect returns the access count and resets the counter
@@ -52,6 +147,240 @@ func (c *AccessCounter) Collect() accessCount {
 	return r
 }
 
+// Record records the response. status is zero when the roundtrip failed.
+func (c *AccessCounter) Record(status int, latency time.Duration, healthCheck bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	ts := now.Truncate(c.unit)
+	st, ok := c.stats[ts]
+	if !ok {
+		st = &AccessStats{
+			Time:      ts,
+			Status:    make(map[string]int64, 1),
+			histogram: make([]int64, len(latencyBounds)+1),
+		}
+		c.stats[ts] = st
+		for t := range c.stats {
+			if t.Before(now.Add(-c.retention)) {
+				delete(c.stats, t)
+			}
+		}
+	}
+	st.Count++
+	if healthCheck {
+		st.HealthChecks++
+	}
+	st.Status[statusClass(status)]++
+	ms := latency.Milliseconds()
+	i := sort.Search(len(latencyBounds), func(i int) bool {
+		return ms <= latencyBounds[i]
+	})
+	st.histogram[i]++
+	st.max = max(st.max, ms)
+	c.dirty[ts] = struct{}{}
+}
+
+// Stats returns statistics of responses since the time in the order of time.
+func (c *AccessCounter) Stats(since time.Time) []*AccessStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := make([]*AccessStats, 0, len(c.stats))
+	for t, st := range c.stats {
+		if t.Before(since.Truncate(c.unit)) {
+			continue
+		}
+		s := *st
+		s.Status = make(map[string]int64, len(st.Status))
+		for k, v := range st.Status {
+			s.Status[k] = v
+		}
+		s.Latency = LatencyPercentiles{
+			P50: st.percentile(0.5),
+			P90: st.percentile(0.9),
+			P99: st.percentile(0.99),
+		}
+		s.histogram = nil
+		stats = append(stats, &s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Time.Before(stats[j].Time)
+	})
+	return stats
+}
+
+// percentile returns the upper bound of the histogram bucket of the percentile.
+// The maximum latency is returned when the percentile is in the overflow bucket.
+func (st *AccessStats) percentile(p float64) int64 {
+	rank := int64(float64(st.Count)*p + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var n int64
+	for i, count := range st.histogram {
+		n += count
+		if n >= rank && i < len(latencyBounds) {
+			return min(latencyBounds[i], st.max)
+		}
+	}
+	return st.max
+}
+
+// statusClass returns the class of the status code. e.g. 2xx
+func statusClass(status int) string {
+	if status == 0 {
+		return "error"
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+// isHealthCheckPath reports whether the path matches any of the patterns of health check paths.
+func isHealthCheckPath(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// prune removes statistics older than the retention. Record removes them only when a new time bucket is created,
+// so statistics of counters which are no longer accessed are kept without pruning.
+func (c *AccessCounter) prune(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for t := range c.stats {
+		if t.Before(now.Add(-c.retention)) {
+			delete(c.stats, t)
+			delete(c.dirty, t)
+		}
+	}
+}
+
 func (c *AccessCounter) fill() {
 	c.count[time.Now().Truncate(c.unit)] = 0
 }
+
+// accessCounterSnapshot is a state of the access counter kept in the persistence.
+// Counts which are not collected yet are not included.
+type accessCounterSnapshot struct {
+	Last  time.Time              `json:"last"`
+	Stats []*accessStatsSnapshot `json:"stats,omitempty"`
+}
+
+type accessStatsSnapshot struct {
+	Time         time.Time        `json:"time"`
+	Count        int64            `json:"count"`
+	HealthChecks int64            `json:"health_checks"`
+	Status       map[string]int64 `json:"status"`
+	Histogram    []int64          `json:"histogram"`
+	Max          int64            `json:"max"`
+}
+
+func (c *AccessCounter) snapshot() *accessCounterSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.snapshotOf(func(time.Time) bool { return true })
+}
+
+// drain returns a snapshot which has statistics changed since the last drain. It returns nil when nothing is accessed.
+func (c *AccessCounter) drain() *accessCounterSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last.IsZero() && len(c.dirty) == 0 {
+		return nil
+	}
+	s := c.snapshotOf(func(t time.Time) bool {
+		_, ok := c.dirty[t]
+		return ok
+	})
+	clear(c.dirty)
+	return s
+}
+
+// snapshotOf returns a snapshot which has statistics of times matched with fn. c.mu must be locked.
+func (c *AccessCounter) snapshotOf(fn func(time.Time) bool) *accessCounterSnapshot {
+	s := &accessCounterSnapshot{Last: c.last}
+	for t, st := range c.stats {
+		if !fn(t) {
+			continue
+		}
+		status := make(map[string]int64, len(st.Status))
+		for k, v := range st.Status {
+			status[k] = v
+		}
+		s.Stats = append(s.Stats, &accessStatsSnapshot{
+			Time:         st.Time,
+			Count:        st.Count,
+			HealthChecks: st.HealthChecks,
+			Status:       status,
+			Histogram:    append([]int64(nil), st.histogram...),
+			Max:          st.max,
+		})
+	}
+	sort.Slice(s.Stats, func(i, j int) bool {
+		return s.Stats[i].Time.Before(s.Stats[j].Time)
+	})
+	return s
+}
+
+// restore merges the snapshot into the counter. Statistics of times which the counter has are not restored.
+func (c *AccessCounter) restore(s *accessCounterSnapshot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s.Last.After(c.last) {
+		c.last = s.Last
+	}
+	expired := time.Now().Add(-c.retention)
+	for _, st := range s.Stats {
+		ts := st.Time.Local().Truncate(c.unit) // keys of c.stats are in the local time zone
+		if _, exists := c.stats[ts]; exists || ts.Before(expired) || len(st.Histogram) != len(latencyBounds)+1 {
+			continue
+		}
+		status := make(map[string]int64, len(st.Status))
+		for k, v := range st.Status {
+			status[k] = v
+		}
+		c.stats[ts] = &AccessStats{
+			Time:         ts,
+			Count:        st.Count,
+			HealthChecks: st.HealthChecks,
+			Status:       status,
+			histogram:    append([]int64(nil), st.Histogram...),
+			max:          st.Max,
+		}
+	}
+}
+
+// add sums the snapshot (e.g. of another process) into the counter.
+func (c *AccessCounter) add(s *accessCounterSnapshot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s.Last.After(c.last) {
+		c.last = s.Last
+	}
+	for _, st := range s.Stats {
+		if len(st.Histogram) != len(latencyBounds)+1 {
+			continue
+		}
+		ts := st.Time.Local().Truncate(c.unit) // keys of c.stats are in the local time zone
+		cur, ok := c.stats[ts]
+		if !ok {
+			cur = &AccessStats{
+				Time:      ts,
+				Status:    make(map[string]int64, len(st.Status)),
+				histogram: make([]int64, len(latencyBounds)+1),
+			}
+			c.stats[ts] = cur
+		}
+		cur.Count += st.Count
+		cur.HealthChecks += st.HealthChecks
+		for k, v := range st.Status {
+			cur.Status[k] += v
+		}
+		for i, v := range st.Histogram {
+			cur.histogram[i] += v
+		}
+		cur.max = max(cur.max, st.Max)
+	}
+}
@@ -1,10 +1,12 @@
 package mirageecs_test
 
 import (
+	"net/http"
 	"testing"
 	"time"
 
 	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestAccessCounter(t *testing.T) {
@@ -36,3 +38,59 @@ func TestAccessCounter(t *testing.T) {
 		}
 	}
 }
+
+func TestAccessCounterStats(t *testing.T) {
+	c := mirageecs.NewAccessCounter(time.Hour)
+	for i := 0; i < 98; i++ {
+		c.Record(http.StatusOK, 3*time.Millisecond, false)
+	}
+	c.Record(http.StatusServiceUnavailable, 700*time.Millisecond, false)
+	c.Record(0, 45*time.Second, true)
+
+	stats := c.Stats(time.Now().Add(-time.Hour))
+	if len(stats) != 1 {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+	s := stats[0]
+	if s.Time != time.Now().Truncate(time.Hour) || s.Count != 100 || s.HealthChecks != 1 {
+		t.Errorf("unexpected stats: %#v", s)
+	}
+	if diff := cmp.Diff(map[string]int64{"2xx": 98, "5xx": 1, "error": 1}, s.Status); diff != "" {
+		t.Errorf("unexpected status classes (-want +got):\n%s", diff)
+	}
+	// percentiles are upper bounds of histogram buckets, and the maximum in the overflow bucket
+	if diff := cmp.Diff(mirageecs.LatencyPercentiles{P50: 5, P90: 5, P99: 1000}, s.Latency); diff != "" {
+		t.Errorf("unexpected latency (-want +got):\n%s", diff)
+	}
+	c.Record(0, time.Minute, false)
+	if p := c.Stats(time.Time{})[0].Latency.P99; p != 60000 {
+		t.Errorf("p99 in the overflow bucket should be the maximum: %d", p)
+	}
+	if stats := c.Stats(time.Now().Add(time.Hour)); len(stats) != 0 {
+		t.Errorf("stats since the future should be empty: %#v", stats)
+	}
+}
+
+func TestAccessCounterCfg(t *testing.T) {
+	var none *mirageecs.AccessCounterCfg
+	if none.EffectiveResolution(false) != time.Minute || none.EffectiveResolution(true) != 10*time.Second {
+		t.Errorf("unexpected default resolutions: %s %s", none.EffectiveResolution(false), none.EffectiveResolution(true))
+	}
+	c := &mirageecs.AccessCounterCfg{Resolution: 30 * time.Second}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Retention != mirageecs.DefaultAccessCounterRetention || c.EffectiveResolution(true) != 30*time.Second {
+		t.Errorf("unexpected config: %#v", c)
+	}
+	for _, c := range []*mirageecs.AccessCounterCfg{
+		{Resolution: time.Millisecond},
+		{Resolution: 2 * time.Hour},
+		{Resolution: 10 * time.Minute, Retention: 5 * time.Minute},
+		{Retention: 30 * time.Second},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected error: %#v", c)
+		}
+	}
+}
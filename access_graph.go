@@ -0,0 +1,123 @@
+package mirageecs
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// AccessGraphDuration is a duration of access graphs in the web interface.
+	AccessGraphDuration = 24 * time.Hour
+	// sparklineBuckets is the number of buckets of sparklines in the list (1 hour).
+	sparklineBuckets = 24
+	// accessGraphBuckets is the number of buckets of the graph of the access page (10 minutes).
+	accessGraphBuckets = 144
+)
+
+// AccessGraph is access counts of an environment in buckets of time, for the web interface.
+// Responses to health check paths are not counted.
+type AccessGraph struct {
+	Since   time.Time
+	Bucket  time.Duration
+	Buckets []*AccessBucket
+	// Total and Max are the sum and the maximum of counts of buckets.
+	Total int64
+	Max   int64
+}
+
+// AccessBucket is access counts in a bucket of AccessGraph.
+type AccessBucket struct {
+	Time  time.Time
+	Count int64
+	// Errors is the number of 5xx responses and failures of roundtrips.
+	Errors int64
+}
+
+// newAccessGraph aggregates statistics of responses since the time into the number of buckets.
+func newAccessGraph(stats []*AccessStats, since time.Time, duration time.Duration, buckets int) *AccessGraph {
+	g := &AccessGraph{
+		Since:   since,
+		Bucket:  duration / time.Duration(buckets),
+		Buckets: make([]*AccessBucket, buckets),
+	}
+	for i := range g.Buckets {
+		g.Buckets[i] = &AccessBucket{Time: since.Add(g.Bucket * time.Duration(i))}
+	}
+	for _, st := range stats {
+		i := int(st.Time.Sub(since) / g.Bucket)
+		if i < 0 || i >= buckets {
+			continue
+		}
+		b := g.Buckets[i]
+		b.Count += st.Count - st.HealthChecks
+		b.Errors += st.Status["5xx"] + st.Status["error"]
+	}
+	for _, b := range g.Buckets {
+		g.Total += b.Count
+		g.Max = max(g.Max, b.Count)
+	}
+	return g
+}
+
+// Points returns points of the polyline of the sparkline in the viewBox "0 0 100 20".
+func (g *AccessGraph) Points() string {
+	points := make([]string, 0, len(g.Buckets))
+	for i, b := range g.Buckets {
+		x := 100 * float64(i) / float64(max(len(g.Buckets)-1, 1))
+		y := 19.0
+		if g.Max > 0 {
+			y -= 18 * float64(b.Count) / float64(g.Max)
+		}
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+	return strings.Join(points, " ")
+}
+
+// Height returns the height of the count in percentage of the maximum.
+func (g *AccessGraph) Height(count int64) float64 {
+	if g.Max == 0 {
+		return 0
+	}
+	return 100 * float64(count) / float64(g.Max)
+}
+
+// accessGraph returns the access graph of the subdomain. It is nil without the reverse proxy.
+func (api *WebApi) accessGraph(subdomain string, now time.Time, buckets int) *AccessGraph {
+	if api.accessStats == nil {
+		return nil
+	}
+	// buckets are aligned to the time of the bucket
+	bucket := AccessGraphDuration / time.Duration(buckets)
+	since := now.Truncate(bucket).Add(bucket - AccessGraphDuration)
+	return newAccessGraph(api.accessStats(subdomain, since), since, AccessGraphDuration, buckets)
+}
+
+// setAccessGraphs sets sparklines of access counts of running and sleeping environments.
+func (api *WebApi) setAccessGraphs(infos []*Information, now time.Time) {
+	graphs := make(map[string]*AccessGraph)
+	for _, info := range infos {
+		g, ok := graphs[info.SubDomain]
+		if !ok {
+			g = api.accessGraph(info.SubDomain, now, sparklineBuckets)
+			graphs[info.SubDomain] = g
+		}
+		info.AccessGraph = g
+	}
+}
+
+// Access shows the graph of access counts of the environment.
+func (api *WebApi) Access(c echo.Context) error {
+	subdomain := c.Param("subdomain")
+	g := api.accessGraph(subdomain, time.Now(), accessGraphBuckets)
+	if g == nil {
+		return c.String(http.StatusNotFound, "access counts are not available")
+	}
+	return c.Render(http.StatusOK, "access.html", map[string]interface{}{
+		"Subdomain": subdomain,
+		"Graph":     g,
+	})
+}
@@ -0,0 +1,77 @@
+package mirageecs_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestNewAccessGraph(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	stats := []*mirageecs.AccessStats{
+		{Time: since, Count: 10, HealthChecks: 4, Status: map[string]int64{"2xx": 10}},
+		{Time: since.Add(30 * time.Minute), Count: 3, Status: map[string]int64{"2xx": 1, "5xx": 1, "error": 1}},
+		{Time: since.Add(5 * time.Hour), Count: 8, Status: map[string]int64{"2xx": 8}},
+		// out of range
+		{Time: since.Add(-time.Hour), Count: 100},
+		{Time: since.Add(24 * time.Hour), Count: 100},
+	}
+	g := mirageecs.NewAccessGraph(stats, since, 24*time.Hour, 24)
+	if g.Bucket != time.Hour || len(g.Buckets) != 24 {
+		t.Fatalf("unexpected buckets: %s %d", g.Bucket, len(g.Buckets))
+	}
+	if b := g.Buckets[0]; b.Time != since || b.Count != 9 || b.Errors != 2 {
+		t.Errorf("unexpected bucket: %#v", b)
+	}
+	if b := g.Buckets[5]; b.Time != since.Add(5*time.Hour) || b.Count != 8 || b.Errors != 0 {
+		t.Errorf("unexpected bucket: %#v", b)
+	}
+	if g.Total != 17 || g.Max != 9 {
+		t.Errorf("unexpected total %d and max %d", g.Total, g.Max)
+	}
+	if h := g.Height(3); h < 33.3 || h > 33.4 {
+		t.Errorf("unexpected height: %f", h)
+	}
+	points := strings.Fields(g.Points())
+	if len(points) != 24 || points[0] != "0.0,1.0" || points[23] != "100.0,19.0" {
+		t.Errorf("unexpected points: %v", points)
+	}
+}
+
+func TestAccessGraphPages(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	if err := m.Runner().Launch(ctx, "feature-x", nil, nil, "dummy"); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	for path, expected := range map[string]string{
+		"/list":             `<a href="/access/feature-x" target="_blank" class="d-block" title="0 requests in the last 24 hours">`,
+		"/access/feature-x": `0 requests in the last 24 hours`,
+	} {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%s: unexpected status %d", path, res.StatusCode)
+		}
+		if !strings.Contains(string(b), expected) {
+			t.Errorf("%s: %s is not found in %s", path, expected, b)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package mirageecs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/firehose"
+	firehoseTypes "github.com/aws/aws-sdk-go-v2/service/firehose/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	DefaultAccessLogExportPrefix        = "mirage-access-logs/"
+	DefaultAccessLogExportFlushInterval = time.Minute
+	DefaultAccessLogExportBatchSize     = 1000
+	DefaultAccessLogExportBufferSize    = 10000
+
+	// firehoseMaxBatchRecords and firehoseMaxBatchBytes are limits of a PutRecordBatch call.
+	firehoseMaxBatchRecords = 500
+	firehoseMaxBatchBytes   = 4 * 1024 * 1024
+	// firehoseMaxRecordBytes is the maximum size of a record of Firehose.
+	firehoseMaxRecordBytes = 1000 * 1024
+)
+
+// AccessLogExport delivers access logs of requests proxied to environments to an S3 bucket or a Firehose delivery stream in batches.
+type AccessLogExport struct {
+	// Bucket is a name of the S3 bucket. Records are put as gzipped JSON lines.
+	Bucket string `yaml:"bucket"`
+	// Prefix is a prefix of keys of S3 objects. Default is "mirage-access-logs/".
+	Prefix string `yaml:"prefix"`
+	// DeliveryStream is a name of the Firehose delivery stream. Records are put as JSON lines.
+	DeliveryStream string `yaml:"delivery_stream"`
+	// FlushInterval is the maximum interval to deliver buffered records. Default is 1m.
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	// BatchSize is the number of records to deliver at once. Default is 1000.
+	BatchSize int `yaml:"batch_size"`
+	// BufferSize is the maximum number of records waiting for delivery. Records are dropped when it is full. Default is 10000.
+	BufferSize int `yaml:"buffer_size"`
+}
+
+func (e *AccessLogExport) validate() error {
+	if e == nil {
+		return nil
+	}
+	if (e.Bucket == "") == (e.DeliveryStream == "") {
+		return fmt.Errorf("either network.access_log_export.bucket or delivery_stream is required")
+	}
+	if e.Prefix == "" {
+		e.Prefix = DefaultAccessLogExportPrefix
+	}
+	if e.FlushInterval == 0 {
+		e.FlushInterval = DefaultAccessLogExportFlushInterval
+	}
+	if e.FlushInterval < time.Second {
+		return fmt.Errorf("network.access_log_export.flush_interval must be at least 1s: %s", e.FlushInterval)
+	}
+	if e.BatchSize == 0 {
+		e.BatchSize = DefaultAccessLogExportBatchSize
+	}
+	if e.BufferSize == 0 {
+		e.BufferSize = DefaultAccessLogExportBufferSize
+	}
+	if e.BatchSize < 0 || e.BufferSize < e.BatchSize {
+		return fmt.Errorf("network.access_log_export.batch_size must be positive and buffer_size must be at least batch_size: %d %d", e.BatchSize, e.BufferSize)
+	}
+	return nil
+}
+
+// AccessLogRecord is a record of the request proxied to the environment.
+type AccessLogRecord struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	Subdomain string    `json:"subdomain"`
+	Host      string    `json:"host"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	// Status is zero when the roundtrip failed.
+	Status      int    `json:"status"`
+	LatencyMs   int64  `json:"latency_ms"`
+	RemoteIP    string `json:"remote_ip"`
+	UserAgent   string `json:"user_agent"`
+	Referer     string `json:"referer,omitempty"`
+	HealthCheck bool   `json:"health_check"`
+	Error       string `json:"error,omitempty"`
+}
+
+func newAccessLogRecord(subdomain string, req *http.Request, remoteIP string, status int, start time.Time, latency time.Duration, healthCheck bool, err error) *AccessLogRecord {
+	r := &AccessLogRecord{
+		Time:        start,
+		RequestID:   req.Header.Get(echo.HeaderXRequestID),
+		Subdomain:   subdomain,
+		Host:        req.Host,
+		Method:      req.Method,
+		URI:         req.URL.RequestURI(),
+		Status:      status,
+		LatencyMs:   latency.Milliseconds(),
+		RemoteIP:    remoteIP,
+		UserAgent:   req.UserAgent(),
+		Referer:     req.Referer(),
+		HealthCheck: healthCheck,
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// encodeAccessLogs encodes records as JSON lines.
+func encodeAccessLogs(records []*AccessLogRecord) ([][]byte, error) {
+	lines := make([][]byte, 0, len(records))
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, append(b, '\n'))
+	}
+	return lines, nil
+}
+
+// accessLogObjectKey returns the key of the S3 object of records delivered at the time.
+// Keys are partitioned by dates (dt=2006-01-02/), so Athena can query them with partition projection.
+func accessLogObjectKey(prefix string, t time.Time) string {
+	t = t.UTC()
+	return fmt.Sprintf("%sdt=%s/%s-%s.json.gz", prefix, t.Format("2006-01-02"), t.Format("20060102T150405Z"), newRequestID()[:8])
+}
+
+// firehoseBatches splits lines into batches within limits of PutRecordBatch. Too large lines are dropped.
+func firehoseBatches(lines [][]byte) [][][]byte {
+	var batches [][][]byte
+	var batch [][]byte
+	size := 0
+	for _, line := range lines {
+		if len(line) > firehoseMaxRecordBytes {
+			slog.Warn(f("access log record is too large for firehose: %d bytes", len(line)))
+			continue
+		}
+		if len(batch) == firehoseMaxBatchRecords || size+len(line) > firehoseMaxBatchBytes {
+			batches = append(batches, batch)
+			batch, size = nil, 0
+		}
+		batch = append(batch, line)
+		size += len(line)
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// accessLogExporter buffers records and delivers them in batches asynchronously.
+type accessLogExporter struct {
+	cfg     *AccessLogExport
+	ch      chan *AccessLogRecord
+	dropped atomic.Int64
+	deliver func(ctx context.Context, records []*AccessLogRecord) error
+}
+
+func newAccessLogExporter(cfg *Config) *accessLogExporter {
+	e := cfg.Network.AccessLogExport
+	if e == nil || cfg.localMode {
+		return nil
+	}
+	x := &accessLogExporter{
+		cfg: e,
+		ch:  make(chan *AccessLogRecord, e.BufferSize),
+	}
+	if e.Bucket != "" {
+		svc := s3.NewFromConfig(*cfg.awscfg)
+		x.deliver = func(ctx context.Context, records []*AccessLogRecord) error {
+			return deliverAccessLogsToS3(ctx, svc, e, records)
+		}
+	} else {
+		svc := firehose.NewFromConfig(*cfg.awscfg)
+		x.deliver = func(ctx context.Context, records []*AccessLogRecord) error {
+			return deliverAccessLogsToFirehose(ctx, svc, e, records)
+		}
+	}
+	return x
+}
+
+// put queues the record without blocking. The record is dropped when the buffer is full.
+func (x *accessLogExporter) put(r *AccessLogRecord) {
+	if x == nil {
+		return
+	}
+	select {
+	case x.ch <- r:
+	default:
+		x.dropped.Add(1)
+	}
+}
+
+// run delivers buffered records when the batch is full or at the flush interval, and at the end.
+func (x *accessLogExporter) run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	tk := time.NewTicker(x.cfg.FlushInterval)
+	defer tk.Stop()
+	batch := make([]*AccessLogRecord, 0, x.cfg.BatchSize)
+	flush := func(ctx context.Context) {
+		if n := x.dropped.Swap(0); n > 0 {
+			slog.Warn(f("dropped %d access log records because the buffer is full", n))
+		}
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, APICallTimeout)
+		defer cancel()
+		if err := x.deliver(ctx, batch); err != nil {
+			slog.Warn(f("failed to deliver %d access log records: %s", len(batch), err))
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case r := <-x.ch:
+			batch = append(batch, r)
+			if len(batch) >= x.cfg.BatchSize {
+				flush(ctx)
+			}
+		case <-tk.C:
+			flush(ctx)
+		case <-ctx.Done():
+			// deliver records buffered until the shutdown
+			for len(x.ch) > 0 {
+				batch = append(batch, <-x.ch)
+			}
+			flush(context.Background())
+			slog.Debug("access log exporter is done")
+			return
+		}
+	}
+}
+
+func deliverAccessLogsToS3(ctx context.Context, svc *s3.Client, e *AccessLogExport, records []*AccessLogRecord) error {
+	lines, err := encodeAccessLogs(records)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	for _, line := range lines {
+		if _, err := zw.Write(line); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	key := accessLogObjectKey(e.Prefix, time.Now())
+	if _, err := svc.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:          aws.String(e.Bucket),
+		Key:             aws.String(key),
+		Body:            bytes.NewReader(buf.Bytes()),
+		ContentType:     aws.String("application/x-ndjson"),
+		ContentEncoding: aws.String("gzip"),
+	}); err != nil {
+		return fmt.Errorf("failed to put s3://%s/%s: %w", e.Bucket, key, err)
+	}
+	slog.Debug(f("delivered %d access log records to s3://%s/%s", len(records), e.Bucket, key))
+	return nil
+}
+
+func deliverAccessLogsToFirehose(ctx context.Context, svc *firehose.Client, e *AccessLogExport, records []*AccessLogRecord) error {
+	lines, err := encodeAccessLogs(records)
+	if err != nil {
+		return err
+	}
+	for _, batch := range firehoseBatches(lines) {
+		input := &firehose.PutRecordBatchInput{
+			DeliveryStreamName: aws.String(e.DeliveryStream),
+			Records:            make([]firehoseTypes.Record, 0, len(batch)),
+		}
+		for _, line := range batch {
+			input.Records = append(input.Records, firehoseTypes.Record{Data: line})
+		}
+		out, err := svc.PutRecordBatch(ctx, input)
+		if err != nil {
+			return fmt.Errorf("failed to put records to %s: %w", e.DeliveryStream, err)
+		}
+		// failed records are not retried, not to block later records
+		if n := aws.ToInt32(out.FailedPutCount); n > 0 {
+			slog.Warn(f("failed to put %d of %d access log records to %s", n, len(batch), e.DeliveryStream))
+		}
+	}
+	slog.Debug(f("delivered %d access log records to %s", len(records), e.DeliveryStream))
+	return nil
+}
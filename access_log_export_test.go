@@ -0,0 +1,141 @@
+package mirageecs_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestAccessLogExportValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		export *mirageecs.AccessLogExport
+		ok     bool
+	}{
+		{"bucket", &mirageecs.AccessLogExport{Bucket: "logs"}, true},
+		{"delivery stream", &mirageecs.AccessLogExport{DeliveryStream: "mirage"}, true},
+		{"neither", &mirageecs.AccessLogExport{}, false},
+		{"both", &mirageecs.AccessLogExport{Bucket: "logs", DeliveryStream: "mirage"}, false},
+		{"short flush interval", &mirageecs.AccessLogExport{Bucket: "logs", FlushInterval: time.Millisecond}, false},
+		{"buffer smaller than batch", &mirageecs.AccessLogExport{Bucket: "logs", BatchSize: 100, BufferSize: 10}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.export.Validate()
+			if c.ok && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if !c.ok && err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestEncodeAccessLogs(t *testing.T) {
+	records := []*mirageecs.AccessLogRecord{
+		{Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), RequestID: "abc", Subdomain: "foo", Method: "GET", URI: "/?q=1", Status: 200, LatencyMs: 12},
+		{Subdomain: "bar", Status: 0, Error: "connection refused"},
+	}
+	lines, err := mirageecs.EncodeAccessLogs(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || !bytes.HasSuffix(lines[0], []byte("\n")) {
+		t.Fatalf("unexpected lines: %q", lines)
+	}
+	var r mirageecs.AccessLogRecord
+	if err := json.Unmarshal(lines[1], &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Subdomain != "bar" || r.Error != "connection refused" {
+		t.Errorf("unexpected record: %#v", r)
+	}
+	if bytes.Contains(lines[0], []byte(`"error"`)) {
+		t.Errorf("empty error must be omitted: %s", lines[0])
+	}
+}
+
+func TestAccessLogObjectKey(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 12, 4, 5, 0, time.FixedZone("JST", 9*3600))
+	key := mirageecs.AccessLogObjectKey("logs/", ts)
+	if !strings.HasPrefix(key, "logs/dt=2024-01-02/20240102T030405Z-") || !strings.HasSuffix(key, ".json.gz") {
+		t.Errorf("unexpected key: %s", key)
+	}
+	if key == mirageecs.AccessLogObjectKey("logs/", ts) {
+		t.Error("keys at the same time must be unique")
+	}
+}
+
+func TestFirehoseBatches(t *testing.T) {
+	lines := make([][]byte, 0, 1200)
+	for i := 0; i < 1200; i++ {
+		lines = append(lines, []byte("{}\n"))
+	}
+	// too large records are dropped
+	lines = append(lines, bytes.Repeat([]byte("x"), 1024*1024))
+	batches := mirageecs.FirehoseBatches(lines)
+	if len(batches) != 3 || len(batches[0]) != 500 || len(batches[2]) != 200 {
+		t.Errorf("unexpected batches: %d", len(batches))
+	}
+
+	// batches are split by the size, too
+	large := make([][]byte, 0, 10)
+	for i := 0; i < 10; i++ {
+		large = append(large, bytes.Repeat([]byte("x"), 900*1024))
+	}
+	batches = mirageecs.FirehoseBatches(large)
+	if len(batches) != 3 || len(batches[0]) != 4 {
+		t.Errorf("unexpected batches by size: %d", len(batches))
+	}
+}
+
+func TestAccessLogExporter(t *testing.T) {
+	cfg := &mirageecs.AccessLogExport{Bucket: "logs", BatchSize: 2, BufferSize: 3, FlushInterval: time.Hour}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	delivered := make(chan []string, 10)
+	x := mirageecs.NewAccessLogExporter(cfg, func(ctx context.Context, records []*mirageecs.AccessLogRecord) error {
+		subdomains := make([]string, 0, len(records))
+		for _, r := range records {
+			subdomains = append(subdomains, r.Subdomain)
+		}
+		delivered <- subdomains
+		return nil
+	})
+	// records over the buffer are dropped
+	for _, s := range []string{"a", "b", "c", "d"} {
+		x.Put(&mirageecs.AccessLogRecord{Subdomain: s})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go x.Run(ctx, &wg)
+
+	select {
+	case got := <-delivered:
+		if strings.Join(got, ",") != "a,b" {
+			t.Errorf("unexpected batch: %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("full batch must be delivered")
+	}
+	// buffered records are delivered on shutdown
+	cancel()
+	wg.Wait()
+	select {
+	case got := <-delivered:
+		if strings.Join(got, ",") != "c" {
+			t.Errorf("unexpected batch: %v", got)
+		}
+	default:
+		t.Error("buffered records must be delivered on shutdown")
+	}
+}
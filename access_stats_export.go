@@ -0,0 +1,207 @@
+package mirageecs
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	DefaultAccessStatsExportPrefix = "mirage-access-stats/"
+
+	// accessStatsExportMaxDelay is the maximum delay of the export after the hour,
+	// to wait for the last counts of the hour and counts of other processes shared by redis.
+	accessStatsExportMaxDelay = 30 * time.Minute
+)
+
+// AccessStatsExport writes hourly statistics of access to environments to an S3 bucket as CSV, for long-term reports (e.g. by Athena).
+type AccessStatsExport struct {
+	// Bucket is a name of the S3 bucket.
+	Bucket string `yaml:"bucket"`
+	// Prefix is a prefix of keys of S3 objects. Default is "mirage-access-stats/".
+	Prefix string `yaml:"prefix"`
+}
+
+func (e *AccessStatsExport) validate(retention time.Duration) error {
+	if e == nil {
+		return nil
+	}
+	if e.Bucket == "" {
+		return fmt.Errorf("network.access_stats_export.bucket is required")
+	}
+	if e.Prefix == "" {
+		e.Prefix = DefaultAccessStatsExportPrefix
+	}
+	if retention < 2*time.Hour {
+		return fmt.Errorf("network.access_stats_export requires network.access_counter.retention of at least 2h: %s", retention)
+	}
+	return nil
+}
+
+// accessStatsCSVHeader is the header of CSV of hourly statistics.
+var accessStatsCSVHeader = []string{
+	"hour", "subdomain", "count", "health_checks",
+	"status_1xx", "status_2xx", "status_3xx", "status_4xx", "status_5xx", "status_error",
+	"latency_p50_ms", "latency_p90_ms", "latency_p99_ms", "latency_max_ms",
+}
+
+// encodeAccessStats encodes statistics of subdomains in the hour as CSV with the header.
+func encodeAccessStats(hour time.Time, stats map[string]*AccessStats) ([]byte, error) {
+	subdomains := make([]string, 0, len(stats))
+	for subdomain := range stats {
+		subdomains = append(subdomains, subdomain)
+	}
+	sort.Strings(subdomains)
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(accessStatsCSVHeader); err != nil {
+		return nil, err
+	}
+	i64 := func(n int64) string { return strconv.FormatInt(n, 10) }
+	for _, subdomain := range subdomains {
+		st := stats[subdomain]
+		if err := w.Write([]string{
+			hour.UTC().Format(time.RFC3339), subdomain, i64(st.Count), i64(st.HealthChecks),
+			i64(st.Status["1xx"]), i64(st.Status["2xx"]), i64(st.Status["3xx"]), i64(st.Status["4xx"]), i64(st.Status["5xx"]), i64(st.Status["error"]),
+			i64(st.Latency.P50), i64(st.Latency.P90), i64(st.Latency.P99), i64(st.max),
+		}); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// accessStatsObjectKey returns the key of the S3 object of statistics in the hour exported by the instance.
+// Keys are partitioned by dates (dt=2006-01-02/), so Athena can query them with partition projection.
+func accessStatsObjectKey(prefix string, hour time.Time, instance string) string {
+	hour = hour.UTC()
+	return fmt.Sprintf("%sdt=%s/%s-%s.csv", prefix, hour.Format("2006-01-02"), hour.Format("20060102T15Z"), instance)
+}
+
+// accessStatsExporter puts hourly statistics to S3.
+type accessStatsExporter struct {
+	cfg      *AccessStatsExport
+	instance string
+	put      func(ctx context.Context, key string, body []byte) error
+}
+
+func newAccessStatsExporter(cfg *Config) *accessStatsExporter {
+	e := cfg.Network.AccessStatsExport
+	if e == nil || cfg.localMode {
+		return nil
+	}
+	instance, _ := os.Hostname()
+	if instance == "" {
+		instance = generateRandomHexID(8)
+	}
+	svc := s3.NewFromConfig(*cfg.awscfg)
+	return &accessStatsExporter{
+		cfg:      e,
+		instance: instance,
+		put: func(ctx context.Context, key string, body []byte) error {
+			_, err := svc.PutObject(ctx, &s3.PutObjectInput{
+				Bucket:      aws.String(e.Bucket),
+				Key:         aws.String(key),
+				Body:        bytes.NewReader(body),
+				ContentType: aws.String("text/csv"),
+			})
+			return err
+		},
+	}
+}
+
+// RunAccessStatsExporter exports statistics of the last hour every hour, and prunes expired statistics in memory.
+func (m *Mirage) RunAccessStatsExporter(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	delay := min(2*m.ReverseProxy.accessCounterUnit, accessStatsExportMaxDelay)
+	for {
+		next := time.Now().Truncate(time.Hour).Add(time.Hour)
+		timer := time.NewTimer(time.Until(next.Add(delay)))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			slog.Debug("RunAccessStatsExporter() is done")
+			return
+		}
+		if err := m.exportAccessStats(ctx, next.Add(-time.Hour)); err != nil {
+			slog.Warn(f("failed to export access statistics: %s", err))
+		}
+		m.ReverseProxy.pruneAccessStats(time.Now())
+	}
+}
+
+// exportAccessStats puts statistics of subdomains in the hour. Nothing is put when no subdomains are accessed.
+func (m *Mirage) exportAccessStats(ctx context.Context, hour time.Time) error {
+	x := m.statsExporter
+	stats := m.ReverseProxy.hourlyAccessStats(hour)
+	if len(stats) == 0 {
+		slog.Debug(f("no access statistics to export at %s", hour))
+		return nil
+	}
+	b, err := encodeAccessStats(hour, stats)
+	if err != nil {
+		return err
+	}
+	key := accessStatsObjectKey(x.cfg.Prefix, hour, x.instance)
+	ctx, cancel := context.WithTimeout(ctx, APICallTimeout)
+	defer cancel()
+	if err := x.put(ctx, key, b); err != nil {
+		return fmt.Errorf("failed to put s3://%s/%s: %w", x.cfg.Bucket, key, err)
+	}
+	slog.Info(f("exported access statistics of %d subdomains to s3://%s/%s", len(stats), x.cfg.Bucket, key))
+	return nil
+}
+
+// hourlyAccessStats returns statistics of responses of subdomains in the hour, by this process and other processes shared by redis.
+func (r *ReverseProxy) hourlyAccessStats(hour time.Time) map[string]*AccessStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	hourly := make(map[string]*AccessCounter)
+	sum := func(subdomain string, c *AccessCounter) {
+		h, ok := hourly[subdomain]
+		if !ok {
+			h = NewAccessCounter(time.Hour)
+			hourly[subdomain] = h
+		}
+		h.add(c.snapshot())
+	}
+	for subdomain, c := range r.accessCounters {
+		sum(subdomain, c)
+	}
+	for subdomain, c := range r.remoteAccess {
+		sum(subdomain, c)
+	}
+	stats := make(map[string]*AccessStats)
+	for subdomain, h := range hourly {
+		for _, st := range h.Stats(hour) {
+			if st.Time.Equal(hour) && st.Count > 0 {
+				stats[subdomain] = st
+			}
+		}
+	}
+	return stats
+}
+
+// pruneAccessStats removes expired statistics of all access counters.
+func (r *ReverseProxy) pruneAccessStats(now time.Time) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.accessCounters {
+		c.prune(now)
+	}
+	for _, c := range r.remoteAccess {
+		c.prune(now)
+	}
+}
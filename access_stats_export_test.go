@@ -0,0 +1,88 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/csv"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestAccessStatsExportValidate(t *testing.T) {
+	if err := (&mirageecs.AccessStatsExport{}).Validate(24 * time.Hour); err == nil {
+		t.Error("bucket is required")
+	}
+	if err := (&mirageecs.AccessStatsExport{Bucket: "stats"}).Validate(time.Hour); err == nil {
+		t.Error("retention shorter than 2h should be invalid")
+	}
+	e := &mirageecs.AccessStatsExport{Bucket: "stats"}
+	if err := e.Validate(24 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if e.Prefix != mirageecs.DefaultAccessStatsExportPrefix {
+		t.Errorf("unexpected default prefix: %s", e.Prefix)
+	}
+}
+
+func TestAccessStatsObjectKey(t *testing.T) {
+	hour := time.Date(2024, 1, 2, 12, 0, 0, 0, time.FixedZone("JST", 9*60*60))
+	if key := mirageecs.AccessStatsObjectKey("stats/", hour, "ip-10-0-0-1"); key != "stats/dt=2024-01-02/20240102T03Z-ip-10-0-0-1.csv" {
+		t.Errorf("unexpected key: %s", key)
+	}
+}
+
+func TestExportAccessStats(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cfg.Cleanup()
+	m := mirageecs.New(ctx, cfg)
+	rp := m.ReverseProxy
+	c := rp.AccessCounterOf("foo")
+	c.Record(http.StatusOK, 30*time.Millisecond, false)
+	c.Record(http.StatusOK, 3*time.Millisecond, true)
+	c.Record(http.StatusBadGateway, 0, false)
+	rp.AccessCounterOf("idle")
+
+	hour := time.Now().Truncate(time.Hour)
+	var key, body string
+	put := func(_ context.Context, k string, b []byte) error {
+		key, body = k, string(b)
+		return nil
+	}
+	if err := m.ExportAccessStats(ctx, hour, put); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, mirageecs.DefaultAccessStatsExportPrefix+"dt="+hour.UTC().Format("2006-01-02")+"/") {
+		t.Errorf("unexpected key: %s", key)
+	}
+	records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("unexpected records: %q", records)
+	}
+	want := []string{hour.UTC().Format(time.RFC3339), "foo", "3", "1", "0", "2", "0", "0", "1", "0", "5", "30", "30", "30"}
+	if got := strings.Join(records[1], ","); got != strings.Join(want, ",") {
+		t.Errorf("unexpected record: %s, want %s", got, strings.Join(want, ","))
+	}
+
+	key = ""
+	if err := m.ExportAccessStats(ctx, hour.Add(-time.Hour), put); err != nil {
+		t.Fatal(err)
+	}
+	if key != "" {
+		t.Errorf("nothing should be put without access: %s", key)
+	}
+
+	rp.PruneAccessStats(time.Now().Add(mirageecs.DefaultAccessCounterRetention + time.Hour))
+	if stats := rp.AccessStats("foo", time.Time{}); len(stats) != 0 {
+		t.Errorf("expired statistics should be pruned: %#v", stats)
+	}
+}
@@ -0,0 +1,376 @@
+package mirageecs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbv2Types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/samber/lo"
+)
+
+const (
+	albTargetGroupPrefix         = "mirage-"
+	DefaultALBRulePriorityOffset = 1000
+	DefaultALBHealthCheckPath    = "/"
+	maxALBRulePriority           = 50000
+	albHostHeaderConditionField  = "host-header"
+	albTargetGroupNameHashLength = 24
+)
+
+// ALBRouting configures routing requests by listener rules of an Application Load Balancer.
+// mirage-ecs manages a host-header rule and a target group per subdomain, and registers IP addresses of tasks to it.
+// So requests to environments are routed by the load balancer directly, not through mirage-ecs.
+type ALBRouting struct {
+	// ListenerArn is an ARN of the listener to add rules.
+	ListenerArn string `yaml:"listener_arn"`
+	// VpcID is an ID of the VPC of target groups.
+	VpcID string `yaml:"vpc_id"`
+	// Port is a port of targets. Default is the target port of the first listen.http.
+	Port int32 `yaml:"port"`
+	// HealthCheckPath is a path of health checks of target groups.
+	HealthCheckPath string `yaml:"health_check_path"`
+	// PriorityOffset is the lowest priority of rules managed by mirage-ecs.
+	PriorityOffset int32 `yaml:"priority_offset"`
+}
+
+func (a *ALBRouting) validate(listen Listen) error {
+	if a == nil {
+		return nil
+	}
+	if a.ListenerArn == "" {
+		return fmt.Errorf("alb_routing.listener_arn is required")
+	}
+	if a.VpcID == "" {
+		return fmt.Errorf("alb_routing.vpc_id is required")
+	}
+	if a.Port == 0 && len(listen.HTTP) > 0 {
+		a.Port = int32(listen.HTTP[0].TargetPort)
+	}
+	if a.Port <= 0 || a.Port > 65535 {
+		return fmt.Errorf("invalid alb_routing.port: %d", a.Port)
+	}
+	if a.HealthCheckPath == "" {
+		a.HealthCheckPath = DefaultALBHealthCheckPath
+	}
+	if a.PriorityOffset == 0 {
+		a.PriorityOffset = DefaultALBRulePriorityOffset
+	}
+	if a.PriorityOffset < 1 || a.PriorityOffset > maxALBRulePriority {
+		return fmt.Errorf("invalid alb_routing.priority_offset: %d", a.PriorityOffset)
+	}
+	return nil
+}
+
+// targetGroupName returns a name of the target group of the subdomain.
+// Names of target groups are limited to 32 characters, so the subdomain is hashed.
+func targetGroupName(subdomain string) string {
+	h := sha256.Sum256([]byte(subdomain))
+	return albTargetGroupPrefix + hex.EncodeToString(h[:])[:albTargetGroupNameHashLength]
+}
+
+// nextRulePriority returns the lowest unused priority from the offset.
+func nextRulePriority(used map[int32]struct{}, offset int32) (int32, error) {
+	for p := offset; p <= maxALBRulePriority; p++ {
+		if _, ok := used[p]; !ok {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("no rule priority is available")
+}
+
+// diffTargets returns IP addresses to register and to deregister.
+func diffTargets(current map[string]struct{}, desired []string) (add []string, remove []string) {
+	want := make(map[string]struct{}, len(desired))
+	for _, ip := range desired {
+		want[ip] = struct{}{}
+		if _, ok := current[ip]; !ok {
+			add = append(add, ip)
+		}
+	}
+	for ip := range current {
+		if _, ok := want[ip]; !ok {
+			remove = append(remove, ip)
+		}
+	}
+	sort.Strings(add)
+	sort.Strings(remove)
+	return add, remove
+}
+
+type albRoute struct {
+	targetGroupArn string
+	ruleArn        string
+	targets        map[string]struct{}
+}
+
+// ALBRouter manages listener rules and target groups of environments.
+type ALBRouter struct {
+	svc     *elbv2.Client
+	cfg     *ALBRouting
+	suffix  string
+	routes  map[string]*albRoute // subdomain -> route
+	adopted bool
+}
+
+func NewALBRouter(cfg *Config) *ALBRouter {
+	if cfg.Network.ALBRouting == nil {
+		return nil
+	}
+	return &ALBRouter{
+		svc:    elbv2.NewFromConfig(*cfg.awscfg),
+		cfg:    cfg.Network.ALBRouting,
+		suffix: cfg.Host.ReverseProxySuffix,
+		routes: make(map[string]*albRoute),
+	}
+}
+
+func (r *ALBRouter) host(subdomain string) string {
+	return subdomain + r.suffix
+}
+
+// Sync makes routes of the load balancer to match the desired targets.
+// desired maps subdomains to IP addresses of the tasks. nil IP addresses keep the current targets (e.g. while relaunching).
+// Routes of subdomains not in desired are deleted.
+func (r *ALBRouter) Sync(ctx context.Context, desired map[string][]string) error {
+	if r == nil {
+		return nil
+	}
+	if !r.adopted {
+		if err := r.adoptRoutes(ctx); err != nil {
+			return err
+		}
+		r.adopted = true
+	}
+	var errs []error
+	for _, subdomain := range lo.Keys(r.routes) {
+		if _, ok := desired[subdomain]; !ok {
+			if err := r.deleteRoute(ctx, subdomain); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	for subdomain, ips := range desired {
+		if ips == nil {
+			continue
+		}
+		if err := r.ensureRoute(ctx, subdomain, ips); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// adoptRoutes loads rules created by mirage-ecs before restarting.
+func (r *ALBRouter) adoptRoutes(ctx context.Context) error {
+	rules, err := r.describeRules(ctx)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		host := hostOfRule(rule)
+		if host == "" || !strings.HasSuffix(host, r.suffix) || len(rule.Actions) == 0 {
+			continue
+		}
+		subdomain := strings.TrimSuffix(host, r.suffix)
+		tg := aws.ToString(rule.Actions[0].TargetGroupArn)
+		if !strings.Contains(tg, ":targetgroup/"+targetGroupName(subdomain)+"/") {
+			continue // not managed by mirage-ecs
+		}
+		route := &albRoute{targetGroupArn: tg, ruleArn: aws.ToString(rule.RuleArn), targets: make(map[string]struct{})}
+		out, err := r.svc.DescribeTargetHealth(ctx, &elbv2.DescribeTargetHealthInput{TargetGroupArn: aws.String(tg)})
+		if err != nil {
+			return fmt.Errorf("failed to describe targets of %s: %w", tg, err)
+		}
+		for _, t := range out.TargetHealthDescriptions {
+			if t.Target != nil {
+				route.targets[aws.ToString(t.Target.Id)] = struct{}{}
+			}
+		}
+		r.routes[subdomain] = route
+		slog.Info(f("adopted the ALB route of %s", subdomain))
+	}
+	return nil
+}
+
+func hostOfRule(rule elbv2Types.Rule) string {
+	for _, c := range rule.Conditions {
+		if aws.ToString(c.Field) != albHostHeaderConditionField {
+			continue
+		}
+		if c.HostHeaderConfig != nil && len(c.HostHeaderConfig.Values) == 1 {
+			return c.HostHeaderConfig.Values[0]
+		}
+		if len(c.Values) == 1 {
+			return c.Values[0]
+		}
+	}
+	return ""
+}
+
+func (r *ALBRouter) describeRules(ctx context.Context) ([]elbv2Types.Rule, error) {
+	var rules []elbv2Types.Rule
+	in := &elbv2.DescribeRulesInput{ListenerArn: aws.String(r.cfg.ListenerArn)}
+	for {
+		out, err := r.svc.DescribeRules(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe rules of %s: %w", r.cfg.ListenerArn, err)
+		}
+		rules = append(rules, out.Rules...)
+		if out.NextMarker == nil {
+			return rules, nil
+		}
+		in.Marker = out.NextMarker
+	}
+}
+
+func (r *ALBRouter) ensureRoute(ctx context.Context, subdomain string, ips []string) error {
+	route, ok := r.routes[subdomain]
+	if !ok {
+		tg, err := r.ensureTargetGroup(ctx, subdomain, targetGroupIPAddressType(ips))
+		if err != nil {
+			return err
+		}
+		rule, err := r.createRule(ctx, subdomain, tg)
+		if err != nil {
+			return err
+		}
+		route = &albRoute{targetGroupArn: tg, ruleArn: rule, targets: make(map[string]struct{})}
+		r.routes[subdomain] = route
+	}
+	add, remove := diffTargets(route.targets, ips)
+	if len(add) > 0 {
+		_, err := r.svc.RegisterTargets(ctx, &elbv2.RegisterTargetsInput{
+			TargetGroupArn: aws.String(route.targetGroupArn),
+			Targets:        r.targetDescriptions(add),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to register targets of %s: %w", subdomain, err)
+		}
+		for _, ip := range add {
+			route.targets[ip] = struct{}{}
+		}
+		slog.Info(f("registered ALB targets of %s: %v", subdomain, add))
+	}
+	if len(remove) > 0 {
+		_, err := r.svc.DeregisterTargets(ctx, &elbv2.DeregisterTargetsInput{
+			TargetGroupArn: aws.String(route.targetGroupArn),
+			Targets:        r.targetDescriptions(remove),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to deregister targets of %s: %w", subdomain, err)
+		}
+		for _, ip := range remove {
+			delete(route.targets, ip)
+		}
+		slog.Info(f("deregistered ALB targets of %s: %v", subdomain, remove))
+	}
+	return nil
+}
+
+func (r *ALBRouter) targetDescriptions(ips []string) []elbv2Types.TargetDescription {
+	targets := make([]elbv2Types.TargetDescription, 0, len(ips))
+	for _, ip := range ips {
+		targets = append(targets, elbv2Types.TargetDescription{Id: aws.String(ip), Port: aws.Int32(r.cfg.Port)})
+	}
+	return targets
+}
+
+// targetGroupIPAddressType returns the IP address type of the target group of the targets.
+func targetGroupIPAddressType(ips []string) elbv2Types.TargetGroupIpAddressTypeEnum {
+	for _, ip := range ips {
+		if addr, err := netip.ParseAddr(ip); err == nil && addr.Is6() && !addr.Is4In6() {
+			return elbv2Types.TargetGroupIpAddressTypeEnumIpv6
+		}
+	}
+	return elbv2Types.TargetGroupIpAddressTypeEnumIpv4
+}
+
+func (r *ALBRouter) ensureTargetGroup(ctx context.Context, subdomain string, ipType elbv2Types.TargetGroupIpAddressTypeEnum) (string, error) {
+	name := targetGroupName(subdomain)
+	out, err := r.svc.DescribeTargetGroups(ctx, &elbv2.DescribeTargetGroupsInput{Names: []string{name}})
+	var notFound *elbv2Types.TargetGroupNotFoundException
+	switch {
+	case err == nil && len(out.TargetGroups) > 0:
+		return aws.ToString(out.TargetGroups[0].TargetGroupArn), nil
+	case err != nil && !errors.As(err, &notFound):
+		return "", fmt.Errorf("failed to describe target group %s: %w", name, err)
+	}
+	created, err := r.svc.CreateTargetGroup(ctx, &elbv2.CreateTargetGroupInput{
+		Name:            aws.String(name),
+		Protocol:        elbv2Types.ProtocolEnumHttp,
+		Port:            aws.Int32(r.cfg.Port),
+		VpcId:           aws.String(r.cfg.VpcID),
+		TargetType:      elbv2Types.TargetTypeEnumIp,
+		IpAddressType:   ipType,
+		HealthCheckPath: aws.String(r.cfg.HealthCheckPath),
+		Tags: []elbv2Types.Tag{
+			{Key: aws.String(TagManagedBy), Value: aws.String(TagValueMirage)},
+			{Key: aws.String(TagSubdomain), Value: aws.String(encodeTagValue(subdomain))},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create target group %s: %w", name, err)
+	}
+	slog.Info(f("created target group %s for %s", name, subdomain))
+	return aws.ToString(created.TargetGroups[0].TargetGroupArn), nil
+}
+
+func (r *ALBRouter) createRule(ctx context.Context, subdomain, targetGroupArn string) (string, error) {
+	rules, err := r.describeRules(ctx)
+	if err != nil {
+		return "", err
+	}
+	used := make(map[int32]struct{}, len(rules))
+	for _, rule := range rules {
+		if p, err := strconv.Atoi(aws.ToString(rule.Priority)); err == nil {
+			used[int32(p)] = struct{}{}
+		}
+	}
+	priority, err := nextRulePriority(used, r.cfg.PriorityOffset)
+	if err != nil {
+		return "", err
+	}
+	out, err := r.svc.CreateRule(ctx, &elbv2.CreateRuleInput{
+		ListenerArn: aws.String(r.cfg.ListenerArn),
+		Priority:    aws.Int32(priority),
+		Conditions: []elbv2Types.RuleCondition{
+			{
+				Field:            aws.String(albHostHeaderConditionField),
+				HostHeaderConfig: &elbv2Types.HostHeaderConditionConfig{Values: []string{r.host(subdomain)}},
+			},
+		},
+		Actions: []elbv2Types.Action{
+			{Type: elbv2Types.ActionTypeEnumForward, TargetGroupArn: aws.String(targetGroupArn)},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create a rule for %s: %w", subdomain, err)
+	}
+	slog.Info(f("created an ALB rule for %s (priority %d)", r.host(subdomain), priority))
+	return aws.ToString(out.Rules[0].RuleArn), nil
+}
+
+func (r *ALBRouter) deleteRoute(ctx context.Context, subdomain string) error {
+	route := r.routes[subdomain]
+	var ruleNotFound *elbv2Types.RuleNotFoundException
+	if _, err := r.svc.DeleteRule(ctx, &elbv2.DeleteRuleInput{RuleArn: aws.String(route.ruleArn)}); err != nil && !errors.As(err, &ruleNotFound) {
+		return fmt.Errorf("failed to delete the rule of %s: %w", subdomain, err)
+	}
+	// a target group can be deleted after the rule which refers it is deleted
+	if _, err := r.svc.DeleteTargetGroup(ctx, &elbv2.DeleteTargetGroupInput{TargetGroupArn: aws.String(route.targetGroupArn)}); err != nil {
+		return fmt.Errorf("failed to delete the target group of %s: %w", subdomain, err)
+	}
+	delete(r.routes, subdomain)
+	slog.Info(f("deleted the ALB route of %s", subdomain))
+	return nil
+}
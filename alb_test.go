@@ -0,0 +1,89 @@
+package mirageecs_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	elbv2Types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/google/go-cmp/cmp"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestALBRoutingValidate(t *testing.T) {
+	listen := mirageecs.Listen{HTTP: []mirageecs.PortMap{{ListenPort: 80, TargetPort: 8080}}}
+	a := &mirageecs.ALBRouting{ListenerArn: "arn:listener", VpcID: "vpc-123"}
+	if err := a.Validate(listen); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.Port != 8080 || a.HealthCheckPath != "/" || a.PriorityOffset != mirageecs.DefaultALBRulePriorityOffset {
+		t.Errorf("unexpected defaults: %#v", a)
+	}
+	if err := (&mirageecs.ALBRouting{VpcID: "vpc-123"}).Validate(listen); err == nil {
+		t.Error("expected error without listener_arn")
+	}
+	if err := (&mirageecs.ALBRouting{ListenerArn: "arn:listener"}).Validate(listen); err == nil {
+		t.Error("expected error without vpc_id")
+	}
+	if err := (&mirageecs.ALBRouting{ListenerArn: "arn:listener", VpcID: "vpc-123"}).Validate(mirageecs.Listen{}); err == nil {
+		t.Error("expected error without port")
+	}
+}
+
+func TestTargetGroupName(t *testing.T) {
+	name := mirageecs.TargetGroupName("a-very-long-subdomain-for-a-pull-request-1234")
+	if len(name) > 32 {
+		t.Errorf("too long target group name: %s", name)
+	}
+	if name != mirageecs.TargetGroupName("a-very-long-subdomain-for-a-pull-request-1234") {
+		t.Error("target group name must be deterministic")
+	}
+	if name == mirageecs.TargetGroupName("another") {
+		t.Error("target group names must differ between subdomains")
+	}
+}
+
+func TestNextRulePriority(t *testing.T) {
+	used := map[int32]struct{}{1000: {}, 1001: {}, 1003: {}}
+	if p, err := mirageecs.NextRulePriority(used, 1000); err != nil || p != 1002 {
+		t.Errorf("unexpected priority: %d %v", p, err)
+	}
+	if _, err := mirageecs.NextRulePriority(map[int32]struct{}{50000: {}}, 50000); err == nil {
+		t.Error("expected error when no priority is available")
+	}
+}
+
+func TestDiffTargets(t *testing.T) {
+	current := map[string]struct{}{"10.0.0.1": {}, "10.0.0.2": {}}
+	add, remove := mirageecs.DiffTargets(current, []string{"10.0.0.2", "10.0.0.3"})
+	if diff := cmp.Diff([]string{"10.0.0.3"}, add); diff != "" {
+		t.Errorf("unexpected targets to add (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"10.0.0.1"}, remove); diff != "" {
+		t.Errorf("unexpected targets to remove (-want +got):\n%s", diff)
+	}
+}
+
+func TestHostOfRule(t *testing.T) {
+	rule := elbv2Types.Rule{
+		Conditions: []elbv2Types.RuleCondition{
+			{Field: aws.String("path-pattern"), Values: []string{"/api/*"}},
+			{Field: aws.String("host-header"), HostHeaderConfig: &elbv2Types.HostHeaderConditionConfig{Values: []string{"pr1.dev.example.net"}}},
+		},
+	}
+	if host := mirageecs.HostOfRule(rule); host != "pr1.dev.example.net" {
+		t.Errorf("unexpected host: %s", host)
+	}
+	if host := mirageecs.HostOfRule(elbv2Types.Rule{IsDefault: true}); host != "" {
+		t.Errorf("unexpected host: %s", host)
+	}
+}
+
+func TestTargetGroupIPAddressType(t *testing.T) {
+	if typ := mirageecs.TargetGroupIPAddressType([]string{"10.0.0.1"}); typ != elbv2Types.TargetGroupIpAddressTypeEnumIpv4 {
+		t.Errorf("unexpected type: %s", typ)
+	}
+	if typ := mirageecs.TargetGroupIPAddressType([]string{"2001:db8::1"}); typ != elbv2Types.TargetGroupIpAddressTypeEnumIpv6 {
+		t.Errorf("unexpected type: %s", typ)
+	}
+}
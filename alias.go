@@ -0,0 +1,304 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53Types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/samber/lo"
+)
+
+const TagAliases = "MirageAliases"
+
+// hostnameLabelRegexp matches a DNS label of hostnames of aliases.
+var hostnameLabelRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Aliases configures custom domain aliases of environments.
+// Aliases are extra hostnames attached to existing environments by the API.
+type Aliases struct {
+	// Domains are domains allowed for aliases.
+	Domains []*AliasDomain `yaml:"domains"`
+	// ListenerArns are ARNs of HTTPS listeners of load balancers to attach certificates of aliases.
+	ListenerArns []string `yaml:"listener_arns"`
+}
+
+// AliasDomain configures a domain allowed for aliases.
+type AliasDomain struct {
+	// Domain is a domain of aliases. The domain itself and its subdomains are allowed.
+	Domain string `yaml:"domain"`
+	// Records creates records of aliases in the hosted zone of the domain.
+	Records *Records `yaml:"records"`
+	// Certificate provisions an ACM certificate per alias. Records are required to validate certificates.
+	Certificate bool `yaml:"certificate"`
+}
+
+func (a *Aliases) validate() error {
+	if a == nil {
+		return nil
+	}
+	if len(a.Domains) == 0 {
+		return fmt.Errorf("aliases.domains is required")
+	}
+	for _, d := range a.Domains {
+		d.Domain = strings.Trim(strings.ToLower(d.Domain), ".")
+		if d.Domain == "" {
+			return fmt.Errorf("aliases.domains[].domain is required")
+		}
+		if err := d.Records.validate(); err != nil {
+			return fmt.Errorf("aliases.domains %s: %w", d.Domain, err)
+		}
+		if d.Certificate && d.Records == nil {
+			return fmt.Errorf("aliases.domains %s: certificate requires records", d.Domain)
+		}
+	}
+	return nil
+}
+
+// domainFor returns the domain of the alias hostname.
+// The most specific domain is used if domains are nested.
+func (a *Aliases) domainFor(hostname string) (*AliasDomain, bool) {
+	var found *AliasDomain
+	for _, d := range a.Domains {
+		if hostname != d.Domain && !strings.HasSuffix(hostname, "."+d.Domain) {
+			continue
+		}
+		if found == nil || len(d.Domain) > len(found.Domain) {
+			found = d
+		}
+	}
+	return found, found != nil
+}
+
+// validateHostname validates the hostname of an alias.
+func (a *Aliases) validateHostname(hostname string, h Host) error {
+	if a == nil {
+		return fmt.Errorf("aliases are not allowed. set host.aliases")
+	}
+	if len(hostname) > 253 {
+		return fmt.Errorf("hostname %s is too long", hostname)
+	}
+	for _, label := range strings.Split(hostname, ".") {
+		if !hostnameLabelRegexp.MatchString(label) {
+			return fmt.Errorf("invalid hostname: %s", hostname)
+		}
+	}
+	if isSameHost(hostname, h.WebApi) || strings.HasSuffix(hostname, h.ReverseProxySuffix) {
+		return fmt.Errorf("hostname %s conflicts with hosts of mirage-ecs", hostname)
+	}
+	if _, ok := a.domainFor(hostname); !ok {
+		return fmt.Errorf("hostname %s is not in the allowed domains", hostname)
+	}
+	return nil
+}
+
+// formatAliases returns aliases in the form of a tag value "host1 host2 ...", sorted and deduplicated.
+func formatAliases(aliases []string) string {
+	aliases = lo.Uniq(aliases)
+	sort.Strings(aliases)
+	return strings.Join(aliases, " ")
+}
+
+func parseAliases(v string) []string {
+	return strings.Fields(v)
+}
+
+func (o *LaunchOption) aliases() []string {
+	if o == nil {
+		return nil
+	}
+	return o.Aliases
+}
+
+// withAlias returns aliases with the hostname added or removed.
+func withAlias(aliases []string, hostname string, add bool) []string {
+	aliases = lo.Without(aliases, hostname)
+	if add {
+		aliases = append(aliases, hostname)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// SetAliases replaces aliases of the subdomain.
+func (r *ReverseProxy) SetAliases(subdomain string, aliases []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeAliases(subdomain)
+	for _, hostname := range aliases {
+		if parent, exists := r.aliases[hostname]; exists {
+			slog.Warn(f("alias %s of subdomain %s conflicts with subdomain %s. ignored", hostname, subdomain, parent))
+			continue
+		}
+		r.aliases[hostname] = subdomain
+	}
+}
+
+// removeAliases removes aliases of the subdomain. r.mu must be locked.
+func (r *ReverseProxy) removeAliases(subdomain string) {
+	for hostname, parent := range r.aliases {
+		if parent == subdomain {
+			delete(r.aliases, hostname)
+		}
+	}
+}
+
+// AliasOf returns the subdomain of the alias hostname.
+func (r *ReverseProxy) AliasOf(hostname string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	subdomain, ok := r.aliases[hostname]
+	return subdomain, ok
+}
+
+// Aliases returns all alias hostnames.
+func (r *ReverseProxy) Aliases() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return lo.Keys(r.aliases)
+}
+
+// ServeAlias serves the request to the alias hostname by the environment of the subdomain.
+func (r *ReverseProxy) ServeAlias(w http.ResponseWriter, req *http.Request, subdomain string, port int) {
+	r.serveSubdomain(w, req, subdomain, port)
+}
+
+// AliasManager creates records and certificates of aliases.
+type AliasManager struct {
+	cfg     *Config
+	aliases *Aliases
+	r53Svc  *route53.Client
+
+	mu      sync.Mutex
+	records map[string]*AliasDomain // hostnames which have records
+	certs   map[string]struct{}     // hostnames whose certificates are provisioned or in progress
+}
+
+func NewAliasManager(cfg *Config) *AliasManager {
+	if cfg.Host.Aliases == nil {
+		return nil
+	}
+	return &AliasManager{
+		cfg:     cfg,
+		aliases: cfg.Host.Aliases,
+		r53Svc:  route53.NewFromConfig(*cfg.awscfg),
+		records: make(map[string]*AliasDomain),
+		certs:   make(map[string]struct{}),
+	}
+}
+
+// Sync creates records and certificates of current aliases, and deletes records of removed aliases.
+// Certificates are kept after aliases are removed, because ACM doesn't charge for them and the alias may come back.
+func (m *AliasManager) Sync(ctx context.Context, hostnames []string) {
+	if m == nil {
+		return
+	}
+	current := make(map[string]struct{}, len(hostnames))
+	for _, hostname := range hostnames {
+		current[hostname] = struct{}{}
+		d, ok := m.aliases.domainFor(hostname)
+		if !ok {
+			slog.Warn(f("alias %s is not in the allowed domains", hostname))
+			continue
+		}
+		if d.Records != nil {
+			m.changeRecord(ctx, hostname, d, r53Types.ChangeActionUpsert)
+		}
+		if d.Certificate {
+			m.ensureCertificate(ctx, hostname, d)
+		}
+	}
+	for _, hostname := range lo.Keys(m.records) {
+		if _, ok := current[hostname]; !ok {
+			m.changeRecord(ctx, hostname, m.records[hostname], r53Types.ChangeActionDelete)
+		}
+	}
+}
+
+func (m *AliasManager) changeRecord(ctx context.Context, hostname string, d *AliasDomain, action r53Types.ChangeAction) {
+	if _, ok := m.records[hostname]; ok == (action == r53Types.ChangeActionUpsert) {
+		return
+	}
+	_, err := m.r53Svc.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(d.Records.HostedZoneID),
+		ChangeBatch: &r53Types.ChangeBatch{
+			Changes: d.Records.changes(hostname, action),
+			Comment: aws.String("alias managed by mirage-ecs"),
+		},
+	})
+	if err != nil {
+		slog.Warn(f("failed to %s the record of alias %s: %s", action, hostname, err))
+		return
+	}
+	if action == r53Types.ChangeActionUpsert {
+		m.records[hostname] = d
+	} else {
+		delete(m.records, hostname)
+	}
+	slog.Info(f("alias record change: %s %s", action, hostname))
+}
+
+// ensureCertificate provisions the certificate of the alias in background, because validation takes minutes.
+func (m *AliasManager) ensureCertificate(ctx context.Context, hostname string, d *AliasDomain) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.certs[hostname]; ok {
+		return
+	}
+	m.certs[hostname] = struct{}{}
+	cm := newCertificateManager(m.cfg, &Certificate{
+		Domain:       hostname,
+		HostedZoneID: d.Records.HostedZoneID,
+		ListenerArns: m.aliases.ListenerArns,
+		exact:        true,
+	})
+	go func() {
+		if err := cm.ensure(ctx); err != nil {
+			slog.Warn(f("certificate of alias %s: %s", hostname, err))
+			// retry in the next sync
+			m.mu.Lock()
+			delete(m.certs, hostname)
+			m.mu.Unlock()
+		}
+	}()
+}
+
+// SetAliases replaces aliases of environments of the subdomain.
+// Aliases are stored in tags of tasks and services, so tasks replaced by services inherit them.
+func (e *ECS) SetAliases(ctx context.Context, subdomain string, aliases []string) error {
+	arns, err := e.resourceArns(ctx, subdomain)
+	if err != nil {
+		return err
+	}
+	v := formatAliases(aliases)
+	if len(v) > maxTagValueLength {
+		return fmt.Errorf("aliases are too long to store in a tag")
+	}
+	for _, arn := range arns {
+		if v == "" {
+			_, err = e.svc.UntagResource(ctx, &ecs.UntagResourceInput{
+				ResourceArn: aws.String(arn),
+				TagKeys:     []string{TagAliases},
+			})
+		} else {
+			_, err = e.svc.TagResource(ctx, &ecs.TagResourceInput{
+				ResourceArn: aws.String(arn),
+				Tags:        []types.Tag{{Key: aws.String(TagAliases), Value: aws.String(v)}},
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to tag aliases of %s: %w", arn, err)
+		}
+	}
+	slog.Info(f("aliases of subdomain %s: %s", subdomain, v))
+	return nil
+}
@@ -0,0 +1,130 @@
+package mirageecs_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestAliasesValidate(t *testing.T) {
+	records := &mirageecs.Records{HostedZoneID: "Z1", Target: []string{"mirage.example.net"}}
+	cases := []struct {
+		name string
+		a    *mirageecs.Aliases
+		ok   bool
+	}{
+		{"nil", nil, true},
+		{"valid", &mirageecs.Aliases{Domains: []*mirageecs.AliasDomain{{Domain: "customer.com"}}}, true},
+		{"with certificate", &mirageecs.Aliases{Domains: []*mirageecs.AliasDomain{{Domain: "customer.com", Records: records, Certificate: true}}}, true},
+		{"no domains", &mirageecs.Aliases{}, false},
+		{"empty domain", &mirageecs.Aliases{Domains: []*mirageecs.AliasDomain{{Domain: "."}}}, false},
+		{"certificate without records", &mirageecs.Aliases{Domains: []*mirageecs.AliasDomain{{Domain: "customer.com", Certificate: true}}}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.a.Validate(); (err == nil) != tc.ok {
+				t.Errorf("unexpected result: %v", err)
+			}
+		})
+	}
+}
+
+func TestAliasesValidateHostname(t *testing.T) {
+	a := &mirageecs.Aliases{Domains: []*mirageecs.AliasDomain{
+		{Domain: ".Customer.com."},
+		{Domain: "demo.customer.com"},
+	}}
+	if err := a.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	h := mirageecs.Host{WebApi: "mirage.dev.example.net", ReverseProxySuffix: ".dev.example.net"}
+	cases := map[string]bool{
+		"demo.customer.com":          true,
+		"customer.com":               true,
+		"a.b.customer.com":           true,
+		"evilcustomer.com":           false,
+		"demo.example.com":           false,
+		"pr1.dev.example.net":        false,
+		"mirage.dev.example.net":     false,
+		"-demo.customer.com":         false,
+		"demo..customer.com":         false,
+		"demo_1.customer.com":        false,
+		"*.customer.com":             false,
+		"UPPER.customer.com":         false,
+		"customer.com.dev.example.n": false,
+	}
+	for hostname, ok := range cases {
+		if err := a.ValidateHostname(hostname, h); (err == nil) != ok {
+			t.Errorf("%s: unexpected result: %v", hostname, err)
+		}
+	}
+	if d, ok := a.DomainFor("x.demo.customer.com"); !ok || d.Domain != "demo.customer.com" {
+		t.Errorf("the most specific domain must be used: %v", d)
+	}
+	var none *mirageecs.Aliases
+	if err := none.ValidateHostname("demo.customer.com", h); err == nil {
+		t.Error("expected error without host.aliases")
+	}
+}
+
+func TestFormatAliases(t *testing.T) {
+	v := mirageecs.FormatAliases([]string{"b.customer.com", "a.customer.com", "b.customer.com"})
+	if v != "a.customer.com b.customer.com" {
+		t.Errorf("unexpected tag value: %s", v)
+	}
+	if diff := cmp.Diff([]string{"a.customer.com", "b.customer.com"}, mirageecs.ParseAliases(v)); diff != "" {
+		t.Errorf("unexpected aliases (-want +got):\n%s", diff)
+	}
+	if got := mirageecs.ParseAliases(""); len(got) != 0 {
+		t.Errorf("unexpected aliases: %v", got)
+	}
+}
+
+func TestWithAlias(t *testing.T) {
+	aliases := mirageecs.WithAlias([]string{"b.customer.com"}, "a.customer.com", true)
+	if diff := cmp.Diff([]string{"a.customer.com", "b.customer.com"}, aliases); diff != "" {
+		t.Errorf("unexpected aliases (-want +got):\n%s", diff)
+	}
+	aliases = mirageecs.WithAlias(aliases, "a.customer.com", true)
+	if len(aliases) != 2 {
+		t.Errorf("aliases must not be duplicated: %v", aliases)
+	}
+	aliases = mirageecs.WithAlias(aliases, "b.customer.com", false)
+	if diff := cmp.Diff([]string{"a.customer.com"}, aliases); diff != "" {
+		t.Errorf("unexpected aliases (-want +got):\n%s", diff)
+	}
+}
+
+func TestReverseProxyAliases(t *testing.T) {
+	cfg, err := mirageecs.NewConfig(context.Background(), &mirageecs.ConfigParams{
+		Domain: "example.net",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Listen.HTTP = []mirageecs.PortMap{{ListenPort: 80, TargetPort: 80}}
+	rp := mirageecs.NewReverseProxy(cfg)
+	rp.AddSubdomain("pr123", "192.168.1.1", 80, nil)
+	rp.AddSubdomain("pr456", "192.168.1.2", 80, nil)
+	rp.SetAliases("pr123", []string{"demo.customer.com"})
+	rp.SetAliases("pr456", []string{"demo.customer.com", "other.customer.com"})
+
+	if subdomain, ok := rp.AliasOf("demo.customer.com"); !ok || subdomain != "pr123" {
+		t.Errorf("conflicted alias must be kept: %s", subdomain)
+	}
+	if subdomain, ok := rp.AliasOf("other.customer.com"); !ok || subdomain != "pr456" {
+		t.Errorf("unexpected alias: %s", subdomain)
+	}
+
+	rp.SetAliases("pr456", nil)
+	if _, ok := rp.AliasOf("other.customer.com"); ok {
+		t.Error("alias must be removed")
+	}
+	rp.RemoveSubdomain("pr123")
+	if _, ok := rp.AliasOf("demo.customer.com"); ok {
+		t.Error("alias must be removed with the subdomain")
+	}
+}
@@ -18,6 +18,8 @@ type Auth struct {
 	Token        *AuthMethodToken    `yaml:"token"`
 	AmznOIDC     *AuthMethodAmznOIDC `yaml:"amzn_oidc"`
 	CookieSecret string              `yaml:"cookie_secret"`
+	// Admin is a token for admin operations (e.g. /api/terminate_all). They are disabled without it.
+	Admin *AuthMethodToken `yaml:"admin"`
 
 	jwtParser  *jwt.Parser
 	jwtKeyFunc func(*jwt.Token) (interface{}, error)
@@ -52,6 +54,18 @@ func (a *Auth) ByToken(req *http.Request, res http.ResponseWriter) (bool, error)
 	return false, nil
 }
 
+func (a *Auth) ByAdminToken(req *http.Request, res http.ResponseWriter) (bool, error) {
+	if a == nil || a.Admin == nil {
+		return false, nil
+	}
+	if ok := a.Admin.Match(req.Header); ok {
+		slog.Debug("admin token auth succeeded")
+		return ok, nil
+	}
+	slog.Debug("admin token auth failed")
+	return false, nil
+}
+
 func (a *Auth) ByAmznOIDC(req *http.Request, res http.ResponseWriter) (bool, error) {
 	if a == nil || a.AmznOIDC == nil {
 		return false, nil
@@ -0,0 +1,202 @@
+package mirageecs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+)
+
+// TagBitbucket is a tag of the repository and the commit (workspace/repo@hash) of environments launched by webhooks of Bitbucket.
+const TagBitbucket = "MirageBitbucket"
+
+// Bitbucket receives webhooks of pull requests from Bitbucket Cloud and Bitbucket Server (Data Center) at /hooks/bitbucket,
+// to launch and terminate environments of pull requests the same way as GitHub.
+type Bitbucket struct {
+	// WebhookSecret is a secret of the webhook to verify signatures of payloads.
+	WebhookSecret string `yaml:"webhook_secret"`
+	// Repositories are full names of repositories to accept. e.g. workspace/repo for Bitbucket Cloud, PROJECT/repo for Bitbucket Server.
+	// Empty accepts all repositories.
+	Repositories []string `yaml:"repositories"`
+	// Launch is a template to launch environments of pull requests.
+	Launch *PullRequestLaunch `yaml:"launch"`
+}
+
+func (b *Bitbucket) validate(taskDefinitionTemplate string) error {
+	if b == nil {
+		return nil
+	}
+	if b.WebhookSecret == "" {
+		return fmt.Errorf("bitbucket.webhook_secret is required")
+	}
+	if b.Launch == nil {
+		return fmt.Errorf("bitbucket.launch is required")
+	}
+	return b.Launch.validate("bitbucket.launch", taskDefinitionTemplate)
+}
+
+// accepts reports whether webhooks of the repository are accepted.
+func (b *Bitbucket) accepts(repository string) bool {
+	return len(b.Repositories) == 0 || lo.ContainsBy(b.Repositories, func(r string) bool {
+		return strings.EqualFold(r, repository)
+	})
+}
+
+// bitbucketCloudEvent is a payload of pullrequest:* events of Bitbucket Cloud.
+type bitbucketCloudEvent struct {
+	PullRequest struct {
+		ID     int    `json:"id"`
+		Title  string `json:"title"`
+		Source struct {
+			Branch struct {
+				Name string `json:"name"`
+			} `json:"branch"`
+			Commit struct {
+				Hash string `json:"hash"`
+			} `json:"commit"`
+		} `json:"source"`
+		Author struct {
+			Nickname string `json:"nickname"`
+		} `json:"author"`
+	} `json:"pullrequest"`
+	Repository struct {
+		Name     string `json:"name"`
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Actor struct {
+		Nickname string `json:"nickname"`
+	} `json:"actor"`
+}
+
+func (ev *bitbucketCloudEvent) pullRequest() *pullRequest {
+	pr := ev.PullRequest
+	return &pullRequest{
+		Provider:       "bitbucket",
+		Repository:     ev.Repository.FullName,
+		RepositoryName: ev.Repository.Name,
+		Number:         pr.ID,
+		Title:          pr.Title,
+		Branch:         pr.Source.Branch.Name,
+		SHA:            pr.Source.Commit.Hash,
+		Author:         pr.Author.Nickname,
+		Sender:         ev.Actor.Nickname,
+	}
+}
+
+// bitbucketServerEvent is a payload of pr:* events of Bitbucket Server.
+type bitbucketServerEvent struct {
+	PullRequest struct {
+		ID      int    `json:"id"`
+		Title   string `json:"title"`
+		FromRef struct {
+			DisplayID    string `json:"displayId"`
+			LatestCommit string `json:"latestCommit"`
+		} `json:"fromRef"`
+		ToRef struct {
+			Repository struct {
+				Slug    string `json:"slug"`
+				Project struct {
+					Key string `json:"key"`
+				} `json:"project"`
+			} `json:"repository"`
+		} `json:"toRef"`
+		Author struct {
+			User struct {
+				Name string `json:"name"`
+			} `json:"user"`
+		} `json:"author"`
+	} `json:"pullRequest"`
+	Actor struct {
+		Name string `json:"name"`
+	} `json:"actor"`
+}
+
+func (ev *bitbucketServerEvent) pullRequest() *pullRequest {
+	pr := ev.PullRequest
+	repo := pr.ToRef.Repository
+	return &pullRequest{
+		Provider:       "bitbucket",
+		Repository:     repo.Project.Key + "/" + repo.Slug,
+		RepositoryName: repo.Slug,
+		Number:         pr.ID,
+		Title:          pr.Title,
+		Branch:         pr.FromRef.DisplayID,
+		SHA:            pr.FromRef.LatestCommit,
+		Author:         pr.Author.User.Name,
+		Sender:         ev.Actor.Name,
+	}
+}
+
+// HookBitbucket launches and terminates environments of pull requests by webhooks of Bitbucket Cloud and Bitbucket Server.
+func (api *WebApi) HookBitbucket(c echo.Context) error {
+	b := api.cfg.Bitbucket
+	if b == nil {
+		return c.JSON(http.StatusNotFound, APIHookResponse{Result: "bitbucket is not configured"})
+	}
+	body, err := readWebhookPayload(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIHookResponse{Result: err.Error()})
+	}
+	if !verifyHubSignature(b.WebhookSecret, body, c.Request().Header.Get("X-Hub-Signature")) {
+		return c.JSON(http.StatusUnauthorized, APIHookResponse{Result: "invalid signature"})
+	}
+	var pr *pullRequest
+	var terminate bool
+	event := c.Request().Header.Get("X-Event-Key")
+	switch event {
+	case "diagnostics:ping":
+		return c.JSON(http.StatusOK, APIHookResponse{Result: "pong"})
+	case "pullrequest:created", "pullrequest:updated", "pullrequest:fulfilled", "pullrequest:rejected":
+		var ev bitbucketCloudEvent
+		if err := json.NewDecoder(bytes.NewReader(body)).Decode(&ev); err != nil {
+			return c.JSON(http.StatusBadRequest, APIHookResponse{Result: err.Error()})
+		}
+		pr = ev.pullRequest()
+		terminate = event == "pullrequest:fulfilled" || event == "pullrequest:rejected"
+	case "pr:opened", "pr:from_ref_updated", "pr:merged", "pr:declined", "pr:deleted":
+		var ev bitbucketServerEvent
+		if err := json.NewDecoder(bytes.NewReader(body)).Decode(&ev); err != nil {
+			return c.JSON(http.StatusBadRequest, APIHookResponse{Result: err.Error()})
+		}
+		pr = ev.pullRequest()
+		terminate = event != "pr:opened" && event != "pr:from_ref_updated"
+	default:
+		return c.JSON(http.StatusOK, APIHookResponse{Result: "ignored"})
+	}
+	if !b.accepts(pr.Repository) {
+		return c.JSON(http.StatusOK, APIHookResponse{Result: "ignored"})
+	}
+	if event == "pullrequest:updated" {
+		// pullrequest:updated is also sent by edits of the title or the description
+		running, err := api.runningCommit(c.Request().Context(), b.Launch, pr)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, APIHookResponse{Result: err.Error()})
+		}
+		if running {
+			return c.JSON(http.StatusOK, APIHookResponse{Result: "ignored"})
+		}
+	}
+	return api.hookPullRequest(c, b.Launch, pr, terminate)
+}
+
+// runningCommit reports whether the environment of the pull request is running the head commit already.
+func (api *WebApi) runningCommit(ctx context.Context, l *PullRequestLaunch, pr *pullRequest) (bool, error) {
+	subdomain, _, err := l.render(pr)
+	if err != nil {
+		// reported by the launch
+		return false, nil
+	}
+	running, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		return false, err
+	}
+	ref := formatGitHubRef(pr.Repository, pr.SHA)
+	return lo.ContainsBy(running, func(info *Information) bool {
+		return info.SubDomain == subdomain && info.Option != nil && info.Option.Bitbucket == ref
+	}), nil
+}
@@ -0,0 +1,150 @@
+package mirageecs_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestBitbucketValidate(t *testing.T) {
+	for _, b := range []*mirageecs.Bitbucket{
+		{Launch: &mirageecs.PullRequestLaunch{Taskdef: []string{"app:1"}}},
+		{WebhookSecret: "secret"},
+		{WebhookSecret: "secret", Launch: &mirageecs.PullRequestLaunch{}},
+	} {
+		if err := b.Validate(); err == nil {
+			t.Errorf("%#v should be invalid", b)
+		}
+	}
+	b := &mirageecs.Bitbucket{WebhookSecret: "secret", Launch: &mirageecs.PullRequestLaunch{Taskdef: []string{"app:1"}}}
+	if err := b.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newBitbucketHook(t *testing.T) (*mirageecs.Mirage, func(event string, payload string, secret string) (int, mirageecs.APIHookResponse)) {
+	t.Helper()
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cfg.Cleanup)
+	cfg.Bitbucket = &mirageecs.Bitbucket{
+		WebhookSecret: "secret",
+		Repositories:  []string{"acidlemon/mirage-ecs", "MIRAGE/mirage-ecs"},
+		Launch:        &mirageecs.PullRequestLaunch{Taskdef: []string{"app:1"}},
+	}
+	if err := cfg.Bitbucket.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	t.Cleanup(ts.Close)
+	post := func(event string, payload string, secret string) (int, mirageecs.APIHookResponse) {
+		t.Helper()
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(payload))
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/hooks/bitbucket", strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Event-Key", event)
+		req.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var r mirageecs.APIHookResponse
+		if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, r
+	}
+	return m, post
+}
+
+func bitbucketCloudPayload(repository string) string {
+	return `{"pullrequest":{"id":34,"title":"cool feature","source":{"branch":{"name":"feature/cool"},"commit":{"hash":"0123456789ab"}},"author":{"nickname":"octocat"}},"repository":{"name":"mirage-ecs","full_name":"` + repository + `"},"actor":{"nickname":"octocat"}}`
+}
+
+const bitbucketServerPayload = `{"eventKey":"pr:opened","actor":{"name":"admin"},"pullRequest":{"id":56,"title":"cool feature","fromRef":{"displayId":"feature/server","latestCommit":"0123456789abcdef"},"toRef":{"displayId":"main","repository":{"slug":"mirage-ecs","project":{"key":"MIRAGE"}}},"author":{"user":{"name":"admin"}}}}`
+
+func TestHookBitbucketCloud(t *testing.T) {
+	m, post := newBitbucketHook(t)
+
+	if code, _ := post("pullrequest:created", bitbucketCloudPayload("acidlemon/mirage-ecs"), "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("invalid signature should be unauthorized: %d", code)
+	}
+	if code, r := post("diagnostics:ping", `{"test":true}`, "secret"); code != http.StatusOK || r.Result != "pong" {
+		t.Errorf("unexpected response of ping: %d %#v", code, r)
+	}
+	if code, r := post("pullrequest:created", bitbucketCloudPayload("someone/else"), "secret"); code != http.StatusOK || r.Result != "ignored" {
+		t.Errorf("other repositories should be ignored: %d %#v", code, r)
+	}
+	if code, r := post("pullrequest:approved", bitbucketCloudPayload("acidlemon/mirage-ecs"), "secret"); code != http.StatusOK || r.Result != "ignored" {
+		t.Errorf("other events should be ignored: %d %#v", code, r)
+	}
+
+	code, r := post("pullrequest:created", bitbucketCloudPayload("acidlemon/mirage-ecs"), "secret")
+	if code != http.StatusAccepted || r.Subdomain != "pr-34" {
+		t.Fatalf("unexpected response of created: %d %#v", code, r)
+	}
+	info := waitRunning(t, m, "pr-34", true)
+	if info.GitBranch != "feature/cool" {
+		t.Errorf("unexpected branch: %s", info.GitBranch)
+	}
+	if info.Option == nil || info.Option.Bitbucket != "acidlemon/mirage-ecs@0123456789ab" {
+		t.Errorf("unexpected option: %#v", info.Option)
+	}
+
+	// edits of the title do not relaunch the environment
+	if code, r := post("pullrequest:updated", bitbucketCloudPayload("acidlemon/mirage-ecs"), "secret"); code != http.StatusOK || r.Result != "ignored" {
+		t.Errorf("updates without commits should be ignored: %d %#v", code, r)
+	}
+	if running := waitRunning(t, m, "pr-34", true); running.ID != info.ID {
+		t.Errorf("the environment should not be relaunched: %s", running.ID)
+	}
+	pushed := strings.Replace(bitbucketCloudPayload("acidlemon/mirage-ecs"), "0123456789ab", "fedcba987654", 1)
+	if code, _ := post("pullrequest:updated", pushed, "secret"); code != http.StatusAccepted {
+		t.Fatalf("unexpected response of updated: %d", code)
+	}
+	for i := 0; ; i++ {
+		if running := waitRunning(t, m, "pr-34", true); running.ID != info.ID {
+			break
+		} else if i >= 50 {
+			t.Fatal("the environment should be relaunched by the new commit")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if code, _ := post("pullrequest:fulfilled", bitbucketCloudPayload("acidlemon/mirage-ecs"), "secret"); code != http.StatusAccepted {
+		t.Fatalf("unexpected response of fulfilled: %d", code)
+	}
+	waitRunning(t, m, "pr-34", false)
+}
+
+func TestHookBitbucketServer(t *testing.T) {
+	m, post := newBitbucketHook(t)
+
+	code, r := post("pr:opened", bitbucketServerPayload, "secret")
+	if code != http.StatusAccepted || r.Subdomain != "pr-56" {
+		t.Fatalf("unexpected response of opened: %d %#v", code, r)
+	}
+	if info := waitRunning(t, m, "pr-56", true); info.GitBranch != "feature/server" {
+		t.Errorf("unexpected branch: %s", info.GitBranch)
+	}
+
+	if code, _ := post("pr:declined", bitbucketServerPayload, "secret"); code != http.StatusAccepted {
+		t.Fatalf("unexpected response of declined: %d", code)
+	}
+	waitRunning(t, m, "pr-56", false)
+}
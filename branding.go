@@ -0,0 +1,86 @@
+package mirageecs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// DefaultBrandingTitle is the title of the web interface without branding.title.
+const DefaultBrandingTitle = "Mirage-ECS"
+
+var (
+	brandingColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	bannerLevels         = []string{"info", "success", "warning", "danger", "secondary"}
+)
+
+// Branding customizes the web interface, to distinguish mirage-ecs instances (e.g. dev and demo).
+type Branding struct {
+	Title string `yaml:"title"`
+	// LogoURL is shown in the navigation bar. e.g. /static/logo.svg
+	LogoURL string `yaml:"logo_url"`
+	// PrimaryColor is the color of the navigation bar and primary buttons in the hex notation. e.g. #7952b3
+	PrimaryColor string        `yaml:"primary_color"`
+	FooterLinks  []*FooterLink `yaml:"footer_links"`
+	Banner       *Banner       `yaml:"banner"`
+}
+
+// FooterLink is a link in the footer of the web interface.
+type FooterLink struct {
+	Text string `yaml:"text"`
+	URL  string `yaml:"url"`
+}
+
+// Banner is an informational message shown at the top of the web interface.
+type Banner struct {
+	Message string `yaml:"message"`
+	// Level is a color of the banner. info, success, warning, danger or secondary.
+	Level string `yaml:"level"`
+}
+
+func (b *Branding) validate() error {
+	if b == nil {
+		return nil
+	}
+	if b.LogoURL != "" && !isBrandingURL(b.LogoURL) {
+		return fmt.Errorf("branding.logo_url must be a http(s) URL or an absolute path: %s", b.LogoURL)
+	}
+	if b.PrimaryColor != "" && !brandingColorPattern.MatchString(b.PrimaryColor) {
+		return fmt.Errorf("branding.primary_color must be a hex color (e.g. #7952b3): %s", b.PrimaryColor)
+	}
+	for _, l := range b.FooterLinks {
+		if l.Text == "" || l.URL == "" {
+			return fmt.Errorf("branding.footer_links requires text and url")
+		}
+		if !isBrandingURL(l.URL) {
+			return fmt.Errorf("branding.footer_links url must be a http(s) URL or an absolute path: %s", l.URL)
+		}
+	}
+	if b.Banner != nil {
+		if b.Banner.Message == "" {
+			return fmt.Errorf("branding.banner.message is required")
+		}
+		if b.Banner.Level == "" {
+			b.Banner.Level = "info"
+		}
+		if !lo.Contains(bannerLevels, b.Banner.Level) {
+			return fmt.Errorf("branding.banner.level must be one of %s: %s", strings.Join(bannerLevels, ", "), b.Banner.Level)
+		}
+	}
+	return nil
+}
+
+// Name returns the title of the web interface.
+func (b *Branding) Name() string {
+	if b == nil || b.Title == "" {
+		return DefaultBrandingTitle
+	}
+	return b.Title
+}
+
+func isBrandingURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://") ||
+		(strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//"))
+}
@@ -0,0 +1,94 @@
+package mirageecs_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestBrandingValidate(t *testing.T) {
+	cases := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"empty", "branding: {}\n", ""},
+		{"invalid color", "branding:\n  primary_color: red\n", "branding.primary_color must be a hex color"},
+		{"injected color", "branding:\n  primary_color: \"#fff; }\"\n", "branding.primary_color must be a hex color"},
+		{"invalid logo", "branding:\n  logo_url: \"javascript:alert(1)\"\n", "branding.logo_url must be"},
+		{"protocol relative logo", "branding:\n  logo_url: //example.com/logo.png\n", "branding.logo_url must be"},
+		{"footer link without url", "branding:\n  footer_links:\n    - text: wiki\n", "branding.footer_links requires text and url"},
+		{"banner without message", "branding:\n  banner:\n    level: info\n", "branding.banner.message is required"},
+		{"invalid banner level", "branding:\n  banner:\n    message: hello\n    level: primary\n", "branding.banner.level must be one of"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := newConfigFromYAML(t, c.src)
+			if c.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("expected error %q, got %v", c.err, err)
+			}
+		})
+	}
+}
+
+func TestBrandingLayout(t *testing.T) {
+	cfg, err := newConfigFromYAML(t, `
+branding:
+  title: mirage demo
+  logo_url: /static/logo.svg
+  primary_color: "#7952b3"
+  footer_links:
+    - text: runbook
+      url: https://wiki.example.com/mirage
+  banner:
+    message: This is the DEMO instance. Environments are shown to customers.
+    level: warning
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Branding.Banner.Level != "warning" {
+		t.Errorf("unexpected banner: %#v", cfg.Branding.Banner)
+	}
+	app := mirageecs.NewWebApi(cfg, &mirageecs.LocalTaskRunner{})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, s := range []string{
+		`<title>mirage demo Dashboard</title>`,
+		`<img src="/static/logo.svg" alt="" height="30" class="d-inline-block align-text-top me-2">mirage demo</a>`,
+		`.navbar.bg-dark { background-color: #7952b3 !important; }`,
+		`<a href="https://wiki.example.com/mirage" target="_blank">runbook</a>`,
+		`<div id="banner" class="alert alert-warning rounded-0 text-center" role="status">This is the DEMO instance. Environments are shown to customers.</div>`,
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("%s is not found in %s", s, body)
+		}
+	}
+
+	// without branding
+	cfg, err = newConfigFromYAML(t, "htmldir: ./html\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app = mirageecs.NewWebApi(cfg, &mirageecs.LocalTaskRunner{})
+	rec = httptest.NewRecorder()
+	app.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	body = rec.Body.String()
+	if !strings.Contains(body, `<a class="navbar-brand" href="/">Mirage-ECS</a>`) || strings.Contains(body, `id="banner"`) {
+		t.Errorf("unexpected layout without branding: %s", body)
+	}
+}
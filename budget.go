@@ -0,0 +1,234 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	HookEventBudgetExceeded = "budget_exceeded"
+
+	DefaultBudgetInterval = 10 * time.Minute
+)
+
+// Budget terminates the least recently accessed environments when the estimated daily spend exceeds the budget.
+type Budget struct {
+	// Daily is a budget of a day in ecs.cost.currency.
+	Daily float64 `yaml:"daily"`
+	// Interval is an interval to check the spend. Default is 10m.
+	Interval time.Duration `yaml:"interval"`
+	// Hooks are webhooks or SNS topics to send events of terminations.
+	Hooks []*Hook `yaml:"hooks"`
+}
+
+func (b *Budget) validate(cost *Cost) error {
+	if b == nil {
+		return nil
+	}
+	if cost == nil {
+		return fmt.Errorf("budget requires ecs.cost")
+	}
+	if b.Daily <= 0 {
+		return fmt.Errorf("budget.daily must be positive: %f", b.Daily)
+	}
+	if b.Interval == 0 {
+		b.Interval = DefaultBudgetInterval
+	}
+	if b.Interval < time.Minute {
+		return fmt.Errorf("budget.interval must be at least 1m: %s", b.Interval)
+	}
+	for _, hook := range b.Hooks {
+		if err := hook.validate(); err != nil {
+			return fmt.Errorf("budget.hooks: %w", err)
+		}
+		if hook.Wait {
+			return fmt.Errorf("budget.hooks: wait is supported only by pre_terminate hooks")
+		}
+	}
+	return nil
+}
+
+// budgetTracker tracks the estimated spend of the day.
+type budgetTracker struct {
+	cost  *Cost
+	day   time.Time
+	gone  float64 // spend of the day by tasks which are not running anymore
+	tasks map[string]*budgetTask
+}
+
+type budgetTask struct {
+	hourly float64
+	start  time.Time
+	seen   time.Time
+}
+
+func newBudgetTracker(cost *Cost) *budgetTracker {
+	return &budgetTracker{cost: cost, tasks: make(map[string]*budgetTask)}
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// spendOf returns the spend of the task between the start of the day and the end.
+func (t *budgetTask) spendOf(day, end time.Time) float64 {
+	start := t.start
+	if start.Before(day) {
+		start = day
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return t.hourly * end.Sub(start).Hours()
+}
+
+// update updates running tasks, and returns the spend of the day and the hourly rate of running tasks.
+func (t *budgetTracker) update(running []*Information, now time.Time) (float64, float64) {
+	day := startOfDay(now)
+	if !day.Equal(t.day) {
+		t.day = day
+		t.gone = 0
+	}
+	current := make(map[string]struct{}, len(running))
+	spent, rate := 0.0, 0.0
+	for _, info := range running {
+		if info.Created.IsZero() {
+			continue
+		}
+		current[info.ID] = struct{}{}
+		task, ok := t.tasks[info.ID]
+		if !ok {
+			task = &budgetTask{hourly: t.cost.hourly(info), start: info.Created}
+			t.tasks[info.ID] = task
+		}
+		task.seen = now
+		spent += task.spendOf(day, now)
+		rate += task.hourly
+	}
+	for id, task := range t.tasks {
+		if _, ok := current[id]; !ok {
+			t.gone += task.spendOf(day, task.seen)
+			delete(t.tasks, id)
+		}
+	}
+	return t.gone + spent, rate
+}
+
+// budgetDecision is a decision to terminate the environment to keep the budget.
+type budgetDecision struct {
+	Subdomain  string
+	LastAccess time.Time
+	Hourly     float64
+	// Projected is the projected spend of the day after the termination.
+	Projected float64
+}
+
+// budgetDecisions returns environments to terminate until the projected spend of the day is under the budget.
+// Environments are terminated in order of the last access (or the launch for environments never accessed). Protected environments are not terminated.
+func (c ECSCfg) budgetDecisions(running []*Information, projected float64, remaining time.Duration, lastAccess func(string) time.Time) []*budgetDecision {
+	if projected <= c.Budget.Daily {
+		return nil
+	}
+	envs := make(map[string]*budgetDecision)
+	protected := make(map[string]bool)
+	accessed := make(map[string]bool)
+	for _, info := range running {
+		if info.Protected {
+			protected[info.SubDomain] = true
+		}
+		d, ok := envs[info.SubDomain]
+		if !ok {
+			d = &budgetDecision{Subdomain: info.SubDomain, LastAccess: lastAccess(info.SubDomain)}
+			envs[info.SubDomain] = d
+			accessed[info.SubDomain] = !d.LastAccess.IsZero()
+		}
+		if !accessed[info.SubDomain] && info.Created.After(d.LastAccess) {
+			d.LastAccess = info.Created
+		}
+		d.Hourly += c.Cost.hourly(info)
+	}
+	var candidates []*budgetDecision
+	for subdomain, d := range envs {
+		if !protected[subdomain] && d.Hourly > 0 {
+			candidates = append(candidates, d)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if !candidates[i].LastAccess.Equal(candidates[j].LastAccess) {
+			return candidates[i].LastAccess.Before(candidates[j].LastAccess)
+		}
+		return candidates[i].Subdomain < candidates[j].Subdomain
+	})
+	var decisions []*budgetDecision
+	for _, d := range candidates {
+		if projected <= c.Budget.Daily {
+			break
+		}
+		projected -= d.Hourly * remaining.Hours()
+		d.Projected = projected
+		decisions = append(decisions, d)
+	}
+	return decisions
+}
+
+// RunBudgetKeeper terminates environments periodically to keep the daily budget.
+func (m *Mirage) RunBudgetKeeper(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	b := m.Config.ECS.Budget
+	tracker := newBudgetTracker(m.Config.ECS.Cost)
+	client := newHookClient(m.Config, b.Hooks)
+	tk := time.NewTicker(b.Interval)
+	defer tk.Stop()
+	for {
+		m.keepBudget(ctx, tracker, client)
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			slog.Debug("RunBudgetKeeper() is done")
+			return
+		}
+	}
+}
+
+func (m *Mirage) keepBudget(ctx context.Context, tracker *budgetTracker, client *hookClient) {
+	cfg := m.Config.ECS
+	running, err := m.runner.List(ctx, statusRunning)
+	if err != nil {
+		slog.Warn(f("failed to list tasks to keep the budget: %s", err))
+		return
+	}
+	now := time.Now()
+	spent, rate := tracker.update(running, now)
+	remaining := startOfDay(now).AddDate(0, 0, 1).Sub(now)
+	projected := spent + rate*remaining.Hours()
+	slog.Debug(f("estimated spend of today: %.3f, projected: %.3f, budget: %.3f %s", spent, projected, cfg.Budget.Daily, cfg.Cost.Currency))
+	decisions := cfg.budgetDecisions(running, projected, remaining, m.ReverseProxy.LastAccess)
+	if projected > cfg.Budget.Daily && len(decisions) == 0 {
+		slog.Warn(f("projected spend of today %.3f %s exceeds the budget %.3f, but no environment can be terminated", projected, cfg.Cost.Currency, cfg.Budget.Daily))
+		return
+	}
+	for _, d := range decisions {
+		text := fmt.Sprintf("%s (last access: %s, %.3f %s/h) is terminated because the projected spend of today %.3f %s exceeds the budget %.3f. projected after termination: %.3f",
+			d.Subdomain, d.LastAccess.Format(time.RFC3339), d.Hourly, cfg.Cost.Currency, projected, cfg.Cost.Currency, cfg.Budget.Daily, d.Projected)
+		slog.Info(text)
+		if err := m.runner.TerminateBySubdomain(ctx, d.Subdomain); err != nil {
+			slog.Warn(f("terminate failed %s %s", d.Subdomain, err))
+			continue
+		}
+		p := &HookPayload{
+			Event:     HookEventBudgetExceeded,
+			Subdomain: d.Subdomain,
+			Host:      d.Subdomain + m.Config.Host.ReverseProxySuffix,
+			Time:      now,
+			Text:      text,
+		}
+		for _, hook := range cfg.Budget.Hooks {
+			go client.call(context.Background(), hook, p)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package mirageecs_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestBudgetValidate(t *testing.T) {
+	cost := &mirageecs.Cost{}
+	if err := cost.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	b := &mirageecs.Budget{Daily: 10}
+	if err := b.Validate(cost); err != nil {
+		t.Fatal(err)
+	}
+	if b.Interval != mirageecs.DefaultBudgetInterval {
+		t.Errorf("unexpected interval: %s", b.Interval)
+	}
+	if err := (&mirageecs.Budget{Daily: 10}).Validate(nil); err == nil {
+		t.Error("expected error without cost")
+	}
+	if err := (&mirageecs.Budget{}).Validate(cost); err == nil {
+		t.Error("expected error without daily")
+	}
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestBudgetTracker(t *testing.T) {
+	cost := &mirageecs.Cost{VCPUPerHour: 1, GBPerHour: 0}
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tr := mirageecs.NewBudgetTracker(cost)
+
+	// foo (1 vCPU) started yesterday, bar (0.5 vCPU) started at 2:00
+	foo := &mirageecs.Information{ID: "foo", SubDomain: "foo", Cpu: 1024, Created: day.Add(-time.Hour)}
+	bar := &mirageecs.Information{ID: "bar", SubDomain: "bar", Cpu: 512, Created: day.Add(2 * time.Hour)}
+	spent, rate := tr.Update([]*mirageecs.Information{foo, bar}, day.Add(4*time.Hour))
+	if !almostEqual(spent, 4+1) || !almostEqual(rate, 1.5) {
+		t.Errorf("unexpected spend: %f %f", spent, rate)
+	}
+
+	// foo is terminated. its spend until the last update is kept
+	spent, rate = tr.Update([]*mirageecs.Information{bar}, day.Add(6*time.Hour))
+	if !almostEqual(spent, 4+2) || !almostEqual(rate, 0.5) {
+		t.Errorf("unexpected spend: %f %f", spent, rate)
+	}
+
+	// the next day
+	spent, _ = tr.Update([]*mirageecs.Information{bar}, day.Add(26*time.Hour))
+	if !almostEqual(spent, 1) {
+		t.Errorf("unexpected spend of the next day: %f", spent)
+	}
+}
+
+func TestBudgetDecisions(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cfg := mirageecs.ECSCfg{
+		Cost:   &mirageecs.Cost{VCPUPerHour: 1, GBPerHour: 0},
+		Budget: &mirageecs.Budget{Daily: 20},
+	}
+	running := []*mirageecs.Information{
+		{SubDomain: "recent", Cpu: 1024, Created: now.Add(-5 * time.Hour)},
+		{SubDomain: "old", Cpu: 1024, Created: now.Add(-10 * time.Hour)},
+		{SubDomain: "never", Cpu: 1024, Created: now.Add(-8 * time.Hour)},
+		{SubDomain: "protected", Cpu: 4096, Created: now.Add(-10 * time.Hour), Protected: true},
+	}
+	lastAccess := func(subdomain string) time.Time {
+		switch subdomain {
+		case "recent":
+			return now.Add(-time.Minute)
+		case "old":
+			return now.Add(-9 * time.Hour)
+		}
+		return time.Time{}
+	}
+	// under the budget
+	if ds := cfg.BudgetDecisions(running, 19, 12*time.Hour, lastAccess); len(ds) != 0 {
+		t.Errorf("unexpected decisions: %v", ds)
+	}
+	// each termination saves 12 for the rest of the day
+	ds := cfg.BudgetDecisions(running, 40, 12*time.Hour, lastAccess)
+	var got []string
+	for _, d := range ds {
+		got = append(got, d.Subdomain)
+	}
+	if len(got) != 2 || got[0] != "old" || got[1] != "never" {
+		t.Errorf("unexpected decisions: %v", got)
+	}
+	if !almostEqual(ds[1].Projected, 16) {
+		t.Errorf("unexpected projected spend: %f", ds[1].Projected)
+	}
+}
@@ -0,0 +1,127 @@
+package mirageecs
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/methane/rproxy"
+)
+
+// CatchAll configures how to respond to requests for subdomains which have no route.
+type CatchAll struct {
+	// Backend is an URL to forward requests for unknown subdomains.
+	Backend string `yaml:"backend"`
+	// LauncherPage serves a page which links to the launcher instead of a bare 404.
+	LauncherPage bool `yaml:"launcher_page"`
+
+	backendURL *url.URL
+}
+
+func (c *CatchAll) validate() error {
+	if c == nil || c.Backend == "" {
+		return nil
+	}
+	u, err := url.Parse(c.Backend)
+	if err != nil {
+		return fmt.Errorf("invalid catch_all backend: %s: %w", c.Backend, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid catch_all backend scheme: %s", c.Backend)
+	}
+	c.backendURL = u
+	return nil
+}
+
+func (m *Mirage) newCatchAllHandler() http.Handler {
+	c := m.Config.Host.CatchAll
+	if c == nil || c.backendURL == nil {
+		return nil
+	}
+	handler := rproxy.NewSingleHostReverseProxy(c.backendURL)
+	handler.Transport = newHTTPTransport(m.Config.Network.ProxyTimeout)
+	slog.Info(f("catch all backend: %s", c.backendURL))
+	return handler
+}
+
+func (m *Mirage) serveUnknownSubdomain(w http.ResponseWriter, req *http.Request, host string, port int) {
+	c := m.Config.Host.CatchAll
+	if m.serveOnDemand(w, req, host, port) {
+		return
+	}
+	if reason, ok := m.sleepReason(subdomainOf(host)); ok {
+		m.serveSleeping(w, req, subdomainOf(host), reason)
+		return
+	}
+	switch {
+	case m.catchAllHandler != nil:
+		if m.requireAuthCookie(port) {
+			if err := validateAuthCookie(req, m.Config.Auth.ValidateAuthCookie); err != nil {
+				slog.WarnContext(req.Context(), f("%s catch all backend: %s", host, err))
+				httpError(w, req, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		slog.DebugContext(req.Context(), f("%s is not found. forward to the catch all backend", host))
+		m.catchAllHandler.ServeHTTP(w, req)
+	case c != nil && c.LauncherPage:
+		slog.DebugContext(req.Context(), f("%s is not found. serve the launcher page", host))
+		m.serveLauncherPage(w, req, host)
+	default:
+		msg := fmt.Sprintf("%s is not found", host)
+		slog.WarnContext(req.Context(), msg)
+		httpError(w, req, msg, http.StatusNotFound)
+	}
+}
+
+// requireAuthCookie reports whether the listen port requires the auth cookie.
+func (m *Mirage) requireAuthCookie(port int) bool {
+	for _, v := range m.Config.Listen.HTTP {
+		if v.ListenPort == port {
+			return v.RequireAuthCookie
+		}
+	}
+	return false
+}
+
+func (m *Mirage) serveLauncherPage(w http.ResponseWriter, req *http.Request, host string) {
+	subdomain := strings.Split(host, ".")[0]
+	webapi := m.Config.Host.WebApi
+	if _, port, err := net.SplitHostPort(req.Host); err == nil && port != "" {
+		webapi = webapi + ":" + port
+	}
+	launcherURL := url.URL{
+		Scheme:   requestScheme(req),
+		Host:     webapi,
+		Path:     "/launcher",
+		RawQuery: url.Values{"subdomain": []string{subdomain}}.Encode(),
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	err := m.WebApi.Renderer.Render(w, "notfound.html", map[string]interface{}{
+		"Subdomain":   subdomain,
+		"Host":        host,
+		"LauncherURL": launcherURL.String(),
+		"RequestID":   req.Header.Get(echo.HeaderXRequestID),
+		"Locale":      negotiateLocale(req.Header.Get("Accept-Language"), m.Config.Locale),
+	}, nil)
+	if err != nil {
+		slog.Warn(f("failed to render notfound.html: %s", err))
+		fmt.Fprintf(w, "%s is not found", host)
+	}
+}
+
+// requestScheme returns the scheme of the request, which may be terminated by load balancers in front of mirage-ecs.
+func requestScheme(req *http.Request) string {
+	if proto := req.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		return proto
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,130 @@
+package mirageecs_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestCatchAll(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "default backend")
+	}))
+	defer backend.Close()
+
+	auth := &mirageecs.Auth{CookieSecret: "dummy"}
+	cookie, err := auth.NewAuthCookie(time.Minute, "localtest.me")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name              string
+		catchAll          *mirageecs.CatchAll
+		requireAuthCookie bool
+		cookie            *http.Cookie
+		wantStatus        int
+		bodyContains      string
+	}{
+		{
+			name:         "not configured",
+			wantStatus:   http.StatusNotFound,
+			bodyContains: "unknown.localtest.me is not found",
+		},
+		{
+			name:         "backend",
+			catchAll:     &mirageecs.CatchAll{Backend: backend.URL},
+			wantStatus:   http.StatusOK,
+			bodyContains: "default backend",
+		},
+		{
+			name:              "backend requires auth cookie",
+			catchAll:          &mirageecs.CatchAll{Backend: backend.URL},
+			requireAuthCookie: true,
+			wantStatus:        http.StatusForbidden,
+			bodyContains:      "Forbidden",
+		},
+		{
+			name:              "backend with auth cookie",
+			catchAll:          &mirageecs.CatchAll{Backend: backend.URL},
+			requireAuthCookie: true,
+			cookie:            cookie,
+			wantStatus:        http.StatusOK,
+			bodyContains:      "default backend",
+		},
+		{
+			name:         "launcher page",
+			catchAll:     &mirageecs.CatchAll{LauncherPage: true},
+			wantStatus:   http.StatusNotFound,
+			bodyContains: "http://mirage.localtest.me/launcher?subdomain=unknown",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+				LocalMode: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Host.CatchAll = tt.catchAll
+			cfg.Auth = auth
+			cfg.Listen.HTTP = []mirageecs.PortMap{
+				{ListenPort: 80, TargetPort: 80, RequireAuthCookie: tt.requireAuthCookie},
+			}
+			if err := cfg.Host.CatchAll.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			m := mirageecs.New(ctx, cfg)
+
+			req := httptest.NewRequest(http.MethodGet, "http://unknown.localtest.me/", nil)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			w := httptest.NewRecorder()
+			m.ServeHTTPWithPort(w, req, 80)
+			if w.Code != tt.wantStatus {
+				t.Errorf("wanted status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if body := w.Body.String(); !strings.Contains(body, tt.bodyContains) {
+				t.Errorf("wanted body to contain %q, got %q", tt.bodyContains, body)
+			}
+		})
+	}
+}
+
+func TestLauncherPageLink(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Host.CatchAll = &mirageecs.CatchAll{LauncherPage: true}
+	m := mirageecs.New(ctx, cfg)
+
+	req := httptest.NewRequest(http.MethodGet, "http://unknown.localtest.me/", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	w := httptest.NewRecorder()
+	m.ServeHTTPWithPort(w, req, 80)
+	if body := w.Body.String(); !strings.Contains(body, "https://mirage.localtest.me/launcher?subdomain=unknown") {
+		t.Errorf("unexpected link of the launcher: %q", body)
+	}
+
+	// browsers are redirected to the top page, which opens the launcher
+	req = httptest.NewRequest(http.MethodGet, "http://mirage.localtest.me/launcher?subdomain=unknown", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	w = httptest.NewRecorder()
+	m.ServeHTTPWithPort(w, req, 80)
+	if loc := w.Header().Get("Location"); w.Code != http.StatusSeeOther || loc != "/?launch=unknown" {
+		t.Errorf("unexpected response of the launcher: %d %s", w.Code, loc)
+	}
+}
@@ -0,0 +1,292 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	acmTypes "github.com/aws/aws-sdk-go-v2/service/acm/types"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbv2Types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53Types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+const DefaultCertificateCheckInterval = 12 * time.Hour
+
+// Certificate configures an ACM certificate of the wildcard domain of environments.
+// mirage-ecs requests the certificate, validates it by DNS records in Route 53, and attaches it to listeners of load balancers.
+// ACM renews the certificate automatically while the validation records exist.
+type Certificate struct {
+	// Domain is a domain of environments. The certificate is issued for *.<domain> and <domain>.
+	// Default is reverse_proxy_suffix without the leading dot.
+	Domain string `yaml:"domain"`
+	// HostedZoneID is an ID of the Route 53 hosted zone to create validation records.
+	HostedZoneID string `yaml:"hosted_zone_id"`
+	// ListenerArns are ARNs of HTTPS listeners of load balancers to attach the certificate.
+	ListenerArns []string `yaml:"listener_arns"`
+	// CheckInterval is an interval to check the certificate.
+	CheckInterval time.Duration `yaml:"check_interval"`
+
+	// exact issues the certificate for Domain only, not for the wildcard domain (e.g. for aliases).
+	exact bool
+}
+
+func (c *Certificate) validate(suffix string) error {
+	if c == nil {
+		return nil
+	}
+	if c.HostedZoneID == "" {
+		return fmt.Errorf("certificate.hosted_zone_id is required")
+	}
+	if c.Domain == "" {
+		c.Domain = strings.TrimPrefix(suffix, ".")
+	}
+	if c.Domain == "" {
+		return fmt.Errorf("certificate.domain is required")
+	}
+	if c.CheckInterval <= 0 {
+		c.CheckInterval = DefaultCertificateCheckInterval
+	}
+	return nil
+}
+
+// certificateDomain returns the domain name of the certificate.
+func (c *Certificate) certificateDomain() string {
+	if c.exact {
+		return c.Domain
+	}
+	return "*." + c.Domain
+}
+
+// pickCertificate returns the ARN of the certificate for the domain.
+// An issued certificate which expires latest is prior to pending ones.
+func pickCertificate(summaries []acmTypes.CertificateSummary, domain string) string {
+	var candidates []acmTypes.CertificateSummary
+	for _, s := range summaries {
+		if aws.ToString(s.DomainName) != domain {
+			continue
+		}
+		if s.Status != acmTypes.CertificateStatusIssued && s.Status != acmTypes.CertificateStatusPendingValidation {
+			continue
+		}
+		candidates = append(candidates, s)
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Status != b.Status {
+			return a.Status == acmTypes.CertificateStatusIssued
+		}
+		return aws.ToTime(a.NotAfter).After(aws.ToTime(b.NotAfter))
+	})
+	return aws.ToString(candidates[0].CertificateArn)
+}
+
+// validationChanges returns changes to create DNS validation records.
+// The wildcard domain and the apex domain share the same record, so records are deduplicated.
+func validationChanges(options []acmTypes.DomainValidation) []r53Types.Change {
+	var changes []r53Types.Change
+	seen := make(map[string]struct{})
+	for _, o := range options {
+		rr := o.ResourceRecord
+		if rr == nil {
+			continue
+		}
+		name := aws.ToString(rr.Name)
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		changes = append(changes, r53Types.Change{
+			Action: r53Types.ChangeActionUpsert,
+			ResourceRecordSet: &r53Types.ResourceRecordSet{
+				Name:            aws.String(name),
+				Type:            r53Types.RRType(rr.Type),
+				TTL:             aws.Int64(300),
+				ResourceRecords: []r53Types.ResourceRecord{{Value: rr.Value}},
+			},
+		})
+	}
+	return changes
+}
+
+// CertificateManager provisions the certificate of the wildcard domain.
+type CertificateManager struct {
+	cfg      *Certificate
+	acmSvc   *acm.Client
+	r53Svc   *route53.Client
+	elbv2Svc *elbv2.Client
+}
+
+func NewCertificateManager(cfg *Config) *CertificateManager {
+	if cfg.Host.Certificate == nil {
+		return nil
+	}
+	return newCertificateManager(cfg, cfg.Host.Certificate)
+}
+
+func newCertificateManager(cfg *Config, c *Certificate) *CertificateManager {
+	return &CertificateManager{
+		cfg:      c,
+		acmSvc:   acm.NewFromConfig(*cfg.awscfg),
+		r53Svc:   route53.NewFromConfig(*cfg.awscfg),
+		elbv2Svc: elbv2.NewFromConfig(*cfg.awscfg),
+	}
+}
+
+// Run ensures the certificate at startup and every check interval.
+func (m *CertificateManager) Run(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Done()
+	ticker := time.NewTicker(m.cfg.CheckInterval)
+	defer ticker.Stop()
+	for {
+		if err := m.ensure(ctx); err != nil {
+			slog.Warn(f("certificate of %s: %s", m.cfg.certificateDomain(), err))
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *CertificateManager) ensure(ctx context.Context) error {
+	arn, err := m.findCertificate(ctx)
+	if err != nil {
+		return err
+	}
+	if arn == "" {
+		if arn, err = m.requestCertificate(ctx); err != nil {
+			return err
+		}
+	}
+	cert, err := m.waitValidationOptions(ctx, arn)
+	if err != nil {
+		return err
+	}
+	if changes := validationChanges(cert.DomainValidationOptions); len(changes) > 0 {
+		_, err := m.r53Svc.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+			HostedZoneId: aws.String(m.cfg.HostedZoneID),
+			ChangeBatch: &r53Types.ChangeBatch{
+				Changes: changes,
+				Comment: aws.String("ACM validation records managed by mirage-ecs"),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create validation records: %w", err)
+		}
+	}
+	if cert.Status != acmTypes.CertificateStatusIssued {
+		slog.Info(f("certificate %s is %s. waiting for validation", arn, cert.Status))
+		w := acm.NewCertificateValidatedWaiter(m.acmSvc)
+		if err := w.Wait(ctx, &acm.DescribeCertificateInput{CertificateArn: aws.String(arn)}, time.Hour); err != nil {
+			return fmt.Errorf("certificate %s is not validated: %w", arn, err)
+		}
+	}
+	slog.Info(f("certificate of %s: %s", m.cfg.certificateDomain(), arn))
+	return m.attachCertificate(ctx, arn)
+}
+
+func (m *CertificateManager) findCertificate(ctx context.Context) (string, error) {
+	var summaries []acmTypes.CertificateSummary
+	p := acm.NewListCertificatesPaginator(m.acmSvc, &acm.ListCertificatesInput{
+		CertificateStatuses: []acmTypes.CertificateStatus{
+			acmTypes.CertificateStatusIssued,
+			acmTypes.CertificateStatusPendingValidation,
+		},
+	})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to list certificates: %w", err)
+		}
+		summaries = append(summaries, out.CertificateSummaryList...)
+	}
+	return pickCertificate(summaries, m.cfg.certificateDomain()), nil
+}
+
+func (m *CertificateManager) requestCertificate(ctx context.Context) (string, error) {
+	in := &acm.RequestCertificateInput{
+		DomainName:       aws.String(m.cfg.certificateDomain()),
+		ValidationMethod: acmTypes.ValidationMethodDns,
+		Tags: []acmTypes.Tag{
+			{Key: aws.String(TagManagedBy), Value: aws.String(TagValueMirage)},
+		},
+	}
+	if !m.cfg.exact {
+		in.SubjectAlternativeNames = []string{m.cfg.Domain}
+	}
+	out, err := m.acmSvc.RequestCertificate(ctx, in)
+	if err != nil {
+		return "", fmt.Errorf("failed to request a certificate: %w", err)
+	}
+	slog.Info(f("requested a certificate of %s: %s", m.cfg.certificateDomain(), aws.ToString(out.CertificateArn)))
+	return aws.ToString(out.CertificateArn), nil
+}
+
+// waitValidationOptions waits for validation records of the certificate, which are filled asynchronously after the request.
+func (m *CertificateManager) waitValidationOptions(ctx context.Context, arn string) (*acmTypes.CertificateDetail, error) {
+	for i := 0; ; i++ {
+		out, err := m.acmSvc.DescribeCertificate(ctx, &acm.DescribeCertificateInput{CertificateArn: aws.String(arn)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe certificate %s: %w", arn, err)
+		}
+		cert := out.Certificate
+		ready := len(cert.DomainValidationOptions) > 0
+		for _, o := range cert.DomainValidationOptions {
+			if o.ResourceRecord == nil {
+				ready = false
+			}
+		}
+		if ready || cert.Status == acmTypes.CertificateStatusIssued {
+			return cert, nil
+		}
+		if i >= 12 {
+			return nil, fmt.Errorf("validation records of certificate %s are not available", arn)
+		}
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (m *CertificateManager) attachCertificate(ctx context.Context, arn string) error {
+	for _, listener := range m.cfg.ListenerArns {
+		out, err := m.elbv2Svc.DescribeListenerCertificates(ctx, &elbv2.DescribeListenerCertificatesInput{
+			ListenerArn: aws.String(listener),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to describe certificates of listener %s: %w", listener, err)
+		}
+		attached := false
+		for _, c := range out.Certificates {
+			if aws.ToString(c.CertificateArn) == arn {
+				attached = true
+			}
+		}
+		if attached {
+			continue
+		}
+		_, err = m.elbv2Svc.AddListenerCertificates(ctx, &elbv2.AddListenerCertificatesInput{
+			ListenerArn:  aws.String(listener),
+			Certificates: []elbv2Types.Certificate{{CertificateArn: aws.String(arn)}},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to attach certificate to listener %s: %w", listener, err)
+		}
+		slog.Info(f("attached certificate %s to listener %s", arn, listener))
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package mirageecs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	acmTypes "github.com/aws/aws-sdk-go-v2/service/acm/types"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestCertificateValidate(t *testing.T) {
+	c := &mirageecs.Certificate{HostedZoneID: "Z123"}
+	if err := c.Validate(".dev.example.net"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Domain != "dev.example.net" || c.CheckInterval != mirageecs.DefaultCertificateCheckInterval {
+		t.Errorf("unexpected defaults: %#v", c)
+	}
+	if err := (&mirageecs.Certificate{}).Validate(".dev.example.net"); err == nil {
+		t.Error("expected error without hosted_zone_id")
+	}
+	if err := (&mirageecs.Certificate{HostedZoneID: "Z123"}).Validate(""); err == nil {
+		t.Error("expected error without domain")
+	}
+}
+
+func TestPickCertificate(t *testing.T) {
+	now := time.Now()
+	summaries := []acmTypes.CertificateSummary{
+		{CertificateArn: aws.String("other"), DomainName: aws.String("*.example.com"), Status: acmTypes.CertificateStatusIssued},
+		{CertificateArn: aws.String("pending"), DomainName: aws.String("*.dev.example.net"), Status: acmTypes.CertificateStatusPendingValidation},
+		{CertificateArn: aws.String("old"), DomainName: aws.String("*.dev.example.net"), Status: acmTypes.CertificateStatusIssued, NotAfter: aws.Time(now.Add(24 * time.Hour))},
+		{CertificateArn: aws.String("new"), DomainName: aws.String("*.dev.example.net"), Status: acmTypes.CertificateStatusIssued, NotAfter: aws.Time(now.Add(300 * 24 * time.Hour))},
+		{CertificateArn: aws.String("expired"), DomainName: aws.String("*.dev.example.net"), Status: acmTypes.CertificateStatusExpired},
+	}
+	if arn := mirageecs.PickCertificate(summaries, "*.dev.example.net"); arn != "new" {
+		t.Errorf("unexpected certificate: %s", arn)
+	}
+	if arn := mirageecs.PickCertificate(summaries[:2], "*.dev.example.net"); arn != "pending" {
+		t.Errorf("unexpected certificate: %s", arn)
+	}
+	if arn := mirageecs.PickCertificate(summaries, "*.stg.example.net"); arn != "" {
+		t.Errorf("unexpected certificate: %s", arn)
+	}
+}
+
+func TestValidationChanges(t *testing.T) {
+	rr := &acmTypes.ResourceRecord{
+		Name:  aws.String("_abc.dev.example.net."),
+		Type:  acmTypes.RecordTypeCname,
+		Value: aws.String("_def.acm-validations.aws."),
+	}
+	options := []acmTypes.DomainValidation{
+		{DomainName: aws.String("*.dev.example.net"), ResourceRecord: rr},
+		{DomainName: aws.String("dev.example.net"), ResourceRecord: rr},
+		{DomainName: aws.String("pending.example.net")},
+	}
+	changes := mirageecs.ValidationChanges(options)
+	if len(changes) != 1 {
+		t.Fatalf("unexpected changes: %#v", changes)
+	}
+	rs := changes[0].ResourceRecordSet
+	if aws.ToString(rs.Name) != "_abc.dev.example.net." || string(rs.Type) != "CNAME" || aws.ToString(rs.ResourceRecords[0].Value) != "_def.acm-validations.aws." {
+		t.Errorf("unexpected record set: %#v", rs)
+	}
+}
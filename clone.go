@@ -0,0 +1,154 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+)
+
+// APICloneRequest is a request of /api/clone
+type APICloneRequest struct {
+	// Source is the subdomain of the running environment to clone.
+	Source    string `json:"source" form:"source"`
+	Subdomain string `json:"subdomain" form:"subdomain"`
+	// Parameters override parameters of the source.
+	Parameters map[string]string `json:"parameters" form:"-"`
+	TTL        string            `json:"ttl" form:"ttl"`
+}
+
+var cloneRequestKeys = map[string]struct{}{
+	"source":    {},
+	"subdomain": {},
+	"ttl":       {},
+}
+
+// MergeForm merges form values except keys of the request into parameters.
+func (r *APICloneRequest) MergeForm(form map[string][]string) {
+	if r.Parameters == nil {
+		r.Parameters = make(map[string]string, len(form))
+	}
+	for key, values := range form {
+		if _, ok := cloneRequestKeys[key]; ok {
+			continue
+		}
+		r.Parameters[key] = values[0]
+	}
+}
+
+// cloneOption returns the launch option of the clone. Protection and aliases are not copied.
+func cloneOption(src *Information, ttl time.Duration, now time.Time) *LaunchOption {
+	opt := &LaunchOption{}
+	if src.Option != nil {
+		*opt = *src.Option
+	}
+	opt.Protected = false
+	opt.Aliases = nil
+	if ttl > 0 {
+		t := now.Add(ttl).Truncate(time.Second)
+		opt.ExpiresAt = &t
+	}
+	opt.EnableExecuteCommand = aws.Bool(src.ExecuteCommandEnabled)
+	return opt
+}
+
+func (api *WebApi) ApiClone(c echo.Context) error {
+	r := APICloneRequest{}
+	ps, _ := c.FormParams()
+	r.MergeForm(ps)
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, APICommonResponse{Result: err.Error()})
+	}
+	code, err := api.clone(c.Request().Context(), &r)
+	if err != nil {
+		return c.JSON(code, APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(code, APICommonResponse{Result: "ok"})
+}
+
+// cloneSource returns task definitions and parameters of the running environment, to pre-fill the launcher to clone it.
+func (api *WebApi) cloneSource(ctx context.Context, source string) ([]string, TaskParameter, error) {
+	ctx, cancel := context.WithTimeout(ctx, APICallTimeout)
+	defer cancel()
+	running, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		return nil, nil, err
+	}
+	infos := lo.Filter(running, func(info *Information, _ int) bool {
+		return info.SubDomain == source
+	})
+	if len(infos) == 0 {
+		return nil, nil, fmt.Errorf("subdomain %s is not found", source)
+	}
+	taskdefs := lo.Uniq(lo.Map(infos, func(info *Information, _ int) string { return info.TaskDef }))
+	return taskdefs, taskParameterFromTags(infos[0].Tags, api.cfg.Parameter), nil
+}
+
+// clone launches the subdomain with the same taskdefs, parameters and options as the source.
+func (api *WebApi) clone(ctx context.Context, r *APICloneRequest) (int, error) {
+	subdomain := strings.ToLower(r.Subdomain)
+	if r.Source == "" || subdomain == "" {
+		return http.StatusBadRequest, fmt.Errorf("parameter required: source=%s, subdomain=%s", r.Source, subdomain)
+	}
+	if err := validateSubdomain(subdomain); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if subdomain == r.Source {
+		return http.StatusBadRequest, fmt.Errorf("subdomain must be different from the source")
+	}
+	var ttl time.Duration
+	if r.TTL != "" {
+		var err error
+		if ttl, err = parseTTL("ttl", r.TTL); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, APICallTimeout)
+	defer cancel()
+	running, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	infos := lo.Filter(running, func(info *Information, _ int) bool {
+		return info.SubDomain == r.Source
+	})
+	if len(infos) == 0 {
+		return http.StatusNotFound, fmt.Errorf("subdomain %s is not found", r.Source)
+	}
+	if lo.ContainsBy(running, func(info *Information) bool { return info.SubDomain == subdomain }) {
+		return http.StatusConflict, fmt.Errorf("subdomain %s is already running", subdomain)
+	}
+
+	params := taskParameterFromTags(infos[0].Tags, api.cfg.Parameter)
+	for k, v := range r.Parameters {
+		params[k] = v
+	}
+	// revisions registered for the source are deregistered with it
+	taskdefs := baseTaskDefs(infos)
+	parameter, err := api.LoadParameter(func(name string) string {
+		return params[name]
+	}, taskdefs...)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	opt := cloneOption(infos[0], ttl, time.Now())
+
+	tags := parameter.ToECSTags(subdomain, api.cfg.Parameter)
+	tags = append(tags, parameter.ToPropagatedTags(api.cfg.Parameter, api.cfg.ECS.ParameterTagPrefix)...)
+	if err := api.cfg.ECS.checkQuotas(running, subdomain, len(taskdefs), tags); err != nil {
+		return http.StatusTooManyRequests, err
+	}
+	slog.Info(f("cloning subdomain %s to %s with taskdefs %v", r.Source, subdomain, taskdefs))
+	if err := api.runner.Launch(ctx, subdomain, parameter, opt, taskdefs...); err != nil {
+		slog.Error(f("clone failed: %s", err))
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
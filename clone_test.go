@@ -0,0 +1,114 @@
+package mirageecs_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/labstack/echo/v4"
+)
+
+func TestClone(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	opt := &mirageecs.LaunchOption{Protected: true, Aliases: []string{"foo-alias"}}
+	if err := m.Runner().Launch(ctx, "foo", mirageecs.TaskParameter{"branch": "develop"}, opt, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	for _, c := range []struct {
+		body   string
+		status int
+	}{
+		{`{"source":"foo","subdomain":"bar","parameters":{"branch":"feature"}}`, http.StatusOK},
+		{`{"source":"foo","subdomain":"bar"}`, http.StatusConflict},
+		{`{"source":"unknown","subdomain":"baz"}`, http.StatusNotFound},
+		{`{"source":"foo","subdomain":"foo"}`, http.StatusBadRequest},
+		{`{"source":"foo"}`, http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/clone", strings.NewReader(c.body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		if err := m.WebApi.ApiClone(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != c.status {
+			t.Errorf("clone %s: wanted status %d, got %d: %s", c.body, c.status, rec.Code, rec.Body.String())
+		}
+	}
+
+	infos, err := m.Runner().List(ctx, "RUNNING")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clone *mirageecs.Information
+	for _, info := range infos {
+		if info.SubDomain == "bar" {
+			clone = info
+		}
+	}
+	if clone == nil {
+		t.Fatal("clone is not launched")
+	}
+	if clone.TaskDef != "app:1" || clone.GitBranch != "feature" {
+		t.Errorf("unexpected clone: taskdef=%s branch=%s", clone.TaskDef, clone.GitBranch)
+	}
+	if clone.Protected || len(clone.Option.Aliases) > 0 {
+		t.Errorf("protection and aliases should not be cloned: %#v", clone.Option)
+	}
+}
+
+func TestCloneLauncher(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	if err := m.Runner().Launch(ctx, "foo", mirageecs.TaskParameter{"branch": "develop"}, nil, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	for query, expected := range map[string][]string{
+		"?clone=foo": {
+			`<h5 class="modal-title">Clone foo</h5>`,
+			`name="subdomain" value=""`,
+			`name="branch" value="develop"`,
+			`name="taskdef" value="app:1"`,
+		},
+		"?clone=unknown": {
+			`<div id="launcher-error" class="alert alert-danger" role="alert" >subdomain unknown is not found</div>`,
+		},
+		"": {
+			`<h5 class="modal-title">Launch New Task</h5>`,
+			`name="branch" value=""`,
+		},
+	} {
+		res, err := http.Get(ts.URL + "/launcher" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		for _, s := range expected {
+			if !strings.Contains(string(b), s) {
+				t.Errorf("launcher%s must contain %s: %s", query, s, b)
+			}
+		}
+	}
+}
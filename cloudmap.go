@@ -0,0 +1,310 @@
+package mirageecs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ttlcache "github.com/ReneKroon/ttlcache/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/servicediscovery"
+	sdTypes "github.com/aws/aws-sdk-go-v2/service/servicediscovery/types"
+)
+
+var invalidDiscoveryNameChars = regexp.MustCompile(`[^A-Za-z0-9-]`)
+
+const cloudMapRecordTTL = 60
+
+// CloudMap configures registration of environments into an AWS Cloud Map namespace.
+// Each task of environments is registered as an instance of the Cloud Map service named by the subdomain.
+type CloudMap struct {
+	// Namespace is a name, an ID or an ARN of the Cloud Map namespace. e.g. preview.local
+	Namespace string `yaml:"namespace"`
+	// PortName is a name of the port mapping in the task definition to register.
+	// Default is the first named port mapping, or the first port of the port map.
+	PortName string `yaml:"port_name"`
+	// Port is a port number to register. Default is the port of the task.
+	Port int32 `yaml:"port"`
+}
+
+func (c *CloudMap) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Namespace == "" {
+		return fmt.Errorf("cloud_map.namespace is required")
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid cloud_map.port: %d", c.Port)
+	}
+	return nil
+}
+
+// discoveryName returns a discovery name of the subdomain.
+// Subdomains may contain wildcards which are not allowed in DNS labels.
+func discoveryName(subdomain string) string {
+	return invalidDiscoveryNameChars.ReplaceAllString(subdomain, "-")
+}
+
+// instancePort returns a port of the task to register.
+func (c *CloudMap) instancePort(info *Information) (int, bool) {
+	if c.Port > 0 {
+		return int(c.Port), true
+	}
+	if c.PortName != "" {
+		port, ok := info.NamedPorts[c.PortName]
+		if !ok {
+			return 0, false
+		}
+		return info.hostPortOf(port), true
+	}
+	if port, ok := firstPort(info.NamedPorts); ok {
+		return info.hostPortOf(port), true
+	}
+	return firstPort(info.PortMap)
+}
+
+// firstPort returns a port of the first name in the ports, to choose it deterministically.
+func firstPort(ports map[string]int) (int, bool) {
+	if len(ports) == 0 {
+		return 0, false
+	}
+	names := make([]string, 0, len(ports))
+	for name := range ports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return ports[names[0]], true
+}
+
+// CloudMapRegistry registers tasks of environments into a Cloud Map namespace.
+type CloudMapRegistry struct {
+	svc         *servicediscovery.Client
+	cfg         *CloudMap
+	namespaceID string
+	dns         bool
+
+	mu       sync.Mutex
+	services map[string]string // discovery name -> service ID
+	cache    *ttlcache.Cache
+}
+
+func NewCloudMapRegistry(ctx context.Context, cfg *Config) *CloudMapRegistry {
+	c := cfg.ECS.CloudMap
+	if c == nil {
+		return nil
+	}
+	r := &CloudMapRegistry{
+		svc:      servicediscovery.NewFromConfig(*cfg.awscfg),
+		cfg:      c,
+		services: make(map[string]string),
+	}
+	id, err := r.resolveNamespaceID(ctx, c.Namespace)
+	if err != nil {
+		slog.Error(f("failed to resolve cloud map namespace %s: %s", c.Namespace, err))
+		return nil
+	}
+	out, err := r.svc.GetNamespace(ctx, &servicediscovery.GetNamespaceInput{Id: aws.String(id)})
+	if err != nil {
+		slog.Error(f("failed to get cloud map namespace %s: %s", id, err))
+		return nil
+	}
+	r.namespaceID = id
+	r.dns = out.Namespace.Type != sdTypes.NamespaceTypeHttp
+	r.cache = ttlcache.NewCache()
+	r.cache.SetTTL(5 * time.Minute)
+	r.cache.SkipTTLExtensionOnHit(true)
+	slog.Info(f("cloud map namespace: %s (%s)", aws.ToString(out.Namespace.Name), id))
+	return r
+}
+
+func (r *CloudMapRegistry) resolveNamespaceID(ctx context.Context, ns string) (string, error) {
+	if strings.HasPrefix(ns, "ns-") {
+		return ns, nil
+	}
+	if strings.HasPrefix(ns, "arn:") {
+		return ns[strings.LastIndex(ns, "/")+1:], nil
+	}
+	p := servicediscovery.NewListNamespacesPaginator(r.svc, &servicediscovery.ListNamespacesInput{
+		Filters: []sdTypes.NamespaceFilter{
+			{
+				Name:      sdTypes.NamespaceFilterNameName,
+				Values:    []string{ns},
+				Condition: sdTypes.FilterConditionEq,
+			},
+		},
+	})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return "", err
+		}
+		for _, n := range out.Namespaces {
+			if aws.ToString(n.Name) == ns {
+				return aws.ToString(n.Id), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("namespace %s is not found", ns)
+}
+
+// Register registers the task as an instance of the service of the subdomain.
+func (r *CloudMapRegistry) Register(ctx context.Context, info *Information) {
+	if r == nil || info.IPAddress == "" {
+		return
+	}
+	key := "register " + info.ShortID
+	if _, err := r.cache.Get(key); err == nil {
+		slog.Debug(f("%s is cached. skip", key))
+		return
+	}
+	port, ok := r.cfg.instancePort(info)
+	if !ok {
+		slog.Warn(f("cloud map: task %s of subdomain %s has no port to register", info.ShortID, info.SubDomain))
+		return
+	}
+	serviceID, err := r.serviceID(ctx, discoveryName(info.SubDomain), true)
+	if err != nil {
+		slog.Warn(f("cloud map: failed to get the service of subdomain %s: %s", info.SubDomain, err))
+		return
+	}
+	_, err = r.svc.RegisterInstance(ctx, &servicediscovery.RegisterInstanceInput{
+		ServiceId:  aws.String(serviceID),
+		InstanceId: aws.String(info.ShortID),
+		Attributes: instanceAttributes(info, port),
+	})
+	if err != nil {
+		var notFound *sdTypes.ServiceNotFound
+		if errors.As(err, &notFound) {
+			r.forgetService(discoveryName(info.SubDomain))
+		}
+		slog.Warn(f("cloud map: failed to register task %s of subdomain %s: %s", info.ShortID, info.SubDomain, err))
+		return
+	}
+	slog.Info(f("cloud map: registered task %s of subdomain %s (%s:%d)", info.ShortID, info.SubDomain, info.IPAddress, port))
+	r.cache.Set(key, nil)
+}
+
+// instanceAttributes returns attributes of the instance of the task. IPv6 addresses are registered as AWS_INSTANCE_IPV6.
+func instanceAttributes(info *Information, port int) map[string]string {
+	attrs := map[string]string{
+		"AWS_INSTANCE_PORT": strconv.Itoa(port),
+		"subdomain":         info.SubDomain,
+		"taskdef":           info.TaskDef,
+	}
+	if info.IPAddress != info.IPv6Address {
+		attrs["AWS_INSTANCE_IPV4"] = info.IPAddress
+	}
+	if info.IPv6Address != "" {
+		attrs["AWS_INSTANCE_IPV6"] = info.IPv6Address
+	}
+	return attrs
+}
+
+// Deregister deregisters the task from the service of the subdomain.
+func (r *CloudMapRegistry) Deregister(ctx context.Context, info *Information) {
+	if r == nil {
+		return
+	}
+	key := "deregister " + info.ShortID
+	if _, err := r.cache.Get(key); err == nil {
+		slog.Debug(f("%s is cached. skip", key))
+		return
+	}
+	serviceID, err := r.serviceID(ctx, discoveryName(info.SubDomain), false)
+	if err != nil {
+		slog.Warn(f("cloud map: failed to get the service of subdomain %s: %s", info.SubDomain, err))
+		return
+	}
+	if serviceID != "" {
+		_, err = r.svc.DeregisterInstance(ctx, &servicediscovery.DeregisterInstanceInput{
+			ServiceId:  aws.String(serviceID),
+			InstanceId: aws.String(info.ShortID),
+		})
+		var notFound *sdTypes.InstanceNotFound
+		var serviceNotFound *sdTypes.ServiceNotFound
+		switch {
+		case err == nil:
+			slog.Info(f("cloud map: deregistered task %s of subdomain %s", info.ShortID, info.SubDomain))
+		case errors.As(err, &notFound), errors.As(err, &serviceNotFound):
+			// already deregistered
+		default:
+			slog.Warn(f("cloud map: failed to deregister task %s of subdomain %s: %s", info.ShortID, info.SubDomain, err))
+			return
+		}
+	}
+	r.cache.Set(key, nil)
+}
+
+// serviceID returns an ID of the service named by the discovery name in the namespace.
+// When create is true, it creates the service if it does not exist.
+// Otherwise, it returns an empty string if it does not exist.
+func (r *CloudMapRegistry) serviceID(ctx context.Context, name string, create bool) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if id, ok := r.services[name]; ok {
+		return id, nil
+	}
+	p := servicediscovery.NewListServicesPaginator(r.svc, &servicediscovery.ListServicesInput{
+		Filters: []sdTypes.ServiceFilter{
+			{
+				Name:      sdTypes.ServiceFilterNameNamespaceId,
+				Values:    []string{r.namespaceID},
+				Condition: sdTypes.FilterConditionEq,
+			},
+		},
+	})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return "", err
+		}
+		for _, s := range out.Services {
+			if aws.ToString(s.Name) == name {
+				r.services[name] = aws.ToString(s.Id)
+				return r.services[name], nil
+			}
+		}
+	}
+	if !create {
+		return "", nil
+	}
+	in := &servicediscovery.CreateServiceInput{
+		Name:        aws.String(name),
+		NamespaceId: aws.String(r.namespaceID),
+		Description: aws.String("created by mirage-ecs"),
+	}
+	if r.dns {
+		in.DnsConfig = &sdTypes.DnsConfig{
+			RoutingPolicy: sdTypes.RoutingPolicyMultivalue,
+			DnsRecords: []sdTypes.DnsRecord{
+				{Type: sdTypes.RecordTypeA, TTL: aws.Int64(cloudMapRecordTTL)},
+			},
+		}
+	}
+	out, err := r.svc.CreateService(ctx, in)
+	if err != nil {
+		var exists *sdTypes.ServiceAlreadyExists
+		if errors.As(err, &exists) && exists.ServiceId != nil {
+			r.services[name] = aws.ToString(exists.ServiceId)
+			return r.services[name], nil
+		}
+		return "", err
+	}
+	slog.Info(f("cloud map: created service %s in namespace %s", name, r.namespaceID))
+	r.services[name] = aws.ToString(out.Service.Id)
+	return r.services[name], nil
+}
+
+func (r *CloudMapRegistry) forgetService(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.services, name)
+}
@@ -0,0 +1,91 @@
+package mirageecs_test
+
+import (
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestCloudMapValidate(t *testing.T) {
+	c := &mirageecs.CloudMap{Namespace: "preview.local"}
+	if err := c.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (&mirageecs.CloudMap{}).Validate(); err == nil {
+		t.Error("expected error without namespace")
+	}
+	if err := (&mirageecs.CloudMap{Namespace: "preview.local", Port: 70000}).Validate(); err == nil {
+		t.Error("expected error for invalid port")
+	}
+	var empty *mirageecs.CloudMap
+	if err := empty.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCloudMapDiscoveryName(t *testing.T) {
+	if name := mirageecs.DiscoveryName("feature-*"); name != "feature--" {
+		t.Errorf("unexpected discovery name: %s", name)
+	}
+	if name := mirageecs.DiscoveryName("bench"); name != "bench" {
+		t.Errorf("unexpected discovery name: %s", name)
+	}
+}
+
+func TestCloudMapInstancePort(t *testing.T) {
+	info := &mirageecs.Information{
+		PortMap:    map[string]int{"web": 80, "api": 8000},
+		NamedPorts: mirageecs.PortRoutes{"http": 8080, "grpc": 9090},
+	}
+	cases := []struct {
+		name string
+		c    *mirageecs.CloudMap
+		port int
+		ok   bool
+	}{
+		{"first named port", &mirageecs.CloudMap{}, 9090, true},
+		{"port name", &mirageecs.CloudMap{PortName: "http"}, 8080, true},
+		{"explicit port", &mirageecs.CloudMap{PortName: "http", Port: 443}, 443, true},
+		{"unknown port name", &mirageecs.CloudMap{PortName: "admin"}, 0, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			port, ok := tc.c.InstancePort(info)
+			if port != tc.port || ok != tc.ok {
+				t.Errorf("got %d %v, want %d %v", port, ok, tc.port, tc.ok)
+			}
+		})
+	}
+
+	info = &mirageecs.Information{PortMap: map[string]int{"web": 80, "api": 8000}}
+	if port, ok := (&mirageecs.CloudMap{}).InstancePort(info); port != 8000 || !ok {
+		t.Errorf("unexpected port of the port map: %d %v", port, ok)
+	}
+	if _, ok := (&mirageecs.CloudMap{}).InstancePort(&mirageecs.Information{}); ok {
+		t.Error("expected no port")
+	}
+}
+
+func TestCloudMapInstanceAttributes(t *testing.T) {
+	cases := []struct {
+		name string
+		info *mirageecs.Information
+		ipv4 string
+		ipv6 string
+	}{
+		{"ipv4", &mirageecs.Information{IPAddress: "10.0.0.1"}, "10.0.0.1", ""},
+		{"dual stack", &mirageecs.Information{IPAddress: "10.0.0.1", IPv6Address: "2001:db8::1"}, "10.0.0.1", "2001:db8::1"},
+		{"ipv6", &mirageecs.Information{IPAddress: "2001:db8::1", IPv6Address: "2001:db8::1"}, "", "2001:db8::1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			attrs := mirageecs.InstanceAttributes(tc.info, 80)
+			if attrs["AWS_INSTANCE_IPV4"] != tc.ipv4 || attrs["AWS_INSTANCE_IPV6"] != tc.ipv6 {
+				t.Errorf("unexpected attributes: %v", attrs)
+			}
+			if attrs["AWS_INSTANCE_PORT"] != "80" {
+				t.Errorf("unexpected port: %v", attrs)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
@@ -9,6 +10,7 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	mirageecs "github.com/acidlemon/mirage-ecs/v2"
 	"gopkg.in/yaml.v2"
@@ -20,19 +22,24 @@ var (
 )
 
 func main() {
-	confFile := flag.String("conf", "", "specify config file or S3 URL")
+	confFile := flag.String("conf", "", "specify config file, S3 URL (s3://bucket/key) or SSM parameter (ssm:///name)")
 	domain := flag.String("domain", ".local", "reverse proxy suffix")
-	var showVersion, showConfig, localMode, compatV1 bool
+	var showVersion, showConfig, showSchema, localMode, compatV1 bool
 	var defaultPort int
-	var logFormat, logLevel string
+	var logFormat, logLevel, logOutput, profile string
+	var reloadInterval time.Duration
 	flag.BoolVar(&showVersion, "version", false, "show version")
 	flag.BoolVar(&showVersion, "v", false, "show version")
 	flag.BoolVar(&showConfig, "x", false, "show config")
+	flag.BoolVar(&showSchema, "schema", false, "show JSON Schema of config")
 	flag.BoolVar(&localMode, "local", false, "local mode (for development)")
 	flag.BoolVar(&compatV1, "compat-v1", false, "compatibility mode for v1")
 	flag.IntVar(&defaultPort, "default-port", 80, "default port number")
 	flag.StringVar(&logFormat, "log-format", "text", "log format (text, json)")
 	flag.StringVar(&logLevel, "log-level", "info", "log level (debug, info, warn, error)")
+	flag.StringVar(&logOutput, "log-output", "stderr", "log output (stderr, stdout, or a file path)")
+	flag.StringVar(&profile, "profile", "", "profile in the config file to override the config (e.g. staging)")
+	flag.DurationVar(&reloadInterval, "conf-reload-interval", 0, "interval to check changes of the config file to restart with it (e.g. 5m)")
 	flag.VisitAll(overrideWithEnv)
 	flag.Parse()
 
@@ -42,31 +49,51 @@ func main() {
 		fmt.Printf("mirage-ecs %s (%s)\n", Version, buildDate)
 		return
 	}
+	if showSchema {
+		b, err := mirageecs.ConfigSchema()
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(string(b))
+		return
+	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
-		Path:        *confFile,
-		LocalMode:   localMode,
-		Domain:      *domain,
-		DefaultPort: defaultPort,
-		CompatV1:    compatV1,
-		LogFormat:   logFormat,
-	})
-	if err != nil {
-		slog.Error(err.Error())
-		os.Exit(1)
-	}
-	if showConfig {
-		yaml.NewEncoder(os.Stdout).Encode(cfg)
-		return
-	}
 	mirageecs.Version = Version
-	app := mirageecs.New(ctx, cfg)
-	if err := app.Run(ctx); err != nil {
-		slog.Error(err.Error())
-		os.Exit(1)
+	for {
+		cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+			Path:           *confFile,
+			LocalMode:      localMode,
+			Domain:         *domain,
+			DefaultPort:    defaultPort,
+			CompatV1:       compatV1,
+			LogFormat:      logFormat,
+			LogOutput:      logOutput,
+			ReloadInterval: reloadInterval,
+			Profile:        profile,
+		})
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		if showConfig {
+			yaml.NewEncoder(os.Stdout).Encode(cfg)
+			return
+		}
+		app := mirageecs.New(ctx, cfg)
+		err = app.Run(ctx)
+		if errors.Is(err, mirageecs.ErrConfigChanged) {
+			// restart with the new config
+			continue
+		}
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		return
 	}
 }
 
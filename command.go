@@ -0,0 +1,117 @@
+package mirageecs
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+)
+
+// CommandOverride configures overrides of container commands and entry points at launch.
+// Overrides are rejected unless the command line matches any of the allowed patterns.
+type CommandOverride struct {
+	// AllowedPatterns are regular expressions of command lines (arguments joined by a space).
+	// Patterns must match the whole command line.
+	AllowedPatterns []string `yaml:"allowed_patterns"`
+
+	allowed []*regexp.Regexp
+}
+
+func (c *CommandOverride) validate() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.AllowedPatterns) == 0 {
+		return fmt.Errorf("command_override.allowed_patterns is required")
+	}
+	c.allowed = make([]*regexp.Regexp, 0, len(c.AllowedPatterns))
+	for _, p := range c.AllowedPatterns {
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid command_override.allowed_patterns %s: %w", p, err)
+		}
+		c.allowed = append(c.allowed, re)
+	}
+	return nil
+}
+
+// allows reports whether the command line is allowed to be specified at launch.
+func (c *CommandOverride) allows(args []string) bool {
+	if c == nil {
+		return false
+	}
+	line := strings.Join(args, " ")
+	for _, re := range c.allowed {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateFor validates commands and entry points specified at launch against the task definition.
+func (c *CommandOverride) validateFor(td *types.TaskDefinition, opt *LaunchOption) error {
+	if opt == nil || (len(opt.Command) == 0 && len(opt.EntryPoint) == 0) {
+		return nil
+	}
+	if c == nil {
+		return fmt.Errorf("command and entry_point overrides are not allowed. set ecs.command_override")
+	}
+	names := make(map[string]struct{}, len(td.ContainerDefinitions))
+	for _, cd := range td.ContainerDefinitions {
+		names[aws.ToString(cd.Name)] = struct{}{}
+	}
+	for kind, overrides := range map[string]map[string][]string{"command": opt.Command, "entry_point": opt.EntryPoint} {
+		containers := lo.Keys(overrides)
+		sort.Strings(containers)
+		for _, name := range containers {
+			if _, ok := names[name]; !ok {
+				return fmt.Errorf("%s for container %s: container is not found in task definition %s", kind, name, aws.ToString(td.Family))
+			}
+			args := overrides[name]
+			if len(args) == 0 {
+				return fmt.Errorf("%s for container %s is empty", kind, name)
+			}
+			if !c.allows(args) {
+				return fmt.Errorf("%s for container %s is not allowed: %s", kind, name, strings.Join(args, " "))
+			}
+		}
+	}
+	return nil
+}
+
+// applyCommandOverrides sets commands specified at launch to the container overrides.
+func (o *LaunchOption) applyCommandOverrides(ov *types.TaskOverride) {
+	if o == nil || len(o.Command) == 0 {
+		return
+	}
+	for i := range ov.ContainerOverrides {
+		c := &ov.ContainerOverrides[i]
+		if args, ok := o.Command[aws.ToString(c.Name)]; ok {
+			slog.Info(f("override command of container %s: %s", aws.ToString(c.Name), strings.Join(args, " ")))
+			c.Command = args
+		}
+	}
+}
+
+// entryPointModifier replaces entry points of containers by ones specified at launch.
+// Task overrides can't override entry points, so a new revision is registered.
+func entryPointModifier(opt *LaunchOption) taskDefinitionModifier {
+	return func(in *ecs.RegisterTaskDefinitionInput) {
+		containers := make([]types.ContainerDefinition, 0, len(in.ContainerDefinitions))
+		for _, c := range in.ContainerDefinitions {
+			if args, ok := opt.EntryPoint[aws.ToString(c.Name)]; ok {
+				slog.Info(f("override entry point of container %s: %s", aws.ToString(c.Name), strings.Join(args, " ")))
+				c.EntryPoint = args
+			}
+			containers = append(containers, c)
+		}
+		in.ContainerDefinitions = containers
+	}
+}
@@ -0,0 +1,103 @@
+package mirageecs_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/google/go-cmp/cmp"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestCommandOverrideValidate(t *testing.T) {
+	if err := (&mirageecs.CommandOverride{}).Validate(); err == nil {
+		t.Error("expected error without allowed_patterns")
+	}
+	if err := (&mirageecs.CommandOverride{AllowedPatterns: []string{"("}}).Validate(); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+	var empty *mirageecs.CommandOverride
+	if err := empty.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCommandOverrideValidateFor(t *testing.T) {
+	td := &types.TaskDefinition{
+		Family: aws.String("app"),
+		ContainerDefinitions: []types.ContainerDefinition{
+			{Name: aws.String("app")},
+			{Name: aws.String("worker")},
+		},
+	}
+	c := &mirageecs.CommandOverride{AllowedPatterns: []string{`bundle exec rake db:seed`, `bin/worker( --queue=\w+)?`}}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		c       *mirageecs.CommandOverride
+		opt     *mirageecs.LaunchOption
+		wantErr bool
+	}{
+		{name: "no overrides", c: nil, opt: &mirageecs.LaunchOption{}},
+		{name: "allowed command", c: c, opt: &mirageecs.LaunchOption{Command: map[string][]string{"worker": {"bin/worker", "--queue=mail"}}}},
+		{name: "allowed entry point", c: c, opt: &mirageecs.LaunchOption{EntryPoint: map[string][]string{"app": {"bundle", "exec", "rake", "db:seed"}}}},
+		{name: "not allowed", c: c, opt: &mirageecs.LaunchOption{Command: map[string][]string{"app": {"bin/worker;", "rm", "-rf", "/"}}}, wantErr: true},
+		{name: "partial match", c: c, opt: &mirageecs.LaunchOption{Command: map[string][]string{"app": {"bin/worker", "--queue=mail", "--debug"}}}, wantErr: true},
+		{name: "unknown container", c: c, opt: &mirageecs.LaunchOption{Command: map[string][]string{"db": {"bin/worker"}}}, wantErr: true},
+		{name: "empty command", c: c, opt: &mirageecs.LaunchOption{Command: map[string][]string{"app": {}}}, wantErr: true},
+		{name: "not configured", c: nil, opt: &mirageecs.LaunchOption{Command: map[string][]string{"app": {"bin/worker"}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.c.ValidateFor(td, tt.opt)
+			if tt.wantErr && err == nil {
+				t.Error("expected error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCommandOverrides(t *testing.T) {
+	opt := &mirageecs.LaunchOption{
+		Command:    map[string][]string{"worker": {"bin/worker"}},
+		EntryPoint: map[string][]string{"app": {"/entrypoint.sh"}},
+	}
+	ov := &types.TaskOverride{
+		ContainerOverrides: []types.ContainerOverride{
+			{Name: aws.String("app")},
+			{Name: aws.String("worker")},
+		},
+	}
+	opt.ApplyCommandOverrides(ov)
+	if ov.ContainerOverrides[0].Command != nil {
+		t.Errorf("command of app must not be overridden: %v", ov.ContainerOverrides[0].Command)
+	}
+	if diff := cmp.Diff([]string{"bin/worker"}, ov.ContainerOverrides[1].Command); diff != "" {
+		t.Errorf("unexpected command (-want +got):\n%s", diff)
+	}
+
+	in := &ecs.RegisterTaskDefinitionInput{
+		ContainerDefinitions: []types.ContainerDefinition{
+			{Name: aws.String("app"), EntryPoint: []string{"/bin/sh", "-c"}},
+			{Name: aws.String("worker")},
+		},
+	}
+	original := in.ContainerDefinitions
+	mirageecs.ApplyEntryPoint(in, opt)
+	if diff := cmp.Diff([]string{"/entrypoint.sh"}, in.ContainerDefinitions[0].EntryPoint); diff != "" {
+		t.Errorf("unexpected entry point (-want +got):\n%s", diff)
+	}
+	if in.ContainerDefinitions[1].EntryPoint != nil {
+		t.Errorf("entry point of worker must not be overridden: %v", in.ContainerDefinitions[1].EntryPoint)
+	}
+	if len(original[0].EntryPoint) != 2 {
+		t.Error("container definitions of the original task definition are modified")
+	}
+}
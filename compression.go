@@ -0,0 +1,172 @@
+package mirageecs
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const DefaultCompressionMinLength = 1024
+
+var DefaultCompressibleContentTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// Compression is a configuration for compressing proxied responses.
+type Compression struct {
+	Enabled      bool     `yaml:"enabled"`
+	ContentTypes []string `yaml:"content_types"`
+	MinLength    int64    `yaml:"min_length"`
+}
+
+// compressionFor returns the compression config applied to an environment.
+// enabled overrides Compression.Enabled when it is specified at launch.
+func (c *Compression) compressionFor(enabled *bool) *Compression {
+	r := Compression{
+		ContentTypes: DefaultCompressibleContentTypes,
+		MinLength:    DefaultCompressionMinLength,
+	}
+	if c != nil {
+		r.Enabled = c.Enabled
+		if len(c.ContentTypes) > 0 {
+			r.ContentTypes = c.ContentTypes
+		}
+		if c.MinLength > 0 {
+			r.MinLength = c.MinLength
+		}
+	}
+	if enabled != nil {
+		r.Enabled = *enabled
+	}
+	if !r.Enabled {
+		return nil
+	}
+	return &r
+}
+
+func (c *Compression) compressible(contentType string) bool {
+	return matchContentType(c.ContentTypes, contentType)
+}
+
+// matchContentType reports whether the contentType matches any of types.
+// types may contain a wildcard subtype (e.g. "text/*").
+func matchContentType(types []string, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		if strings.HasSuffix(t, "/*") {
+			if strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*")) {
+				return true
+			}
+		} else if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding chooses a content encoding from Accept-Encoding header.
+// br is preferred over gzip, and gzip is preferred over deflate.
+func negotiateEncoding(acceptEncoding string) string {
+	accepts := make(map[string]bool)
+	for _, v := range strings.Split(acceptEncoding, ",") {
+		p := strings.Split(strings.TrimSpace(v), ";")
+		name := strings.ToLower(strings.TrimSpace(p[0]))
+		accepted := true
+		for _, param := range p[1:] {
+			param = strings.TrimSpace(param)
+			if q, ok := strings.CutPrefix(param, "q="); ok {
+				if f, err := strconv.ParseFloat(q, 64); err == nil && f == 0 {
+					accepted = false
+				}
+			}
+		}
+		accepts[name] = accepted
+	}
+	for _, enc := range []string{"br", "gzip", "deflate"} {
+		if accepts[enc] {
+			return enc
+		}
+	}
+	return ""
+}
+
+func newCompressWriter(w io.Writer, encoding string) io.WriteCloser {
+	switch encoding {
+	case "br":
+		return brotli.NewWriter(w)
+	case "gzip":
+		return gzip.NewWriter(w)
+	case "deflate":
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	}
+	return nil
+}
+
+// Compress compresses the response body when the client accepts it and the upstream didn't compress.
+func (c *Compression) Compress(req *http.Request, resp *http.Response) *http.Response {
+	if c == nil || resp == nil || resp.Body == nil {
+		return resp
+	}
+	if req.Method == http.MethodHead {
+		return resp
+	}
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return resp
+	}
+	if resp.Header.Get("Content-Encoding") != "" {
+		// already compressed by the upstream
+		return resp
+	}
+	if !c.compressible(resp.Header.Get("Content-Type")) {
+		return resp
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength < c.MinLength {
+		return resp
+	}
+	encoding := negotiateEncoding(req.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return resp
+	}
+	slog.Debug(f("compress response %s by %s", req.URL, encoding))
+
+	body := resp.Body
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+		w := newCompressWriter(pw, encoding)
+		_, err := io.Copy(w, body)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+	resp.Body = pr
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	resp.Header.Set("Content-Encoding", encoding)
+	resp.Header.Add("Vary", "Accept-Encoding")
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		resp.Header.Set("ETag", "W/"+etag)
+	}
+	return resp
+}
@@ -0,0 +1,117 @@
+package mirageecs_test
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestCompression(t *testing.T) {
+	tests := []struct {
+		name            string
+		contentType     string
+		contentEncoding string
+		acceptEncoding  string
+		body            string
+		wantEncoding    string
+	}{
+		{
+			name:           "gzip html",
+			contentType:    "text/html; charset=utf-8",
+			acceptEncoding: "gzip, deflate",
+			body:           strings.Repeat("<p>hello</p>", 1024),
+			wantEncoding:   "gzip",
+		},
+		{
+			name:           "br preferred",
+			contentType:    "application/json",
+			acceptEncoding: "gzip, deflate, br",
+			body:           strings.Repeat(`{"hello":"world"}`, 1024),
+			wantEncoding:   "br",
+		},
+		{
+			name:           "br refused by q=0",
+			contentType:    "application/json",
+			acceptEncoding: "br;q=0, deflate",
+			body:           strings.Repeat(`{"hello":"world"}`, 1024),
+			wantEncoding:   "deflate",
+		},
+		{
+			name:           "not accepted",
+			contentType:    "text/html",
+			acceptEncoding: "",
+			body:           strings.Repeat("<p>hello</p>", 1024),
+			wantEncoding:   "",
+		},
+		{
+			name:           "not compressible",
+			contentType:    "image/png",
+			acceptEncoding: "gzip",
+			body:           strings.Repeat("x", 4096),
+			wantEncoding:   "",
+		},
+		{
+			name:           "too small",
+			contentType:    "text/plain",
+			acceptEncoding: "gzip",
+			body:           "small",
+			wantEncoding:   "",
+		},
+		{
+			name:            "already compressed",
+			contentType:     "text/plain",
+			contentEncoding: "identity-x",
+			acceptEncoding:  "gzip",
+			body:            strings.Repeat("x", 4096),
+			wantEncoding:    "identity-x",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				if tt.contentEncoding != "" {
+					w.Header().Set("Content-Encoding", tt.contentEncoding)
+				}
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+
+			tr := &mirageecs.Transport{
+				Counter:     mirageecs.NewAccessCounter(time.Second),
+				Transport:   mirageecs.NewHTTPTransport(time.Second),
+				Subdomain:   "test-subdomain",
+				Compression: &mirageecs.Compression{Enabled: true, ContentTypes: mirageecs.DefaultCompressibleContentTypes, MinLength: 1024},
+			}
+			req, _ := http.NewRequest("GET", server.URL, nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer resp.Body.Close()
+			if enc := resp.Header.Get("Content-Encoding"); enc != tt.wantEncoding {
+				t.Errorf("wanted encoding %q, got %q", tt.wantEncoding, enc)
+			}
+			if tt.wantEncoding == "gzip" {
+				r, err := gzip.NewReader(resp.Body)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				b, _ := io.ReadAll(r)
+				if string(b) != tt.body {
+					t.Errorf("body mismatch after decompression")
+				}
+			}
+		})
+	}
+}
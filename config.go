@@ -14,7 +14,9 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -23,8 +25,8 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	metadata "github.com/brunoscheufler/aws-ecs-metadata-go"
-	config "github.com/kayac/go-config"
 	"github.com/labstack/echo/v4"
+	bolt "go.etcd.io/bbolt"
 )
 
 var DefaultParameter = &Parameter{
@@ -36,19 +38,43 @@ var DefaultParameter = &Parameter{
 }
 
 type Config struct {
-	Host      Host       `yaml:"host"`
-	Listen    Listen     `yaml:"listen"`
-	Network   Network    `yaml:"network"`
-	HtmlDir   string     `yaml:"htmldir"`
-	Parameter Parameters `yaml:"parameters"`
-	ECS       ECSCfg     `yaml:"ecs"`
-	Link      Link       `yaml:"link"`
-	Auth      *Auth      `yaml:"auth"`
+	Host             Host              `yaml:"host"`
+	Listen           Listen            `yaml:"listen"`
+	Network          Network           `yaml:"network"`
+	HtmlDir          string            `yaml:"htmldir"`
+	Branding         *Branding         `yaml:"branding"`
+	Locale           string            `yaml:"locale"`
+	Parameter        Parameters        `yaml:"parameters"`
+	ECS              ECSCfg            `yaml:"ecs"`
+	Link             Link              `yaml:"link"`
+	Auth             *Auth             `yaml:"auth"`
+	Hooks            *Hooks            `yaml:"hooks"`
+	GitHub           *GitHub           `yaml:"github"`
+	Bitbucket        *Bitbucket        `yaml:"bitbucket"`
+	Slack            *Slack            `yaml:"slack"`
+	Purge            *Purge            `yaml:"purge"`
+	PurgeWarning     *PurgeWarning     `yaml:"purge_warning"`
+	Tracing          *Tracing          `yaml:"tracing"`
+	Events           *Events           `yaml:"events"`
+	History          *History          `yaml:"history"`
+	Persistence      *Persistence      `yaml:"persistence"`
+	Storage          *Storage          `yaml:"storage"`
+	Redis            *Redis            `yaml:"redis"`
+	HighAvailability *HighAvailability `yaml:"high_availability"`
+	Datadog          *Datadog          `yaml:"datadog"`
+	ErrorAlert       *ErrorAlert       `yaml:"error_alert"`
 
-	compatV1  bool
-	localMode bool
-	awscfg    *aws.Config
-	cleanups  []func() error
+	compatV1    bool
+	localMode   bool
+	awscfg      *aws.Config
+	cleanups    []func() error
+	history     historyStore
+	persistence persistenceStore
+	storage     *bolt.DB
+	redis       *redisClient
+	datadog     *datadogClient
+	params      *ConfigParams
+	source      []byte // rendered content of the config file
 }
 
 type ECSCfg struct {
@@ -59,9 +85,42 @@ type ECSCfg struct {
 	NetworkConfiguration     *NetworkConfiguration    `yaml:"network_configuration"`
 	DefaultTaskDefinition    string                   `yaml:"default_task_definition"`
 	EnableExecuteCommand     *bool                    `yaml:"enable_execute_command"`
+	RelaunchInterruptedTasks bool                     `yaml:"relaunch_interrupted_tasks"`
+	TaskDefinitionTemplate   string                   `yaml:"task_definition_template"`
+	HealthCheckTimeout       time.Duration            `yaml:"health_check_timeout"`
+	ServiceMode              bool                     `yaml:"service_mode"`
+	RelaunchOnFailure        *RelaunchOnFailure       `yaml:"relaunch_on_failure"`
+	EFS                      *EFSCfg                  `yaml:"efs"`
+	Sidecars                 []*Sidecar               `yaml:"sidecars"`
+	StoppedTaskRetention     time.Duration            `yaml:"stopped_task_retention"`
+	RuntimePlatform          *RuntimePlatform         `yaml:"runtime_platform"`
+	GPU                      *GPU                     `yaml:"gpu"`
+	TaskProtectionExpiresIn  time.Duration            `yaml:"task_protection_expires_in"`
+	ParameterTagPrefix       string                   `yaml:"parameter_tag_prefix"`
+	CloudMap                 *CloudMap                `yaml:"cloud_map"`
+	Windows                  *Windows                 `yaml:"windows"`
+	CommandOverride          *CommandOverride         `yaml:"command_override"`
+	SecurityGroupRules       []*SecurityGroupRule     `yaml:"security_group_rules"`
+	Endpoints                Endpoints                `yaml:"endpoints"`
+	Schedules                []*Schedule              `yaml:"schedules"`
+	IdleStop                 []*IdleStopRule          `yaml:"idle_stop"`
+	WarmPool                 *WarmPool                `yaml:"warm_pool"`
+	MaxRunningTasks          int                      `yaml:"max_running_tasks"`
+	Quotas                   []*Quota                 `yaml:"quotas"`
+	Cost                     *Cost                    `yaml:"cost"`
+	Budget                   *Budget                  `yaml:"budget"`
+	Refreshes                []*Refresh               `yaml:"refreshes"`
+	LaunchQueue              *LaunchQueue             `yaml:"launch_queue"`
+	AutoRedeploy             *AutoRedeploy            `yaml:"auto_redeploy"`
+	LogsInsights             *LogsInsights            `yaml:"logs_insights"`
+	Utilization              *Utilization             `yaml:"utilization"`
+	TaskEvents               *TaskEvents              `yaml:"task_events"`
+	Dashboard                *Dashboard               `yaml:"dashboard"`
 
 	capacityProviderStrategy []types.CapacityProviderStrategyItem `yaml:"-"`
 	networkConfiguration     *types.NetworkConfiguration          `yaml:"-"`
+	executeCommandByDefault  bool                                 `yaml:"-"` // enable_execute_command is not set explicitly
+	taskDefinitionTemplate   *template.Template                   `yaml:"-"`
 }
 
 func (c ECSCfg) String() string {
@@ -73,6 +132,33 @@ func (c ECSCfg) String() string {
 		"network_configuration":      c.networkConfiguration,
 		"default_task_definition":    c.DefaultTaskDefinition,
 		"enable_execute_command":     c.EnableExecuteCommand,
+		"relaunch_interrupted_tasks": c.RelaunchInterruptedTasks,
+		"task_definition_template":   c.TaskDefinitionTemplate,
+		"health_check_timeout":       c.HealthCheckTimeout.String(),
+		"service_mode":               c.ServiceMode,
+		"relaunch_on_failure":        c.RelaunchOnFailure,
+		"efs":                        c.EFS,
+		"sidecars":                   c.Sidecars,
+		"stopped_task_retention":     c.StoppedTaskRetention.String(),
+		"runtime_platform":           c.RuntimePlatform,
+		"gpu":                        c.GPU,
+		"task_protection_expires_in": c.TaskProtectionExpiresIn.String(),
+		"parameter_tag_prefix":       c.ParameterTagPrefix,
+		"cloud_map":                  c.CloudMap,
+		"windows":                    c.Windows,
+		"command_override":           c.CommandOverride,
+		"security_group_rules":       c.SecurityGroupRules,
+		"endpoints":                  c.Endpoints,
+		"schedules":                  c.Schedules,
+		"idle_stop":                  c.IdleStop,
+		"warm_pool":                  c.WarmPool,
+		"max_running_tasks":          c.MaxRunningTasks,
+		"quotas":                     c.Quotas,
+		"cost":                       c.Cost,
+		"budget":                     c.Budget,
+		"refreshes":                  c.Refreshes,
+		"launch_queue":               c.LaunchQueue,
+		"auto_redeploy":              c.AutoRedeploy,
 	}
 	b, _ := json.Marshal(m)
 	return string(b)
@@ -107,10 +193,59 @@ func (s CapacityProviderStrategy) toSDK() []types.CapacityProviderStrategyItem {
 	return items
 }
 
+// String returns a string representation of the strategy which can be stored in a tag value.
+// e.g. "FARGATE_SPOT:3:0 FARGATE:1:1" (capacity_provider:weight:base)
+func (s CapacityProviderStrategy) String() string {
+	items := make([]string, 0, len(s))
+	for _, item := range s {
+		items = append(items, fmt.Sprintf("%s:%d:%d", aws.ToString(item.CapacityProvider), item.Weight, item.Base))
+	}
+	return strings.Join(items, " ")
+}
+
+func parseCapacityProviderStrategy(s string) (CapacityProviderStrategy, error) {
+	var st CapacityProviderStrategy
+	for _, item := range strings.Fields(s) {
+		p := strings.Split(item, ":")
+		if len(p) != 3 {
+			return nil, fmt.Errorf("invalid capacity provider strategy item: %s", item)
+		}
+		weight, err := strconv.ParseInt(p[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight of capacity provider strategy item: %s", item)
+		}
+		base, err := strconv.ParseInt(p[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base of capacity provider strategy item: %s", item)
+		}
+		st = append(st, &CapacityProviderStrategyItem{
+			CapacityProvider: aws.String(p[0]),
+			Weight:           int32(weight),
+			Base:             int32(base),
+		})
+	}
+	return st, nil
+}
+
+func (s CapacityProviderStrategy) validate() error {
+	for _, item := range s {
+		if aws.ToString(item.CapacityProvider) == "" {
+			return fmt.Errorf("capacity_provider is required in capacity_provider_strategy")
+		}
+		if item.Weight < 0 || item.Weight > 1000 {
+			return fmt.Errorf("weight of capacity_provider_strategy must be between 0 and 1000")
+		}
+		if item.Base < 0 || item.Base > 100000 {
+			return fmt.Errorf("base of capacity_provider_strategy must be between 0 and 100000")
+		}
+	}
+	return nil
+}
+
 type CapacityProviderStrategyItem struct {
-	CapacityProvider *string `yaml:"capacity_provider"`
-	Weight           int32   `yaml:"weight"`
-	Base             int32   `yaml:"base"`
+	CapacityProvider *string `yaml:"capacity_provider" json:"capacity_provider"`
+	Weight           int32   `yaml:"weight" json:"weight"`
+	Base             int32   `yaml:"base" json:"base"`
 }
 
 func (i CapacityProviderStrategyItem) toSDK() types.CapacityProviderStrategyItem {
@@ -149,8 +284,14 @@ func (c *AwsVpcConfiguration) toSDK() *types.AwsVpcConfiguration {
 }
 
 type Host struct {
-	WebApi             string `yaml:"webapi"`
-	ReverseProxySuffix string `yaml:"reverse_proxy_suffix"`
+	WebApi             string       `yaml:"webapi"`
+	ReverseProxySuffix string       `yaml:"reverse_proxy_suffix"`
+	CatchAll           *CatchAll    `yaml:"catch_all"`
+	OnDemand           *OnDemand    `yaml:"on_demand"`
+	Records            *Records     `yaml:"records"`
+	Certificate        *Certificate `yaml:"certificate"`
+	Aliases            *Aliases     `yaml:"aliases"`
+	ExternalDNS        *ExternalDNS `yaml:"external_dns"`
 }
 
 type Link struct {
@@ -162,12 +303,15 @@ type Listen struct {
 	ForeignAddress string    `yaml:"foreign_address,omitempty"`
 	HTTP           []PortMap `yaml:"http,omitempty"`
 	HTTPS          []PortMap `yaml:"https,omitempty"`
+	// TLSPassthrough routes TLS connections to target ports of tasks by SNI without terminating TLS.
+	TLSPassthrough []PortMap `yaml:"tls_passthrough,omitempty"`
 }
 
 type PortMap struct {
 	ListenPort        int  `yaml:"listen"`
 	TargetPort        int  `yaml:"target"`
 	RequireAuthCookie bool `yaml:"require_auth_cookie"`
+	Streaming         bool `yaml:"streaming"`
 }
 
 type Parameter struct {
@@ -179,6 +323,14 @@ type Parameter struct {
 	Default     string            `yaml:"default"`
 	Description string            `yaml:"description"`
 	Options     []ParameterOption `yaml:"options"`
+	// Type is a type of values: string (default), select (one of options), boolean or integer (between min and max).
+	Type string `yaml:"type"`
+	Min  *int64 `yaml:"min"`
+	Max  *int64 `yaml:"max"`
+	// TaskDefinitions are families of task definitions which use the parameter. Empty means all.
+	TaskDefinitions []string `yaml:"task_definitions"`
+	// PropagateTag propagates the parameter to a tag prefixed by ecs.parameter_tag_prefix.
+	PropagateTag bool `yaml:"propagate_tag"`
 }
 
 type ParameterOption struct {
@@ -195,14 +347,55 @@ type ConfigParams struct {
 	DefaultPort int
 	CompatV1    bool
 	LogFormat   string
+	// LogOutput is stderr (default), stdout or a path of the file to append logs.
+	LogOutput string
+	// ReloadInterval is an interval to check changes of the config file. Zero disables checks.
+	ReloadInterval time.Duration
+	// Profile is a name of the profile in the profiles section to override the config.
+	Profile string
 }
 
 type Network struct {
-	ProxyTimeout time.Duration `yaml:"proxy_timeout"`
+	ProxyTimeout time.Duration       `yaml:"proxy_timeout"`
+	Compression  *Compression        `yaml:"compression"`
+	Rewrites     []*SubdomainRewrite `yaml:"rewrites"`
+
+	StreamingContentTypes []string `yaml:"streaming_content_types"`
+
+	// NamedPortRouting routes <name>-<subdomain> to named port mappings in task definitions.
+	NamedPortRouting bool `yaml:"named_port_routing"`
+
+	// ALBRouting routes requests to environments by listener rules of an ALB instead of mirage-ecs.
+	ALBRouting *ALBRouting `yaml:"alb_routing"`
+
+	// Private configures internal-only environments launched with visibility=private.
+	Private *Private `yaml:"private"`
+
+	// PreferIPv6 routes requests to IPv6 addresses of dual-stack tasks.
+	// Tasks without IPv4 addresses are routed by IPv6 addresses regardless of this.
+	PreferIPv6 bool `yaml:"prefer_ipv6"`
+
+	// HealthCheckPaths are path patterns of health checks. e.g. /healthz
+	// Requests to them are not counted as access, so they don't keep idle environments alive.
+	HealthCheckPaths []string `yaml:"health_check_paths"`
+
+	// AccessLog logs requests proxied to environments.
+	AccessLog bool `yaml:"access_log"`
+
+	// AccessLogExport delivers access logs of requests proxied to environments to S3 or Firehose.
+	AccessLogExport *AccessLogExport `yaml:"access_log_export"`
+
+	// AccessStatsExport writes hourly statistics of access to environments to S3.
+	AccessStatsExport *AccessStatsExport `yaml:"access_stats_export"`
+
+	// AccessCounter configures counters of access to environments.
+	AccessCounter *AccessCounterCfg `yaml:"access_counter"`
 }
 
 const DefaultPort = 80
 const DefaultProxyTimeout = 0
+
+const DefaultHealthCheckTimeout = 5 * time.Minute
 const AuthCookieName = "mirage-ecs-auth"
 const AuthCookieExpire = 24 * time.Hour
 
@@ -221,7 +414,7 @@ func NewConfig(ctx context.Context, p *ConfigParams) (*Config, error) {
 			ReverseProxySuffix: domain,
 		},
 		Listen: Listen{
-			ForeignAddress: "0.0.0.0",
+			ForeignAddress: "", // all addresses of IPv4 and IPv6
 			HTTP: []PortMap{
 				{ListenPort: p.DefaultPort, TargetPort: p.DefaultPort},
 			},
@@ -230,28 +423,26 @@ func NewConfig(ctx context.Context, p *ConfigParams) (*Config, error) {
 		Network: Network{
 			ProxyTimeout: DefaultProxyTimeout,
 		},
-		HtmlDir: "./html",
+		HtmlDir: DefaultHtmlDir,
+		Locale:  DefaultLocale,
 		ECS: ECSCfg{
-			Region: os.Getenv("AWS_REGION"),
+			Region:                  os.Getenv("AWS_REGION"),
+			HealthCheckTimeout:      DefaultHealthCheckTimeout,
+			TaskProtectionExpiresIn: DefaultTaskProtectionExpiresIn,
+			ParameterTagPrefix:      DefaultParameterTagPrefix,
 		},
 		Auth: nil,
 
 		localMode: p.LocalMode,
 		compatV1:  p.CompatV1,
+		params:    p,
 	}
-	opt := &slog.HandlerOptions{
-		Level:     LogLevel,
-		AddSource: true,
-	}
-
-	switch p.LogFormat {
-	case "text", "":
-		slog.SetDefault(slog.New(NewLogHandler(os.Stderr, opt)))
-	case "json":
-		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opt)))
-	default:
-		return nil, fmt.Errorf("invalid log format (text or json): %s", p.LogFormat)
+	logger, closeLog, err := newLogger(p.LogFormat, p.LogOutput)
+	if err != nil {
+		return nil, err
 	}
+	slog.SetDefault(logger)
+	cfg.cleanups = append(cfg.cleanups, closeLog)
 
 	if awscfg, err := awsv2Config.LoadDefaultConfig(ctx, awsv2Config.WithRegion(cfg.ECS.Region)); err != nil {
 		return nil, err
@@ -260,24 +451,41 @@ func NewConfig(ctx context.Context, p *ConfigParams) (*Config, error) {
 	}
 
 	if p.Path == "" {
+		if p.Profile != "" {
+			return nil, fmt.Errorf("profile requires a config file")
+		}
 		slog.Info(f("no config file specified, using default config with domain suffix: %s", domain))
 	} else {
-		var content []byte
-		var err error
-		if strings.HasPrefix(p.Path, "s3://") {
-			content, err = loadFromS3(ctx, cfg.awscfg, p.Path)
-		} else {
-			content, err = loadFromFile(p.Path)
+		slog.Info(f("loading config file: %s", p.Path))
+		if p.Profile != "" {
+			slog.Info(f("using profile: %s", p.Profile))
 		}
+		rendered, err := loadConfigDocument(ctx, cfg.awscfg, p.Path)
 		if err != nil {
 			return nil, fmt.Errorf("cannot load config: %s: %w", p.Path, err)
 		}
-		slog.Info(f("loading config file: %s", p.Path))
-		if err := config.LoadWithEnvBytes(&cfg, content); err != nil {
+		if err := unmarshalConfig(cfg, rendered, p.Profile); err != nil {
+			return nil, fmt.Errorf("cannot load config: %s: %w", p.Path, err)
+		}
+		if err := decryptConfig(cfg, kmsDecrypter(ctx, cfg.awscfg)); err != nil {
 			return nil, fmt.Errorf("cannot load config: %s: %w", p.Path, err)
 		}
+		cfg.source = rendered
+	}
+	if p.ReloadInterval != 0 {
+		if p.Path == "" {
+			return nil, fmt.Errorf("reload interval requires a config file")
+		}
+		if p.ReloadInterval < DefaultSyncInterval {
+			return nil, fmt.Errorf("reload interval must be at least %s: %s", DefaultSyncInterval, p.ReloadInterval)
+		}
 	}
 
+	if err := cfg.ECS.Endpoints.validate(); err != nil {
+		return nil, err
+	}
+	cfg.applyAWSConfig()
+
 	addDefaultParameter := true
 	for _, v := range cfg.Parameter {
 		if v.Name == DefaultParameter.Name {
@@ -290,13 +498,218 @@ func NewConfig(ctx context.Context, p *ConfigParams) (*Config, error) {
 	}
 
 	for _, v := range cfg.Parameter {
-		if v.Rule != "" {
-			paramRegex, err := regexp.Compile(v.Rule)
-			if err != nil {
-				return nil, fmt.Errorf("invalid parameter rule: %s: %w", v.Rule, err)
-			}
-			v.Regexp = *paramRegex
+		if err := validateParameterName(v.Name); err != nil {
+			return nil, err
 		}
+		if err := v.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.Host.CatchAll.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Host.OnDemand.validate(cfg.ECS.TaskDefinitionTemplate); err != nil {
+		return nil, err
+	}
+	if err := cfg.Host.Records.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Host.Certificate.validate(cfg.Host.ReverseProxySuffix); err != nil {
+		return nil, err
+	}
+	if err := cfg.Host.Aliases.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Host.ExternalDNS.validate(cfg.Host.Records); err != nil {
+		return nil, err
+	}
+
+	for _, r := range cfg.Network.Rewrites {
+		if err := r.Rules.compile(); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateTLSPassthrough(cfg.Listen); err != nil {
+		return nil, err
+	}
+	if err := cfg.Network.ALBRouting.validate(cfg.Listen); err != nil {
+		return nil, err
+	}
+	if err := cfg.Network.Private.validate(); err != nil {
+		return nil, err
+	}
+	for _, p := range cfg.Network.HealthCheckPaths {
+		if _, err := path.Match(p, ""); err != nil || !strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("invalid network.health_check_paths: %s", p)
+		}
+	}
+	if err := cfg.Network.AccessCounter.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Network.AccessLogExport.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Network.AccessStatsExport.validate(cfg.Network.AccessCounter.retention()); err != nil {
+		return nil, err
+	}
+
+	cfg.ECS.RelaunchOnFailure.fillDefaults()
+	if err := cfg.ECS.EFS.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.ECS.RuntimePlatform.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.ECS.GPU.validate(); err != nil {
+		return nil, err
+	}
+	if err := validateTaskProtectionExpiresIn(cfg.ECS.TaskProtectionExpiresIn); err != nil {
+		return nil, err
+	}
+	if err := validateParameterTagPrefix(cfg.ECS.ParameterTagPrefix, cfg.Parameter); err != nil {
+		return nil, err
+	}
+	if err := cfg.ECS.CloudMap.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.ECS.Windows.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.ECS.CommandOverride.validate(); err != nil {
+		return nil, err
+	}
+	for _, r := range cfg.ECS.SecurityGroupRules {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateSchedules(cfg.ECS.Schedules); err != nil {
+		return nil, err
+	}
+	for _, r := range cfg.ECS.IdleStop {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.ECS.WarmPool.validate(cfg.ECS); err != nil {
+		return nil, err
+	}
+	if cfg.ECS.MaxRunningTasks < 0 {
+		return nil, fmt.Errorf("ecs.max_running_tasks must not be negative: %d", cfg.ECS.MaxRunningTasks)
+	}
+	for _, q := range cfg.ECS.Quotas {
+		if err := q.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.ECS.Cost.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.ECS.Budget.validate(cfg.ECS.Cost); err != nil {
+		return nil, err
+	}
+	for _, r := range cfg.ECS.Refreshes {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.ECS.LaunchQueue.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.ECS.AutoRedeploy.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.ECS.LogsInsights.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.ECS.Utilization.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.ECS.TaskEvents.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.ECS.Dashboard.validate(); err != nil {
+		return nil, err
+	}
+	for _, s := range cfg.ECS.Sidecars {
+		if err := s.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.Hooks.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.GitHub.validate(cfg.ECS.TaskDefinitionTemplate); err != nil {
+		return nil, err
+	}
+	if err := cfg.Bitbucket.validate(cfg.ECS.TaskDefinitionTemplate); err != nil {
+		return nil, err
+	}
+	if err := cfg.Slack.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Purge.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.PurgeWarning.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Events.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Storage.validate(); err != nil {
+		return nil, err
+	}
+	cfg.Storage.applyDefaults(cfg)
+	if err := cfg.History.validate(cfg.Storage); err != nil {
+		return nil, err
+	}
+	if err := cfg.Persistence.validate(cfg.Storage); err != nil {
+		return nil, err
+	}
+	if err := cfg.Redis.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.HighAvailability.validate(cfg.Redis); err != nil {
+		return nil, err
+	}
+	if err := cfg.Datadog.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.ErrorAlert.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Branding.validate(); err != nil {
+		return nil, err
+	}
+	if err := validateLocale(cfg.Locale); err != nil {
+		return nil, err
+	}
+	if err := cfg.Tracing.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.setupTracing(ctx); err != nil {
+		return nil, err
+	}
+	if err := cfg.openStorage(); err != nil {
+		return nil, err
+	}
+	if err := cfg.openHistory(); err != nil {
+		return nil, err
+	}
+	if err := cfg.openPersistence(); err != nil {
+		return nil, err
+	}
+	if err := cfg.openRedis(); err != nil {
+		return nil, err
+	}
+	if err := cfg.openDatadog(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.loadTaskDefinitionTemplate(ctx); err != nil {
+		return nil, err
 	}
 
 	if strings.HasPrefix(cfg.HtmlDir, "s3://") {
@@ -330,11 +743,28 @@ func (c *Config) Cleanup() {
 }
 
 func (c *Config) NewTaskRunner() TaskRunner {
+	var runner TaskRunner
 	if c.localMode {
-		return NewLocalTaskRunner(c)
+		runner = NewLocalTaskRunner(c)
 	} else {
-		return NewECSTaskRunner(c)
+		runner = NewECSTaskRunner(c)
+	}
+	if c.Hooks != nil {
+		runner = newHookRunner(c, runner)
+	}
+	if c.Events != nil {
+		runner = newEventRunner(c, runner)
+	}
+	if c.datadog != nil {
+		runner = newDatadogRunner(c, runner)
+	}
+	if c.history != nil {
+		runner = newHistoryRunner(c, runner)
+	}
+	if c.Tracing != nil {
+		runner = &tracingRunner{TaskRunner: runner}
 	}
+	return runner
 }
 
 func (c *Config) fillECSDefaults(ctx context.Context) error {
@@ -361,6 +791,7 @@ func (c *Config) fillECSDefaults(ctx context.Context) error {
 	}
 	if c.ECS.EnableExecuteCommand == nil {
 		c.ECS.EnableExecuteCommand = aws.Bool(true)
+		c.ECS.executeCommandByDefault = true
 		slog.Info(f("enable_execute_command is not set, using enable_execute_command=%t", *c.ECS.EnableExecuteCommand))
 	}
 
@@ -487,6 +918,26 @@ func (cfg *Config) AuthMiddlewareForAPI(next echo.HandlerFunc) echo.HandlerFunc
 	}
 }
 
+// AuthMiddlewareForAdmin allows admin operations only by the admin token.
+func (cfg *Config) AuthMiddlewareForAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if cfg.Auth == nil || cfg.Auth.Admin == nil {
+			slog.Warn(f("admin operation %s is disabled without auth.admin", c.Request().URL.Path))
+			return echo.ErrForbidden
+		}
+		ok, err := cfg.Auth.Do(c.Request(), c.Response(), cfg.Auth.ByAdminToken)
+		if err != nil {
+			slog.Error(f("auth error: %s", err))
+			return echo.ErrInternalServerError
+		}
+		if !ok {
+			slog.Warn(f("admin auth failed"))
+			return echo.ErrForbidden
+		}
+		return next(c)
+	}
+}
+
 func (cfg *Config) CompatMiddlewareForAPI(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		req := c.Request()
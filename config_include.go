@@ -0,0 +1,158 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// includeKey is the top-level key of files to include in the config file.
+	includeKey = "include"
+	// maxIncludeDepth is the maximum depth of nested includes.
+	maxIncludeDepth = 8
+)
+
+// configLoader loads the config file and files included by it.
+type configLoader struct {
+	ctx    context.Context
+	awscfg *aws.Config
+	lookup func(name string) (string, error)
+}
+
+// loadConfigDocument loads the config file, and merges files included by it.
+// Each file is rendered separately. The rendered content is returned as is when it includes no files, to keep lines of errors.
+func loadConfigDocument(ctx context.Context, awscfg *aws.Config, p string) ([]byte, error) {
+	l := &configLoader{ctx: ctx, awscfg: awscfg, lookup: ssmParameterLookup(ctx, awscfg)}
+	rendered, doc, included, err := l.load(p, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !included {
+		return rendered, nil
+	}
+	return yaml.Marshal(doc)
+}
+
+// load returns the rendered content of the file, the document merged with included files, and whether it includes files.
+func (l *configLoader) load(p string, parents []string) ([]byte, map[interface{}]interface{}, bool, error) {
+	for _, parent := range parents {
+		if parent == p {
+			return nil, nil, false, fmt.Errorf("circular include: %s", strings.Join(append(parents, p), " -> "))
+		}
+	}
+	if len(parents) > maxIncludeDepth {
+		return nil, nil, false, fmt.Errorf("too deep include: %s", strings.Join(append(parents, p), " -> "))
+	}
+	content, err := loadConfigSource(l.ctx, l.awscfg, p)
+	if err != nil {
+		if len(parents) > 0 {
+			return nil, nil, false, fmt.Errorf("cannot include %s: %w", p, err)
+		}
+		return nil, nil, false, err
+	}
+	rendered, err := renderConfig(content, l.lookup)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("%s: %w", p, err)
+	}
+	if rendered, err = rewriteKMSTags(rendered); err != nil {
+		return nil, nil, false, fmt.Errorf("%s: %w", p, err)
+	}
+	var doc map[interface{}]interface{}
+	if err := yaml.UnmarshalStrict(rendered, &doc); err != nil {
+		return nil, nil, false, fmt.Errorf("%s: invalid config: %w", p, err)
+	}
+	v, ok := doc[includeKey]
+	if !ok {
+		return rendered, doc, false, nil
+	}
+	delete(doc, includeKey)
+	var includes []string
+	if b, err := yaml.Marshal(v); err != nil {
+		return nil, nil, false, err
+	} else if err := yaml.UnmarshalStrict(b, &includes); err != nil {
+		return nil, nil, false, fmt.Errorf("%s: include must be a list of files: %w", p, err)
+	}
+
+	chain := append(parents[:len(parents):len(parents)], p)
+	merged := make(map[interface{}]interface{})
+	for _, inc := range includes {
+		files, err := resolveInclude(p, inc)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("%s: %w", p, err)
+		}
+		for _, file := range files {
+			_, d, _, err := l.load(file, chain)
+			if err != nil {
+				return nil, nil, false, err
+			}
+			merged = appendYAML(merged, d)
+		}
+	}
+	return rendered, appendYAML(merged, doc), true, nil
+}
+
+// resolveInclude returns paths of the included files relative to the including file.
+// Glob patterns (e.g. taskdefs/*.yml) are expanded only for local files.
+func resolveInclude(parent, inc string) ([]string, error) {
+	if inc == "" {
+		return nil, fmt.Errorf("include must not be empty")
+	}
+	if strings.Contains(inc, "://") {
+		return []string{inc}, nil
+	}
+	if strings.Contains(parent, "://") {
+		u, err := url.Parse(parent)
+		if err != nil {
+			return nil, err
+		}
+		if path.IsAbs(inc) {
+			u.Path = inc
+		} else {
+			u.Path = path.Join(path.Dir(u.Path), inc)
+		}
+		return []string{u.String()}, nil
+	}
+	if !filepath.IsAbs(inc) {
+		inc = filepath.Join(filepath.Dir(parent), inc)
+	}
+	if !strings.ContainsAny(inc, "*?[") {
+		return []string{inc}, nil
+	}
+	files, err := filepath.Glob(inc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include: %s: %w", inc, err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// appendYAML merges overlay over base recursively. Lists are appended, and other values are replaced.
+func appendYAML(base, overlay map[interface{}]interface{}) map[interface{}]interface{} {
+	if base == nil {
+		base = make(map[interface{}]interface{}, len(overlay))
+	}
+	for k, v := range overlay {
+		switch ov := v.(type) {
+		case map[interface{}]interface{}:
+			if bm, ok := base[k].(map[interface{}]interface{}); ok {
+				base[k] = appendYAML(bm, ov)
+				continue
+			}
+		case []interface{}:
+			if bl, ok := base[k].([]interface{}); ok {
+				base[k] = append(bl, ov...)
+				continue
+			}
+		}
+		base[k] = v
+	}
+	return base
+}
@@ -0,0 +1,125 @@
+package mirageecs_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestConfigInclude(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yml": `include:
+  - parameters.yml
+  - taskdefs/*.yml
+htmldir: ./html
+network:
+  proxy_timeout: 1m
+parameters:
+  - name: nick
+    env: NICK
+`,
+		"parameters.yml": `network:
+  proxy_timeout: 30s
+  access_log: true
+parameters:
+  - name: branch
+    env: GIT_BRANCH
+    required: true
+`,
+		"taskdefs/api.yml": `parameters:
+  - name: db_snapshot
+    env: DB_SNAPSHOT
+    task_definitions: [api]
+`,
+		"taskdefs/docs.yml": `parameters:
+  - name: docs_version
+    env: DOCS_VERSION
+    task_definitions: [docs]
+`,
+	})
+	cfg, err := mirageecs.NewConfig(context.Background(), &mirageecs.ConfigParams{Path: filepath.Join(dir, "config.yml"), LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range cfg.Parameter {
+		names = append(names, p.Name)
+	}
+	if s := strings.Join(names, ","); s != "branch,db_snapshot,docs_version,nick" {
+		t.Errorf("parameters must be appended in order: %s", s)
+	}
+	if cfg.Network.ProxyTimeout.String() != "1m0s" || !cfg.Network.AccessLog {
+		t.Errorf("unexpected network: %s %v", cfg.Network.ProxyTimeout, cfg.Network.AccessLog)
+	}
+}
+
+func TestConfigIncludeErrors(t *testing.T) {
+	cases := []struct {
+		files map[string]string
+		want  string
+	}{
+		{
+			files: map[string]string{"config.yml": "include: [a.yml]\n", "a.yml": "include: [config.yml]\n"},
+			want:  "circular include",
+		},
+		{
+			files: map[string]string{"config.yml": "include: [missing.yml]\n"},
+			want:  "cannot include",
+		},
+		{
+			files: map[string]string{"config.yml": "include: a.yml\n"},
+			want:  "include must be a list of files",
+		},
+		{
+			files: map[string]string{"config.yml": "include: [a.yml]\n", "a.yml": "netwrk:\n  access_log: true\n"},
+			want:  "unknown field netwrk",
+		},
+	}
+	for _, c := range cases {
+		dir := writeConfigFiles(t, c.files)
+		_, err := mirageecs.NewConfig(context.Background(), &mirageecs.ConfigParams{Path: filepath.Join(dir, "config.yml"), LocalMode: true})
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%v: unexpected error: %v", c.files, err)
+		}
+	}
+}
+
+func TestResolveInclude(t *testing.T) {
+	cases := []struct {
+		parent string
+		inc    string
+		want   string
+	}{
+		{"/etc/mirage/config.yml", "auth.yml", "/etc/mirage/auth.yml"},
+		{"/etc/mirage/config.yml", "/opt/auth.yml", "/opt/auth.yml"},
+		{"s3://bucket/mirage/config.yml", "auth.yml", "s3://bucket/mirage/auth.yml"},
+		{"s3://bucket/mirage/config.yml", "../shared/auth.yml", "s3://bucket/shared/auth.yml"},
+		{"ssm:///mirage/config", "auth", "ssm:///mirage/auth"},
+		{"/etc/mirage/config.yml", "s3://bucket/auth.yml", "s3://bucket/auth.yml"},
+	}
+	for _, c := range cases {
+		files, err := mirageecs.ResolveInclude(c.parent, c.inc)
+		if err != nil || len(files) != 1 || files[0] != c.want {
+			t.Errorf("%s %s: unexpected files %v %v", c.parent, c.inc, files, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package mirageecs
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// kmsPrefix is the prefix of values encrypted by KMS in the config file. The rest is the base64 encoded ciphertext.
+const kmsPrefix = "kms:"
+
+var (
+	// kmsTagPattern matches values tagged by !kms, which may be quoted.
+	kmsTagPattern = regexp.MustCompile(`!kms[ \t]+(?:"([A-Za-z0-9+/=]*)"|'([A-Za-z0-9+/=]*)'|([A-Za-z0-9+/=]+))`)
+	// kmsTagRemains matches !kms tags not rewritten by kmsTagPattern.
+	kmsTagRemains = regexp.MustCompile(`(?m)(^|[\s:,\[{-])!kms\b`)
+
+	configType = reflect.TypeOf(Config{})
+)
+
+// rewriteKMSTags rewrites values tagged by !kms to the kms: prefix, to keep them through merges of profiles and includes.
+// Lines are kept as is.
+func rewriteKMSTags(rendered []byte) ([]byte, error) {
+	if !strings.Contains(string(rendered), "!kms") {
+		return rendered, nil
+	}
+	rewritten := kmsTagPattern.ReplaceAll(rendered, []byte(`"`+kmsPrefix+`${1}${2}${3}"`))
+	if loc := kmsTagRemains.FindIndex(rewritten); loc != nil {
+		line := strings.Count(string(rewritten[:loc[1]]), "\n") + 1
+		return nil, fmt.Errorf("line %d: !kms must be followed by a base64 encoded ciphertext", line)
+	}
+	return rewritten, nil
+}
+
+// decryptConfig replaces string values with the kms: prefix in the config by decrypted values.
+func decryptConfig(cfg *Config, decrypt func(ciphertext string) (string, error)) error {
+	cache := make(map[string]string)
+	return decryptValue(reflect.ValueOf(cfg).Elem(), "", func(name, v string) (string, error) {
+		ciphertext, ok := strings.CutPrefix(v, kmsPrefix)
+		if !ok {
+			return v, nil
+		}
+		if plaintext, ok := cache[ciphertext]; ok {
+			return plaintext, nil
+		}
+		plaintext, err := decrypt(ciphertext)
+		if err != nil {
+			return "", fmt.Errorf("cannot decrypt %s: %w", name, err)
+		}
+		cache[ciphertext] = plaintext
+		return plaintext, nil
+	})
+}
+
+// decryptValue walks structs of this package, pointers, slices and maps, and replaces strings by decrypt.
+// Names of values are paths of yaml keys (e.g. auth.basic.password) for errors.
+func decryptValue(v reflect.Value, name string, decrypt func(name, v string) (string, error)) error {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return decryptValue(v.Elem(), name, decrypt)
+	case reflect.String:
+		s, err := decrypt(name, v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := decryptValue(v.Index(i), fmt.Sprintf("%s[%d]", name, i), decrypt); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			// map values are not addressable
+			e := reflect.New(iter.Value().Type()).Elem()
+			e.Set(iter.Value())
+			if err := decryptValue(e, fmt.Sprintf("%s.%v", name, iter.Key()), decrypt); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), e)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		if t.PkgPath() != configType.PkgPath() {
+			return nil
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			key, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if key == "-" {
+				continue
+			}
+			if key == "" {
+				key = strings.ToLower(field.Name)
+			}
+			fieldName := name
+			if !strings.Contains(opts, "inline") {
+				fieldName = strings.TrimPrefix(name+"."+key, ".")
+			}
+			if err := decryptValue(v.Field(i), fieldName, decrypt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// kmsDecrypter returns a function to decrypt base64 encoded ciphertexts by KMS.
+func kmsDecrypter(ctx context.Context, awscfg *aws.Config) func(ciphertext string) (string, error) {
+	return func(ciphertext string) (string, error) {
+		blob, err := base64.StdEncoding.DecodeString(ciphertext)
+		if err != nil {
+			return "", fmt.Errorf("invalid ciphertext: %w", err)
+		}
+		ctx, cancel := context.WithTimeout(ctx, APICallTimeout)
+		defer cancel()
+		svc := kms.NewFromConfig(*awscfg)
+		out, err := svc.Decrypt(ctx, &kms.DecryptInput{CiphertextBlob: blob})
+		if err != nil {
+			return "", err
+		}
+		return string(out.Plaintext), nil
+	}
+}
@@ -0,0 +1,81 @@
+package mirageecs_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+const kmsConfig = `
+auth:
+  basic:
+    username: mirage
+    password: !kms AQICAHpassword==
+  token:
+    token: !kms "AQICAHtoken"
+    header: x-mirage-token
+  admin:
+    token: kms:AQICAHadmin
+  cookie_secret: !kms 'AQICAHpassword=='
+profiles:
+  staging:
+    auth:
+      cookie_secret: !kms AQICAHstaging
+`
+
+func TestKMSConfig(t *testing.T) {
+	rewritten, err := mirageecs.RewriteKMSTags([]byte(kmsConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(rewritten), "\n") != strings.Count(kmsConfig, "\n") {
+		t.Errorf("lines are changed: %s", rewritten)
+	}
+	for _, profile := range []string{"", "staging"} {
+		var cfg mirageecs.Config
+		if err := mirageecs.UnmarshalConfig(&cfg, rewritten, profile); err != nil {
+			t.Fatal(err)
+		}
+		calls := 0
+		err := cfg.Decrypt(func(ciphertext string) (string, error) {
+			calls++
+			return "decrypted-" + strings.TrimPrefix(ciphertext, "AQICAH"), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookieSecret, expectedCalls := "decrypted-password==", 3
+		if profile == "staging" {
+			cookieSecret, expectedCalls = "decrypted-staging", 4
+		}
+		a := cfg.Auth
+		if a.Basic.Username != "mirage" || a.Basic.Password != "decrypted-password==" ||
+			a.Token.Token != "decrypted-token" || a.Token.Header != "x-mirage-token" ||
+			a.Admin.Token != "decrypted-admin" || a.CookieSecret != cookieSecret {
+			t.Errorf("unexpected auth (profile %q): %#v %#v %#v %s", profile, a.Basic, a.Token, a.Admin, a.CookieSecret)
+		}
+		// the same ciphertext is decrypted once
+		if calls != expectedCalls {
+			t.Errorf("unexpected calls of decrypt: %d", calls)
+		}
+	}
+}
+
+func TestKMSConfigError(t *testing.T) {
+	if _, err := mirageecs.RewriteKMSTags([]byte("auth:\n  basic:\n    password: !kms |\n      AQICAH\n")); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("expected error of line 3: %v", err)
+	}
+
+	var cfg mirageecs.Config
+	if err := mirageecs.UnmarshalConfig(&cfg, []byte("auth:\n  basic:\n    password: kms:AQICAH\n"), ""); err != nil {
+		t.Fatal(err)
+	}
+	err := cfg.Decrypt(func(string) (string, error) {
+		return "", fmt.Errorf("AccessDeniedException")
+	})
+	if err == nil || !strings.Contains(err.Error(), "auth.basic.password") {
+		t.Errorf("expected error of auth.basic.password: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package mirageecs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// profilesKey is the top-level key of profiles in the config file.
+const profilesKey = "profiles"
+
+// applyProfile merges the profile in the profiles section over the rest of the config, and removes the profiles section.
+// Mappings are merged recursively, and other values (including lists) are replaced.
+// The content is returned as is when it has no profiles and the profile is not specified, to keep lines of errors.
+func applyProfile(rendered []byte, profile string) ([]byte, error) {
+	var doc map[interface{}]interface{}
+	if err := yaml.UnmarshalStrict(rendered, &doc); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	profiles, ok := doc[profilesKey]
+	if !ok {
+		if profile != "" {
+			return nil, fmt.Errorf("profile %s is not found: the config has no profiles", profile)
+		}
+		return rendered, nil
+	}
+	delete(doc, profilesKey)
+	if profile != "" {
+		m, ok := profiles.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("profiles must be a mapping of names to configs")
+		}
+		overlay, ok := m[profile]
+		if !ok {
+			return nil, fmt.Errorf("profile %s is not found in %s", profile, strings.Join(profileNames(m), ", "))
+		}
+		o, ok := overlay.(map[interface{}]interface{})
+		if !ok && overlay != nil {
+			return nil, fmt.Errorf("profiles.%s must be a mapping", profile)
+		}
+		if _, ok := o[profilesKey]; ok {
+			return nil, fmt.Errorf("profiles.%s must not have profiles", profile)
+		}
+		doc = mergeYAML(doc, o)
+	}
+	return yaml.Marshal(doc)
+}
+
+// mergeYAML merges overlay over base recursively.
+func mergeYAML(base, overlay map[interface{}]interface{}) map[interface{}]interface{} {
+	if base == nil {
+		base = make(map[interface{}]interface{}, len(overlay))
+	}
+	for k, v := range overlay {
+		bm, ok1 := base[k].(map[interface{}]interface{})
+		om, ok2 := v.(map[interface{}]interface{})
+		if ok1 && ok2 {
+			base[k] = mergeYAML(bm, om)
+		} else {
+			base[k] = v
+		}
+	}
+	return base
+}
+
+func profileNames(profiles map[interface{}]interface{}) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, fmt.Sprint(name))
+	}
+	sort.Strings(names)
+	return names
+}
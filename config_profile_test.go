@@ -0,0 +1,97 @@
+package mirageecs_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+const profilesConfig = `
+htmldir: ./html
+network:
+  proxy_timeout: 30s
+  access_log: true
+ecs:
+  cluster: mirage-dev
+  default_task_definition: myapp-dev
+  network_configuration:
+    awsvpc_configuration:
+      subnets: [subnet-dev-a, subnet-dev-c]
+      assign_public_ip: ENABLED
+profiles:
+  staging:
+    network:
+      proxy_timeout: 1m
+    ecs:
+      cluster: mirage-staging
+      network_configuration:
+        awsvpc_configuration:
+          subnets: [subnet-stg-a]
+  empty:
+`
+
+func TestConfigProfiles(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(p, []byte(profilesConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{Path: p, LocalMode: true, Profile: "staging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vpc := cfg.ECS.NetworkConfiguration.AwsVpcConfiguration
+	if cfg.ECS.Cluster != "mirage-staging" || cfg.ECS.DefaultTaskDefinition != "myapp-dev" {
+		t.Errorf("unexpected ecs: %s %s", cfg.ECS.Cluster, cfg.ECS.DefaultTaskDefinition)
+	}
+	if len(vpc.Subnets) != 1 || vpc.Subnets[0] != "subnet-stg-a" || vpc.AssignPublicIp != "ENABLED" {
+		t.Errorf("unexpected awsvpc configuration: %#v", vpc)
+	}
+	if cfg.Network.ProxyTimeout.String() != "1m0s" || !cfg.Network.AccessLog {
+		t.Errorf("unexpected network: %s %v", cfg.Network.ProxyTimeout, cfg.Network.AccessLog)
+	}
+
+	for _, profile := range []string{"", "empty"} {
+		cfg, err = mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{Path: p, LocalMode: true, Profile: profile})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.ECS.Cluster != "mirage-dev" || len(cfg.ECS.NetworkConfiguration.AwsVpcConfiguration.Subnets) != 2 {
+			t.Errorf("%q: unexpected ecs: %s %v", profile, cfg.ECS.Cluster, cfg.ECS.NetworkConfiguration.AwsVpcConfiguration.Subnets)
+		}
+	}
+
+	if _, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{Path: p, LocalMode: true, Profile: "prod"}); err == nil || !strings.Contains(err.Error(), "empty, staging") {
+		t.Errorf("unknown profiles must fail with names of profiles: %v", err)
+	}
+	if _, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true, Profile: "staging"}); err == nil {
+		t.Error("profile without a config file must fail")
+	}
+}
+
+func TestConfigProfilesStrict(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{"htmldir: ./html\nprofiles:\n  staging:\n    ecs:\n      clustr: mirage\n", "unknown field ecs.clustr"},
+		{"htmldir: ./html\nprofiles:\n  staging:\n    profiles: {}\n", "must not have profiles"},
+		{"htmldir: ./html\nprofiles: [staging]\n", "must be a mapping"},
+		{"htmldir: ./html\n", "has no profiles"},
+	}
+	for _, c := range cases {
+		p := filepath.Join(t.TempDir(), "config.yml")
+		if err := os.WriteFile(p, []byte(c.src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := mirageecs.NewConfig(context.Background(), &mirageecs.ConfigParams{Path: p, LocalMode: true, Profile: "staging"})
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%q: unexpected error: %v", c.src, err)
+		}
+	}
+}
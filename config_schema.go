@@ -0,0 +1,310 @@
+package mirageecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// ConfigSchemaID is the $schema of the JSON Schema of the config file.
+const ConfigSchemaID = "https://json-schema.org/draft/2020-12/schema"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// unmarshalConfig validates the rendered config with the profile by the JSON Schema, and decodes it strictly.
+// Unknown keys and values of wrong types are errors with their lines and fields.
+func unmarshalConfig(cfg *Config, rendered []byte, profile string) error {
+	rendered, err := applyProfile(rendered, profile)
+	if err != nil {
+		return err
+	}
+	if err := validateConfigSchema(rendered); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(rendered, cfg); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	cfg.Storage = cfg.Storage.migrateLegacy(cfg)
+	return nil
+}
+
+// ConfigSchema returns the JSON Schema of the config file.
+func ConfigSchema() ([]byte, error) {
+	return json.MarshalIndent(configSchema(), "", "  ")
+}
+
+func configSchema() map[string]any {
+	g := &schemaGenerator{defs: make(map[string]any)}
+	root := g.schemaOf(reflect.TypeOf(Config{}))
+	// profiles are partial configs, and included files are merged before decoding
+	props := g.defs["Config"].(map[string]any)["properties"].(map[string]any)
+	props[profilesKey] = map[string]any{
+		"type":                 "object",
+		"additionalProperties": root,
+	}
+	props[includeKey] = map[string]any{
+		"type":  "array",
+		"items": map[string]any{"type": "string"},
+	}
+	schema := map[string]any{
+		"$schema": ConfigSchemaID,
+		"title":   "mirage-ecs config",
+		"$ref":    root["$ref"],
+		"$defs":   g.defs,
+	}
+	return schema
+}
+
+// schemaGenerator generates JSON Schema of types by the yaml tags of fields. Structs are defined in $defs by their names.
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+func (g *schemaGenerator) schemaOf(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == durationType {
+		// yaml.v2 decodes durations from strings (e.g. 5m) and nanoseconds
+		return map[string]any{"type": []string{"string", "integer"}}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		ref := map[string]any{"$ref": "#/$defs/" + name}
+		if _, ok := g.defs[name]; ok {
+			return ref
+		}
+		// define before properties for recursive types
+		def := map[string]any{"type": "object", "additionalProperties": false}
+		g.defs[name] = def
+		props := make(map[string]any)
+		g.properties(t, props)
+		def["properties"] = props
+		return ref
+	}
+	return map[string]any{}
+}
+
+// properties adds properties of the struct to props in the same way as yaml.v2.
+func (g *schemaGenerator) properties(t reflect.Type, props map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if strings.Contains(opts, "inline") {
+			g.properties(field.Type, props)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		schema := g.schemaOf(field.Type)
+		if field.Tag.Get("schema") == "deprecated" {
+			schema = map[string]any{"allOf": []any{schema}, "deprecated": true}
+		}
+		props[name] = schema
+	}
+}
+
+// validateConfigSchema validates the config by the JSON Schema of the config file.
+// All errors are reported with their lines and fields.
+func validateConfigSchema(rendered []byte) error {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(rendered, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	schema := configSchema()
+	v := &schemaValidator{defs: schema["$defs"].(map[string]any)}
+	v.validate(doc.Content[0], schema, "")
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("schema errors:\n  %s", strings.Join(v.errs, "\n  "))
+}
+
+// schemaValidator validates YAML nodes by the subset of JSON Schema generated by schemaGenerator.
+type schemaValidator struct {
+	defs map[string]any
+	errs []string
+}
+
+func (v *schemaValidator) errorf(n *yamlv3.Node, format string, args ...any) {
+	v.errs = append(v.errs, fmt.Sprintf("line %d: ", n.Line)+fmt.Sprintf(format, args...))
+}
+
+func (v *schemaValidator) validate(n *yamlv3.Node, schema map[string]any, path string) {
+	if n.Kind == yamlv3.AliasNode {
+		n = n.Alias
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		schema = v.defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]any)
+	}
+	if all, ok := schema["allOf"].([]any); ok {
+		for _, s := range all {
+			v.validate(n, s.(map[string]any), path)
+		}
+		return
+	}
+	if n.Tag == "!!null" {
+		// decoded as the zero value
+		return
+	}
+	var types []string
+	switch t := schema["type"].(type) {
+	case string:
+		types = []string{t}
+	case []string:
+		types = t
+	}
+	if len(types) > 0 && !lo.ContainsBy(types, func(t string) bool { return schemaTypeMatches(n, t) }) {
+		v.errorf(n, "%s must be %s, not %s", schemaPath(path), strings.Join(types, " or "), nodeType(n))
+		return
+	}
+	switch n.Kind {
+	case yamlv3.MappingNode:
+		v.validateMapping(n, schema, path)
+	case yamlv3.SequenceNode:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range n.Content {
+				v.validate(item, items, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	}
+}
+
+func (v *schemaValidator) validateMapping(n *yamlv3.Node, schema map[string]any, path string) {
+	props, _ := schema["properties"].(map[string]any)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		if key.Value == "<<" && key.Tag == "!!merge" {
+			// merged mappings are validated as a part of this mapping
+			merged := []*yamlv3.Node{value}
+			if value.Kind == yamlv3.SequenceNode {
+				merged = value.Content
+			}
+			for _, m := range merged {
+				v.validate(m, schema, path)
+			}
+			continue
+		}
+		name := key.Value
+		if path != "" {
+			name = path + "." + key.Value
+		}
+		if p, ok := props[key.Value].(map[string]any); ok {
+			v.validate(value, p, name)
+			continue
+		}
+		switch ap := schema["additionalProperties"].(type) {
+		case bool:
+			if !ap {
+				v.errorf(key, "unknown field %s", name)
+			}
+		case map[string]any:
+			v.validate(value, ap, name)
+		}
+	}
+}
+
+// schemaTypeMatches reports whether the node is decoded as the type by yaml.v2.
+func schemaTypeMatches(n *yamlv3.Node, typ string) bool {
+	switch typ {
+	case "object":
+		return n.Kind == yamlv3.MappingNode
+	case "array":
+		return n.Kind == yamlv3.SequenceNode
+	}
+	if n.Kind != yamlv3.ScalarNode {
+		return false
+	}
+	switch typ {
+	case "string":
+		return n.Tag == "!!str"
+	case "boolean":
+		// yaml.v2 decodes booleans of YAML 1.1 (e.g. yes, off)
+		return n.Tag == "!!bool" || n.Tag == "!!str" && lo.Contains([]string{"y", "yes", "n", "no", "on", "off"}, strings.ToLower(n.Value))
+	case "integer":
+		return n.Tag == "!!int"
+	case "number":
+		return n.Tag == "!!int" || n.Tag == "!!float"
+	}
+	return true
+}
+
+func nodeType(n *yamlv3.Node) string {
+	switch n.Kind {
+	case yamlv3.MappingNode:
+		return "object"
+	case yamlv3.SequenceNode:
+		return "array"
+	}
+	switch n.Tag {
+	case "!!str":
+		return fmt.Sprintf("string %q", n.Value)
+	case "!!bool":
+		return "boolean"
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	}
+	return n.Tag
+}
+
+func schemaPath(path string) string {
+	if path == "" {
+		return "config"
+	}
+	return path
+}
+
+// migrateLegacy accepts the storage section of mirage v1 (htmldir and datadir) with warnings.
+// The storage section is removed unless its file is set.
+func (s *Storage) migrateLegacy(cfg *Config) *Storage {
+	if s == nil {
+		return nil
+	}
+	if s.HtmlDir != "" {
+		slog.Warn("storage.htmldir is deprecated, use htmldir instead")
+		if cfg.HtmlDir == "" {
+			cfg.HtmlDir = s.HtmlDir
+		}
+	}
+	if s.DataDir != "" {
+		slog.Warn("storage.datadir is deprecated and ignored")
+	}
+	if s.File == "" && (s.HtmlDir != "" || s.DataDir != "") {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,101 @@
+package mirageecs_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestStrictConfig(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{"htmldir: ./html\nparamter:\n  - name: foo\n", "line 2: unknown field paramter"},
+		{"network:\n  proxy_timeout: 30s\n  access_log: yes please\n", `line 3: network.access_log must be boolean, not string "yes please"`},
+		{"parameters:\n  - name: foo\n    requird: true\n", "line 3: unknown field parameters[0].requird"},
+		{"parameters:\n  - name: foo\n    min: one\n", `line 3: parameters[0].min must be integer, not string "one"`},
+		{"ecs:\n  default_task_definition: [app]\n", "line 2: ecs.default_task_definition must be string, not array"},
+		{"ecs:\n  cluster: mirage\n  cluster: mirage2\n", "already set"},
+	}
+	for _, c := range cases {
+		_, err := newConfigFromYAML(t, c.src)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%q: unexpected error: %v", c.src, err)
+		}
+	}
+}
+
+func TestConfigSchemaErrors(t *testing.T) {
+	_, err := newConfigFromYAML(t, "htmldir: ./html\nnetwork:\n  proxy_timout: 30s\nparamter: []\n")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, want := range []string{"line 3: unknown field network.proxy_timout", "line 4: unknown field paramter"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("all errors must be reported: %s", err)
+		}
+	}
+
+	// YAML 1.1 booleans, durations in nanoseconds and anchors are valid as yaml.v2 decodes them
+	cfg, err := newConfigFromYAML(t, "htmldir: ./html\nnetwork:\n  access_log: yes\n  proxy_timeout: 30000000000\nparameters:\n  - name: branch\n    env: &env GIT_BRANCH\n  - name: nick\n    env: *env\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Network.AccessLog || cfg.Network.ProxyTimeout != 30*time.Second {
+		t.Errorf("unexpected network: %#v", cfg.Network)
+	}
+}
+
+func TestLegacyStorageConfig(t *testing.T) {
+	cfg, err := newConfigFromYAML(t, "storage:\n  datadir: ./data\n  htmldir: ./html\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HtmlDir != "./html" {
+		t.Errorf("storage.htmldir must be used as htmldir: %s", cfg.HtmlDir)
+	}
+	if cfg.Storage != nil {
+		t.Errorf("the storage of mirage v1 must not open the storage file: %#v", cfg.Storage)
+	}
+}
+
+func TestConfigSchema(t *testing.T) {
+	b, err := mirageecs.ConfigSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Ref  string `json:"$ref"`
+		Defs map[string]struct {
+			AdditionalProperties bool                      `json:"additionalProperties"`
+			Properties           map[string]map[string]any `json:"properties"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.Ref != "#/$defs/Config" {
+		t.Errorf("unexpected $ref: %s", schema.Ref)
+	}
+	cfg, ok := schema.Defs["Config"]
+	if !ok || cfg.AdditionalProperties {
+		t.Fatalf("Config must not have additional properties: %s", b)
+	}
+	if _, ok := cfg.Properties["parameters"]; !ok {
+		t.Errorf("Config must have parameters: %v", cfg.Properties)
+	}
+	params := schema.Defs["Parameter"].Properties
+	if _, ok := params["Regexp"]; ok {
+		t.Error("fields without yaml must not be properties")
+	}
+	if params["min"]["type"] != "integer" || params["required"]["type"] != "boolean" {
+		t.Errorf("unexpected properties of parameter: %v", params)
+	}
+	if typ := schema.Defs["ErrorAlert"].Properties["window"]["type"]; len(typ.([]any)) != 2 {
+		t.Errorf("durations must be strings or integers: %v", typ)
+	}
+}
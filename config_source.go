@@ -0,0 +1,108 @@
+package mirageecs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// ErrConfigChanged is returned by Mirage.Run when the config file has been changed, to be restarted with the new config.
+var ErrConfigChanged = errors.New("config has been changed")
+
+// loadConfigSource loads the content of the config file from the local file, S3 (s3://bucket/key) or SSM Parameter Store (ssm:///name).
+func loadConfigSource(ctx context.Context, awscfg *aws.Config, p string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(p, "s3://"):
+		return loadFromS3(ctx, awscfg, p)
+	case strings.HasPrefix(p, "ssm://"):
+		return loadFromSSM(ctx, awscfg, p)
+	default:
+		return loadFromFile(p)
+	}
+}
+
+// ssmParameterName returns the name of the parameter by the URL. e.g. ssm:///mirage/config -> /mirage/config, ssm://mirage-config -> mirage-config
+func ssmParameterName(u string) (string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Scheme != "ssm" {
+		return "", fmt.Errorf("invalid scheme: %s", parsed.Scheme)
+	}
+	name := parsed.Host + parsed.Path
+	if name == "" || name == "/" {
+		return "", fmt.Errorf("parameter name is required: %s", u)
+	}
+	return name, nil
+}
+
+// loadFromSSM loads the value of the parameter. SecureString parameters are decrypted.
+func loadFromSSM(ctx context.Context, awscfg *aws.Config, u string) ([]byte, error) {
+	name, err := ssmParameterName(u)
+	if err != nil {
+		return nil, err
+	}
+	svc := ssm.NewFromConfig(*awscfg)
+	out, err := svc.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(aws.ToString(out.Parameter.Value)), nil
+}
+
+// checkConfigSource reports whether the config file (or included files, values of environment variables and SSM parameters in them) has been changed from the loaded content.
+// Changed contents which are not valid YAML are ignored, not to restart with them.
+func (c *Config) checkConfigSource(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, APICallTimeout)
+	defer cancel()
+	rendered, err := loadConfigDocument(ctx, c.awscfg, c.params.Path)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(rendered, c.source) {
+		return false, nil
+	}
+	var parsed Config
+	if err := unmarshalConfig(&parsed, rendered, c.params.Profile); err != nil {
+		return false, fmt.Errorf("changed config is invalid: %w", err)
+	}
+	return true, nil
+}
+
+// RunConfigWatcher checks the config file periodically, and returns true when it has been changed.
+func (m *Mirage) RunConfigWatcher(ctx context.Context, wg *sync.WaitGroup) bool {
+	defer wg.Done()
+	cfg := m.Config
+	tk := time.NewTicker(cfg.params.ReloadInterval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			slog.Debug("RunConfigWatcher() is done")
+			return false
+		}
+		changed, err := cfg.checkConfigSource(ctx)
+		if err != nil {
+			slog.Warn(f("failed to check config %s: %s", cfg.params.Path, err))
+			continue
+		}
+		if changed {
+			slog.Info(f("config %s has been changed, restarting", cfg.params.Path))
+			return true
+		}
+	}
+}
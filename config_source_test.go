@@ -0,0 +1,75 @@
+package mirageecs_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestSSMParameterName(t *testing.T) {
+	cases := []struct {
+		url  string
+		name string
+		ok   bool
+	}{
+		{"ssm:///mirage/config", "/mirage/config", true},
+		{"ssm://mirage-config", "mirage-config", true},
+		{"ssm:///", "", false},
+		{"s3://bucket/key", "", false},
+	}
+	for _, c := range cases {
+		name, err := mirageecs.SSMParameterName(c.url)
+		if c.ok && (err != nil || name != c.name) {
+			t.Errorf("%s: unexpected name %q %v", c.url, name, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%s: expected error", c.url)
+		}
+	}
+}
+
+func TestCheckConfigSource(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(p, []byte("htmldir: ./html\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{Path: p, LocalMode: true, ReloadInterval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := cfg.CheckConfigSource(ctx); changed || err != nil {
+		t.Errorf("config must not be changed: %v %v", changed, err)
+	}
+	// invalid configs are ignored
+	if err := os.WriteFile(p, []byte("htmldir: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := cfg.CheckConfigSource(ctx); changed || err == nil {
+		t.Errorf("invalid config must be reported: %v %v", changed, err)
+	}
+	if err := os.WriteFile(p, []byte("htmldir: ./html\nnetwork:\n  access_log: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := cfg.CheckConfigSource(ctx); !changed || err != nil {
+		t.Errorf("config must be changed: %v %v", changed, err)
+	}
+}
+
+func TestReloadIntervalValidate(t *testing.T) {
+	ctx := context.Background()
+	if _, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true, ReloadInterval: time.Minute}); err == nil {
+		t.Error("reload interval without a config file must fail")
+	}
+	p := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(p, []byte("htmldir: ./html\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{Path: p, LocalMode: true, ReloadInterval: time.Second}); err == nil {
+		t.Error("too short reload interval must fail")
+	}
+}
@@ -0,0 +1,92 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	config "github.com/kayac/go-config"
+)
+
+// envVarPattern matches ${NAME}, ${NAME:-default} and the escaped $${.
+var envVarPattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// expandEnvVars replaces ${NAME} with the value of the environment variable, and ${NAME:-default} with the default value when it is empty or not defined.
+// $${ is replaced with ${. Undefined variables without default values are errors, not to launch environments with broken configs.
+func expandEnvVars(content []byte) ([]byte, error) {
+	var undefined []string
+	expanded := envVarPattern.ReplaceAllFunc(content, func(m []byte) []byte {
+		if string(m) == "$${" {
+			return []byte("${")
+		}
+		sub := envVarPattern.FindSubmatch(m)
+		name, hasDefault := string(sub[1]), strings.Contains(string(m), ":-")
+		if v := os.Getenv(name); v != "" {
+			return []byte(v)
+		}
+		if hasDefault {
+			return sub[2]
+		}
+		if _, ok := os.LookupEnv(name); !ok {
+			undefined = append(undefined, name)
+		}
+		return nil
+	})
+	if len(undefined) > 0 {
+		return nil, fmt.Errorf("environment variables are not defined: %s", strings.Join(undefined, ", "))
+	}
+	return expanded, nil
+}
+
+// renderConfig expands environment variables and template functions (env, must_env, json_escape and ssm) in the content of the config file.
+// lookup looks up the value of the SSM parameter by the name.
+func renderConfig(content []byte, lookup func(name string) (string, error)) (rendered []byte, err error) {
+	expanded, err := expandEnvVars(content)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	loader := config.New()
+	loader.Funcs(template.FuncMap{
+		"ssm": func(name string) (string, error) {
+			if v, ok := values[name]; ok {
+				return v, nil
+			}
+			v, err := lookup(name)
+			if err != nil {
+				return "", fmt.Errorf("ssm: failed to get parameter %s: %w", name, err)
+			}
+			values[name] = v
+			return v, nil
+		},
+	})
+	// go-config panics on undefined variables of must_env
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return loader.ReadWithEnvBytes(expanded)
+}
+
+// ssmParameterLookup returns a function to look up values of SSM parameters. SecureString parameters are decrypted.
+func ssmParameterLookup(ctx context.Context, awscfg *aws.Config) func(name string) (string, error) {
+	return func(name string) (string, error) {
+		ctx, cancel := context.WithTimeout(ctx, APICallTimeout)
+		defer cancel()
+		svc := ssm.NewFromConfig(*awscfg)
+		out, err := svc.GetParameter(ctx, &ssm.GetParameterInput{
+			Name:           aws.String(name),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return "", err
+		}
+		return aws.ToString(out.Parameter.Value), nil
+	}
+}
@@ -0,0 +1,92 @@
+package mirageecs_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestExpandEnvVars(t *testing.T) {
+	t.Setenv("MIRAGE_TEST_CLUSTER", "staging")
+	t.Setenv("MIRAGE_TEST_EMPTY", "")
+	cases := []struct {
+		src string
+		out string
+		ok  bool
+	}{
+		{"cluster: ${MIRAGE_TEST_CLUSTER}", "cluster: staging", true},
+		{"cluster: ${MIRAGE_TEST_UNDEFINED:-default}", "cluster: default", true},
+		{"cluster: ${MIRAGE_TEST_EMPTY:-default}", "cluster: default", true},
+		{"cluster: ${MIRAGE_TEST_CLUSTER:-default}", "cluster: staging", true},
+		{"cluster: ${MIRAGE_TEST_EMPTY}", "cluster: ", true},
+		{"cluster: ${MIRAGE_TEST_UNDEFINED:-}", "cluster: ", true},
+		{"rule: '^[a-z]+$'", "rule: '^[a-z]+$'", true},
+		{"value: $${MIRAGE_TEST_CLUSTER}", "value: ${MIRAGE_TEST_CLUSTER}", true},
+		{"cluster: ${MIRAGE_TEST_UNDEFINED}", "", false},
+	}
+	for _, c := range cases {
+		out, err := mirageecs.ExpandEnvVars([]byte(c.src))
+		if c.ok && (err != nil || string(out) != c.out) {
+			t.Errorf("%s: unexpected %q %v", c.src, out, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%s: expected error", c.src)
+		}
+	}
+}
+
+func TestRenderConfig(t *testing.T) {
+	t.Setenv("MIRAGE_TEST_ENV", "prod")
+	calls := 0
+	lookup := func(name string) (string, error) {
+		calls++
+		switch name {
+		case "/mirage/prod/subnet":
+			return "subnet-12345", nil
+		default:
+			return "", errors.New("ParameterNotFound")
+		}
+	}
+	src := `subnet: {{ ssm "/mirage/${MIRAGE_TEST_ENV}/subnet" }}
+subnets: [{{ ssm "/mirage/prod/subnet" }}]
+env: {{ must_env "MIRAGE_TEST_ENV" }}
+`
+	out, err := mirageecs.RenderConfig([]byte(src), lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(out); s != "subnet: subnet-12345\nsubnets: [subnet-12345]\nenv: prod\n" {
+		t.Errorf("unexpected rendered config: %q", s)
+	}
+	if calls != 1 {
+		t.Errorf("parameters must be looked up once: %d", calls)
+	}
+
+	if _, err := mirageecs.RenderConfig([]byte(`subnet: {{ ssm "/mirage/none" }}`), lookup); err == nil || !strings.Contains(err.Error(), "/mirage/none") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := mirageecs.RenderConfig([]byte(`env: {{ must_env "MIRAGE_TEST_UNDEFINED" }}`), lookup); err == nil {
+		t.Error("undefined must_env must fail")
+	}
+}
+
+func TestNewConfigWithEnvVars(t *testing.T) {
+	t.Setenv("MIRAGE_TEST_CLUSTER", "mirage-dev")
+	p := filepath.Join(t.TempDir(), "config.yml")
+	src := "ecs:\n  cluster: ${MIRAGE_TEST_CLUSTER}\nhtmldir: ${MIRAGE_TEST_HTMLDIR:-./html}\n"
+	if err := os.WriteFile(p, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := mirageecs.NewConfig(context.Background(), &mirageecs.ConfigParams{Path: p, LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ECS.Cluster != "mirage-dev" || cfg.HtmlDir != "./html" {
+		t.Errorf("unexpected config: %s %s", cfg.ECS.Cluster, cfg.HtmlDir)
+	}
+}
@@ -47,9 +47,7 @@ ecs:
         - sg-gggg
       assign_public_ip: ENABLED
 
-storage:
-  datadir: ./data
-  htmldir: ./html
+htmldir: ./html
 parameters:
   - name: branch
     env: GIT_BRANCH
@@ -127,3 +125,24 @@ link:
 		t.Error("could not parse link default task definitions")
 	}
 }
+
+func TestReservedParameterName(t *testing.T) {
+	for _, name := range []string{"compression", "cpu", "image_tag", "protected"} {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.CreateTemp("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			data := "parameters:\n  - name: " + name + "\n    env: FOO\n"
+			if _, err := f.WriteString(data); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+			_, err = mirageecs.NewConfig(context.Background(), &mirageecs.ConfigParams{Path: f.Name(), LocalMode: true})
+			if err == nil {
+				t.Errorf("parameter %s should be rejected", name)
+			}
+		})
+	}
+}
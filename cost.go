@@ -0,0 +1,157 @@
+package mirageecs
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Default prices of Fargate (Linux/x86) in us-east-1.
+const (
+	DefaultCostVCPUPerHour  = 0.04048
+	DefaultCostGBPerHour    = 0.004445
+	DefaultCostSpotDiscount = 0.7
+	DefaultCostCurrency     = "USD"
+)
+
+// Cost estimates running costs of environments by vCPU and memory of tasks and their uptime.
+type Cost struct {
+	// VCPUPerHour is a price of 1 vCPU per hour.
+	VCPUPerHour float64 `yaml:"vcpu_per_hour"`
+	// GBPerHour is a price of 1 GB of memory per hour.
+	GBPerHour float64 `yaml:"gb_per_hour"`
+	// SpotDiscount is a discount rate of Fargate Spot. e.g. 0.7 means 70% off.
+	SpotDiscount float64 `yaml:"spot_discount"`
+	// Currency is a currency of the prices. It is only displayed.
+	Currency string `yaml:"currency"`
+}
+
+func (c *Cost) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.VCPUPerHour == 0 {
+		c.VCPUPerHour = DefaultCostVCPUPerHour
+	}
+	if c.GBPerHour == 0 {
+		c.GBPerHour = DefaultCostGBPerHour
+	}
+	if c.SpotDiscount == 0 {
+		c.SpotDiscount = DefaultCostSpotDiscount
+	}
+	if c.Currency == "" {
+		c.Currency = DefaultCostCurrency
+	}
+	if c.VCPUPerHour < 0 || c.GBPerHour < 0 {
+		return fmt.Errorf("cost.vcpu_per_hour and cost.gb_per_hour must not be negative")
+	}
+	if c.SpotDiscount < 0 || c.SpotDiscount >= 1 {
+		return fmt.Errorf("cost.spot_discount must be in [0, 1): %f", c.SpotDiscount)
+	}
+	return nil
+}
+
+// hourly returns the price of the task per hour.
+func (c *Cost) hourly(info *Information) float64 {
+	p := float64(info.Cpu)/1024*c.VCPUPerHour + float64(info.Memory)/1024*c.GBPerHour
+	if info.Spot {
+		p *= 1 - c.SpotDiscount
+	}
+	return p
+}
+
+// estimate sets estimated costs of tasks from their start until now (or their stop).
+func (c *Cost) estimate(infos []*Information, now time.Time) {
+	if c == nil {
+		return
+	}
+	for _, info := range infos {
+		if info.Created.IsZero() || info.Cpu == 0 && info.Memory == 0 {
+			continue
+		}
+		end := now
+		if info.StoppedAt != nil {
+			end = *info.StoppedAt
+		} else if info.LastStatus != statusRunning {
+			continue
+		}
+		hourly := c.hourly(info)
+		info.HourlyCost = roundCost(hourly)
+		info.EstimatedCost = roundCost(hourly * end.Sub(info.Created).Hours())
+	}
+}
+
+func roundCost(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// EnvironmentCost is an estimated cost of the environment.
+type EnvironmentCost struct {
+	Subdomain     string  `json:"subdomain"`
+	Tasks         int     `json:"tasks"`
+	HourlyCost    float64 `json:"hourly_cost"`
+	EstimatedCost float64 `json:"estimated_cost"`
+}
+
+// environmentCosts sums estimated costs of tasks by subdomains in descending order of the cost.
+func environmentCosts(infos []*Information) []*EnvironmentCost {
+	m := make(map[string]*EnvironmentCost)
+	for _, info := range infos {
+		ec, ok := m[info.SubDomain]
+		if !ok {
+			ec = &EnvironmentCost{Subdomain: info.SubDomain}
+			m[info.SubDomain] = ec
+		}
+		ec.Tasks++
+		ec.HourlyCost = roundCost(ec.HourlyCost + info.HourlyCost)
+		ec.EstimatedCost = roundCost(ec.EstimatedCost + info.EstimatedCost)
+	}
+	costs := make([]*EnvironmentCost, 0, len(m))
+	for _, ec := range m {
+		costs = append(costs, ec)
+	}
+	sort.Slice(costs, func(i, j int) bool {
+		if costs[i].EstimatedCost != costs[j].EstimatedCost {
+			return costs[i].EstimatedCost > costs[j].EstimatedCost
+		}
+		return costs[i].Subdomain < costs[j].Subdomain
+	})
+	return costs
+}
+
+// parseTaskSize parses cpu units or memory (MiB) of the task. Tasks of EC2 may not have them.
+func parseTaskSize(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// ApiCost returns estimated costs of running environments.
+func (api *WebApi) ApiCost(c echo.Context) error {
+	cost := api.cfg.ECS.Cost
+	if cost == nil {
+		return c.JSON(http.StatusNotFound, APICommonResponse{Result: "ecs.cost is not configured"})
+	}
+	infos, err := api.runner.List(c.Request().Context(), statusRunning)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, APICommonResponse{Result: err.Error()})
+	}
+	if subdomain := c.QueryParam("subdomain"); subdomain != "" {
+		var filtered []*Information
+		for _, info := range infos {
+			if info.SubDomain == subdomain {
+				filtered = append(filtered, info)
+			}
+		}
+		infos = filtered
+	}
+	cost.estimate(infos, time.Now())
+	return c.JSON(http.StatusOK, APICostResponse{
+		Result:   environmentCosts(infos),
+		Currency: cost.Currency,
+	})
+}
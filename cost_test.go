@@ -0,0 +1,63 @@
+package mirageecs_test
+
+import (
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCostValidate(t *testing.T) {
+	c := &mirageecs.Cost{}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.VCPUPerHour != mirageecs.DefaultCostVCPUPerHour || c.GBPerHour != mirageecs.DefaultCostGBPerHour ||
+		c.SpotDiscount != mirageecs.DefaultCostSpotDiscount || c.Currency != mirageecs.DefaultCostCurrency {
+		t.Errorf("unexpected defaults: %#v", c)
+	}
+	if err := (&mirageecs.Cost{SpotDiscount: 1}).Validate(); err == nil {
+		t.Error("expected error for spot_discount 1")
+	}
+	if err := (&mirageecs.Cost{VCPUPerHour: -1}).Validate(); err == nil {
+		t.Error("expected error for negative price")
+	}
+}
+
+func TestCostEstimate(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	stoppedAt := now.Add(-time.Hour)
+	c := &mirageecs.Cost{VCPUPerHour: 0.04, GBPerHour: 0.004, SpotDiscount: 0.5}
+	infos := []*mirageecs.Information{
+		// 1 vCPU, 2 GB for 10 hours
+		{SubDomain: "foo", LastStatus: "RUNNING", Cpu: 1024, Memory: 2048, Created: now.Add(-10 * time.Hour)},
+		// spot 0.5 vCPU, 1 GB for 4 hours
+		{SubDomain: "foo", LastStatus: "RUNNING", Cpu: 512, Memory: 1024, Spot: true, Created: now.Add(-4 * time.Hour)},
+		// stopped after 2 hours
+		{SubDomain: "bar", LastStatus: "STOPPED", Cpu: 1024, Memory: 2048, Created: stoppedAt.Add(-2 * time.Hour), StoppedAt: &stoppedAt},
+		// sleeping
+		{SubDomain: "baz", LastStatus: "SLEEPING", Cpu: 1024, Memory: 2048},
+	}
+	c.Estimate(infos, now)
+	for i, expected := range []struct{ hourly, total float64 }{
+		{0.048, 0.48},
+		{0.012, 0.048},
+		{0.048, 0.096},
+		{0, 0},
+	} {
+		if infos[i].HourlyCost != expected.hourly || infos[i].EstimatedCost != expected.total {
+			t.Errorf("unexpected cost of %d: %f %f", i, infos[i].HourlyCost, infos[i].EstimatedCost)
+		}
+	}
+
+	costs := mirageecs.EnvironmentCosts(infos)
+	expected := []*mirageecs.EnvironmentCost{
+		{Subdomain: "foo", Tasks: 2, HourlyCost: 0.06, EstimatedCost: 0.528},
+		{Subdomain: "bar", Tasks: 1, HourlyCost: 0.048, EstimatedCost: 0.096},
+		{Subdomain: "baz", Tasks: 1},
+	}
+	if diff := cmp.Diff(expected, costs); diff != "" {
+		t.Errorf("unexpected costs (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,326 @@
+package mirageecs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cw "github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+)
+
+const (
+	DefaultDashboardNamePrefix   = "mirage-"
+	DefaultDashboardErrorPattern = "ERROR"
+
+	// maxDashboardNameLength is the maximum length of names of CloudWatch dashboards.
+	maxDashboardNameLength = 255
+	// dashboardErrorsLimit is the number of log events in the errors widget.
+	dashboardErrorsLimit = 100
+)
+
+// validDashboardName matches characters which are allowed in names of CloudWatch dashboards.
+var validDashboardName = regexp.MustCompile(`^[A-Za-z0-9_-]*$`)
+
+// Dashboard creates a CloudWatch dashboard of CPU and memory utilization and error logs per environment,
+// and deletes it when the environment is terminated.
+// CPU and memory widgets show task level metrics of Container Insights with enhanced observability.
+type Dashboard struct {
+	// NamePrefix is a prefix of names of dashboards. Default is "mirage-".
+	NamePrefix string `yaml:"name_prefix"`
+	// ErrorPattern is a regular expression of error logs. Default is "ERROR".
+	ErrorPattern string `yaml:"error_pattern"`
+}
+
+func (d *Dashboard) validate() error {
+	if d == nil {
+		return nil
+	}
+	if d.NamePrefix == "" {
+		d.NamePrefix = DefaultDashboardNamePrefix
+	}
+	if !validDashboardName.MatchString(d.NamePrefix) {
+		return fmt.Errorf("ecs.dashboard.name_prefix must consist of alphanumerics, hyphens and underscores: %s", d.NamePrefix)
+	}
+	if len(d.NamePrefix) > maxDashboardNameLength-64 {
+		return fmt.Errorf("ecs.dashboard.name_prefix is too long: %s", d.NamePrefix)
+	}
+	if d.ErrorPattern == "" {
+		d.ErrorPattern = DefaultDashboardErrorPattern
+	}
+	if strings.Contains(d.ErrorPattern, "/") {
+		// the pattern is embedded in a Logs Insights query as /pattern/
+		return fmt.Errorf("ecs.dashboard.error_pattern must not contain '/': %s", d.ErrorPattern)
+	}
+	if _, err := regexp.Compile(d.ErrorPattern); err != nil {
+		return fmt.Errorf("invalid ecs.dashboard.error_pattern: %w", err)
+	}
+	return nil
+}
+
+// name returns the name of the dashboard of the subdomain. Characters which are not allowed are replaced with "_".
+func (d *Dashboard) name(subdomain string) string {
+	name := d.NamePrefix + strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || ('0' <= r && r <= '9') || ('A' <= r && r <= 'Z') || ('a' <= r && r <= 'z') {
+			return r
+		}
+		return '_'
+	}, subdomain)
+	if len(name) > maxDashboardNameLength {
+		name = name[:maxDashboardNameLength]
+	}
+	return name
+}
+
+// dashboardURL returns the link to the dashboard of the subdomain on the AWS console.
+func (d *Dashboard) dashboardURL(region, subdomain string) string {
+	if d == nil {
+		return ""
+	}
+	return fmt.Sprintf("https://%s.console.aws.amazon.com/cloudwatch/home?region=%s#dashboards/dashboard/%s",
+		region, url.QueryEscape(region), d.name(subdomain))
+}
+
+// dashboardTask is a task shown in the dashboard.
+type dashboardTask struct {
+	id      string
+	family  string
+	streams []logStream
+}
+
+// body returns the JSON of the dashboard of the tasks.
+func (d *Dashboard) body(region, cluster, subdomain string, tasks []dashboardTask) (string, error) {
+	type widget struct {
+		Type       string         `json:"type"`
+		X          int            `json:"x"`
+		Y          int            `json:"y"`
+		Width      int            `json:"width"`
+		Height     int            `json:"height"`
+		Properties map[string]any `json:"properties"`
+	}
+	utilization := func(title, utilized, reserved string) map[string]any {
+		var metrics []any
+		for i, t := range tasks {
+			u, r := fmt.Sprintf("u%d", i), fmt.Sprintf("r%d", i)
+			metrics = append(metrics,
+				[]any{map[string]any{"expression": fmt.Sprintf("100*%s/%s", u, r), "label": t.id, "id": fmt.Sprintf("e%d", i)}},
+			)
+			for _, m := range [][2]string{{utilized, u}, {reserved, r}} {
+				metrics = append(metrics, []any{
+					containerInsightsNamespace, m[0],
+					"ClusterName", cluster, "TaskDefinitionFamily", t.family, "TaskId", t.id,
+					map[string]any{"id": m[1], "visible": false},
+				})
+			}
+		}
+		return map[string]any{
+			"title":   fmt.Sprintf("%s %s (%%)", subdomain, title),
+			"region":  region,
+			"view":    "timeSeries",
+			"stat":    "Average",
+			"period":  60,
+			"metrics": metrics,
+			"yAxis":   map[string]any{"left": map[string]any{"min": 0, "max": 100}},
+		}
+	}
+	widgets := []widget{
+		{Type: "metric", X: 0, Y: 0, Width: 12, Height: 6, Properties: utilization("CPU utilization", "CpuUtilized", "CpuReserved")},
+		{Type: "metric", X: 12, Y: 0, Width: 12, Height: 6, Properties: utilization("memory utilization", "MemoryUtilized", "MemoryReserved")},
+	}
+	if query := d.errorsQuery(tasks); query != "" {
+		widgets = append(widgets, widget{Type: "log", X: 0, Y: 6, Width: 24, Height: 8, Properties: map[string]any{
+			"title":  fmt.Sprintf("%s errors", subdomain),
+			"region": region,
+			"view":   "table",
+			"query":  query,
+		}})
+	}
+	b, err := json.Marshal(map[string]any{"widgets": widgets})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// errorsQuery returns the Logs Insights query of error logs of the tasks. It is empty when the tasks have no log streams.
+func (d *Dashboard) errorsQuery(tasks []dashboardTask) string {
+	groups := make(map[string]struct{})
+	var streams []string
+	for _, t := range tasks {
+		for _, s := range t.streams {
+			groups[s.group] = struct{}{}
+			streams = append(streams, s.name)
+		}
+	}
+	if len(streams) == 0 {
+		return ""
+	}
+	sortedGroups := lo.Keys(groups)
+	sort.Strings(sortedGroups)
+	sort.Strings(streams)
+	sources := lo.Map(sortedGroups, func(g string, _ int) string {
+		return fmt.Sprintf("SOURCE '%s' | ", g)
+	})
+	query := fmt.Sprintf("fields @timestamp, @logStream, @message\n| filter @message like /%s/\n| sort @timestamp desc\n| limit %d",
+		d.ErrorPattern, dashboardErrorsLimit)
+	return strings.Join(sources, "") + scopedLogsQuery(streams, query)
+}
+
+// DashboardManager creates and deletes CloudWatch dashboards of environments by ecs.dashboard.
+type DashboardManager struct {
+	cfg     *Dashboard
+	region  string
+	cluster string
+	cwSvc   *cw.Client
+	ecsSvc  *ecs.Client
+
+	mu         sync.Mutex
+	synced     bool
+	dashboards map[string]string                // subdomain -> task IDs shown in the dashboard
+	taskdefs   map[string]*types.TaskDefinition // task definition ARN -> task definition
+}
+
+func NewDashboardManager(cfg *Config) *DashboardManager {
+	if cfg.ECS.Dashboard == nil || cfg.localMode {
+		return nil
+	}
+	return &DashboardManager{
+		cfg:        cfg.ECS.Dashboard,
+		region:     cfg.ECS.Region,
+		cluster:    cfg.ECS.Cluster,
+		cwSvc:      cw.NewFromConfig(*cfg.awscfg),
+		ecsSvc:     ecs.NewFromConfig(*cfg.awscfg),
+		dashboards: make(map[string]string),
+		taskdefs:   make(map[string]*types.TaskDefinition),
+	}
+}
+
+// Sync puts dashboards of running environments whose tasks have changed,
+// and deletes dashboards of environments which are neither running nor kept (e.g. sleeping).
+func (m *DashboardManager) Sync(ctx context.Context, running []*Information, keep map[string]bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.synced {
+		// dashboards created before restart are deleted if their environments are gone
+		if err := m.loadDashboards(ctx); err != nil {
+			slog.Warn(f("failed to list dashboards: %s", err))
+			return
+		}
+		m.synced = true
+	}
+
+	tasksBySubdomain := make(map[string][]*Information)
+	for _, info := range running {
+		tasksBySubdomain[info.SubDomain] = append(tasksBySubdomain[info.SubDomain], info)
+	}
+	for subdomain, infos := range tasksBySubdomain {
+		sort.Slice(infos, func(i, j int) bool { return infos[i].ShortID < infos[j].ShortID })
+		signature := strings.Join(lo.Map(infos, func(info *Information, _ int) string { return info.ShortID }), ",")
+		if m.dashboards[subdomain] == signature {
+			continue
+		}
+		if err := m.put(ctx, subdomain, infos); err != nil {
+			slog.Warn(f("failed to put the dashboard of %s: %s", subdomain, err))
+			continue
+		}
+		m.dashboards[subdomain] = signature
+	}
+
+	var names, deleted []string
+	for subdomain := range m.dashboards {
+		if _, ok := tasksBySubdomain[subdomain]; ok || keep[subdomain] {
+			continue
+		}
+		names = append(names, m.cfg.name(subdomain))
+		deleted = append(deleted, subdomain)
+	}
+	if len(names) == 0 {
+		return
+	}
+	if _, err := m.cwSvc.DeleteDashboards(ctx, &cw.DeleteDashboardsInput{DashboardNames: names}); err != nil {
+		slog.Warn(f("failed to delete dashboards %v: %s", names, err))
+		return
+	}
+	slog.Info(f("deleted dashboards %v", names))
+	for _, subdomain := range deleted {
+		delete(m.dashboards, subdomain)
+	}
+}
+
+// loadDashboards loads existing dashboards by the prefix. They are put again on the next sync.
+func (m *DashboardManager) loadDashboards(ctx context.Context) error {
+	p := cw.NewListDashboardsPaginator(m.cwSvc, &cw.ListDashboardsInput{
+		DashboardNamePrefix: aws.String(m.cfg.NamePrefix),
+	})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, d := range out.DashboardEntries {
+			subdomain := strings.TrimPrefix(aws.ToString(d.DashboardName), m.cfg.NamePrefix)
+			m.dashboards[subdomain] = ""
+		}
+	}
+	return nil
+}
+
+func (m *DashboardManager) put(ctx context.Context, subdomain string, infos []*Information) error {
+	tasks := make([]dashboardTask, 0, len(infos))
+	for _, info := range infos {
+		t := dashboardTask{id: info.ShortID}
+		t.family, _, _ = strings.Cut(info.TaskDef, ":")
+		if info.task != nil {
+			td, err := m.taskDefinitionOf(ctx, aws.ToString(info.task.TaskDefinitionArn))
+			if err != nil {
+				return err
+			}
+			t.streams = logStreamsOf(ctx, td, info.ShortID, "")
+		}
+		tasks = append(tasks, t)
+	}
+	body, err := m.cfg.body(m.region, m.cluster, subdomain, tasks)
+	if err != nil {
+		return err
+	}
+	name := m.cfg.name(subdomain)
+	out, err := m.cwSvc.PutDashboard(ctx, &cw.PutDashboardInput{
+		DashboardName: aws.String(name),
+		DashboardBody: aws.String(body),
+	})
+	if err != nil {
+		return err
+	}
+	for _, msg := range out.DashboardValidationMessages {
+		slog.Warn(f("dashboard %s: %s %s", name, aws.ToString(msg.DataPath), aws.ToString(msg.Message)))
+	}
+	slog.Info(f("put dashboard %s", name))
+	return nil
+}
+
+// taskDefinitionOf returns the task definition of the ARN. Revisions of task definitions are immutable, so they are cached forever.
+func (m *DashboardManager) taskDefinitionOf(ctx context.Context, arn string) (*types.TaskDefinition, error) {
+	if td, ok := m.taskdefs[arn]; ok {
+		return td, nil
+	}
+	out, err := m.ecsSvc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(arn),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe task definition %s: %w", arn, err)
+	}
+	m.taskdefs[arn] = out.TaskDefinition
+	return out.TaskDefinition, nil
+}
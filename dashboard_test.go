@@ -0,0 +1,129 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestDashboardValidate(t *testing.T) {
+	d := &mirageecs.Dashboard{}
+	if err := d.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if d.NamePrefix != mirageecs.DefaultDashboardNamePrefix || d.ErrorPattern != mirageecs.DefaultDashboardErrorPattern {
+		t.Errorf("unexpected defaults: %#v", d)
+	}
+	for _, d := range []*mirageecs.Dashboard{
+		{NamePrefix: "mirage."},
+		{NamePrefix: strings.Repeat("x", 200)},
+		{ErrorPattern: "a/b"},
+		{ErrorPattern: "(ERROR"},
+	} {
+		if err := d.Validate(); err == nil {
+			t.Errorf("invalid dashboard must fail: %#v", d)
+		}
+	}
+}
+
+func TestDashboardName(t *testing.T) {
+	d := &mirageecs.Dashboard{NamePrefix: "dev-"}
+	if name := d.Name("feature-x"); name != "dev-feature-x" {
+		t.Errorf("unexpected name: %s", name)
+	}
+	if name := d.Name("a.b*c"); name != "dev-a_b_c" {
+		t.Errorf("unexpected name: %s", name)
+	}
+	if u := d.DashboardURL("ap-northeast-1", "feature-x"); u != "https://ap-northeast-1.console.aws.amazon.com/cloudwatch/home?region=ap-northeast-1#dashboards/dashboard/dev-feature-x" {
+		t.Errorf("unexpected url: %s", u)
+	}
+	var nilDashboard *mirageecs.Dashboard
+	if u := nilDashboard.DashboardURL("ap-northeast-1", "feature-x"); u != "" {
+		t.Errorf("url must be empty without ecs.dashboard: %s", u)
+	}
+}
+
+func TestDashboardBody(t *testing.T) {
+	d := &mirageecs.Dashboard{}
+	if err := d.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	body, err := d.Body("ap-northeast-1", "default", "feature-x", "aaa", "myapp", "/ecs/myapp", "ecs/app/aaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dashboard struct {
+		Widgets []struct {
+			Type       string         `json:"type"`
+			Properties map[string]any `json:"properties"`
+		} `json:"widgets"`
+	}
+	if err := json.Unmarshal([]byte(body), &dashboard); err != nil {
+		t.Fatal(err)
+	}
+	if len(dashboard.Widgets) != 3 {
+		t.Fatalf("unexpected widgets: %s", body)
+	}
+	cpu := dashboard.Widgets[0].Properties["metrics"].([]any)
+	if len(cpu) != 3 {
+		t.Fatalf("unexpected metrics: %v", cpu)
+	}
+	expr := cpu[0].([]any)[0].(map[string]any)
+	if expr["expression"] != "100*u0/r0" || expr["label"] != "aaa" {
+		t.Errorf("unexpected expression: %v", expr)
+	}
+	metric := cpu[1].([]any)
+	if metric[1] != "CpuUtilized" || metric[7] != "aaa" || metric[5] != "myapp" {
+		t.Errorf("unexpected metric: %v", metric)
+	}
+	query := dashboard.Widgets[2].Properties["query"].(string)
+	for _, s := range []string{"SOURCE '/ecs/myapp' | ", `filter @logStream in ["ecs/app/aaa"]`, "filter @message like /ERROR/"} {
+		if !strings.Contains(query, s) {
+			t.Errorf("query must contain %q: %s", s, query)
+		}
+	}
+
+	body, err = d.Body("ap-northeast-1", "default", "feature-x", "aaa", "myapp", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(body, `"log"`) {
+		t.Errorf("errors widget must be omitted without log streams: %s", body)
+	}
+}
+
+func TestDashboardURLInList(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ECS.Region = "ap-northeast-1"
+	cfg.ECS.Dashboard = &mirageecs.Dashboard{}
+	if err := cfg.ECS.Dashboard.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	if err := m.Runner().Launch(ctx, "feature-x", nil, nil, "dummy"); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+	res, err := http.Get(ts.URL + "/api/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var list mirageecs.APIListResponse
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Result) != 1 || !strings.HasSuffix(list.Result[0].DashboardURL, "#dashboards/dashboard/mirage-feature-x") {
+		t.Errorf("unexpected list: %#v", list.Result)
+	}
+}
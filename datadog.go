@@ -0,0 +1,288 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+const (
+	DefaultDatadogAddress   = "127.0.0.1:8125"
+	DefaultDatadogNamespace = "mirage"
+	DefaultDatadogInterval  = time.Minute
+)
+
+// defaultDatadogTagKeys maps tags of Datadog to tags (parameters) of environments.
+var defaultDatadogTagKeys = map[string]string{
+	"env":     "env",
+	"service": "service",
+}
+
+// Datadog sends metrics and lifecycle events of environments to the Datadog Agent by DogStatsD.
+type Datadog struct {
+	// Address is an address of DogStatsD. Default is DD_AGENT_HOST:DD_DOGSTATSD_PORT or 127.0.0.1:8125.
+	Address string `yaml:"address"`
+	// Namespace is a prefix of metric names. Default is mirage.
+	Namespace string `yaml:"namespace"`
+	// Tags are added to all metrics and events. e.g. team:platform
+	Tags []string `yaml:"tags"`
+	// TagKeys maps tags of Datadog to tags of environments. Default is env: env, service: service.
+	TagKeys map[string]string `yaml:"tag_keys"`
+	// Interval is an interval to send gauges of environments. Default is 1m.
+	Interval time.Duration `yaml:"interval"`
+}
+
+func (d *Datadog) validate() error {
+	if d == nil {
+		return nil
+	}
+	if d.Address == "" {
+		d.Address = DefaultDatadogAddress
+		if host := os.Getenv("DD_AGENT_HOST"); host != "" {
+			port := os.Getenv("DD_DOGSTATSD_PORT")
+			if port == "" {
+				port = "8125"
+			}
+			d.Address = net.JoinHostPort(host, port)
+		}
+	}
+	if _, _, err := net.SplitHostPort(d.Address); err != nil {
+		return fmt.Errorf("invalid datadog.address: %w", err)
+	}
+	if d.Namespace == "" {
+		d.Namespace = DefaultDatadogNamespace
+	}
+	if d.TagKeys == nil {
+		d.TagKeys = defaultDatadogTagKeys
+	}
+	if d.Interval == 0 {
+		d.Interval = DefaultDatadogInterval
+	}
+	if d.Interval < 0 {
+		return fmt.Errorf("datadog.interval must be positive: %s", d.Interval)
+	}
+	return nil
+}
+
+// datadogTagReplacer replaces characters which have meanings in the DogStatsD protocol.
+var datadogTagReplacer = strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_", "\r", "_")
+
+// tagsOf returns Datadog tags of the environment by tags of its task.
+func (d *Datadog) tagsOf(subdomain string, tags []types.Tag) []string {
+	return d.tagsBy(subdomain, func(key string) string {
+		return getTag(tags, key)
+	})
+}
+
+// tagsOfParameter returns Datadog tags of the environment by launch parameters.
+func (d *Datadog) tagsOfParameter(subdomain string, param TaskParameter) []string {
+	return d.tagsBy(subdomain, func(key string) string {
+		return param[key]
+	})
+}
+
+func (d *Datadog) tagsBy(subdomain string, value func(key string) string) []string {
+	tags := []string{"subdomain:" + subdomain}
+	for name, key := range d.TagKeys {
+		if v := value(key); v != "" {
+			tags = append(tags, name+":"+v)
+		}
+	}
+	sort.Strings(tags[1:])
+	return tags
+}
+
+// formatDatadogMetric formats the metric in the DogStatsD protocol. e.g. mirage.environments:3|g|#status:running
+func formatDatadogMetric(namespace, name string, value float64, typ string, tags []string) string {
+	var b strings.Builder
+	b.WriteString(namespace + "." + name + ":")
+	b.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	b.WriteString("|" + typ)
+	writeDatadogTags(&b, tags)
+	return b.String()
+}
+
+// formatDatadogEvent formats the event in the DogStatsD protocol. e.g. _e{5,4}:title|text|t:info|#tags
+func formatDatadogEvent(title, text, alertType string, tags []string) string {
+	// new lines in texts must be escaped
+	text = strings.ReplaceAll(text, "\n", "\\n")
+	var b strings.Builder
+	fmt.Fprintf(&b, "_e{%d,%d}:%s|%s|t:%s", len(title), len(text), title, text, alertType)
+	writeDatadogTags(&b, tags)
+	return b.String()
+}
+
+func writeDatadogTags(b *strings.Builder, tags []string) {
+	for i, tag := range tags {
+		if i == 0 {
+			b.WriteString("|#")
+		} else {
+			b.WriteString(",")
+		}
+		b.WriteString(datadogTagReplacer.Replace(tag))
+	}
+}
+
+// datadogClient sends metrics and events to DogStatsD.
+type datadogClient struct {
+	cfg  *Datadog
+	conn net.Conn
+
+	mu   sync.Mutex
+	tags map[string][]string // subdomain -> tags of the environment
+}
+
+func (c *Config) openDatadog() error {
+	d := c.Datadog
+	if d == nil {
+		return nil
+	}
+	// UDP never fails to connect even if the agent is not running
+	conn, err := net.Dial("udp", d.Address)
+	if err != nil {
+		return fmt.Errorf("failed to open datadog.address %s: %w", d.Address, err)
+	}
+	c.datadog = &datadogClient{cfg: d, conn: conn, tags: map[string][]string{}}
+	c.cleanups = append(c.cleanups, conn.Close)
+	return nil
+}
+
+func (c *datadogClient) send(ctx context.Context, msg string) {
+	if _, err := c.conn.Write([]byte(msg)); err != nil {
+		slog.WarnContext(ctx, f("failed to send to datadog %s: %s", c.cfg.Address, err))
+	}
+}
+
+func (c *datadogClient) metric(ctx context.Context, name string, value float64, typ string, tags []string) {
+	c.send(ctx, formatDatadogMetric(c.cfg.Namespace, name, value, typ, appendTags(tags, c.cfg.Tags...)))
+}
+
+func (c *datadogClient) event(ctx context.Context, title, text, alertType string, tags []string) {
+	c.send(ctx, formatDatadogEvent(title, text, alertType, appendTags(tags, c.cfg.Tags...)))
+}
+
+// appendTags appends tags to a copy of the slice, because tags of environments are shared.
+func appendTags(tags []string, more ...string) []string {
+	return append(tags[:len(tags):len(tags)], more...)
+}
+
+// environmentTags returns tags of the environment which was seen by the last report.
+func (c *datadogClient) environmentTags(subdomain string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if tags, ok := c.tags[subdomain]; ok {
+		return tags
+	}
+	return []string{"subdomain:" + subdomain}
+}
+
+// report sends gauges of environments by statuses and Datadog tags.
+func (c *datadogClient) report(ctx context.Context, infos []*Information) {
+	counts := make(map[string]int)
+	tags := make(map[string][]string, len(infos))
+	for _, info := range infos {
+		t := c.cfg.tagsOf(info.SubDomain, info.Tags)
+		tags[info.SubDomain] = t
+		// environments are counted without the subdomain tag, not to make too many contexts
+		key := strings.Join(append([]string{"status:" + strings.ToLower(info.LastStatus)}, t[1:]...), "\x00")
+		counts[key]++
+	}
+	c.mu.Lock()
+	c.tags = tags
+	c.mu.Unlock()
+	for key, n := range counts {
+		c.metric(ctx, "environments", float64(n), "g", strings.Split(key, "\x00"))
+	}
+}
+
+// RunDatadogReporter sends gauges of environments to Datadog periodically.
+func (m *Mirage) RunDatadogReporter(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	dd := m.Config.datadog
+	tk := time.NewTicker(dd.cfg.Interval)
+	defer tk.Stop()
+	for {
+		if infos, err := m.runner.List(ctx, statusRunning); err != nil {
+			slog.Warn(f("failed to list tasks to report to datadog: %s", err))
+		} else {
+			dd.report(ctx, infos)
+		}
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			slog.Debug("RunDatadogReporter() is done")
+			return
+		}
+	}
+}
+
+// datadogRunner is a TaskRunner which sends access counts and lifecycle events of environments to Datadog.
+type datadogRunner struct {
+	TaskRunner
+
+	cfg *Config
+	dd  *datadogClient
+}
+
+func newDatadogRunner(cfg *Config, runner TaskRunner) TaskRunner {
+	return &datadogRunner{
+		TaskRunner: runner,
+		cfg:        cfg,
+		dd:         cfg.datadog,
+	}
+}
+
+func (r *datadogRunner) PutAccessCounts(ctx context.Context, all map[string]accessCount) error {
+	for subdomain, counters := range all {
+		var sum int64
+		for _, count := range counters {
+			sum += count
+		}
+		r.dd.metric(ctx, "access.count", float64(sum), "c", r.dd.environmentTags(subdomain))
+	}
+	return r.TaskRunner.PutAccessCounts(ctx, all)
+}
+
+func (r *datadogRunner) Launch(ctx context.Context, subdomain string, param TaskParameter, opt *LaunchOption, taskdefs ...string) error {
+	err := r.TaskRunner.Launch(ctx, subdomain, param, opt, taskdefs...)
+	tags := r.dd.cfg.tagsOfParameter(subdomain, param)
+	if err != nil {
+		r.lifecycle(ctx, EventEnvironmentFailed, subdomain, err.Error(), "error", tags)
+	} else {
+		r.lifecycle(ctx, EventEnvironmentLaunched, subdomain, "taskdefs: "+strings.Join(taskdefs, ", "), "success", tags)
+	}
+	return err
+}
+
+func (r *datadogRunner) TerminateBySubdomain(ctx context.Context, subdomain string) error {
+	tags := r.dd.environmentTags(subdomain)
+	if err := r.TaskRunner.TerminateBySubdomain(ctx, subdomain); err != nil {
+		return err
+	}
+	detailType := EventEnvironmentTerminated
+	if isPurge(ctx) {
+		detailType = EventEnvironmentPurged
+	}
+	r.lifecycle(ctx, detailType, subdomain, "", "info", tags)
+	return nil
+}
+
+// lifecycle sends the event and the count of the transition. e.g. mirage.environment.launched
+func (r *datadogRunner) lifecycle(ctx context.Context, detailType, subdomain, text, alertType string, tags []string) {
+	name := strings.TrimPrefix(detailType, "mirage.")
+	r.dd.metric(ctx, name, 1, "c", tags)
+	title := fmt.Sprintf("%s %s", subdomain, strings.TrimPrefix(name, "environment."))
+	if text == "" {
+		text = subdomain + r.cfg.Host.ReverseProxySuffix
+	}
+	r.dd.event(ctx, title, text, alertType, appendTags(tags, "source:"+DefaultEventSource, "event:"+detailType))
+}
@@ -0,0 +1,104 @@
+package mirageecs_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDatadogValidate(t *testing.T) {
+	t.Setenv("DD_AGENT_HOST", "")
+	d := &mirageecs.Datadog{}
+	if err := d.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if d.Address != mirageecs.DefaultDatadogAddress || d.Namespace != mirageecs.DefaultDatadogNamespace || d.Interval != mirageecs.DefaultDatadogInterval {
+		t.Errorf("unexpected defaults: %#v", d)
+	}
+
+	t.Setenv("DD_AGENT_HOST", "10.0.0.1")
+	t.Setenv("DD_DOGSTATSD_PORT", "18125")
+	d = &mirageecs.Datadog{}
+	if err := d.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if d.Address != "10.0.0.1:18125" {
+		t.Errorf("unexpected address by environment variables: %s", d.Address)
+	}
+
+	for _, d := range []*mirageecs.Datadog{
+		{Address: "localhost"},
+		{Interval: -time.Second},
+	} {
+		if err := d.Validate(); err == nil {
+			t.Errorf("%#v should be invalid", d)
+		}
+	}
+}
+
+func TestFormatDatadog(t *testing.T) {
+	if s := mirageecs.FormatDatadogMetric("mirage", "environments", 3, "g", []string{"status:running", "env:dev|1"}); s != "mirage.environments:3|g|#status:running,env:dev_1" {
+		t.Errorf("unexpected metric: %s", s)
+	}
+	if s := mirageecs.FormatDatadogMetric("mirage", "access.count", 0.5, "c", nil); s != "mirage.access.count:0.5|c" {
+		t.Errorf("unexpected metric: %s", s)
+	}
+	if s := mirageecs.FormatDatadogEvent("foo failed", "line1\nline2", "error", []string{"subdomain:foo"}); s != `_e{10,12}:foo failed|line1\nline2|t:error|#subdomain:foo` {
+		t.Errorf("unexpected event: %s", s)
+	}
+}
+
+func TestDatadogLifecycle(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cfg.Cleanup()
+	cfg.Datadog = &mirageecs.Datadog{
+		Address: pc.LocalAddr().String(),
+		Tags:    []string{"team:platform"},
+		TagKeys: map[string]string{"env": "branch"},
+	}
+	if err := cfg.OpenDatadog(); err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	if err := m.Runner().Launch(ctx, "foo", mirageecs.TaskParameter{"branch": "develop"}, nil, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	var packets []string
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	for len(packets) < 2 {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+	expected := []string{
+		"mirage.environment.launched:1|c|#subdomain:foo,env:develop,team:platform",
+		"_e{12,15}:foo launched|taskdefs: app:1|t:success|#subdomain:foo,env:develop,source:mirage-ecs,event:mirage.environment.launched,team:platform",
+	}
+	if diff := cmp.Diff(expected, packets); diff != "" {
+		t.Errorf("unexpected packets (-want +got):\n%s", diff)
+	}
+	for _, p := range packets {
+		if strings.Contains(p, "\n") {
+			t.Errorf("packets must not contain new lines: %q", p)
+		}
+	}
+}
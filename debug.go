@@ -0,0 +1,67 @@
+package mirageecs
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/metrics"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ApiPprof serves profiles of net/http/pprof under /api/debug/pprof/. It is an admin operation.
+func (api *WebApi) ApiPprof(c echo.Context) error {
+	// pprof.Index finds profiles by the path /debug/pprof/, so profiles are served by names
+	var h http.Handler
+	switch name := c.Param("name"); name {
+	case "":
+		h = http.HandlerFunc(pprof.Index)
+	case "cmdline":
+		h = http.HandlerFunc(pprof.Cmdline)
+	case "profile":
+		h = http.HandlerFunc(pprof.Profile)
+	case "symbol":
+		h = http.HandlerFunc(pprof.Symbol)
+	case "trace":
+		h = http.HandlerFunc(pprof.Trace)
+	default:
+		h = pprof.Handler(name)
+	}
+	h.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// runtimeMetrics returns values of Go runtime metrics. Histograms are omitted.
+func runtimeMetrics() map[string]float64 {
+	descs := metrics.All()
+	samples := make([]metrics.Sample, len(descs))
+	for i, d := range descs {
+		samples[i].Name = d.Name
+	}
+	metrics.Read(samples)
+	values := make(map[string]float64, len(samples))
+	for _, s := range samples {
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			values[s.Name] = float64(s.Value.Uint64())
+		case metrics.KindFloat64:
+			values[s.Name] = s.Value.Float64()
+		}
+	}
+	return values
+}
+
+// ApiRuntime returns Go runtime metrics and sizes of states of mirage-ecs. It is an admin operation.
+func (api *WebApi) ApiRuntime(c echo.Context) error {
+	routes, startedAt := api.health.state()
+	return c.JSON(http.StatusOK, APIRuntimeResponse{
+		Result:     "ok",
+		Version:    Version,
+		GoVersion:  runtime.Version(),
+		Uptime:     int64(time.Since(startedAt).Seconds()),
+		Goroutines: runtime.NumGoroutine(),
+		Routes:     routes,
+		Metrics:    runtimeMetrics(),
+	})
+}
@@ -0,0 +1,93 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestDebugEndpoints(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Auth = &mirageecs.Auth{
+		Token: &mirageecs.AuthMethodToken{Header: "x-mirage-token", Token: "user"},
+		Admin: &mirageecs.AuthMethodToken{Header: "x-mirage-admin-token", Token: "admin"},
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	get := func(path, adminToken string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Header.Set("x-mirage-token", "user")
+		if adminToken != "" {
+			req.Header.Set("x-mirage-admin-token", adminToken)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(b)
+	}
+
+	for _, path := range []string{"/api/debug/pprof/", "/api/debug/pprof/heap", "/api/debug/runtime"} {
+		if code, _ := get(path, ""); code != http.StatusForbidden {
+			t.Errorf("%s without the admin token: wanted 403, got %d", path, code)
+		}
+	}
+	if code, body := get("/api/debug/pprof/", "admin"); code != http.StatusOK || !strings.Contains(body, "goroutine") {
+		t.Errorf("unexpected index of profiles: %d %s", code, body)
+	}
+	if code, body := get("/api/debug/pprof/goroutine?debug=1", "admin"); code != http.StatusOK || !strings.Contains(body, "goroutine profile") {
+		t.Errorf("unexpected goroutine profile: %d %s", code, body)
+	}
+	if code, _ := get("/api/debug/pprof/unknown", "admin"); code != http.StatusNotFound {
+		t.Errorf("unknown profile: wanted 404, got %d", code)
+	}
+
+	code, body := get("/api/debug/runtime", "admin")
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status: %d %s", code, body)
+	}
+	var r mirageecs.APIRuntimeResponse
+	if err := json.Unmarshal([]byte(body), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Goroutines == 0 || r.Metrics["/memory/classes/heap/objects:bytes"] == 0 {
+		t.Errorf("unexpected runtime metrics: %#v", r)
+	}
+	if _, ok := r.Metrics["/gc/heap/allocs-by-size:bytes"]; ok {
+		t.Error("histograms must be omitted")
+	}
+}
+
+func TestDebugEndpointsWithoutAdmin(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+	res, err := http.Get(ts.URL + "/api/debug/pprof/heap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("debug endpoints must be disabled without auth.admin: %d", res.StatusCode)
+	}
+}
@@ -26,12 +26,16 @@ var e2eRequestsForm = map[string]string{
 	"/api/terminate": url.Values{
 		"subdomain": []string{"mytask"},
 	}.Encode(),
+	"/api/protect": url.Values{
+		"subdomain": []string{"mytask"},
+	}.Encode(),
 }
 
 var e2eRequestsJSON = map[string]string{
 	"/api/launch":    `{"subdomain":"mytask","taskdef":["dummy"],"branch":"develop","parameters":{"env":"test"}}`,
 	"/api/purge":     `{"duration":"300"}`,
 	"/api/terminate": `{"subdomain":"mytask"}`,
+	"/api/protect":   `{"subdomain":"mytask"}`,
 }
 
 func TestE2EAPI(t *testing.T) {
@@ -149,6 +153,32 @@ func testE2EAPI(t *testing.T, reqs map[string]string, contentType string, compat
 		}
 	})
 
+	t.Run("/api/protect", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", ts.URL+"/api/protect", strings.NewReader(reqs["/api/protect"]))
+		req.Header.Set("Content-Type", contentType)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			body, _ := io.ReadAll(res.Body)
+			t.Errorf("status code should be 200: %d", res.StatusCode)
+			t.Errorf("body: %s", body)
+			return
+		}
+		res, err = client.Get(ts.URL + "/api/list")
+		if err != nil {
+			t.Error(err)
+		}
+		defer res.Body.Close()
+		var r mirageecs.APIListResponse
+		json.NewDecoder(res.Body).Decode(&r)
+		if len(r.Result) != 1 || !r.Result[0].Protected {
+			t.Errorf("mytask should be protected %#v", r)
+		}
+	})
+
 	t.Run("/api/purge", func(t *testing.T) {
 		req, _ := http.NewRequest("POST", ts.URL+"/api/purge", strings.NewReader(reqs["/api/purge"]))
 		req.Header.Set("Content-Type", contentType)
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -20,6 +21,7 @@ import (
 	cwlogs "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/efs"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -27,19 +29,69 @@ import (
 var taskDefinitionCache = ttlcache.NewCache() // no need to expire because taskdef is immutable.
 
 type Information struct {
-	ID         string            `json:"id"`
-	ShortID    string            `json:"short_id"`
-	SubDomain  string            `json:"subdomain"`
-	GitBranch  string            `json:"branch"`
-	TaskDef    string            `json:"taskdef"`
-	IPAddress  string            `json:"ipaddress"`
-	Created    time.Time         `json:"created"`
-	LastStatus string            `json:"last_status"`
-	PortMap    map[string]int    `json:"port_map"`
-	Env        map[string]string `json:"env"`
-	Tags       []types.Tag       `json:"tags"`
+	ID        string `json:"id"`
+	ShortID   string `json:"short_id"`
+	SubDomain string `json:"subdomain"`
+	GitBranch string `json:"branch"`
+	TaskDef   string `json:"taskdef"`
+	IPAddress string `json:"ipaddress"`
+	// IPv6Address is the IPv6 address of the task. IPAddress is the same address if the task is routed by IPv6.
+	IPv6Address string    `json:"ipv6address,omitempty"`
+	Created     time.Time `json:"created"`
+	LastStatus  string    `json:"last_status"`
+	// State is the state of the environment by LastStatus and health checks. e.g. PROVISIONING, HEALTHY
+	State    string            `json:"state"`
+	PortMap  map[string]int    `json:"port_map"`
+	Env      map[string]string `json:"env"`
+	Tags     []types.Tag       `json:"tags"`
+	Option   *LaunchOption     `json:"option,omitempty"`
+	StopCode string            `json:"stop_code,omitempty"`
+	// HealthStatus is the health status of the task which has container health checks.
+	HealthStatus string `json:"health_status,omitempty"`
+	// Ready reports whether the task is ready to receive requests.
+	Ready bool `json:"ready"`
+	// Service is the name of the ECS service which started the task in service mode.
+	Service string `json:"service,omitempty"`
+	// Relaunches is the history of relaunches caused by task failures.
+	// Old records may be dropped, so RelaunchCount is the number of relaunches.
+	Relaunches    []RelaunchRecord `json:"relaunches,omitempty"`
+	RelaunchCount int              `json:"relaunch_count,omitempty"`
+	// StoppedAt, StoppedReason and ExitCodes describe why the task stopped.
+	StoppedAt     *time.Time       `json:"stopped_at,omitempty"`
+	StoppedReason string           `json:"stopped_reason,omitempty"`
+	ExitCodes     map[string]int32 `json:"exit_codes,omitempty"`
+	// SleepReason is the reason why the environment is sleeping. e.g. "schedule:office-hours"
+	SleepReason string `json:"sleep_reason,omitempty"`
+	// LogsURL is a link to logs of the stopped task.
+	LogsURL string `json:"logs_url,omitempty"`
+	// DashboardURL is a link to the CloudWatch dashboard of the environment by ecs.dashboard.
+	DashboardURL string `json:"dashboard_url,omitempty"`
+	// Protected reports whether the environment is protected from purge and scale-in.
+	Protected bool `json:"protected"`
+	// ExecuteCommandEnabled reports whether ECS Exec is enabled for the task.
+	ExecuteCommandEnabled bool `json:"execute_command_enabled"`
+	// OSFamily is the OS family of the task definition. Empty means Linux.
+	OSFamily string `json:"os_family,omitempty"`
+	// Cpu (CPU units) and Memory (MiB) are the size of the task, and Spot reports whether the task runs on Fargate Spot.
+	Cpu    int  `json:"cpu,omitempty"`
+	Memory int  `json:"memory,omitempty"`
+	Spot   bool `json:"spot,omitempty"`
+	// HourlyCost and EstimatedCost are estimated by ecs.cost.
+	HourlyCost    float64 `json:"hourly_cost,omitempty"`
+	EstimatedCost float64 `json:"estimated_cost,omitempty"`
+	// Images are images of containers in the task.
+	Images []ContainerImage `json:"images,omitempty"`
+	// Utilization is CPU and memory utilization of the running task by ecs.utilization.
+	Utilization *TaskUtilization `json:"utilization,omitempty"`
+	// ErrorAlert is the state of the alert of errors of the environment by error_alert.mark_unhealthy.
+	ErrorAlert *ErrorAlertState `json:"error_alert,omitempty"`
+	// NamedPorts are container ports of named port mappings in the task definition.
+	NamedPorts PortRoutes `json:"named_ports,omitempty"`
+	// AccessGraph is access counts of the last 24 hours for the web interface.
+	AccessGraph *AccessGraph `json:"-"`
 
-	task *types.Task
+	task      *types.Task
+	hostPorts map[int]int // container port -> host port
 }
 
 func (info Information) ShouldBePurged(duration time.Duration, excludesMap map[string]struct{}, excludeTagsMap map[string]string) bool {
@@ -47,6 +99,10 @@ func (info Information) ShouldBePurged(duration time.Duration, excludesMap map[s
 		slog.Info(f("skip not running task: %s subdomain: %s", info.LastStatus, info.SubDomain))
 		return false
 	}
+	if info.Protected {
+		slog.Info(f("skip protected subdomain: %s", info.SubDomain))
+		return false
+	}
 	if _, ok := excludesMap[info.SubDomain]; ok {
 		slog.Info(f("skip exclude subdomain: %s", info.SubDomain))
 		return false
@@ -132,24 +188,241 @@ func (p TaskParameter) ToEnv(subdomain string, configParams Parameters, enc func
 	return env
 }
 
+// LaunchOption is a set of per-environment options specified at launch.
+// The options which affect the proxy and relaunching are stored in the tags of the task.
+type LaunchOption struct {
+	Compression              *bool                    `json:"compression,omitempty"`
+	Rewrites                 RewriteRules             `json:"rewrites,omitempty"`
+	CapacityProviderStrategy CapacityProviderStrategy `json:"capacity_provider_strategy,omitempty"`
+	Protected                bool                     `json:"protected,omitempty"`
+	PortRoutes               PortRoutes               `json:"port_routes,omitempty"`
+	Visibility               string                   `json:"visibility,omitempty"`
+	Aliases                  []string                 `json:"aliases,omitempty"`
+	ExpiresAt                *time.Time               `json:"expires_at,omitempty"`
+	Schedule                 string                   `json:"schedule,omitempty"`
+	GitHub                   string                   `json:"github,omitempty"`
+	Bitbucket                string                   `json:"bitbucket,omitempty"`
+
+	// task definition overrides. these are not stored in tags.
+	ImageTag        string              `json:"-"`
+	ImageTags       map[string]string   `json:"-"`
+	Cpu             string              `json:"-"`
+	Memory          string              `json:"-"`
+	Environment     map[string]string   `json:"-"`
+	RuntimePlatform *RuntimePlatform    `json:"-"`
+	GPU             *GPU                `json:"-"`
+	Command         map[string][]string `json:"-"`
+	EntryPoint      map[string][]string `json:"-"`
+
+	EnableExecuteCommand *bool `json:"-"`
+}
+
+func (o *LaunchOption) ToECSTags() []types.Tag {
+	var tags []types.Tag
+	if o == nil {
+		return tags
+	}
+	if o.Compression != nil {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagCompression),
+			Value: aws.String(strconv.FormatBool(*o.Compression)),
+		})
+	}
+	if len(o.Rewrites) > 0 {
+		if v, err := encodeRewriteRules(o.Rewrites); err != nil {
+			slog.Warn(f("failed to encode rewrite rules: %s", err))
+		} else {
+			tags = append(tags, types.Tag{
+				Key:   aws.String(TagRewrites),
+				Value: aws.String(v),
+			})
+		}
+	}
+	if len(o.CapacityProviderStrategy) > 0 {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagCapacityProviderStrategy),
+			Value: aws.String(o.CapacityProviderStrategy.String()),
+		})
+	}
+	if o.Protected {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagProtected),
+			Value: aws.String("true"),
+		})
+	}
+	if len(o.PortRoutes) > 0 {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagPortRoutes),
+			Value: aws.String(o.PortRoutes.String()),
+		})
+	}
+	if len(o.Aliases) > 0 {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagAliases),
+			Value: aws.String(formatAliases(o.Aliases)),
+		})
+	}
+	if o.private() {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagVisibility),
+			Value: aws.String(VisibilityPrivate),
+		})
+	}
+	if o.ExpiresAt != nil {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagExpiresAt),
+			Value: aws.String(formatExpiresAt(*o.ExpiresAt)),
+		})
+	}
+	if o.Schedule != "" {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagSchedule),
+			Value: aws.String(o.Schedule),
+		})
+	}
+	if o.GitHub != "" {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagGitHub),
+			Value: aws.String(o.GitHub),
+		})
+	}
+	if o.Bitbucket != "" {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagBitbucket),
+			Value: aws.String(o.Bitbucket),
+		})
+	}
+	return tags
+}
+
+func launchOptionFromTags(tags []types.Tag) *LaunchOption {
+	var o *LaunchOption
+	for _, t := range tags {
+		k, v := aws.ToString(t.Key), aws.ToString(t.Value)
+		switch k {
+		case TagCompression:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				slog.Warn(f("invalid tag value %s=%s", k, v))
+				continue
+			}
+			if o == nil {
+				o = &LaunchOption{}
+			}
+			o.Compression = &b
+		case TagRewrites:
+			rs, err := decodeRewriteRules(v)
+			if err != nil {
+				slog.Warn(f("invalid tag value %s=%s: %s", k, v, err))
+				continue
+			}
+			if o == nil {
+				o = &LaunchOption{}
+			}
+			o.Rewrites = rs
+		case TagCapacityProviderStrategy:
+			st, err := parseCapacityProviderStrategy(v)
+			if err != nil {
+				slog.Warn(f("invalid tag value %s=%s: %s", k, v, err))
+				continue
+			}
+			if o == nil {
+				o = &LaunchOption{}
+			}
+			o.CapacityProviderStrategy = st
+		case TagProtected:
+			if o == nil {
+				o = &LaunchOption{}
+			}
+			o.Protected = v == "true"
+		case TagPortRoutes:
+			rs, err := parsePortRoutes(v)
+			if err != nil {
+				slog.Warn(f("invalid tag value %s=%s: %s", k, v, err))
+				continue
+			}
+			if o == nil {
+				o = &LaunchOption{}
+			}
+			o.PortRoutes = rs
+		case TagVisibility:
+			if o == nil {
+				o = &LaunchOption{}
+			}
+			o.Visibility = v
+		case TagAliases:
+			if o == nil {
+				o = &LaunchOption{}
+			}
+			o.Aliases = parseAliases(v)
+		case TagExpiresAt:
+			t, err := parseExpiresAt(v)
+			if err != nil {
+				slog.Warn(f("invalid tag value %s=%s: %s", k, v, err))
+				continue
+			}
+			if o == nil {
+				o = &LaunchOption{}
+			}
+			o.ExpiresAt = &t
+		case TagSchedule:
+			if o == nil {
+				o = &LaunchOption{}
+			}
+			o.Schedule = v
+		case TagGitHub:
+			if o == nil {
+				o = &LaunchOption{}
+			}
+			o.GitHub = v
+		case TagBitbucket:
+			if o == nil {
+				o = &LaunchOption{}
+			}
+			o.Bitbucket = v
+		}
+	}
+	return o
+}
+
 const (
 	TagManagedBy   = "ManagedBy"
 	TagSubdomain   = "Subdomain"
 	TagValueMirage = "Mirage"
+	TagCompression = "MirageCompression"
+	TagRewrites    = "MirageRewrites"
+
+	TagCapacityProviderStrategy = "MirageCapacityProviderStrategy"
+
+	// maxTagValueLength is the limit of a tag value length of ECS
+	maxTagValueLength = 256
 
 	EnvSubdomain    = "SUBDOMAIN"
 	EnvSubdomainRaw = "SUBDOMAINRAW"
 
+	stopCodeSpotInterruption = string(types.TaskStopCodeSpotInterruption)
+
 	statusRunning = string(types.DesiredStatusRunning)
 	statusStopped = string(types.DesiredStatusStopped)
 )
 
 type TaskRunner interface {
-	Launch(ctx context.Context, subdomain string, param TaskParameter, taskdefs ...string) error
-	Logs(ctx context.Context, subdomain string, since time.Time, tail int) ([]string, error)
+	Launch(ctx context.Context, subdomain string, param TaskParameter, opt *LaunchOption, taskdefs ...string) error
+	Logs(ctx context.Context, subdomain string, since time.Time, tail int, filter *LogsFilter) ([]string, error)
+	QueryLogs(ctx context.Context, subdomain string, query string, since, until time.Time, limit int32) ([]map[string]string, error)
+	DownloadLogs(ctx context.Context, subdomain string, since time.Time, fn func(*LogLine) error) error
+	CheckHealth(ctx context.Context) []*HealthCheck
 	Trace(ctx context.Context, id string) (string, error)
 	Terminate(ctx context.Context, subdomain string) error
+	Relaunch(ctx context.Context, info *Information) error
 	TerminateBySubdomain(ctx context.Context, subdomain string) error
+	Protect(ctx context.Context, subdomain string, protected bool) error
+	SetAliases(ctx context.Context, subdomain string, aliases []string) error
+	SetExpiresAt(ctx context.Context, subdomain string, t time.Time) error
+	Sleep(ctx context.Context, subdomain string, reason string) error
+	Wake(ctx context.Context, subdomain string) error
+	ListSleeping(ctx context.Context) ([]*Information, error)
+	FillWarmPool(ctx context.Context) error
 	List(ctx context.Context, status string) ([]*Information, error)
 	SetProxyControlChannel(ch chan *proxyControl)
 	GetAccessCount(ctx context.Context, subdomain string, duration time.Duration) (int64, error)
@@ -161,7 +434,12 @@ type ECS struct {
 	svc            *ecs.Client
 	logsSvc        *cwlogs.Client
 	cwSvc          *cw.Client
+	efsSvc         *efs.Client
 	proxyControlCh chan *proxyControl
+
+	accessPointLocks sync.Map // subdomain -> *sync.Mutex
+	sleeping         sleepingStore
+	warmPoolMu       sync.Mutex
 }
 
 func NewECSTaskRunner(cfg *Config) TaskRunner {
@@ -170,7 +448,9 @@ func NewECSTaskRunner(cfg *Config) TaskRunner {
 		svc:     ecs.NewFromConfig(*cfg.awscfg),
 		logsSvc: cwlogs.NewFromConfig(*cfg.awscfg),
 		cwSvc:   cw.NewFromConfig(*cfg.awscfg),
+		efsSvc:  efs.NewFromConfig(*cfg.awscfg),
 	}
+	e.sleeping.store = cfg.persistence
 	return e
 }
 
@@ -178,16 +458,57 @@ func (e *ECS) SetProxyControlChannel(ch chan *proxyControl) {
 	e.proxyControlCh = ch
 }
 
-func (e *ECS) launchTask(ctx context.Context, subdomain string, taskdef string, option TaskParameter) error {
+func (e *ECS) launchTask(ctx context.Context, subdomain string, taskdef string, option TaskParameter, opt *LaunchOption) error {
 	cfg := e.cfg
+	ctx = withLogAttrs(ctx, slog.String("taskdef", taskdef))
+
+	// revisions registered in this launch are deregistered unless the task runs with it
+	var registered []string
+	used := ""
+	base := taskdef
+	defer func() {
+		for _, arn := range registered {
+			if arn != used {
+				e.deregisterTaskDefinition(ctx, arn)
+			}
+		}
+	}()
+
+	if taskdef == "" && cfg.ECS.taskDefinitionTemplate != nil {
+		arn, err := e.registerTaskDefinitionFromTemplate(ctx, subdomain, option, opt)
+		if err != nil {
+			return err
+		}
+		taskdef = arn
+		registered = append(registered, arn)
+		ctx = withLogAttrs(ctx, slog.String("taskdef", taskdef))
+	}
 
-	slog.Info(f("launching task subdomain:%s taskdef:%s", subdomain, taskdef))
+	slog.InfoContext(ctx, f("launching task subdomain:%s taskdef:%s", subdomain, taskdef))
 	tdOut, err := e.svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
 		TaskDefinition: aws.String(taskdef),
+		Include:        []types.TaskDefinitionField{types.TaskDefinitionFieldTags},
 	})
 	if err != nil {
 		return fmt.Errorf("failed to describe task definition: %w", err)
 	}
+	if err := cfg.ECS.CommandOverride.validateFor(tdOut.TaskDefinition, opt); err != nil {
+		return err
+	}
+	if err := cfg.Network.Private.validateFor(opt, cfg.ECS.networkConfiguration); err != nil {
+		return err
+	}
+	if mods, err := e.taskDefinitionModifiers(ctx, subdomain, tdOut.TaskDefinition, opt); err != nil {
+		return err
+	} else if len(mods) > 0 {
+		td, err := e.registerModifiedTaskDefinition(ctx, subdomain, tdOut.TaskDefinition, tdOut.Tags, mods)
+		if err != nil {
+			return err
+		}
+		tdOut.TaskDefinition = td
+		taskdef = aws.ToString(td.TaskDefinitionArn)
+		registered = append(registered, taskdef)
+	}
 
 	// override envs for each container in taskdef
 	ov := &types.TaskOverride{}
@@ -203,24 +524,76 @@ func (e *ECS) launchTask(ctx context.Context, subdomain string, taskdef string,
 			},
 		)
 	}
-	slog.Debug(f("Task Override: %v", ov))
+	opt.applyOverrides(ov)
+	opt.applyCommandOverrides(ov)
+	if gpu := cfg.ECS.GPU.gpuFor(opt); gpu != nil {
+		strategy := cfg.ECS.capacityProviderStrategy
+		if opt != nil && len(opt.CapacityProviderStrategy) > 0 {
+			strategy = opt.CapacityProviderStrategy.toSDK()
+		}
+		if err := gpu.validateLaunchTarget(cfg.ECS.LaunchType, strategy); err != nil {
+			return err
+		}
+		gpu.applyOverrides(ov)
+	}
+	slog.DebugContext(ctx, f("Task Override: %v", ov))
+
+	exec, err := cfg.ECS.executeCommandEnabled(tdOut.TaskDefinition, opt)
+	if err != nil {
+		return err
+	}
+	// copy not to affect launches of other task definitions
+	o := LaunchOption{}
+	if opt != nil {
+		o = *opt
+	}
+	o.EnableExecuteCommand = aws.Bool(exec)
+	opt = &o
 
 	tags := option.ToECSTags(subdomain, cfg.Parameter)
+	tags = append(tags, option.ToPropagatedTags(cfg.Parameter, cfg.ECS.ParameterTagPrefix)...)
+	tags = append(tags, opt.ToECSTags()...)
+	tags = append(tags, traceParentTags(ctx)...)
+	if len(registered) > 0 {
+		tags = append(tags, types.Tag{Key: aws.String(TagBaseTaskDefinition), Value: aws.String(base)})
+	}
+	if cfg.ECS.ServiceMode {
+		// the service runs a revision registered with the overrides
+		return e.createService(ctx, subdomain, tdOut.TaskDefinition, tdOut.Tags, ov, tags, opt)
+	}
+	if err := e.runTask(ctx, taskdef, ov, tags, opt); err != nil {
+		return err
+	}
+	used = taskdef
+	return nil
+}
+
+func (e *ECS) runTask(ctx context.Context, taskdef string, ov *types.TaskOverride, tags []types.Tag, opt *LaunchOption) error {
+	cfg := e.cfg
+	nc, err := cfg.networkConfigurationFor(tags, opt)
+	if err != nil {
+		return err
+	}
 	runtaskInput := &ecs.RunTaskInput{
 		CapacityProviderStrategy: cfg.ECS.capacityProviderStrategy,
 		Cluster:                  aws.String(cfg.ECS.Cluster),
 		TaskDefinition:           aws.String(taskdef),
-		NetworkConfiguration:     cfg.ECS.networkConfiguration,
+		NetworkConfiguration:     nc,
 		Overrides:                ov,
 		Count:                    aws.Int32(1),
 		Tags:                     tags,
-		EnableExecuteCommand:     aws.ToBool(cfg.ECS.EnableExecuteCommand),
+		EnableExecuteCommand:     cfg.ECS.enableExecuteCommandFor(opt),
 	}
 	if lt := cfg.ECS.LaunchType; lt != nil {
 		runtaskInput.LaunchType = types.LaunchType(*lt)
 	}
+	if opt != nil && len(opt.CapacityProviderStrategy) > 0 {
+		// capacity provider strategy specified at launch overrides launch type in config
+		runtaskInput.CapacityProviderStrategy = opt.CapacityProviderStrategy.toSDK()
+		runtaskInput.LaunchType = ""
+	}
 
-	slog.Debug(f("RunTaskInput: %v", runtaskInput))
+	slog.DebugContext(ctx, f("RunTaskInput: %v", runtaskInput))
 	out, err := e.svc.RunTask(ctx, runtaskInput)
 	if err != nil {
 		return err
@@ -240,28 +613,64 @@ func (e *ECS) launchTask(ctx context.Context, subdomain string, taskdef string,
 		)
 	}
 	task := out.Tasks[0]
-	slog.Info(f("launced task ARN: %s", *task.TaskArn))
+	slog.InfoContext(ctx, f("launced task ARN: %s", *task.TaskArn))
 	return nil
 }
 
-func (e *ECS) Launch(ctx context.Context, subdomain string, option TaskParameter, taskdefs ...string) error {
+// Relaunch runs a new task with the same task definition, overrides and tags as the stopped task.
+func (e *ECS) Relaunch(ctx context.Context, info *Information) error {
+	if info.task == nil {
+		return fmt.Errorf("task of %s is not found", info.ID)
+	}
+	ctx = withLogAttrs(ctx, slog.String("subdomain", info.SubDomain), slog.String("taskdef", info.TaskDef))
+	slog.InfoContext(ctx, f("relaunching task subdomain:%s taskdef:%s stopped task:%s", info.SubDomain, info.TaskDef, info.ShortID))
+	tags := lo.Filter(info.Tags, func(t types.Tag, _ int) bool {
+		// tags prefixed by "aws:" are reserved, and the relaunch is not traced by the trace of the launch
+		return !strings.HasPrefix(aws.ToString(t.Key), "aws:") && aws.ToString(t.Key) != TagTraceParent
+	})
+	opt := &LaunchOption{}
+	if info.Option != nil {
+		*opt = *info.Option
+	}
+	opt.EnableExecuteCommand = aws.Bool(info.task.EnableExecuteCommand)
+	return e.runTask(ctx, aws.ToString(info.task.TaskDefinitionArn), info.task.Overrides, tags, opt)
+}
+
+func (e *ECS) Launch(ctx context.Context, subdomain string, option TaskParameter, opt *LaunchOption, taskdefs ...string) error {
+	ctx = withLogAttrs(ctx, slog.String("subdomain", subdomain))
+	if _, _, err := e.sleeping.remove(ctx, subdomain); err != nil {
+		slog.WarnContext(ctx, f("failed to remove sleeping subdomain %s: %s", subdomain, err))
+	}
+	if e.cfg.ECS.ServiceMode {
+		// services of the sleeping environment are replaced
+		if err := e.deleteServicesBySubdomain(ctx, subdomain); err != nil {
+			return err
+		}
+	}
 	if infos, err := e.find(ctx, subdomain); err != nil {
 		return fmt.Errorf("failed to get subdomain %s: %w", subdomain, err)
 	} else if len(infos) > 0 {
-		slog.Info(f("subdomain %s is already running %d tasks. Terminating...", subdomain, len(infos)))
-		err := e.TerminateBySubdomain(ctx, subdomain)
+		slog.InfoContext(ctx, f("subdomain %s is already running %d tasks. Terminating...", subdomain, len(infos)))
+		// revisions to be launched are kept
+		err := e.terminateBySubdomain(ctx, subdomain, taskdefs)
 		if err != nil {
 			return err
 		}
 	}
 
-	slog.Info(f("launching subdomain:%s taskdefs:%v", subdomain, taskdefs))
+	if claimed, err := e.claimWarmTask(ctx, subdomain, taskdefs, option, opt); err != nil {
+		return err
+	} else if claimed {
+		return nil
+	}
+
+	slog.InfoContext(ctx, f("launching subdomain:%s taskdefs:%v", subdomain, taskdefs))
 
 	var eg errgroup.Group
 	for _, taskdef := range taskdefs {
 		taskdef := taskdef
 		eg.Go(func() error {
-			return e.launchTask(ctx, subdomain, taskdef, option)
+			return e.launchTask(ctx, subdomain, taskdef, option, opt)
 		})
 	}
 	return eg.Wait()
@@ -284,32 +693,97 @@ func (e *ECS) Trace(ctx context.Context, id string) (string, error) {
 	return buf.String(), nil
 }
 
-func (e *ECS) Logs(ctx context.Context, subdomain string, since time.Time, tail int) ([]string, error) {
-	infos, err := e.find(ctx, subdomain)
+func (e *ECS) Logs(ctx context.Context, subdomain string, since time.Time, tail int, filter *LogsFilter) ([]string, error) {
+	ctx = withLogAttrs(ctx, slog.String("subdomain", subdomain))
+	infos, err := e.logTasks(ctx, subdomain)
 	if err != nil {
 		return nil, err
 	}
-	if len(infos) == 0 {
-		return nil, fmt.Errorf("subdomain %s is not found", subdomain)
-	}
 
-	var logs []string
+	var events []logEvent
 	var eg errgroup.Group
 	var mu sync.Mutex
 	for _, info := range infos {
 		info := info
 		eg.Go(func() error {
-			l, err := e.logs(ctx, info, since, tail)
+			evs, err := e.logs(ctx, info, since, filter)
 			mu.Lock()
 			defer mu.Unlock()
-			logs = append(logs, l...)
+			events = append(events, evs...)
 			return err
 		})
 	}
-	return logs, eg.Wait()
+	err = eg.Wait()
+	return formatLogEvents(events, tail), err
+}
+
+// logTasks returns running tasks of the subdomain, or the last stopped tasks when it is not running.
+func (e *ECS) logTasks(ctx context.Context, subdomain string) ([]*Information, error) {
+	infos, err := e.find(ctx, subdomain)
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		// logs of the last stopped task
+		stopped, err := e.List(ctx, statusStopped)
+		if err != nil {
+			return nil, err
+		}
+		infos = lastStoppedBySubdomain(stopped, nil, 0)
+		infos = lo.Filter(infos, func(info *Information, _ int) bool {
+			return info.SubDomain == subdomain
+		})
+	}
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("subdomain %s is not found", subdomain)
+	}
+	return infos, nil
+}
+
+func (e *ECS) logs(ctx context.Context, info *Information, since time.Time, filter *LogsFilter) ([]logEvent, error) {
+	streams, err := e.logStreams(ctx, info, filter.container())
+	if err != nil {
+		return nil, err
+	}
+	if filter.pattern() != "" {
+		return e.filterLogs(ctx, streams, since, filter)
+	}
+
+	events := []logEvent{}
+	for _, stream := range streams {
+		slog.DebugContext(ctx, f("get log events from group:%s stream:%s start:%s", stream.group, stream.name, since))
+		in := &cwlogs.GetLogEventsInput{
+			LogGroupName:  aws.String(stream.group),
+			LogStreamName: aws.String(stream.name),
+		}
+		if !since.IsZero() {
+			in.StartTime = aws.Int64(since.Unix() * 1000)
+		}
+		eventsOut, err := e.logsSvc.GetLogEvents(ctx, in)
+		if err != nil {
+			slog.WarnContext(ctx, f("failed to get log events from group %s stream %s: %s", stream.group, stream.name, err))
+			continue
+		}
+		slog.DebugContext(ctx, f("%d log events", len(eventsOut.Events)))
+		for _, ev := range eventsOut.Events {
+			if ev := newLogEvent(stream.container, ev.Timestamp, ev.Message); filter.match(ev.message) {
+				events = append(events, ev)
+			}
+		}
+	}
+	return events, nil
+}
+
+// logStream is a log stream of the container.
+type logStream struct {
+	group     string
+	name      string
+	container string
 }
 
-func (e *ECS) logs(ctx context.Context, info *Information, since time.Time, tail int) ([]string, error) {
+// logStreams returns log streams of containers in the task.
+// An empty container selects all containers.
+func (e *ECS) logStreams(ctx context.Context, info *Information, container string) ([]logStream, error) {
 	task := info.task
 	taskdefOut, err := e.svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
 		TaskDefinition: task.TaskDefinitionArn,
@@ -318,63 +792,70 @@ func (e *ECS) logs(ctx context.Context, info *Information, since time.Time, tail
 	if err != nil {
 		return nil, fmt.Errorf("failed to describe task definition: %w", err)
 	}
+	return logStreamsOf(ctx, taskdefOut.TaskDefinition, info.ShortID, container), nil
+}
 
-	streams := make(map[string][]string)
-	for _, c := range taskdefOut.TaskDefinition.ContainerDefinitions {
+// logStreamsOf returns log streams of containers of the task definition by awslogs options.
+func logStreamsOf(ctx context.Context, td *types.TaskDefinition, taskID string, container string) []logStream {
+	var streams []logStream
+	for _, c := range td.ContainerDefinitions {
 		c := c
 		logConf := c.LogConfiguration
-		if logConf == nil {
+		if logConf == nil || (container != "" && aws.ToString(c.Name) != container) {
 			continue
 		}
 		if logConf.LogDriver != types.LogDriverAwslogs {
-			slog.Warn(f("LogDriver %s is not supported", logConf.LogDriver))
+			slog.WarnContext(ctx, f("LogDriver %s is not supported", logConf.LogDriver))
 			continue
 		}
 		group := logConf.Options["awslogs-group"]
 		streamPrefix := logConf.Options["awslogs-stream-prefix"]
 		if group == "" || streamPrefix == "" {
-			slog.Warn(f("invalid options. awslogs-group %s awslogs-stream-prefix %s", group, streamPrefix))
+			slog.WarnContext(ctx, f("invalid options. awslogs-group %s awslogs-stream-prefix %s", group, streamPrefix))
 			continue
 		}
 		// streamName: prefix/containerName/taskID
-		streams[group] = append(
-			streams[group],
-			fmt.Sprintf("%s/%s/%s", streamPrefix, *c.Name, info.ShortID),
-		)
+		streams = append(streams, logStream{
+			group:     group,
+			name:      fmt.Sprintf("%s/%s/%s", streamPrefix, *c.Name, taskID),
+			container: *c.Name,
+		})
 	}
+	return streams
+}
 
-	logs := []string{}
-	for group, streamNames := range streams {
-		group := group
-		for _, stream := range streamNames {
-			stream := stream
-			slog.Debug(f("get log events from group:%s stream:%s start:%s", group, stream, since))
-			in := &cwlogs.GetLogEventsInput{
-				LogGroupName:  aws.String(group),
-				LogStreamName: aws.String(stream),
-			}
-			if !since.IsZero() {
-				in.StartTime = aws.Int64(since.Unix() * 1000)
-			}
-			eventsOut, err := e.logsSvc.GetLogEvents(ctx, in)
-			if err != nil {
-				slog.Warn(f("failed to get log events from group %s stream %s: %s", group, stream, err))
-				continue
-			}
-			slog.Debug(f("%d log events", len(eventsOut.Events)))
-			for _, ev := range eventsOut.Events {
-				logs = append(logs, *ev.Message)
-			}
+func (e *ECS) Terminate(ctx context.Context, taskArn string) error {
+	out, err := e.svc.DescribeTasks(ctx, &ecs.DescribeTasksInput{
+		Cluster: aws.String(e.cfg.ECS.Cluster),
+		Tasks:   []string{taskArn},
+		Include: []types.TaskField{types.TaskFieldTags},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe task %s: %w", taskArn, err)
+	}
+	if len(out.Tasks) == 0 {
+		return e.stopTask(ctx, taskArn)
+	}
+	task := &out.Tasks[0]
+	deleted := false
+	if e.cfg.ECS.ServiceMode {
+		// a stopped task is replaced by the service, so delete the service instead
+		if deleted, err = e.deleteServiceOfTask(ctx, task); err != nil {
+			return err
 		}
 	}
-	if tail > 0 && len(logs) >= tail {
-		return logs[len(logs)-tail:], nil
+	if !deleted {
+		if err := e.stopTask(ctx, taskArn); err != nil {
+			return err
+		}
 	}
-	return logs, nil
+	subdomain := decodeTagValue(getTag(task.Tags, TagSubdomain))
+	e.deregisterTaskDefinitionFor(ctx, aws.ToString(task.TaskDefinitionArn), subdomain)
+	return nil
 }
 
-func (e *ECS) Terminate(ctx context.Context, taskArn string) error {
-	slog.Info(f("stop task %s", taskArn))
+func (e *ECS) stopTask(ctx context.Context, taskArn string) error {
+	slog.InfoContext(ctx, f("stop task %s", taskArn))
 	_, err := e.svc.StopTask(ctx, &ecs.StopTaskInput{
 		Cluster: aws.String(e.cfg.ECS.Cluster),
 		Task:    aws.String(taskArn),
@@ -384,6 +865,15 @@ func (e *ECS) Terminate(ctx context.Context, taskArn string) error {
 }
 
 func (e *ECS) TerminateBySubdomain(ctx context.Context, subdomain string) error {
+	return e.terminateBySubdomain(ctx, subdomain, nil)
+}
+
+// terminateBySubdomain terminates the subdomain, and deregisters revisions registered for it except the task definitions to be launched.
+func (e *ECS) terminateBySubdomain(ctx context.Context, subdomain string, launching []string) error {
+	ctx = withLogAttrs(ctx, slog.String("subdomain", subdomain))
+	if _, _, err := e.sleeping.remove(ctx, subdomain); err != nil {
+		slog.WarnContext(ctx, f("failed to remove sleeping subdomain %s: %s", subdomain, err))
+	}
 	infos, err := e.find(ctx, subdomain)
 	if err != nil {
 		return err
@@ -396,13 +886,29 @@ func (e *ECS) TerminateBySubdomain(ctx context.Context, subdomain string) error
 		}
 		return nil
 	})
+	if e.cfg.ECS.ServiceMode {
+		eg.Go(func() error {
+			return e.deleteServicesBySubdomain(ctx, subdomain)
+		})
+	}
+	if c := e.cfg.ECS.EFS; c != nil && c.DeleteOnTerminate {
+		eg.Go(func() error {
+			return e.deleteAccessPoints(ctx, subdomain)
+		})
+	}
 	for _, info := range infos {
 		info := info
 		eg.Go(func() error {
-			return e.Terminate(ctx, info.ID)
+			return e.stopTask(ctx, info.ID)
 		})
 	}
-	return eg.Wait()
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	for _, taskdef := range deregistrableTaskDefs(infos, launching) {
+		e.deregisterTaskDefinitionFor(ctx, taskdef, subdomain)
+	}
+	return nil
 }
 
 func (e *ECS) find(ctx context.Context, subdomain string) ([]*Information, error) {
@@ -455,25 +961,58 @@ func (e *ECS) List(ctx context.Context, desiredStatus string) ([]*Information, e
 				continue
 			}
 			info := &Information{
-				ID:         *task.TaskArn,
-				ShortID:    shortenArn(*task.TaskArn),
-				SubDomain:  decodeTagValue(getTagsFromTask(&task, "Subdomain")),
-				GitBranch:  getEnvironmentFromTask(&task, "GIT_BRANCH"),
-				TaskDef:    shortenArn(*task.TaskDefinitionArn),
-				IPAddress:  getIPV4AddressFromTask(&task),
-				LastStatus: *task.LastStatus,
-				Env:        getEnvironmentsFromTask(&task),
-				Tags:       task.Tags,
-				task:       &task,
+				ID:          *task.TaskArn,
+				ShortID:     shortenArn(*task.TaskArn),
+				SubDomain:   decodeTagValue(getTagsFromTask(&task, "Subdomain")),
+				GitBranch:   getEnvironmentFromTask(&task, "GIT_BRANCH"),
+				TaskDef:     shortenArn(*task.TaskDefinitionArn),
+				IPAddress:   taskAddress(&task, e.cfg.Network.PreferIPv6),
+				IPv6Address: getIPV6AddressFromTask(&task),
+				LastStatus:  *task.LastStatus,
+				Env:         getEnvironmentsFromTask(&task),
+				Tags:        task.Tags,
+				Option:      launchOptionFromTags(task.Tags),
+				StopCode:    string(task.StopCode),
+				Service:     serviceNameOfTask(&task),
+				Relaunches:  decodeRelaunchHistory(getTagsFromTask(&task, TagRelaunchHistory)),
+				Protected:   getTagsFromTask(&task, TagProtected) == "true",
+				Cpu:         parseTaskSize(aws.ToString(task.Cpu)),
+				Memory:      parseTaskSize(aws.ToString(task.Memory)),
+				Spot:        aws.ToString(task.CapacityProviderName) == "FARGATE_SPOT",
+				Images:      containerImagesOfTask(&task),
+				task:        &task,
+
+				StoppedReason:         aws.ToString(task.StoppedReason),
+				ExitCodes:             exitCodesOfTask(&task),
+				ExecuteCommandEnabled: task.EnableExecuteCommand,
+			}
+			info.RelaunchCount = relaunchCountOf(task.Tags, info.Relaunches)
+			if task.StoppedAt != nil {
+				stoppedAt := (*task.StoppedAt).In(time.Local)
+				info.StoppedAt = &stoppedAt
 			}
-			if portMap, err := e.portMapInTask(ctx, &task); err != nil {
+			var healthCheck bool
+			if td, err := e.taskDefinitionOf(ctx, &task); err != nil {
 				slog.Warn(f("failed to get portMap in task %s %s", *task.TaskArn, err))
 			} else {
-				info.PortMap = portMap
+				info.PortMap = portMapInTaskDefinition(td)
+				info.OSFamily = osFamilyOf(td)
+				info.NamedPorts = namedPortsInTaskDefinition(td)
+				info.hostPorts = hostPortsOfTask(td, &task)
+				healthCheck = hasHealthCheck(td)
+				if info.Service != "" && len(td.ContainerDefinitions) > 0 {
+					// tasks started by services have environment variables in the task definition
+					info.Env = environmentsInContainerDefinition(&td.ContainerDefinitions[0])
+					info.GitBranch = info.Env["GIT_BRANCH"]
+				}
+			}
+			if healthCheck {
+				info.HealthStatus = string(task.HealthStatus)
 			}
 			if task.StartedAt != nil {
 				info.Created = (*task.StartedAt).In(time.Local)
 			}
+			info.Ready = e.isReady(info, healthCheck)
 			infos = append(infos, info)
 		}
 
@@ -503,12 +1042,29 @@ func shortenArn(arn string) string {
 }
 
 func getIPV4AddressFromTask(task *types.Task) string {
-	if len(task.Attachments) == 0 {
-		return ""
+	return getAttachmentDetail(task, "privateIPv4Address")
+}
+
+func getIPV6AddressFromTask(task *types.Task) string {
+	return getAttachmentDetail(task, "ipv6Address")
+}
+
+// taskAddress returns the address to route requests to the task.
+// IPv6 addresses are used if preferred, or the task has no IPv4 address (e.g. in IPv6-only subnets).
+func taskAddress(task *types.Task, preferIPv6 bool) string {
+	v4, v6 := getIPV4AddressFromTask(task), getIPV6AddressFromTask(task)
+	if v6 != "" && (preferIPv6 || v4 == "") {
+		return v6
 	}
-	for _, d := range task.Attachments[0].Details {
-		if *d.Name == "privateIPv4Address" {
-			return *d.Value
+	return v4
+}
+
+func getAttachmentDetail(task *types.Task, name string) string {
+	for _, a := range task.Attachments {
+		for _, d := range a.Details {
+			if aws.ToString(d.Name) == name {
+				return aws.ToString(d.Value)
+			}
 		}
 	}
 	return ""
@@ -523,6 +1079,54 @@ func getTagsFromTask(task *types.Task, name string) string {
 	return ""
 }
 
+func exitCodesOfTask(task *types.Task) map[string]int32 {
+	var codes map[string]int32
+	for _, c := range task.Containers {
+		if c.ExitCode == nil {
+			continue
+		}
+		if codes == nil {
+			codes = make(map[string]int32, len(task.Containers))
+		}
+		codes[aws.ToString(c.Name)] = *c.ExitCode
+	}
+	return codes
+}
+
+// lastStoppedBySubdomain returns the last stopped task for each subdomain which has no running task.
+// Tasks stopped before the retention are excluded when the retention is positive.
+func lastStoppedBySubdomain(stopped []*Information, running []*Information, retention time.Duration) []*Information {
+	runningSubdomains := make(map[string]struct{}, len(running))
+	for _, info := range running {
+		runningSubdomains[info.SubDomain] = struct{}{}
+	}
+	last := make(map[string]*Information)
+	for _, info := range stopped {
+		if _, ok := runningSubdomains[info.SubDomain]; ok {
+			continue
+		}
+		if retention > 0 && (info.StoppedAt == nil || time.Since(*info.StoppedAt) > retention) {
+			continue
+		}
+		if l, ok := last[info.SubDomain]; ok && stoppedAt(l).After(stoppedAt(info)) {
+			continue
+		}
+		last[info.SubDomain] = info
+	}
+	infos := lo.Values(last)
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].SubDomain < infos[j].SubDomain
+	})
+	return infos
+}
+
+func stoppedAt(info *Information) time.Time {
+	if info.StoppedAt == nil {
+		return info.Created
+	}
+	return *info.StoppedAt
+}
+
 func getEnvironmentFromTask(task *types.Task, name string) string {
 	if len(task.Overrides.ContainerOverrides) == 0 {
 		return ""
@@ -561,8 +1165,7 @@ func decodeTagValue(s string) string {
 	return string(d)
 }
 
-func (e *ECS) portMapInTask(ctx context.Context, task *types.Task) (map[string]int, error) {
-	portMap := make(map[string]int)
+func (e *ECS) taskDefinitionOf(ctx context.Context, task *types.Task) (*types.TaskDefinition, error) {
 	tdArn := *task.TaskDefinitionArn
 	td, err := taskDefinitionCache.Get(tdArn)
 	if err != nil && err == ttlcache.ErrNotFound {
@@ -578,19 +1181,55 @@ func (e *ECS) portMapInTask(ctx context.Context, task *types.Task) (map[string]i
 	} else {
 		slog.Debug(f("cache hit for %s", tdArn))
 	}
-	if _td, ok := td.(*types.TaskDefinition); ok {
-		for _, c := range _td.ContainerDefinitions {
-			for _, m := range c.PortMappings {
-				if m.HostPort == nil {
-					continue
-				}
-				portMap[*c.Name] = int(*m.HostPort)
+	_td, ok := td.(*types.TaskDefinition)
+	if !ok {
+		return nil, fmt.Errorf("invalid type %s", td)
+	}
+	return _td, nil
+}
+
+func portMapInTaskDefinition(td *types.TaskDefinition) map[string]int {
+	portMap := make(map[string]int)
+	for _, c := range td.ContainerDefinitions {
+		for _, m := range c.PortMappings {
+			if m.HostPort == nil {
+				continue
 			}
+			portMap[*c.Name] = int(*m.HostPort)
 		}
-	} else {
-		slog.Warn(f("invalid type %s", td))
 	}
-	return portMap, nil
+	return portMap
+}
+
+// hasHealthCheck reports whether any essential container in the task definition has a health check.
+// The health status of the task is determined by the essential containers.
+func hasHealthCheck(td *types.TaskDefinition) bool {
+	for _, c := range td.ContainerDefinitions {
+		if c.HealthCheck != nil && (c.Essential == nil || *c.Essential) {
+			return true
+		}
+	}
+	return false
+}
+
+// isReady reports whether the task is ready to receive requests.
+// A task which has container health checks is ready after it becomes HEALTHY or the health check timeout is exceeded.
+func (e *ECS) isReady(info *Information, healthCheck bool) bool {
+	if info.LastStatus != statusRunning {
+		return false
+	}
+	if !healthCheck || info.HealthStatus == string(types.HealthStatusHealthy) {
+		return true
+	}
+	timeout := e.cfg.ECS.HealthCheckTimeout
+	if timeout > 0 {
+		timeout = e.cfg.ECS.Windows.timeoutFor(info, timeout)
+	}
+	if timeout > 0 && !info.Created.IsZero() && time.Since(info.Created) > timeout {
+		slog.Warn(f("task %s of subdomain %s is %s, but health check timeout %s exceeded", info.ShortID, info.SubDomain, info.HealthStatus, timeout))
+		return true
+	}
+	return false
 }
 
 func (e *ECS) GetAccessCount(ctx context.Context, subdomain string, duration time.Duration) (int64, error) {
@@ -188,4 +188,139 @@ func TestShouldBePurged(t *testing.T) {
 			}
 		})
 	}
+	t.Run("protected task", func(t *testing.T) {
+		protected := info
+		protected.Protected = true
+		if protected.ShouldBePurged(1*time.Minute, nil, nil) {
+			t.Error("protected task should not be purged")
+		}
+	})
+}
+
+func TestLaunchOptionTags(t *testing.T) {
+	compression := true
+	expiresAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	opt := &mirageecs.LaunchOption{
+		Compression: &compression,
+		CapacityProviderStrategy: mirageecs.CapacityProviderStrategy{
+			{CapacityProvider: aws.String("FARGATE_SPOT"), Weight: 3},
+			{CapacityProvider: aws.String("FARGATE"), Weight: 1, Base: 1},
+		},
+		Protected:  true,
+		Visibility: mirageecs.VisibilityPrivate,
+		ExpiresAt:  &expiresAt,
+		Schedule:   "office-hours",
+	}
+	tags := opt.ToECSTags()
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == mirageecs.TagCapacityProviderStrategy {
+			if v := aws.ToString(tag.Value); v != "FARGATE_SPOT:3:0 FARGATE:1:1" {
+				t.Errorf("unexpected capacity provider strategy tag %s", v)
+			}
+		}
+	}
+	restored := mirageecs.LaunchOptionFromTags(tags)
+	if diff := cmp.Diff(opt, restored, cmpopts.IgnoreUnexported(mirageecs.RewriteRule{})); diff != "" {
+		t.Errorf("Mismatch in LaunchOption (-want +got):\n%s", diff)
+	}
+}
+
+func TestHasHealthCheck(t *testing.T) {
+	healthCheck := &types.HealthCheck{Command: []string{"CMD-SHELL", "curl -f http://localhost/ || exit 1"}}
+	tests := []struct {
+		name     string
+		td       *types.TaskDefinition
+		expected bool
+	}{
+		{
+			name: "no health check",
+			td: &types.TaskDefinition{ContainerDefinitions: []types.ContainerDefinition{
+				{Name: aws.String("app"), Essential: aws.Bool(true)},
+			}},
+			expected: false,
+		},
+		{
+			name: "essential container has health check",
+			td: &types.TaskDefinition{ContainerDefinitions: []types.ContainerDefinition{
+				{Name: aws.String("app"), Essential: aws.Bool(true), HealthCheck: healthCheck},
+			}},
+			expected: true,
+		},
+		{
+			name: "only non-essential container has health check",
+			td: &types.TaskDefinition{ContainerDefinitions: []types.ContainerDefinition{
+				{Name: aws.String("app"), Essential: aws.Bool(true)},
+				{Name: aws.String("sidecar"), Essential: aws.Bool(false), HealthCheck: healthCheck},
+			}},
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mirageecs.HasHealthCheck(tt.td); got != tt.expected {
+				t.Errorf("HasHealthCheck() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLastStoppedBySubdomain(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(-d)
+		return &t
+	}
+	running := []*mirageecs.Information{
+		{ID: "running", SubDomain: "alive"},
+	}
+	stopped := []*mirageecs.Information{
+		{ID: "alive-old", SubDomain: "alive", StoppedAt: at(10 * time.Minute)},
+		{ID: "crash-1", SubDomain: "crash", StoppedAt: at(20 * time.Minute)},
+		{ID: "crash-2", SubDomain: "crash", StoppedAt: at(5 * time.Minute)},
+		{ID: "expired", SubDomain: "expired", StoppedAt: at(2 * time.Hour)},
+	}
+
+	ids := func(infos []*mirageecs.Information) []string {
+		var ids []string
+		for _, info := range infos {
+			ids = append(ids, info.ID)
+		}
+		return ids
+	}
+	if diff := cmp.Diff([]string{"crash-2"}, ids(mirageecs.LastStoppedBySubdomain(stopped, running, time.Hour))); diff != "" {
+		t.Errorf("unexpected stopped tasks (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"crash-2", "expired"}, ids(mirageecs.LastStoppedBySubdomain(stopped, running, 0))); diff != "" {
+		t.Errorf("unexpected stopped tasks without retention (-want +got):\n%s", diff)
+	}
+}
+
+func TestTaskAddress(t *testing.T) {
+	eni := func(details ...string) *types.Task {
+		a := types.Attachment{Type: aws.String("ElasticNetworkInterface")}
+		for i := 0; i < len(details); i += 2 {
+			a.Details = append(a.Details, types.KeyValuePair{Name: aws.String(details[i]), Value: aws.String(details[i+1])})
+		}
+		return &types.Task{Attachments: []types.Attachment{a}}
+	}
+	dualStack := eni("subnetId", "subnet-1", "privateIPv4Address", "10.0.0.1", "ipv6Address", "2001:db8::1")
+	cases := []struct {
+		name       string
+		task       *types.Task
+		preferIPv6 bool
+		want       string
+	}{
+		{"ipv4 only", eni("privateIPv4Address", "10.0.0.1"), true, "10.0.0.1"},
+		{"dual stack", dualStack, false, "10.0.0.1"},
+		{"dual stack preferring ipv6", dualStack, true, "2001:db8::1"},
+		{"ipv6 only", eni("ipv6Address", "2001:db8::1"), false, "2001:db8::1"},
+		{"no attachments", &types.Task{}, false, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := mirageecs.TaskAddress(tc.task, tc.preferIPv6); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
 }
@@ -0,0 +1,263 @@
+package mirageecs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/efs"
+	efsTypes "github.com/aws/aws-sdk-go-v2/service/efs/types"
+)
+
+const (
+	DefaultEFSRootDirectory = "/mirage"
+	DefaultEFSPermissions   = "755"
+
+	efsVolumeName = "mirage-efs"
+
+	accessPointWaitRetries = 30
+)
+
+// EFSCfg configures an EFS volume mounted into launched tasks.
+// A directory for each subdomain is created under the root directory via an EFS access point.
+type EFSCfg struct {
+	FileSystemID      string   `yaml:"file_system_id"`
+	RootDirectory     string   `yaml:"root_directory"`
+	ContainerPath     string   `yaml:"container_path"`
+	Containers        []string `yaml:"containers"`
+	ReadOnly          bool     `yaml:"read_only"`
+	UID               int64    `yaml:"uid"`
+	GID               int64    `yaml:"gid"`
+	Permissions       string   `yaml:"permissions"`
+	DeleteOnTerminate bool     `yaml:"delete_on_terminate"`
+}
+
+func (c *EFSCfg) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.FileSystemID == "" {
+		return fmt.Errorf("efs.file_system_id is required")
+	}
+	if c.ContainerPath == "" {
+		return fmt.Errorf("efs.container_path is required")
+	}
+	if c.RootDirectory == "" {
+		c.RootDirectory = DefaultEFSRootDirectory
+	}
+	if !path.IsAbs(c.RootDirectory) {
+		return fmt.Errorf("efs.root_directory must be an absolute path: %s", c.RootDirectory)
+	}
+	if c.Permissions == "" {
+		c.Permissions = DefaultEFSPermissions
+	}
+	if _, err := strconv.ParseUint(c.Permissions, 8, 32); err != nil {
+		return fmt.Errorf("invalid efs.permissions: %s", c.Permissions)
+	}
+	return nil
+}
+
+// directoryFor returns a path of the directory for the subdomain.
+func (c *EFSCfg) directoryFor(subdomain string) string {
+	return path.Join(c.RootDirectory, invalidServiceNameChars.ReplaceAllString(subdomain, "_"))
+}
+
+func (c *EFSCfg) mountable(container string) bool {
+	if len(c.Containers) == 0 {
+		return true
+	}
+	for _, name := range c.Containers {
+		if name == container {
+			return true
+		}
+	}
+	return false
+}
+
+// efsModifier returns a modifier which mounts the directory for the subdomain.
+func (e *ECS) efsModifier(ctx context.Context, subdomain string) (taskDefinitionModifier, error) {
+	ap, err := e.ensureAccessPoint(ctx, subdomain)
+	if err != nil {
+		return nil, err
+	}
+	return efsVolumeModifier(e.cfg.ECS.EFS, aws.ToString(ap.AccessPointId)), nil
+}
+
+func efsVolumeModifier(c *EFSCfg, accessPointID string) taskDefinitionModifier {
+	return func(in *ecs.RegisterTaskDefinitionInput) {
+		in.Volumes = append(in.Volumes, types.Volume{
+			Name: aws.String(efsVolumeName),
+			EfsVolumeConfiguration: &types.EFSVolumeConfiguration{
+				FileSystemId:      aws.String(c.FileSystemID),
+				TransitEncryption: types.EFSTransitEncryptionEnabled,
+				AuthorizationConfig: &types.EFSAuthorizationConfig{
+					AccessPointId: aws.String(accessPointID),
+				},
+			},
+		})
+		containers := make([]types.ContainerDefinition, 0, len(in.ContainerDefinitions))
+		for _, cd := range in.ContainerDefinitions {
+			if c.mountable(aws.ToString(cd.Name)) {
+				cd.MountPoints = append(cd.MountPoints[:len(cd.MountPoints):len(cd.MountPoints)], types.MountPoint{
+					SourceVolume:  aws.String(efsVolumeName),
+					ContainerPath: aws.String(c.ContainerPath),
+					ReadOnly:      aws.Bool(c.ReadOnly),
+				})
+			}
+			containers = append(containers, cd)
+		}
+		in.ContainerDefinitions = containers
+	}
+}
+
+// findAccessPoints returns access points for the subdomain.
+func (e *ECS) findAccessPoints(ctx context.Context, subdomain string) ([]efsTypes.AccessPointDescription, error) {
+	var aps []efsTypes.AccessPointDescription
+	p := efs.NewDescribeAccessPointsPaginator(e.efsSvc, &efs.DescribeAccessPointsInput{
+		FileSystemId: aws.String(e.cfg.ECS.EFS.FileSystemID),
+	})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe access points: %w", err)
+		}
+		for _, ap := range out.AccessPoints {
+			if getEFSTag(ap.Tags, TagManagedBy) != TagValueMirage {
+				continue
+			}
+			if decodeTagValue(getEFSTag(ap.Tags, TagSubdomain)) != subdomain {
+				continue
+			}
+			if ap.LifeCycleState == efsTypes.LifeCycleStateDeleting || ap.LifeCycleState == efsTypes.LifeCycleStateDeleted {
+				continue
+			}
+			aps = append(aps, ap)
+		}
+	}
+	return aps, nil
+}
+
+// accessPointClientToken returns an idempotency token for creating the access point of the subdomain.
+func accessPointClientToken(fileSystemID, subdomain string) string {
+	sum := sha256.Sum256([]byte(fileSystemID + "/" + subdomain))
+	return hex.EncodeToString(sum[:]) // 64 characters at most
+}
+
+// ensureAccessPoint returns an access point for the subdomain. It creates the access point if not exists.
+func (e *ECS) ensureAccessPoint(ctx context.Context, subdomain string) (*efsTypes.AccessPointDescription, error) {
+	// tasks of the subdomain are launched concurrently
+	mu, _ := e.accessPointLocks.LoadOrStore(subdomain, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	c := e.cfg.ECS.EFS
+	aps, err := e.findAccessPoints(ctx, subdomain)
+	if err != nil {
+		return nil, err
+	}
+	if len(aps) > 0 {
+		return e.waitAccessPoint(ctx, &aps[0])
+	}
+	dir := c.directoryFor(subdomain)
+	slog.Info(f("creating EFS access point for %s: %s", subdomain, dir))
+	out, err := e.efsSvc.CreateAccessPoint(ctx, &efs.CreateAccessPointInput{
+		FileSystemId: aws.String(c.FileSystemID),
+		ClientToken:  aws.String(accessPointClientToken(c.FileSystemID, subdomain)),
+		PosixUser: &efsTypes.PosixUser{
+			Uid: aws.Int64(c.UID),
+			Gid: aws.Int64(c.GID),
+		},
+		RootDirectory: &efsTypes.RootDirectory{
+			Path: aws.String(dir),
+			CreationInfo: &efsTypes.CreationInfo{
+				OwnerUid:    aws.Int64(c.UID),
+				OwnerGid:    aws.Int64(c.GID),
+				Permissions: aws.String(c.Permissions),
+			},
+		},
+		Tags: []efsTypes.Tag{
+			{Key: aws.String("Name"), Value: aws.String("mirage-" + subdomain)},
+			{Key: aws.String(TagManagedBy), Value: aws.String(TagValueMirage)},
+			{Key: aws.String(TagSubdomain), Value: aws.String(encodeTagValue(subdomain))},
+		},
+	})
+	var exists *efsTypes.AccessPointAlreadyExists
+	if errors.As(err, &exists) {
+		// created by another mirage-ecs with the same client token
+		slog.Info(f("EFS access point %s already exists for %s", aws.ToString(exists.AccessPointId), subdomain))
+		return e.waitAccessPoint(ctx, &efsTypes.AccessPointDescription{AccessPointId: exists.AccessPointId})
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to create access point: %w", err)
+	}
+	return e.waitAccessPoint(ctx, &efsTypes.AccessPointDescription{
+		AccessPointId:  out.AccessPointId,
+		LifeCycleState: out.LifeCycleState,
+	})
+}
+
+// waitAccessPoint waits for the access point to become available, because tasks can't mount it until then.
+func (e *ECS) waitAccessPoint(ctx context.Context, ap *efsTypes.AccessPointDescription) (*efsTypes.AccessPointDescription, error) {
+	id := aws.ToString(ap.AccessPointId)
+	for i := 0; ap.LifeCycleState != efsTypes.LifeCycleStateAvailable; i++ {
+		switch ap.LifeCycleState {
+		case efsTypes.LifeCycleStateDeleting, efsTypes.LifeCycleStateDeleted, efsTypes.LifeCycleStateError:
+			return nil, fmt.Errorf("EFS access point %s is %s", id, ap.LifeCycleState)
+		}
+		if i >= accessPointWaitRetries {
+			return nil, fmt.Errorf("EFS access point %s did not become available (%s)", id, ap.LifeCycleState)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+		desc, err := e.efsSvc.DescribeAccessPoints(ctx, &efs.DescribeAccessPointsInput{
+			AccessPointId: ap.AccessPointId,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe access point: %w", err)
+		}
+		if len(desc.AccessPoints) > 0 {
+			ap = &desc.AccessPoints[0]
+		}
+	}
+	slog.Info(f("EFS access point %s is %s", id, ap.LifeCycleState))
+	return ap, nil
+}
+
+// deleteAccessPoints deletes access points for the subdomain.
+// Files in the directory are not deleted because EFS API can't delete files.
+func (e *ECS) deleteAccessPoints(ctx context.Context, subdomain string) error {
+	aps, err := e.findAccessPoints(ctx, subdomain)
+	if err != nil {
+		return err
+	}
+	for _, ap := range aps {
+		slog.Info(f("delete EFS access point %s for %s", aws.ToString(ap.AccessPointId), subdomain))
+		if _, err := e.efsSvc.DeleteAccessPoint(ctx, &efs.DeleteAccessPointInput{
+			AccessPointId: ap.AccessPointId,
+		}); err != nil {
+			return fmt.Errorf("failed to delete access point: %w", err)
+		}
+	}
+	return nil
+}
+
+func getEFSTag(tags []efsTypes.Tag, name string) string {
+	for _, t := range tags {
+		if aws.ToString(t.Key) == name {
+			return aws.ToString(t.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+package mirageecs_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestEFSConfig(t *testing.T) {
+	c := &mirageecs.EFSCfg{FileSystemID: "fs-12345678", ContainerPath: "/data"}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.RootDirectory != mirageecs.DefaultEFSRootDirectory {
+		t.Errorf("unexpected root directory: %s", c.RootDirectory)
+	}
+	if d := c.DirectoryFor("feature-*"); d != "/mirage/feature-_" {
+		t.Errorf("unexpected directory: %s", d)
+	}
+
+	for _, invalid := range []*mirageecs.EFSCfg{
+		{ContainerPath: "/data"},
+		{FileSystemID: "fs-12345678"},
+		{FileSystemID: "fs-12345678", ContainerPath: "/data", RootDirectory: "mirage"},
+		{FileSystemID: "fs-12345678", ContainerPath: "/data", Permissions: "999"},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("expected error for %#v", invalid)
+		}
+	}
+}
+
+func TestEFSVolume(t *testing.T) {
+	c := &mirageecs.EFSCfg{FileSystemID: "fs-12345678", ContainerPath: "/data", Containers: []string{"app"}}
+	in := &ecs.RegisterTaskDefinitionInput{
+		ContainerDefinitions: []types.ContainerDefinition{
+			{Name: aws.String("app")},
+			{Name: aws.String("nginx")},
+		},
+	}
+	c.ApplyVolume(in, "fsap-12345678")
+	if len(in.Volumes) != 1 {
+		t.Fatalf("unexpected volumes: %#v", in.Volumes)
+	}
+	v := in.Volumes[0].EfsVolumeConfiguration
+	if aws.ToString(v.FileSystemId) != "fs-12345678" || aws.ToString(v.AuthorizationConfig.AccessPointId) != "fsap-12345678" {
+		t.Errorf("unexpected volume: %#v", v)
+	}
+	if len(in.ContainerDefinitions[0].MountPoints) != 1 {
+		t.Errorf("app must mount the volume")
+	}
+	if len(in.ContainerDefinitions[1].MountPoints) != 0 {
+		t.Errorf("nginx must not mount the volume")
+	}
+}
+
+func TestAccessPointClientToken(t *testing.T) {
+	token := mirageecs.AccessPointClientToken("fs-12345678", "feature")
+	if len(token) > 64 {
+		t.Errorf("client token is too long: %d", len(token))
+	}
+	if token != mirageecs.AccessPointClientToken("fs-12345678", "feature") {
+		t.Error("client token should be stable for the same subdomain")
+	}
+	if token == mirageecs.AccessPointClientToken("fs-12345678", "other") {
+		t.Error("client token should differ by subdomain")
+	}
+	if token == mirageecs.AccessPointClientToken("fs-87654321", "feature") {
+		t.Error("client token should differ by file system")
+	}
+}
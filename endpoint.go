@@ -0,0 +1,106 @@
+package mirageecs
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/efs"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/firehose"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/servicediscovery"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// endpointServiceIDs maps keys of ecs.endpoints to service IDs of the SDK.
+// Keys are endpoint prefixes of services (e.g. "logs" of logs.us-east-1.amazonaws.com).
+var endpointServiceIDs = map[string]string{
+	"acm":                  acm.ServiceID,
+	"monitoring":           cloudwatch.ServiceID,
+	"logs":                 cloudwatchlogs.ServiceID,
+	"ecs":                  ecs.ServiceID,
+	"elasticfilesystem":    efs.ServiceID,
+	"elasticloadbalancing": elbv2.ServiceID,
+	"firehose":             firehose.ServiceID,
+	"route53":              route53.ServiceID,
+	"s3":                   s3.ServiceID,
+	"servicediscovery":     servicediscovery.ServiceID,
+	"sqs":                  sqs.ServiceID,
+	"ssm":                  ssm.ServiceID,
+}
+
+// Endpoints are custom endpoint URLs of AWS services used by mirage-ecs.
+// e.g. URLs of VPC interface endpoints without private DNS names, or endpoints of other partitions.
+type Endpoints map[string]string
+
+func (e Endpoints) validate() error {
+	for _, key := range e.keys() {
+		if _, ok := endpointServiceIDs[key]; !ok {
+			return fmt.Errorf("unknown service of ecs.endpoints: %s (available: %v)", key, availableEndpoints())
+		}
+		u, err := url.Parse(e[key])
+		if err != nil {
+			return fmt.Errorf("invalid ecs.endpoints.%s: %w", key, err)
+		}
+		if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid ecs.endpoints.%s: %s must be an absolute http(s) URL", key, e[key])
+		}
+	}
+	return nil
+}
+
+func (e Endpoints) keys() []string {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func availableEndpoints() []string {
+	keys := make([]string, 0, len(endpointServiceIDs))
+	for k := range endpointServiceIDs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// resolver returns an endpoint resolver which resolves configured endpoints.
+// Other services fall back to the default endpoints of the region.
+func (e Endpoints) resolver() aws.EndpointResolverWithOptions {
+	urls := make(map[string]string, len(e))
+	for key, u := range e {
+		urls[endpointServiceIDs[key]] = u
+	}
+	return aws.EndpointResolverWithOptionsFunc(func(service, region string, _ ...interface{}) (aws.Endpoint, error) {
+		u, ok := urls[service]
+		if !ok {
+			return aws.Endpoint{}, &aws.EndpointNotFoundError{}
+		}
+		return aws.Endpoint{
+			URL:           u,
+			SigningRegion: region,
+			Source:        aws.EndpointSourceCustom,
+		}, nil
+	})
+}
+
+// applyAWSConfig applies the region and endpoints of the config file to the AWS config.
+func (c *Config) applyAWSConfig() {
+	if c.ECS.Region != "" {
+		c.awscfg.Region = c.ECS.Region
+	}
+	if len(c.ECS.Endpoints) > 0 {
+		c.awscfg.EndpointResolverWithOptions = c.ECS.Endpoints.resolver()
+	}
+}
@@ -0,0 +1,57 @@
+package mirageecs_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestEndpointsValidate(t *testing.T) {
+	cases := []struct {
+		name      string
+		endpoints mirageecs.Endpoints
+		ok        bool
+	}{
+		{"empty", nil, true},
+		{"valid", mirageecs.Endpoints{"ecs": "https://vpce-0123.ecs.us-east-1.vpce.amazonaws.com", "logs": "http://localhost:4566"}, true},
+		{"unknown service", mirageecs.Endpoints{"dynamodb": "https://dynamodb.example.com"}, false},
+		{"relative url", mirageecs.Endpoints{"ecs": "ecs.example.com"}, false},
+		{"invalid scheme", mirageecs.Endpoints{"ecs": "ftp://ecs.example.com"}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.endpoints.Validate()
+			if c.ok && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !c.ok && err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestEndpointsResolver(t *testing.T) {
+	r := mirageecs.Endpoints{"ecs": "https://vpce-0123.ecs.cn-north-1.vpce.amazonaws.com.cn"}.Resolver()
+	ep, err := r.ResolveEndpoint(ecs.ServiceID, "cn-north-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep.URL != "https://vpce-0123.ecs.cn-north-1.vpce.amazonaws.com.cn" {
+		t.Errorf("unexpected url: %s", ep.URL)
+	}
+	if ep.SigningRegion != "cn-north-1" {
+		t.Errorf("unexpected signing region: %s", ep.SigningRegion)
+	}
+
+	_, err = r.ResolveEndpoint(cloudwatchlogs.ServiceID, "cn-north-1")
+	var notFound *aws.EndpointNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Errorf("expected fallback to the default endpoint, got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	HookEventErrorAlert     = "error_alert"
+	HookEventErrorRecovered = "error_recovered"
+
+	DefaultErrorAlertWindow      = 5 * time.Minute
+	DefaultErrorAlertThreshold   = 0.5
+	DefaultErrorAlertMinRequests = 10
+
+	// errorAlertInterval is an interval to check error rates. It is the unit of access counters.
+	errorAlertInterval = time.Minute
+)
+
+// ErrorAlert alerts environments whose rate of 5xx responses (and failures of roundtrips) by the reverse proxy exceeds the threshold.
+type ErrorAlert struct {
+	// Window is a time range to calculate error rates. Default is 5m.
+	Window time.Duration `yaml:"window"`
+	// Threshold is a rate of errors to alert, between 0 and 1. Default is 0.5.
+	Threshold float64 `yaml:"threshold"`
+	// MinRequests is the minimum number of responses in the window to alert. Default is 10.
+	MinRequests int64 `yaml:"min_requests"`
+	// MarkUnhealthy shows alerting environments as unhealthy in the list.
+	MarkUnhealthy bool `yaml:"mark_unhealthy"`
+	// Hooks are webhooks (e.g. Slack incoming webhooks) or SNS topics to send alerts and recoveries.
+	Hooks []*Hook `yaml:"hooks"`
+}
+
+func (a *ErrorAlert) validate() error {
+	if a == nil {
+		return nil
+	}
+	if a.Window == 0 {
+		a.Window = DefaultErrorAlertWindow
+	}
+	if a.Window < errorAlertInterval {
+		return fmt.Errorf("error_alert.window must be at least %s: %s", errorAlertInterval, a.Window)
+	}
+	if a.Threshold == 0 {
+		a.Threshold = DefaultErrorAlertThreshold
+	}
+	if a.Threshold < 0 || a.Threshold > 1 {
+		return fmt.Errorf("error_alert.threshold must be between 0 and 1: %f", a.Threshold)
+	}
+	if a.MinRequests == 0 {
+		a.MinRequests = DefaultErrorAlertMinRequests
+	}
+	if a.MinRequests < 0 {
+		return fmt.Errorf("error_alert.min_requests must be positive: %d", a.MinRequests)
+	}
+	if len(a.Hooks) == 0 && !a.MarkUnhealthy {
+		return fmt.Errorf("error_alert requires hooks or mark_unhealthy")
+	}
+	for _, hook := range a.Hooks {
+		if err := hook.validate(); err != nil {
+			return fmt.Errorf("error_alert.hooks: %w", err)
+		}
+		if hook.Wait {
+			return fmt.Errorf("error_alert.hooks: wait is supported only by pre_terminate hooks")
+		}
+	}
+	return nil
+}
+
+// ErrorAlertState is a state of the alerting environment.
+type ErrorAlertState struct {
+	// Since is a time when the alert fired.
+	Since    time.Time `json:"since"`
+	Errors   int64     `json:"errors"`
+	Requests int64     `json:"requests"`
+	Rate     float64   `json:"rate"`
+}
+
+// Percent returns the rate of errors in percent.
+func (s *ErrorAlertState) Percent() float64 {
+	return s.Rate * 100
+}
+
+// countErrors returns the number of errors (5xx and failures of roundtrips) and all responses.
+func countErrors(stats []*AccessStats) (errors, requests int64) {
+	for _, st := range stats {
+		errors += st.Status["5xx"] + st.Status["error"]
+		requests += st.Count
+	}
+	return errors, requests
+}
+
+// errorAlerter keeps alerting environments.
+type errorAlerter struct {
+	cfg    *Config
+	client *hookClient
+
+	mu     sync.Mutex
+	alerts map[string]*ErrorAlertState // subdomain -> state of the alert
+}
+
+func newErrorAlerter(cfg *Config) *errorAlerter {
+	if cfg.ErrorAlert == nil {
+		return nil
+	}
+	return &errorAlerter{
+		cfg:    cfg,
+		client: newHookClient(cfg, cfg.ErrorAlert.Hooks),
+		alerts: make(map[string]*ErrorAlertState),
+	}
+}
+
+// check checks error rates of the subdomains, and sends alerts and recoveries. Alerts of other subdomains are dropped.
+func (a *errorAlerter) check(ctx context.Context, subdomains []string, stats func(subdomain string, since time.Time) []*AccessStats, now time.Time) {
+	c := a.cfg.ErrorAlert
+	var payloads []*HookPayload
+	a.mu.Lock()
+	current := make(map[string]*ErrorAlertState, len(a.alerts))
+	for _, subdomain := range subdomains {
+		errors, requests := countErrors(stats(subdomain, now.Add(-c.Window)))
+		alert, alerting := a.alerts[subdomain]
+		var rate float64
+		if requests > 0 {
+			rate = float64(errors) / float64(requests)
+		}
+		switch {
+		case requests >= c.MinRequests && rate >= c.Threshold:
+			if !alerting {
+				alert = &ErrorAlertState{Since: now}
+				slog.WarnContext(ctx, f("error rate of %s is %.1f%% (%d/%d) in %s", subdomain, rate*100, errors, requests, c.Window))
+			}
+			alert.Errors, alert.Requests, alert.Rate = errors, requests, rate
+			current[subdomain] = alert
+			if !alerting {
+				// the state is updated by later checks while hooks are called
+				s := *alert
+				payloads = append(payloads, a.payload(HookEventErrorAlert, subdomain, &s, now,
+					fmt.Sprintf("%s returns errors: %.1f%% of %d responses in %s", a.host(subdomain), rate*100, requests, c.Window)))
+			}
+		case alerting && requests == 0:
+			// no responses to tell whether it has recovered
+			slog.InfoContext(ctx, f("error alert of %s is cleared without responses", subdomain))
+		case alerting && requests < c.MinRequests:
+			// too few responses to tell whether it has recovered
+			current[subdomain] = alert
+		case alerting:
+			slog.InfoContext(ctx, f("error rate of %s has recovered to %.1f%% (%d/%d)", subdomain, rate*100, errors, requests))
+			recovered := &ErrorAlertState{Since: alert.Since, Errors: errors, Requests: requests, Rate: rate}
+			payloads = append(payloads, a.payload(HookEventErrorRecovered, subdomain, recovered, now,
+				fmt.Sprintf("%s has recovered: %.1f%% errors of %d responses in %s", a.host(subdomain), rate*100, requests, c.Window)))
+		}
+	}
+	a.alerts = current
+	a.mu.Unlock()
+
+	for _, p := range payloads {
+		for _, hook := range c.Hooks {
+			go a.client.call(context.Background(), hook, p)
+		}
+	}
+}
+
+func (a *errorAlerter) host(subdomain string) string {
+	return subdomain + a.cfg.Host.ReverseProxySuffix
+}
+
+func (a *errorAlerter) payload(event, subdomain string, alert *ErrorAlertState, now time.Time, text string) *HookPayload {
+	return &HookPayload{
+		Event:      event,
+		Subdomain:  subdomain,
+		Host:       a.host(subdomain),
+		Time:       now,
+		ErrorAlert: alert,
+		Text:       text,
+	}
+}
+
+// apply sets states of alerts to running environments when error_alert.mark_unhealthy is enabled.
+func (a *errorAlerter) apply(infos []*Information) {
+	if a == nil || !a.cfg.ErrorAlert.MarkUnhealthy {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, info := range infos {
+		if info.LastStatus != statusRunning {
+			continue
+		}
+		if alert, ok := a.alerts[info.SubDomain]; ok {
+			s := *alert
+			info.ErrorAlert = &s
+		}
+	}
+}
+
+// RunErrorAlerter checks error rates of environments by the reverse proxy periodically.
+func (m *Mirage) RunErrorAlerter(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	tk := time.NewTicker(errorAlertInterval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			slog.Debug("RunErrorAlerter() is done")
+			return
+		}
+		m.WebApi.errorAlerts.check(ctx, m.ReverseProxy.Subdomains(), m.ReverseProxy.AccessStats, time.Now())
+	}
+}
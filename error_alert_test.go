@@ -0,0 +1,120 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestErrorAlertValidate(t *testing.T) {
+	hooks := []*mirageecs.Hook{{URL: "https://hooks.slack.com/services/xxx"}}
+	cases := []struct {
+		name  string
+		alert *mirageecs.ErrorAlert
+		ok    bool
+	}{
+		{"hooks", &mirageecs.ErrorAlert{Hooks: hooks}, true},
+		{"mark_unhealthy", &mirageecs.ErrorAlert{MarkUnhealthy: true}, true},
+		{"neither hooks nor mark_unhealthy", &mirageecs.ErrorAlert{}, false},
+		{"short window", &mirageecs.ErrorAlert{Hooks: hooks, Window: 30 * time.Second}, false},
+		{"threshold over 1", &mirageecs.ErrorAlert{Hooks: hooks, Threshold: 50}, false},
+		{"wait hook", &mirageecs.ErrorAlert{Hooks: []*mirageecs.Hook{{URL: "https://example.com", Wait: true}}}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.alert.Validate()
+			if c.ok && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if !c.ok && err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+	a := &mirageecs.ErrorAlert{MarkUnhealthy: true}
+	a.Validate()
+	if a.Window != mirageecs.DefaultErrorAlertWindow || a.Threshold != mirageecs.DefaultErrorAlertThreshold || a.MinRequests != mirageecs.DefaultErrorAlertMinRequests {
+		t.Errorf("unexpected defaults: %#v", a)
+	}
+}
+
+func TestErrorAlerter(t *testing.T) {
+	payloads := make(chan *mirageecs.HookPayload, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p mirageecs.HookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Error(err)
+		}
+		payloads <- &p
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ErrorAlert = &mirageecs.ErrorAlert{
+		MarkUnhealthy: true,
+		Hooks:         []*mirageecs.Hook{{URL: srv.URL}},
+	}
+	if err := cfg.ErrorAlert.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	a := mirageecs.NewErrorAlerter(cfg)
+
+	stats := map[string]*mirageecs.AccessStats{}
+	statsOf := func(subdomain string, since time.Time) []*mirageecs.AccessStats {
+		if st, ok := stats[subdomain]; ok {
+			return []*mirageecs.AccessStats{st}
+		}
+		return nil
+	}
+	now := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	subdomains := []string{"crash", "fine", "quiet"}
+	stats["crash"] = &mirageecs.AccessStats{Count: 20, Status: map[string]int64{"2xx": 5, "5xx": 10, "error": 5}}
+	stats["fine"] = &mirageecs.AccessStats{Count: 100, Status: map[string]int64{"2xx": 95, "5xx": 5}}
+	stats["quiet"] = &mirageecs.AccessStats{Count: 3, Status: map[string]int64{"5xx": 3}}
+	a.Check(ctx, subdomains, statsOf, now)
+
+	p := receivePayload(t, payloads)
+	if p.Event != mirageecs.HookEventErrorAlert || p.Subdomain != "crash" || p.ErrorAlert == nil || p.ErrorAlert.Rate != 0.75 {
+		t.Errorf("unexpected payload %#v", p)
+	}
+	if p.Text != "crash.localtest.me returns errors: 75.0% of 20 responses in 5m0s" {
+		t.Errorf("unexpected text %q", p.Text)
+	}
+	infos := []*mirageecs.Information{
+		{SubDomain: "crash", LastStatus: "RUNNING"},
+		{SubDomain: "fine", LastStatus: "RUNNING"},
+	}
+	a.Apply(infos)
+	if infos[0].ErrorAlert == nil || !infos[0].ErrorAlert.Since.Equal(now) || infos[1].ErrorAlert != nil {
+		t.Errorf("only crash must be unhealthy: %#v %#v", infos[0].ErrorAlert, infos[1].ErrorAlert)
+	}
+
+	// alerted only once while errors continue
+	a.Check(ctx, subdomains, statsOf, now.Add(time.Minute))
+	select {
+	case p := <-payloads:
+		t.Errorf("unexpected alert %#v", p)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	stats["crash"] = &mirageecs.AccessStats{Count: 50, Status: map[string]int64{"2xx": 49, "5xx": 1}}
+	a.Check(ctx, subdomains, statsOf, now.Add(2*time.Minute))
+	p = receivePayload(t, payloads)
+	if p.Event != mirageecs.HookEventErrorRecovered || p.Subdomain != "crash" || p.ErrorAlert.Requests != 50 || !p.ErrorAlert.Since.Equal(now) {
+		t.Errorf("unexpected payload %#v", p)
+	}
+	infos[0].ErrorAlert = nil
+	a.Apply(infos)
+	if infos[0].ErrorAlert != nil {
+		t.Errorf("recovered environment must not be unhealthy: %#v", infos[0].ErrorAlert)
+	}
+}
@@ -0,0 +1,206 @@
+package mirageecs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebTypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snsTypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+const (
+	EventEnvironmentLaunched   = "mirage.environment.launched"
+	EventEnvironmentTerminated = "mirage.environment.terminated"
+	EventEnvironmentPurged     = "mirage.environment.purged"
+	EventEnvironmentFailed     = "mirage.environment.failed"
+
+	DefaultEventSource = "mirage-ecs"
+)
+
+// Events publishes lifecycle events of environments to an EventBridge event bus and (or) an SNS topic.
+type Events struct {
+	// EventBusName is a name or an ARN of the EventBridge event bus. e.g. default
+	EventBusName string `yaml:"event_bus_name"`
+	// SNSTopicArn is an ARN of the SNS topic.
+	SNSTopicArn string `yaml:"sns_topic_arn"`
+	// Source is a source of events. Default is mirage-ecs.
+	Source string `yaml:"source"`
+}
+
+func (e *Events) validate() error {
+	if e == nil {
+		return nil
+	}
+	if e.EventBusName == "" && e.SNSTopicArn == "" {
+		return fmt.Errorf("events.event_bus_name or events.sns_topic_arn is required")
+	}
+	if e.Source == "" {
+		e.Source = DefaultEventSource
+	}
+	return nil
+}
+
+// EnvironmentEvent is a detail of a lifecycle event of the environment.
+type EnvironmentEvent struct {
+	Subdomain  string            `json:"subdomain"`
+	Host       string            `json:"host"`
+	Taskdefs   []string          `json:"taskdefs,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Time       time.Time         `json:"time"`
+	// Error is the reason of the failure.
+	Error string `json:"error,omitempty"`
+}
+
+// snsEventMessage is a message of events published to the SNS topic, in the same shape as EventBridge events.
+type snsEventMessage struct {
+	DetailType string            `json:"detail-type"`
+	Source     string            `json:"source"`
+	Detail     *EnvironmentEvent `json:"detail"`
+}
+
+type purgeContextKey struct{}
+
+// withPurge marks terminations in the context as purges.
+func withPurge(ctx context.Context) context.Context {
+	return context.WithValue(ctx, purgeContextKey{}, true)
+}
+
+func isPurge(ctx context.Context) bool {
+	v, _ := ctx.Value(purgeContextKey{}).(bool)
+	return v
+}
+
+// eventPublisher publishes events to the event bus and the topic.
+type eventPublisher struct {
+	cfg *Events
+	eb  *eventbridge.Client
+	sns *sns.Client
+}
+
+func newEventPublisher(cfg *Config) *eventPublisher {
+	p := &eventPublisher{cfg: cfg.Events}
+	if cfg.Events.EventBusName != "" {
+		p.eb = eventbridge.NewFromConfig(*cfg.awscfg)
+	}
+	if cfg.Events.SNSTopicArn != "" {
+		p.sns = sns.NewFromConfig(*cfg.awscfg)
+	}
+	return p
+}
+
+// publish publishes the event. Errors are logged and returned.
+func (p *eventPublisher) publish(ctx context.Context, detailType string, ev *EnvironmentEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, APICallTimeout)
+	defer cancel()
+	detail, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if p.eb != nil {
+		if err := p.putEvent(ctx, detailType, string(detail)); err != nil {
+			slog.WarnContext(ctx, f("failed to put event %s of subdomain %s to %s: %s", detailType, ev.Subdomain, p.cfg.EventBusName, err))
+			return err
+		}
+	}
+	if p.sns != nil {
+		b, err := json.Marshal(snsEventMessage{DetailType: detailType, Source: p.cfg.Source, Detail: ev})
+		if err != nil {
+			return err
+		}
+		_, err = p.sns.Publish(ctx, &sns.PublishInput{
+			TopicArn: aws.String(p.cfg.SNSTopicArn),
+			Message:  aws.String(string(b)),
+			// subscriptions can filter events by the detail-type
+			MessageAttributes: map[string]snsTypes.MessageAttributeValue{
+				"detail-type": {DataType: aws.String("String"), StringValue: aws.String(detailType)},
+			},
+		})
+		if err != nil {
+			slog.WarnContext(ctx, f("failed to publish event %s of subdomain %s to %s: %s", detailType, ev.Subdomain, p.cfg.SNSTopicArn, err))
+			return err
+		}
+	}
+	slog.DebugContext(ctx, f("published event %s of subdomain %s", detailType, ev.Subdomain))
+	return nil
+}
+
+func (p *eventPublisher) putEvent(ctx context.Context, detailType, detail string) error {
+	out, err := p.eb.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []ebTypes.PutEventsRequestEntry{
+			{
+				EventBusName: aws.String(p.cfg.EventBusName),
+				Source:       aws.String(p.cfg.Source),
+				DetailType:   aws.String(detailType),
+				Detail:       aws.String(detail),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if out.FailedEntryCount > 0 && len(out.Entries) > 0 {
+		e := out.Entries[0]
+		return fmt.Errorf("%s: %s", aws.ToString(e.ErrorCode), aws.ToString(e.ErrorMessage))
+	}
+	return nil
+}
+
+// eventRunner is a TaskRunner which publishes lifecycle events of environments.
+type eventRunner struct {
+	TaskRunner
+
+	cfg       *Config
+	publisher *eventPublisher
+}
+
+func newEventRunner(cfg *Config, runner TaskRunner) TaskRunner {
+	return &eventRunner{
+		TaskRunner: runner,
+		cfg:        cfg,
+		publisher:  newEventPublisher(cfg),
+	}
+}
+
+func (r *eventRunner) Launch(ctx context.Context, subdomain string, param TaskParameter, opt *LaunchOption, taskdefs ...string) error {
+	err := r.TaskRunner.Launch(ctx, subdomain, param, opt, taskdefs...)
+	ev := r.event(subdomain, taskdefs, param)
+	detailType := EventEnvironmentLaunched
+	if err != nil {
+		detailType = EventEnvironmentFailed
+		ev.Error = err.Error()
+	}
+	go r.publisher.publish(context.WithoutCancel(ctx), detailType, ev)
+	return err
+}
+
+func (r *eventRunner) TerminateBySubdomain(ctx context.Context, subdomain string) error {
+	taskdefs, param, err := environmentOf(ctx, r.TaskRunner, r.cfg, subdomain)
+	if err != nil {
+		return err
+	}
+	if err := r.TaskRunner.TerminateBySubdomain(ctx, subdomain); err != nil {
+		return err
+	}
+	detailType := EventEnvironmentTerminated
+	if isPurge(ctx) {
+		detailType = EventEnvironmentPurged
+	}
+	go r.publisher.publish(context.WithoutCancel(ctx), detailType, r.event(subdomain, taskdefs, param))
+	return nil
+}
+
+func (r *eventRunner) event(subdomain string, taskdefs []string, param TaskParameter) *EnvironmentEvent {
+	return &EnvironmentEvent{
+		Subdomain:  subdomain,
+		Host:       subdomain + r.cfg.Host.ReverseProxySuffix,
+		Taskdefs:   taskdefs,
+		Parameters: param,
+		Time:       time.Now(),
+	}
+}
@@ -0,0 +1,116 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/google/go-cmp/cmp"
+)
+
+type putEventsRequest struct {
+	Entries []struct {
+		EventBusName string
+		Source       string
+		DetailType   string
+		Detail       string
+	}
+}
+
+func TestEventRunner(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+
+	requests := make(chan putEventsRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var r putEventsRequest
+		b, _ := io.ReadAll(req.Body)
+		if err := json.Unmarshal(b, &r); err != nil {
+			t.Errorf("invalid request: %s", b)
+		}
+		requests <- r
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		io.WriteString(w, `{"Entries":[{"EventId":"1"}],"FailedEntryCount":0}`)
+	}))
+	defer srv.Close()
+	cfg.Events = &mirageecs.Events{EventBusName: "mirage", Source: "mirage-ecs"}
+	runner := mirageecs.NewEventRunnerWithEndpoint(cfg, m.Runner(), srv.URL)
+
+	receive := func() (string, *mirageecs.EnvironmentEvent) {
+		t.Helper()
+		select {
+		case r := <-requests:
+			if len(r.Entries) != 1 || r.Entries[0].EventBusName != "mirage" || r.Entries[0].Source != "mirage-ecs" {
+				t.Fatalf("unexpected entries: %#v", r.Entries)
+			}
+			var ev mirageecs.EnvironmentEvent
+			if err := json.Unmarshal([]byte(r.Entries[0].Detail), &ev); err != nil {
+				t.Fatal(err)
+			}
+			return r.Entries[0].DetailType, &ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("event is not published")
+		}
+		return "", nil
+	}
+
+	if err := runner.Launch(ctx, "foo", mirageecs.TaskParameter{"branch": "develop"}, nil, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+	detailType, ev := receive()
+	if detailType != mirageecs.EventEnvironmentLaunched {
+		t.Errorf("unexpected detail-type: %s", detailType)
+	}
+	if diff := cmp.Diff(&mirageecs.EnvironmentEvent{
+		Subdomain:  "foo",
+		Host:       "foo" + cfg.Host.ReverseProxySuffix,
+		Taskdefs:   []string{"app:1"},
+		Parameters: map[string]string{"branch": "develop"},
+	}, ev, cmpIgnoreTime); diff != "" {
+		t.Errorf("unexpected event (-want +got):\n%s", diff)
+	}
+
+	if err := runner.TerminateBySubdomain(ctx, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if detailType, ev := receive(); detailType != mirageecs.EventEnvironmentTerminated || ev.Subdomain != "foo" || len(ev.Taskdefs) != 1 {
+		t.Errorf("unexpected event: %s %#v", detailType, ev)
+	}
+
+	if err := runner.Launch(ctx, "bar", mirageecs.TaskParameter{"branch": "develop"}, nil, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+	receive()
+	if err := runner.TerminateBySubdomain(mirageecs.WithPurge(ctx), "bar"); err != nil {
+		t.Fatal(err)
+	}
+	if detailType, _ := receive(); detailType != mirageecs.EventEnvironmentPurged {
+		t.Errorf("unexpected detail-type of purge: %s", detailType)
+	}
+}
+
+var cmpIgnoreTime = cmp.FilterPath(func(p cmp.Path) bool {
+	return p.Last().String() == ".Time"
+}, cmp.Ignore())
+
+func TestEventsValidate(t *testing.T) {
+	if err := (&mirageecs.Events{}).Validate(); err == nil {
+		t.Error("expected error without event_bus_name and sns_topic_arn")
+	}
+	e := &mirageecs.Events{SNSTopicArn: "arn:aws:sns:ap-northeast-1:123456789012:mirage-events"}
+	if err := e.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if e.Source != mirageecs.DefaultEventSource {
+		t.Errorf("unexpected default source: %s", e.Source)
+	}
+}
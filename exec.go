@@ -0,0 +1,74 @@
+package mirageecs
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// DefaultExecCommand is a command executed by ECS Exec when no command is specified.
+const DefaultExecCommand = "/bin/sh"
+
+// enableExecuteCommandFor reports whether ECS Exec is enabled for the launch.
+// The value specified at launch is prior to the config.
+func (c ECSCfg) enableExecuteCommandFor(opt *LaunchOption) bool {
+	if opt != nil && opt.EnableExecuteCommand != nil {
+		return *opt.EnableExecuteCommand
+	}
+	return aws.ToBool(c.EnableExecuteCommand)
+}
+
+// validateExecuteCommand validates that the task definition satisfies the requirements of ECS Exec.
+// ECS Exec requires a task role which allows ssmmessages:* actions to communicate with the SSM agent.
+func validateExecuteCommand(td *types.TaskDefinition) error {
+	if aws.ToString(td.TaskRoleArn) == "" {
+		return fmt.Errorf("ECS Exec requires a task role, but task definition %s has no task role", aws.ToString(td.Family))
+	}
+	for _, c := range td.ContainerDefinitions {
+		if c.LinuxParameters == nil || !aws.ToBool(c.LinuxParameters.InitProcessEnabled) {
+			slog.Debug(f("initProcessEnabled is recommended for ECS Exec, but container %s has not", aws.ToString(c.Name)))
+		}
+	}
+	return nil
+}
+
+// executeCommandEnabled decides whether ECS Exec is enabled for the task definition.
+// When ECS Exec is requested explicitly at launch or by the config, unsatisfied requirements are errors.
+// When it is enabled only because enable_execute_command is not set, ECS Exec is disabled with a warning.
+func (c ECSCfg) executeCommandEnabled(td *types.TaskDefinition, opt *LaunchOption) (bool, error) {
+	if !c.enableExecuteCommandFor(opt) {
+		return false, nil
+	}
+	if err := validateExecuteCommand(td); err != nil {
+		if (opt != nil && opt.EnableExecuteCommand != nil) || !c.executeCommandByDefault {
+			return false, err
+		}
+		slog.Warn(f("disable ECS Exec: %s", err))
+		return false, nil
+	}
+	return true, nil
+}
+
+// execCommandLine returns a command line of AWS CLI to execute a command in the task by ECS Exec.
+func execCommandLine(cluster string, info *Information, container, command string) string {
+	if command == "" {
+		command = DefaultExecCommand
+	}
+	args := []string{
+		"aws", "ecs", "execute-command",
+		"--cluster", cluster,
+		"--task", info.ShortID,
+	}
+	if container != "" {
+		args = append(args, "--container", container)
+	}
+	args = append(args, "--interactive", "--command", shellQuote(command))
+	return strings.Join(args, " ")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
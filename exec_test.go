@@ -0,0 +1,69 @@
+package mirageecs_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestExecuteCommandEnabled(t *testing.T) {
+	withRole := &types.TaskDefinition{Family: aws.String("app"), TaskRoleArn: aws.String("arn:aws:iam::123456789012:role/app")}
+	withoutRole := &types.TaskDefinition{Family: aws.String("app")}
+	tests := []struct {
+		name     string
+		cfg      *bool
+		byDef    bool
+		opt      *bool
+		td       *types.TaskDefinition
+		expected bool
+		wantErr  bool
+	}{
+		{name: "enabled by config", cfg: aws.Bool(true), td: withRole, expected: true},
+		{name: "disabled by config", cfg: aws.Bool(false), td: withRole, expected: false},
+		{name: "enabled at launch", cfg: aws.Bool(false), opt: aws.Bool(true), td: withRole, expected: true},
+		{name: "disabled at launch", cfg: aws.Bool(true), opt: aws.Bool(false), td: withRole, expected: false},
+		{name: "no task role by config", cfg: aws.Bool(true), td: withoutRole, wantErr: true},
+		{name: "no task role by default", byDef: true, td: withoutRole, expected: false},
+		{name: "enabled by default", byDef: true, td: withRole, expected: true},
+		{name: "no task role at launch", opt: aws.Bool(true), td: withoutRole, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := mirageecs.ECSCfg{EnableExecuteCommand: tt.cfg}
+			if tt.byDef {
+				cfg = cfg.WithExecuteCommandByDefault()
+			}
+			opt := &mirageecs.LaunchOption{EnableExecuteCommand: tt.opt}
+			enabled, err := cfg.ExecuteCommandEnabled(tt.td, opt)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if enabled != tt.expected {
+				t.Errorf("expected %t, got %t", tt.expected, enabled)
+			}
+		})
+	}
+}
+
+func TestExecCommandLine(t *testing.T) {
+	info := &mirageecs.Information{ShortID: "af8e7a6dad6e44d4862696002f41c2dc"}
+	got := mirageecs.ExecCommandLine("mycluster", info, "app", "")
+	expected := "aws ecs execute-command --cluster mycluster --task af8e7a6dad6e44d4862696002f41c2dc --container app --interactive --command '/bin/sh'"
+	if got != expected {
+		t.Errorf("unexpected command line: %s", got)
+	}
+	got = mirageecs.ExecCommandLine("mycluster", info, "", "echo 'hello'")
+	expected = `aws ecs execute-command --cluster mycluster --task af8e7a6dad6e44d4862696002f41c2dc --interactive --command 'echo '\''hello'\'''`
+	if got != expected {
+		t.Errorf("unexpected command line: %s", got)
+	}
+}
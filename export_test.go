@@ -1,6 +1,899 @@
 package mirageecs
 
+import (
+	"context"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	r53Types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ValidateSubdomain         = validateSubdomain
+	ValidateParameterName     = validateParameterName
+	NewHTTPTransport          = newHTTPTransport
+	ReplaceImageTag           = replaceImageTag
+	HasHealthCheck            = hasHealthCheck
+	ServiceName               = serviceName
+	MergeEnvironment          = mergeEnvironment
+	RegisteredFor             = registeredFor
+	EncodeTagValue            = encodeTagValue
+	MergeResourceRequirements = mergeResourceRequirements
+
+	EncodeRelaunchHistory = encodeRelaunchHistory
+	DecodeRelaunchHistory = decodeRelaunchHistory
+	FailureReason         = failureReason
+	WithRelaunchHistory   = withRelaunchHistory
+	RelaunchCountOf       = relaunchCountOf
+	WithRelaunchedFrom    = withRelaunchedFrom
+
+	RecentlyStopped        = recentlyStopped
+	LastStoppedBySubdomain = lastStoppedBySubdomain
+	AccessPointClientToken = accessPointClientToken
+	NewSubdomainMatcher    = newSubdomainMatcher
+	ParseIncludeTags       = parseIncludeTags
+	HasAnyTag              = hasAnyTag
+	TaskDefinitionFamily   = taskDefinitionFamily
+)
+
+func (c *EFSCfg) Validate() error {
+	return c.validate()
+}
+
+func (c *EFSCfg) ApplyVolume(in *ecs.RegisterTaskDefinitionInput, accessPointID string) {
+	efsVolumeModifier(c, accessPointID)(in)
+}
+
+func (c *EFSCfg) DirectoryFor(subdomain string) string {
+	return c.directoryFor(subdomain)
+}
+
+func (s *Sidecar) Validate() error {
+	return s.validate()
+}
+
+func InjectSidecars(in *ecs.RegisterTaskDefinitionInput, sidecars []*Sidecar) {
+	sidecarModifier(sidecars)(in)
+}
+
+func (p *RuntimePlatform) Validate() error {
+	return p.validate()
+}
+
+func (p *RuntimePlatform) RuntimePlatformFor(opt *LaunchOption) *RuntimePlatform {
+	return p.runtimePlatformFor(opt)
+}
+
+func (p *RuntimePlatform) NeedsModification(td *types.TaskDefinition) bool {
+	return p.needsModification(td)
+}
+
+func (p *RuntimePlatform) ValidateFor(td *types.TaskDefinition) error {
+	return p.validateFor(td)
+}
+
+func (g *GPU) Validate() error {
+	return g.validate()
+}
+
+func (g *GPU) GPUFor(opt *LaunchOption) *GPU {
+	return g.gpuFor(opt)
+}
+
+func (g *GPU) ValidateLaunchTarget(launchType *string, strategy []types.CapacityProviderStrategyItem) error {
+	return g.validateLaunchTarget(launchType, strategy)
+}
+
+func (g *GPU) ApplyOverrides(ov *types.TaskOverride) {
+	g.applyOverrides(ov)
+}
+
+func (c ECSCfg) ExecuteCommandEnabled(td *types.TaskDefinition, opt *LaunchOption) (bool, error) {
+	return c.executeCommandEnabled(td, opt)
+}
+
+func (c ECSCfg) WithExecuteCommandByDefault() ECSCfg {
+	c.EnableExecuteCommand = aws.Bool(true)
+	c.executeCommandByDefault = true
+	return c
+}
+
+var ExecCommandLine = execCommandLine
+
+func (r *RelaunchOnFailure) Backoff(retry int) time.Duration {
+	return r.backoff(retry)
+}
+
+func RenderTaskDefinition(tmpl string, data TaskDefinitionTemplateData) (*ecs.RegisterTaskDefinitionInput, error) {
+	t, err := template.New("test").Option("missingkey=zero").Funcs(taskDefinitionTemplateFuncs).Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return renderTaskDefinition(t, data)
+}
+
+func (c *CatchAll) Validate() error {
+	return c.validate()
+}
+
+func (rs RewriteRules) Compile() error {
+	return rs.compile()
+}
+
+var LaunchOptionFromTags = launchOptionFromTags
+
+func (c *CloudMap) Validate() error {
+	return c.validate()
+}
+
+func (c *CloudMap) InstancePort(info *Information) (int, bool) {
+	return c.instancePort(info)
+}
+
+var DiscoveryName = discoveryName
+
+func (w *Windows) Validate() error {
+	return w.validate()
+}
+
+func (w *Windows) TimeoutFor(info *Information, timeout time.Duration) time.Duration {
+	return w.timeoutFor(info, timeout)
+}
+
+func ApplyWindowsLogConfiguration(in *ecs.RegisterTaskDefinitionInput, w *Windows) {
+	windowsModifier(w)(in)
+}
+
+var ValidateParameterTagPrefix = validateParameterTagPrefix
+
+var (
+	ParsePortRoutes            = parsePortRoutes
+	NamedPortsInTaskDefinition = namedPortsInTaskDefinition
+)
+
+func (info *Information) SetHostPorts(td *types.TaskDefinition, task *types.Task) {
+	info.hostPorts = hostPortsOfTask(td, task)
+}
+
+func (n Network) PortRoutesFor(info *Information) PortRoutes {
+	return n.portRoutesFor(info)
+}
+
+func (info *Information) StateOf() string {
+	return info.state()
+}
+
+func (info *Information) RelaunchKey() string {
+	return info.relaunchKey()
+}
+
+func (c *CommandOverride) Validate() error {
+	return c.validate()
+}
+
+func (c *CommandOverride) ValidateFor(td *types.TaskDefinition, opt *LaunchOption) error {
+	return c.validateFor(td, opt)
+}
+
+func (o *LaunchOption) ApplyCommandOverrides(ov *types.TaskOverride) {
+	o.applyCommandOverrides(ov)
+}
+
+func ApplyEntryPoint(in *ecs.RegisterTaskDefinitionInput, opt *LaunchOption) {
+	entryPointModifier(opt)(in)
+}
+
+func (r *Records) Validate() error {
+	return r.validate()
+}
+
+func (r *Records) Changes(name string, action r53Types.ChangeAction) []r53Types.Change {
+	return r.changes(name, action)
+}
+
+var RecordName = recordName
+
+func (c *Certificate) Validate(suffix string) error {
+	return c.validate(suffix)
+}
+
+var (
+	PickCertificate   = pickCertificate
+	ValidationChanges = validationChanges
+)
+
+func (a *ALBRouting) Validate(listen Listen) error {
+	return a.validate(listen)
+}
+
+var (
+	TargetGroupName  = targetGroupName
+	NextRulePriority = nextRulePriority
+	DiffTargets      = diffTargets
+	HostOfRule       = hostOfRule
+)
+
+func (p *Private) Validate() error {
+	return p.validate()
+}
+
+func (p *Private) ValidateFor(opt *LaunchOption, nc *types.NetworkConfiguration) error {
+	return p.validateFor(opt, nc)
+}
+
+func (p *Private) NetworkConfigurationFor(nc *types.NetworkConfiguration, opt *LaunchOption) *types.NetworkConfiguration {
+	return p.networkConfigurationFor(nc, opt)
+}
+
+func (p *Private) Allows(req *http.Request) bool {
+	return p.allows(req)
+}
+
+func (p *Private) Handler(subdomain string, h http.Handler) http.Handler {
+	return p.handler(subdomain, h)
+}
+
+var (
+	TaskAddress              = taskAddress
+	HostOnly                 = hostOnly
+	ListenAddr               = listenAddr
+	InstanceAttributes       = instanceAttributes
+	TargetGroupIPAddressType = targetGroupIPAddressType
+)
+
+func (a *Aliases) Validate() error {
+	return a.validate()
+}
+
+func (a *Aliases) ValidateHostname(hostname string, h Host) error {
+	return a.validateHostname(hostname, h)
+}
+
+func (a *Aliases) DomainFor(hostname string) (*AliasDomain, bool) {
+	return a.domainFor(hostname)
+}
+
+var (
+	FormatAliases = formatAliases
+	ParseAliases  = parseAliases
+	WithAlias     = withAlias
+)
+
+var ValidateTLSPassthrough = validateTLSPassthrough
+
+func (p *TLSPassthrough) SetTargets(targets map[string][]string, private bool) {
+	desired := make(map[string]*passthroughTarget, len(targets))
+	for subdomain, addrs := range targets {
+		desired[subdomain] = &passthroughTarget{addrs: addrs, private: private}
+	}
+	p.Set(desired)
+}
+
+var SecurityGroupsFor = securityGroupsFor
+
+func (r *SecurityGroupRule) Validate() error {
+	return r.validate()
+}
+
+func (c *Config) SetNetworkConfiguration(nc *types.NetworkConfiguration) {
+	c.ECS.networkConfiguration = nc
+}
+
+func (c *Config) NetworkConfigurationFor(tags []types.Tag, opt *LaunchOption) (*types.NetworkConfiguration, error) {
+	return c.networkConfigurationFor(tags, opt)
+}
+
+func (e Endpoints) Validate() error {
+	return e.validate()
+}
+
+func (e Endpoints) Resolver() aws.EndpointResolverWithOptions {
+	return e.resolver()
+}
+
+func (e *ExternalDNS) Validate(records *Records) error {
+	return e.validate(records)
+}
+
+func (e *ExternalDNS) DNSEndpoint(infos []*Information, suffix string) *DNSEndpoint {
+	return e.dnsEndpoint(infos, suffix)
+}
+
+var ExtendExpiresAt = extendExpiresAt
+
+var ValidateSchedules = validateSchedules
+
+func (s *Schedule) Validate() error {
+	return s.validate()
+}
+
+func (s *Schedule) Active(t time.Time) bool {
+	return s.active(t)
+}
+
+func (s *Schedule) LastStop(t time.Time) time.Time {
+	return s.lastStop(t)
+}
+
+func (s *Schedule) NextStart(t time.Time) time.Time {
+	return s.nextStart(t)
+}
+
+func (s *Schedule) Match(tags []types.Tag, opt *LaunchOption) bool {
+	return s.match(tags, opt)
+}
+
+func (o *OnDemand) Validate(taskDefinitionTemplate string) error {
+	return o.validate(taskDefinitionTemplate)
+}
+
+func (o *OnDemand) LaunchFor(subdomain string) (*OnDemandLaunch, map[string]string, bool) {
+	return o.launchFor(subdomain)
+}
+
+func (m *Mirage) Runner() TaskRunner {
+	return m.runner
+}
+
+func (r *IdleStopRule) Validate() error {
+	return r.validate()
+}
+
+func (c ECSCfg) IdleCandidates(running []*Information, now time.Time) map[string]time.Duration {
+	return c.idleCandidates(running, now)
+}
+
+func (p *WarmPool) Validate(cfg ECSCfg) error {
+	return p.validate(cfg)
+}
+
+func (p *WarmPool) Claimable(taskdefs []string, opt *LaunchOption) bool {
+	return p.claimable(taskdefs, opt)
+}
+
+func (h *Hooks) Validate() error {
+	return h.validate()
+}
+
+func (w *PurgeWarning) Validate() error {
+	return w.validate()
+}
+
+func (p *Purge) Validate() error {
+	return p.validate()
+}
+
+func (p *Purge) Excluded(info *Information) bool {
+	return p.excluded(info)
+}
+
+func (c *AccessCounterCfg) Validate() error {
+	return c.validate()
+}
+
+func (c *AccessCounterCfg) EffectiveResolution(localMode bool) time.Duration {
+	return c.resolution(localMode)
+}
+
+func (api *WebApi) WarnPurge(ctx context.Context, subdomain string) {
+	api.warnPurge(ctx, subdomain)
+}
+
+func (api *WebApi) PurgeAfterGracePeriod(ctx context.Context, subdomain string) {
+	api.purgeAfterGracePeriod(ctx, subdomain)
+}
+
+func (c ECSCfg) CheckQuotas(running []*Information, subdomain string, n int, tags []types.Tag) error {
+	return c.checkQuotas(running, subdomain, n, tags)
+}
+
+func (c ECSCfg) QuotaUsages(running []*Information) []*QuotaUsage {
+	return c.quotaUsages(running)
+}
+
+func (m *subdomainMatcher) Match(subdomain string) bool {
+	return m.match(subdomain)
+}
+
+func (c *Cost) Validate() error {
+	return c.validate()
+}
+
+func (c *Cost) Estimate(infos []*Information, now time.Time) {
+	c.estimate(infos, now)
+}
+
+var EnvironmentCosts = environmentCosts
+
+func (b *Budget) Validate(cost *Cost) error {
+	return b.validate(cost)
+}
+
+func NewBudgetTracker(cost *Cost) *budgetTracker {
+	return newBudgetTracker(cost)
+}
+
+func (t *budgetTracker) Update(running []*Information, now time.Time) (float64, float64) {
+	return t.update(running, now)
+}
+
+func (c ECSCfg) BudgetDecisions(running []*Information, projected float64, remaining time.Duration, lastAccess func(string) time.Time) []*budgetDecision {
+	return c.budgetDecisions(running, projected, remaining, lastAccess)
+}
+
+func (r *Refresh) Validate() error {
+	return r.validate()
+}
+
+func (r *Refresh) LastRun(t time.Time) time.Time {
+	return r.lastRun(t)
+}
+
+func (r *Refresh) Taskdefs(infos []*Information) []string {
+	return r.taskdefs(infos)
+}
+
+func (c ECSCfg) RefreshCandidates(running []*Information, now time.Time) map[string]*Refresh {
+	return c.refreshCandidates(running, now)
+}
+
+var IsCapacityError = isCapacityError
+
+func (q *LaunchQueue) Validate() error {
+	return q.validate()
+}
+
+func (q *LaunchQueue) Backoff(attempts int) time.Duration {
+	return q.backoff(attempts)
+}
+
+func NewLaunchQueue(cfg *LaunchQueue, runner TaskRunner) *launchQueue {
+	return newLaunchQueue(cfg, runner)
+}
+
+func (q *launchQueue) Enqueue(subdomain string, param TaskParameter, taskdefs []string, err error) {
+	q.enqueue(subdomain, param, nil, taskdefs, err)
+}
+
+func (q *launchQueue) List() []LaunchJob {
+	return q.list()
+}
+
+func (r *AutoRedeploy) Validate() error {
+	return r.validate()
+}
+
+func (r *AutoRedeploy) Targets(running []*Information, ev *APIECREventRequest) []string {
+	return r.targets(running, ev)
+}
+
+// SetTracerProvider traces by the provider until the returned func is called.
+func SetTracerProvider(tp trace.TracerProvider) func() {
+	old := otelTracer
+	otelTracer = tp.Tracer(tracerName)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return func() {
+		otelTracer = old
+	}
+}
+
+func TraceRequest(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tw, req, span := startRequestSpan(w, req)
+		defer endRequestSpan(tw, span)
+		h.ServeHTTP(tw, req)
+	})
+}
+
+var TraceParentTags = traceParentTags
+
+func (info *Information) SetTask(task *types.Task) {
+	info.task = task
+}
+
+var TraceTaskLifecycle = traceTaskLifecycle
+
+var (
+	NewLogger    = newLogger
+	WithLogAttrs = withLogAttrs
+)
+
+// NewEventRunnerWithEndpoint returns the runner which puts events to the EventBridge endpoint.
+func NewEventRunnerWithEndpoint(cfg *Config, runner TaskRunner, endpoint string) TaskRunner {
+	r := newEventRunner(cfg, runner).(*eventRunner)
+	r.publisher.eb = eventbridge.NewFromConfig(*cfg.awscfg, func(o *eventbridge.Options) {
+		o.Region = "us-east-1"
+		o.Credentials = aws.AnonymousCredentials{}
+		o.EndpointResolver = eventbridge.EndpointResolverFromURL(endpoint)
+	})
+	return r
+}
+
+var WithPurge = withPurge
+
+func (e *Events) Validate() error {
+	return e.validate()
+}
+
+func (h *History) Validate() error {
+	return h.validate(nil)
+}
+
+// OpenHistory opens the history of the config, as NewConfig does.
+func (c *Config) OpenHistory() error {
+	if err := c.History.validate(c.Storage); err != nil {
+		return err
+	}
+	return c.openHistory()
+}
+
+func (p *Persistence) Validate() error {
+	return p.validate(nil)
+}
+
+// OpenPersistence opens the persistence of the config, as NewConfig does.
+func (c *Config) OpenPersistence() error {
+	if err := c.Persistence.validate(c.Storage); err != nil {
+		return err
+	}
+	return c.openPersistence()
+}
+
+func (s *Storage) Validate() error {
+	return s.validate()
+}
+
+// OpenStorage opens the storage, and the history and the persistence in it, as NewConfig does.
+func (c *Config) OpenStorage() error {
+	if err := c.Storage.validate(); err != nil {
+		return err
+	}
+	c.Storage.applyDefaults(c)
+	if err := c.openStorage(); err != nil {
+		return err
+	}
+	if err := c.OpenHistory(); err != nil {
+		return err
+	}
+	return c.OpenPersistence()
+}
+
+var StorageSchemaVersion = len(storageMigrations)
+
+// ReconcileRoutes rebuilds the route table from running tasks, as Run does on startup.
+func (m *Mirage) ReconcileRoutes(ctx context.Context) {
+	m.reconcileRoutes(ctx)
+}
+
+// SaveState saves routes of running tasks and access counters, as the sync and RunPersister do.
+func (m *Mirage) SaveState(ctx context.Context) error {
+	infos, err := m.runner.List(ctx, statusRunning)
+	if err != nil {
+		return err
+	}
+	m.persister.setRoutes(m.Config.Network, infos)
+	m.saveState(ctx)
+	return nil
+}
+
+func (m *Mirage) RestoreState(ctx context.Context) {
+	m.restoreState(ctx)
+}
+
+func (r *ReverseProxy) AccessCounterOf(subdomain string) *AccessCounter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.accessCounterFor(subdomain)
+}
+
+var (
+	RedisUnlockScript = redisUnlockScript
+	RedisExtendScript = redisExtendScript
+)
+
+func (r *Redis) Validate() error {
+	return r.validate()
+}
+
+// OpenRedis opens the redis client of the config, as NewConfig does.
+func (c *Config) OpenRedis() error {
+	if err := c.Redis.validate(); err != nil {
+		return err
+	}
+	return c.openRedis()
+}
+
+// TryRedisLock acquires the lock by redis. It returns a function to release the lock.
+func (c *Config) TryRedisLock(ctx context.Context, name string, ttl time.Duration) (func(), bool, error) {
+	l, err := c.redis.tryLock(ctx, name, ttl)
+	if err != nil || l == nil {
+		return nil, false, err
+	}
+	return l.release, true, nil
+}
+
+func (m *Mirage) ShareAccess(ctx context.Context) {
+	m.shareAccess(ctx)
+}
+
+func (h *HighAvailability) Validate(redis *Redis) error {
+	return h.validate(redis)
+}
+
+func (m *Mirage) Elect(ctx context.Context) {
+	m.election.elect(ctx)
+}
+
+func (m *Mirage) Resign(ctx context.Context) {
+	m.election.resign(ctx)
+}
+
+func (m *Mirage) IsLeader() bool {
+	return m.election.isLeader()
+}
+
+func (m *Mirage) RunAsLeader(ctx context.Context, wg *sync.WaitGroup, job func(context.Context, *sync.WaitGroup)) {
+	m.runAsLeader(ctx, wg, job)
+}
+
+func (l *LogsInsights) Validate() error {
+	return l.validate()
+}
+
 var (
-	ValidateSubdomain = validateSubdomain
-	NewHTTPTransport  = newHTTPTransport
+	ScopedLogsQuery  = scopedLogsQuery
+	LogsQueryResults = logsQueryResults
 )
+
+var TailLogs = tailLogs
+
+func (f *LogsFilter) Match(line string) bool {
+	return f.match(line)
+}
+
+type LogEvent = logEvent
+
+var FormatLogEvents = formatLogEvents
+
+func NewLogEvent(container string, timestamp int64, message string) LogEvent {
+	return newLogEvent(container, &timestamp, &message)
+}
+
+type HealthState = healthState
+
+func NewHealthState(startedAt time.Time) *HealthState {
+	return &healthState{startedAt: startedAt, syncTimeout: healthSyncTimeout}
+}
+
+func (h *healthState) Synced(routes int) {
+	h.synced(routes)
+}
+
+func (h *healthState) Purging(t time.Time) {
+	h.purging(t)
+}
+
+func (h *healthState) CheckRoutes(now time.Time) *HealthCheck {
+	return h.checkRoutes(now)
+}
+
+func (h *healthState) CheckPurge(now time.Time) *HealthCheck {
+	return h.checkPurge(now)
+}
+
+func (u *Utilization) Validate() error {
+	return u.validate()
+}
+
+var (
+	UtilizationQueries = utilizationQueries
+	UtilizationsOf     = utilizationsOf
+)
+
+func (d *Datadog) Validate() error {
+	return d.validate()
+}
+
+// OpenDatadog opens the connection to DogStatsD of the config, as NewConfig does.
+func (c *Config) OpenDatadog() error {
+	if err := c.Datadog.validate(); err != nil {
+		return err
+	}
+	return c.openDatadog()
+}
+
+var (
+	FormatDatadogMetric = formatDatadogMetric
+	FormatDatadogEvent  = formatDatadogEvent
+)
+
+func (e *TaskEvents) Validate() error {
+	return e.validate()
+}
+
+// TaskEventsC returns the channel of notifications of task state change events.
+func (api *WebApi) TaskEventsC() <-chan struct{} {
+	return api.taskEvents.C()
+}
+
+func (d *Dashboard) Validate() error {
+	return d.validate()
+}
+
+func (d *Dashboard) Name(subdomain string) string {
+	return d.name(subdomain)
+}
+
+func (d *Dashboard) DashboardURL(region, subdomain string) string {
+	return d.dashboardURL(region, subdomain)
+}
+
+// Body returns the JSON of the dashboard of a task. Empty group means the task has no log streams.
+func (d *Dashboard) Body(region, cluster, subdomain, taskID, family, group, stream string) (string, error) {
+	t := dashboardTask{id: taskID, family: family}
+	if group != "" {
+		t.streams = []logStream{{group: group, name: stream}}
+	}
+	return d.body(region, cluster, subdomain, []dashboardTask{t})
+}
+
+func (a *ErrorAlert) Validate() error {
+	return a.validate()
+}
+
+type ErrorAlerter = errorAlerter
+
+func NewErrorAlerter(cfg *Config) *ErrorAlerter {
+	return newErrorAlerter(cfg)
+}
+
+func (a *ErrorAlerter) Check(ctx context.Context, subdomains []string, stats func(subdomain string, since time.Time) []*AccessStats, now time.Time) {
+	a.check(ctx, subdomains, stats, now)
+}
+
+func (a *ErrorAlerter) Apply(infos []*Information) {
+	a.apply(infos)
+}
+
+func (e *AccessLogExport) Validate() error {
+	return e.validate()
+}
+
+var (
+	EncodeAccessLogs   = encodeAccessLogs
+	AccessLogObjectKey = accessLogObjectKey
+	FirehoseBatches    = firehoseBatches
+)
+
+type AccessLogExporter = accessLogExporter
+
+// NewAccessLogExporter returns an exporter which delivers records by the function.
+func NewAccessLogExporter(cfg *AccessLogExport, deliver func(ctx context.Context, records []*AccessLogRecord) error) *AccessLogExporter {
+	return &accessLogExporter{
+		cfg:     cfg,
+		ch:      make(chan *AccessLogRecord, cfg.BufferSize),
+		deliver: deliver,
+	}
+}
+
+func (x *AccessLogExporter) Put(r *AccessLogRecord) {
+	x.put(r)
+}
+
+func (x *AccessLogExporter) Run(ctx context.Context, wg *sync.WaitGroup) {
+	x.run(ctx, wg)
+}
+
+func (e *AccessStatsExport) Validate(retention time.Duration) error {
+	return e.validate(retention)
+}
+
+var AccessStatsObjectKey = accessStatsObjectKey
+
+// ExportAccessStats exports statistics of the hour by the function, as RunAccessStatsExporter does.
+func (m *Mirage) ExportAccessStats(ctx context.Context, hour time.Time, put func(ctx context.Context, key string, body []byte) error) error {
+	m.statsExporter = &accessStatsExporter{
+		cfg:      &AccessStatsExport{Bucket: "mirage-stats", Prefix: DefaultAccessStatsExportPrefix},
+		instance: "test",
+		put:      put,
+	}
+	return m.exportAccessStats(ctx, hour)
+}
+
+func (r *ReverseProxy) PruneAccessStats(now time.Time) {
+	r.pruneAccessStats(now)
+}
+
+var (
+	SSMParameterName = ssmParameterName
+	ExpandEnvVars    = expandEnvVars
+	RenderConfig     = renderConfig
+	ResolveInclude   = resolveInclude
+	RewriteKMSTags   = rewriteKMSTags
+	UnmarshalConfig  = unmarshalConfig
+	LoadTemplates    = loadTemplates
+	NewAccessGraph   = newAccessGraph
+	NegotiateLocale  = negotiateLocale
+	Translate        = translate
+	TranslateMessage = translateTemplate
+)
+
+func (c *Config) Decrypt(decrypt func(ciphertext string) (string, error)) error {
+	return decryptConfig(c, decrypt)
+}
+
+func (c *Config) CheckConfigSource(ctx context.Context) (bool, error) {
+	return c.checkConfigSource(ctx)
+}
+
+func CatalogMessages(locale string) map[string]string {
+	return catalogs[locale].messages
+}
+
+func (g *GitHub) Validate() error {
+	return g.validate("")
+}
+
+func (s *Slack) Validate() error {
+	return s.validate()
+}
+
+func (b *Bitbucket) Validate() error {
+	return b.validate("")
+}
+
+func SetLaunchWaitInterval(d time.Duration) func() {
+	prev := launchWaitInterval
+	launchWaitInterval = d
+	return func() { launchWaitInterval = prev }
+}
+
+func RemoteIP(req *http.Request, trustedProxies ...string) string {
+	trusted, err := parsePrefixes(trustedProxies)
+	if err != nil {
+		panic(err)
+	}
+	return remoteIP(req, trusted)
+}
+
+var BaseTaskDefs = baseTaskDefs
+var DeregistrableTaskDefs = deregistrableTaskDefs
+
+// SleepingStore is the store of sleeping environments of the ECS runner with the persistence of the config.
+type SleepingStore struct {
+	s *sleepingStore
+}
+
+func NewSleepingStore(c *Config) *SleepingStore {
+	return &SleepingStore{s: &sleepingStore{store: c.persistence}}
+}
+
+func (s *SleepingStore) Add(ctx context.Context, subdomain, reason string, tasks []*Information) error {
+	return s.s.add(ctx, subdomain, reason, tasks)
+}
+
+// Remove returns tasks to relaunch.
+func (s *SleepingStore) Remove(ctx context.Context, subdomain string) ([]*Information, bool, error) {
+	env, ok, err := s.s.remove(ctx, subdomain)
+	if !ok {
+		return nil, ok, err
+	}
+	return env.tasks, ok, err
+}
+
+func (s *SleepingStore) List(ctx context.Context) ([]*Information, error) {
+	return s.s.list(ctx)
+}
+
+func (info *Information) Task() *types.Task {
+	return info.task
+}
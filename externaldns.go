@@ -0,0 +1,133 @@
+package mirageecs
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	DefaultExternalDNSName = "mirage-ecs"
+
+	externalDNSAPIVersion = "externaldns.k8s.io/v1alpha1"
+	externalDNSKind       = "DNSEndpoint"
+)
+
+// ExternalDNS configures route metadata of environments published in the format of the DNSEndpoint resource of external-dns.
+// So DNS automation on Kubernetes can mirror routes of mirage-ecs into other zones.
+type ExternalDNS struct {
+	// Name is the name of the DNSEndpoint resource.
+	Name string `yaml:"name"`
+	// RecordType is a type of records. CNAME or A. Default is the type of host.records.
+	RecordType string `yaml:"record_type"`
+	// Targets are targets of records. e.g. the DNS name of the load balancer in front of mirage-ecs. Default is the target of host.records.
+	Targets []string `yaml:"targets"`
+	// TTL is TTL of records in seconds. Default is the TTL of host.records.
+	TTL int64 `yaml:"ttl"`
+	// Labels are labels of endpoints. e.g. to select endpoints by external-dns.
+	Labels map[string]string `yaml:"labels"`
+}
+
+// validate validates external_dns. Records are used for defaults, so they must be validated before.
+func (e *ExternalDNS) validate(records *Records) error {
+	if e == nil {
+		return nil
+	}
+	if e.Name == "" {
+		e.Name = DefaultExternalDNSName
+	}
+	if records != nil {
+		if e.RecordType == "" && len(e.Targets) == 0 {
+			e.RecordType = records.Type
+			e.Targets = records.Target
+		}
+		if e.TTL == 0 {
+			e.TTL = records.TTL
+		}
+	}
+	if e.RecordType == "" {
+		e.RecordType = "CNAME"
+	}
+	if e.TTL == 0 {
+		e.TTL = DefaultRecordTTL
+	}
+	if err := validateRecordTarget(e.RecordType, e.Targets); err != nil {
+		return fmt.Errorf("external_dns.targets: %w", err)
+	}
+	return nil
+}
+
+// DNSEndpoint is a DNSEndpoint resource of external-dns.
+type DNSEndpoint struct {
+	APIVersion string              `json:"apiVersion"`
+	Kind       string              `json:"kind"`
+	Metadata   DNSEndpointMetadata `json:"metadata"`
+	Spec       DNSEndpointSpec     `json:"spec"`
+}
+
+type DNSEndpointMetadata struct {
+	Name string `json:"name"`
+}
+
+type DNSEndpointSpec struct {
+	Endpoints []*ExternalDNSEndpoint `json:"endpoints"`
+}
+
+// ExternalDNSEndpoint is a DNS record of external-dns.
+type ExternalDNSEndpoint struct {
+	DNSName    string            `json:"dnsName"`
+	RecordType string            `json:"recordType"`
+	Targets    []string          `json:"targets"`
+	RecordTTL  int64             `json:"recordTTL,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// dnsEndpoint returns the DNSEndpoint of routes of running environments.
+// Private environments are not published, because their records are managed in the private hosted zone.
+func (e *ExternalDNS) dnsEndpoint(infos []*Information, suffix string) *DNSEndpoint {
+	names := make(map[string]struct{})
+	for _, info := range infos {
+		if !info.Ready || info.IPAddress == "" || info.Option.private() {
+			continue
+		}
+		if name, ok := recordName(info.SubDomain, suffix); ok {
+			names[name] = struct{}{}
+		}
+		for _, alias := range info.Option.aliases() {
+			names[alias] = struct{}{}
+		}
+	}
+	endpoints := make([]*ExternalDNSEndpoint, 0, len(names))
+	for name := range names {
+		endpoints = append(endpoints, &ExternalDNSEndpoint{
+			DNSName:    name,
+			RecordType: e.RecordType,
+			Targets:    e.Targets,
+			RecordTTL:  e.TTL,
+			Labels:     e.Labels,
+		})
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].DNSName < endpoints[j].DNSName
+	})
+	return &DNSEndpoint{
+		APIVersion: externalDNSAPIVersion,
+		Kind:       externalDNSKind,
+		Metadata:   DNSEndpointMetadata{Name: e.Name},
+		Spec:       DNSEndpointSpec{Endpoints: endpoints},
+	}
+}
+
+func (api *WebApi) ApiDNSEndpoint(c echo.Context) error {
+	e := api.cfg.Host.ExternalDNS
+	if e == nil {
+		return c.JSON(http.StatusNotFound, APICommonResponse{Result: "external_dns is not configured"})
+	}
+	infos, err := api.runner.List(c.Request().Context(), statusRunning)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(http.StatusOK, e.dnsEndpoint(infos, api.cfg.Host.ReverseProxySuffix))
+}
@@ -0,0 +1,77 @@
+package mirageecs_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestExternalDNSValidate(t *testing.T) {
+	records := &mirageecs.Records{HostedZoneID: "Z0123", Type: "CNAME", Target: []string{"alb.example.com"}, TTL: 300}
+	e := &mirageecs.ExternalDNS{}
+	if err := e.Validate(records); err != nil {
+		t.Fatal(err)
+	}
+	expected := &mirageecs.ExternalDNS{Name: "mirage-ecs", RecordType: "CNAME", Targets: []string{"alb.example.com"}, TTL: 300}
+	if diff := cmp.Diff(expected, e); diff != "" {
+		t.Errorf("unexpected defaults (-want +got):\n%s", diff)
+	}
+
+	e = &mirageecs.ExternalDNS{RecordType: "A", Targets: []string{"192.0.2.1", "192.0.2.2"}}
+	if err := e.Validate(records); err != nil {
+		t.Fatal(err)
+	}
+	if e.RecordType != "A" || len(e.Targets) != 2 || e.TTL != 300 {
+		t.Errorf("targets must not be overridden by host.records: %#v", e)
+	}
+
+	if err := (&mirageecs.ExternalDNS{}).Validate(nil); err == nil {
+		t.Error("expected error without targets")
+	}
+	if err := (&mirageecs.ExternalDNS{RecordType: "TXT", Targets: []string{"x"}}).Validate(nil); err == nil {
+		t.Error("expected error of unsupported record type")
+	}
+}
+
+func TestExternalDNSEndpoint(t *testing.T) {
+	e := &mirageecs.ExternalDNS{
+		Name:       "mirage",
+		RecordType: "CNAME",
+		Targets:    []string{"alb.example.com"},
+		TTL:        60,
+		Labels:     map[string]string{"owner": "mirage"},
+	}
+	infos := []*mirageecs.Information{
+		{SubDomain: "bbb", IPAddress: "10.0.0.2", Ready: true, Option: &mirageecs.LaunchOption{Aliases: []string{"demo.example.net"}}},
+		{SubDomain: "aaa", IPAddress: "10.0.0.1", Ready: true},
+		{SubDomain: "aaa", IPAddress: "10.0.0.3", Ready: true},
+		{SubDomain: "notready", IPAddress: "10.0.0.4"},
+		{SubDomain: "pending", Ready: true},
+		{SubDomain: "internal", IPAddress: "10.0.0.5", Ready: true, Option: &mirageecs.LaunchOption{Visibility: "private"}},
+		{SubDomain: "feat-*", IPAddress: "10.0.0.6", Ready: true},
+	}
+	endpoint := func(name string) *mirageecs.ExternalDNSEndpoint {
+		return &mirageecs.ExternalDNSEndpoint{
+			DNSName:    name,
+			RecordType: "CNAME",
+			Targets:    []string{"alb.example.com"},
+			RecordTTL:  60,
+			Labels:     map[string]string{"owner": "mirage"},
+		}
+	}
+	expected := &mirageecs.DNSEndpoint{
+		APIVersion: "externaldns.k8s.io/v1alpha1",
+		Kind:       "DNSEndpoint",
+		Metadata:   mirageecs.DNSEndpointMetadata{Name: "mirage"},
+		Spec: mirageecs.DNSEndpointSpec{Endpoints: []*mirageecs.ExternalDNSEndpoint{
+			endpoint("aaa.dev.example.com"),
+			endpoint("bbb.dev.example.com"),
+			endpoint("demo.example.net"),
+		}},
+	}
+	if diff := cmp.Diff(expected, e.DNSEndpoint(infos, ".dev.example.com")); diff != "" {
+		t.Errorf("unexpected dns endpoint (-want +got):\n%s", diff)
+	}
+}
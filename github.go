@@ -0,0 +1,314 @@
+package mirageecs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+)
+
+const (
+	DefaultPullRequestSubdomain = "pr-{{ .Number }}"
+
+	// maxWebhookPayloadBytes is the maximum size of payloads of webhooks. GitHub caps payloads at 25MB.
+	maxWebhookPayloadBytes = 25 * 1024 * 1024
+)
+
+// DefaultPullRequestParameters are parameters of environments of pull requests by default.
+var DefaultPullRequestParameters = map[string]string{
+	"branch": "{{ .Branch }}",
+}
+
+// GitHub receives webhooks of pull requests from GitHub at /hooks/github,
+// to launch environments of pull requests when they are opened or pushed, and terminate them when they are closed.
+// Deployments reports environments to GitHub as deployments.
+type GitHub struct {
+	// WebhookSecret is a secret of the webhook to verify signatures of payloads.
+	WebhookSecret string `yaml:"webhook_secret"`
+	// Repositories are full names (owner/name) of repositories to accept. Empty accepts all repositories.
+	Repositories []string `yaml:"repositories"`
+	// Launch is a template to launch environments of pull requests.
+	Launch *PullRequestLaunch `yaml:"launch"`
+	// Deployments reports URLs of environments to GitHub as deployments.
+	Deployments *GitHubDeployments `yaml:"deployments"`
+}
+
+func (g *GitHub) validate(taskDefinitionTemplate string) error {
+	if g == nil {
+		return nil
+	}
+	if err := g.Deployments.validate(); err != nil {
+		return err
+	}
+	if g.Deployments != nil && g.WebhookSecret == "" && g.Launch == nil {
+		// only deployments
+		return nil
+	}
+	if g.WebhookSecret == "" {
+		return fmt.Errorf("github.webhook_secret is required")
+	}
+	if g.Launch == nil {
+		return fmt.Errorf("github.launch is required")
+	}
+	return g.Launch.validate("github.launch", taskDefinitionTemplate)
+}
+
+// accepts reports whether webhooks of the repository are accepted.
+func (g *GitHub) accepts(repository string) bool {
+	return len(g.Repositories) == 0 || lo.ContainsBy(g.Repositories, func(r string) bool {
+		return strings.EqualFold(r, repository)
+	})
+}
+
+// PullRequestLaunch is a template to launch an environment of a pull request.
+// Subdomain and values of parameters are Go templates of the pull request. e.g. {{ .Number }}, {{ .Branch }}, {{ .SHA }}
+type PullRequestLaunch struct {
+	// Subdomain is a template of the subdomain. Default is "pr-{{ .Number }}".
+	Subdomain string `yaml:"subdomain"`
+	// Taskdef are task definitions to launch. Empty uses ecs.task_definition_template.
+	Taskdef []string `yaml:"taskdef"`
+	// Parameters are templates of parameters of the environment. Default is {"branch": "{{ .Branch }}"}.
+	Parameters map[string]string `yaml:"parameters"`
+	// TTL is a TTL of the environment. e.g. "72h"
+	TTL string `yaml:"ttl"`
+
+	subdomain  *template.Template
+	parameters map[string]*template.Template
+	ttl        time.Duration
+}
+
+func (l *PullRequestLaunch) validate(name string, taskDefinitionTemplate string) error {
+	if l.Subdomain == "" {
+		l.Subdomain = DefaultPullRequestSubdomain
+	}
+	if l.Parameters == nil {
+		l.Parameters = maps.Clone(DefaultPullRequestParameters)
+	}
+	var err error
+	if l.subdomain, err = template.New("subdomain").Option("missingkey=error").Parse(l.Subdomain); err != nil {
+		return fmt.Errorf("invalid %s.subdomain: %w", name, err)
+	}
+	l.parameters = make(map[string]*template.Template, len(l.Parameters))
+	for key, v := range l.Parameters {
+		if l.parameters[key], err = template.New(key).Option("missingkey=error").Parse(v); err != nil {
+			return fmt.Errorf("invalid %s.parameters.%s: %w", name, key, err)
+		}
+	}
+	if len(l.Taskdef) == 0 && taskDefinitionTemplate == "" {
+		return fmt.Errorf("%s.taskdef is required without ecs.task_definition_template", name)
+	}
+	if l.TTL != "" {
+		if l.ttl, err = parseTTL(name+".ttl", l.TTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pullRequest is a pull request of webhooks. It is the data of templates of PullRequestLaunch.
+type pullRequest struct {
+	Provider       string // e.g. "github"
+	Repository     string // full name of the repository. e.g. owner/name
+	RepositoryName string // name of the repository without the owner
+	Number         int
+	Title          string
+	Branch         string // head branch
+	SHA            string // head commit
+	Author         string
+	Sender         string // user who triggered the webhook
+}
+
+// render returns the subdomain and parameters of the environment of the pull request.
+func (l *PullRequestLaunch) render(pr *pullRequest) (string, map[string]string, error) {
+	exec := func(t *template.Template) (string, error) {
+		var b strings.Builder
+		if err := t.Execute(&b, pr); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+	subdomain, err := exec(l.subdomain)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to render subdomain: %w", err)
+	}
+	subdomain = strings.ToLower(subdomain)
+	if err := validateSubdomain(subdomain); err != nil {
+		return "", nil, fmt.Errorf("invalid subdomain %q of the pull request: %w", subdomain, err)
+	}
+	params := make(map[string]string, len(l.parameters))
+	for key, t := range l.parameters {
+		if params[key], err = exec(t); err != nil {
+			return "", nil, fmt.Errorf("failed to render parameter %s: %w", key, err)
+		}
+	}
+	return subdomain, params, nil
+}
+
+func (l *PullRequestLaunch) launchOption(pr *pullRequest) *LaunchOption {
+	opt := &LaunchOption{}
+	switch pr.Provider {
+	case "github":
+		opt.GitHub = formatGitHubRef(pr.Repository, pr.SHA)
+	case "bitbucket":
+		opt.Bitbucket = formatGitHubRef(pr.Repository, pr.SHA)
+	}
+	if l.ttl > 0 {
+		t := time.Now().Add(l.ttl).Truncate(time.Second)
+		opt.ExpiresAt = &t
+	}
+	return opt
+}
+
+// APIHookResponse is a response of webhooks at /hooks/*
+type APIHookResponse struct {
+	Result    string `json:"result"`
+	Subdomain string `json:"subdomain,omitempty"`
+}
+
+// gitHubPullRequestEvent is a payload of the pull_request event of GitHub.
+type gitHubPullRequestEvent struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		Title string `json:"title"`
+		Head  struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		} `json:"head"`
+		User struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	} `json:"pull_request"`
+	Repository struct {
+		Name     string `json:"name"`
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+}
+
+func (ev *gitHubPullRequestEvent) pullRequest() *pullRequest {
+	return &pullRequest{
+		Provider:       "github",
+		Repository:     ev.Repository.FullName,
+		RepositoryName: ev.Repository.Name,
+		Number:         ev.Number,
+		Title:          ev.PullRequest.Title,
+		Branch:         ev.PullRequest.Head.Ref,
+		SHA:            ev.PullRequest.Head.SHA,
+		Author:         ev.PullRequest.User.Login,
+		Sender:         ev.Sender.Login,
+	}
+}
+
+// verifyHubSignature verifies the signature (sha256=<HMAC-SHA256 in hex>) of the payload,
+// of the X-Hub-Signature-256 header of GitHub and the X-Hub-Signature header of Bitbucket.
+func verifyHubSignature(secret string, body []byte, signature string) bool {
+	sig, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// readWebhookPayload reads the payload of the webhook up to maxWebhookPayloadBytes.
+func readWebhookPayload(c echo.Context) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxWebhookPayloadBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxWebhookPayloadBytes {
+		return nil, fmt.Errorf("payload is too large")
+	}
+	return body, nil
+}
+
+// HookGitHub launches and terminates environments of pull requests by webhooks of GitHub.
+func (api *WebApi) HookGitHub(c echo.Context) error {
+	g := api.cfg.GitHub
+	if g == nil {
+		return c.JSON(http.StatusNotFound, APIHookResponse{Result: "github is not configured"})
+	}
+	if g.WebhookSecret == "" || g.Launch == nil {
+		// only deployments are configured
+		return c.JSON(http.StatusNotFound, APIHookResponse{Result: "webhooks are not configured"})
+	}
+	body, err := readWebhookPayload(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIHookResponse{Result: err.Error()})
+	}
+	if !verifyHubSignature(g.WebhookSecret, body, c.Request().Header.Get("X-Hub-Signature-256")) {
+		return c.JSON(http.StatusUnauthorized, APIHookResponse{Result: "invalid signature"})
+	}
+	switch event := c.Request().Header.Get("X-GitHub-Event"); event {
+	case "ping":
+		return c.JSON(http.StatusOK, APIHookResponse{Result: "pong"})
+	case "pull_request":
+	default:
+		return c.JSON(http.StatusOK, APIHookResponse{Result: "ignored"})
+	}
+	var ev gitHubPullRequestEvent
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&ev); err != nil {
+		return c.JSON(http.StatusBadRequest, APIHookResponse{Result: err.Error()})
+	}
+	if !g.accepts(ev.Repository.FullName) {
+		return c.JSON(http.StatusOK, APIHookResponse{Result: "ignored"})
+	}
+	switch ev.Action {
+	case "opened", "reopened", "synchronize":
+		return api.hookPullRequest(c, g.Launch, ev.pullRequest(), false)
+	case "closed":
+		return api.hookPullRequest(c, g.Launch, ev.pullRequest(), true)
+	default:
+		return c.JSON(http.StatusOK, APIHookResponse{Result: "ignored"})
+	}
+}
+
+// hookPullRequest launches (or relaunches) the environment of the pull request, or terminates it, in background.
+// Webhooks should be acknowledged before launches complete.
+func (api *WebApi) hookPullRequest(c echo.Context, l *PullRequestLaunch, pr *pullRequest, terminate bool) error {
+	subdomain, params, err := l.render(pr)
+	if err != nil {
+		slog.Warn(f("%s pull request %s#%d: %s", pr.Provider, pr.Repository, pr.Number, err))
+		return c.JSON(http.StatusBadRequest, APIHookResponse{Result: err.Error()})
+	}
+	ctx := withActor(context.Background(), pr.Provider+":"+pr.Sender)
+	ctx = withLogAttrs(ctx, slog.String("subdomain", subdomain))
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, APICallTimeout)
+		defer cancel()
+		if terminate {
+			slog.InfoContext(ctx, f("terminating subdomain %s of %s pull request %s#%d", subdomain, pr.Provider, pr.Repository, pr.Number))
+			api.launchQueue.cancel(subdomain)
+			if err := api.runner.TerminateBySubdomain(ctx, subdomain); err != nil {
+				slog.WarnContext(ctx, f("failed to terminate subdomain %s: %s", subdomain, err))
+			}
+			return
+		}
+		slog.InfoContext(ctx, f("launching subdomain %s of %s pull request %s#%d at %s", subdomain, pr.Provider, pr.Repository, pr.Number, pr.SHA))
+		if err := api.launchWith(ctx, subdomain, l.Taskdef, params, l.launchOption(pr)); err != nil {
+			slog.WarnContext(ctx, f("failed to launch subdomain %s: %s", subdomain, err))
+		}
+	}()
+	return c.JSON(http.StatusAccepted, APIHookResponse{Result: "accepted", Subdomain: subdomain})
+}
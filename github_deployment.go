@@ -0,0 +1,252 @@
+package mirageecs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultGitHubAPIURL               = "https://api.github.com"
+	DefaultGitHubRefParameter         = "branch"
+	DefaultGitHubDeploymentsURLScheme = "https"
+
+	// TagGitHub is a tag of the repository and the ref (owner/name@ref) of environments launched by webhooks of GitHub.
+	TagGitHub = "MirageGitHub"
+
+	// gitHubDescriptionMax is the maximum length of descriptions of deployment statuses.
+	gitHubDescriptionMax = 140
+)
+
+// GitHubDeployments reports environments to GitHub as deployments with their URLs, so reviewers find them in pull requests.
+type GitHubDeployments struct {
+	// Token is a token of GitHub API, which has the permission to write deployments of repositories.
+	Token string `yaml:"token"`
+	// Repository is a repository (owner/name) of environments launched by the API and the web interface.
+	// Environments launched by webhooks are reported to repositories of their pull requests.
+	Repository string `yaml:"repository"`
+	// RefParameter is a name of the parameter of the ref (a branch or a commit) of environments launched by the API. Default is "branch".
+	RefParameter string `yaml:"ref_parameter"`
+	// URLScheme is a scheme of URLs of environments. Default is "https".
+	URLScheme string `yaml:"url_scheme"`
+	// APIURL is a URL of GitHub API. Default is "https://api.github.com". e.g. https://github.example.com/api/v3 for GitHub Enterprise Server
+	APIURL string `yaml:"api_url"`
+}
+
+func (d *GitHubDeployments) validate() error {
+	if d == nil {
+		return nil
+	}
+	if d.Token == "" {
+		return fmt.Errorf("github.deployments.token is required")
+	}
+	if d.Repository != "" && strings.Count(d.Repository, "/") != 1 {
+		return fmt.Errorf("github.deployments.repository must be owner/name: %s", d.Repository)
+	}
+	if d.RefParameter == "" {
+		d.RefParameter = DefaultGitHubRefParameter
+	}
+	if d.URLScheme == "" {
+		d.URLScheme = DefaultGitHubDeploymentsURLScheme
+	}
+	if d.APIURL == "" {
+		d.APIURL = DefaultGitHubAPIURL
+	}
+	if u, err := url.Parse(d.APIURL); err != nil || u.Host == "" {
+		return fmt.Errorf("invalid github.deployments.api_url: %s", d.APIURL)
+	}
+	d.APIURL = strings.TrimSuffix(d.APIURL, "/")
+	return nil
+}
+
+// formatGitHubRef formats the repository and the ref of the environment for TagGitHub.
+func formatGitHubRef(repository, ref string) string {
+	return repository + "@" + ref
+}
+
+func parseGitHubRef(s string) (string, string, bool) {
+	i := strings.LastIndex(s, "@")
+	if i <= 0 || i == len(s)-1 {
+		return "", "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+// gitHubDeployment is a deployment of the environment reported to GitHub.
+type gitHubDeployment struct {
+	ID         int64  `json:"id"`
+	Repository string `json:"-"`
+	Ref        string `json:"ref"`
+}
+
+// GitHubDeployer reports deployments of environments to GitHub.
+type GitHubDeployer struct {
+	cfg    *GitHubDeployments
+	params Parameters
+	suffix string
+	client *http.Client
+
+	mu          sync.Mutex
+	deployments map[string]*gitHubDeployment // subdomain -> the deployment reported
+}
+
+func NewGitHubDeployer(cfg *Config) *GitHubDeployer {
+	if cfg.GitHub == nil || cfg.GitHub.Deployments == nil {
+		return nil
+	}
+	return &GitHubDeployer{
+		cfg:         cfg.GitHub.Deployments,
+		params:      cfg.Parameter,
+		suffix:      cfg.Host.ReverseProxySuffix,
+		client:      &http.Client{Timeout: APICallTimeout},
+		deployments: make(map[string]*gitHubDeployment),
+	}
+}
+
+// target returns the repository and the ref of the environment. Environments without them are not reported.
+func (d *GitHubDeployer) target(info *Information) (string, string, bool) {
+	if info.Option != nil && info.Option.GitHub != "" {
+		return parseGitHubRef(info.Option.GitHub)
+	}
+	if d.cfg.Repository == "" {
+		return "", "", false
+	}
+	ref := taskParameterFromTags(info.Tags, d.params)[d.cfg.RefParameter]
+	return d.cfg.Repository, ref, ref != ""
+}
+
+// Sync reports deployments of ready environments which have not been reported with the same ref,
+// and marks deployments of environments which are not kept (terminated) inactive.
+func (d *GitHubDeployer) Sync(ctx context.Context, running []*Information, keep map[string]bool) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	reported := make(map[string]bool)
+	for _, info := range running {
+		if !info.Ready || reported[info.SubDomain] {
+			continue
+		}
+		repository, ref, ok := d.target(info)
+		if !ok {
+			continue
+		}
+		reported[info.SubDomain] = true
+		if cur := d.deployments[info.SubDomain]; cur != nil && cur.Repository == repository && cur.Ref == ref {
+			continue
+		}
+		dep, err := d.deploy(ctx, info, repository, ref)
+		if err != nil {
+			slog.Warn(f("failed to report the deployment of %s to %s: %s", info.SubDomain, repository, err))
+			continue
+		}
+		d.deployments[info.SubDomain] = dep
+	}
+	for subdomain, dep := range d.deployments {
+		if keep[subdomain] {
+			continue
+		}
+		if err := d.setStatus(ctx, dep, "inactive", subdomain, "terminated by mirage-ecs"); err != nil {
+			slog.Warn(f("failed to mark the deployment of %s inactive: %s", subdomain, err))
+			continue
+		}
+		slog.Info(f("marked the deployment %d of %s in %s inactive", dep.ID, subdomain, dep.Repository))
+		delete(d.deployments, subdomain)
+	}
+}
+
+// deploy creates the deployment of the environment (or finds the deployment created before restarts), and sets its status success.
+func (d *GitHubDeployer) deploy(ctx context.Context, info *Information, repository, ref string) (*gitHubDeployment, error) {
+	host := info.SubDomain + d.suffix
+	var found []*gitHubDeployment
+	q := url.Values{"environment": {host}, "ref": {ref}, "per_page": {"1"}}
+	if err := d.call(ctx, http.MethodGet, "/repos/"+repository+"/deployments?"+q.Encode(), nil, &found); err != nil {
+		return nil, err
+	}
+	var dep *gitHubDeployment
+	if len(found) > 0 {
+		dep = found[0]
+	} else {
+		dep = &gitHubDeployment{}
+		if err := d.call(ctx, http.MethodPost, "/repos/"+repository+"/deployments", map[string]any{
+			"ref":                   ref,
+			"environment":           host,
+			"description":           "launched by mirage-ecs",
+			"auto_merge":            false,
+			"required_contexts":     []string{},
+			"transient_environment": true,
+		}, dep); err != nil {
+			return nil, err
+		}
+	}
+	dep.Repository = repository
+	dep.Ref = ref
+	desc := "launched by mirage-ecs"
+	if info.Option != nil && info.Option.ExpiresAt != nil {
+		desc = "expires at " + info.Option.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	if err := d.setStatus(ctx, dep, "success", info.SubDomain, desc); err != nil {
+		return nil, err
+	}
+	slog.Info(f("reported the deployment %d of %s at %s to %s", dep.ID, info.SubDomain, ref, repository))
+	return dep, nil
+}
+
+func (d *GitHubDeployer) setStatus(ctx context.Context, dep *gitHubDeployment, state, subdomain, desc string) error {
+	if len(desc) > gitHubDescriptionMax {
+		desc = desc[:gitHubDescriptionMax]
+	}
+	body := map[string]any{
+		"state":         state,
+		"description":   desc,
+		"auto_inactive": true,
+	}
+	if state == "success" {
+		body["environment_url"] = d.cfg.URLScheme + "://" + subdomain + d.suffix
+	}
+	return d.call(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/deployments/%d/statuses", dep.Repository, dep.ID), body, nil)
+}
+
+// call calls GitHub API with the JSON body, and decodes the JSON response into out.
+func (d *GitHubDeployer) call(ctx context.Context, method, path string, body any, out any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, d.cfg.APIURL+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+d.cfg.Token)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%s %s: %s %s", method, path, res.Status, strings.TrimSpace(string(b)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
@@ -0,0 +1,189 @@
+package mirageecs_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestGitHubDeploymentsValidate(t *testing.T) {
+	for _, g := range []*mirageecs.GitHub{
+		{},
+		{Deployments: &mirageecs.GitHubDeployments{}},
+		{Deployments: &mirageecs.GitHubDeployments{Token: "token", Repository: "mirage-ecs"}},
+		{Deployments: &mirageecs.GitHubDeployments{Token: "token", APIURL: "github"}},
+		{WebhookSecret: "secret", Deployments: &mirageecs.GitHubDeployments{Token: "token"}},
+	} {
+		if err := g.Validate(); err == nil {
+			t.Errorf("%#v should be invalid", g)
+		}
+	}
+	g := &mirageecs.GitHub{Deployments: &mirageecs.GitHubDeployments{Token: "token", APIURL: "https://github.example.com/api/v3/"}}
+	if err := g.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	d := g.Deployments
+	if d.RefParameter != "branch" || d.URLScheme != "https" || d.APIURL != "https://github.example.com/api/v3" {
+		t.Errorf("unexpected defaults: %#v", d)
+	}
+}
+
+type fakeGitHubRequest struct {
+	Method string
+	Path   string
+	Body   map[string]any
+}
+
+func newFakeGitHubAPI(t *testing.T) (*httptest.Server, func() []fakeGitHubRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var reqs []fakeGitHubRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		req := fakeGitHubRequest{Method: r.Method, Path: r.URL.Path}
+		if r.Method == http.MethodPost {
+			json.NewDecoder(r.Body).Decode(&req.Body)
+		}
+		mu.Lock()
+		reqs = append(reqs, req)
+		mu.Unlock()
+		switch {
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`[]`))
+		case r.URL.Path == "/repos/acidlemon/mirage-ecs/deployments":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":42,"ref":"develop"}`))
+		default:
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts, func() []fakeGitHubRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		r := reqs
+		reqs = nil
+		return r
+	}
+}
+
+func TestGitHubDeployments(t *testing.T) {
+	ctx := context.Background()
+	api, requests := newFakeGitHubAPI(t)
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cfg.Cleanup()
+	cfg.GitHub = &mirageecs.GitHub{
+		Deployments: &mirageecs.GitHubDeployments{Token: "token", Repository: "acidlemon/mirage-ecs", APIURL: api.URL},
+	}
+	if err := cfg.GitHub.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	syncDeployments := func(keep ...string) {
+		t.Helper()
+		running, err := m.Runner().List(ctx, "RUNNING")
+		if err != nil {
+			t.Fatal(err)
+		}
+		k := make(map[string]bool)
+		for _, s := range keep {
+			k[s] = true
+		}
+		m.Deployments.Sync(ctx, running, k)
+	}
+
+	if err := m.Runner().Launch(ctx, "foo", mirageecs.TaskParameter{"branch": "develop"}, nil, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+	// without the ref parameter
+	if err := m.Runner().Launch(ctx, "bar", mirageecs.TaskParameter{}, nil, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+	syncDeployments("foo", "bar")
+	reqs := requests()
+	if len(reqs) != 3 {
+		t.Fatalf("unexpected requests: %#v", reqs)
+	}
+	if r := reqs[1]; r.Path != "/repos/acidlemon/mirage-ecs/deployments" || r.Body["ref"] != "develop" || r.Body["environment"] != "foo"+cfg.Host.ReverseProxySuffix {
+		t.Errorf("unexpected deployment: %#v", r)
+	}
+	if r := reqs[2]; r.Path != "/repos/acidlemon/mirage-ecs/deployments/42/statuses" || r.Body["state"] != "success" || r.Body["environment_url"] != "https://foo"+cfg.Host.ReverseProxySuffix {
+		t.Errorf("unexpected status: %#v", r)
+	}
+
+	// reported once
+	syncDeployments("foo", "bar")
+	if reqs := requests(); len(reqs) != 0 {
+		t.Errorf("unexpected requests: %#v", reqs)
+	}
+
+	if err := m.Runner().TerminateBySubdomain(ctx, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	syncDeployments("bar")
+	reqs = requests()
+	if len(reqs) != 1 || reqs[0].Path != "/repos/acidlemon/mirage-ecs/deployments/42/statuses" || reqs[0].Body["state"] != "inactive" {
+		t.Errorf("unexpected requests: %#v", reqs)
+	}
+}
+
+func TestGitHubDeploymentsOfPullRequest(t *testing.T) {
+	m, post := newGitHubHook(t)
+	if code, _ := post("pull_request", pullRequestPayload("opened", "acidlemon/mirage-ecs"), "secret"); code != http.StatusAccepted {
+		t.Fatalf("unexpected status: %d", code)
+	}
+	info := waitRunning(t, m, "pr-12", true)
+	if o := info.Option; o == nil || o.GitHub != "acidlemon/mirage-ecs@0123456789abcdef" {
+		t.Errorf("repository and commit of the pull request should be stored: %#v", o)
+	}
+}
+
+func TestHookGitHubWithoutWebhook(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cfg.Cleanup()
+	cfg.GitHub = &mirageecs.GitHub{Deployments: &mirageecs.GitHubDeployments{Token: "token"}}
+	if err := cfg.GitHub.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	// signed with the empty secret
+	payload := pullRequestPayload("opened", "acidlemon/mirage-ecs")
+	mac := hmac.New(sha256.New, []byte(""))
+	mac.Write([]byte(payload))
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/hooks/github", strings.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", "pull_request")
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("webhooks should not be served without webhook_secret: %d", res.StatusCode)
+	}
+	waitRunning(t, m, "pr-12", false)
+}
@@ -0,0 +1,135 @@
+package mirageecs_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestGitHubValidate(t *testing.T) {
+	for _, g := range []*mirageecs.GitHub{
+		{Launch: &mirageecs.PullRequestLaunch{Taskdef: []string{"app:1"}}},
+		{WebhookSecret: "secret"},
+		{WebhookSecret: "secret", Launch: &mirageecs.PullRequestLaunch{}},
+		{WebhookSecret: "secret", Launch: &mirageecs.PullRequestLaunch{Taskdef: []string{"app:1"}, Subdomain: "pr-{{ .Number"}},
+		{WebhookSecret: "secret", Launch: &mirageecs.PullRequestLaunch{Taskdef: []string{"app:1"}, TTL: "forever"}},
+	} {
+		if err := g.Validate(); err == nil {
+			t.Errorf("%#v should be invalid", g)
+		}
+	}
+	g := &mirageecs.GitHub{WebhookSecret: "secret", Launch: &mirageecs.PullRequestLaunch{Taskdef: []string{"app:1"}}}
+	if err := g.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if g.Launch.Subdomain != mirageecs.DefaultPullRequestSubdomain || g.Launch.Parameters["branch"] != "{{ .Branch }}" {
+		t.Errorf("unexpected defaults: %#v", g.Launch)
+	}
+}
+
+func newGitHubHook(t *testing.T) (*mirageecs.Mirage, func(event string, payload string, secret string) (int, mirageecs.APIHookResponse)) {
+	t.Helper()
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cfg.Cleanup)
+	cfg.GitHub = &mirageecs.GitHub{
+		WebhookSecret: "secret",
+		Repositories:  []string{"acidlemon/mirage-ecs"},
+		Launch:        &mirageecs.PullRequestLaunch{Taskdef: []string{"app:1"}},
+	}
+	if err := cfg.GitHub.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	t.Cleanup(ts.Close)
+	post := func(event string, payload string, secret string) (int, mirageecs.APIHookResponse) {
+		t.Helper()
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(payload))
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/hooks/github", strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var r mirageecs.APIHookResponse
+		if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, r
+	}
+	return m, post
+}
+
+func pullRequestPayload(action string, repository string) string {
+	return `{"action":"` + action + `","number":12,"pull_request":{"title":"cool feature","head":{"ref":"feature/cool","sha":"0123456789abcdef"},"user":{"login":"octocat"}},"repository":{"name":"mirage-ecs","full_name":"` + repository + `"},"sender":{"login":"octocat"}}`
+}
+
+// waitRunning waits for the subdomain to be running (or not).
+func waitRunning(t *testing.T, m *mirageecs.Mirage, subdomain string, running bool) *mirageecs.Information {
+	t.Helper()
+	for i := 0; i < 50; i++ {
+		infos, err := m.Runner().List(context.Background(), "RUNNING")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found *mirageecs.Information
+		for _, info := range infos {
+			if info.SubDomain == subdomain {
+				found = info
+			}
+		}
+		if (found != nil) == running {
+			return found
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("subdomain %s should be running=%t", subdomain, running)
+	return nil
+}
+
+func TestHookGitHub(t *testing.T) {
+	m, post := newGitHubHook(t)
+
+	if code, _ := post("pull_request", pullRequestPayload("opened", "acidlemon/mirage-ecs"), "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("invalid signature should be unauthorized: %d", code)
+	}
+	if code, r := post("ping", `{"zen":"Keep it logically awesome."}`, "secret"); code != http.StatusOK || r.Result != "pong" {
+		t.Errorf("unexpected response of ping: %d %#v", code, r)
+	}
+	if code, r := post("pull_request", pullRequestPayload("opened", "someone/else"), "secret"); code != http.StatusOK || r.Result != "ignored" {
+		t.Errorf("other repositories should be ignored: %d %#v", code, r)
+	}
+	if code, r := post("pull_request", pullRequestPayload("labeled", "acidlemon/mirage-ecs"), "secret"); code != http.StatusOK || r.Result != "ignored" {
+		t.Errorf("other actions should be ignored: %d %#v", code, r)
+	}
+
+	code, r := post("pull_request", pullRequestPayload("opened", "acidlemon/mirage-ecs"), "secret")
+	if code != http.StatusAccepted || r.Subdomain != "pr-12" {
+		t.Fatalf("unexpected response of opened: %d %#v", code, r)
+	}
+	if info := waitRunning(t, m, "pr-12", true); info.GitBranch != "feature/cool" {
+		t.Errorf("unexpected branch: %s", info.GitBranch)
+	}
+
+	if code, _ := post("pull_request", pullRequestPayload("closed", "acidlemon/mirage-ecs"), "secret"); code != http.StatusAccepted {
+		t.Fatalf("unexpected response of closed: %d", code)
+	}
+	waitRunning(t, m, "pr-12", false)
+}
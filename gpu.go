@@ -0,0 +1,118 @@
+package mirageecs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+)
+
+// GPU configures GPUs and inference accelerators assigned to launched tasks.
+// These require EC2 container instances which have GPUs (or Elastic Inference accelerators).
+type GPU struct {
+	// Count is the number of GPUs assigned to each container.
+	Count int32 `yaml:"count"`
+	// Containers are names of containers which GPUs are assigned to. Default is the first container.
+	Containers []string `yaml:"containers"`
+	// InferenceAccelerators maps device names defined in the task definition to device types.
+	InferenceAccelerators map[string]string `yaml:"inference_accelerators"`
+
+	countSet bool // Count is specified explicitly at launch, including 0
+}
+
+func (g *GPU) validate() error {
+	if g == nil {
+		return nil
+	}
+	if g.Count < 0 {
+		return fmt.Errorf("gpu.count must not be negative: %d", g.Count)
+	}
+	return nil
+}
+
+// gpuFor returns GPU settings applied at launch. Settings specified at launch are prior to the config.
+// An explicit count 0 at launch disables GPUs of the config.
+func (g *GPU) gpuFor(opt *LaunchOption) *GPU {
+	var r GPU
+	if g != nil {
+		r = *g
+	}
+	if opt != nil && opt.GPU != nil {
+		if opt.GPU.Count > 0 || opt.GPU.countSet {
+			r.Count = opt.GPU.Count
+		}
+		if len(opt.GPU.Containers) > 0 {
+			r.Containers = opt.GPU.Containers
+		}
+		if len(opt.GPU.InferenceAccelerators) > 0 {
+			r.InferenceAccelerators = opt.GPU.InferenceAccelerators
+		}
+	}
+	if r.Count == 0 && len(r.InferenceAccelerators) == 0 {
+		return nil
+	}
+	return &r
+}
+
+// validateLaunchTarget validates that GPUs can be used with the launch type or capacity providers.
+func (g *GPU) validateLaunchTarget(launchType *string, strategy []types.CapacityProviderStrategyItem) error {
+	if g == nil {
+		return nil
+	}
+	if launchType != nil && types.LaunchType(*launchType) == types.LaunchTypeFargate {
+		return fmt.Errorf("GPUs are not supported on Fargate")
+	}
+	for _, item := range strategy {
+		if strings.HasPrefix(aws.ToString(item.CapacityProvider), "FARGATE") {
+			return fmt.Errorf("GPUs are not supported on %s", aws.ToString(item.CapacityProvider))
+		}
+	}
+	return nil
+}
+
+// applyOverrides assigns GPUs and inference accelerators to the task override.
+func (g *GPU) applyOverrides(ov *types.TaskOverride) {
+	if g == nil {
+		return
+	}
+	for name, deviceType := range g.InferenceAccelerators {
+		ov.InferenceAcceleratorOverrides = append(ov.InferenceAcceleratorOverrides, types.InferenceAcceleratorOverride{
+			DeviceName: aws.String(name),
+			DeviceType: aws.String(deviceType),
+		})
+	}
+	if g.Count == 0 {
+		return
+	}
+	for i := range ov.ContainerOverrides {
+		c := &ov.ContainerOverrides[i]
+		if len(g.Containers) == 0 && i > 0 {
+			break
+		}
+		if len(g.Containers) > 0 && !lo.Contains(g.Containers, aws.ToString(c.Name)) {
+			continue
+		}
+		c.ResourceRequirements = mergeResourceRequirements(c.ResourceRequirements, []types.ResourceRequirement{
+			{
+				Type:  types.ResourceTypeGpu,
+				Value: aws.String(strconv.Itoa(int(g.Count))),
+			},
+		})
+	}
+}
+
+// mergeResourceRequirements returns resource requirements merged with the override.
+// Requirements in the override replace ones of the same type.
+func mergeResourceRequirements(reqs []types.ResourceRequirement, override []types.ResourceRequirement) []types.ResourceRequirement {
+	merged := make([]types.ResourceRequirement, 0, len(reqs)+len(override))
+	for _, r := range reqs {
+		if lo.ContainsBy(override, func(o types.ResourceRequirement) bool { return o.Type == r.Type }) {
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return append(merged, override...)
+}
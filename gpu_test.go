@@ -0,0 +1,119 @@
+package mirageecs_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestGPUValidate(t *testing.T) {
+	if err := (&mirageecs.GPU{Count: -1}).Validate(); err == nil {
+		t.Error("expected error for negative count")
+	}
+	var empty *mirageecs.GPU
+	if err := empty.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestGPUFor(t *testing.T) {
+	cfg := &mirageecs.GPU{Count: 1, Containers: []string{"app"}}
+	g := cfg.GPUFor(&mirageecs.LaunchOption{GPU: &mirageecs.GPU{Count: 2}})
+	if g.Count != 2 || len(g.Containers) != 1 || g.Containers[0] != "app" {
+		t.Errorf("unexpected gpu: %#v", g)
+	}
+	var empty *mirageecs.GPU
+	if g := empty.GPUFor(nil); g != nil {
+		t.Errorf("gpu must be nil: %#v", g)
+	}
+	if g := empty.GPUFor(&mirageecs.LaunchOption{GPU: &mirageecs.GPU{Count: 1}}); g == nil || g.Count != 1 {
+		t.Errorf("unexpected gpu: %#v", g)
+	}
+}
+
+func TestGPUValidateLaunchTarget(t *testing.T) {
+	g := &mirageecs.GPU{Count: 1}
+	if err := g.ValidateLaunchTarget(aws.String("FARGATE"), nil); err == nil {
+		t.Error("expected error for Fargate")
+	}
+	strategy := []types.CapacityProviderStrategyItem{{CapacityProvider: aws.String("FARGATE_SPOT")}}
+	if err := g.ValidateLaunchTarget(nil, strategy); err == nil {
+		t.Error("expected error for Fargate Spot")
+	}
+	if err := g.ValidateLaunchTarget(aws.String("EC2"), nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestGPUApplyOverrides(t *testing.T) {
+	ov := &types.TaskOverride{
+		ContainerOverrides: []types.ContainerOverride{
+			{Name: aws.String("app")},
+			{Name: aws.String("worker")},
+		},
+	}
+	g := &mirageecs.GPU{
+		Count:                 2,
+		InferenceAccelerators: map[string]string{"device1": "eia2.medium"},
+	}
+	g.ApplyOverrides(ov)
+	rr := ov.ContainerOverrides[0].ResourceRequirements
+	if len(rr) != 1 || rr[0].Type != types.ResourceTypeGpu || aws.ToString(rr[0].Value) != "2" {
+		t.Errorf("unexpected resource requirements: %#v", rr)
+	}
+	if len(ov.ContainerOverrides[1].ResourceRequirements) != 0 {
+		t.Error("GPUs must be assigned to the first container only")
+	}
+	if len(ov.InferenceAcceleratorOverrides) != 1 || aws.ToString(ov.InferenceAcceleratorOverrides[0].DeviceType) != "eia2.medium" {
+		t.Errorf("unexpected inference accelerator overrides: %#v", ov.InferenceAcceleratorOverrides)
+	}
+
+	ov = &types.TaskOverride{
+		ContainerOverrides: []types.ContainerOverride{
+			{Name: aws.String("app")},
+			{Name: aws.String("worker")},
+		},
+	}
+	(&mirageecs.GPU{Count: 1, Containers: []string{"worker"}}).ApplyOverrides(ov)
+	if len(ov.ContainerOverrides[0].ResourceRequirements) != 0 || len(ov.ContainerOverrides[1].ResourceRequirements) != 1 {
+		t.Errorf("GPUs must be assigned to the worker only: %#v", ov.ContainerOverrides)
+	}
+}
+
+func TestGPUForExplicitZero(t *testing.T) {
+	cfg := &mirageecs.GPU{Count: 1}
+	req := &mirageecs.APILaunchRequest{Subdomain: "gpu", Taskdef: []string{"app"}, GPU: "0"}
+	opt, err := req.LaunchOption()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g := cfg.GPUFor(opt); g != nil {
+		t.Errorf("gpu=0 at launch must disable GPUs: %#v", g)
+	}
+	if g := cfg.GPUFor(&mirageecs.LaunchOption{GPU: &mirageecs.GPU{Containers: []string{"app"}}}); g == nil || g.Count != 1 {
+		t.Errorf("unexpected gpu: %#v", g)
+	}
+}
+
+func TestMergeResourceRequirements(t *testing.T) {
+	reqs := []types.ResourceRequirement{
+		{Type: types.ResourceTypeGpu, Value: aws.String("1")},
+		{Type: types.ResourceTypeInferenceAccelerator, Value: aws.String("device1")},
+	}
+	override := []types.ResourceRequirement{
+		{Type: types.ResourceTypeGpu, Value: aws.String("2")},
+	}
+	got := mirageecs.MergeResourceRequirements(reqs, override)
+	want := []types.ResourceRequirement{
+		{Type: types.ResourceTypeInferenceAccelerator, Value: aws.String("device1")},
+		{Type: types.ResourceTypeGpu, Value: aws.String("2")},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreUnexported(types.ResourceRequirement{})); diff != "" {
+		t.Errorf("unexpected resource requirements (-want +got):\n%s", diff)
+	}
+}
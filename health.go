@@ -0,0 +1,168 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cwlogs "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HealthOK     = "ok"
+	HealthFailed = "failed"
+
+	// healthCheckTimeout is a timeout of each dependency check.
+	healthCheckTimeout = 5 * time.Second
+	// healthSyncTimeout is a time the route table can be left without syncing. It is synced every 10 seconds by default.
+	healthSyncTimeout = time.Minute
+	// healthPurgeTimeout is a time a purge can hold the lock.
+	healthPurgeTimeout = time.Hour
+)
+
+// HealthCheck is a status of the dependency of mirage-ecs.
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	// Latency is a time of the check in milliseconds.
+	Latency int64 `json:"latency_ms"`
+}
+
+func newHealthCheck(name string, start time.Time, err error, msg string) *HealthCheck {
+	c := &HealthCheck{Name: name, Status: HealthOK, Message: msg, Latency: time.Since(start).Milliseconds()}
+	if err != nil {
+		c.Status = HealthFailed
+		c.Message = err.Error()
+	}
+	return c
+}
+
+// healthState keeps states of background work of mirage-ecs for health checks.
+type healthState struct {
+	mu             sync.Mutex
+	syncTimeout    time.Duration
+	startedAt      time.Time
+	syncedAt       time.Time
+	routes         int
+	purgeStartedAt time.Time
+}
+
+func newHealthState(syncInterval time.Duration) *healthState {
+	// the route table may be synced less frequently with task events
+	return &healthState{startedAt: time.Now(), syncTimeout: max(healthSyncTimeout, 3*syncInterval)}
+}
+
+// synced records the sync of the route table.
+func (h *healthState) synced(routes int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.syncedAt = time.Now()
+	h.routes = routes
+}
+
+// purging records the start (or the end by the zero time) of the purge, which holds the purge lock.
+func (h *healthState) purging(t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.purgeStartedAt = t
+}
+
+// state returns the number of routes at the last sync and the start time of mirage-ecs.
+func (h *healthState) state() (int, time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.routes, h.startedAt
+}
+
+func (h *healthState) checkRoutes(now time.Time) *HealthCheck {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.syncedAt.IsZero() {
+		if now.Sub(h.startedAt) > h.syncTimeout {
+			return newHealthCheck("routes", now, fmt.Errorf("route table has not been synced since %s", h.startedAt.Format(time.RFC3339)), "")
+		}
+		return newHealthCheck("routes", now, nil, "waiting for the first sync")
+	}
+	if now.Sub(h.syncedAt) > h.syncTimeout {
+		return newHealthCheck("routes", now, fmt.Errorf("route table was last synced at %s", h.syncedAt.Format(time.RFC3339)), "")
+	}
+	return newHealthCheck("routes", now, nil, fmt.Sprintf("%d routes synced at %s", h.routes, h.syncedAt.Format(time.RFC3339)))
+}
+
+func (h *healthState) checkPurge(now time.Time) *HealthCheck {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.purgeStartedAt.IsZero() {
+		return newHealthCheck("purge", now, nil, "not running")
+	}
+	if now.Sub(h.purgeStartedAt) > healthPurgeTimeout {
+		return newHealthCheck("purge", now, fmt.Errorf("purge lock has been held since %s", h.purgeStartedAt.Format(time.RFC3339)), "")
+	}
+	return newHealthCheck("purge", now, nil, fmt.Sprintf("running since %s", h.purgeStartedAt.Format(time.RFC3339)))
+}
+
+// CheckHealth checks access to the ECS cluster and CloudWatch Logs.
+func (e *ECS) CheckHealth(ctx context.Context) []*HealthCheck {
+	var checks [2]*HealthCheck
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		checks[0] = e.checkCluster(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		defer cancel()
+		_, err := e.logsSvc.DescribeLogGroups(ctx, &cwlogs.DescribeLogGroupsInput{Limit: aws.Int32(1)})
+		checks[1] = newHealthCheck("logs", start, err, "")
+	}()
+	wg.Wait()
+	return checks[:]
+}
+
+func (e *ECS) checkCluster(ctx context.Context) *HealthCheck {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	out, err := e.svc.DescribeClusters(ctx, &ecs.DescribeClustersInput{
+		Clusters: []string{e.cfg.ECS.Cluster},
+	})
+	if err != nil {
+		return newHealthCheck("ecs", start, err, "")
+	}
+	if len(out.Clusters) == 0 {
+		return newHealthCheck("ecs", start, fmt.Errorf("cluster %s is not found", e.cfg.ECS.Cluster), "")
+	}
+	status := aws.ToString(out.Clusters[0].Status)
+	if status != "ACTIVE" {
+		return newHealthCheck("ecs", start, fmt.Errorf("cluster %s is %s", e.cfg.ECS.Cluster, status), "")
+	}
+	return newHealthCheck("ecs", start, nil, fmt.Sprintf("cluster %s is %s", e.cfg.ECS.Cluster, status))
+}
+
+// ApiHealth checks dependencies of mirage-ecs. It responds 503 when any check failed.
+func (api *WebApi) ApiHealth(c echo.Context) error {
+	checks := api.runner.CheckHealth(c.Request().Context())
+	now := time.Now()
+	checks = append(checks, api.health.checkRoutes(now), api.health.checkPurge(now))
+	if api.election != nil {
+		checks = append(checks, api.election.check(now))
+	}
+	res := APIHealthResponse{Result: HealthOK, Checks: checks}
+	code := http.StatusOK
+	for _, check := range checks {
+		if check.Status != HealthOK {
+			res.Result = HealthFailed
+			code = http.StatusServiceUnavailable
+		}
+	}
+	return c.JSON(code, res)
+}
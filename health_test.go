@@ -0,0 +1,74 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestHealthStateRoutes(t *testing.T) {
+	now := time.Now()
+	h := mirageecs.NewHealthState(now.Add(-10 * time.Second))
+	if c := h.CheckRoutes(now); c.Status != mirageecs.HealthOK {
+		t.Errorf("routes should be ok before the first sync: %#v", c)
+	}
+	if c := h.CheckRoutes(now.Add(time.Hour)); c.Status != mirageecs.HealthFailed {
+		t.Errorf("routes should fail without syncs: %#v", c)
+	}
+	h.Synced(3)
+	if c := h.CheckRoutes(time.Now()); c.Status != mirageecs.HealthOK {
+		t.Errorf("routes should be ok after the sync: %#v", c)
+	}
+	if c := h.CheckRoutes(time.Now().Add(2 * time.Minute)); c.Status != mirageecs.HealthFailed {
+		t.Errorf("routes should fail after the sync stopped: %#v", c)
+	}
+}
+
+func TestHealthStatePurge(t *testing.T) {
+	now := time.Now()
+	h := mirageecs.NewHealthState(now)
+	if c := h.CheckPurge(now); c.Status != mirageecs.HealthOK {
+		t.Errorf("purge should be ok when not running: %#v", c)
+	}
+	h.Purging(now.Add(-time.Minute))
+	if c := h.CheckPurge(now); c.Status != mirageecs.HealthOK {
+		t.Errorf("purge should be ok while running: %#v", c)
+	}
+	h.Purging(now.Add(-2 * time.Hour))
+	if c := h.CheckPurge(now); c.Status != mirageecs.HealthFailed {
+		t.Errorf("purge should fail when the lock is held too long: %#v", c)
+	}
+	h.Purging(time.Time{})
+	if c := h.CheckPurge(now); c.Status != mirageecs.HealthOK {
+		t.Errorf("purge should be ok after the purge: %#v", c)
+	}
+}
+
+func TestApiHealth(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+
+	// by IP addresses without authorization, as health checks of load balancers
+	req := httptest.NewRequest(http.MethodGet, "http://10.0.0.1/api/health", nil)
+	w := httptest.NewRecorder()
+	m.ServeHTTPWithPort(w, req, 80)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d %s", w.Code, w.Body.String())
+	}
+	var r mirageecs.APIHealthResponse
+	if err := json.NewDecoder(w.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Result != mirageecs.HealthOK || len(r.Checks) != 2 || r.Checks[0].Name != "routes" || r.Checks[1].Name != "purge" {
+		t.Errorf("unexpected response: %#v", r)
+	}
+}
@@ -0,0 +1,459 @@
+package mirageecs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/labstack/echo/v4"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	HistoryLaunched   = "launched"
+	HistoryRelaunched = "relaunched"
+	HistoryTerminated = "terminated"
+	HistoryPurged     = "purged"
+	HistoryFailed     = "failed"
+
+	DefaultHistoryRetention = 90 * 24 * time.Hour
+
+	// historyTimeFormat is a fixed width format of times, so the lexical order of keys is the order of times.
+	historyTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+	historyBucket     = "history"
+	historyOpenLimit  = 10 * time.Second
+)
+
+// History keeps lifecycle events of environments in a DynamoDB table or a local file.
+type History struct {
+	// DynamoDBTable is a name of the DynamoDB table, which has the partition key "subdomain" (S) and the sort key "time" (S).
+	// Enable TTL of the table with the attribute "expires_at" to remove events after the retention.
+	DynamoDBTable string `yaml:"dynamodb_table"`
+	// File is a path of the database file. It should be on a persistent volume, e.g. EFS.
+	// Events are stored in the storage file without dynamodb_table and file.
+	File string `yaml:"file"`
+	// Retention is a period to keep events. Default is 2160h (90 days).
+	Retention time.Duration `yaml:"retention"`
+}
+
+func (h *History) validate(storage *Storage) error {
+	if h == nil {
+		return nil
+	}
+	if h.DynamoDBTable != "" && h.File != "" || h.DynamoDBTable == "" && h.File == "" && storage == nil {
+		return fmt.Errorf("either history.dynamodb_table or history.file is required")
+	}
+	if h.Retention == 0 {
+		h.Retention = DefaultHistoryRetention
+	}
+	if h.Retention < 0 {
+		return fmt.Errorf("history.retention must be positive: %s", h.Retention)
+	}
+	return nil
+}
+
+// HistoryEvent is a lifecycle event of the environment kept in the history.
+type HistoryEvent struct {
+	Subdomain string    `json:"subdomain"`
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	// Actor is a user (or a client address) who requested the operation. Empty for operations by mirage-ecs itself.
+	Actor      string            `json:"actor,omitempty"`
+	Taskdefs   []string          `json:"taskdefs,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// Reason is a reason of the failure or the relaunch.
+	Reason string `json:"reason,omitempty"`
+}
+
+// historyStore stores events of the history.
+type historyStore interface {
+	put(ctx context.Context, ev *HistoryEvent) error
+	// query returns events since the time in the order of times. Empty subdomain queries all environments.
+	query(ctx context.Context, subdomain string, since time.Time) ([]*HistoryEvent, error)
+	close() error
+}
+
+func (c *Config) openHistory() error {
+	h := c.History
+	if h == nil {
+		return nil
+	}
+	if h.DynamoDBTable != "" {
+		c.history = &dynamoDBHistory{
+			svc:       dynamodb.NewFromConfig(*c.awscfg),
+			table:     h.DynamoDBTable,
+			retention: h.Retention,
+		}
+	} else if h.File == "" {
+		// the storage file is closed by its own cleanup
+		c.history = &fileHistory{db: c.storage, retention: h.Retention}
+		return nil
+	} else {
+		s, err := openFileHistory(h.File, h.Retention)
+		if err != nil {
+			return err
+		}
+		c.history = s
+	}
+	c.cleanups = append(c.cleanups, c.history.close)
+	return nil
+}
+
+type actorContextKey struct{}
+
+func withActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+func actorFrom(ctx context.Context) string {
+	v, _ := ctx.Value(actorContextKey{}).(string)
+	return v
+}
+
+// actorMiddleware sets the actor of the request to the context.
+// The actor is an identity of ALB OIDC, a user name of basic auth or the client address.
+func (cfg *Config) actorMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		actor := c.RealIP()
+		if id := req.Header.Get("x-amzn-oidc-identity"); id != "" && cfg.Auth != nil && cfg.Auth.AmznOIDC != nil {
+			actor = id
+		} else if user, _, ok := req.BasicAuth(); ok && cfg.Auth != nil && cfg.Auth.Basic != nil {
+			actor = user
+		}
+		c.SetRequest(req.WithContext(withActor(req.Context(), actor)))
+		return next(c)
+	}
+}
+
+// fileHistory is a history stored in a bbolt database file.
+type fileHistory struct {
+	db        *bolt.DB
+	retention time.Duration
+}
+
+func openFileHistory(path string, retention time.Duration) (*fileHistory, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: historyOpenLimit})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file %s: %w", path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(historyBucket))
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bucket of history file %s: %w", path, err)
+	}
+	return &fileHistory{db: db, retention: retention}, nil
+}
+
+func historyKey(t time.Time, subdomain string) []byte {
+	return []byte(t.UTC().Format(historyTimeFormat) + "/" + subdomain)
+}
+
+// put stores the event and removes events older than the retention.
+func (s *fileHistory) put(ctx context.Context, ev *HistoryEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	expired := historyKey(time.Now().Add(-s.retention), "")
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(historyBucket))
+		c := bucket.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, expired) < 0; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return bucket.Put(historyKey(ev.Time, ev.Subdomain), b)
+	})
+}
+
+func (s *fileHistory) query(ctx context.Context, subdomain string, since time.Time) ([]*HistoryEvent, error) {
+	events := []*HistoryEvent{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(historyBucket)).Cursor()
+		for k, v := c.Seek(historyKey(since, "")); k != nil; k, v = c.Next() {
+			var ev HistoryEvent
+			if err := json.Unmarshal(v, &ev); err != nil {
+				return fmt.Errorf("failed to parse history %s: %w", k, err)
+			}
+			if subdomain == "" || ev.Subdomain == subdomain {
+				events = append(events, &ev)
+			}
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (s *fileHistory) close() error {
+	return s.db.Close()
+}
+
+// dynamoDBHistory is a history stored in a DynamoDB table.
+type dynamoDBHistory struct {
+	svc       *dynamodb.Client
+	table     string
+	retention time.Duration
+}
+
+func (s *dynamoDBHistory) put(ctx context.Context, ev *HistoryEvent) error {
+	item := map[string]ddbTypes.AttributeValue{
+		"subdomain":  &ddbTypes.AttributeValueMemberS{Value: ev.Subdomain},
+		"time":       &ddbTypes.AttributeValueMemberS{Value: ev.Time.UTC().Format(historyTimeFormat)},
+		"event":      &ddbTypes.AttributeValueMemberS{Value: ev.Event},
+		"expires_at": &ddbTypes.AttributeValueMemberN{Value: strconv.FormatInt(ev.Time.Add(s.retention).Unix(), 10)},
+	}
+	if ev.Actor != "" {
+		item["actor"] = &ddbTypes.AttributeValueMemberS{Value: ev.Actor}
+	}
+	if ev.Reason != "" {
+		item["reason"] = &ddbTypes.AttributeValueMemberS{Value: ev.Reason}
+	}
+	if len(ev.Taskdefs) > 0 {
+		taskdefs := make([]ddbTypes.AttributeValue, 0, len(ev.Taskdefs))
+		for _, td := range ev.Taskdefs {
+			taskdefs = append(taskdefs, &ddbTypes.AttributeValueMemberS{Value: td})
+		}
+		item["taskdefs"] = &ddbTypes.AttributeValueMemberL{Value: taskdefs}
+	}
+	if len(ev.Parameters) > 0 {
+		params := make(map[string]ddbTypes.AttributeValue, len(ev.Parameters))
+		for name, v := range ev.Parameters {
+			params[name] = &ddbTypes.AttributeValueMemberS{Value: v}
+		}
+		item["parameters"] = &ddbTypes.AttributeValueMemberM{Value: params}
+	}
+	_, err := s.svc.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item:      item,
+	})
+	return err
+}
+
+// query queries events of the subdomain, or scans events of all environments.
+// Expired events are filtered out, because TTL of DynamoDB removes items lazily.
+func (s *dynamoDBHistory) query(ctx context.Context, subdomain string, since time.Time) ([]*HistoryEvent, error) {
+	if t := time.Now().Add(-s.retention); since.Before(t) {
+		since = t
+	}
+	names := map[string]string{"#time": "time"}
+	values := map[string]ddbTypes.AttributeValue{
+		":since": &ddbTypes.AttributeValueMemberS{Value: since.UTC().Format(historyTimeFormat)},
+	}
+	var items []map[string]ddbTypes.AttributeValue
+	if subdomain != "" {
+		values[":subdomain"] = &ddbTypes.AttributeValueMemberS{Value: subdomain}
+		p := dynamodb.NewQueryPaginator(s.svc, &dynamodb.QueryInput{
+			TableName:                 aws.String(s.table),
+			KeyConditionExpression:    aws.String("subdomain = :subdomain AND #time >= :since"),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		})
+		for p.HasMorePages() {
+			out, err := p.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, out.Items...)
+		}
+	} else {
+		p := dynamodb.NewScanPaginator(s.svc, &dynamodb.ScanInput{
+			TableName:                 aws.String(s.table),
+			FilterExpression:          aws.String("#time >= :since"),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		})
+		for p.HasMorePages() {
+			out, err := p.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, out.Items...)
+		}
+	}
+	events := make([]*HistoryEvent, 0, len(items))
+	for _, item := range items {
+		ev, err := historyEventFromItem(item)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events, nil
+}
+
+func (s *dynamoDBHistory) close() error {
+	return nil
+}
+
+func historyEventFromItem(item map[string]ddbTypes.AttributeValue) (*HistoryEvent, error) {
+	str := func(name string) string {
+		if v, ok := item[name].(*ddbTypes.AttributeValueMemberS); ok {
+			return v.Value
+		}
+		return ""
+	}
+	t, err := time.Parse(historyTimeFormat, str("time"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid time of history of %s: %w", str("subdomain"), err)
+	}
+	ev := &HistoryEvent{
+		Subdomain: str("subdomain"),
+		Event:     str("event"),
+		Time:      t,
+		Actor:     str("actor"),
+		Reason:    str("reason"),
+	}
+	if l, ok := item["taskdefs"].(*ddbTypes.AttributeValueMemberL); ok {
+		for _, v := range l.Value {
+			if s, ok := v.(*ddbTypes.AttributeValueMemberS); ok {
+				ev.Taskdefs = append(ev.Taskdefs, s.Value)
+			}
+		}
+	}
+	if m, ok := item["parameters"].(*ddbTypes.AttributeValueMemberM); ok {
+		ev.Parameters = make(map[string]string, len(m.Value))
+		for name, v := range m.Value {
+			if s, ok := v.(*ddbTypes.AttributeValueMemberS); ok {
+				ev.Parameters[name] = s.Value
+			}
+		}
+	}
+	return ev, nil
+}
+
+// historyRunner is a TaskRunner which keeps lifecycle events of environments in the history.
+// Events are stored before returning, to keep the order of events of the environment.
+type historyRunner struct {
+	TaskRunner
+
+	cfg   *Config
+	store historyStore
+}
+
+func newHistoryRunner(cfg *Config, runner TaskRunner) TaskRunner {
+	return &historyRunner{
+		TaskRunner: runner,
+		cfg:        cfg,
+		store:      cfg.history,
+	}
+}
+
+func (r *historyRunner) Launch(ctx context.Context, subdomain string, param TaskParameter, opt *LaunchOption, taskdefs ...string) error {
+	err := r.TaskRunner.Launch(ctx, subdomain, param, opt, taskdefs...)
+	ev := r.event(ctx, subdomain, HistoryLaunched, taskdefs, param)
+	if err != nil {
+		ev.Event = HistoryFailed
+		ev.Reason = err.Error()
+	}
+	r.record(ctx, ev)
+	return err
+}
+
+func (r *historyRunner) Relaunch(ctx context.Context, info *Information) error {
+	err := r.TaskRunner.Relaunch(ctx, info)
+	ev := r.event(ctx, info.SubDomain, HistoryRelaunched, []string{info.TaskDef}, taskParameterFromTags(info.Tags, r.cfg.Parameter))
+	if n := len(info.Relaunches); n > 0 {
+		ev.Reason = info.Relaunches[n-1].Reason
+	}
+	if err != nil {
+		ev.Event = HistoryFailed
+		ev.Reason = err.Error()
+	}
+	r.record(ctx, ev)
+	return err
+}
+
+func (r *historyRunner) TerminateBySubdomain(ctx context.Context, subdomain string) error {
+	taskdefs, param, err := environmentOf(ctx, r.TaskRunner, r.cfg, subdomain)
+	if err != nil {
+		return err
+	}
+	if err := r.TaskRunner.TerminateBySubdomain(ctx, subdomain); err != nil {
+		return err
+	}
+	event := HistoryTerminated
+	if isPurge(ctx) {
+		event = HistoryPurged
+	}
+	r.record(ctx, r.event(ctx, subdomain, event, taskdefs, param))
+	return nil
+}
+
+func (r *historyRunner) event(ctx context.Context, subdomain, event string, taskdefs []string, param TaskParameter) *HistoryEvent {
+	return &HistoryEvent{
+		Subdomain:  subdomain,
+		Event:      event,
+		Time:       time.Now(),
+		Actor:      actorFrom(ctx),
+		Taskdefs:   taskdefs,
+		Parameters: param,
+	}
+}
+
+// record stores the event. Errors are logged, not to fail operations.
+func (r *historyRunner) record(ctx context.Context, ev *HistoryEvent) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), APICallTimeout)
+	defer cancel()
+	if err := r.store.put(ctx, ev); err != nil {
+		slog.WarnContext(ctx, f("failed to record history %s of subdomain %s: %s", ev.Event, ev.Subdomain, err))
+	}
+}
+
+// environmentOf returns task definitions and parameters of the running environment.
+func environmentOf(ctx context.Context, runner TaskRunner, cfg *Config, subdomain string) ([]string, TaskParameter, error) {
+	infos, err := runner.List(ctx, statusRunning)
+	if err != nil {
+		return nil, nil, err
+	}
+	var taskdefs []string
+	var param TaskParameter
+	for _, info := range infos {
+		if info.SubDomain != subdomain {
+			continue
+		}
+		taskdefs = append(taskdefs, info.TaskDef)
+		param = taskParameterFromTags(info.Tags, cfg.Parameter)
+	}
+	return taskdefs, param, nil
+}
+
+// ApiHistory returns events of the history since the time, for the subdomain or all environments.
+func (api *WebApi) ApiHistory(c echo.Context) error {
+	store := api.cfg.history
+	if store == nil {
+		return c.JSON(http.StatusNotFound, APICommonResponse{Result: "history is not configured"})
+	}
+	since := time.Now().Add(-api.cfg.History.Retention)
+	if s := c.QueryParam("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, APICommonResponse{Result: fmt.Sprintf("cannot parse since: %s", err)})
+		}
+		if t.After(since) {
+			since = t
+		}
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), APICallTimeout)
+	defer cancel()
+	events, err := store.query(ctx, c.QueryParam("subdomain"), since)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(http.StatusOK, APIHistoryResponse{Result: events})
+}
@@ -0,0 +1,112 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestHistoryValidate(t *testing.T) {
+	for _, h := range []*mirageecs.History{
+		{},
+		{DynamoDBTable: "mirage-history", File: "/tmp/history.db"},
+		{File: "/tmp/history.db", Retention: -time.Hour},
+	} {
+		if err := h.Validate(); err == nil {
+			t.Errorf("%#v should be invalid", h)
+		}
+	}
+	h := &mirageecs.History{DynamoDBTable: "mirage-history"}
+	if err := h.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if h.Retention != mirageecs.DefaultHistoryRetention {
+		t.Errorf("unexpected default retention: %s", h.Retention)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cfg.Cleanup()
+	cfg.History = &mirageecs.History{File: filepath.Join(t.TempDir(), "history.db")}
+	if err := cfg.OpenHistory(); err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/launch", strings.NewReader(`{"subdomain":"foo","taskdef":["app:1"],"branch":"develop"}`))
+	req.Header.Set("Content-Type", "application/json")
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("failed to launch: %d", res.StatusCode)
+	}
+	if err := m.Runner().Launch(ctx, "bar", mirageecs.TaskParameter{"branch": "main"}, nil, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Runner().TerminateBySubdomain(mirageecs.WithPurge(ctx), "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(q string) []*mirageecs.HistoryEvent {
+		t.Helper()
+		res, err := ts.Client().Get(ts.URL + "/api/history?" + q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status: %d", res.StatusCode)
+		}
+		var r mirageecs.APIHistoryResponse
+		if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		return r.Result
+	}
+
+	events := query("subdomain=foo")
+	if len(events) != 2 {
+		t.Fatalf("unexpected events: %#v", events)
+	}
+	if ev := events[0]; ev.Event != mirageecs.HistoryLaunched || ev.Actor != "127.0.0.1" || ev.Parameters["branch"] != "develop" {
+		t.Errorf("unexpected launched event: %#v", ev)
+	}
+	if ev := events[1]; ev.Event != mirageecs.HistoryPurged || ev.Actor != "" || len(ev.Taskdefs) != 1 || ev.Taskdefs[0] != "app:1" {
+		t.Errorf("unexpected purged event: %#v", ev)
+	}
+
+	if events := query(""); len(events) != 3 {
+		t.Errorf("unexpected events of all environments: %#v", events)
+	}
+	since := events[1].Time.Add(-time.Nanosecond).UTC().Format(time.RFC3339Nano)
+	if events := query("since=" + url.QueryEscape(since)); len(events) != 1 || events[0].Event != mirageecs.HistoryPurged {
+		t.Errorf("unexpected events since %s: %#v", since, events)
+	}
+
+	res, err = ts.Client().Get(ts.URL + "/api/history?since=yesterday")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid since should be a bad request: %d", res.StatusCode)
+	}
+}
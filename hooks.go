@@ -0,0 +1,244 @@
+package mirageecs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+const (
+	HookEventPostLaunch   = "post_launch"
+	HookEventPreTerminate = "pre_terminate"
+
+	DefaultHookTimeout = 30 * time.Second
+)
+
+// Hooks are webhooks (or SNS topics) called with the environment right after launch, and right before termination.
+type Hooks struct {
+	// PostLaunch are called after launches succeed.
+	PostLaunch []*Hook `yaml:"post_launch"`
+	// PreTerminate are called before environments are terminated (by the API, purge or TTL).
+	PreTerminate []*Hook `yaml:"pre_terminate"`
+}
+
+// Hook is a webhook URL or an SNS topic.
+type Hook struct {
+	// URL is an URL to POST the payload as JSON.
+	URL string `yaml:"url"`
+	// Headers are HTTP headers of requests to the URL. e.g. Authorization
+	Headers map[string]string `yaml:"headers"`
+	// SNSTopicArn is an ARN of the SNS topic to publish the payload.
+	SNSTopicArn string `yaml:"sns_topic_arn"`
+	// Wait waits for the 2xx response of the pre_terminate hook before terminating. Termination fails without it.
+	Wait bool `yaml:"wait"`
+	// Timeout is a timeout of the hook. Default is 30s.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (h *Hooks) validate() error {
+	if h == nil {
+		return nil
+	}
+	for _, hook := range h.PostLaunch {
+		if err := hook.validate(); err != nil {
+			return fmt.Errorf("hooks.post_launch: %w", err)
+		}
+		if hook.Wait {
+			return fmt.Errorf("hooks.post_launch: wait is supported only by pre_terminate hooks")
+		}
+	}
+	for _, hook := range h.PreTerminate {
+		if err := hook.validate(); err != nil {
+			return fmt.Errorf("hooks.pre_terminate: %w", err)
+		}
+		if hook.Wait && hook.URL == "" {
+			return fmt.Errorf("hooks.pre_terminate: wait requires url")
+		}
+	}
+	return nil
+}
+
+func (h *Hook) validate() error {
+	if (h.URL == "") == (h.SNSTopicArn == "") {
+		return fmt.Errorf("either url or sns_topic_arn is required")
+	}
+	if h.URL != "" {
+		u, err := url.Parse(h.URL)
+		if err != nil {
+			return fmt.Errorf("invalid url: %w", err)
+		}
+		if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid url: %s must be an absolute http(s) URL", h.URL)
+		}
+	}
+	if h.Timeout == 0 {
+		h.Timeout = DefaultHookTimeout
+	}
+	return nil
+}
+
+func (h *Hook) String() string {
+	if h.URL != "" {
+		return h.URL
+	}
+	return h.SNSTopicArn
+}
+
+// HookPayload is a payload of hooks.
+type HookPayload struct {
+	Event      string            `json:"event"`
+	Subdomain  string            `json:"subdomain"`
+	Host       string            `json:"host"`
+	Taskdefs   []string          `json:"taskdefs,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Time       time.Time         `json:"time"`
+
+	// fields of purge warnings
+	Owner   string     `json:"owner,omitempty"`
+	PurgeAt *time.Time `json:"purge_at,omitempty"`
+	// fields of error alerts
+	ErrorAlert *ErrorAlertState `json:"error_alert,omitempty"`
+	// Text is a message for humans. Slack incoming webhooks show it.
+	Text string `json:"text,omitempty"`
+}
+
+// hookClient sends payloads to webhooks and SNS topics.
+type hookClient struct {
+	http *http.Client
+	sns  *sns.Client
+}
+
+func newHookClient(cfg *Config, hooks ...[]*Hook) *hookClient {
+	c := &hookClient{http: &http.Client{}}
+	for _, hs := range hooks {
+		for _, hook := range hs {
+			if hook.SNSTopicArn != "" && c.sns == nil {
+				c.sns = sns.NewFromConfig(*cfg.awscfg)
+			}
+		}
+	}
+	return c
+}
+
+// hookRunner is a TaskRunner which calls hooks on launch and termination.
+type hookRunner struct {
+	TaskRunner
+
+	cfg    *Config
+	hooks  *Hooks
+	client *hookClient
+}
+
+func newHookRunner(cfg *Config, runner TaskRunner) TaskRunner {
+	return &hookRunner{
+		TaskRunner: runner,
+		cfg:        cfg,
+		hooks:      cfg.Hooks,
+		client:     newHookClient(cfg, cfg.Hooks.PostLaunch, cfg.Hooks.PreTerminate),
+	}
+}
+
+func (r *hookRunner) Launch(ctx context.Context, subdomain string, param TaskParameter, opt *LaunchOption, taskdefs ...string) error {
+	if err := r.TaskRunner.Launch(ctx, subdomain, param, opt, taskdefs...); err != nil {
+		return err
+	}
+	p := r.payload(HookEventPostLaunch, subdomain, taskdefs, param)
+	for _, hook := range r.hooks.PostLaunch {
+		go r.client.call(context.Background(), hook, p)
+	}
+	return nil
+}
+
+func (r *hookRunner) TerminateBySubdomain(ctx context.Context, subdomain string) error {
+	if len(r.hooks.PreTerminate) > 0 {
+		infos, err := r.List(ctx, statusRunning)
+		if err != nil {
+			return err
+		}
+		var taskdefs []string
+		var param TaskParameter
+		for _, info := range infos {
+			if info.SubDomain != subdomain {
+				continue
+			}
+			taskdefs = append(taskdefs, info.TaskDef)
+			param = taskParameterFromTags(info.Tags, r.cfg.Parameter)
+		}
+		p := r.payload(HookEventPreTerminate, subdomain, taskdefs, param)
+		for _, hook := range r.hooks.PreTerminate {
+			if !hook.Wait {
+				go r.client.call(context.Background(), hook, p)
+				continue
+			}
+			if err := r.client.call(ctx, hook, p); err != nil {
+				return fmt.Errorf("pre_terminate hook failed. subdomain %s is not terminated: %w", subdomain, err)
+			}
+		}
+	}
+	return r.TaskRunner.TerminateBySubdomain(ctx, subdomain)
+}
+
+func (r *hookRunner) payload(event, subdomain string, taskdefs []string, param TaskParameter) *HookPayload {
+	return &HookPayload{
+		Event:      event,
+		Subdomain:  subdomain,
+		Host:       subdomain + r.cfg.Host.ReverseProxySuffix,
+		Taskdefs:   taskdefs,
+		Parameters: param,
+		Time:       time.Now(),
+	}
+}
+
+// call calls the hook with the payload. Errors are logged and returned.
+func (c *hookClient) call(ctx context.Context, hook *Hook, p *HookPayload) error {
+	ctx, cancel := context.WithTimeout(ctx, hook.Timeout)
+	defer cancel()
+	err := c.send(ctx, hook, p)
+	if err != nil {
+		slog.Warn(f("%s hook %s for subdomain %s failed: %s", p.Event, hook, p.Subdomain, err))
+	} else {
+		slog.Info(f("%s hook %s for subdomain %s succeeded", p.Event, hook, p.Subdomain))
+	}
+	return err
+}
+
+func (c *hookClient) send(ctx context.Context, hook *Hook, p *HookPayload) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if hook.SNSTopicArn != "" {
+		_, err := c.sns.Publish(ctx, &sns.PublishInput{
+			TopicArn: aws.String(hook.SNSTopicArn),
+			Message:  aws.String(string(b)),
+		})
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range hook.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestHooksValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		hooks *mirageecs.Hooks
+		ok    bool
+	}{
+		{"nil", nil, true},
+		{"url", &mirageecs.Hooks{PostLaunch: []*mirageecs.Hook{{URL: "https://example.com/hook"}}}, true},
+		{"sns", &mirageecs.Hooks{PreTerminate: []*mirageecs.Hook{{SNSTopicArn: "arn:aws:sns:ap-northeast-1:123456789012:mirage"}}}, true},
+		{"wait", &mirageecs.Hooks{PreTerminate: []*mirageecs.Hook{{URL: "https://example.com/hook", Wait: true}}}, true},
+		{"empty", &mirageecs.Hooks{PostLaunch: []*mirageecs.Hook{{}}}, false},
+		{"both", &mirageecs.Hooks{PostLaunch: []*mirageecs.Hook{{URL: "https://example.com/hook", SNSTopicArn: "arn:aws:sns:ap-northeast-1:123456789012:mirage"}}}, false},
+		{"relative url", &mirageecs.Hooks{PostLaunch: []*mirageecs.Hook{{URL: "/hook"}}}, false},
+		{"wait post_launch", &mirageecs.Hooks{PostLaunch: []*mirageecs.Hook{{URL: "https://example.com/hook", Wait: true}}}, false},
+		{"wait sns", &mirageecs.Hooks{PreTerminate: []*mirageecs.Hook{{SNSTopicArn: "arn:aws:sns:ap-northeast-1:123456789012:mirage", Wait: true}}}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.hooks.Validate()
+			if c.ok && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if !c.ok && err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestHooks(t *testing.T) {
+	payloads := make(chan *mirageecs.HookPayload, 10)
+	var status atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p mirageecs.HookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Error(err)
+		}
+		payloads <- &p
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Hooks = &mirageecs.Hooks{
+		PostLaunch:   []*mirageecs.Hook{{URL: srv.URL + "/post_launch"}},
+		PreTerminate: []*mirageecs.Hook{{URL: srv.URL + "/pre_terminate", Wait: true}},
+	}
+	if err := cfg.Hooks.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	runner := mirageecs.New(ctx, cfg).Runner()
+
+	if err := runner.Launch(ctx, "foo", mirageecs.TaskParameter{"branch": "develop"}, nil, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+	p := receivePayload(t, payloads)
+	if p.Event != mirageecs.HookEventPostLaunch || p.Subdomain != "foo" || p.Host != "foo.localtest.me" || p.Parameters["branch"] != "develop" {
+		t.Errorf("unexpected payload %#v", p)
+	}
+
+	// termination fails when the pre_terminate hook fails
+	if err := runner.TerminateBySubdomain(ctx, "foo"); err == nil {
+		t.Error("expected error")
+	}
+	receivePayload(t, payloads)
+	if infos, _ := runner.List(ctx, "RUNNING"); len(infos) != 1 {
+		t.Errorf("foo must not be terminated: %v", infos)
+	}
+
+	status.Store(http.StatusOK)
+	if err := runner.TerminateBySubdomain(ctx, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	p = receivePayload(t, payloads)
+	if p.Event != mirageecs.HookEventPreTerminate || p.Subdomain != "foo" || p.Parameters["branch"] != "develop" || len(p.Taskdefs) != 1 {
+		t.Errorf("unexpected payload %#v", p)
+	}
+	if infos, _ := runner.List(ctx, "RUNNING"); len(infos) != 0 {
+		t.Errorf("foo must be terminated: %v", infos)
+	}
+}
+
+func receivePayload(t *testing.T, ch chan *mirageecs.HookPayload) *mirageecs.HookPayload {
+	t.Helper()
+	select {
+	case p := <-ch:
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("hook is not called")
+	}
+	return nil
+}
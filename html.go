@@ -0,0 +1,95 @@
+package mirageecs
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// DefaultHtmlDir is the default htmldir. It is not required to exist, unlike other directories.
+const DefaultHtmlDir = "./html"
+
+// defaultHTML has the default templates and static assets, which are overridden by files in htmldir.
+//
+//go:embed html
+var defaultHTML embed.FS
+
+// templateFuncs are functions available in templates.
+var templateFuncs = template.FuncMap{
+	"t": translateTemplate,
+}
+
+// loadTemplates parses the default templates, and templates in the dir over them.
+// Templates in the dir replace the default templates of the same names.
+func loadTemplates(dir string) (*template.Template, error) {
+	t, err := template.New("").Funcs(templateFuncs).ParseFS(defaultHTML, "html/*.html")
+	if err != nil {
+		return nil, err
+	}
+	files, err := templateFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return t, nil
+	}
+	slog.Info(f("loading %d template files from %s", len(files), dir))
+	return t.ParseFiles(files...)
+}
+
+// templateFiles returns files in the dir. Missing DefaultHtmlDir has no files.
+func templateFiles(dir string) ([]string, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(dir); err != nil {
+		if errors.Is(err, fs.ErrNotExist) && dir == DefaultHtmlDir {
+			slog.Debug(f("%s is not found, using default templates", dir))
+			return nil, nil
+		}
+		return nil, fmt.Errorf("htmldir: %w", err)
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(matches))
+	for _, m := range matches {
+		if st, err := os.Stat(m); err != nil {
+			return nil, err
+		} else if st.Mode().IsRegular() {
+			files = append(files, m)
+		}
+	}
+	return files, nil
+}
+
+// staticFS returns static assets in static/ of the dir over the default assets.
+func staticFS(dir string) fs.FS {
+	defaults, _ := fs.Sub(defaultHTML, "html/static")
+	if dir == "" {
+		return defaults
+	}
+	return overlayFS{os.DirFS(filepath.Join(dir, "static")), defaults}
+}
+
+// overlayFS opens files from the first FS which has them.
+type overlayFS []fs.FS
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	for _, fsys := range o {
+		f, err := fsys.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
@@ -0,0 +1,92 @@
+package mirageecs_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestLoadTemplates(t *testing.T) {
+	// the default templates are embedded
+	tmpl, err := mirageecs.LoadTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"layout.html", "list.html", "launcher.html", "notfound.html", "waiting.html", "logs.html", "access.html", "purge.html", "stopped.html"} {
+		if tmpl.Lookup(name) == nil {
+			t.Errorf("%s is not found", name)
+		}
+	}
+
+	// missing default dir uses the default templates, but other dirs are errors
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if _, err := mirageecs.LoadTemplates(mirageecs.DefaultHtmlDir); err != nil {
+		t.Errorf("missing %s must not be an error: %s", mirageecs.DefaultHtmlDir, err)
+	}
+	if _, err := mirageecs.LoadTemplates("./custom"); err == nil {
+		t.Error("missing ./custom must be an error")
+	}
+}
+
+func TestHtmlDirOverride(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "static"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"layout.html":     `<html>custom layout {{ .Version }}</html>`,
+		"static/logo.svg": `<svg></svg>`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg, err := newConfigFromYAML(t, "htmldir: "+dir+"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := mirageecs.NewWebApi(cfg, &mirageecs.LocalTaskRunner{})
+	ts := httptest.NewServer(app)
+	defer ts.Close()
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(b)
+	}
+	if code, body := get("/"); code != http.StatusOK || !strings.HasPrefix(body, "<html>custom layout") {
+		t.Errorf("unexpected response of /: %d %s", code, body)
+	}
+	if code, body := get("/static/logo.svg"); code != http.StatusOK || body != files["static/logo.svg"] {
+		t.Errorf("unexpected response of /static/logo.svg: %d %s", code, body)
+	}
+	if code, _ := get("/static/missing.css"); code != http.StatusNotFound {
+		t.Errorf("unexpected status of /static/missing.css: %d", code)
+	}
+
+	// templates which are not overridden are the defaults
+	var buf bytes.Buffer
+	if err := app.Renderer.Render(&buf, "notfound.html", map[string]interface{}{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<html") {
+		t.Errorf("unexpected notfound.html: %s", buf.String())
+	}
+}
@@ -0,0 +1,192 @@
+package mirageecs
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/text/language"
+)
+
+// DefaultLocale is the locale of messages in the code and templates. It has no catalog.
+const DefaultLocale = "en"
+
+// localeFiles has message catalogs of locales (e.g. locales/ja.json).
+// A catalog maps messages in English to translations. Messages may be formats of fmt (e.g. "subdomain %s is not found").
+//
+//go:embed locales
+var localeFiles embed.FS
+
+var (
+	catalogs      = mustLoadCatalogs(localeFiles)
+	localeMatcher = language.NewMatcher(localeTags())
+	formatVerb    = regexp.MustCompile(`%(\[\d+\])?[-+# 0-9.]*[a-zA-Z]`)
+)
+
+// catalog is translations of messages of a locale.
+type catalog struct {
+	messages map[string]string
+	// formats match formatted messages, to translate messages which are not formatted by templates (e.g. errors).
+	formats []*messageFormat
+}
+
+type messageFormat struct {
+	pattern     *regexp.Regexp
+	translation string
+}
+
+func mustLoadCatalogs(fsys fs.FS) map[string]*catalog {
+	c, err := loadCatalogs(fsys)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func loadCatalogs(fsys fs.FS) (map[string]*catalog, error) {
+	files, err := fs.Glob(fsys, "locales/*.json")
+	if err != nil {
+		return nil, err
+	}
+	catalogs := make(map[string]*catalog, len(files))
+	for _, file := range files {
+		b, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		c := &catalog{}
+		if err := json.Unmarshal(b, &c.messages); err != nil {
+			return nil, fmt.Errorf("invalid catalog %s: %w", file, err)
+		}
+		for msg, translation := range c.messages {
+			if !formatVerb.MatchString(msg) {
+				continue
+			}
+			// verbs match any text, and the translation takes them as strings
+			parts := formatVerb.Split(msg, -1)
+			for i := range parts {
+				parts[i] = regexp.QuoteMeta(parts[i])
+			}
+			c.formats = append(c.formats, &messageFormat{
+				pattern:     regexp.MustCompile("^" + strings.Join(parts, "(.+?)") + "$"),
+				translation: formatVerb.ReplaceAllString(translation, "%${1}s"),
+			})
+		}
+		// longer formats are more specific
+		sort.SliceStable(c.formats, func(i, j int) bool {
+			return len(c.formats[i].pattern.String()) > len(c.formats[j].pattern.String())
+		})
+		catalogs[strings.TrimSuffix(path.Base(file), ".json")] = c
+	}
+	return catalogs, nil
+}
+
+// localeTags returns supported locales. The first is DefaultLocale.
+func localeTags() []language.Tag {
+	tags := []language.Tag{language.Make(DefaultLocale)}
+	for _, locale := range supportedLocales() {
+		if locale != DefaultLocale {
+			tags = append(tags, language.Make(locale))
+		}
+	}
+	return tags
+}
+
+// supportedLocales returns DefaultLocale and locales which have catalogs.
+func supportedLocales() []string {
+	locales := []string{DefaultLocale}
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales[1:])
+	return locales
+}
+
+func validateLocale(locale string) error {
+	for _, l := range supportedLocales() {
+		if l == locale {
+			return nil
+		}
+	}
+	return fmt.Errorf("locale must be one of %s: %s", strings.Join(supportedLocales(), ", "), locale)
+}
+
+// negotiateLocale returns the locale preferred by Accept-Language, or the default locale.
+func negotiateLocale(acceptLanguage, defaultLocale string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return defaultLocale
+	}
+	_, i, confidence := localeMatcher.Match(tags...)
+	if confidence == language.No {
+		return defaultLocale
+	}
+	base, _ := localeTags()[i].Base()
+	return base.String()
+}
+
+// requestLocale returns the locale of the request.
+func (c *Config) requestLocale(ctx echo.Context) string {
+	if ctx == nil {
+		return c.Locale
+	}
+	return negotiateLocale(ctx.Request().Header.Get("Accept-Language"), c.Locale)
+}
+
+// translateTemplate translates the message in templates, and formats it with args.
+// e.g. {{ t $.Locale "%d requests in the last 24 hours" .Total }}
+func translateTemplate(locale, msg string, args ...interface{}) string {
+	if c, ok := catalogs[locale]; ok {
+		if s, ok := c.messages[msg]; ok {
+			msg = s
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// translate translates the formatted message (e.g. an error) by formats in the catalog.
+// Arguments in the message are kept as is, because they are often names (e.g. of parameters). Unknown messages are returned as is.
+func translate(locale, msg string) string {
+	c, ok := catalogs[locale]
+	if !ok {
+		return msg
+	}
+	if s, ok := c.messages[msg]; ok {
+		return s
+	}
+	for _, format := range c.formats {
+		m := format.pattern.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+		args := make([]interface{}, 0, len(m)-1)
+		for _, arg := range m[1:] {
+			args = append(args, arg)
+		}
+		return fmt.Sprintf(format.translation, args...)
+	}
+	return msg
+}
+
+// localizedJSONSerializer translates results of error responses into the locale of the request.
+type localizedJSONSerializer struct {
+	echo.DefaultJSONSerializer
+	cfg *Config
+}
+
+func (s *localizedJSONSerializer) Serialize(c echo.Context, i interface{}, indent string) error {
+	if r, ok := i.(APICommonResponse); ok && c.Response().Status >= 400 {
+		r.Result = translate(s.cfg.requestLocale(c), r.Result)
+		i = r
+	}
+	return s.DefaultJSONSerializer.Serialize(c, i, indent)
+}
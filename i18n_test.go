@@ -0,0 +1,135 @@
+package mirageecs_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestNegotiateLocale(t *testing.T) {
+	for _, c := range []struct {
+		acceptLanguage string
+		defaultLocale  string
+		want           string
+	}{
+		{"", "en", "en"},
+		{"", "ja", "ja"},
+		{"ja", "en", "ja"},
+		{"ja-JP,ja;q=0.9,en-US;q=0.8,en;q=0.7", "en", "ja"},
+		{"en-US,en;q=0.9,ja;q=0.8", "ja", "en"},
+		{"fr-FR,fr;q=0.9", "ja", "ja"},
+		{"invalid;;", "ja", "ja"},
+	} {
+		if got := mirageecs.NegotiateLocale(c.acceptLanguage, c.defaultLocale); got != c.want {
+			t.Errorf("NegotiateLocale(%q, %q) = %s, want %s", c.acceptLanguage, c.defaultLocale, got, c.want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	for _, c := range []struct {
+		locale string
+		msg    string
+		want   string
+	}{
+		{"en", "subdomain foo is not found", "subdomain foo is not found"},
+		{"ja", "subdomain foo is not found", "サブドメイン foo が見つかりません"},
+		{"ja", "parameter required: subdomain", "パラメータが必要です: subdomain"},
+		{"ja", "parameter branch value x is not an integer", "パラメータ branch の値 x は整数ではありません"},
+		// arguments are reordered
+		{"ja", "quota exceeded: 3 environments of team=a are running (max_environments 3): [x y z]",
+			"クォータを超えています: team=a の環境が 3 個実行中です (max_environments 3): [x y z]"},
+		// unknown messages are kept as is
+		{"ja", "something went wrong", "something went wrong"},
+	} {
+		if got := mirageecs.Translate(c.locale, c.msg); got != c.want {
+			t.Errorf("Translate(%s, %q) = %q, want %q", c.locale, c.msg, got, c.want)
+		}
+	}
+	if got := mirageecs.TranslateMessage("ja", "max %d requests per %s", 12, "10m0s"); got != "10m0s あたり最大 12 リクエスト" {
+		t.Errorf("unexpected message: %s", got)
+	}
+	if got := mirageecs.TranslateMessage("en", "max %d requests per %s", 12, "10m0s"); got != "max 12 requests per 10m0s" {
+		t.Errorf("unexpected message: %s", got)
+	}
+}
+
+func TestCatalogVerbs(t *testing.T) {
+	verb := regexp.MustCompile(`%(\[\d+\])?[a-z]`)
+	for msg, translation := range mirageecs.CatalogMessages("ja") {
+		if len(verb.FindAllString(msg, -1)) != len(verb.FindAllString(translation, -1)) {
+			t.Errorf("verbs of the translation of %q do not match: %q", msg, translation)
+		}
+	}
+}
+
+func TestLocalizedWebApi(t *testing.T) {
+	cfg, err := newConfigFromYAML(t, "htmldir: ./html\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := mirageecs.NewWebApi(cfg, &mirageecs.LocalTaskRunner{})
+
+	get := func(path, acceptLanguage string) string {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, req)
+		b, _ := io.ReadAll(rec.Body)
+		return string(b)
+	}
+	if body := get("/", "ja-JP,ja;q=0.9"); !strings.Contains(body, `<html lang="ja">`) || !strings.Contains(body, "新しい環境を起動") {
+		t.Errorf("top page is not translated: %s", body)
+	}
+	if body := get("/", "en-US"); !strings.Contains(body, `<html lang="en">`) || !strings.Contains(body, "Launch New Task") {
+		t.Errorf("top page should be in English: %s", body)
+	}
+	if body := get("/launcher", "ja"); !strings.Contains(body, `tr("%s は必須です。", label)`) {
+		t.Errorf("launcher is not translated: %s", body)
+	}
+
+	// API errors
+	var res mirageecs.APICommonResponse
+	if err := json.Unmarshal([]byte(get("/api/logs", "ja")), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Result != "パラメータが必要です: subdomain" {
+		t.Errorf("unexpected error: %s", res.Result)
+	}
+
+	// errors shown in the launcher
+	req := httptest.NewRequest(http.MethodPost, "/launch", strings.NewReader(url.Values{"subdomain": {"-"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "http://"+cfg.Host.WebApi)
+	req.Header.Set("Hx-Request", "true")
+	req.Header.Set("Accept-Language", "ja")
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || rec.Body.String() != "サブドメインが短すぎます" {
+		t.Errorf("unexpected response: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestDefaultLocale(t *testing.T) {
+	cfg, err := newConfigFromYAML(t, "locale: ja\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := mirageecs.NewWebApi(cfg, &mirageecs.LocalTaskRunner{})
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(rec.Body.String(), "新しい環境を起動") {
+		t.Errorf("top page is not translated by the default locale: %s", rec.Body.String())
+	}
+
+	if _, err := newConfigFromYAML(t, "locale: fr\n"); err == nil || !strings.Contains(err.Error(), "locale must be one of en, ja") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
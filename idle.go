@@ -0,0 +1,115 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+const (
+	// SleepReasonIdle is a reason of environments stopped by idle_stop.
+	SleepReasonIdle = "idle"
+
+	idleStopInterval = 10 * time.Minute
+)
+
+// IdleStopRule stops (not terminates) environments which have no requests for the duration.
+// Stopped environments are resumed by host.on_demand.wake.
+type IdleStopRule struct {
+	// Tag is a key of the tag (or a name of the parameter). Empty matches all environments.
+	Tag string `yaml:"tag"`
+	// Value is a value of the tag. Empty matches any value.
+	Value string `yaml:"value"`
+	// Duration is a duration without requests to stop environments. e.g. 3h
+	Duration time.Duration `yaml:"duration"`
+}
+
+func (r *IdleStopRule) validate() error {
+	if r.Duration < PurgeMinimumDuration {
+		return fmt.Errorf("idle_stop[].duration must be at least %s: %s", PurgeMinimumDuration, r.Duration)
+	}
+	return nil
+}
+
+func (r *IdleStopRule) match(tags []types.Tag) bool {
+	if r.Tag == "" {
+		return true
+	}
+	v := getTag(tags, r.Tag)
+	if r.Value == "" {
+		return v != ""
+	}
+	return v == r.Value
+}
+
+// idleStopFor returns the first rule matched with the tags.
+func (c ECSCfg) idleStopFor(tags []types.Tag) (*IdleStopRule, bool) {
+	for _, r := range c.IdleStop {
+		if r.match(tags) {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// idleCandidates returns subdomains which may be idle with durations of their rules.
+// Environments which are protected, not ready or launched within the duration are excluded.
+func (c ECSCfg) idleCandidates(running []*Information, now time.Time) map[string]time.Duration {
+	candidates := make(map[string]time.Duration)
+	excluded := make(map[string]struct{})
+	for _, info := range running {
+		if _, ok := excluded[info.SubDomain]; ok {
+			continue
+		}
+		r, ok := c.idleStopFor(info.Tags)
+		if !ok || info.Protected || !info.Ready || info.Created.After(now.Add(-r.Duration)) {
+			excluded[info.SubDomain] = struct{}{}
+			delete(candidates, info.SubDomain)
+			continue
+		}
+		candidates[info.SubDomain] = r.Duration
+	}
+	return candidates
+}
+
+// RunIdleStopper stops idle environments periodically.
+func (m *Mirage) RunIdleStopper(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	tk := time.NewTicker(idleStopInterval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			slog.Debug("RunIdleStopper() is done")
+			return
+		}
+		m.stopIdle(ctx)
+	}
+}
+
+func (m *Mirage) stopIdle(ctx context.Context) {
+	running, err := m.runner.List(ctx, statusRunning)
+	if err != nil {
+		slog.Warn(f("failed to list tasks to stop idle environments: %s", err))
+		return
+	}
+	for subdomain, d := range m.Config.ECS.idleCandidates(running, time.Now()) {
+		sum, err := m.runner.GetAccessCount(ctx, subdomain, d)
+		if err != nil {
+			slog.Warn(f("access count failed: %s %s", subdomain, err))
+			continue
+		}
+		if sum > 0 {
+			continue
+		}
+		slog.Info(f("subdomain %s has no access for %s. stopping", subdomain, d))
+		if err := m.runner.Sleep(ctx, subdomain, SleepReasonIdle); err != nil {
+			slog.Warn(f("failed to stop idle subdomain %s: %s", subdomain, err))
+		}
+	}
+}
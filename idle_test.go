@@ -0,0 +1,64 @@
+package mirageecs_test
+
+import (
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIdleStopRuleValidate(t *testing.T) {
+	if err := (&mirageecs.IdleStopRule{Duration: time.Hour}).Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	for _, d := range []time.Duration{0, time.Minute} {
+		if err := (&mirageecs.IdleStopRule{Duration: d}).Validate(); err == nil {
+			t.Errorf("expected error for duration %s", d)
+		}
+	}
+}
+
+func TestIdleCandidates(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cfg := mirageecs.ECSCfg{
+		IdleStop: []*mirageecs.IdleStopRule{
+			{Tag: "Env", Value: "dev", Duration: time.Hour},
+			{Tag: "Env", Value: "stg", Duration: 6 * time.Hour},
+		},
+	}
+	tags := func(env string) []types.Tag {
+		return []types.Tag{{Key: aws.String("Env"), Value: aws.String(env)}}
+	}
+	running := []*mirageecs.Information{
+		{SubDomain: "dev-old", Tags: tags("dev"), Ready: true, Created: now.Add(-2 * time.Hour)},
+		{SubDomain: "dev-new", Tags: tags("dev"), Ready: true, Created: now.Add(-30 * time.Minute)},
+		{SubDomain: "stg-old", Tags: tags("stg"), Ready: true, Created: now.Add(-2 * time.Hour)},
+		{SubDomain: "prd", Tags: tags("prd"), Ready: true, Created: now.Add(-24 * time.Hour)},
+		{SubDomain: "protected", Tags: tags("dev"), Ready: true, Protected: true, Created: now.Add(-2 * time.Hour)},
+		{SubDomain: "not-ready", Tags: tags("dev"), Created: now.Add(-2 * time.Hour)},
+		// one of tasks is relaunched recently
+		{SubDomain: "multi", Tags: tags("dev"), Ready: true, Created: now.Add(-2 * time.Hour)},
+		{SubDomain: "multi", Tags: tags("dev"), Ready: true, Created: now.Add(-10 * time.Minute)},
+	}
+	expected := map[string]time.Duration{
+		"dev-old": time.Hour,
+	}
+	if diff := cmp.Diff(expected, cfg.IdleCandidates(running, now)); diff != "" {
+		t.Errorf("unexpected candidates (-want +got):\n%s", diff)
+	}
+
+	all := mirageecs.ECSCfg{
+		IdleStop: []*mirageecs.IdleStopRule{{Duration: time.Hour}},
+	}
+	expected = map[string]time.Duration{
+		"dev-old": time.Hour,
+		"stg-old": time.Hour,
+		"prd":     time.Hour,
+	}
+	if diff := cmp.Diff(expected, all.IdleCandidates(running, now)); diff != "" {
+		t.Errorf("unexpected candidates (-want +got):\n%s", diff)
+	}
+}
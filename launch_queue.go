@@ -0,0 +1,240 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+)
+
+const (
+	LaunchJobQueued   = "queued"
+	LaunchJobLaunched = "launched"
+	LaunchJobFailed   = "failed"
+
+	DefaultLaunchQueueWindow      = 30 * time.Minute
+	DefaultLaunchQueueInterval    = 30 * time.Second
+	DefaultLaunchQueueMaxInterval = 5 * time.Minute
+
+	// launchJobRetention is a time to keep finished jobs.
+	launchJobRetention = time.Hour
+)
+
+// capacityErrorPatterns are substrings of errors (and failure reasons of RunTask) caused by exhausted capacity or quotas.
+var capacityErrorPatterns = []string{
+	"RESOURCE:",                         // no container instance has enough CPU, memory, ports or ENIs
+	"Capacity is unavailable",           // Fargate (Spot) capacity
+	"reached the limit on the number",   // service quotas of running tasks
+	"exceeded your account quota",       // service quotas of vCPUs
+	"insufficient capacity",             // capacity providers
+	"No Container Instances were found", // no EC2 capacity
+}
+
+func isCapacityError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return lo.SomeBy(capacityErrorPatterns, func(p string) bool {
+		return strings.Contains(msg, p)
+	})
+}
+
+// LaunchQueue retries launches which failed by exhausted capacity with exponential backoff for the window.
+type LaunchQueue struct {
+	// Window is the time to keep retrying the launch. Default is 30m.
+	Window time.Duration `yaml:"window"`
+	// Interval is the first interval of retries. Default is 30s.
+	Interval time.Duration `yaml:"interval"`
+	// MaxInterval is the max interval of retries. Default is 5m.
+	MaxInterval time.Duration `yaml:"max_interval"`
+}
+
+func (q *LaunchQueue) validate() error {
+	if q == nil {
+		return nil
+	}
+	if q.Window == 0 {
+		q.Window = DefaultLaunchQueueWindow
+	}
+	if q.Interval == 0 {
+		q.Interval = DefaultLaunchQueueInterval
+	}
+	if q.MaxInterval == 0 {
+		q.MaxInterval = DefaultLaunchQueueMaxInterval
+	}
+	if q.Interval < time.Second || q.MaxInterval < q.Interval {
+		return fmt.Errorf("launch_queue.interval must be at least 1s and max_interval must not be less than interval")
+	}
+	if q.Window < q.Interval {
+		return fmt.Errorf("launch_queue.window must not be less than interval")
+	}
+	return nil
+}
+
+func (q *LaunchQueue) backoff(attempts int) time.Duration {
+	d := q.Interval
+	for i := 1; i < attempts && d < q.MaxInterval; i++ {
+		d *= 2
+	}
+	return min(d, q.MaxInterval)
+}
+
+// LaunchJob is a launch in the queue.
+type LaunchJob struct {
+	Subdomain   string     `json:"subdomain"`
+	Taskdefs    []string   `json:"taskdefs"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	QueuedAt    time.Time  `json:"queued_at"`
+	NextRetryAt *time.Time `json:"next_retry_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+
+	param  TaskParameter
+	opt    *LaunchOption
+	cancel context.CancelFunc
+}
+
+// launchQueue runs launch jobs in the background.
+type launchQueue struct {
+	cfg    *LaunchQueue
+	runner TaskRunner
+
+	mu   sync.Mutex
+	jobs map[string]*LaunchJob // subdomain -> job
+}
+
+func newLaunchQueue(cfg *LaunchQueue, runner TaskRunner) *launchQueue {
+	if cfg == nil {
+		return nil
+	}
+	return &launchQueue{cfg: cfg, runner: runner, jobs: make(map[string]*LaunchJob)}
+}
+
+// enqueue queues the launch which failed by the error. A queued launch of the same subdomain is replaced.
+func (q *launchQueue) enqueue(subdomain string, param TaskParameter, opt *LaunchOption, taskdefs []string, err error) *LaunchJob {
+	now := time.Now()
+	next := now.Add(q.cfg.backoff(1))
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(q.cfg.Window))
+	job := &LaunchJob{
+		Subdomain:   subdomain,
+		Taskdefs:    taskdefs,
+		Status:      LaunchJobQueued,
+		Attempts:    1,
+		QueuedAt:    now,
+		NextRetryAt: &next,
+		LastError:   err.Error(),
+		param:       param,
+		opt:         opt,
+		cancel:      cancel,
+	}
+	q.mu.Lock()
+	if prev, ok := q.jobs[subdomain]; ok && prev.cancel != nil {
+		prev.cancel()
+	}
+	q.jobs[subdomain] = job
+	q.mu.Unlock()
+	slog.Warn(f("launch of subdomain %s is queued: %s", subdomain, err))
+	go q.run(ctx, job)
+	return job
+}
+
+// cancel cancels the queued launch of the subdomain. e.g. when the subdomain is launched or terminated.
+func (q *launchQueue) cancel(subdomain string) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if job, ok := q.jobs[subdomain]; ok && job.Status == LaunchJobQueued {
+		job.cancel()
+		delete(q.jobs, subdomain)
+	}
+}
+
+func (q *launchQueue) run(ctx context.Context, job *LaunchJob) {
+	defer job.cancel()
+	for {
+		q.mu.Lock()
+		wait := time.Until(*job.NextRetryAt)
+		q.mu.Unlock()
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			q.finish(job, LaunchJobFailed, fmt.Errorf("gave up retrying the launch in %s: %s", q.cfg.Window, job.LastError))
+			return
+		}
+		launchCtx, cancel := context.WithTimeout(ctx, APICallTimeout)
+		err := q.runner.Launch(launchCtx, job.Subdomain, job.param, job.opt, job.Taskdefs...)
+		cancel()
+		if err == nil {
+			q.finish(job, LaunchJobLaunched, nil)
+			return
+		}
+		if ctx.Err() != nil {
+			// canceled by another launch, or the window is over
+			q.finish(job, LaunchJobFailed, fmt.Errorf("gave up retrying the launch: %s", err))
+			return
+		}
+		if !isCapacityError(err) {
+			q.finish(job, LaunchJobFailed, err)
+			return
+		}
+		q.mu.Lock()
+		job.Attempts++
+		next := time.Now().Add(q.cfg.backoff(job.Attempts))
+		job.NextRetryAt = &next
+		job.LastError = err.Error()
+		q.mu.Unlock()
+		slog.Warn(f("launch of subdomain %s failed (attempt %d). retrying at %s: %s", job.Subdomain, job.Attempts, next.Format(time.RFC3339), err))
+	}
+}
+
+func (q *launchQueue) finish(job *LaunchJob, status string, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	job.Status = status
+	job.FinishedAt = &now
+	job.NextRetryAt = nil
+	if err != nil {
+		job.LastError = err.Error()
+		slog.Warn(f("queued launch of subdomain %s failed: %s", job.Subdomain, err))
+	} else {
+		slog.Info(f("queued launch of subdomain %s succeeded after %d attempts", job.Subdomain, job.Attempts))
+	}
+}
+
+// list returns jobs in the queue and jobs finished recently.
+func (q *launchQueue) list() []LaunchJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]LaunchJob, 0, len(q.jobs))
+	for subdomain, job := range q.jobs {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > launchJobRetention {
+			delete(q.jobs, subdomain)
+			continue
+		}
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].QueuedAt.Before(jobs[j].QueuedAt)
+	})
+	return jobs
+}
+
+// ApiQueue returns launches in the queue.
+func (api *WebApi) ApiQueue(c echo.Context) error {
+	if api.launchQueue == nil {
+		return c.JSON(http.StatusOK, APIQueueResponse{Result: []LaunchJob{}})
+	}
+	return c.JSON(http.StatusOK, APIQueueResponse{Result: api.launchQueue.list()})
+}
@@ -0,0 +1,112 @@
+package mirageecs_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestIsCapacityError(t *testing.T) {
+	for msg, expected := range map[string]bool{
+		"run task failed. reason:RESOURCE:MEMORY arn:(unknown)":                    true,
+		"run task failed. reason:Capacity is unavailable at this time. arn:xxx":    true,
+		"You've reached the limit on the number of tasks you can run concurrently": true,
+		"failed to describe task definition: not found":                            false,
+	} {
+		if got := mirageecs.IsCapacityError(errors.New(msg)); got != expected {
+			t.Errorf("IsCapacityError(%s) = %v, want %v", msg, got, expected)
+		}
+	}
+}
+
+func TestLaunchQueueBackoff(t *testing.T) {
+	q := &mirageecs.LaunchQueue{}
+	if err := q.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for attempts, expected := range map[int]time.Duration{
+		1: 30 * time.Second,
+		2: time.Minute,
+		4: 4 * time.Minute,
+		5: 5 * time.Minute,
+		9: 5 * time.Minute,
+	} {
+		if got := q.Backoff(attempts); got != expected {
+			t.Errorf("Backoff(%d) = %s, want %s", attempts, got, expected)
+		}
+	}
+	if err := (&mirageecs.LaunchQueue{Interval: time.Minute, MaxInterval: time.Second}).Validate(); err == nil {
+		t.Error("expected error for max_interval less than interval")
+	}
+}
+
+// capacityRunner fails launches by exhausted capacity until the count reaches zero.
+type capacityRunner struct {
+	mirageecs.TaskRunner
+
+	mu       sync.Mutex
+	failures int
+	launched []string
+}
+
+func (r *capacityRunner) Launch(ctx context.Context, subdomain string, param mirageecs.TaskParameter, opt *mirageecs.LaunchOption, taskdefs ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("run task failed. reason:RESOURCE:CPU arn:(unknown)")
+	}
+	r.launched = append(r.launched, subdomain)
+	return nil
+}
+
+func TestLaunchQueue(t *testing.T) {
+	runner := &capacityRunner{failures: 2}
+	q := mirageecs.NewLaunchQueue(&mirageecs.LaunchQueue{
+		Window:      time.Second,
+		Interval:    10 * time.Millisecond,
+		MaxInterval: 20 * time.Millisecond,
+	}, runner)
+	q.Enqueue("foo", mirageecs.TaskParameter{"branch": "develop"}, []string{"app:1"}, errors.New("RESOURCE:CPU"))
+	jobs := q.List()
+	if len(jobs) != 1 || jobs[0].Status != mirageecs.LaunchJobQueued {
+		t.Fatalf("unexpected jobs: %#v", jobs)
+	}
+
+	for i := 0; i < 50; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if jobs = q.List(); jobs[0].Status != mirageecs.LaunchJobQueued {
+			break
+		}
+	}
+	if jobs[0].Status != mirageecs.LaunchJobLaunched || jobs[0].Attempts != 3 {
+		t.Errorf("unexpected job: %#v", jobs[0])
+	}
+	if len(runner.launched) != 1 || runner.launched[0] != "foo" {
+		t.Errorf("unexpected launches: %v", runner.launched)
+	}
+}
+
+func TestLaunchQueueGiveUp(t *testing.T) {
+	runner := &capacityRunner{failures: 1000}
+	q := mirageecs.NewLaunchQueue(&mirageecs.LaunchQueue{
+		Window:      100 * time.Millisecond,
+		Interval:    10 * time.Millisecond,
+		MaxInterval: 10 * time.Millisecond,
+	}, runner)
+	q.Enqueue("foo", nil, []string{"app:1"}, errors.New("RESOURCE:CPU"))
+	var jobs []mirageecs.LaunchJob
+	for i := 0; i < 50; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if jobs = q.List(); jobs[0].Status != mirageecs.LaunchJobQueued {
+			break
+		}
+	}
+	if jobs[0].Status != mirageecs.LaunchJobFailed || jobs[0].LastError == "" {
+		t.Errorf("unexpected job: %#v", jobs[0])
+	}
+}
@@ -0,0 +1,111 @@
+package mirageecs
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// DefaultLaunchWaitTimeout is the timeout of /api/launch with wait=true without timeout.
+	DefaultLaunchWaitTimeout = 300 * time.Second
+	// MaxLaunchWaitTimeout is the maximum timeout of /api/launch with wait=true.
+	MaxLaunchWaitTimeout = time.Hour
+)
+
+// launchWaitInterval is an interval to check whether the launched environment is routable.
+var launchWaitInterval = 2 * time.Second
+
+// waitLaunch responds to /api/launch with wait=true when the environment is routable, or the timeout hits.
+// Running tasks of the subdomain are new tasks, because old tasks are terminated by the launch.
+func (api *WebApi) waitLaunch(c echo.Context, subdomain string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+	defer cancel()
+	ctx = withLogAttrs(ctx, slog.String("subdomain", subdomain))
+	res := APILaunchWaitResponse{
+		URL: c.Scheme() + "://" + subdomain + api.cfg.Host.ReverseProxySuffix,
+	}
+	ticker := time.NewTicker(launchWaitInterval)
+	defer ticker.Stop()
+	for {
+		routable, tasks, err := api.launchedTasks(ctx, subdomain)
+		if err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, f("failed to list tasks to wait for the launch: %s", err))
+		}
+		if tasks != nil {
+			res.Tasks = tasks
+		}
+		res.Status = environmentState(res.Tasks)
+		if routable {
+			res.Result = "ok"
+			return c.JSON(http.StatusOK, res)
+		}
+		select {
+		case <-ctx.Done():
+			if c.Request().Context().Err() != nil {
+				// the client has gone
+				return nil
+			}
+			slog.WarnContext(ctx, f("subdomain %s is not routable in %s: %s", subdomain, timeout, res.Status))
+			res.Result = "timeout"
+			return c.JSON(http.StatusGatewayTimeout, res)
+		case <-ticker.C:
+		}
+	}
+}
+
+// launchedTasks returns running tasks of the subdomain, and whether all of them are ready and routed by the reverse proxy.
+// Tasks without ports are not routed, so they are only required to be ready.
+func (api *WebApi) launchedTasks(ctx context.Context, subdomain string) (bool, []*Information, error) {
+	infos, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		return false, nil, err
+	}
+	tasks := []*Information{}
+	routable := true
+	for _, info := range infos {
+		if info.SubDomain != subdomain {
+			continue
+		}
+		tasks = append(tasks, info)
+		routable = routable && info.Ready
+		if len(info.PortMap) > 0 && api.routable != nil {
+			routable = routable && api.routable(subdomain, info.IPAddress)
+		}
+	}
+	setStates(tasks)
+	return routable && len(tasks) > 0, tasks, nil
+}
+
+// routesTo reports whether the subdomain is routed to the IP address.
+func (r *ReverseProxy) routesTo(subdomain string, ipaddress string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, handlers := range r.domainMap[subdomain] {
+		for addr := range handlers {
+			if host, _, _ := net.SplitHostPort(addr); host == ipaddress {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// environmentState returns the state of the environment by its tasks. The least progressed state is returned.
+func environmentState(tasks []*Information) string {
+	if len(tasks) == 0 {
+		return StateProvisioning
+	}
+	for _, state := range []string{StateStopping, StateProvisioning, StateStarting, StateUnhealthy} {
+		for _, info := range tasks {
+			if info.State == state {
+				return state
+			}
+		}
+	}
+	return tasks[0].State
+}
@@ -0,0 +1,98 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestAPILaunchWaitTimeout(t *testing.T) {
+	for _, r := range []mirageecs.APILaunchRequest{
+		{Wait: "yes"},
+		{Wait: "true", Timeout: "5m"},
+		{Wait: "true", Timeout: "0"},
+		{Wait: "true", Timeout: "3601"},
+	} {
+		if _, err := r.WaitTimeout(); err == nil {
+			t.Errorf("%#v should be invalid", r)
+		}
+	}
+	for _, tc := range []struct {
+		r       mirageecs.APILaunchRequest
+		timeout time.Duration
+	}{
+		{mirageecs.APILaunchRequest{}, 0},
+		{mirageecs.APILaunchRequest{Wait: "false", Timeout: "10"}, 0},
+		{mirageecs.APILaunchRequest{Wait: "true"}, mirageecs.DefaultLaunchWaitTimeout},
+		{mirageecs.APILaunchRequest{Wait: "true", Timeout: "10"}, 10 * time.Second},
+	} {
+		if d, err := tc.r.WaitTimeout(); err != nil || d != tc.timeout {
+			t.Errorf("unexpected timeout of %#v: %s %v", tc.r, d, err)
+		}
+	}
+}
+
+func TestAPILaunchWait(t *testing.T) {
+	defer mirageecs.SetLaunchWaitInterval(10 * time.Millisecond)()
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cfg.Cleanup()
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	launch := func(query string, body string) (int, mirageecs.APILaunchWaitResponse) {
+		t.Helper()
+		res, err := ts.Client().Post(ts.URL+"/api/launch?"+query, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var r mirageecs.APILaunchWaitResponse
+		if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, r
+	}
+
+	if code, r := launch("wait=true&timeout=abc", `{"subdomain":"foo","taskdef":["app:1"]}`); code != http.StatusBadRequest {
+		t.Errorf("invalid timeout should be rejected: %d %#v", code, r)
+	}
+
+	// not routed by the reverse proxy
+	code, r := launch("wait=true&timeout=1", `{"subdomain":"foo","taskdef":["app:1"],"branch":"develop"}`)
+	if code != http.StatusGatewayTimeout || r.Result != "timeout" || len(r.Tasks) != 1 {
+		t.Errorf("unexpected response: %d %#v", code, r)
+	}
+
+	go func() {
+		// routes are added by the sync loop
+		for i := 0; i < 500; i++ {
+			infos, _ := m.Runner().List(ctx, "RUNNING")
+			for _, info := range infos {
+				if info.SubDomain == "bar" {
+					time.Sleep(100 * time.Millisecond)
+					m.ReverseProxy.AddSubdomain("bar", info.IPAddress, info.PortMap["httpd"], nil)
+					return
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	code, r = launch("", `{"subdomain":"bar","taskdef":["app:1"],"branch":"develop","wait":"true","timeout":"5"}`)
+	if code != http.StatusOK || r.Result != "ok" || r.Status != mirageecs.StateHealthy {
+		t.Errorf("unexpected response: %d %#v", code, r)
+	}
+	if r.URL != "http://bar"+cfg.Host.ReverseProxySuffix {
+		t.Errorf("unexpected url: %s", r.URL)
+	}
+}
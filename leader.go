@@ -0,0 +1,311 @@
+package mirageecs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	DefaultLeaderLockName      = "mirage-ecs"
+	DefaultLeaderLeaseDuration = 30 * time.Second
+)
+
+// HighAvailability runs two or more mirage-ecs processes. All processes serve requests,
+// but background jobs (e.g. purge, relaunch and changes of DNS records) run only on the leader elected by a lock.
+type HighAvailability struct {
+	// DynamoDBTable is a name of the DynamoDB table of the lock, which has the partition key "name" (S).
+	DynamoDBTable string `yaml:"dynamodb_table"`
+	// Redis uses the redis section for the lock.
+	Redis bool `yaml:"redis"`
+	// LockName is a name of the lock. Default is "mirage-ecs". Processes of the same mirage-ecs must have the same name.
+	LockName string `yaml:"lock_name"`
+	// LeaseDuration is a time the leader holds the lock without renewals. Default is 30s.
+	LeaseDuration time.Duration `yaml:"lease_duration"`
+}
+
+func (h *HighAvailability) validate(redis *Redis) error {
+	if h == nil {
+		return nil
+	}
+	if (h.DynamoDBTable == "") == !h.Redis {
+		return fmt.Errorf("either high_availability.dynamodb_table or high_availability.redis is required")
+	}
+	if h.Redis && redis == nil {
+		return fmt.Errorf("high_availability.redis requires the redis section")
+	}
+	if h.LockName == "" {
+		h.LockName = DefaultLeaderLockName
+	}
+	if h.LeaseDuration == 0 {
+		h.LeaseDuration = DefaultLeaderLeaseDuration
+	}
+	if h.LeaseDuration < 3*time.Second {
+		return fmt.Errorf("high_availability.lease_duration must be at least 3s: %s", h.LeaseDuration)
+	}
+	return nil
+}
+
+// leaderLock is a lock held by the leader until the lease expires.
+type leaderLock interface {
+	// acquire acquires or renews the lock for the owner. It returns false when another owner holds the lock.
+	acquire(ctx context.Context, owner string, lease time.Duration) (bool, error)
+	release(ctx context.Context, owner string) error
+}
+
+// leaderElection keeps the state of the leader election of this process.
+type leaderElection struct {
+	cfg   *HighAvailability
+	lock  leaderLock
+	owner string
+
+	mu        sync.Mutex
+	leader    bool
+	renewedAt time.Time
+	changed   chan struct{} // closed when the state is changed
+}
+
+func newLeaderElection(cfg *Config) *leaderElection {
+	h := cfg.HighAvailability
+	if h == nil {
+		return nil
+	}
+	hostname, _ := os.Hostname()
+	e := &leaderElection{
+		cfg:     h,
+		owner:   hostname + "-" + generateRandomHexID(8),
+		changed: make(chan struct{}),
+	}
+	if h.Redis {
+		e.lock = &redisLeaderLock{c: cfg.redis, key: cfg.redis.key("lock:" + h.LockName)}
+	} else {
+		e.lock = &dynamoDBLeaderLock{
+			svc:   dynamodb.NewFromConfig(*cfg.awscfg),
+			table: h.DynamoDBTable,
+			name:  h.LockName,
+		}
+	}
+	return e
+}
+
+// isLeader reports whether this process is the leader. It is always true without high availability.
+func (e *leaderElection) isLeader() bool {
+	if e == nil {
+		return true
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// state returns whether this process is the leader, and a channel closed when the state is changed.
+func (e *leaderElection) state() (bool, <-chan struct{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader, e.changed
+}
+
+func (e *leaderElection) set(leader bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if leader {
+		e.renewedAt = time.Now()
+	}
+	if e.leader == leader {
+		return
+	}
+	e.leader = leader
+	close(e.changed)
+	e.changed = make(chan struct{})
+	if leader {
+		slog.Info(f("became the leader as %s", e.owner))
+	} else {
+		slog.Info(f("stepped down from the leader as %s", e.owner))
+	}
+}
+
+// check reports the state of the election. Followers are healthy, because they serve requests.
+func (e *leaderElection) check(now time.Time) *HealthCheck {
+	msg := "follower"
+	if e.isLeader() {
+		msg = "leader"
+	}
+	return newHealthCheck("leader", now, nil, fmt.Sprintf("%s (%s)", msg, e.owner))
+}
+
+// elect acquires or renews the lock. The leader steps down when the lock cannot be renewed before the lease expires.
+func (e *leaderElection) elect(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.LeaseDuration/3)
+	defer cancel()
+	ok, err := e.lock.acquire(ctx, e.owner, e.cfg.LeaseDuration)
+	if err != nil {
+		slog.Warn(f("failed to acquire the leader lock: %s", err))
+		e.mu.Lock()
+		expired := time.Since(e.renewedAt) > e.cfg.LeaseDuration*2/3
+		e.mu.Unlock()
+		if expired {
+			// another process may acquire the lock soon
+			e.set(false)
+		}
+		return
+	}
+	e.set(ok)
+}
+
+// resign steps down and releases the lock, so another process becomes the leader without waiting for the lease.
+func (e *leaderElection) resign(ctx context.Context) {
+	if !e.isLeader() {
+		return
+	}
+	e.set(false)
+	if err := e.lock.release(ctx, e.owner); err != nil {
+		slog.Warn(f("failed to release the leader lock: %s", err))
+	}
+}
+
+// RunLeaderElection runs the election periodically, and resigns on shutdown.
+func (m *Mirage) RunLeaderElection(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	e := m.election
+	tk := time.NewTicker(e.cfg.LeaseDuration / 3)
+	defer tk.Stop()
+	for {
+		e.elect(ctx)
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			// ctx is canceled. release the lock with a new context
+			rctx, cancel := context.WithTimeout(context.Background(), APICallTimeout)
+			e.resign(rctx)
+			cancel()
+			slog.Debug("RunLeaderElection() is done")
+			return
+		}
+	}
+}
+
+// runAsLeader runs the job while this process is the leader. The job is canceled when this process steps down.
+func (m *Mirage) runAsLeader(ctx context.Context, wg *sync.WaitGroup, job func(context.Context, *sync.WaitGroup)) {
+	defer wg.Done()
+	e := m.election
+	if e == nil {
+		var jwg sync.WaitGroup
+		jwg.Add(1)
+		job(ctx, &jwg)
+		jwg.Wait()
+		return
+	}
+	for {
+		leader, changed := e.state()
+		if leader {
+			jctx, cancel := context.WithCancel(ctx)
+			var jwg sync.WaitGroup
+			jwg.Add(1)
+			go job(jctx, &jwg)
+			select {
+			case <-changed:
+			case <-ctx.Done():
+			}
+			cancel()
+			jwg.Wait()
+		} else {
+			select {
+			case <-changed:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// syncInterval returns the interval to sync routes with running tasks.
+// Followers sync frequently, because task state change events are received by the leader.
+func (m *Mirage) syncInterval() time.Duration {
+	if !m.election.isLeader() {
+		return DefaultSyncInterval
+	}
+	return m.Config.ECS.TaskEvents.syncInterval()
+}
+
+// redisLeaderLock is a leader lock in Redis.
+type redisLeaderLock struct {
+	c   *redisClient
+	key string
+}
+
+func (l *redisLeaderLock) acquire(ctx context.Context, owner string, lease time.Duration) (bool, error) {
+	if ok, err := l.c.extendLock(ctx, l.key, owner, lease); err != nil || ok {
+		return ok, err
+	}
+	return l.c.setLock(ctx, l.key, owner, lease)
+}
+
+func (l *redisLeaderLock) release(ctx context.Context, owner string) error {
+	return l.c.unlock(ctx, l.key, owner)
+}
+
+// dynamoDBLeaderLock is a leader lock in a DynamoDB table.
+// The item of the lock has the owner and the time of the lease expiration (unix time in milliseconds).
+type dynamoDBLeaderLock struct {
+	svc   *dynamodb.Client
+	table string
+	name  string
+}
+
+func (l *dynamoDBLeaderLock) acquire(ctx context.Context, owner string, lease time.Duration) (bool, error) {
+	now := time.Now()
+	_, err := l.svc.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(l.table),
+		Item: map[string]ddbTypes.AttributeValue{
+			"name":        &ddbTypes.AttributeValueMemberS{Value: l.name},
+			"owner":       &ddbTypes.AttributeValueMemberS{Value: owner},
+			"lease_until": &ddbTypes.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(lease).UnixMilli(), 10)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#name) OR #owner = :owner OR #lease_until < :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#name":        "name",
+			"#owner":       "owner",
+			"#lease_until": "lease_until",
+		},
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":owner": &ddbTypes.AttributeValueMemberS{Value: owner},
+			":now":   &ddbTypes.AttributeValueMemberN{Value: strconv.FormatInt(now.UnixMilli(), 10)},
+		},
+	})
+	var failed *ddbTypes.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *dynamoDBLeaderLock) release(ctx context.Context, owner string) error {
+	_, err := l.svc.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(l.table),
+		Key: map[string]ddbTypes.AttributeValue{
+			"name": &ddbTypes.AttributeValueMemberS{Value: l.name},
+		},
+		ConditionExpression:      aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]string{"#owner": "owner"},
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":owner": &ddbTypes.AttributeValueMemberS{Value: owner},
+		},
+	})
+	var failed *ddbTypes.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		// the lock is held by another owner
+		return nil
+	}
+	return err
+}
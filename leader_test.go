@@ -0,0 +1,123 @@
+package mirageecs_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestHighAvailabilityValidate(t *testing.T) {
+	redis := &mirageecs.Redis{Address: "localhost:6379"}
+	for _, c := range []struct {
+		ha    *mirageecs.HighAvailability
+		redis *mirageecs.Redis
+	}{
+		{&mirageecs.HighAvailability{}, redis},
+		{&mirageecs.HighAvailability{DynamoDBTable: "mirage-lock", Redis: true}, redis},
+		{&mirageecs.HighAvailability{Redis: true}, nil},
+		{&mirageecs.HighAvailability{DynamoDBTable: "mirage-lock", LeaseDuration: time.Second}, nil},
+	} {
+		if err := c.ha.Validate(c.redis); err == nil {
+			t.Errorf("%#v should be invalid", c.ha)
+		}
+	}
+	h := &mirageecs.HighAvailability{DynamoDBTable: "mirage-lock"}
+	if err := h.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	if h.LockName != mirageecs.DefaultLeaderLockName || h.LeaseDuration != mirageecs.DefaultLeaderLeaseDuration {
+		t.Errorf("unexpected defaults: %#v", h)
+	}
+}
+
+func newHAMirage(t *testing.T, addr string) *mirageecs.Mirage {
+	t.Helper()
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cfg.Cleanup)
+	cfg.Redis = &mirageecs.Redis{Address: addr}
+	if err := cfg.OpenRedis(); err != nil {
+		t.Fatal(err)
+	}
+	cfg.HighAvailability = &mirageecs.HighAvailability{Redis: true}
+	if err := cfg.HighAvailability.Validate(cfg.Redis); err != nil {
+		t.Fatal(err)
+	}
+	return mirageecs.New(ctx, cfg)
+}
+
+func TestLeaderElection(t *testing.T) {
+	ctx := context.Background()
+	addr := newFakeRedis(t)
+	m1, m2 := newHAMirage(t, addr), newHAMirage(t, addr)
+	if m1.IsLeader() || m2.IsLeader() {
+		t.Fatal("processes are followers before the election")
+	}
+
+	m1.Elect(ctx)
+	m2.Elect(ctx)
+	if !m1.IsLeader() || m2.IsLeader() {
+		t.Fatalf("m1 should be the leader: %v %v", m1.IsLeader(), m2.IsLeader())
+	}
+	// the leader renews the lock
+	m1.Elect(ctx)
+	m2.Elect(ctx)
+	if !m1.IsLeader() || m2.IsLeader() {
+		t.Fatalf("m1 should be still the leader: %v %v", m1.IsLeader(), m2.IsLeader())
+	}
+
+	m1.Resign(ctx)
+	m2.Elect(ctx)
+	m1.Elect(ctx)
+	if m1.IsLeader() || !m2.IsLeader() {
+		t.Fatalf("m2 should be the leader after the resignation: %v %v", m1.IsLeader(), m2.IsLeader())
+	}
+}
+
+func TestRunAsLeader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr := newFakeRedis(t)
+	m := newHAMirage(t, addr)
+
+	started := make(chan struct{}, 1)
+	stopped := make(chan struct{}, 1)
+	job := func(ctx context.Context, wg *sync.WaitGroup) {
+		defer wg.Done()
+		started <- struct{}{}
+		<-ctx.Done()
+		stopped <- struct{}{}
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go m.RunAsLeader(ctx, &wg, job)
+
+	wait := func(ch chan struct{}, what string) {
+		t.Helper()
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("the job is not %s", what)
+		}
+	}
+	select {
+	case <-started:
+		t.Fatal("the job must not run on followers")
+	case <-time.After(100 * time.Millisecond):
+	}
+	m.Elect(ctx)
+	wait(started, "started by the election")
+	m.Resign(ctx)
+	wait(stopped, "stopped by the resignation")
+	m.Elect(ctx)
+	wait(started, "restarted by the election")
+	cancel()
+	wait(stopped, "stopped on shutdown")
+	wg.Wait()
+}
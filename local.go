@@ -51,7 +51,7 @@ func (e *LocalTaskRunner) Trace(_ context.Context, id string) (string, error) {
 	return fmt.Sprintf("mock trace of %s", id), nil
 }
 
-func (e *LocalTaskRunner) Launch(ctx context.Context, subdomain string, option TaskParameter, taskdefs ...string) error {
+func (e *LocalTaskRunner) Launch(ctx context.Context, subdomain string, option TaskParameter, opt *LaunchOption, taskdefs ...string) error {
 	if info, ok := e.find(subdomain); ok {
 		slog.Info(f("subdomain %s is already running task id %s. Terminating...", subdomain, info.ShortID))
 		err := e.TerminateBySubdomain(ctx, subdomain)
@@ -64,6 +64,9 @@ func (e *LocalTaskRunner) Launch(ctx context.Context, subdomain string, option T
 	slog.Info(f("Launching a new mock task: subdomain=%s, taskdef=%s, id=%s", subdomain, taskdefs[0], id))
 	contents := fmt.Sprintf("Hello, Mirage! subdomain: %s\n%#v", subdomain, env)
 	port, stopServerFunc := runMockServer(contents)
+	tags := option.ToECSTags(subdomain, e.cfg.Parameter)
+	tags = append(tags, option.ToPropagatedTags(e.cfg.Parameter, e.cfg.ECS.ParameterTagPrefix)...)
+	tags = append(tags, opt.ToECSTags()...)
 	e.Informations = append(e.Informations, &Information{
 		ID:         "arn:aws:ecs:ap-northeast-1:123456789012:task/mirage/" + id,
 		ShortID:    id,
@@ -73,11 +76,16 @@ func (e *LocalTaskRunner) Launch(ctx context.Context, subdomain string, option T
 		IPAddress:  "127.0.0.1",
 		Created:    time.Now().UTC(),
 		LastStatus: statusRunning,
+		Ready:      true,
 		PortMap: map[string]int{
 			"httpd": port,
 		},
-		Env:  env,
-		Tags: option.ToECSTags(subdomain, e.cfg.Parameter),
+		Env:       env,
+		Tags:      tags,
+		Option:    opt,
+		Protected: opt != nil && opt.Protected,
+
+		ExecuteCommandEnabled: e.cfg.ECS.enableExecuteCommandFor(opt),
 	})
 	e.stopServerFuncs[id] = stopServerFunc
 	e.proxyControlCh <- &proxyControl{
@@ -85,13 +93,35 @@ func (e *LocalTaskRunner) Launch(ctx context.Context, subdomain string, option T
 		Subdomain: subdomain,
 		IPAddress: "127.0.0.1",
 		Port:      port,
+		Option:    opt,
 	}
 	return nil
 }
 
-func (e *LocalTaskRunner) Logs(_ context.Context, subdomain string, since time.Time, tail int) ([]string, error) {
+func (e *LocalTaskRunner) Relaunch(ctx context.Context, info *Information) error {
+	param := taskParameterFromTags(info.Tags, e.cfg.Parameter)
+	return e.Launch(ctx, info.SubDomain, param, info.Option, info.TaskDef)
+}
+
+func (e *LocalTaskRunner) Logs(_ context.Context, subdomain string, since time.Time, tail int, filter *LogsFilter) ([]string, error) {
 	// Logs returns logs of the specified subdomain.
-	return []string{"Sorry. mock server logs are empty."}, nil
+	logs := []string{"Sorry. mock server logs are empty."}
+	return lo.Filter(logs, func(line string, _ int) bool {
+		return filter.match(line)
+	}), nil
+}
+
+func (e *LocalTaskRunner) CheckHealth(_ context.Context) []*HealthCheck {
+	// no dependencies in local mode
+	return nil
+}
+
+func (e *LocalTaskRunner) DownloadLogs(_ context.Context, subdomain string, since time.Time, fn func(*LogLine) error) error {
+	return fn(&LogLine{Time: time.Now().UTC(), Container: "mock", Message: "Sorry. mock server logs are empty."})
+}
+
+func (e *LocalTaskRunner) QueryLogs(_ context.Context, subdomain string, query string, since, until time.Time, limit int32) ([]map[string]string, error) {
+	return []map[string]string{{"@message": "Sorry. mock server logs are empty."}}, nil
 }
 
 func (e *LocalTaskRunner) Terminate(ctx context.Context, id string) error {
@@ -114,6 +144,9 @@ func (e *LocalTaskRunner) find(subdomain string) (*Information, bool) {
 
 func (e *LocalTaskRunner) TerminateBySubdomain(ctx context.Context, subdomain string) error {
 	slog.Info(f("Terminating a mock task: subdomain=%s", subdomain))
+	if info, ok := e.findSleeping(subdomain); ok {
+		e.Informations = lo.Without(e.Informations, info)
+	}
 	if info, ok := e.find(subdomain); ok {
 		if stop := e.stopServerFuncs[info.ShortID]; stop != nil {
 			stop()
@@ -123,6 +156,10 @@ func (e *LocalTaskRunner) TerminateBySubdomain(ctx context.Context, subdomain st
 			Subdomain: subdomain,
 		}
 		info.LastStatus = statusStopped
+		now := time.Now()
+		info.StoppedAt = &now
+		info.StoppedReason = "Terminate requested by Mirage"
+		info.Ready = false
 		e.Informations = lo.Filter(e.Informations, func(i *Information, _ int) bool {
 			return i.ShortID != info.ShortID
 		})
@@ -151,6 +188,77 @@ func runMockServer(content string) (int, func()) {
 	return port, ts.Close
 }
 
+func (e *LocalTaskRunner) Protect(_ context.Context, subdomain string, protected bool) error {
+	info, ok := e.find(subdomain)
+	if !ok {
+		return fmt.Errorf("subdomain %s is not found", subdomain)
+	}
+	info.Protected = protected
+	if info.Option == nil {
+		info.Option = &LaunchOption{}
+	}
+	info.Option.Protected = protected
+	return nil
+}
+
+func (e *LocalTaskRunner) SetAliases(_ context.Context, subdomain string, aliases []string) error {
+	info, ok := e.find(subdomain)
+	if !ok {
+		return fmt.Errorf("subdomain %s is not found", subdomain)
+	}
+	if info.Option == nil {
+		info.Option = &LaunchOption{}
+	}
+	info.Option.Aliases = aliases
+	return nil
+}
+
+func (e *LocalTaskRunner) SetExpiresAt(_ context.Context, subdomain string, t time.Time) error {
+	info, ok := e.find(subdomain)
+	if !ok {
+		return fmt.Errorf("subdomain %s is not found", subdomain)
+	}
+	if info.Option == nil {
+		info.Option = &LaunchOption{}
+	}
+	info.Option.ExpiresAt = &t
+	return nil
+}
+
+func (e *LocalTaskRunner) Sleep(_ context.Context, subdomain string, reason string) error {
+	info, ok := e.find(subdomain)
+	if !ok {
+		return fmt.Errorf("subdomain %s is not found", subdomain)
+	}
+	if stop := e.stopServerFuncs[info.ShortID]; stop != nil {
+		stop()
+	}
+	info.LastStatus = statusSleeping
+	info.SleepReason = reason
+	info.Ready = false
+	slog.Info(f("subdomain %s is sleeping: %s", subdomain, reason))
+	return nil
+}
+
+func (e *LocalTaskRunner) Wake(ctx context.Context, subdomain string) error {
+	info, ok := e.findSleeping(subdomain)
+	if !ok {
+		return fmt.Errorf("subdomain %s is not sleeping", subdomain)
+	}
+	e.Informations = lo.Without(e.Informations, info)
+	return e.Relaunch(ctx, info)
+}
+
+func (e *LocalTaskRunner) ListSleeping(ctx context.Context) ([]*Information, error) {
+	return e.List(ctx, statusSleeping)
+}
+
+func (e *LocalTaskRunner) findSleeping(subdomain string) (*Information, bool) {
+	return lo.Find(e.Informations, func(info *Information) bool {
+		return info.SubDomain == subdomain && info.LastStatus == statusSleeping
+	})
+}
+
 func (e *LocalTaskRunner) GetAccessCount(_ context.Context, subdomain string, duration time.Duration) (int64, error) {
 	slog.Debug("GetAccessCount is not implemented in LocalTaskRunner")
 	return 0, nil
@@ -160,3 +268,8 @@ func (e *LocalTaskRunner) PutAccessCounts(_ context.Context, _ map[string]access
 	slog.Debug("PutAccessCounts is not implemented in LocalTaskRunner")
 	return nil
 }
+
+func (e *LocalTaskRunner) FillWarmPool(_ context.Context) error {
+	slog.Debug("FillWarmPool is not implemented in LocalTaskRunner")
+	return nil
+}
@@ -6,10 +6,16 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net/http"
+	"os"
 	"path"
 	"runtime"
 	"strings"
 	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/samber/lo"
 )
 
 const LogTimeFormat = "2006-01-02T15:04:05.999Z07:00"
@@ -81,7 +87,7 @@ func (h *logHandler) Handle(ctx context.Context, record slog.Record) error {
 }
 
 func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	preformatted := []byte{}
+	preformatted := append([]byte{}, h.preformatted...)
 	for _, a := range attrs {
 		preformatted = append(preformatted, fmt.Sprintf(" [%s:%v]", a.Key, a.Value)...)
 	}
@@ -96,3 +102,122 @@ func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 func (h *logHandler) WithGroup(group string) slog.Handler {
 	return h
 }
+
+type logAttrsKey struct{}
+
+// withLogAttrs returns the context whose logs have the attributes. e.g. request_id, subdomain and taskdef
+func withLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	parent := logAttrsFrom(ctx)
+	merged := make([]slog.Attr, 0, len(parent)+len(attrs))
+	for _, p := range parent {
+		// attributes of the same keys are replaced
+		if !lo.ContainsBy(attrs, func(a slog.Attr) bool { return a.Key == p.Key }) {
+			merged = append(merged, p)
+		}
+	}
+	merged = append(merged, attrs...)
+	return context.WithValue(ctx, logAttrsKey{}, merged)
+}
+
+func logAttrsFrom(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// contextLogHandler adds attributes of contexts to records logged by slog.*Context.
+type contextLogHandler struct {
+	slog.Handler
+}
+
+func (h contextLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs := logAttrsFrom(ctx); len(attrs) > 0 {
+		record = record.Clone()
+		record.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextLogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextLogHandler) WithGroup(name string) slog.Handler {
+	return contextLogHandler{h.Handler.WithGroup(name)}
+}
+
+// newLogger returns the logger which writes logs in the format to the output (stderr, stdout or a file path).
+// The returned func closes the file.
+func newLogger(format, output string) (*slog.Logger, func() error, error) {
+	var w io.Writer
+	closer := func() error { return nil }
+	switch output {
+	case "stderr", "":
+		w = os.Stderr
+	case "stdout":
+		w = os.Stdout
+	default:
+		fp, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open log output: %w", err)
+		}
+		w, closer = fp, fp.Close
+	}
+	opt := &slog.HandlerOptions{
+		Level:     LogLevel,
+		AddSource: true,
+	}
+	var h slog.Handler
+	switch format {
+	case "text", "":
+		h = NewLogHandler(w, opt)
+	case "json":
+		h = slog.NewJSONHandler(w, opt)
+	default:
+		closer()
+		return nil, nil, fmt.Errorf("invalid log format (text or json): %s", format)
+	}
+	return slog.New(contextLogHandler{h}), closer, nil
+}
+
+// logContextMiddleware sets the request ID to the context of the request, so logs of the request have it.
+func logContextMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		id := c.Response().Header().Get(echo.HeaderXRequestID)
+		c.SetRequest(req.WithContext(withLogAttrs(req.Context(), slog.String("request_id", id))))
+		return next(c)
+	}
+}
+
+// accessLogConfig logs requests to the web UI and the API.
+var accessLogConfig = middleware.RequestLoggerConfig{
+	LogMethod:    true,
+	LogURI:       true,
+	LogStatus:    true,
+	LogLatency:   true,
+	LogRemoteIP:  true,
+	LogUserAgent: true,
+	LogError:     true,
+	LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+		level := slog.LevelInfo
+		if v.Status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", v.Method),
+			slog.String("uri", v.URI),
+			slog.Int("status", v.Status),
+			slog.Duration("latency", v.Latency),
+			slog.String("remote_ip", v.RemoteIP),
+			slog.String("user_agent", v.UserAgent),
+		}
+		if v.Error != nil {
+			attrs = append(attrs, slog.String("error", v.Error.Error()))
+		}
+		slog.LogAttrs(c.Request().Context(), level, "access", attrs...)
+		return nil
+	},
+}
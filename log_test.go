@@ -0,0 +1,76 @@
+package mirageecs_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestLoggerWithContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mirage.log")
+	logger, closer, err := mirageecs.NewLogger("json", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := mirageecs.WithLogAttrs(context.Background(), slog.String("request_id", "req-1"), slog.String("taskdef", ""))
+	ctx = mirageecs.WithLogAttrs(ctx, slog.String("subdomain", "foo"), slog.String("taskdef", "myapp:1"))
+	logger.InfoContext(ctx, "launching")
+	logger.Info("without context")
+	if err := closer(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(b), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("unexpected lines: %s", b)
+	}
+	var rec map[string]any
+	if err := json.Unmarshal(lines[0], &rec); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{"msg": "launching", "request_id": "req-1", "subdomain": "foo", "taskdef": "myapp:1"} {
+		if rec[k] != v {
+			t.Errorf("unexpected %s: %v, want %s", k, rec[k], v)
+		}
+	}
+	if n := bytes.Count(lines[0], []byte(`"taskdef"`)); n != 1 {
+		t.Errorf("attributes of the same key must be replaced: %s", lines[0])
+	}
+	if bytes.Contains(lines[1], []byte("subdomain")) {
+		t.Errorf("logs without the context must not have attributes: %s", lines[1])
+	}
+}
+
+func TestLoggerText(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mirage.log")
+	logger, closer, err := mirageecs.NewLogger("text", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.With("foo", "bar").With("baz", "qux").InfoContext(mirageecs.WithLogAttrs(context.Background(), slog.String("subdomain", "foo")), "hello")
+	closer()
+	b, _ := os.ReadFile(path)
+	line := string(b)
+	for _, s := range []string{"[info]", "[foo:bar]", "[baz:qux]", "[subdomain:foo]", " hello\n"} {
+		if !strings.Contains(line, s) {
+			t.Errorf("%q is not in %q", s, line)
+		}
+	}
+}
+
+func TestLoggerInvalidFormat(t *testing.T) {
+	if _, _, err := mirageecs.NewLogger("xml", "stderr"); err == nil {
+		t.Error("expected error for invalid log format")
+	}
+}
@@ -0,0 +1,147 @@
+package mirageecs
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cwlogs "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	LogsFormatText   = "text"
+	LogsFormatNDJSON = "ndjson"
+)
+
+// LogLine is a line of logs in downloaded files.
+type LogLine struct {
+	Time      time.Time `json:"time"`
+	Container string    `json:"container"`
+	Message   string    `json:"message"`
+}
+
+// DownloadLogs reads all logs of the environment since the time, through all pages of log streams.
+// Lines are passed to fn container by container, without loading all logs into memory.
+func (e *ECS) DownloadLogs(ctx context.Context, subdomain string, since time.Time, fn func(*LogLine) error) error {
+	ctx = withLogAttrs(ctx, slog.String("subdomain", subdomain))
+	infos, err := e.logTasks(ctx, subdomain)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		streams, err := e.logStreams(ctx, info, "")
+		if err != nil {
+			return err
+		}
+		for _, stream := range streams {
+			slog.DebugContext(ctx, f("download log events from group:%s stream:%s start:%s", stream.group, stream.name, since))
+			in := &cwlogs.GetLogEventsInput{
+				LogGroupName:  aws.String(stream.group),
+				LogStreamName: aws.String(stream.name),
+				StartFromHead: aws.Bool(true),
+			}
+			if !since.IsZero() {
+				in.StartTime = aws.Int64(since.Unix() * 1000)
+			}
+			p := cwlogs.NewGetLogEventsPaginator(e.logsSvc, in, func(o *cwlogs.GetLogEventsPaginatorOptions) {
+				// the last page returns the same token
+				o.StopOnDuplicateToken = true
+			})
+			for p.HasMorePages() {
+				out, err := p.NextPage(ctx)
+				if err != nil {
+					return fmt.Errorf("failed to get log events from group %s stream %s: %w", stream.group, stream.name, err)
+				}
+				for _, ev := range out.Events {
+					ev := newLogEvent(stream.container, ev.Timestamp, ev.Message)
+					if err := fn(ev.logLine()); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (ev logEvent) logLine() *LogLine {
+	return &LogLine{
+		Time:      time.UnixMilli(ev.timestamp).UTC(),
+		Container: ev.container,
+		Message:   ev.message,
+	}
+}
+
+// logLineWriter writes lines of logs in the format.
+func logLineWriter(w io.Writer, format string) func(*LogLine) error {
+	if format == LogsFormatNDJSON {
+		enc := json.NewEncoder(w)
+		return func(l *LogLine) error {
+			return enc.Encode(l)
+		}
+	}
+	return func(l *LogLine) error {
+		_, err := fmt.Fprintf(w, "%s [%s] %s\n", l.Time.Format(time.RFC3339Nano), l.Container, l.Message)
+		return err
+	}
+}
+
+// ApiLogsDownload streams all logs of the environment as a gzip compressed file.
+func (api *WebApi) ApiLogsDownload(c echo.Context) error {
+	subdomain := c.QueryParam("subdomain")
+	if subdomain == "" {
+		return c.JSON(http.StatusBadRequest, APICommonResponse{Result: "parameter required: subdomain"})
+	}
+	var since time.Time
+	if s := c.QueryParam("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, APICommonResponse{Result: fmt.Sprintf("cannot parse since: %s", err)})
+		}
+		since = t
+	}
+	format := c.QueryParam("format")
+	ext := "log"
+	switch format {
+	case "", LogsFormatText:
+		format = LogsFormatText
+	case LogsFormatNDJSON:
+		ext = "ndjson"
+	default:
+		return c.JSON(http.StatusBadRequest, APICommonResponse{Result: fmt.Sprintf("invalid format: %s", format)})
+	}
+
+	res := c.Response()
+	gz := gzip.NewWriter(res)
+	write := logLineWriter(gz, format)
+	// the response starts at the first line, so errors before it are responded as JSON
+	started := false
+	err := api.runner.DownloadLogs(c.Request().Context(), subdomain, since, func(l *LogLine) error {
+		if !started {
+			started = true
+			filename := fmt.Sprintf("%s-%s.%s.gz", subdomain, time.Now().Format("20060102-150405"), ext)
+			res.Header().Set(echo.HeaderContentType, "application/gzip")
+			res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+			res.WriteHeader(http.StatusOK)
+		}
+		return write(l)
+	})
+	if err != nil && !started {
+		return c.JSON(http.StatusInternalServerError, APICommonResponse{Result: err.Error()})
+	}
+	if err != nil {
+		// the response is truncated
+		slog.WarnContext(c.Request().Context(), f("failed to download logs of subdomain %s: %s", subdomain, err))
+	}
+	if !started {
+		return c.JSON(http.StatusNotFound, APICommonResponse{Result: fmt.Sprintf("no logs of subdomain %s", subdomain)})
+	}
+	return gz.Close()
+}
@@ -0,0 +1,81 @@
+package mirageecs_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestApiLogsDownload(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+	// a client which does not decompress responses transparently
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	download := func(q string) (*http.Response, []string) {
+		t.Helper()
+		res, err := client.Get(ts.URL + "/api/logs/download?" + q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return res, nil
+		}
+		gz, err := gzip.NewReader(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		s := bufio.NewScanner(gz)
+		for s.Scan() {
+			lines = append(lines, s.Text())
+		}
+		if err := s.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return res, lines
+	}
+
+	res, lines := download("subdomain=foo")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", res.StatusCode)
+	}
+	if cd := res.Header.Get("Content-Disposition"); !strings.HasPrefix(cd, `attachment; filename="foo-`) || !strings.HasSuffix(cd, `.log.gz"`) {
+		t.Errorf("unexpected Content-Disposition: %s", cd)
+	}
+	if len(lines) != 1 || !strings.HasSuffix(lines[0], "[mock] Sorry. mock server logs are empty.") {
+		t.Errorf("unexpected lines: %#v", lines)
+	}
+
+	res, lines = download("subdomain=foo&format=ndjson")
+	if res.StatusCode != http.StatusOK || len(lines) != 1 {
+		t.Fatalf("unexpected response: %d %#v", res.StatusCode, lines)
+	}
+	var l mirageecs.LogLine
+	if err := json.Unmarshal([]byte(lines[0]), &l); err != nil {
+		t.Fatal(err)
+	}
+	if l.Container != "mock" || l.Time.IsZero() {
+		t.Errorf("unexpected line: %#v", l)
+	}
+
+	for _, q := range []string{"", "subdomain=foo&format=csv", "subdomain=foo&since=yesterday"} {
+		if res, _ := download(q); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("status of %q should be 400: %d", q, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cwlogs "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/samber/lo"
+)
+
+// maxFilterLogStreams is the maximum number of log streams of a FilterLogEvents call.
+const maxFilterLogStreams = 100
+
+// LogsFilter selects lines of logs.
+type LogsFilter struct {
+	// Pattern is a filter pattern of CloudWatch Logs. e.g. ERROR, { $.level = "error" }
+	Pattern string
+	// Regexp matches lines filtered by the pattern.
+	Regexp *regexp.Regexp
+	// Container is a name of the container. Empty selects all containers.
+	Container string
+}
+
+func (f *LogsFilter) pattern() string {
+	if f == nil {
+		return ""
+	}
+	return f.Pattern
+}
+
+func (f *LogsFilter) container() string {
+	if f == nil {
+		return ""
+	}
+	return f.Container
+}
+
+// match reports whether the line matches the regexp.
+func (f *LogsFilter) match(line string) bool {
+	if f == nil || f.Regexp == nil {
+		return true
+	}
+	return f.Regexp.MatchString(line)
+}
+
+// tailLogs returns the last n lines. Zero returns all lines.
+func tailLogs(logs []string, n int) []string {
+	if n > 0 && len(logs) >= n {
+		return logs[len(logs)-n:]
+	}
+	return logs
+}
+
+// logEvent is a line of logs of the container.
+type logEvent struct {
+	timestamp int64 // milliseconds
+	container string
+	message   string
+}
+
+func newLogEvent(container string, timestamp *int64, message *string) logEvent {
+	return logEvent{
+		timestamp: aws.ToInt64(timestamp),
+		container: container,
+		// Windows containers write lines terminated by CRLF
+		message: strings.TrimSuffix(aws.ToString(message), "\r"),
+	}
+}
+
+// formatLogEvents returns the last n lines of events in the order of timestamps.
+// Lines are prefixed with names of containers when events are of several containers.
+func formatLogEvents(events []logEvent, n int) []string {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].timestamp < events[j].timestamp
+	})
+	containers := lo.UniqBy(events, func(ev logEvent) string {
+		return ev.container
+	})
+	logs := make([]string, 0, len(events))
+	for _, ev := range events {
+		if len(containers) > 1 {
+			logs = append(logs, fmt.Sprintf("[%s] %s", ev.container, ev.message))
+		} else {
+			logs = append(logs, ev.message)
+		}
+	}
+	return tailLogs(logs, n)
+}
+
+// filterLogs returns events of the log streams which match the filter pattern by FilterLogEvents.
+func (e *ECS) filterLogs(ctx context.Context, streams []logStream, since time.Time, filter *LogsFilter) ([]logEvent, error) {
+	containers := make(map[string]string, len(streams)) // stream name -> container
+	namesByGroup := make(map[string][]string)
+	for _, stream := range streams {
+		containers[stream.name] = stream.container
+		namesByGroup[stream.group] = append(namesByGroup[stream.group], stream.name)
+	}
+	events := []logEvent{}
+	for group, names := range namesByGroup {
+		for len(names) > 0 {
+			n := min(len(names), maxFilterLogStreams)
+			chunk := names[:n]
+			names = names[n:]
+			slog.DebugContext(ctx, f("filter log events from group:%s streams:%v pattern:%s start:%s", group, chunk, filter.Pattern, since))
+			in := &cwlogs.FilterLogEventsInput{
+				LogGroupName:   aws.String(group),
+				LogStreamNames: chunk,
+				FilterPattern:  aws.String(filter.Pattern),
+			}
+			if !since.IsZero() {
+				in.StartTime = aws.Int64(since.Unix() * 1000)
+			}
+			p := cwlogs.NewFilterLogEventsPaginator(e.logsSvc, in)
+			for p.HasMorePages() {
+				out, err := p.NextPage(ctx)
+				if err != nil {
+					return nil, fmt.Errorf("failed to filter log events from group %s: %w", group, err)
+				}
+				for _, ev := range out.Events {
+					container := containers[aws.ToString(ev.LogStreamName)]
+					if ev := newLogEvent(container, ev.Timestamp, ev.Message); filter.match(ev.message) {
+						events = append(events, ev)
+					}
+				}
+			}
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,107 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLogsFilterMatch(t *testing.T) {
+	var nilFilter *mirageecs.LogsFilter
+	if !nilFilter.Match("anything") {
+		t.Error("nil filter should match any lines")
+	}
+	f := &mirageecs.LogsFilter{Pattern: "ERROR", Regexp: regexp.MustCompile(`user=\d+`)}
+	if !f.Match("ERROR failed user=42") {
+		t.Error("should match")
+	}
+	if f.Match("ERROR failed user=anonymous") {
+		t.Error("should not match")
+	}
+}
+
+func TestTailLogs(t *testing.T) {
+	logs := []string{"a", "b", "c"}
+	for n, expected := range map[int][]string{
+		0: {"a", "b", "c"},
+		2: {"b", "c"},
+		5: {"a", "b", "c"},
+	} {
+		if diff := cmp.Diff(expected, mirageecs.TailLogs(logs, n)); diff != "" {
+			t.Errorf("unexpected tail %d (-want +got):\n%s", n, diff)
+		}
+	}
+}
+
+func TestFormatLogEvents(t *testing.T) {
+	app := []mirageecs.LogEvent{
+		mirageecs.NewLogEvent("app", 1000, "app started"),
+		mirageecs.NewLogEvent("app", 3000, "GET / 200\r"),
+	}
+	nginx := []mirageecs.LogEvent{
+		mirageecs.NewLogEvent("nginx", 2000, "nginx started"),
+		mirageecs.NewLogEvent("nginx", 4000, "127.0.0.1 GET /"),
+	}
+	if diff := cmp.Diff([]string{"app started", "GET / 200"}, mirageecs.FormatLogEvents(app, 0)); diff != "" {
+		t.Errorf("lines of a container should not be labeled (-want +got):\n%s", diff)
+	}
+	expected := []string{
+		"[app] app started",
+		"[nginx] nginx started",
+		"[app] GET / 200",
+		"[nginx] 127.0.0.1 GET /",
+	}
+	if diff := cmp.Diff(expected, mirageecs.FormatLogEvents(append(nginx, app...), 0)); diff != "" {
+		t.Errorf("unexpected lines (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(expected[2:], mirageecs.FormatLogEvents(append(nginx, app...), 2)); diff != "" {
+		t.Errorf("unexpected tail (-want +got):\n%s", diff)
+	}
+}
+
+func TestApiLogsFilter(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	for re, expected := range map[string]int{
+		"mock":  1,
+		"^mock": 0,
+	} {
+		q := url.Values{"subdomain": {"foo"}, "container": {"app"}, "filter": {"Sorry"}, "regexp": {re}}
+		res, err := ts.Client().Get(ts.URL + "/api/logs?" + q.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r mirageecs.APILogsResponse
+		err = json.NewDecoder(res.Body).Decode(&r)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK || len(r.Result) != expected {
+			t.Errorf("unexpected logs of regexp %s: %d %#v", re, res.StatusCode, r.Result)
+		}
+	}
+
+	res, err := ts.Client().Get(ts.URL + "/api/logs?subdomain=foo&regexp=" + url.QueryEscape("(unclosed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid regexp should be a bad request: %d", res.StatusCode)
+	}
+}
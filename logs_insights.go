@@ -0,0 +1,196 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cwlogs "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwlogsTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+)
+
+const (
+	DefaultLogsInsightsLimit   = 1000
+	DefaultLogsInsightsTimeout = 30 * time.Second
+	DefaultLogsInsightsPeriod  = time.Hour
+
+	// maxLogsInsightsLimit is the maximum number of results of a Logs Insights query.
+	maxLogsInsightsLimit = 10000
+	// logsInsightsPollInterval is an interval to poll results of queries.
+	logsInsightsPollInterval = time.Second
+)
+
+// LogsInsights allows CloudWatch Logs Insights queries to logs of environments by /api/logs/query.
+// Only queries of the templates can run, so clients cannot run expensive queries.
+type LogsInsights struct {
+	// Templates are queries by names. e.g. errors: "fields @timestamp, @message | filter @message like /ERROR/"
+	Templates map[string]string `yaml:"templates"`
+	// Limit is the maximum number of results. Default is 1000.
+	Limit int32 `yaml:"limit"`
+	// Timeout is a time to wait for queries to complete. Default is 30s.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (l *LogsInsights) validate() error {
+	if l == nil {
+		return nil
+	}
+	if len(l.Templates) == 0 {
+		return fmt.Errorf("ecs.logs_insights.templates is required")
+	}
+	for name, query := range l.Templates {
+		if name == "" || strings.TrimSpace(query) == "" {
+			return fmt.Errorf("ecs.logs_insights.templates must have names and queries: %q", name)
+		}
+	}
+	if l.Limit == 0 {
+		l.Limit = DefaultLogsInsightsLimit
+	}
+	if l.Limit < 0 || l.Limit > maxLogsInsightsLimit {
+		return fmt.Errorf("ecs.logs_insights.limit must be between 1 and %d: %d", maxLogsInsightsLimit, l.Limit)
+	}
+	if l.Timeout == 0 {
+		l.Timeout = DefaultLogsInsightsTimeout
+	}
+	return nil
+}
+
+// scopedLogsQuery returns the query which is scoped to the log streams.
+func scopedLogsQuery(streams []string, query string) string {
+	quoted := lo.Map(streams, func(s string, _ int) string {
+		return strconv.Quote(s)
+	})
+	return fmt.Sprintf("filter @logStream in [%s]\n| %s", strings.Join(quoted, ", "), query)
+}
+
+// logsQueryResults converts results of the query to maps of fields. The internal field @ptr is omitted.
+func logsQueryResults(rows [][]cwlogsTypes.ResultField) []map[string]string {
+	results := make([]map[string]string, 0, len(rows))
+	for _, row := range rows {
+		r := make(map[string]string, len(row))
+		for _, field := range row {
+			name := aws.ToString(field.Field)
+			if name == "@ptr" {
+				continue
+			}
+			r[name] = aws.ToString(field.Value)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// QueryLogs runs the Logs Insights query to log streams of the environment, and waits for the results.
+func (e *ECS) QueryLogs(ctx context.Context, subdomain string, query string, since, until time.Time, limit int32) ([]map[string]string, error) {
+	ctx = withLogAttrs(ctx, slog.String("subdomain", subdomain))
+	infos, err := e.logTasks(ctx, subdomain)
+	if err != nil {
+		return nil, err
+	}
+	streamsByGroup := make(map[string][]string)
+	for _, info := range infos {
+		streams, err := e.logStreams(ctx, info, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, stream := range streams {
+			streamsByGroup[stream.group] = append(streamsByGroup[stream.group], stream.name)
+		}
+	}
+	if len(streamsByGroup) == 0 {
+		return nil, fmt.Errorf("no log streams of subdomain %s", subdomain)
+	}
+	groups := lo.Keys(streamsByGroup)
+	sort.Strings(groups)
+	streams := lo.Flatten(lo.Values(streamsByGroup))
+	sort.Strings(streams)
+
+	out, err := e.logsSvc.StartQuery(ctx, &cwlogs.StartQueryInput{
+		LogGroupNames: groups,
+		QueryString:   aws.String(scopedLogsQuery(streams, query)),
+		StartTime:     aws.Int64(since.Unix()),
+		EndTime:       aws.Int64(until.Unix()),
+		Limit:         aws.Int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start query: %w", err)
+	}
+	queryID := out.QueryId
+	slog.DebugContext(ctx, f("started query %s to log groups %v", aws.ToString(queryID), groups))
+
+	ticker := time.NewTicker(logsInsightsPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// the query keeps running (and costs) unless it is stopped
+			if _, err := e.logsSvc.StopQuery(context.WithoutCancel(ctx), &cwlogs.StopQueryInput{QueryId: queryID}); err != nil {
+				slog.WarnContext(ctx, f("failed to stop query %s: %s", aws.ToString(queryID), err))
+			}
+			return nil, fmt.Errorf("query %s is not completed: %w", aws.ToString(queryID), ctx.Err())
+		case <-ticker.C:
+		}
+		res, err := e.logsSvc.GetQueryResults(ctx, &cwlogs.GetQueryResultsInput{QueryId: queryID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get query results: %w", err)
+		}
+		switch res.Status {
+		case cwlogsTypes.QueryStatusComplete:
+			return logsQueryResults(res.Results), nil
+		case cwlogsTypes.QueryStatusFailed, cwlogsTypes.QueryStatusCancelled, cwlogsTypes.QueryStatusTimeout:
+			return nil, fmt.Errorf("query %s is %s", aws.ToString(queryID), res.Status)
+		}
+	}
+}
+
+// ApiLogsQuery runs the query of the template to logs of the environment.
+func (api *WebApi) ApiLogsQuery(c echo.Context) error {
+	l := api.cfg.ECS.LogsInsights
+	if l == nil {
+		return c.JSON(http.StatusNotFound, APICommonResponse{Result: "ecs.logs_insights is not configured"})
+	}
+	subdomain := c.QueryParam("subdomain")
+	if subdomain == "" {
+		return c.JSON(http.StatusBadRequest, APICommonResponse{Result: "parameter required: subdomain"})
+	}
+	name := c.QueryParam("template")
+	query, ok := l.Templates[name]
+	if !ok {
+		return c.JSON(http.StatusBadRequest, APICommonResponse{Result: fmt.Sprintf("template %q is not allowed", name)})
+	}
+	until := time.Now()
+	if s := c.QueryParam("until"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, APICommonResponse{Result: fmt.Sprintf("cannot parse until: %s", err)})
+		}
+		until = t
+	}
+	since := until.Add(-DefaultLogsInsightsPeriod)
+	if s := c.QueryParam("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, APICommonResponse{Result: fmt.Sprintf("cannot parse since: %s", err)})
+		}
+		since = t
+	}
+	if !since.Before(until) {
+		return c.JSON(http.StatusBadRequest, APICommonResponse{Result: "since must be before until"})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), l.Timeout)
+	defer cancel()
+	results, err := api.runner.QueryLogs(ctx, subdomain, query, since, until, l.Limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(http.StatusOK, APILogsQueryResponse{Result: results})
+}
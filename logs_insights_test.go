@@ -0,0 +1,112 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cwlogsTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLogsInsightsValidate(t *testing.T) {
+	for _, l := range []*mirageecs.LogsInsights{
+		{},
+		{Templates: map[string]string{"errors": " "}},
+		{Templates: map[string]string{"errors": "fields @message"}, Limit: 10001},
+	} {
+		if err := l.Validate(); err == nil {
+			t.Errorf("%#v should be invalid", l)
+		}
+	}
+	l := &mirageecs.LogsInsights{Templates: map[string]string{"errors": "fields @message"}}
+	if err := l.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if l.Limit != mirageecs.DefaultLogsInsightsLimit || l.Timeout != mirageecs.DefaultLogsInsightsTimeout {
+		t.Errorf("unexpected defaults: %#v", l)
+	}
+}
+
+func TestScopedLogsQuery(t *testing.T) {
+	q := mirageecs.ScopedLogsQuery(
+		[]string{"app/nginx/0123456789abcdef", "app/web/0123456789abcdef"},
+		"fields @timestamp, @message | filter @message like /ERROR/",
+	)
+	expected := `filter @logStream in ["app/nginx/0123456789abcdef", "app/web/0123456789abcdef"]
+| fields @timestamp, @message | filter @message like /ERROR/`
+	if q != expected {
+		t.Errorf("unexpected query: %s", q)
+	}
+}
+
+func TestLogsQueryResults(t *testing.T) {
+	results := mirageecs.LogsQueryResults([][]cwlogsTypes.ResultField{
+		{
+			{Field: aws.String("@timestamp"), Value: aws.String("2024-01-02 03:04:05.000")},
+			{Field: aws.String("@message"), Value: aws.String("ERROR something wrong")},
+			{Field: aws.String("@ptr"), Value: aws.String("CmAKJwoj...")},
+		},
+	})
+	expected := []map[string]string{
+		{"@timestamp": "2024-01-02 03:04:05.000", "@message": "ERROR something wrong"},
+	}
+	if diff := cmp.Diff(expected, results); diff != "" {
+		t.Errorf("unexpected results (-want +got):\n%s", diff)
+	}
+}
+
+func TestApiLogsQuery(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	get := func(q string) int {
+		t.Helper()
+		res, err := ts.Client().Get(ts.URL + "/api/logs/query?" + q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			var r mirageecs.APILogsQueryResponse
+			if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+				t.Fatal(err)
+			}
+			if len(r.Result) != 1 {
+				t.Errorf("unexpected results: %#v", r)
+			}
+		}
+		return res.StatusCode
+	}
+
+	if code := get("subdomain=foo&template=errors"); code != http.StatusNotFound {
+		t.Errorf("unexpected status without logs_insights: %d", code)
+	}
+	cfg.ECS.LogsInsights = &mirageecs.LogsInsights{
+		Templates: map[string]string{"errors": "fields @timestamp, @message | filter @message like /ERROR/"},
+	}
+	if err := cfg.ECS.LogsInsights.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for q, code := range map[string]int{
+		"subdomain=foo&template=errors":                            http.StatusOK,
+		"subdomain=foo&template=raw":                               http.StatusBadRequest,
+		"template=errors":                                          http.StatusBadRequest,
+		"subdomain=foo&template=errors&since=yesterday":            http.StatusBadRequest,
+		"subdomain=foo&template=errors&since=2030-01-01T00:00:00Z": http.StatusBadRequest,
+	} {
+		if got := get(q); got != code {
+			t.Errorf("status of %s should be %d: %d", q, code, got)
+		}
+	}
+}
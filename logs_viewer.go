@@ -0,0 +1,119 @@
+package mirageecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// LogsFollowInterval is an interval to read new logs for the log viewer.
+	LogsFollowInterval = 5 * time.Second
+	// LogsViewerBacklog is a duration of logs which the log viewer shows at first.
+	LogsViewerBacklog = 10 * time.Minute
+)
+
+// LogsViewer shows the log viewer of the environment.
+func (api *WebApi) LogsViewer(c echo.Context) error {
+	return c.Render(http.StatusOK, "logs.html", map[string]interface{}{
+		"Subdomain": c.Param("subdomain"),
+	})
+}
+
+// LogsStream streams logs of the environment as server-sent events, following new logs.
+// Each event is a LogLine in JSON, and its id is the time of the line to resume by Last-Event-ID.
+func (api *WebApi) LogsStream(c echo.Context) error {
+	ctx := c.Request().Context()
+	subdomain := c.Param("subdomain")
+	follower := &logsFollower{since: time.Now().Add(-LogsViewerBacklog)}
+	if id := c.Request().Header.Get("Last-Event-ID"); id != "" {
+		if t, err := time.Parse(time.RFC3339Nano, id); err == nil {
+			// resumes after the last received line
+			follower.since, follower.after = t, t
+		}
+	}
+	lines, err := follower.next(api, c)
+	if err != nil {
+		// EventSource doesn't reconnect on errors before the stream
+		return c.String(http.StatusNotFound, err.Error())
+	}
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.WriteHeader(http.StatusOK)
+
+	tk := time.NewTicker(LogsFollowInterval)
+	defer tk.Stop()
+	for {
+		for _, l := range lines {
+			b, _ := json.Marshal(l)
+			if _, err := fmt.Fprintf(res, "id: %s\ndata: %s\n\n", l.Time.Format(time.RFC3339Nano), b); err != nil {
+				return nil
+			}
+		}
+		if err != nil {
+			slog.WarnContext(ctx, f("failed to follow logs of subdomain %s: %s", subdomain, err))
+			b, _ := json.Marshal(err.Error())
+			fmt.Fprintf(res, "event: failure\ndata: %s\n\n", b)
+		}
+		res.Flush()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tk.C:
+		}
+		lines, err = follower.next(api, c)
+	}
+}
+
+// logsFollower reads new lines of logs since the last lines.
+type logsFollower struct {
+	since time.Time
+	// after skips lines which have been received before reconnection.
+	after time.Time
+	// seen has lines at or after the second of since, because logs are read by seconds.
+	seen map[LogLine]struct{}
+}
+
+func (f *logsFollower) next(api *WebApi, c echo.Context) ([]*LogLine, error) {
+	from := f.since.Truncate(time.Second)
+	var lines []*LogLine
+	err := api.runner.DownloadLogs(c.Request().Context(), c.Param("subdomain"), from, func(l *LogLine) error {
+		if l.Time.Before(from) || !l.Time.After(f.after) {
+			return nil
+		}
+		if _, ok := f.seen[*l]; !ok {
+			lines = append(lines, l)
+		}
+		return nil
+	})
+	if err != nil && len(lines) == 0 {
+		return nil, err
+	}
+	// lines are read container by container
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Time.Before(lines[j].Time)
+	})
+	if len(lines) > 0 {
+		f.since = lines[len(lines)-1].Time
+	}
+	last := f.since.Truncate(time.Second)
+	seen := make(map[LogLine]struct{})
+	for l := range f.seen {
+		if !l.Time.Before(last) {
+			seen[l] = struct{}{}
+		}
+	}
+	for _, l := range lines {
+		if !l.Time.Before(last) {
+			seen[*l] = struct{}{}
+		}
+	}
+	f.seen = seen
+	return lines, err
+}
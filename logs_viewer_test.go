@@ -0,0 +1,119 @@
+package mirageecs_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+// logsRunner returns the lines as logs of any subdomains except "missing".
+type logsRunner struct {
+	*mirageecs.LocalTaskRunner
+	lines []*mirageecs.LogLine
+}
+
+func (r *logsRunner) DownloadLogs(_ context.Context, subdomain string, since time.Time, fn func(*mirageecs.LogLine) error) error {
+	if subdomain == "missing" {
+		return fmt.Errorf("subdomain %s is not found", subdomain)
+	}
+	for _, l := range r.lines {
+		if !l.Time.Before(since) {
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestLogsStream(t *testing.T) {
+	cfg, err := newConfigFromYAML(t, "htmldir: ./html\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	runner := &logsRunner{
+		LocalTaskRunner: &mirageecs.LocalTaskRunner{},
+		// container by container
+		lines: []*mirageecs.LogLine{
+			{Time: now.Add(-time.Hour), Container: "app", Message: "too old"},
+			{Time: now.Add(-2 * time.Second), Container: "app", Message: "app 1"},
+			{Time: now, Container: "app", Message: "app 2"},
+			{Time: now.Add(-time.Second), Container: "nginx", Message: "\x1b[32mGET / 200\x1b[0m"},
+		},
+	}
+	ts := httptest.NewServer(mirageecs.NewWebApi(cfg, runner))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/logs/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status of the viewer: %d", res.StatusCode)
+	}
+
+	stream := func(lastEventID string) []string {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/logs/foo/stream", nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("unexpected content type: %s", ct)
+		}
+		// events of the first read are flushed at once
+		var messages []string
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				var l mirageecs.LogLine
+				if err := json.Unmarshal([]byte(data), &l); err != nil {
+					t.Fatal(err)
+				}
+				messages = append(messages, l.Message)
+				if l.Message == "app 2" {
+					break
+				}
+			}
+		}
+		return messages
+	}
+
+	// lines are sorted by time
+	expected := []string{"app 1", "\x1b[32mGET / 200\x1b[0m", "app 2"}
+	if got := stream(""); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected lines: %q", got)
+	}
+	// resumes after the last event
+	expected = []string{"app 2"}
+	if got := stream(now.Add(-time.Second).Format(time.RFC3339Nano)); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected lines after reconnection: %q", got)
+	}
+
+	res, err = http.Get(ts.URL + "/logs/missing/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected status of missing logs: %d", res.StatusCode)
+	}
+}
@@ -3,11 +3,11 @@ package mirageecs
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -20,9 +20,36 @@ type Mirage struct {
 	WebApi       *WebApi
 	ReverseProxy *ReverseProxy
 	Route53      *Route53
+	CloudMap     *CloudMapRegistry
+	Records      *RecordManager
+	Private      *RecordManager // records of private environments
+	Certificates *CertificateManager
+	Aliases      *AliasManager
+	Dashboards   *DashboardManager
+	Deployments  *GitHubDeployer
+	Passthrough  *TLSPassthrough
+	ALBRouter    *ALBRouter
 
-	runner         TaskRunner
-	proxyControlCh chan *proxyControl
+	runner          TaskRunner
+	proxyControlCh  chan *proxyControl
+	catchAllHandler http.Handler
+	relaunching     map[string]time.Time // subdomain -> deadline of relaunching
+	relaunched      map[string]struct{}  // task IDs which have been relaunched
+	terminated      map[string]time.Time // subdomain -> time of terminated
+	protectedAt     map[string]time.Time // task ID -> time of task protection updated
+	expired         map[string]struct{}  // subdomains which are being terminated by TTL
+	refreshed       map[string]time.Time // subdomain -> time of the last refresh
+	readyTasks      map[string]bool      // task ID -> whether the task was ready at the last sync
+
+	sleepingMu sync.RWMutex
+	sleeping   map[string]string // subdomain -> reason of sleeping
+
+	onDemandMu sync.Mutex
+	onDemand   map[string]*onDemandState // subdomain -> state of launching on demand
+
+	persister     *persister
+	election      *leaderElection
+	statsExporter *accessStatsExporter
 }
 
 func New(ctx context.Context, cfg *Config) *Mirage {
@@ -35,9 +62,33 @@ func New(ctx context.Context, cfg *Config) *Mirage {
 		ReverseProxy:   NewReverseProxy(cfg),
 		WebApi:         NewWebApi(cfg, runner),
 		Route53:        NewRoute53(ctx, cfg),
+		CloudMap:       NewCloudMapRegistry(ctx, cfg),
+		Records:        NewRecordManager(cfg),
+		Private:        NewPrivateRecordManager(cfg),
+		Certificates:   NewCertificateManager(cfg),
+		Aliases:        NewAliasManager(cfg),
+		Dashboards:     NewDashboardManager(cfg),
+		Deployments:    NewGitHubDeployer(cfg),
+		ALBRouter:      NewALBRouter(cfg),
 		runner:         runner,
 		proxyControlCh: ch,
+		relaunching:    make(map[string]time.Time),
+		relaunched:     make(map[string]struct{}),
+		terminated:     make(map[string]time.Time),
+		protectedAt:    make(map[string]time.Time),
+		expired:        make(map[string]struct{}),
+		refreshed:      make(map[string]time.Time),
+		readyTasks:     make(map[string]bool),
+		onDemand:       make(map[string]*onDemandState),
+		persister:      newPersister(cfg.persistence),
+		election:       newLeaderElection(cfg),
+		statsExporter:  newAccessStatsExporter(cfg),
 	}
+	m.Passthrough = NewTLSPassthrough(cfg, m.ReverseProxy)
+	m.WebApi.accessStats = m.ReverseProxy.AccessStats
+	m.WebApi.routable = m.ReverseProxy.routesTo
+	m.WebApi.election = m.election
+	m.catchAllHandler = m.newCatchAllHandler()
 	return m
 }
 
@@ -46,11 +97,14 @@ func (m *Mirage) Run(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	errors := make(chan error, 10)
+	// restore the state and reconcile routes with running tasks before serving, so routes are available until the first sync
+	m.restoreState(ctx)
+	m.reconcileRoutes(ctx)
 	for _, v := range m.Config.Listen.HTTP {
 		wg.Add(1)
 		go func(port int) {
 			defer wg.Done()
-			laddr := fmt.Sprintf("%s:%d", m.Config.Listen.ForeignAddress, port)
+			laddr := listenAddr(m.Config.Listen.ForeignAddress, port)
 			listener, err := net.Listen("tcp", laddr)
 			if err != nil {
 				slog.Error(f("cannot listen %s: %s", laddr, err))
@@ -74,22 +128,109 @@ func (m *Mirage) Run(ctx context.Context) error {
 		}(v.ListenPort)
 	}
 
+	for _, v := range m.Config.Listen.TLSPassthrough {
+		wg.Add(1)
+		go func(v PortMap) {
+			defer wg.Done()
+			laddr := listenAddr(m.Config.Listen.ForeignAddress, v.ListenPort)
+			listener, err := net.Listen("tcp", laddr)
+			if err != nil {
+				slog.Error(f("cannot listen %s: %s", laddr, err))
+				errors <- err
+				cancel()
+				return
+			}
+			slog.Info(f("listen addr: %s (tls passthrough)", laddr))
+			if err := m.Passthrough.Serve(ctx, listener, v); err != nil {
+				slog.Error(f("tls passthrough %s: %s", laddr, err))
+				errors <- err
+				cancel()
+			}
+			slog.Info(f("shutdown tls passthrough: %s", laddr))
+		}(v)
+	}
+
 	wg.Add(2)
 	go m.syncECSToMirage(ctx, &wg)
 	go m.RunAccessCountCollector(ctx, &wg)
+	if m.election != nil {
+		wg.Add(1)
+		go m.RunLeaderElection(ctx, &wg)
+	}
+	// jobs below run only on the leader with high availability
+	if m.Certificates != nil {
+		wg.Add(1)
+		go m.runAsLeader(ctx, &wg, m.Certificates.Run)
+	}
+	if p := m.Config.Purge; p != nil && p.Interval > 0 {
+		wg.Add(1)
+		go m.runAsLeader(ctx, &wg, m.RunPurger)
+	}
+	if len(m.Config.ECS.IdleStop) > 0 {
+		wg.Add(1)
+		go m.runAsLeader(ctx, &wg, m.RunIdleStopper)
+	}
+	if m.Config.ECS.WarmPool != nil {
+		wg.Add(1)
+		go m.runAsLeader(ctx, &wg, m.RunWarmPool)
+	}
+	if m.Config.ECS.Budget != nil {
+		wg.Add(1)
+		go m.runAsLeader(ctx, &wg, m.RunBudgetKeeper)
+	}
+	if e := m.Config.ECS.TaskEvents; e != nil && e.QueueURL != "" && !m.Config.localMode {
+		wg.Add(1)
+		go m.runAsLeader(ctx, &wg, m.RunTaskEventsReceiver)
+	}
+	if m.Config.datadog != nil {
+		wg.Add(1)
+		go m.runAsLeader(ctx, &wg, m.RunDatadogReporter)
+	}
+	if m.Config.ErrorAlert != nil {
+		wg.Add(1)
+		go m.runAsLeader(ctx, &wg, m.RunErrorAlerter)
+	}
+	if m.statsExporter != nil {
+		wg.Add(1)
+		go m.runAsLeader(ctx, &wg, m.RunAccessStatsExporter)
+	}
+	if m.persister != nil {
+		wg.Add(1)
+		go m.RunPersister(ctx, &wg)
+	}
+	if m.ReverseProxy.accessLogs != nil {
+		wg.Add(1)
+		go m.ReverseProxy.accessLogs.run(ctx, &wg)
+	}
+	if p := m.Config.params; p != nil && p.ReloadInterval > 0 {
+		wg.Add(1)
+		go func() {
+			if m.RunConfigWatcher(ctx, &wg) {
+				errors <- ErrConfigChanged
+				cancel()
+			}
+		}()
+	}
 	wg.Wait()
 	slog.Info("shutdown mirage-ecs")
+	// resources of the config (e.g. files) must be released before restarting with a new config
+	m.Config.Cleanup()
 	select {
 	case err := <-errors:
 		return err
 	default:
 	}
-	m.Config.Cleanup()
 	return nil
 }
 
 func (m *Mirage) ServeHTTPWithPort(w http.ResponseWriter, req *http.Request, port int) {
-	host := strings.ToLower(strings.Split(req.Host, ":")[0])
+	if m.Config.Tracing != nil {
+		tw, treq, span := startRequestSpan(w, req)
+		defer endRequestSpan(tw, span)
+		w, req = tw, treq
+	}
+	req = withRequestID(w, req)
+	host := strings.ToLower(hostOnly(req.Host))
 
 	switch {
 	case m.isWebApiHost(host):
@@ -98,10 +239,16 @@ func (m *Mirage) ServeHTTPWithPort(w http.ResponseWriter, req *http.Request, por
 	case m.isTaskHost(host):
 		m.ReverseProxy.ServeHTTPWithPort(w, req, port)
 
+	case m.isAliasHost(host):
+		subdomain, _ := m.ReverseProxy.AliasOf(host)
+		m.ReverseProxy.ServeAlias(w, req, subdomain, port)
+
 	case strings.HasSuffix(host, m.Config.Host.ReverseProxySuffix):
-		msg := fmt.Sprintf("%s is not found", host)
-		slog.Warn(msg)
-		http.Error(w, msg, http.StatusNotFound)
+		m.serveUnknownSubdomain(w, req, host, port)
+
+	case req.URL.Path == "/api/health":
+		// for health checks of load balancers by IP addresses
+		m.WebApi.ServeHTTP(w, req)
 
 	default:
 		// not a vhost, returns 200 (for healthcheck)
@@ -119,10 +266,28 @@ func (m *Mirage) isTaskHost(host string) bool {
 	return false
 }
 
+func (m *Mirage) isAliasHost(host string) bool {
+	_, ok := m.ReverseProxy.AliasOf(host)
+	return ok
+}
+
 func (m *Mirage) isWebApiHost(host string) bool {
 	return isSameHost(m.Config.Host.WebApi, host)
 }
 
+// listenAddr returns the address to listen on. An empty or "::" address listens on a dual-stack socket.
+func listenAddr(foreign string, port int) string {
+	return net.JoinHostPort(strings.Trim(foreign, "[]"), strconv.Itoa(port))
+}
+
+// hostOnly strips the port from the Host header. IPv6 literals are returned without brackets.
+func hostOnly(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return strings.Trim(hostport, "[]")
+}
+
 func isSameHost(s1 string, s2 string) bool {
 	lower1 := strings.Trim(strings.ToLower(s1), " ")
 	lower2 := strings.Trim(strings.ToLower(s2), " ")
@@ -137,6 +302,10 @@ func (m *Mirage) RunAccessCountCollector(ctx context.Context, wg *sync.WaitGroup
 		select {
 		case <-tk.C:
 		case <-ctx.Done():
+			// put counts which are not collected yet, not to lose them on restarts
+			sctx, cancel := context.WithTimeout(context.Background(), APICallTimeout)
+			m.runner.PutAccessCounts(sctx, m.ReverseProxy.CollectAccessCounts())
+			cancel()
 			slog.Warn("RunAccessCountCollector() is done")
 			return
 		}
@@ -144,6 +313,9 @@ func (m *Mirage) RunAccessCountCollector(ctx context.Context, wg *sync.WaitGroup
 		s, _ := json.Marshal(all)
 		slog.Info(f("access counters: %s", string(s)))
 		m.runner.PutAccessCounts(ctx, all)
+		if m.Config.redis != nil {
+			m.shareAccess(ctx)
+		}
 	}
 }
 
@@ -158,7 +330,8 @@ func (app *Mirage) syncECSToMirage(ctx context.Context, wg *sync.WaitGroup) {
 	slog.Debug("starting up syncECSToMirage()")
 	rp := app.ReverseProxy
 	r53 := app.Route53
-	ticker := time.NewTicker(time.Second * 10)
+	cm := app.CloudMap
+	ticker := time.NewTicker(app.syncInterval())
 	defer ticker.Stop()
 
 SYNC:
@@ -167,12 +340,22 @@ SYNC:
 		case msg := <-app.proxyControlCh:
 			slog.Debug(f("proxyControl %#v", msg))
 			rp.Modify(msg)
+			if msg.Action == proxyRemove {
+				// don't relaunch tasks of the terminated subdomain
+				app.terminated[msg.Subdomain] = time.Now()
+				delete(app.relaunching, msg.Subdomain)
+			}
 			continue SYNC
 		case <-ticker.C:
+		case <-app.WebApi.taskEvents.C():
+			slog.Debug("syncing by task state change events")
 		case <-ctx.Done():
 			slog.Debug("syncECSToMirage() is done")
 			return
 		}
+		ticker.Reset(app.syncInterval())
+		// followers only sync the route table. changes of tasks and records are made by the leader
+		leader := app.election.isLeader()
 
 		running, err := app.runner.List(ctx, statusRunning)
 		if err != nil {
@@ -183,17 +366,56 @@ SYNC:
 			return running[i].Created.Before(running[j].Created)
 		})
 		available := make(map[string]bool)
+		private := make(map[string]bool)
+		albTargets := make(map[string][]string)
+		passthroughTargets := make(map[string]*passthroughTarget)
 		for _, info := range running {
+			if info.Option.private() {
+				private[info.SubDomain] = true
+			}
 			slog.Debug(f("ruuning task %s", info.ID))
+			if !info.Ready {
+				slog.Info(f("task %s of subdomain %s is not ready yet (health status: %s)", info.ShortID, info.SubDomain, info.HealthStatus))
+				continue
+			}
 			if info.IPAddress != "" {
 				available[info.SubDomain] = true
-				for name, port := range info.PortMap {
-					rp.AddSubdomain(info.SubDomain, info.IPAddress, port)
-					r53.Add(name+"."+info.SubDomain, info.IPAddress)
+				app.addRoutes(info)
+				if leader {
+					for name := range info.PortMap {
+						r53.Add(name+"."+info.SubDomain, info.IPAddress)
+					}
+					cm.Register(ctx, info)
+				}
+				t, ok := passthroughTargets[info.SubDomain]
+				if !ok {
+					t = &passthroughTarget{private: info.Option.private()}
+					passthroughTargets[info.SubDomain] = t
+				}
+				t.addrs = append(t.addrs, info.IPAddress)
+				if !info.Option.private() {
+					// private environments are routed by mirage-ecs to restrict accesses
+					albTargets[info.SubDomain] = append(albTargets[info.SubDomain], info.IPAddress)
 				}
 			}
 		}
 
+		app.traceReadyTasks(running)
+		if leader {
+			app.refreshTaskProtection(ctx, running)
+		}
+
+		sleeping, err := app.runner.ListSleeping(ctx)
+		if err != nil {
+			slog.Warn(err.Error())
+			continue
+		}
+		if leader {
+			app.terminateExpired(ctx, append(running, sleeping...))
+			app.applySchedules(ctx, running, sleeping)
+			app.applyRefreshes(ctx, running)
+		}
+
 		stopped, err := app.runner.List(ctx, statusStopped)
 		if err != nil {
 			slog.Warn(err.Error())
@@ -201,18 +423,226 @@ SYNC:
 		}
 		for _, info := range stopped {
 			slog.Debug(f("stopped task %s", info.ID))
+			if info.Option.private() {
+				private[info.SubDomain] = true
+			}
+			if !leader {
+				continue
+			}
 			for name := range info.PortMap {
 				r53.Delete(name+"."+info.SubDomain, info.IPAddress)
 			}
+			cm.Deregister(ctx, info)
 		}
+		if leader {
+			app.relaunchStoppedTasks(ctx, stopped, running)
+		}
+		for subdomain, deadline := range app.relaunching {
+			if available[subdomain] || time.Now().After(deadline) {
+				delete(app.relaunching, subdomain)
+				continue
+			}
+			// keep the route while relaunching
+			available[subdomain] = true
+		}
+
+		app.syncOnDemand(available)
 
+		sleepingReasons := sleepingSubdomains(sleeping)
+		app.setSleeping(sleepingReasons)
+		for _, info := range sleeping {
+			if available[info.SubDomain] {
+				continue
+			}
+			// keep records of sleeping environments, so the URL doesn't change
+			if info.Option.private() {
+				app.Private.Add(info.SubDomain)
+			} else {
+				app.Records.Add(info.SubDomain)
+			}
+		}
 		for _, subdomain := range rp.Subdomains() {
 			if !available[subdomain] {
 				rp.RemoveSubdomain(subdomain)
 			}
 		}
+		rp.pruneAccessCounters(available)
+		app.persister.setRoutes(app.Config.Network, running)
+		app.WebApi.health.synced(len(rp.Subdomains()))
+		for _, subdomain := range append(app.Records.Subdomains(), app.Private.Subdomains()...) {
+			if _, ok := sleepingReasons[subdomain]; !ok && !available[subdomain] {
+				app.Records.Delete(subdomain)
+				app.Private.Delete(subdomain)
+			}
+		}
+		for subdomain := range available {
+			if _, ok := passthroughTargets[subdomain]; !ok {
+				passthroughTargets[subdomain] = nil // keep targets while relaunching
+			}
+			if private[subdomain] {
+				app.Private.Add(subdomain)
+				continue
+			}
+			app.Records.Add(subdomain)
+			if _, ok := albTargets[subdomain]; !ok {
+				albTargets[subdomain] = nil // keep targets while relaunching
+			}
+		}
+		app.Passthrough.Set(passthroughTargets)
+		if !leader {
+			continue
+		}
+		app.Aliases.Sync(ctx, rp.Aliases())
+		keep := make(map[string]bool, len(available)+len(sleepingReasons))
+		for subdomain := range available {
+			keep[subdomain] = true
+		}
+		for subdomain := range sleepingReasons {
+			keep[subdomain] = true
+		}
+		app.Dashboards.Sync(ctx, running, keep)
+		app.Deployments.Sync(ctx, running, keep)
+		if err := app.ALBRouter.Sync(ctx, albTargets); err != nil {
+			slog.Warn(err.Error())
+		}
 		if err := r53.Apply(ctx); err != nil {
 			slog.Warn(err.Error())
 		}
+		if err := app.Records.Apply(ctx); err != nil {
+			slog.Warn(err.Error())
+		}
+		if err := app.Private.Apply(ctx); err != nil {
+			slog.Warn(err.Error())
+		}
+	}
+}
+
+const relaunchTimeout = 5 * time.Minute
+
+// relaunchStoppedTasks relaunches tasks stopped by Spot interruption or failures.
+func (app *Mirage) relaunchStoppedTasks(ctx context.Context, stopped []*Information, running []*Information) {
+	cfg := app.Config.ECS
+	if !cfg.RelaunchInterruptedTasks && cfg.RelaunchOnFailure == nil {
+		return
+	}
+	runningKeys := make(map[string]bool, len(running))
+	relaunchedFrom := make(map[string]struct{}) // stopped task IDs which are recorded as relaunched in tags
+	for _, info := range running {
+		runningKeys[info.relaunchKey()] = true
+		if id := getTag(info.Tags, TagRelaunchedFrom); id != "" {
+			relaunchedFrom[id] = struct{}{}
+		}
+	}
+	stoppedIDs := make(map[string]struct{}, len(stopped))
+	latest := make(map[string]*Information) // relaunch key -> the last stopped task
+	for _, info := range stopped {
+		stoppedIDs[info.ID] = struct{}{}
+		if id := getTag(info.Tags, TagRelaunchedFrom); id != "" {
+			relaunchedFrom[id] = struct{}{}
+		}
+		if info.task == nil || info.task.StoppedAt == nil {
+			continue
+		}
+		key := info.relaunchKey()
+		if l, ok := latest[key]; !ok || l.task.StoppedAt.Before(*info.task.StoppedAt) {
+			latest[key] = info
+		}
+	}
+	for id := range app.relaunched {
+		if _, ok := stoppedIDs[id]; !ok {
+			delete(app.relaunched, id)
+		}
+	}
+	for subdomain, t := range app.terminated {
+		if time.Since(t) > relaunchTimeout+cfg.RelaunchOnFailure.maxInterval() {
+			delete(app.terminated, subdomain)
+		}
+	}
+	for _, info := range stopped {
+		if info.Service != "" {
+			// the service replaces the stopped task
+			continue
+		}
+		if _, ok := app.relaunched[info.ID]; ok {
+			continue
+		}
+		if _, ok := relaunchedFrom[info.ShortID]; ok {
+			// relaunched before mirage-ecs restarted
+			app.relaunched[info.ID] = struct{}{}
+			continue
+		}
+		key := info.relaunchKey()
+		if runningKeys[key] {
+			// another task is running for the task definition of the subdomain
+			app.relaunched[info.ID] = struct{}{}
+			continue
+		}
+		switch {
+		case cfg.RelaunchInterruptedTasks && info.StopCode == stopCodeSpotInterruption:
+			app.relaunched[info.ID] = struct{}{}
+			slog.Info(f("task %s of subdomain %s was interrupted by Spot. relaunching", info.ShortID, info.SubDomain))
+			if app.relaunch(ctx, info, cfg.Windows.timeoutFor(info, relaunchTimeout)) {
+				runningKeys[key] = true
+			}
+		case cfg.RelaunchOnFailure != nil:
+			if latest[key] != info {
+				// only the last task of the task definition has the latest relaunch history
+				continue
+			}
+			if app.relaunchFailedTask(ctx, info) {
+				runningKeys[key] = true
+			}
+		}
+	}
+}
+
+// relaunchFailedTask relaunches the task which stopped unexpectedly with exponential backoff.
+// It returns true when the task is relaunched.
+func (app *Mirage) relaunchFailedTask(ctx context.Context, info *Information) bool {
+	r := app.Config.ECS.RelaunchOnFailure
+	reason := failureReason(info.task)
+	if reason == "" || info.task.StoppedAt == nil {
+		return false
+	}
+	retries := info.RelaunchCount
+	if retries >= r.MaxRetries {
+		slog.Warn(f("task %s of subdomain %s failed (%s). gave up relaunching after %d retries", info.ShortID, info.SubDomain, reason, retries))
+		app.relaunched[info.ID] = struct{}{}
+		return false
+	}
+	stoppedAt := *info.task.StoppedAt
+	if t, ok := app.terminated[info.SubDomain]; ok && !stoppedAt.After(t) {
+		app.relaunched[info.ID] = struct{}{}
+		return false
+	}
+	backoff := r.backoff(retries)
+	timeout := app.Config.ECS.Windows.timeoutFor(info, relaunchTimeout)
+	if elapsed := time.Since(stoppedAt); elapsed > backoff+timeout {
+		// too old. it may be stopped before mirage-ecs started
+		app.relaunched[info.ID] = struct{}{}
+		return false
+	} else if elapsed < backoff {
+		slog.Debug(f("task %s of subdomain %s failed (%s). relaunching after %s", info.ShortID, info.SubDomain, reason, backoff-elapsed))
+		// keep the route while waiting
+		app.relaunching[info.SubDomain] = stoppedAt.Add(backoff + timeout)
+		return false
+	}
+	app.relaunched[info.ID] = struct{}{}
+	slog.Info(f("task %s of subdomain %s failed (%s). relaunching (retry %d/%d)", info.ShortID, info.SubDomain, reason, retries+1, r.MaxRetries))
+	next := *info
+	next.Relaunches = append(info.Relaunches[:len(info.Relaunches):len(info.Relaunches)], RelaunchRecord{Time: stoppedAt, Reason: reason})
+	next.RelaunchCount = retries + 1
+	next.Tags = withRelaunchHistory(info.Tags, next.Relaunches, next.RelaunchCount)
+	return app.relaunch(ctx, &next, timeout)
+}
+
+func (app *Mirage) relaunch(ctx context.Context, info *Information, timeout time.Duration) bool {
+	next := *info
+	next.Tags = withRelaunchedFrom(info.Tags, info.ShortID)
+	if err := app.runner.Relaunch(ctx, &next); err != nil {
+		slog.Warn(f("failed to relaunch subdomain %s: %s", info.SubDomain, err))
+		return false
 	}
+	app.relaunching[info.SubDomain] = time.Now().Add(timeout)
+	return true
 }
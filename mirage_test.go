@@ -0,0 +1,36 @@
+package mirageecs_test
+
+import (
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestHostOnly(t *testing.T) {
+	cases := map[string]string{
+		"pr1.dev.example.net":      "pr1.dev.example.net",
+		"pr1.dev.example.net:8080": "pr1.dev.example.net",
+		"[2001:db8::1]:8080":       "2001:db8::1",
+		"[2001:db8::1]":            "2001:db8::1",
+	}
+	for in, want := range cases {
+		if got := mirageecs.HostOnly(in); got != want {
+			t.Errorf("HostOnly(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	cases := map[string]string{
+		"":          ":80",
+		"0.0.0.0":   "0.0.0.0:80",
+		"::":        "[::]:80",
+		"[::]":      "[::]:80",
+		"127.0.0.1": "127.0.0.1:80",
+	}
+	for in, want := range cases {
+		if got := mirageecs.ListenAddr(in, 80); got != want {
+			t.Errorf("ListenAddr(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	DefaultOnDemandTimeout = 10 * time.Minute
+
+	// onDemandRetryInterval is an interval to retry a failed launch on demand.
+	onDemandRetryInterval = 30 * time.Second
+	// onDemandRefreshInterval is an interval to reload the waiting page.
+	onDemandRefreshInterval = 5
+)
+
+// OnDemand launches environments on the first request for them, and serves a waiting page until they are ready.
+type OnDemand struct {
+	// Wake resumes sleeping environments on the first request.
+	Wake bool `yaml:"wake"`
+	// Launches are templates to launch environments for subdomains which are not running.
+	Launches []*OnDemandLaunch `yaml:"launches"`
+	// Timeout is a time to wait for the environment to be ready. Default is 10m.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// OnDemandLaunch is a template to launch an environment on demand.
+type OnDemandLaunch struct {
+	// Subdomain is a regexp which matches the whole of subdomains. e.g. ^pr-(\d+)$
+	Subdomain string `yaml:"subdomain"`
+	// Taskdef are task definitions to launch. Empty uses ecs.task_definition_template.
+	Taskdef []string `yaml:"taskdef"`
+	// Parameters are parameters of the environment. Values can refer submatches of the subdomain by $1.
+	Parameters map[string]string `yaml:"parameters"`
+	// TTL is a TTL of the environment. e.g. "24h"
+	TTL string `yaml:"ttl"`
+
+	subdomain *regexp.Regexp
+	ttl       time.Duration
+}
+
+func (o *OnDemand) validate(taskDefinitionTemplate string) error {
+	if o == nil {
+		return nil
+	}
+	if o.Timeout == 0 {
+		o.Timeout = DefaultOnDemandTimeout
+	}
+	for _, l := range o.Launches {
+		if l.Subdomain == "" {
+			return fmt.Errorf("on_demand.launches[].subdomain is required")
+		}
+		re, err := regexp.Compile(l.Subdomain)
+		if err != nil {
+			return fmt.Errorf("invalid on_demand.launches[].subdomain: %s: %w", l.Subdomain, err)
+		}
+		l.subdomain = re
+		if len(l.Taskdef) == 0 && taskDefinitionTemplate == "" {
+			return fmt.Errorf("on_demand.launches[].taskdef is required for %s without ecs.task_definition_template", l.Subdomain)
+		}
+		if l.TTL != "" {
+			if l.ttl, err = parseTTL("on_demand.launches[].ttl", l.TTL); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// launchFor returns the first template matched with the whole of the subdomain, and parameters expanded by submatches.
+func (o *OnDemand) launchFor(subdomain string) (*OnDemandLaunch, map[string]string, bool) {
+	for _, l := range o.Launches {
+		m := l.subdomain.FindStringSubmatchIndex(subdomain)
+		if m == nil || m[0] != 0 || m[1] != len(subdomain) {
+			continue
+		}
+		params := make(map[string]string, len(l.Parameters))
+		for name, v := range l.Parameters {
+			params[name] = string(l.subdomain.ExpandString(nil, v, subdomain, m))
+		}
+		return l, params, true
+	}
+	return nil, nil, false
+}
+
+func (l *OnDemandLaunch) launchOption() *LaunchOption {
+	opt := &LaunchOption{}
+	if l.ttl > 0 {
+		t := time.Now().Add(l.ttl).Truncate(time.Second)
+		opt.ExpiresAt = &t
+	}
+	return opt
+}
+
+// onDemandState is a state of the environment launched on demand.
+type onDemandState struct {
+	deadline time.Time
+	err      error
+}
+
+// serveOnDemand launches (or wakes) the environment of the subdomain on demand and serves the waiting page.
+// It returns false when the subdomain is not launched on demand.
+func (m *Mirage) serveOnDemand(w http.ResponseWriter, req *http.Request, host string, port int) bool {
+	o := m.Config.Host.OnDemand
+	if o == nil {
+		return false
+	}
+	subdomain := subdomainOf(host)
+	state, started := m.onDemandState(subdomain)
+	var start func(ctx context.Context) error
+	if !started {
+		if _, ok := m.sleepReason(subdomain); ok && o.Wake {
+			start = func(ctx context.Context) error {
+				return m.wakeOnDemand(ctx, subdomain)
+			}
+		} else if l, params, ok := o.launchFor(subdomain); ok {
+			start = func(ctx context.Context) error {
+				return m.launchOnDemand(ctx, subdomain, l, params)
+			}
+		} else {
+			return false
+		}
+	}
+	// the waiting page (and errors of launches) is not shown to unauthenticated clients
+	if m.requireAuthCookie(port) {
+		if err := validateAuthCookie(req, m.Config.Auth.ValidateAuthCookie); err != nil {
+			slog.WarnContext(req.Context(), f("%s on demand: %s", host, err))
+			httpError(w, req, "Forbidden", http.StatusForbidden)
+			return true
+		}
+	}
+	if started {
+		m.serveWaiting(w, req, host, state.err)
+		return true
+	}
+	if m.startOnDemand(subdomain, o.Timeout) {
+		slog.Info(f("subdomain %s is requested. launching on demand", subdomain))
+		// the request context is canceled before the launch completes
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), APICallTimeout)
+			defer cancel()
+			if err := start(ctx); err != nil {
+				slog.Warn(f("failed to launch subdomain %s on demand: %s", subdomain, err))
+				m.failOnDemand(subdomain, err)
+			}
+		}()
+	}
+	m.serveWaiting(w, req, host, nil)
+	return true
+}
+
+// launchOnDemand launches the environment by the template, unless it is already running but not ready yet.
+func (m *Mirage) launchOnDemand(ctx context.Context, subdomain string, l *OnDemandLaunch, params map[string]string) error {
+	if err := validateSubdomain(subdomain); err != nil {
+		return err
+	}
+	running, err := m.runner.List(ctx, statusRunning)
+	if err != nil {
+		return err
+	}
+	for _, info := range running {
+		if info.SubDomain == subdomain {
+			return nil
+		}
+	}
+	taskdefs := l.Taskdef
+	if len(taskdefs) == 0 {
+		// an empty taskdef means a task definition rendered from the template
+		taskdefs = []string{""}
+	}
+	param, err := m.WebApi.LoadParameter(func(name string) string {
+		return params[name]
+	}, taskdefs...)
+	if err != nil {
+		return err
+	}
+	tags := param.ToECSTags(subdomain, m.Config.Parameter)
+	tags = append(tags, param.ToPropagatedTags(m.Config.Parameter, m.Config.ECS.ParameterTagPrefix)...)
+	if err := m.Config.ECS.checkQuotas(running, subdomain, len(taskdefs), tags); err != nil {
+		return err
+	}
+	return m.runner.Launch(ctx, subdomain, param, l.launchOption(), taskdefs...)
+}
+
+// wakeOnDemand wakes the sleeping environment unless it exceeds quotas.
+func (m *Mirage) wakeOnDemand(ctx context.Context, subdomain string) error {
+	sleeping, err := m.runner.ListSleeping(ctx)
+	if err != nil {
+		return err
+	}
+	n := 0
+	var tags []types.Tag
+	for _, info := range sleeping {
+		if info.SubDomain == subdomain {
+			n++
+			tags = info.Tags
+		}
+	}
+	if err := checkLaunchQuotas(ctx, m.Config, m.runner, subdomain, n, tags); err != nil {
+		return err
+	}
+	return m.runner.Wake(ctx, subdomain)
+}
+
+func (m *Mirage) onDemandState(subdomain string) (onDemandState, bool) {
+	m.onDemandMu.Lock()
+	defer m.onDemandMu.Unlock()
+	state, ok := m.onDemand[subdomain]
+	if !ok || time.Now().After(state.deadline) {
+		return onDemandState{}, false
+	}
+	return *state, true
+}
+
+// startOnDemand reports whether the caller should launch the subdomain. It returns false while launching.
+func (m *Mirage) startOnDemand(subdomain string, timeout time.Duration) bool {
+	m.onDemandMu.Lock()
+	defer m.onDemandMu.Unlock()
+	if state, ok := m.onDemand[subdomain]; ok && time.Now().Before(state.deadline) {
+		return false
+	}
+	m.onDemand[subdomain] = &onDemandState{deadline: time.Now().Add(timeout)}
+	return true
+}
+
+// failOnDemand records the error of the launch, and retries it after the interval.
+func (m *Mirage) failOnDemand(subdomain string, err error) {
+	m.onDemandMu.Lock()
+	defer m.onDemandMu.Unlock()
+	m.onDemand[subdomain] = &onDemandState{deadline: time.Now().Add(onDemandRetryInterval), err: err}
+}
+
+// syncOnDemand forgets environments launched on demand which are available or timed out.
+func (m *Mirage) syncOnDemand(available map[string]bool) {
+	m.onDemandMu.Lock()
+	defer m.onDemandMu.Unlock()
+	for subdomain, state := range m.onDemand {
+		if available[subdomain] || time.Now().After(state.deadline) {
+			delete(m.onDemand, subdomain)
+		}
+	}
+}
+
+// serveWaiting serves the waiting page which reloads itself until the environment is ready.
+func (m *Mirage) serveWaiting(w http.ResponseWriter, req *http.Request, host string, launchErr error) {
+	w.Header().Set("Retry-After", strconv.Itoa(onDemandRefreshInterval))
+	if launchErr != nil {
+		httpError(w, req, fmt.Sprintf("failed to launch %s: %s", host, launchErr), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	err := m.WebApi.Renderer.Render(w, "waiting.html", map[string]interface{}{
+		"Host":      host,
+		"Refresh":   onDemandRefreshInterval,
+		"RequestID": req.Header.Get(echo.HeaderXRequestID),
+		"Locale":    negotiateLocale(req.Header.Get("Accept-Language"), m.Config.Locale),
+	}, nil)
+	if err != nil {
+		slog.Warn(f("failed to render waiting.html: %s", err))
+		fmt.Fprintf(w, "%s is launching. please reload after a while", host)
+	}
+}
@@ -0,0 +1,182 @@
+package mirageecs_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOnDemandValidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		onDemand *mirageecs.OnDemand
+		template string
+		ok       bool
+	}{
+		{"nil", nil, "", true},
+		{"valid", &mirageecs.OnDemand{Launches: []*mirageecs.OnDemandLaunch{{Subdomain: `pr-(\d+)`, Taskdef: []string{"app"}}}}, "", true},
+		{"template", &mirageecs.OnDemand{Launches: []*mirageecs.OnDemandLaunch{{Subdomain: `pr-(\d+)`}}}, "taskdef.json", true},
+		{"no subdomain", &mirageecs.OnDemand{Launches: []*mirageecs.OnDemandLaunch{{Taskdef: []string{"app"}}}}, "", false},
+		{"invalid subdomain", &mirageecs.OnDemand{Launches: []*mirageecs.OnDemandLaunch{{Subdomain: `pr-(`, Taskdef: []string{"app"}}}}, "", false},
+		{"no taskdef", &mirageecs.OnDemand{Launches: []*mirageecs.OnDemandLaunch{{Subdomain: `pr-(\d+)`}}}, "", false},
+		{"invalid ttl", &mirageecs.OnDemand{Launches: []*mirageecs.OnDemandLaunch{{Subdomain: `pr-(\d+)`, Taskdef: []string{"app"}, TTL: "1d"}}}, "", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.onDemand.Validate(c.template)
+			if c.ok && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if !c.ok && err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestOnDemandLaunchFor(t *testing.T) {
+	o := &mirageecs.OnDemand{
+		Launches: []*mirageecs.OnDemandLaunch{
+			{Subdomain: `pr-(\d+)`, Taskdef: []string{"app"}, Parameters: map[string]string{"branch": "pull/$1"}},
+			{Subdomain: `feature-.+`, Taskdef: []string{"app"}},
+		},
+	}
+	if err := o.Validate(""); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		subdomain string
+		ok        bool
+		taskdef   string
+		params    map[string]string
+	}{
+		{"pr-123", true, "app", map[string]string{"branch": "pull/123"}},
+		{"feature-x", true, "app", map[string]string{}},
+		{"pr-123a", false, "", nil},
+		{"xpr-123", false, "", nil},
+	}
+	for _, c := range cases {
+		t.Run(c.subdomain, func(t *testing.T) {
+			l, params, ok := o.LaunchFor(c.subdomain)
+			if ok != c.ok {
+				t.Fatalf("LaunchFor() ok = %v, want %v", ok, c.ok)
+			}
+			if !ok {
+				return
+			}
+			if l.Taskdef[0] != c.taskdef {
+				t.Errorf("unexpected taskdef %v", l.Taskdef)
+			}
+			if diff := cmp.Diff(c.params, params); diff != "" {
+				t.Errorf("unexpected parameters (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOnDemandLaunch(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Host.OnDemand = &mirageecs.OnDemand{
+		Launches: []*mirageecs.OnDemandLaunch{
+			{Subdomain: `pr-(\d+)`, Taskdef: []string{"app"}, Parameters: map[string]string{"branch": "pull/$1"}},
+		},
+	}
+	if err := cfg.Host.OnDemand.Validate(""); err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+
+	for _, host := range []string{"pr-1.localtest.me", "unknown.localtest.me"} {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		w := httptest.NewRecorder()
+		m.ServeHTTPWithPort(w, req, 80)
+		switch host {
+		case "pr-1.localtest.me":
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("wanted status %d, got %d", http.StatusServiceUnavailable, w.Code)
+			}
+			if body := w.Body.String(); !strings.Contains(body, "is launching") {
+				t.Errorf("unexpected body %q", body)
+			}
+		default:
+			if w.Code != http.StatusNotFound {
+				t.Errorf("wanted status %d, got %d", http.StatusNotFound, w.Code)
+			}
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		infos, err := m.Runner().List(ctx, "RUNNING")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(infos) > 0 {
+			if len(infos) != 1 || infos[0].SubDomain != "pr-1" || infos[0].GitBranch != "pull/1" {
+				t.Errorf("unexpected tasks %#v", infos)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("pr-1 is not launched")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestOnDemandRequiresAuthCookie(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Auth = &mirageecs.Auth{CookieSecret: "dummy"}
+	cookie, err := cfg.Auth.NewAuthCookie(time.Minute, "localtest.me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Listen.HTTP = []mirageecs.PortMap{{ListenPort: 80, TargetPort: 80, RequireAuthCookie: true}}
+	cfg.Host.OnDemand = &mirageecs.OnDemand{
+		Launches: []*mirageecs.OnDemandLaunch{
+			{Subdomain: `pr-(\d+)`, Taskdef: []string{"app"}, Parameters: map[string]string{"branch": "pull/$1"}},
+		},
+	}
+	if err := cfg.Host.OnDemand.Validate(""); err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+
+	serve := func(c *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://pr-1.localtest.me/", nil)
+		if c != nil {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		m.ServeHTTPWithPort(w, req, 80)
+		return w
+	}
+	if w := serve(nil); w.Code != http.StatusForbidden {
+		t.Errorf("wanted status %d, got %d", http.StatusForbidden, w.Code)
+	}
+	if w := serve(cookie); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("wanted status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	// the waiting page of the launching environment is not shown without the cookie
+	if w := serve(nil); w.Code != http.StatusForbidden || strings.Contains(w.Body.String(), "is launching") {
+		t.Errorf("wanted status %d, got %d %q", http.StatusForbidden, w.Code, w.Body.String())
+	}
+}
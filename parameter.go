@@ -0,0 +1,129 @@
+package mirageecs
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ParameterTaskDefinitionTemplate is an entry of task_definitions of parameters, which means task definitions rendered from ecs.task_definition_template.
+// It is not a valid name of task definition families.
+const ParameterTaskDefinitionTemplate = "@template"
+
+const (
+	ParameterTypeString  = "string"
+	ParameterTypeSelect  = "select"
+	ParameterTypeBoolean = "boolean"
+	ParameterTypeInteger = "integer"
+)
+
+func (p *Parameter) validate() error {
+	switch p.Type {
+	case "":
+		p.Type = ParameterTypeString
+	case ParameterTypeString:
+	case ParameterTypeSelect:
+		if len(p.Options) == 0 {
+			return fmt.Errorf("parameter %s: options are required by the select type", p.Name)
+		}
+	case ParameterTypeBoolean, ParameterTypeInteger:
+		if len(p.Options) > 0 {
+			return fmt.Errorf("parameter %s: options are not supported by the %s type", p.Name, p.Type)
+		}
+	default:
+		return fmt.Errorf("parameter %s: invalid type %s", p.Name, p.Type)
+	}
+	if (p.Min != nil || p.Max != nil) && p.Type != ParameterTypeInteger {
+		return fmt.Errorf("parameter %s: min and max are supported only by the integer type", p.Name)
+	}
+	if p.Min != nil && p.Max != nil && *p.Min > *p.Max {
+		return fmt.Errorf("parameter %s: min must not be greater than max: %d %d", p.Name, *p.Min, *p.Max)
+	}
+	for _, family := range p.TaskDefinitions {
+		if family == "" || family != ParameterTaskDefinitionTemplate && strings.ContainsAny(family, ":/") {
+			return fmt.Errorf("parameter %s: task_definitions must be families of task definitions: %q", p.Name, family)
+		}
+	}
+	if p.Rule != "" {
+		re, err := regexp.Compile(p.Rule)
+		if err != nil {
+			return fmt.Errorf("invalid parameter rule: %s: %w", p.Rule, err)
+		}
+		p.Regexp = *re
+	}
+	if p.Default != "" {
+		if _, err := p.normalize(p.Default); err != nil {
+			return fmt.Errorf("parameter %s: invalid default: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// normalize validates the value by the type, and returns the canonical value. Booleans are "true" or "false".
+func (p *Parameter) normalize(v string) (string, error) {
+	switch p.Type {
+	case ParameterTypeSelect:
+		for _, o := range p.Options {
+			if o.Value == v {
+				return v, nil
+			}
+		}
+		return "", fmt.Errorf("parameter %s value %s is not one of options", p.Name, v)
+	case ParameterTypeBoolean:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return "", fmt.Errorf("parameter %s value %s is not a boolean", p.Name, v)
+		}
+		return strconv.FormatBool(b), nil
+	case ParameterTypeInteger:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("parameter %s value %s is not an integer", p.Name, v)
+		}
+		if p.Min != nil && n < *p.Min {
+			return "", fmt.Errorf("parameter %s value %d is less than %d", p.Name, n, *p.Min)
+		}
+		if p.Max != nil && n > *p.Max {
+			return "", fmt.Errorf("parameter %s value %d is greater than %d", p.Name, n, *p.Max)
+		}
+		return strconv.FormatInt(n, 10), nil
+	}
+	return v, nil
+}
+
+// Checked reports whether the checkbox of the boolean parameter is checked by default.
+func (p *Parameter) Checked() bool {
+	b, _ := strconv.ParseBool(p.Default)
+	return b
+}
+
+// taskDefinitionFamily returns the family of the task definition specified by the family, family:revision or the ARN.
+// The empty task definition (rendered from the template) is ParameterTaskDefinitionTemplate.
+func taskDefinitionFamily(taskdef string) string {
+	if taskdef == "" {
+		return ParameterTaskDefinitionTemplate
+	}
+	if i := strings.LastIndex(taskdef, "/"); i >= 0 {
+		taskdef = taskdef[i+1:]
+	}
+	family, _, _ := strings.Cut(taskdef, ":")
+	return family
+}
+
+// usedBy reports whether the parameter is used by any of the task definitions.
+// All parameters are used when task definitions are not specified.
+func (p *Parameter) usedBy(taskdefs []string) bool {
+	if len(p.TaskDefinitions) == 0 || len(taskdefs) == 0 {
+		return true
+	}
+	for _, td := range taskdefs {
+		family := taskDefinitionFamily(td)
+		for _, f := range p.TaskDefinitions {
+			if f == family {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,249 @@
+package mirageecs_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+const typedParametersConfig = `
+parameters:
+  - name: branch
+    env: GIT_BRANCH
+    required: true
+  - name: db
+    env: DB
+    type: select
+    default: mysql
+    options:
+      - value: mysql
+      - label: PostgreSQL
+        value: postgres
+  - name: debug
+    env: DEBUG
+    type: boolean
+    default: "true"
+  - name: replicas
+    env: REPLICAS
+    type: integer
+    min: 1
+    max: 3
+  - name: ticket
+    env: TICKET
+    rule: "^[A-Z]+-[0-9]+$"
+    description: the ticket of the change
+`
+
+func newConfigFromYAML(t *testing.T, src string) (*mirageecs.Config, error) {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(p, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return mirageecs.NewConfig(context.Background(), &mirageecs.ConfigParams{Path: p, LocalMode: true})
+}
+
+func TestLoadTypedParameters(t *testing.T) {
+	cfg, err := newConfigFromYAML(t, typedParametersConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := mirageecs.NewWebApi(cfg, &mirageecs.LocalTaskRunner{})
+	cases := []struct {
+		params map[string]string
+		want   map[string]string
+		ok     bool
+	}{
+		{
+			params: map[string]string{"branch": "main"},
+			want:   map[string]string{"branch": "main", "db": "mysql", "debug": "true"},
+			ok:     true,
+		},
+		{
+			params: map[string]string{"branch": "main", "db": "postgres", "debug": "0", "replicas": "03"},
+			want:   map[string]string{"branch": "main", "db": "postgres", "debug": "false", "replicas": "3"},
+			ok:     true,
+		},
+		{params: map[string]string{"branch": "main", "db": "sqlite"}},
+		{params: map[string]string{"branch": "main", "debug": "yes"}},
+		{params: map[string]string{"branch": "main", "replicas": "1.5"}},
+		{params: map[string]string{"branch": "main", "replicas": "0"}},
+		{params: map[string]string{"branch": "main", "replicas": "4"}},
+	}
+	for _, c := range cases {
+		got, err := app.LoadParameter(func(name string) string { return c.params[name] })
+		if !c.ok {
+			if err == nil {
+				t.Errorf("%v: expected error", c.params)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %s", c.params, err)
+			continue
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("%v: unexpected parameters: %v", c.params, got)
+		}
+		for k, v := range c.want {
+			if got[k] != v {
+				t.Errorf("%v: unexpected %s: %q", c.params, k, got[k])
+			}
+		}
+	}
+}
+
+func TestTypedParametersValidate(t *testing.T) {
+	invalid := []string{
+		"parameters:\n  - name: db\n    type: select\n",
+		"parameters:\n  - name: debug\n    type: boolean\n    default: maybe\n",
+		"parameters:\n  - name: debug\n    type: boolean\n    options:\n      - value: \"true\"\n",
+		"parameters:\n  - name: replicas\n    type: integer\n    min: 3\n    max: 1\n",
+		"parameters:\n  - name: replicas\n    type: integer\n    max: 3\n    default: \"5\"\n",
+		"parameters:\n  - name: replicas\n    max: 3\n",
+		"parameters:\n  - name: replicas\n    type: float\n",
+	}
+	for _, src := range invalid {
+		if _, err := newConfigFromYAML(t, src); err == nil {
+			t.Errorf("expected error: %s", src)
+		}
+	}
+}
+
+func TestLauncherTypedParameters(t *testing.T) {
+	cfg, err := newConfigFromYAML(t, typedParametersConfig+"htmldir: ./html\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(context.Background(), cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/launcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	body := string(b)
+	for _, s := range []string{
+		`<option value="postgres" >PostgreSQL</option>`,
+		`type="checkbox" name="debug" value="true" id="debug" checked`,
+		`<input type="hidden" name="debug" value="false" />`,
+		`type="number" step="1" name="replicas"`,
+		`min="1"`,
+		`max="3"`,
+		`data-label="branch" data-default="" data-required="true"`,
+		`data-label="ticket" data-default=""  data-rule="^[A-Z]&#43;-[0-9]&#43;$"`,
+		`<div class="form-text">the ticket of the change</div>`,
+		`<div class="invalid-feedback"></div>`,
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("launcher must contain %s: %s", s, body)
+		}
+	}
+}
+
+func TestTaskDefinitionFamily(t *testing.T) {
+	cases := map[string]string{
+		"api":   "api",
+		"api:3": "api",
+		"arn:aws:ecs:ap-northeast-1:123456789012:task-definition/api:3": "api",
+		"": mirageecs.ParameterTaskDefinitionTemplate,
+	}
+	for taskdef, family := range cases {
+		if got := mirageecs.TaskDefinitionFamily(taskdef); got != family {
+			t.Errorf("%s: unexpected family %s", taskdef, got)
+		}
+	}
+}
+
+const perTaskDefinitionParametersConfig = `
+htmldir: ./html
+parameters:
+  - name: branch
+    env: GIT_BRANCH
+    required: true
+    task_definitions: [api, "@template"]
+  - name: db_snapshot
+    env: DB_SNAPSHOT
+    required: true
+    task_definitions: [api]
+  - name: nick
+    env: NICK
+`
+
+func TestLoadParameterForTaskDefinitions(t *testing.T) {
+	cfg, err := newConfigFromYAML(t, perTaskDefinitionParametersConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := mirageecs.NewWebApi(cfg, &mirageecs.LocalTaskRunner{})
+	params := map[string]string{"branch": "main", "db_snapshot": "snap-1", "nick": "mirage"}
+	get := func(name string) string { return params[name] }
+
+	cases := []struct {
+		taskdefs []string
+		want     []string
+	}{
+		{[]string{"arn:aws:ecs:ap-northeast-1:123456789012:task-definition/api:3"}, []string{"branch", "db_snapshot", "nick"}},
+		{[]string{"docs"}, []string{"nick"}},
+		{[]string{""}, []string{"branch", "nick"}},
+		{[]string{"docs", "api:1"}, []string{"branch", "db_snapshot", "nick"}},
+		{nil, []string{"branch", "db_snapshot", "nick"}},
+	}
+	for _, c := range cases {
+		got, err := app.LoadParameter(get, c.taskdefs...)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %s", c.taskdefs, err)
+			continue
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("%v: unexpected parameters: %v", c.taskdefs, got)
+		}
+		for _, name := range c.want {
+			if got[name] != params[name] {
+				t.Errorf("%v: %s is not loaded: %v", c.taskdefs, name, got)
+			}
+		}
+	}
+
+	// required parameters of other task definitions are not required
+	if _, err := app.LoadParameter(func(string) string { return "" }, "docs"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if _, err := app.LoadParameter(func(string) string { return "" }, "api"); err == nil {
+		t.Error("required parameters must be required")
+	}
+
+	if _, err := newConfigFromYAML(t, "parameters:\n  - name: nick\n    task_definitions: [\"api:3\"]\n"); err == nil {
+		t.Error("task_definitions with revisions must fail")
+	}
+}
+
+func TestLauncherParametersForTaskDefinitions(t *testing.T) {
+	cfg, err := newConfigFromYAML(t, perTaskDefinitionParametersConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(context.Background(), cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/launcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if body := string(b); !strings.Contains(body, `data-task-definitions="api "`) || !strings.Contains(body, `data-task-definitions="api @template "`) {
+		t.Errorf("launcher must have task definitions of parameters: %s", body)
+	}
+}
@@ -0,0 +1,231 @@
+package mirageecs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tlsPassthroughHelloTimeout = 10 * time.Second
+	tlsPassthroughDialTimeout  = 10 * time.Second
+)
+
+var errServerNamePeeked = errors.New("server name is peeked")
+
+// validateTLSPassthrough validates listen.tls_passthrough.
+func validateTLSPassthrough(listen Listen) error {
+	ports := make(map[int]struct{}, len(listen.HTTP))
+	for _, v := range listen.HTTP {
+		ports[v.ListenPort] = struct{}{}
+	}
+	for _, v := range listen.TLSPassthrough {
+		if v.ListenPort <= 0 || v.ListenPort > 65535 || v.TargetPort <= 0 || v.TargetPort > 65535 {
+			return fmt.Errorf("invalid listen.tls_passthrough port: %d -> %d", v.ListenPort, v.TargetPort)
+		}
+		if _, ok := ports[v.ListenPort]; ok {
+			return fmt.Errorf("listen.tls_passthrough port %d conflicts with other listen ports", v.ListenPort)
+		}
+		ports[v.ListenPort] = struct{}{}
+		if v.RequireAuthCookie || v.Streaming {
+			return fmt.Errorf("require_auth_cookie and streaming are not supported by listen.tls_passthrough")
+		}
+	}
+	return nil
+}
+
+// passthroughTarget is addresses of tasks of a subdomain to route TLS connections.
+type passthroughTarget struct {
+	addrs   []string
+	private bool
+}
+
+// TLSPassthrough routes TLS connections to environments by SNI without terminating TLS.
+// So environments can authenticate clients by their own certificates (mTLS).
+type TLSPassthrough struct {
+	cfg *Config
+	rp  *ReverseProxy
+
+	mu      sync.RWMutex
+	targets map[string]*passthroughTarget // subdomain -> target
+}
+
+func NewTLSPassthrough(cfg *Config, rp *ReverseProxy) *TLSPassthrough {
+	if len(cfg.Listen.TLSPassthrough) == 0 {
+		return nil
+	}
+	return &TLSPassthrough{
+		cfg:     cfg,
+		rp:      rp,
+		targets: make(map[string]*passthroughTarget),
+	}
+}
+
+// Set replaces targets of subdomains. nil targets keep the current ones (e.g. while relaunching).
+func (p *TLSPassthrough) Set(desired map[string]*passthroughTarget) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	targets := make(map[string]*passthroughTarget, len(desired))
+	for subdomain, t := range desired {
+		if t == nil {
+			t = p.targets[subdomain]
+		}
+		if t != nil {
+			targets[subdomain] = t
+		}
+	}
+	p.targets = targets
+}
+
+// lookup returns the subdomain and the target of the server name.
+func (p *TLSPassthrough) lookup(serverName string) (string, *passthroughTarget, bool) {
+	host := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	subdomain := ""
+	if strings.HasSuffix(host, p.cfg.Host.ReverseProxySuffix) {
+		subdomain = strings.Split(host, ".")[0]
+	} else if s, ok := p.rp.AliasOf(host); ok {
+		subdomain = s
+	} else {
+		return "", nil, false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if t, ok := p.targets[subdomain]; ok {
+		return subdomain, t, true
+	}
+	for name, t := range p.targets {
+		if m, _ := path.Match(name, subdomain); m {
+			return name, t, true
+		}
+	}
+	return "", nil, false
+}
+
+// Serve accepts TLS connections on the listener until ctx is done.
+func (p *TLSPassthrough) Serve(ctx context.Context, l net.Listener, v PortMap) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		go p.handle(conn, v)
+	}
+}
+
+func (p *TLSPassthrough) handle(conn net.Conn, v PortMap) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(tlsPassthroughHelloTimeout))
+	serverName, hello, err := peekServerName(conn)
+	if err != nil {
+		slog.Debug(f("tls passthrough: failed to read client hello from %s: %s", conn.RemoteAddr(), err))
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	subdomain, target, ok := p.lookup(serverName)
+	if !ok || len(target.addrs) == 0 {
+		slog.Debug(f("tls passthrough: subdomain of %s is not found", serverName))
+		return
+	}
+	if target.private && !p.cfg.Network.Private.allowsAddr(remoteAddr(conn)) {
+		slog.Warn(f("subdomain %s is private. tls connection from %s is denied", subdomain, conn.RemoteAddr()))
+		return
+	}
+	addr := net.JoinHostPort(target.addrs[rand.Intn(len(target.addrs))], strconv.Itoa(v.TargetPort))
+	upstream, err := net.DialTimeout("tcp", addr, tlsPassthroughDialTimeout)
+	if err != nil {
+		slog.Warn(f("tls passthrough: failed to connect to %s of subdomain %s: %s", addr, subdomain, err))
+		return
+	}
+	defer upstream.Close()
+	p.rp.CountAccess(subdomain)
+	slog.Debug(f("tls passthrough: %s -> %s (%s)", conn.RemoteAddr(), addr, serverName))
+	pipeConn(conn, upstream, hello)
+}
+
+// readOnlyConn is a connection to read a ClientHello without responding to the client.
+type readOnlyConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c readOnlyConn) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+// peekServerName reads the ClientHello and returns the server name (SNI).
+// The returned reader replays bytes read from the connection.
+func peekServerName(conn net.Conn) (string, io.Reader, error) {
+	var buf bytes.Buffer
+	var serverName string
+	err := tls.Server(readOnlyConn{Conn: conn, r: io.TeeReader(conn, &buf)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errServerNamePeeked
+		},
+	}).Handshake()
+	if !errors.Is(err, errServerNamePeeked) {
+		return "", nil, err
+	}
+	if serverName == "" {
+		return "", nil, fmt.Errorf("no server name in client hello")
+	}
+	return serverName, io.MultiReader(&buf, conn), nil
+}
+
+// pipeConn copies data between the client and the upstream until both directions are closed.
+func pipeConn(client, upstream net.Conn, hello io.Reader) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(upstream, hello)
+		closeWrite(upstream)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(client, upstream)
+		closeWrite(client)
+	}()
+	wg.Wait()
+}
+
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+func remoteAddr(conn net.Conn) netip.Addr {
+	if a, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return a.AddrPort().Addr().Unmap()
+	}
+	return netip.Addr{}
+}
@@ -0,0 +1,97 @@
+package mirageecs_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestValidateTLSPassthrough(t *testing.T) {
+	httpPorts := []mirageecs.PortMap{{ListenPort: 80, TargetPort: 80}}
+	cases := []struct {
+		name string
+		tls  []mirageecs.PortMap
+		ok   bool
+	}{
+		{"empty", nil, true},
+		{"valid", []mirageecs.PortMap{{ListenPort: 443, TargetPort: 8443}}, true},
+		{"conflict with http", []mirageecs.PortMap{{ListenPort: 80, TargetPort: 8443}}, false},
+		{"duplicated", []mirageecs.PortMap{{ListenPort: 443, TargetPort: 8443}, {ListenPort: 443, TargetPort: 9443}}, false},
+		{"no target", []mirageecs.PortMap{{ListenPort: 443}}, false},
+		{"auth cookie", []mirageecs.PortMap{{ListenPort: 443, TargetPort: 8443, RequireAuthCookie: true}}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := mirageecs.ValidateTLSPassthrough(mirageecs.Listen{HTTP: httpPorts, TLSPassthrough: tc.tls})
+			if (err == nil) != tc.ok {
+				t.Errorf("unexpected result: %v", err)
+			}
+		})
+	}
+}
+
+func TestTLSPassthrough(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello from "+r.Host)
+	}))
+	defer upstream.Close()
+	_, port, _ := net.SplitHostPort(upstream.Listener.Addr().String())
+	targetPort, _ := strconv.Atoi(port)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{Domain: "example.net"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := mirageecs.PortMap{ListenPort: 443, TargetPort: targetPort}
+	cfg.Listen.TLSPassthrough = []mirageecs.PortMap{v}
+	rp := mirageecs.NewReverseProxy(cfg)
+	p := mirageecs.NewTLSPassthrough(cfg, rp)
+	p.SetTargets(map[string][]string{"pr1": {"127.0.0.1"}}, false)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(ctx, l, v)
+
+	get := func(serverName string) (string, error) {
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("tcp", l.Addr().String())
+			},
+			TLSClientConfig: &tls.Config{ServerName: serverName, InsecureSkipVerify: true},
+		}}
+		resp, err := client.Get("https://" + serverName + "/")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		return string(b), err
+	}
+
+	body, err := get("pr1.example.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "hello from pr1.example.net" {
+		t.Errorf("unexpected response: %s", body)
+	}
+	if _, err := get("unknown.example.net"); err == nil {
+		t.Error("connections to unknown subdomains must be closed")
+	}
+
+	p.SetTargets(map[string][]string{"pr1": {"127.0.0.1"}}, true)
+	if _, err := get("pr1.example.net"); err == nil {
+		t.Error("connections to private environments must be denied without network.private")
+	}
+}
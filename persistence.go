@@ -0,0 +1,541 @@
+package mirageecs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	DefaultPersistenceInterval = time.Minute
+
+	persistenceBucket = "subdomains"
+	sleepingBucket    = "sleeping"
+	// sleepingKeyPrefix is a prefix of partition keys of sleeping environments in the DynamoDB table.
+	sleepingKeyPrefix = "sleeping:"
+	// persistedRoutesLifetime is a limit of the age of routes to be restored.
+	// Older routes may point to addresses of other tasks.
+	persistedRoutesLifetime = 10 * time.Minute
+)
+
+// Persistence keeps the route table and access counters in a DynamoDB table or a local file,
+// to restore them on restarts.
+type Persistence struct {
+	// DynamoDBTable is a name of the DynamoDB table, which has the partition key "subdomain" (S).
+	// Enable TTL of the table with the attribute "expires_at" to remove items of environments which have gone.
+	DynamoDBTable string `yaml:"dynamodb_table"`
+	// File is a path of the database file. It should be on a persistent volume, e.g. EFS.
+	// States are stored in the storage file without dynamodb_table and file.
+	File string `yaml:"file"`
+	// Interval is an interval to save the state. Default is 1m. The state is also saved on shutdown.
+	Interval time.Duration `yaml:"interval"`
+}
+
+func (p *Persistence) validate(storage *Storage) error {
+	if p == nil {
+		return nil
+	}
+	if p.DynamoDBTable != "" && p.File != "" || p.DynamoDBTable == "" && p.File == "" && storage == nil {
+		return fmt.Errorf("either persistence.dynamodb_table or persistence.file is required")
+	}
+	if p.Interval == 0 {
+		p.Interval = DefaultPersistenceInterval
+	}
+	if p.Interval < time.Second {
+		return fmt.Errorf("persistence.interval must be at least 1s: %s", p.Interval)
+	}
+	return nil
+}
+
+// persistedSubdomain is a state of the subdomain kept in the persistence.
+type persistedSubdomain struct {
+	Subdomain string                 `json:"subdomain"`
+	Routes    []*persistedRoute      `json:"routes,omitempty"`
+	Access    *accessCounterSnapshot `json:"access,omitempty"`
+	UpdatedAt time.Time              `json:"updated_at"`
+}
+
+// persistedRoute is a route to a task of the subdomain.
+type persistedRoute struct {
+	IPAddress  string         `json:"ip_address"`
+	PortMap    map[string]int `json:"port_map"`
+	PortRoutes PortRoutes     `json:"port_routes,omitempty"`
+	Option     *LaunchOption  `json:"option,omitempty"`
+}
+
+// persistedSleeping is a sleeping environment in the task mode, whose tasks are relaunched on wake.
+type persistedSleeping struct {
+	Subdomain string                   `json:"subdomain"`
+	Reason    string                   `json:"reason"`
+	Tasks     []*persistedSleepingTask `json:"tasks"`
+	SleptAt   time.Time                `json:"slept_at"`
+}
+
+// persistedSleepingTask is a stopped task of the sleeping environment with parameters to relaunch it.
+type persistedSleepingTask struct {
+	Info                 *Information        `json:"info"`
+	TaskDefinitionArn    string              `json:"task_definition_arn"`
+	Overrides            *types.TaskOverride `json:"overrides,omitempty"`
+	EnableExecuteCommand bool                `json:"enable_execute_command"`
+}
+
+func newPersistedSleepingTask(info *Information) *persistedSleepingTask {
+	t := &persistedSleepingTask{Info: info}
+	if info.task != nil {
+		t.TaskDefinitionArn = aws.ToString(info.task.TaskDefinitionArn)
+		t.Overrides = info.task.Overrides
+		t.EnableExecuteCommand = info.task.EnableExecuteCommand
+	}
+	return t
+}
+
+// information returns the task to relaunch.
+func (t *persistedSleepingTask) information() *Information {
+	info := *t.Info
+	info.Option = launchOptionFromTags(info.Tags)
+	info.task = &types.Task{
+		TaskDefinitionArn:    aws.String(t.TaskDefinitionArn),
+		Overrides:            t.Overrides,
+		EnableExecuteCommand: t.EnableExecuteCommand,
+	}
+	return &info
+}
+
+// persistenceStore stores states of subdomains.
+type persistenceStore interface {
+	save(ctx context.Context, s *persistedSubdomain) error
+	delete(ctx context.Context, subdomain string) error
+	// load returns states which have not expired.
+	load(ctx context.Context) ([]*persistedSubdomain, error)
+	// saveSleeping, deleteSleeping and listSleeping keep sleeping environments, which never expire.
+	saveSleeping(ctx context.Context, s *persistedSleeping) error
+	// deleteSleeping returns the deleted environment, or nil if it is not sleeping.
+	deleteSleeping(ctx context.Context, subdomain string) (*persistedSleeping, error)
+	listSleeping(ctx context.Context) ([]*persistedSleeping, error)
+	close() error
+}
+
+func (c *Config) openPersistence() error {
+	p := c.Persistence
+	if p == nil {
+		return nil
+	}
+	retention := c.Network.AccessCounter.retention()
+	if p.DynamoDBTable != "" {
+		c.persistence = &dynamoDBPersistence{
+			svc:       dynamodb.NewFromConfig(*c.awscfg),
+			table:     p.DynamoDBTable,
+			retention: retention,
+		}
+	} else if p.File == "" {
+		// the storage file is closed by its own cleanup
+		c.persistence = &filePersistence{db: c.storage, retention: retention}
+		return nil
+	} else {
+		s, err := openFilePersistence(p.File, retention)
+		if err != nil {
+			return err
+		}
+		c.persistence = s
+	}
+	c.cleanups = append(c.cleanups, c.persistence.close)
+	return nil
+}
+
+// filePersistence is a persistence stored in a bbolt database file.
+type filePersistence struct {
+	db        *bolt.DB
+	retention time.Duration
+}
+
+func openFilePersistence(path string, retention time.Duration) (*filePersistence, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: historyOpenLimit})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open persistence file %s: %w", path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{persistenceBucket, sleepingBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bucket of persistence file %s: %w", path, err)
+	}
+	return &filePersistence{db: db, retention: retention}, nil
+}
+
+func (s *filePersistence) save(ctx context.Context, p *persistedSubdomain) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(persistenceBucket)).Put([]byte(p.Subdomain), b)
+	})
+}
+
+func (s *filePersistence) delete(ctx context.Context, subdomain string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(persistenceBucket)).Delete([]byte(subdomain))
+	})
+}
+
+// load returns states saved within the retention, and removes expired ones.
+func (s *filePersistence) load(ctx context.Context) ([]*persistedSubdomain, error) {
+	var states []*persistedSubdomain
+	expired := time.Now().Add(-s.retention)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(persistenceBucket)).Cursor()
+		for k, v := c.First(); k != nil; {
+			var p persistedSubdomain
+			if err := json.Unmarshal(v, &p); err != nil {
+				return fmt.Errorf("failed to parse persisted state of %s: %w", k, err)
+			}
+			if p.UpdatedAt.Before(expired) {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				// Delete moves the cursor to the next key
+				k, v = c.Seek(k)
+				continue
+			}
+			states = append(states, &p)
+			k, v = c.Next()
+		}
+		return nil
+	})
+	return states, err
+}
+
+func (s *filePersistence) saveSleeping(ctx context.Context, p *persistedSleeping) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(sleepingBucket)).Put([]byte(p.Subdomain), b)
+	})
+}
+
+func (s *filePersistence) deleteSleeping(ctx context.Context, subdomain string) (*persistedSleeping, error) {
+	var p *persistedSleeping
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(sleepingBucket))
+		v := b.Get([]byte(subdomain))
+		if v == nil {
+			return nil
+		}
+		p = &persistedSleeping{}
+		if err := json.Unmarshal(v, p); err != nil {
+			return fmt.Errorf("failed to parse sleeping environment %s: %w", subdomain, err)
+		}
+		return b.Delete([]byte(subdomain))
+	})
+	return p, err
+}
+
+func (s *filePersistence) listSleeping(ctx context.Context) ([]*persistedSleeping, error) {
+	var envs []*persistedSleeping
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(sleepingBucket)).ForEach(func(k, v []byte) error {
+			var p persistedSleeping
+			if err := json.Unmarshal(v, &p); err != nil {
+				return fmt.Errorf("failed to parse sleeping environment %s: %w", k, err)
+			}
+			envs = append(envs, &p)
+			return nil
+		})
+	})
+	return envs, err
+}
+
+func (s *filePersistence) close() error {
+	return s.db.Close()
+}
+
+// dynamoDBPersistence is a persistence stored in a DynamoDB table.
+type dynamoDBPersistence struct {
+	svc       *dynamodb.Client
+	table     string
+	retention time.Duration
+}
+
+func (s *dynamoDBPersistence) save(ctx context.Context, p *persistedSubdomain) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = s.svc.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]ddbTypes.AttributeValue{
+			"subdomain":  &ddbTypes.AttributeValueMemberS{Value: p.Subdomain},
+			"state":      &ddbTypes.AttributeValueMemberS{Value: string(b)},
+			"expires_at": &ddbTypes.AttributeValueMemberN{Value: strconv.FormatInt(p.UpdatedAt.Add(s.retention).Unix(), 10)},
+		},
+	})
+	return err
+}
+
+func (s *dynamoDBPersistence) delete(ctx context.Context, subdomain string) error {
+	_, err := s.svc.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]ddbTypes.AttributeValue{
+			"subdomain": &ddbTypes.AttributeValueMemberS{Value: subdomain},
+		},
+	})
+	return err
+}
+
+// load scans the table. Expired items are filtered out, because TTL of DynamoDB removes items lazily.
+func (s *dynamoDBPersistence) load(ctx context.Context) ([]*persistedSubdomain, error) {
+	var states []*persistedSubdomain
+	expired := time.Now().Add(-s.retention)
+	p := dynamodb.NewScanPaginator(s.svc, &dynamodb.ScanInput{
+		TableName: aws.String(s.table),
+	})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			v, ok := item["state"].(*ddbTypes.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			var ps persistedSubdomain
+			if err := json.Unmarshal([]byte(v.Value), &ps); err != nil {
+				return nil, fmt.Errorf("failed to parse persisted state of %s: %w", ps.Subdomain, err)
+			}
+			if ps.UpdatedAt.Before(expired) {
+				continue
+			}
+			states = append(states, &ps)
+		}
+	}
+	return states, nil
+}
+
+// saveSleeping puts the sleeping environment as an item without expires_at, whose key is prefixed not to be loaded as a state.
+func (s *dynamoDBPersistence) saveSleeping(ctx context.Context, p *persistedSleeping) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = s.svc.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]ddbTypes.AttributeValue{
+			"subdomain": &ddbTypes.AttributeValueMemberS{Value: sleepingKeyPrefix + p.Subdomain},
+			"sleeping":  &ddbTypes.AttributeValueMemberS{Value: string(b)},
+		},
+	})
+	return err
+}
+
+func (s *dynamoDBPersistence) deleteSleeping(ctx context.Context, subdomain string) (*persistedSleeping, error) {
+	out, err := s.svc.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]ddbTypes.AttributeValue{
+			"subdomain": &ddbTypes.AttributeValueMemberS{Value: sleepingKeyPrefix + subdomain},
+		},
+		ReturnValues: ddbTypes.ReturnValueAllOld,
+	})
+	if err != nil {
+		return nil, err
+	}
+	v, ok := out.Attributes["sleeping"].(*ddbTypes.AttributeValueMemberS)
+	if !ok {
+		return nil, nil
+	}
+	var p persistedSleeping
+	if err := json.Unmarshal([]byte(v.Value), &p); err != nil {
+		return nil, fmt.Errorf("failed to parse sleeping environment %s: %w", subdomain, err)
+	}
+	return &p, nil
+}
+
+func (s *dynamoDBPersistence) listSleeping(ctx context.Context) ([]*persistedSleeping, error) {
+	var envs []*persistedSleeping
+	p := dynamodb.NewScanPaginator(s.svc, &dynamodb.ScanInput{
+		TableName:                aws.String(s.table),
+		FilterExpression:         aws.String("attribute_exists(#sleeping)"),
+		ExpressionAttributeNames: map[string]string{"#sleeping": "sleeping"},
+	})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			v, ok := item["sleeping"].(*ddbTypes.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			var ps persistedSleeping
+			if err := json.Unmarshal([]byte(v.Value), &ps); err != nil {
+				return nil, fmt.Errorf("failed to parse sleeping environment: %w", err)
+			}
+			envs = append(envs, &ps)
+		}
+	}
+	return envs, nil
+}
+
+func (s *dynamoDBPersistence) close() error {
+	return nil
+}
+
+// persister saves the route table and access counters of Mirage.
+type persister struct {
+	store  persistenceStore
+	mu     sync.Mutex
+	routes map[string][]*persistedRoute // subdomain -> routes of ready tasks
+	saved  map[string]struct{}          // subdomains which have been saved
+}
+
+func newPersister(store persistenceStore) *persister {
+	if store == nil {
+		return nil
+	}
+	return &persister{
+		store:  store,
+		routes: make(map[string][]*persistedRoute),
+		saved:  make(map[string]struct{}),
+	}
+}
+
+// setRoutes replaces routes to be saved by routes of the ready tasks.
+func (p *persister) setRoutes(n Network, infos []*Information) {
+	if p == nil {
+		return
+	}
+	routes := make(map[string][]*persistedRoute)
+	for _, info := range infos {
+		if !info.Ready || info.IPAddress == "" {
+			continue
+		}
+		routes[info.SubDomain] = append(routes[info.SubDomain], &persistedRoute{
+			IPAddress:  info.IPAddress,
+			PortMap:    info.PortMap,
+			PortRoutes: n.portRoutesFor(info),
+			Option:     info.Option,
+		})
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.routes = routes
+}
+
+// restoreState restores access counters and routes from the persistence.
+// Restored routes are used when running tasks cannot be listed on startup, until the first sync with ECS.
+func (m *Mirage) restoreState(ctx context.Context) {
+	if m.persister == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, APICallTimeout)
+	defer cancel()
+	states, err := m.persister.store.load(ctx)
+	if err != nil {
+		slog.Warn(f("failed to load persisted state: %s", err))
+		return
+	}
+	rp := m.ReverseProxy
+	restored := 0
+	for _, s := range states {
+		if s.Access != nil {
+			rp.restoreAccess(s.Subdomain, s.Access)
+		}
+		if time.Since(s.UpdatedAt) > persistedRoutesLifetime {
+			continue
+		}
+		for _, r := range s.Routes {
+			if r.Option != nil {
+				if err := r.Option.Rewrites.compile(); err != nil {
+					slog.Warn(f("skip restoring routes of subdomain %s: %s", s.Subdomain, err))
+					continue
+				}
+			}
+			for _, port := range r.PortMap {
+				rp.AddSubdomain(s.Subdomain, r.IPAddress, port, r.Option)
+			}
+			for name, port := range r.PortRoutes {
+				rp.AddPortRoute(s.Subdomain, name, r.IPAddress, port, r.Option)
+			}
+			rp.SetAliases(s.Subdomain, r.Option.aliases())
+		}
+		m.persister.saved[s.Subdomain] = struct{}{}
+		restored++
+	}
+	slog.Info(f("restored persisted state of %d subdomains", restored))
+}
+
+// RunPersister saves the state periodically and on shutdown.
+func (m *Mirage) RunPersister(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	tk := time.NewTicker(m.Config.Persistence.Interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			m.saveState(ctx)
+		case <-ctx.Done():
+			// ctx is canceled. save the last state with a new context
+			sctx, cancel := context.WithTimeout(context.Background(), APICallTimeout)
+			m.saveState(sctx)
+			cancel()
+			slog.Warn("RunPersister() is done")
+			return
+		}
+	}
+}
+
+// saveState saves states of subdomains which have routes or access counters, and deletes states of the others.
+func (m *Mirage) saveState(ctx context.Context) {
+	p := m.persister
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	states := make(map[string]*persistedSubdomain)
+	for subdomain, routes := range p.routes {
+		states[subdomain] = &persistedSubdomain{Subdomain: subdomain, Routes: routes, UpdatedAt: now}
+	}
+	for subdomain, access := range m.ReverseProxy.accessSnapshots() {
+		s, ok := states[subdomain]
+		if !ok {
+			s = &persistedSubdomain{Subdomain: subdomain, UpdatedAt: now}
+			states[subdomain] = s
+		}
+		s.Access = access
+	}
+	for subdomain, s := range states {
+		if err := p.store.save(ctx, s); err != nil {
+			slog.Warn(f("failed to save state of subdomain %s: %s", subdomain, err))
+			continue
+		}
+		p.saved[subdomain] = struct{}{}
+	}
+	for subdomain := range p.saved {
+		if _, ok := states[subdomain]; ok {
+			continue
+		}
+		if err := p.store.delete(ctx, subdomain); err != nil {
+			slog.Warn(f("failed to delete state of subdomain %s: %s", subdomain, err))
+			continue
+		}
+		delete(p.saved, subdomain)
+	}
+	slog.Debug(f("saved state of %d subdomains", len(states)))
+}
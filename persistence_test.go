@@ -0,0 +1,149 @@
+package mirageecs_test
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestPersistenceValidate(t *testing.T) {
+	for _, p := range []*mirageecs.Persistence{
+		{},
+		{DynamoDBTable: "mirage-state", File: "/tmp/state.db"},
+		{File: "/tmp/state.db", Interval: time.Millisecond},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("%#v should be invalid", p)
+		}
+	}
+	p := &mirageecs.Persistence{DynamoDBTable: "mirage-state"}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Interval != mirageecs.DefaultPersistenceInterval {
+		t.Errorf("unexpected default interval: %s", p.Interval)
+	}
+}
+
+func newPersistentMirage(t *testing.T, file string) *mirageecs.Mirage {
+	t.Helper()
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Persistence = &mirageecs.Persistence{File: file}
+	if err := cfg.OpenPersistence(); err != nil {
+		t.Fatal(err)
+	}
+	return mirageecs.New(ctx, cfg)
+}
+
+func TestPersistence(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "state.db")
+
+	m := newPersistentMirage(t, file)
+	if err := m.Runner().Launch(ctx, "foo", mirageecs.TaskParameter{"branch": "develop"}, nil, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+	m.ReverseProxy.CountAccess("foo")
+	m.ReverseProxy.AccessCounterOf("foo").Record(http.StatusOK, 30*time.Millisecond, false)
+	m.ReverseProxy.CountAccess("gone")
+	last := m.ReverseProxy.LastAccess("foo")
+	if err := m.SaveState(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m.Config.Cleanup()
+
+	// restarted
+	m = newPersistentMirage(t, file)
+	m.RestoreState(ctx)
+	rp := m.ReverseProxy
+	if !rp.Exists("foo") {
+		t.Error("route of foo is not restored")
+	}
+	if rp.Exists("gone") {
+		t.Error("gone has no routes")
+	}
+	if got := rp.LastAccess("foo"); !got.Equal(last) {
+		t.Errorf("unexpected last access: %s, want %s", got, last)
+	}
+	stats := rp.AccessStats("foo", time.Now().Add(-time.Hour))
+	if len(stats) != 1 || stats[0].Count != 1 || stats[0].Status["2xx"] != 1 || stats[0].Latency.P50 != 30 {
+		t.Errorf("unexpected access stats: %#v", stats)
+	}
+
+	// states of subdomains which have gone are deleted
+	rp.RemoveSubdomain("foo")
+	rp.RemoveSubdomain("gone")
+	if err := m.Runner().TerminateBySubdomain(ctx, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SaveState(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m.Config.Cleanup()
+	m = newPersistentMirage(t, file)
+	defer m.Config.Cleanup()
+	m.RestoreState(ctx)
+	if m.ReverseProxy.Exists("foo") || !m.ReverseProxy.LastAccess("foo").IsZero() {
+		t.Error("state of foo should be deleted")
+	}
+}
+
+func TestPersistentSleeping(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "state.db")
+	tags := []types.Tag{
+		{Key: aws.String(mirageecs.TagSubdomain), Value: aws.String("foo")},
+		{Key: aws.String(mirageecs.TagProtected), Value: aws.String("true")},
+	}
+	task := &mirageecs.Information{ID: "arn:aws:ecs:ap-northeast-1:123456789012:task/mirage/0123", SubDomain: "foo", TaskDef: "app:1", IPAddress: "10.0.0.1", Tags: tags, Protected: true}
+	task.SetTask(&types.Task{
+		TaskDefinitionArn:    aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task-definition/app:1"),
+		Overrides:            &types.TaskOverride{ContainerOverrides: []types.ContainerOverride{{Name: aws.String("app"), Environment: []types.KeyValuePair{{Name: aws.String("BRANCH"), Value: aws.String("develop")}}}}},
+		EnableExecuteCommand: true,
+	})
+
+	m := newPersistentMirage(t, file)
+	if err := mirageecs.NewSleepingStore(m.Config).Add(ctx, "foo", "schedule:office-hours", []*mirageecs.Information{task}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SaveState(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m.Config.Cleanup()
+
+	// restarted
+	m = newPersistentMirage(t, file)
+	defer m.Config.Cleanup()
+	s := mirageecs.NewSleepingStore(m.Config)
+	infos, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].SubDomain != "foo" || infos[0].SleepReason != "schedule:office-hours" || infos[0].IPAddress != "" || !infos[0].Protected {
+		t.Errorf("unexpected sleeping environments: %#v", infos)
+	}
+	tasks, ok, err := s.Remove(ctx, "foo")
+	if err != nil || !ok || len(tasks) != 1 {
+		t.Fatalf("foo should be sleeping: %v %v", tasks, err)
+	}
+	if tk := tasks[0].Task(); aws.ToString(tk.TaskDefinitionArn) != aws.ToString(task.Task().TaskDefinitionArn) || !tk.EnableExecuteCommand ||
+		aws.ToString(tk.Overrides.ContainerOverrides[0].Environment[0].Value) != "develop" {
+		t.Errorf("unexpected task to relaunch: %#v", tk)
+	}
+	if tasks[0].Option == nil || !tasks[0].Option.Protected {
+		t.Errorf("unexpected option: %#v", tasks[0].Option)
+	}
+	if _, ok, _ := s.Remove(ctx, "foo"); ok {
+		t.Error("foo should be removed")
+	}
+}
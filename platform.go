@@ -0,0 +1,114 @@
+package mirageecs
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+)
+
+// RuntimePlatform is a runtime platform (CPU architecture and OS family) of launched tasks.
+type RuntimePlatform struct {
+	CpuArchitecture       string `yaml:"cpu_architecture" json:"cpu_architecture,omitempty"`
+	OperatingSystemFamily string `yaml:"operating_system_family" json:"operating_system_family,omitempty"`
+}
+
+func (p *RuntimePlatform) isEmpty() bool {
+	return p == nil || (p.CpuArchitecture == "" && p.OperatingSystemFamily == "")
+}
+
+func (p *RuntimePlatform) validate() error {
+	if p == nil {
+		return nil
+	}
+	p.CpuArchitecture = strings.ToUpper(p.CpuArchitecture)
+	p.OperatingSystemFamily = strings.ToUpper(p.OperatingSystemFamily)
+	if a := p.CpuArchitecture; a != "" {
+		if !lo.Contains(types.CPUArchitecture("").Values(), types.CPUArchitecture(a)) {
+			return fmt.Errorf("invalid cpu_architecture: %s", a)
+		}
+	}
+	if o := p.OperatingSystemFamily; o != "" {
+		if !lo.Contains(types.OSFamily("").Values(), types.OSFamily(o)) {
+			return fmt.Errorf("invalid operating_system_family: %s", o)
+		}
+	}
+	return nil
+}
+
+func cpuArchitecture(p *RuntimePlatform) string {
+	if p == nil {
+		return ""
+	}
+	return p.CpuArchitecture
+}
+
+// runtimePlatformFor returns the runtime platform applied at launch.
+// The platform specified at launch is prior to the platform in the config.
+func (p *RuntimePlatform) runtimePlatformFor(opt *LaunchOption) *RuntimePlatform {
+	var r RuntimePlatform
+	if p != nil {
+		r = *p
+	}
+	if opt != nil && opt.RuntimePlatform != nil {
+		if a := opt.RuntimePlatform.CpuArchitecture; a != "" {
+			r.CpuArchitecture = a
+		}
+		if o := opt.RuntimePlatform.OperatingSystemFamily; o != "" {
+			r.OperatingSystemFamily = o
+		}
+	}
+	if r.isEmpty() {
+		return nil
+	}
+	return &r
+}
+
+// merge returns the runtime platform of the task definition overridden by p.
+func (p *RuntimePlatform) merge(current *types.RuntimePlatform) *types.RuntimePlatform {
+	rp := &types.RuntimePlatform{}
+	if current != nil {
+		*rp = *current
+	}
+	if p.CpuArchitecture != "" {
+		rp.CpuArchitecture = types.CPUArchitecture(p.CpuArchitecture)
+	}
+	if p.OperatingSystemFamily != "" {
+		rp.OperatingSystemFamily = types.OSFamily(p.OperatingSystemFamily)
+	}
+	return rp
+}
+
+// needsModification reports whether the runtime platform differs from the task definition.
+func (p *RuntimePlatform) needsModification(td *types.TaskDefinition) bool {
+	rp := p.merge(td.RuntimePlatform)
+	if td.RuntimePlatform == nil {
+		return true
+	}
+	return *rp != *td.RuntimePlatform
+}
+
+// validateFor validates the runtime platform against compatibilities of the task definition.
+func (p *RuntimePlatform) validateFor(td *types.TaskDefinition) error {
+	rp := p.merge(td.RuntimePlatform)
+	windows := strings.HasPrefix(string(rp.OperatingSystemFamily), "WINDOWS")
+	if windows && rp.CpuArchitecture == types.CPUArchitectureArm64 {
+		return fmt.Errorf("cpu_architecture ARM64 is not supported with %s", rp.OperatingSystemFamily)
+	}
+	for _, c := range td.RequiresCompatibilities {
+		if c == types.CompatibilityExternal && rp.CpuArchitecture != "" {
+			return fmt.Errorf("runtime platform can't be specified for the task definition which requires EXTERNAL compatibility")
+		}
+	}
+	return nil
+}
+
+func runtimePlatformModifier(p *RuntimePlatform) taskDefinitionModifier {
+	return func(in *ecs.RegisterTaskDefinitionInput) {
+		in.RuntimePlatform = p.merge(in.RuntimePlatform)
+		slog.Info(f("runtime platform: %s/%s", in.RuntimePlatform.OperatingSystemFamily, in.RuntimePlatform.CpuArchitecture))
+	}
+}
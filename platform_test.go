@@ -0,0 +1,63 @@
+package mirageecs_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestRuntimePlatformValidate(t *testing.T) {
+	p := &mirageecs.RuntimePlatform{CpuArchitecture: "arm64", OperatingSystemFamily: "linux"}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.CpuArchitecture != "ARM64" || p.OperatingSystemFamily != "LINUX" {
+		t.Errorf("values must be normalized: %#v", p)
+	}
+	if err := (&mirageecs.RuntimePlatform{CpuArchitecture: "RISCV"}).Validate(); err == nil {
+		t.Error("expected error for invalid cpu architecture")
+	}
+}
+
+func TestRuntimePlatformFor(t *testing.T) {
+	cfg := &mirageecs.RuntimePlatform{CpuArchitecture: "X86_64", OperatingSystemFamily: "LINUX"}
+	opt := &mirageecs.LaunchOption{RuntimePlatform: &mirageecs.RuntimePlatform{CpuArchitecture: "ARM64"}}
+	rp := cfg.RuntimePlatformFor(opt)
+	if rp.CpuArchitecture != "ARM64" || rp.OperatingSystemFamily != "LINUX" {
+		t.Errorf("unexpected runtime platform: %#v", rp)
+	}
+	var empty *mirageecs.RuntimePlatform
+	if rp := empty.RuntimePlatformFor(nil); rp != nil {
+		t.Errorf("runtime platform must be nil: %#v", rp)
+	}
+
+	td := &types.TaskDefinition{
+		RuntimePlatform: &types.RuntimePlatform{
+			CpuArchitecture:       types.CPUArchitectureArm64,
+			OperatingSystemFamily: types.OSFamilyLinux,
+		},
+	}
+	if rp.NeedsModification(td) {
+		t.Error("task definition which has the same runtime platform must not be modified")
+	}
+	td.RuntimePlatform.CpuArchitecture = types.CPUArchitectureX8664
+	if !rp.NeedsModification(td) {
+		t.Error("task definition which has another runtime platform must be modified")
+	}
+}
+
+func TestRuntimePlatformValidateFor(t *testing.T) {
+	rp := &mirageecs.RuntimePlatform{CpuArchitecture: "ARM64"}
+	windows := &types.TaskDefinition{
+		RuntimePlatform: &types.RuntimePlatform{OperatingSystemFamily: types.OSFamilyWindowsServer2022Core},
+	}
+	if err := rp.ValidateFor(windows); err == nil {
+		t.Error("ARM64 with Windows must be invalid")
+	}
+	fargate := &types.TaskDefinition{RequiresCompatibilities: []types.Compatibility{types.CompatibilityFargate}}
+	if err := rp.ValidateFor(fargate); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package mirageecs
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+)
+
+const TagPortRoutes = "MiragePortRoutes"
+
+// portRouteNameRegexp matches names of port routes, which are used as a DNS label prefix.
+var portRouteNameRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// PortRoutes maps names to container ports. A port route is served at <name>-<subdomain>.
+type PortRoutes map[string]int
+
+func (rs PortRoutes) validate() error {
+	for name, port := range rs {
+		if !portRouteNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid port route name: %s", name)
+		}
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port of port route %s: %d", name, port)
+		}
+	}
+	return nil
+}
+
+// String returns port routes in the form of "name=port name=port ...", sorted by names.
+func (rs PortRoutes) String() string {
+	items := make([]string, 0, len(rs))
+	for name, port := range rs {
+		items = append(items, name+"="+strconv.Itoa(port))
+	}
+	sort.Strings(items)
+	return strings.Join(items, " ")
+}
+
+func parsePortRoutes(s string) (PortRoutes, error) {
+	rs := make(PortRoutes)
+	for _, item := range strings.Fields(s) {
+		name, port, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid port route: %s", item)
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port of port route: %s", item)
+		}
+		rs[name] = p
+	}
+	return rs, rs.validate()
+}
+
+// namedPortsInTaskDefinition returns container ports of named port mappings in the task definition.
+func namedPortsInTaskDefinition(td *types.TaskDefinition) PortRoutes {
+	ports := make(PortRoutes)
+	for _, c := range td.ContainerDefinitions {
+		for _, m := range c.PortMappings {
+			name := strings.ToLower(aws.ToString(m.Name))
+			if name == "" || m.ContainerPort == nil || !portRouteNameRegexp.MatchString(name) {
+				continue
+			}
+			ports[name] = int(*m.ContainerPort)
+		}
+	}
+	return ports
+}
+
+// hostPortsOfTask maps container ports to host ports of the task, like PortMap of the environment.
+// Host ports in the task definition are overridden by network bindings of the task,
+// which have dynamically assigned host ports in the bridge network mode.
+func hostPortsOfTask(td *types.TaskDefinition, task *types.Task) map[int]int {
+	ports := make(map[int]int)
+	for _, c := range td.ContainerDefinitions {
+		for _, m := range c.PortMappings {
+			if m.ContainerPort == nil || m.HostPort == nil || *m.HostPort == 0 {
+				continue
+			}
+			ports[int(*m.ContainerPort)] = int(*m.HostPort)
+		}
+	}
+	if task == nil {
+		return ports
+	}
+	for _, c := range task.Containers {
+		for _, b := range c.NetworkBindings {
+			if b.ContainerPort == nil || b.HostPort == nil || *b.HostPort == 0 {
+				continue
+			}
+			ports[int(*b.ContainerPort)] = int(*b.HostPort)
+		}
+	}
+	return ports
+}
+
+// hostPortOf returns the host port of the container port. In the awsvpc network mode, they are the same.
+func (info *Information) hostPortOf(containerPort int) int {
+	if p, ok := info.hostPorts[containerPort]; ok {
+		return p
+	}
+	return containerPort
+}
+
+// portRoutesFor returns host ports of port routes of the environment.
+// Routes specified at launch are prior to named ports in the task definition.
+func (n Network) portRoutesFor(info *Information) PortRoutes {
+	routes := make(PortRoutes)
+	if n.NamedPortRouting {
+		for name, port := range info.NamedPorts {
+			routes[name] = info.hostPortOf(port)
+		}
+	}
+	if info.Option != nil {
+		for name, port := range info.Option.PortRoutes {
+			routes[name] = info.hostPortOf(port)
+		}
+	}
+	return routes
+}
+
+func portRouteName(name, subdomain string) string {
+	return name + "-" + subdomain
+}
+
+// AddPortRoute adds a route from <name>-<subdomain> to the port of the task on all HTTP listeners.
+// Accesses to the route are counted as accesses to the subdomain.
+func (r *ReverseProxy) AddPortRoute(subdomain string, name string, ipaddress string, targetPort int, opt *LaunchOption) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	route := portRouteName(name, subdomain)
+	if lo.Contains(r.domains, route) {
+		slog.Warn(f("port route %s conflicts with the subdomain. skipped", route))
+		return
+	}
+	addr := net.JoinHostPort(ipaddress, strconv.Itoa(targetPort))
+	ph, exists := r.domainMap[route]
+	if !exists {
+		ph = make(proxyHandlers)
+	}
+	for _, v := range r.cfg.Listen.HTTP {
+		if ph.exists(v.ListenPort, addr) {
+			continue
+		}
+		handler, err := r.newHandler(subdomain, addr, v, opt)
+		if err != nil {
+			slog.Error(err.Error())
+			continue
+		}
+		ph.add(v.ListenPort, addr, handler)
+		slog.Info(f("add port route: %s:%d -> %s", route, v.ListenPort, addr))
+	}
+	r.domainMap[route] = ph
+	r.portRoutes[route] = subdomain
+}
+
+// removePortRoutes removes port routes of the subdomain. r.mu must be locked.
+func (r *ReverseProxy) removePortRoutes(subdomain string) {
+	for route, parent := range r.portRoutes {
+		if parent == subdomain {
+			slog.Info(f("removing port route: %s", route))
+			delete(r.portRoutes, route)
+			delete(r.domainMap, route)
+		}
+	}
+}
+
+// matchPortRoute returns a port route matched with the subdomain pattern. r.mu must be locked.
+func (r *ReverseProxy) matchPortRoute(subdomain string) (string, bool) {
+	for route := range r.portRoutes {
+		if m, _ := path.Match(route, subdomain); m {
+			return route, true
+		}
+	}
+	return "", false
+}
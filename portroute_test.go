@@ -0,0 +1,133 @@
+package mirageecs_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/google/go-cmp/cmp"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestPortRoutesString(t *testing.T) {
+	rs := mirageecs.PortRoutes{"web": 3000, "api": 8080, "mail": 8025}
+	s := rs.String()
+	if s != "api=8080 mail=8025 web=3000" {
+		t.Errorf("unexpected string: %s", s)
+	}
+	parsed, err := mirageecs.ParsePortRoutes(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(rs, parsed); diff != "" {
+		t.Errorf("Mismatch in PortRoutes (-want +got):\n%s", diff)
+	}
+	for _, s := range []string{"web", "web=x", "Web=80", "web=70000"} {
+		if _, err := mirageecs.ParsePortRoutes(s); err == nil {
+			t.Errorf("expected error for %s", s)
+		}
+	}
+}
+
+func TestPortRoutesFor(t *testing.T) {
+	info := &mirageecs.Information{
+		NamedPorts: mirageecs.PortRoutes{"web": 3000, "mail": 8025},
+		Option:     &mirageecs.LaunchOption{PortRoutes: mirageecs.PortRoutes{"mail": 1025}},
+	}
+	if diff := cmp.Diff(mirageecs.PortRoutes{"mail": 1025}, mirageecs.Network{}.PortRoutesFor(info)); diff != "" {
+		t.Errorf("Mismatch in PortRoutes (-want +got):\n%s", diff)
+	}
+	n := mirageecs.Network{NamedPortRouting: true}
+	if diff := cmp.Diff(mirageecs.PortRoutes{"web": 3000, "mail": 1025}, n.PortRoutesFor(info)); diff != "" {
+		t.Errorf("Mismatch in PortRoutes (-want +got):\n%s", diff)
+	}
+}
+
+func TestPortRoutesForBridgeNetworkMode(t *testing.T) {
+	td := &types.TaskDefinition{
+		NetworkMode: types.NetworkModeBridge,
+		ContainerDefinitions: []types.ContainerDefinition{
+			{
+				Name: aws.String("app"),
+				PortMappings: []types.PortMapping{
+					{Name: aws.String("web"), ContainerPort: aws.Int32(3000), HostPort: aws.Int32(0)},
+					{Name: aws.String("mail"), ContainerPort: aws.Int32(8025), HostPort: aws.Int32(18025)},
+					{ContainerPort: aws.Int32(1025), HostPort: aws.Int32(0)},
+				},
+			},
+		},
+	}
+	task := &types.Task{
+		Containers: []types.Container{
+			{
+				Name: aws.String("app"),
+				NetworkBindings: []types.NetworkBinding{
+					{ContainerPort: aws.Int32(3000), HostPort: aws.Int32(32768)},
+					{ContainerPort: aws.Int32(1025), HostPort: aws.Int32(32769)},
+				},
+			},
+		},
+	}
+	info := &mirageecs.Information{
+		NamedPorts: mirageecs.NamedPortsInTaskDefinition(td),
+		Option:     &mirageecs.LaunchOption{PortRoutes: mirageecs.PortRoutes{"smtp": 1025}},
+	}
+	info.SetHostPorts(td, task)
+	n := mirageecs.Network{NamedPortRouting: true}
+	want := mirageecs.PortRoutes{"web": 32768, "mail": 18025, "smtp": 32769}
+	if diff := cmp.Diff(want, n.PortRoutesFor(info)); diff != "" {
+		t.Errorf("Mismatch in PortRoutes (-want +got):\n%s", diff)
+	}
+}
+
+func TestReverseProxyPortRoute(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		Domain: "example.net",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Listen.HTTP = []mirageecs.PortMap{
+		{ListenPort: 80, TargetPort: 80},
+	}
+	mail := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "mail")
+	}))
+	defer mail.Close()
+	host, port, _ := net.SplitHostPort(mail.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	rp := mirageecs.NewReverseProxy(cfg)
+	rp.AddSubdomain("pr123", host, 80, nil)
+	rp.AddPortRoute("pr123", "mail", host, p, nil)
+
+	if diff := cmp.Diff([]string{"pr123"}, rp.Subdomains()); diff != "" {
+		t.Errorf("port routes must not be listed as subdomains %s", diff)
+	}
+	if !rp.Exists("mail-pr123") {
+		t.Fatal("port route mail-pr123 not found")
+	}
+	h := rp.FindHandler("mail-pr123", 80)
+	if h == nil {
+		t.Fatal("handler not found for mail-pr123:80")
+	}
+	req := httptest.NewRequest("GET", "http://mail-pr123.example.net/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Body.String() != "mail" {
+		t.Errorf("unexpected response: %s", w.Body.String())
+	}
+
+	rp.RemoveSubdomain("pr123")
+	if rp.Exists("mail-pr123") {
+		t.Error("port route must be removed with the subdomain")
+	}
+}
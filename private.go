@@ -0,0 +1,206 @@
+package mirageecs
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+const (
+	TagVisibility = "MirageVisibility"
+
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+// Private configures internal-only environments launched with visibility=private.
+// Tasks of private environments run in private subnets without public IP addresses,
+// their records are created only in a private hosted zone, and the proxy accepts requests only from allowed CIDRs.
+type Private struct {
+	// Subnets are private subnets to run tasks.
+	Subnets []string `yaml:"subnets"`
+	// SecurityGroups are security groups of tasks. Default is the security groups of ecs.network_configuration.
+	SecurityGroups []string `yaml:"security_groups"`
+	// Records configures records of environments in the private hosted zone.
+	Records *Records `yaml:"records"`
+	// AllowedCIDRs are CIDRs of clients allowed to access private environments. e.g. CIDRs of VPN.
+	AllowedCIDRs []string `yaml:"allowed_cidrs"`
+	// TrustedProxies are CIDRs of proxies in front of mirage-ecs (e.g. load balancers).
+	// X-Forwarded-For headers added by them are used to determine client addresses.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	allowed []netip.Prefix
+	trusted []netip.Prefix
+}
+
+func (p *Private) validate() error {
+	if p == nil {
+		return nil
+	}
+	if len(p.Subnets) == 0 {
+		return fmt.Errorf("private.subnets is required")
+	}
+	if len(p.AllowedCIDRs) == 0 {
+		return fmt.Errorf("private.allowed_cidrs is required")
+	}
+	if err := p.Records.validate(); err != nil {
+		return fmt.Errorf("private: %w", err)
+	}
+	var err error
+	if p.allowed, err = parsePrefixes(p.AllowedCIDRs); err != nil {
+		return fmt.Errorf("invalid private.allowed_cidrs: %w", err)
+	}
+	if p.trusted, err = parsePrefixes(p.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid private.trusted_proxies: %w", err)
+	}
+	return nil
+}
+
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, c := range cidrs {
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateVisibility validates the visibility specified at launch.
+func validateVisibility(v string) error {
+	switch v {
+	case "", VisibilityPublic, VisibilityPrivate:
+		return nil
+	}
+	return fmt.Errorf("invalid visibility: %s", v)
+}
+
+// private reports whether the environment is internal-only.
+func (o *LaunchOption) private() bool {
+	return o != nil && o.Visibility == VisibilityPrivate
+}
+
+// validateFor validates the launch option of a private environment against the network configuration of tasks.
+func (p *Private) validateFor(opt *LaunchOption, nc *types.NetworkConfiguration) error {
+	if !opt.private() {
+		return nil
+	}
+	if p == nil {
+		return fmt.Errorf("visibility=private is not allowed. set network.private")
+	}
+	if nc == nil || nc.AwsvpcConfiguration == nil {
+		return fmt.Errorf("visibility=private requires ecs.network_configuration of the awsvpc network mode")
+	}
+	return nil
+}
+
+// networkConfigurationFor returns the network configuration of tasks launched with the option.
+// Private environments run in private subnets without public IP addresses.
+func (p *Private) networkConfigurationFor(nc *types.NetworkConfiguration, opt *LaunchOption) *types.NetworkConfiguration {
+	if p == nil || !opt.private() || nc == nil || nc.AwsvpcConfiguration == nil {
+		return nc
+	}
+	vpc := *nc.AwsvpcConfiguration
+	vpc.Subnets = p.Subnets
+	vpc.AssignPublicIp = types.AssignPublicIpDisabled
+	if len(p.SecurityGroups) > 0 {
+		vpc.SecurityGroups = p.SecurityGroups
+	}
+	return &types.NetworkConfiguration{AwsvpcConfiguration: &vpc}
+}
+
+// clientAddr returns the address of the client of the request.
+func (p *Private) clientAddr(req *http.Request) (netip.Addr, error) {
+	return clientAddr(req, p.trusted)
+}
+
+// clientAddr returns the address of the client of the request.
+// X-Forwarded-For is read from right to left while the peer is a trusted proxy, because its left entries are controlled by clients.
+func clientAddr(req *http.Request, trusted []netip.Prefix) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid remote address %s: %w", req.RemoteAddr, err)
+	}
+	addr = addr.Unmap()
+	var forwarded []string
+	for _, v := range req.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(v, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0 && containsAddr(trusted, addr); i-- {
+		a, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("invalid X-Forwarded-For %s: %w", forwarded[i], err)
+		}
+		addr = a.Unmap()
+	}
+	return addr, nil
+}
+
+// remoteIP returns the address of the client of the request for logs. The remote address is returned for invalid X-Forwarded-For.
+func remoteIP(req *http.Request, trusted []netip.Prefix) string {
+	if addr, err := clientAddr(req, trusted); err == nil {
+		return addr.String()
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// trustedProxies returns CIDRs of trusted proxies in front of mirage-ecs by network.private.trusted_proxies.
+func (n *Network) trustedProxies() []netip.Prefix {
+	if n.Private == nil {
+		return nil
+	}
+	return n.Private.trusted
+}
+
+// allows reports whether the request comes from allowed CIDRs. Requests are denied if network.private is not configured.
+func (p *Private) allows(req *http.Request) bool {
+	if p == nil {
+		return false
+	}
+	addr, err := p.clientAddr(req)
+	if err != nil {
+		slog.Warn(err.Error())
+		return false
+	}
+	return p.allowsAddr(addr)
+}
+
+// allowsAddr reports whether the client address is in allowed CIDRs.
+func (p *Private) allowsAddr(addr netip.Addr) bool {
+	return p != nil && containsAddr(p.allowed, addr)
+}
+
+// handler restricts accesses to the private environment by allowed CIDRs.
+func (p *Private) handler(subdomain string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !p.allows(req) {
+			slog.WarnContext(req.Context(), f("subdomain %s is private. access from %s is denied", subdomain, req.RemoteAddr))
+			httpError(w, req, "Forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
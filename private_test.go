@@ -0,0 +1,187 @@
+package mirageecs_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/google/go-cmp/cmp"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func newTestPrivate(t *testing.T) *mirageecs.Private {
+	t.Helper()
+	p := &mirageecs.Private{
+		Subnets:        []string{"subnet-private"},
+		AllowedCIDRs:   []string{"10.8.0.0/16", "2001:db8::/32"},
+		TrustedProxies: []string{"10.0.0.0/24"},
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPrivateValidate(t *testing.T) {
+	cases := []struct {
+		name string
+		p    *mirageecs.Private
+		ok   bool
+	}{
+		{"nil", nil, true},
+		{"valid", &mirageecs.Private{Subnets: []string{"subnet-1"}, AllowedCIDRs: []string{"10.8.0.0/16"}}, true},
+		{"no subnets", &mirageecs.Private{AllowedCIDRs: []string{"10.8.0.0/16"}}, false},
+		{"no allowed cidrs", &mirageecs.Private{Subnets: []string{"subnet-1"}}, false},
+		{"invalid cidr", &mirageecs.Private{Subnets: []string{"subnet-1"}, AllowedCIDRs: []string{"10.8.0.0"}}, false},
+		{"invalid trusted proxy", &mirageecs.Private{Subnets: []string{"subnet-1"}, AllowedCIDRs: []string{"10.8.0.0/16"}, TrustedProxies: []string{"lb"}}, false},
+		{"invalid records", &mirageecs.Private{Subnets: []string{"subnet-1"}, AllowedCIDRs: []string{"10.8.0.0/16"}, Records: &mirageecs.Records{}}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.p.Validate(); (err == nil) != tc.ok {
+				t.Errorf("unexpected result: %v", err)
+			}
+		})
+	}
+}
+
+func TestPrivateValidateFor(t *testing.T) {
+	private := &mirageecs.LaunchOption{Visibility: mirageecs.VisibilityPrivate}
+	awsvpc := &types.NetworkConfiguration{AwsvpcConfiguration: &types.AwsVpcConfiguration{Subnets: []string{"subnet-public"}}}
+	var none *mirageecs.Private
+	if err := none.ValidateFor(&mirageecs.LaunchOption{}, nil); err != nil {
+		t.Errorf("unexpected error for public environments: %v", err)
+	}
+	if err := none.ValidateFor(private, awsvpc); err == nil {
+		t.Error("expected error without network.private")
+	}
+	p := newTestPrivate(t)
+	if err := p.ValidateFor(private, nil); err == nil {
+		t.Error("expected error without awsvpc network configuration")
+	}
+	if err := p.ValidateFor(private, awsvpc); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPrivateNetworkConfigurationFor(t *testing.T) {
+	nc := &types.NetworkConfiguration{AwsvpcConfiguration: &types.AwsVpcConfiguration{
+		Subnets:        []string{"subnet-public"},
+		SecurityGroups: []string{"sg-1"},
+		AssignPublicIp: types.AssignPublicIpEnabled,
+	}}
+	p := newTestPrivate(t)
+	if got := p.NetworkConfigurationFor(nc, &mirageecs.LaunchOption{}); got != nc {
+		t.Error("network configuration of public environments must not be modified")
+	}
+	got := p.NetworkConfigurationFor(nc, &mirageecs.LaunchOption{Visibility: mirageecs.VisibilityPrivate})
+	want := &types.AwsVpcConfiguration{
+		Subnets:        []string{"subnet-private"},
+		SecurityGroups: []string{"sg-1"},
+		AssignPublicIp: types.AssignPublicIpDisabled,
+	}
+	if diff := cmp.Diff(want, got.AwsvpcConfiguration, cmp.AllowUnexported(types.AwsVpcConfiguration{})); diff != "" {
+		t.Errorf("unexpected network configuration (-want +got):\n%s", diff)
+	}
+	if nc.AwsvpcConfiguration.Subnets[0] != "subnet-public" {
+		t.Error("the original network configuration must not be modified")
+	}
+}
+
+func TestPrivateAllows(t *testing.T) {
+	p := newTestPrivate(t)
+	cases := []struct {
+		name   string
+		remote string
+		xff    string
+		ok     bool
+	}{
+		{"allowed client", "10.8.1.2:12345", "", true},
+		{"allowed ipv6 client", "[2001:db8::1]:12345", "", true},
+		{"denied client", "192.0.2.1:12345", "", false},
+		{"untrusted peer with forged header", "192.0.2.1:12345", "10.8.1.2", false},
+		{"trusted proxy", "10.0.0.10:12345", "10.8.1.2", true},
+		{"trusted proxy with denied client", "10.0.0.10:12345", "192.0.2.1", false},
+		{"forged header via trusted proxy", "10.0.0.10:12345", "10.8.1.2, 192.0.2.1", false},
+		{"trusted proxy without header", "10.0.0.10:12345", "", false},
+		{"invalid header", "10.0.0.10:12345", "unknown", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://pr1.dev.example.net/", nil)
+			req.RemoteAddr = tc.remote
+			if tc.xff != "" {
+				req.Header.Set("X-Forwarded-For", tc.xff)
+			}
+			if ok := p.Allows(req); ok != tc.ok {
+				t.Errorf("got %v, want %v", ok, tc.ok)
+			}
+		})
+	}
+
+	var none *mirageecs.Private
+	req := httptest.NewRequest(http.MethodGet, "http://pr1.dev.example.net/", nil)
+	req.RemoteAddr = "10.8.1.2:12345"
+	if none.Allows(req) {
+		t.Error("requests to private environments must be denied without network.private")
+	}
+}
+
+func TestPrivateHandler(t *testing.T) {
+	p := newTestPrivate(t)
+	h := p.Handler("pr1", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for remote, status := range map[string]int{"10.8.1.2:12345": http.StatusOK, "192.0.2.1:12345": http.StatusForbidden} {
+		req := httptest.NewRequest(http.MethodGet, "http://pr1.dev.example.net/", nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != status {
+			t.Errorf("%s: got status %d, want %d", remote, rec.Code, status)
+		}
+	}
+}
+
+func TestLaunchRequestVisibility(t *testing.T) {
+	opt, err := (&mirageecs.APILaunchRequest{Visibility: "private"}).LaunchOption()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.Visibility != mirageecs.VisibilityPrivate {
+		t.Errorf("unexpected visibility: %s", opt.Visibility)
+	}
+	if _, err := (&mirageecs.APILaunchRequest{Visibility: "internal"}).LaunchOption(); err == nil {
+		t.Error("expected error for invalid visibility")
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	cases := []struct {
+		name    string
+		remote  string
+		xff     string
+		trusted []string
+		want    string
+	}{
+		{"direct client", "192.0.2.1:12345", "", nil, "192.0.2.1"},
+		{"forged header without trusted proxies", "192.0.2.1:12345", "10.8.1.2, 192.0.2.1", nil, "192.0.2.1"},
+		{"trusted proxy", "10.0.0.10:12345", "10.8.1.2", []string{"10.0.0.0/24"}, "10.8.1.2"},
+		{"forged header via trusted proxy", "10.0.0.10:12345", "198.51.100.1, 192.0.2.1", []string{"10.0.0.0/24"}, "192.0.2.1"},
+		{"invalid header", "10.0.0.10:12345", "unknown", []string{"10.0.0.0/24"}, "10.0.0.10"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://pr1.dev.example.net/", nil)
+			req.RemoteAddr = tc.remote
+			if tc.xff != "" {
+				req.Header.Set("X-Forwarded-For", tc.xff)
+			}
+			if got := mirageecs.RemoteIP(req, tc.trusted...); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+const (
+	TagProtected = "MirageProtected"
+
+	// DefaultTaskProtectionExpiresIn is the maximum period of ECS task scale-in protection.
+	DefaultTaskProtectionExpiresIn = 48 * time.Hour
+)
+
+func validateTaskProtectionExpiresIn(d time.Duration) error {
+	if d < time.Minute || d > DefaultTaskProtectionExpiresIn {
+		return fmt.Errorf("task_protection_expires_in must be between 1m and %s: %s", DefaultTaskProtectionExpiresIn, d)
+	}
+	return nil
+}
+
+// Protect marks or unmarks environments of the subdomain as protected.
+// Protected environments are skipped by purge, and tasks of services are protected from scale-in by ECS task protection.
+func (e *ECS) Protect(ctx context.Context, subdomain string, protected bool) error {
+	infos, err := e.find(ctx, subdomain)
+	if err != nil {
+		return err
+	}
+	if len(infos) == 0 {
+		return fmt.Errorf("subdomain %s is not found", subdomain)
+	}
+	var arns, serviceTasks []string
+	for _, info := range infos {
+		arns = append(arns, info.ID)
+		if info.Service != "" {
+			serviceTasks = append(serviceTasks, info.ID)
+		}
+	}
+	if e.cfg.ECS.ServiceMode {
+		// tags of services are propagated to tasks replaced by ECS
+		services, err := e.findServices(ctx, subdomain)
+		if err != nil {
+			return err
+		}
+		for _, s := range services {
+			arns = append(arns, aws.ToString(s.ServiceArn))
+		}
+	}
+	for _, arn := range arns {
+		if err := e.tagProtected(ctx, arn, protected); err != nil {
+			return err
+		}
+	}
+	if len(serviceTasks) > 0 {
+		if err := e.updateTaskProtection(ctx, serviceTasks, protected); err != nil {
+			return err
+		}
+	}
+	slog.Info(f("subdomain %s protected: %t", subdomain, protected))
+	return nil
+}
+
+func (e *ECS) tagProtected(ctx context.Context, arn string, protected bool) error {
+	if protected {
+		_, err := e.svc.TagResource(ctx, &ecs.TagResourceInput{
+			ResourceArn: aws.String(arn),
+			Tags:        []types.Tag{{Key: aws.String(TagProtected), Value: aws.String("true")}},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to tag %s: %w", arn, err)
+		}
+		return nil
+	}
+	_, err := e.svc.UntagResource(ctx, &ecs.UntagResourceInput{
+		ResourceArn: aws.String(arn),
+		TagKeys:     []string{TagProtected},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to untag %s: %w", arn, err)
+	}
+	return nil
+}
+
+// updateTaskProtection enables or disables ECS task scale-in protection of tasks started by services.
+func (e *ECS) updateTaskProtection(ctx context.Context, taskArns []string, protected bool) error {
+	in := &ecs.UpdateTaskProtectionInput{
+		Cluster:           aws.String(e.cfg.ECS.Cluster),
+		Tasks:             taskArns,
+		ProtectionEnabled: protected,
+	}
+	if protected {
+		in.ExpiresInMinutes = aws.Int32(int32(e.cfg.ECS.TaskProtectionExpiresIn.Minutes()))
+	}
+	out, err := e.svc.UpdateTaskProtection(ctx, in)
+	if err != nil {
+		return fmt.Errorf("failed to update task protection: %w", err)
+	}
+	if len(out.Failures) > 0 {
+		reasons := make([]string, 0, len(out.Failures))
+		for _, f := range out.Failures {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", shortenArn(aws.ToString(f.Arn)), aws.ToString(f.Reason)))
+		}
+		return fmt.Errorf("failed to update task protection: %s", strings.Join(reasons, ", "))
+	}
+	return nil
+}
+
+// refreshTaskProtection renews ECS task protection of protected service tasks before it expires.
+// Tasks replaced by services are also protected because they inherit the tag from the service.
+func (app *Mirage) refreshTaskProtection(ctx context.Context, running []*Information) {
+	expiresIn := app.Config.ECS.TaskProtectionExpiresIn
+	alive := make(map[string]bool, len(running))
+	for _, info := range running {
+		alive[info.ID] = true
+		if !info.Protected || info.Service == "" {
+			continue
+		}
+		if t, ok := app.protectedAt[info.ID]; ok && time.Since(t) < expiresIn/2 {
+			continue
+		}
+		if err := app.runner.Protect(ctx, info.SubDomain, true); err != nil {
+			slog.Warn(f("failed to refresh task protection of %s: %s", info.SubDomain, err))
+			continue
+		}
+		app.protectedAt[info.ID] = time.Now()
+	}
+	for id := range app.protectedAt {
+		if !alive[id] {
+			delete(app.protectedAt, id)
+		}
+	}
+}
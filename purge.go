@@ -0,0 +1,173 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// Purge configures defaults of /api/purge, and purges environments periodically.
+type Purge struct {
+	// MinimumDuration is the minimum duration of purges. Default is 5m.
+	MinimumDuration time.Duration `yaml:"minimum_duration"`
+	// Duration is the duration of purges which don't specify the duration.
+	Duration time.Duration `yaml:"duration"`
+	// Excludes are subdomains never purged, in addition to excludes of purges.
+	Excludes []string `yaml:"excludes"`
+	// ExcludeTags are tags (Key:Value) of environments never purged, in addition to exclude_tags of purges.
+	ExcludeTags []string `yaml:"exclude_tags"`
+	// Interval purges environments by duration periodically. Zero disables periodic purges.
+	Interval time.Duration `yaml:"interval"`
+
+	excludes    *subdomainMatcher
+	excludeTags map[string][]string
+}
+
+func (p *Purge) validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.MinimumDuration == 0 {
+		p.MinimumDuration = PurgeMinimumDuration
+	}
+	if p.MinimumDuration < time.Minute {
+		return fmt.Errorf("purge.minimum_duration must be at least 1m: %s", p.MinimumDuration)
+	}
+	if p.Duration != 0 && p.Duration < p.MinimumDuration {
+		return fmt.Errorf("purge.duration must be at least %s: %s", p.MinimumDuration, p.Duration)
+	}
+	var err error
+	if p.excludes, err = newSubdomainMatcher(p.Excludes); err != nil {
+		return fmt.Errorf("purge.excludes: %w", err)
+	}
+	if p.excludeTags, err = parseIncludeTags(p.ExcludeTags); err != nil {
+		return fmt.Errorf("purge.exclude_tags: %w", err)
+	}
+	if p.Interval != 0 {
+		if p.Interval < time.Minute {
+			return fmt.Errorf("purge.interval must be at least 1m: %s", p.Interval)
+		}
+		if p.Duration == 0 {
+			return fmt.Errorf("purge.interval requires purge.duration")
+		}
+	}
+	return nil
+}
+
+func (p *Purge) minimumDuration() time.Duration {
+	if p == nil {
+		return PurgeMinimumDuration
+	}
+	return p.MinimumDuration
+}
+
+func (p *Purge) defaultDuration() time.Duration {
+	if p == nil {
+		return 0
+	}
+	return p.Duration
+}
+
+// excluded reports whether the environment is excluded by the config.
+func (p *Purge) excluded(info *Information) bool {
+	if p == nil {
+		return false
+	}
+	return p.excludes.match(info.SubDomain) || hasAnyTag(info.Tags, p.excludeTags)
+}
+
+// RunPurger purges environments by the defaults of purge periodically.
+func (m *Mirage) RunPurger(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	tk := time.NewTicker(m.Config.Purge.Interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			slog.Debug("RunPurger() is done")
+			return
+		}
+		if _, err := m.WebApi.purgeBy(ctx, &APIPurgeRequest{}); err != nil {
+			slog.Warn(f("periodic purge failed: %s", err))
+		}
+	}
+}
+
+// subdomainMatcher matches subdomains with names, glob patterns or regexps enclosed in slashes.
+// e.g. "main", "demo-*", "/pr-\d+/"
+type subdomainMatcher struct {
+	globs   []string
+	regexps []*regexp.Regexp
+}
+
+func newSubdomainMatcher(patterns []string) (*subdomainMatcher, error) {
+	m := &subdomainMatcher{}
+	for _, p := range patterns {
+		if len(p) >= 2 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+			// regexps match the whole of subdomains
+			re, err := regexp.Compile("^(?:" + p[1:len(p)-1] + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid regexp %s: %w", p, err)
+			}
+			m.regexps = append(m.regexps, re)
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", p, err)
+		}
+		m.globs = append(m.globs, p)
+	}
+	return m, nil
+}
+
+func (m *subdomainMatcher) empty() bool {
+	return len(m.globs) == 0 && len(m.regexps) == 0
+}
+
+func (m *subdomainMatcher) match(subdomain string) bool {
+	for _, g := range m.globs {
+		if ok, _ := path.Match(g, subdomain); ok {
+			return true
+		}
+	}
+	for _, re := range m.regexps {
+		if re.MatchString(subdomain) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIncludeTags parses include_tags of the format Key:Value. A key may have multiple values.
+func parseIncludeTags(includeTags []string) (map[string][]string, error) {
+	m := make(map[string][]string, len(includeTags))
+	for _, includeTag := range includeTags {
+		k, v, ok := strings.Cut(includeTag, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid include_tags format %s", includeTag)
+		}
+		m[k] = append(m[k], v)
+	}
+	return m, nil
+}
+
+// hasAnyTag reports whether the tags have any of the key-value pairs.
+func hasAnyTag(tags []types.Tag, pairs map[string][]string) bool {
+	for _, t := range tags {
+		for _, v := range pairs[aws.ToString(t.Key)] {
+			if v == aws.ToString(t.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package mirageecs
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+)
+
+// PurgeCandidate is an environment to be purged, for the dry-run of /api/purge and the purge preview page.
+type PurgeCandidate struct {
+	Subdomain string      `json:"subdomain"`
+	GitBranch string      `json:"branch"`
+	TaskDef   string      `json:"taskdef"`
+	Created   time.Time   `json:"created"`
+	Tags      []types.Tag `json:"tags"`
+	// Accesses is the access count in the duration. Candidates which have been accessed are not purged.
+	Accesses int64 `json:"accesses"`
+	// LastAccess is the time of the last access counted by the reverse proxy. It is nil if unknown.
+	LastAccess *time.Time `json:"last_access,omitempty"`
+}
+
+// Age returns the uptime of the candidate in minutes.
+func (c *PurgeCandidate) Age() time.Duration {
+	return time.Since(c.Created).Truncate(time.Minute)
+}
+
+// purgeCandidates returns candidates of the purge by the request without terminating them.
+func (api *WebApi) purgeCandidates(ctx context.Context, r *APIPurgeRequest) (int, []*PurgeCandidate, error) {
+	duration, infos, code, err := api.purgeTargets(ctx, r)
+	if err != nil {
+		return code, nil, err
+	}
+	candidates := make([]*PurgeCandidate, 0, len(infos))
+	for _, info := range infos {
+		sum, err := api.runner.GetAccessCount(ctx, info.SubDomain, duration)
+		if err != nil {
+			slog.Warn(f("access count failed: %s %s", info.SubDomain, err))
+			continue
+		}
+		candidates = append(candidates, &PurgeCandidate{
+			Subdomain:  info.SubDomain,
+			GitBranch:  info.GitBranch,
+			TaskDef:    info.TaskDef,
+			Created:    info.Created,
+			Tags:       info.Tags,
+			Accesses:   sum,
+			LastAccess: api.lastAccess(info.SubDomain, time.Now().Add(-duration)),
+		})
+	}
+	return http.StatusOK, candidates, nil
+}
+
+// lastAccess returns the time of the last access to the subdomain since the time, excluding health checks.
+func (api *WebApi) lastAccess(subdomain string, since time.Time) *time.Time {
+	if api.accessStats == nil {
+		return nil
+	}
+	var last *time.Time
+	for _, st := range api.accessStats(subdomain, since) {
+		if st.Count > st.HealthChecks && (last == nil || st.Time.After(*last)) {
+			t := st.Time
+			last = &t
+		}
+	}
+	return last
+}
+
+// purgeRequestFromForm returns the purge request by the form of the purge preview page.
+// Values of lists are separated by spaces.
+func purgeRequestFromForm(c echo.Context) *APIPurgeRequest {
+	return &APIPurgeRequest{
+		Duration:    json.Number(strings.TrimSpace(c.FormValue("duration"))),
+		Excludes:    strings.Fields(c.FormValue("excludes")),
+		ExcludeTags: strings.Fields(c.FormValue("exclude_tags")),
+		Only:        strings.Fields(c.FormValue("only")),
+		IncludeTags: strings.Fields(c.FormValue("include_tags")),
+	}
+}
+
+// PurgePreview shows candidates of the purge by the conditions of the form.
+func (api *WebApi) PurgePreview(c echo.Context) error {
+	r := purgeRequestFromForm(c)
+	value := map[string]interface{}{
+		"Form": c.QueryParams(),
+	}
+	// shows only the form at first, unless the default duration is configured
+	if c.QueryParams().Has("duration") || api.cfg.Purge.defaultDuration() > 0 {
+		code, candidates, err := api.purgeCandidates(c.Request().Context(), r)
+		if err != nil {
+			value["Error"] = translate(api.cfg.requestLocale(c), err.Error())
+			return c.Render(code, "purge.html", value)
+		}
+		value["Previewed"] = true
+		value["Candidates"] = candidates
+		value["Duration"] = r.Duration
+	}
+	return c.Render(http.StatusOK, "purge.html", value)
+}
+
+// PurgeSelected purges the selected candidates. Subdomains which are no longer candidates are not purged.
+func (api *WebApi) PurgeSelected(c echo.Context) error {
+	params, err := c.FormParams()
+	if err != nil {
+		return c.Render(http.StatusBadRequest, "purge.html", map[string]interface{}{
+			"Form":  url.Values{},
+			"Error": err.Error(),
+		})
+	}
+	value := map[string]interface{}{
+		"Form": params,
+	}
+	selected := lo.Uniq(params["subdomain"])
+	if len(selected) == 0 {
+		value["Error"] = translate(api.cfg.requestLocale(c), "parameter required: subdomain")
+		return c.Render(http.StatusBadRequest, "purge.html", value)
+	}
+	r := purgeRequestFromForm(c)
+	duration, infos, code, err := api.purgeTargets(c.Request().Context(), r)
+	if err != nil {
+		value["Error"] = translate(api.cfg.requestLocale(c), err.Error())
+		return c.Render(code, "purge.html", value)
+	}
+	subdomains := lo.FilterMap(infos, func(info *Information, _ int) (string, bool) {
+		return info.SubDomain, lo.Contains(selected, info.SubDomain)
+	})
+	slog.Info(f("purge %d selected subdomains (requested from %s): %v", len(subdomains), c.RealIP(), subdomains))
+	if len(subdomains) == 0 {
+		// the selected subdomains are no longer candidates
+		value["Previewed"] = true
+		return c.Render(http.StatusOK, "purge.html", value)
+	}
+	// running in background. Don't cancel by client context.
+	go api.purgeSubdomains(context.Background(), subdomains, duration)
+	value["Purged"] = subdomains
+	return c.Render(http.StatusOK, "purge.html", value)
+}
@@ -0,0 +1,116 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestPurgePreview(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Auth = &mirageecs.Auth{
+		Token: &mirageecs.AuthMethodToken{Header: "x-mirage-token", Token: "user"},
+		Admin: &mirageecs.AuthMethodToken{Header: "x-mirage-admin-token", Token: "admin"},
+	}
+	m := mirageecs.New(ctx, cfg)
+	if err := m.Runner().Launch(ctx, "foo", mirageecs.TaskParameter{"branch": "develop"}, &mirageecs.LaunchOption{}, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	do := func(method, path string, form url.Values, adminToken string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "http://"+cfg.Host.WebApi)
+		req.Header.Set("x-mirage-token", "user")
+		if adminToken != "" {
+			req.Header.Set("x-mirage-admin-token", adminToken)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(b)
+	}
+
+	if code, _ := do(http.MethodGet, "/purge", nil, ""); code != http.StatusForbidden {
+		t.Errorf("without the admin token: wanted 403, got %d", code)
+	}
+	if code, _ := do(http.MethodPost, "/purge", url.Values{"subdomain": {"foo"}, "duration": {"300"}}, ""); code != http.StatusForbidden {
+		t.Errorf("purge without the admin token: wanted 403, got %d", code)
+	}
+
+	// only the form without the duration
+	code, body := do(http.MethodGet, "/purge", nil, "admin")
+	if code != http.StatusOK || !strings.Contains(body, `name="duration"`) || strings.Contains(body, "No environments to purge.") {
+		t.Errorf("unexpected form: %d %s", code, body)
+	}
+	// foo is not a candidate because it has been launched just now
+	code, body = do(http.MethodGet, "/purge?duration=300&excludes=bar+baz", nil, "admin")
+	if code != http.StatusOK || !strings.Contains(body, "No environments to purge.") || !strings.Contains(body, `value="bar baz"`) {
+		t.Errorf("unexpected preview: %d %s", code, body)
+	}
+	code, body = do(http.MethodGet, "/purge?duration=60", nil, "admin")
+	if code != http.StatusBadRequest || !strings.Contains(body, "invalid duration 60 (at least 300)") {
+		t.Errorf("unexpected preview of an invalid duration: %d %s", code, body)
+	}
+
+	if code, _ := do(http.MethodPost, "/purge", url.Values{"duration": {"300"}}, "admin"); code != http.StatusBadRequest {
+		t.Errorf("purge without subdomains: wanted 400, got %d", code)
+	}
+	// foo is not purged because it is no longer a candidate
+	code, body = do(http.MethodPost, "/purge", url.Values{"subdomain": {"foo"}, "duration": {"300"}}, "admin")
+	if code != http.StatusOK || !strings.Contains(body, "No environments to purge.") {
+		t.Errorf("unexpected purge: %d %s", code, body)
+	}
+	infos, _ := m.Runner().List(ctx, "RUNNING")
+	if len(infos) != 1 {
+		t.Errorf("foo must not be purged: %#v", infos)
+	}
+}
+
+func TestPurgeDryRun(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	if err := m.Runner().Launch(ctx, "foo", mirageecs.TaskParameter{"branch": "develop"}, &mirageecs.LaunchOption{}, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	res, err := ts.Client().Post(ts.URL+"/api/purge", "application/json", strings.NewReader(`{"duration":300,"dry_run":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var r mirageecs.APIPurgeResponse
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || r.Result != "ok" || r.Candidates == nil || len(r.Candidates) != 0 {
+		t.Errorf("unexpected dry-run: %d %#v", res.StatusCode, r)
+	}
+}
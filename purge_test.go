@@ -0,0 +1,151 @@
+package mirageecs_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestSubdomainMatcher(t *testing.T) {
+	m, err := mirageecs.NewSubdomainMatcher([]string{"main", "demo-*", `/staging|pr-\d+/`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for subdomain, expected := range map[string]bool{
+		"main":      true,
+		"main2":     false,
+		"demo-foo":  true,
+		"demo":      false,
+		"staging":   true,
+		"staging-2": false,
+		"pr-123":    true,
+		"pr-abc":    false,
+		"xpr-123":   false,
+	} {
+		if got := m.Match(subdomain); got != expected {
+			t.Errorf("Match(%s) = %v, want %v", subdomain, got, expected)
+		}
+	}
+}
+
+func TestSubdomainMatcherInvalid(t *testing.T) {
+	for _, p := range []string{"/pr-(/", "demo-["} {
+		if _, err := mirageecs.NewSubdomainMatcher([]string{p}); err == nil {
+			t.Errorf("expected error for %s", p)
+		}
+	}
+}
+
+func TestIncludeTags(t *testing.T) {
+	include, err := mirageecs.ParseIncludeTags([]string{"purpose:preview", "purpose:pr", "team:payments"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag := func(k, v string) []types.Tag {
+		return []types.Tag{{Key: aws.String(k), Value: aws.String(v)}}
+	}
+	for _, c := range []struct {
+		tags     []types.Tag
+		expected bool
+	}{
+		{tag("purpose", "preview"), true},
+		{tag("purpose", "pr"), true},
+		{tag("team", "payments"), true},
+		{tag("purpose", "demo"), false},
+		{nil, false},
+	} {
+		if got := mirageecs.HasAnyTag(c.tags, include); got != c.expected {
+			t.Errorf("HasAnyTag(%v) = %v, want %v", c.tags, got, c.expected)
+		}
+	}
+	if _, err := mirageecs.ParseIncludeTags([]string{"purpose"}); err == nil {
+		t.Error("expected error for invalid format")
+	}
+}
+
+func TestPurgeValidate(t *testing.T) {
+	p := &mirageecs.Purge{}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if p.MinimumDuration != mirageecs.PurgeMinimumDuration {
+		t.Errorf("unexpected default minimum duration: %s", p.MinimumDuration)
+	}
+	for _, p := range []*mirageecs.Purge{
+		{MinimumDuration: 30 * time.Second},
+		{MinimumDuration: time.Hour, Duration: 30 * time.Minute},
+		{Excludes: []string{"/pr-(/"}},
+		{ExcludeTags: []string{"purpose"}},
+		{Interval: time.Hour},
+		{Interval: time.Second, Duration: time.Hour},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("expected error: %#v", p)
+		}
+	}
+}
+
+func TestPurgeExcluded(t *testing.T) {
+	p := &mirageecs.Purge{Excludes: []string{"main", "demo-*"}, ExcludeTags: []string{"purpose:demo", "purpose:qa"}}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tag := func(k, v string) []types.Tag {
+		return []types.Tag{{Key: aws.String(k), Value: aws.String(v)}}
+	}
+	for _, c := range []struct {
+		info     *mirageecs.Information
+		expected bool
+	}{
+		{&mirageecs.Information{SubDomain: "main"}, true},
+		{&mirageecs.Information{SubDomain: "demo-x"}, true},
+		{&mirageecs.Information{SubDomain: "pr-1", Tags: tag("purpose", "qa")}, true},
+		{&mirageecs.Information{SubDomain: "pr-1", Tags: tag("purpose", "preview")}, false},
+	} {
+		if got := p.Excluded(c.info); got != c.expected {
+			t.Errorf("Excluded(%s) = %v, want %v", c.info.SubDomain, got, c.expected)
+		}
+	}
+	var none *mirageecs.Purge
+	if none.Excluded(&mirageecs.Information{SubDomain: "main"}) {
+		t.Error("nil purge must not exclude")
+	}
+}
+
+func TestPurgeDefaults(t *testing.T) {
+	cases := []struct {
+		config string
+		body   string
+		status int
+	}{
+		{"", `{}`, http.StatusBadRequest},
+		{"", `{"duration":"120"}`, http.StatusBadRequest},
+		{"purge:\n  duration: 24h\n", `{}`, http.StatusOK},
+		{"purge:\n  minimum_duration: 1m\n", `{"duration":"120"}`, http.StatusOK},
+		{"purge:\n  minimum_duration: 1m\n", `{"duration":"30"}`, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		cfg, err := newConfigFromYAML(t, "htmldir: ./html\n"+c.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := mirageecs.New(context.Background(), cfg)
+		ts := httptest.NewServer(m.WebApi)
+		res, err := http.Post(ts.URL+"/api/purge", "application/json", strings.NewReader(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		ts.Close()
+		if res.StatusCode != c.status {
+			t.Errorf("%q %s: unexpected status %d", c.config, c.body, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	HookEventPurgeWarning = "purge_warning"
+
+	DefaultPurgeGracePeriod = time.Hour
+)
+
+// PurgeWarning warns owners of environments before purge, and purges them after the grace period unless they are accessed or protected.
+type PurgeWarning struct {
+	// GracePeriod is a period between the warning and the purge. Default is 1h.
+	GracePeriod time.Duration `yaml:"grace_period"`
+	// OwnerTag is a key of the tag (or a name of the parameter) of the owner of the environment.
+	OwnerTag string `yaml:"owner_tag"`
+	// Hooks are webhooks (e.g. Slack incoming webhooks) or SNS topics (e.g. with email subscriptions) to send warnings.
+	Hooks []*Hook `yaml:"hooks"`
+}
+
+func (w *PurgeWarning) validate() error {
+	if w == nil {
+		return nil
+	}
+	if w.GracePeriod == 0 {
+		w.GracePeriod = DefaultPurgeGracePeriod
+	}
+	if w.GracePeriod < time.Minute {
+		return fmt.Errorf("purge_warning.grace_period must be at least 1m: %s", w.GracePeriod)
+	}
+	if len(w.Hooks) == 0 {
+		return fmt.Errorf("purge_warning.hooks is required")
+	}
+	for _, hook := range w.Hooks {
+		if err := hook.validate(); err != nil {
+			return fmt.Errorf("purge_warning.hooks: %w", err)
+		}
+		if hook.Wait {
+			return fmt.Errorf("purge_warning.hooks: wait is supported only by pre_terminate hooks")
+		}
+	}
+	return nil
+}
+
+// purgeWarner keeps environments which are warned to be purged.
+type purgeWarner struct {
+	cfg    *Config
+	client *hookClient
+
+	mu     sync.Mutex
+	warned map[string]time.Time // subdomain -> time to purge
+}
+
+func newPurgeWarner(cfg *Config) *purgeWarner {
+	if cfg.PurgeWarning == nil {
+		return nil
+	}
+	return &purgeWarner{
+		cfg:    cfg,
+		client: newHookClient(cfg, cfg.PurgeWarning.Hooks),
+		warned: make(map[string]time.Time),
+	}
+}
+
+// warn sends the warning for the environment. It returns false when the environment has been warned already.
+func (w *purgeWarner) warn(ctx context.Context, info *Information) (time.Time, bool) {
+	c := w.cfg.PurgeWarning
+	w.mu.Lock()
+	if purgeAt, ok := w.warned[info.SubDomain]; ok {
+		w.mu.Unlock()
+		return purgeAt, false
+	}
+	purgeAt := time.Now().Add(c.GracePeriod).Truncate(time.Second)
+	w.warned[info.SubDomain] = purgeAt
+	w.mu.Unlock()
+
+	owner := ""
+	if c.OwnerTag != "" {
+		owner = getTag(info.Tags, c.OwnerTag)
+	}
+	host := info.SubDomain + w.cfg.Host.ReverseProxySuffix
+	text := fmt.Sprintf("%s will be purged in %s unless accessed or protected.", host, c.GracePeriod)
+	if owner != "" {
+		text = fmt.Sprintf("%s (owner: %s) will be purged in %s unless accessed or protected.", host, owner, c.GracePeriod)
+	}
+	p := &HookPayload{
+		Event:      HookEventPurgeWarning,
+		Subdomain:  info.SubDomain,
+		Host:       host,
+		Taskdefs:   []string{info.TaskDef},
+		Parameters: taskParameterFromTags(info.Tags, w.cfg.Parameter),
+		Time:       time.Now(),
+		Owner:      owner,
+		PurgeAt:    &purgeAt,
+		Text:       text,
+	}
+	for _, hook := range c.Hooks {
+		w.client.call(ctx, hook, p)
+	}
+	return purgeAt, true
+}
+
+func (w *purgeWarner) done(subdomain string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.warned, subdomain)
+}
+
+// warnPurge warns the environment to be purged, and purges it after the grace period unless it is accessed or protected.
+func (api *WebApi) warnPurge(ctx context.Context, subdomain string) {
+	infos, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		slog.Warn(f("list ecs failed: %s", err))
+		return
+	}
+	for _, info := range infos {
+		if info.SubDomain != subdomain {
+			continue
+		}
+		purgeAt, ok := api.purgeWarner.warn(ctx, info)
+		if !ok {
+			slog.Info(f("skip purge %s, it will be purged at %s", subdomain, purgeAt.Format(time.RFC3339)))
+			return
+		}
+		slog.Info(f("warned to purge %s at %s", subdomain, purgeAt.Format(time.RFC3339)))
+		go func() {
+			defer api.purgeWarner.done(subdomain)
+			time.Sleep(time.Until(purgeAt))
+			api.purgeAfterGracePeriod(context.Background(), subdomain)
+		}()
+		return
+	}
+}
+
+// purgeAfterGracePeriod re-checks the warned environment and purges it.
+func (api *WebApi) purgeAfterGracePeriod(ctx context.Context, subdomain string) {
+	infos, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		slog.Warn(f("list ecs failed: %s", err))
+		return
+	}
+	found := false
+	for _, info := range infos {
+		if info.SubDomain != subdomain {
+			continue
+		}
+		found = true
+		if info.Protected {
+			slog.Info(f("skip purge %s, it has been protected", subdomain))
+			return
+		}
+	}
+	if !found {
+		return
+	}
+	sum, err := api.runner.GetAccessCount(ctx, subdomain, api.cfg.PurgeWarning.GracePeriod)
+	if err != nil {
+		slog.Warn(f("access count failed: %s %s", subdomain, err))
+		return
+	}
+	if sum > 0 {
+		slog.Info(f("skip purge %s %d access in the grace period", subdomain, sum))
+		return
+	}
+	if err := api.runner.TerminateBySubdomain(withPurge(ctx), subdomain); err != nil {
+		slog.Warn(f("terminate failed %s %s", subdomain, err))
+		return
+	}
+	slog.Info(f("purged %s after the grace period", subdomain))
+}
@@ -0,0 +1,103 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestPurgeWarningValidate(t *testing.T) {
+	hooks := []*mirageecs.Hook{{URL: "https://hooks.example.com/purge"}}
+	cases := []struct {
+		name    string
+		warning *mirageecs.PurgeWarning
+		ok      bool
+	}{
+		{"nil", nil, true},
+		{"default grace period", &mirageecs.PurgeWarning{Hooks: hooks}, true},
+		{"grace period", &mirageecs.PurgeWarning{GracePeriod: 3 * time.Hour, Hooks: hooks}, true},
+		{"too short grace period", &mirageecs.PurgeWarning{GracePeriod: time.Second, Hooks: hooks}, false},
+		{"no hooks", &mirageecs.PurgeWarning{}, false},
+		{"wait", &mirageecs.PurgeWarning{Hooks: []*mirageecs.Hook{{URL: "https://hooks.example.com/purge", Wait: true}}}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.warning.Validate()
+			if c.ok && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if !c.ok && err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestPurgeWarning(t *testing.T) {
+	payloads := make(chan *mirageecs.HookPayload, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p mirageecs.HookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Error(err)
+		}
+		payloads <- &p
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.PurgeWarning = &mirageecs.PurgeWarning{
+		GracePeriod: time.Hour,
+		OwnerTag:    "branch",
+		Hooks:       []*mirageecs.Hook{{URL: srv.URL}},
+	}
+	if err := cfg.PurgeWarning.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	runner := m.Runner()
+	if err := runner.Launch(ctx, "foo", mirageecs.TaskParameter{"branch": "alice"}, nil, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Launch(ctx, "bar", mirageecs.TaskParameter{"branch": "bob"}, &mirageecs.LaunchOption{Protected: true}, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	m.WebApi.WarnPurge(ctx, "foo")
+	p := receivePayload(t, payloads)
+	if p.Event != mirageecs.HookEventPurgeWarning || p.Subdomain != "foo" || p.Owner != "alice" || p.PurgeAt == nil {
+		t.Errorf("unexpected payload %#v", p)
+	}
+	if p.Text != "foo.localtest.me (owner: alice) will be purged in 1h0m0s unless accessed or protected." {
+		t.Errorf("unexpected text %q", p.Text)
+	}
+
+	// warned only once in the grace period
+	m.WebApi.WarnPurge(ctx, "foo")
+	select {
+	case p := <-payloads:
+		t.Errorf("unexpected warning %#v", p)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// protected environments are not purged after the grace period
+	m.WebApi.PurgeAfterGracePeriod(ctx, "bar")
+	m.WebApi.PurgeAfterGracePeriod(ctx, "foo")
+	infos, err := runner.List(ctx, "RUNNING")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].SubDomain != "bar" {
+		t.Errorf("only bar must be running: %v", infos)
+	}
+}
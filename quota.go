@@ -0,0 +1,169 @@
+package mirageecs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+)
+
+// QuotaExceededError is returned when a launch exceeds limits of running environments.
+type QuotaExceededError struct {
+	msg string
+}
+
+func (e *QuotaExceededError) Error() string {
+	return e.msg
+}
+
+func isQuotaExceeded(err error) bool {
+	var qe *QuotaExceededError
+	return errors.As(err, &qe)
+}
+
+// Quota limits the number of running environments which have the tag.
+type Quota struct {
+	// Tag is a key of the tag (or a name of the parameter). e.g. owner
+	Tag string `yaml:"tag"`
+	// Value is a value of the tag. Empty limits environments for each value. e.g. each owner
+	Value string `yaml:"value"`
+	// MaxEnvironments is the maximum number of running environments.
+	MaxEnvironments int `yaml:"max_environments"`
+}
+
+func (q *Quota) validate() error {
+	if q.Tag == "" {
+		return fmt.Errorf("quotas[].tag is required")
+	}
+	if q.MaxEnvironments <= 0 {
+		return fmt.Errorf("quotas[].max_environments must be positive: %d", q.MaxEnvironments)
+	}
+	return nil
+}
+
+func (q *Quota) match(value string) bool {
+	if value == "" {
+		return false
+	}
+	return q.Value == "" || q.Value == value
+}
+
+// environments returns subdomains of running environments which have the tag value.
+func (q *Quota) environments(running []*Information, value string) map[string]struct{} {
+	envs := make(map[string]struct{})
+	for _, info := range running {
+		if getTag(info.Tags, q.Tag) == value {
+			envs[info.SubDomain] = struct{}{}
+		}
+	}
+	return envs
+}
+
+// QuotaUsage is a usage of max_running_tasks or a quota.
+type QuotaUsage struct {
+	// Tag and Value are empty for max_running_tasks.
+	Tag        string   `json:"tag,omitempty"`
+	Value      string   `json:"value,omitempty"`
+	Unit       string   `json:"unit"`
+	Used       int      `json:"used"`
+	Max        int      `json:"max"`
+	Subdomains []string `json:"subdomains,omitempty"`
+}
+
+// checkQuotas checks limits of running tasks before launching n tasks for the subdomain with the tags.
+// Running tasks of the subdomain are not counted, because they are replaced by the launch.
+func (c ECSCfg) checkQuotas(running []*Information, subdomain string, n int, tags []types.Tag) error {
+	if c.MaxRunningTasks > 0 {
+		count := 0
+		for _, info := range running {
+			if info.SubDomain != subdomain {
+				count++
+			}
+		}
+		if count+n > c.MaxRunningTasks {
+			return &QuotaExceededError{
+				msg: fmt.Sprintf("too many running tasks: %d tasks are running and launching %d tasks exceeds max_running_tasks %d", count, n, c.MaxRunningTasks),
+			}
+		}
+	}
+	for _, q := range c.Quotas {
+		value := getTag(tags, q.Tag)
+		if !q.match(value) {
+			continue
+		}
+		envs := q.environments(running, value)
+		delete(envs, subdomain)
+		if len(envs)+1 > q.MaxEnvironments {
+			return &QuotaExceededError{
+				msg: fmt.Sprintf("quota exceeded: %d environments of %s=%s are running (max_environments %d): %v",
+					len(envs), q.Tag, value, q.MaxEnvironments, lo.Keys(envs)),
+			}
+		}
+	}
+	return nil
+}
+
+// quotaUsages returns usages of max_running_tasks and quotas by running tasks.
+func (c ECSCfg) quotaUsages(running []*Information) []*QuotaUsage {
+	var usages []*QuotaUsage
+	if c.MaxRunningTasks > 0 {
+		usages = append(usages, &QuotaUsage{
+			Unit: "tasks",
+			Used: len(running),
+			Max:  c.MaxRunningTasks,
+		})
+	}
+	for _, q := range c.Quotas {
+		values := make(map[string]struct{})
+		if q.Value != "" {
+			values[q.Value] = struct{}{}
+		} else {
+			for _, info := range running {
+				if v := getTag(info.Tags, q.Tag); v != "" {
+					values[v] = struct{}{}
+				}
+			}
+		}
+		vs := lo.Keys(values)
+		sort.Strings(vs)
+		for _, v := range vs {
+			subdomains := lo.Keys(q.environments(running, v))
+			sort.Strings(subdomains)
+			usages = append(usages, &QuotaUsage{
+				Tag:        q.Tag,
+				Value:      v,
+				Unit:       "environments",
+				Used:       len(subdomains),
+				Max:        q.MaxEnvironments,
+				Subdomains: subdomains,
+			})
+		}
+	}
+	return usages
+}
+
+// checkLaunchQuotas lists running tasks and checks limits of running tasks before launching n tasks for the subdomain.
+func checkLaunchQuotas(ctx context.Context, cfg *Config, runner TaskRunner, subdomain string, n int, tags []types.Tag) error {
+	if cfg.ECS.MaxRunningTasks <= 0 && len(cfg.ECS.Quotas) == 0 {
+		return nil
+	}
+	running, err := runner.List(ctx, statusRunning)
+	if err != nil {
+		return err
+	}
+	return cfg.ECS.checkQuotas(running, subdomain, n, tags)
+}
+
+// ApiQuotas returns usages of max_running_tasks and quotas.
+func (api *WebApi) ApiQuotas(c echo.Context) error {
+	running, err := api.runner.List(c.Request().Context(), statusRunning)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(http.StatusOK, APIQuotasResponse{Result: api.cfg.ECS.quotaUsages(running)})
+}
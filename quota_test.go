@@ -0,0 +1,152 @@
+package mirageecs_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/labstack/echo/v4"
+)
+
+func TestCheckQuotasMaxRunningTasks(t *testing.T) {
+	running := []*mirageecs.Information{
+		{SubDomain: "foo"},
+		{SubDomain: "foo"},
+		{SubDomain: "bar"},
+	}
+	cases := []struct {
+		name      string
+		max       int
+		subdomain string
+		n         int
+		ok        bool
+	}{
+		{"unlimited", 0, "baz", 10, true},
+		{"within the limit", 4, "baz", 1, true},
+		{"exceeds the limit", 4, "baz", 2, false},
+		{"replaces running tasks", 3, "foo", 2, true},
+		{"replaces running tasks and exceeds the limit", 3, "foo", 3, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := mirageecs.ECSCfg{MaxRunningTasks: c.max}
+			err := cfg.CheckQuotas(running, c.subdomain, c.n, nil)
+			if c.ok && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if !c.ok {
+				var qe *mirageecs.QuotaExceededError
+				if !errors.As(err, &qe) {
+					t.Errorf("expected QuotaExceededError, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckQuotasByTags(t *testing.T) {
+	cfg := mirageecs.ECSCfg{
+		Quotas: []*mirageecs.Quota{
+			{Tag: "owner", MaxEnvironments: 2},
+			{Tag: "team", Value: "payments", MaxEnvironments: 3},
+		},
+	}
+	tags := func(owner, team string) []types.Tag {
+		return []types.Tag{
+			{Key: aws.String("owner"), Value: aws.String(owner)},
+			{Key: aws.String("team"), Value: aws.String(team)},
+		}
+	}
+	running := []*mirageecs.Information{
+		{SubDomain: "alice-1", Tags: tags("alice", "payments")},
+		{SubDomain: "alice-1", Tags: tags("alice", "payments")},
+		{SubDomain: "alice-2", Tags: tags("alice", "payments")},
+		{SubDomain: "bob-1", Tags: tags("bob", "search")},
+	}
+	cases := []struct {
+		name      string
+		subdomain string
+		tags      []types.Tag
+		ok        bool
+	}{
+		{"owner within the quota", "bob-2", tags("bob", "search"), true},
+		{"owner exceeds the quota", "alice-3", tags("alice", "search"), false},
+		{"relaunch of the owner", "alice-2", tags("alice", "payments"), true},
+		{"team within the quota", "carol-1", tags("carol", "payments"), true},
+		{"untagged", "dave-1", nil, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := cfg.CheckQuotas(running, c.subdomain, 1, c.tags)
+			if c.ok && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if !c.ok {
+				var qe *mirageecs.QuotaExceededError
+				if !errors.As(err, &qe) {
+					t.Errorf("expected QuotaExceededError, got %v", err)
+				}
+			}
+		})
+	}
+
+	// the team quota is exceeded after carol launches
+	running = append(running, &mirageecs.Information{SubDomain: "carol-1", Tags: tags("carol", "payments")})
+	if err := cfg.CheckQuotas(running, "carol-2", 1, tags("carol", "payments")); err == nil {
+		t.Error("expected QuotaExceededError of the team")
+	}
+
+	usages := cfg.QuotaUsages(running)
+	expected := []*mirageecs.QuotaUsage{
+		{Tag: "owner", Value: "alice", Unit: "environments", Used: 2, Max: 2, Subdomains: []string{"alice-1", "alice-2"}},
+		{Tag: "owner", Value: "bob", Unit: "environments", Used: 1, Max: 2, Subdomains: []string{"bob-1"}},
+		{Tag: "owner", Value: "carol", Unit: "environments", Used: 1, Max: 2, Subdomains: []string{"carol-1"}},
+		{Tag: "team", Value: "payments", Unit: "environments", Used: 3, Max: 3, Subdomains: []string{"alice-1", "alice-2", "carol-1"}},
+	}
+	if diff := cmp.Diff(expected, usages); diff != "" {
+		t.Errorf("unexpected usages (-want +got):\n%s", diff)
+	}
+}
+
+func TestLaunchMaxRunningTasks(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ECS.MaxRunningTasks = 1
+	m := mirageecs.New(ctx, cfg)
+	if err := m.Runner().Launch(ctx, "foo", mirageecs.TaskParameter{"branch": "develop"}, nil, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	for _, c := range []struct {
+		subdomain string
+		status    int
+	}{
+		{"bar", http.StatusTooManyRequests},
+		{"foo", http.StatusOK},
+	} {
+		form := url.Values{"subdomain": {c.subdomain}, "branch": {"develop"}, "taskdef": {"app:1"}}
+		req := httptest.NewRequest(http.MethodPost, "/api/launch", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		if err := m.WebApi.ApiLaunch(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != c.status {
+			t.Errorf("launch %s: wanted status %d, got %d: %s", c.subdomain, c.status, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package mirageecs
+
+import (
+	"context"
+	"log/slog"
+	"net"
+)
+
+// reconcileRoutes rebuilds the route table from running tasks before serving, not to wait for the first sync.
+// Routes which have no ready tasks (e.g. restored from the persistence for environments which have gone) are orphaned, and removed.
+func (m *Mirage) reconcileRoutes(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, APICallTimeout)
+	defer cancel()
+	running, err := m.runner.List(ctx, statusRunning)
+	if err != nil {
+		// keep restored routes until the first sync
+		slog.Warn(f("failed to list running tasks to reconcile routes: %s", err))
+		return
+	}
+	rp := m.ReverseProxy
+	ips := make(map[string]map[string]bool)
+	for _, info := range running {
+		if !info.Ready || info.IPAddress == "" {
+			continue
+		}
+		if ips[info.SubDomain] == nil {
+			ips[info.SubDomain] = make(map[string]bool)
+		}
+		ips[info.SubDomain][info.IPAddress] = true
+		m.addRoutes(info)
+	}
+	orphaned := 0
+	for _, subdomain := range rp.Subdomains() {
+		if ips[subdomain] == nil {
+			slog.Warn(f("route of subdomain %s is orphaned. no tasks are running", subdomain))
+			rp.RemoveSubdomain(subdomain)
+			orphaned++
+			continue
+		}
+		rp.removeStaleTargets(subdomain, ips[subdomain])
+	}
+	slog.Info(f("reconciled routes of %d subdomains with running tasks, removed %d orphaned routes", len(ips), orphaned))
+}
+
+// addRoutes adds routes of the subdomain, port routes and aliases to the ready task.
+func (m *Mirage) addRoutes(info *Information) {
+	rp := m.ReverseProxy
+	for _, port := range info.PortMap {
+		rp.AddSubdomain(info.SubDomain, info.IPAddress, port, info.Option)
+	}
+	for name, port := range m.Config.Network.portRoutesFor(info) {
+		rp.AddPortRoute(info.SubDomain, name, info.IPAddress, port, info.Option)
+	}
+	rp.SetAliases(info.SubDomain, info.Option.aliases())
+}
+
+// removeStaleTargets removes targets of the subdomain and its port routes whose IP addresses are not in ips.
+func (r *ReverseProxy) removeStaleTargets(subdomain string, ips map[string]bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	routes := []string{subdomain}
+	for route, parent := range r.portRoutes {
+		if parent == subdomain {
+			routes = append(routes, route)
+		}
+	}
+	for _, route := range routes {
+		for port, handlers := range r.domainMap[route] {
+			for addr := range handlers {
+				if host, _, _ := net.SplitHostPort(addr); !ips[host] {
+					slog.Info(f("removing stale target: %s:%d -> %s", route, port, addr))
+					delete(handlers, addr)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package mirageecs_test
+
+import (
+	"context"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestReconcileRoutes(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cfg.Cleanup()
+	m := mirageecs.New(ctx, cfg)
+	if err := m.Runner().Launch(ctx, "foo", mirageecs.TaskParameter{"branch": "develop"}, nil, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+	rp := m.ReverseProxy
+	// a route restored for the environment which has gone
+	rp.AddSubdomain("gone", "10.0.0.1", 80, nil)
+	if rp.Exists("foo") {
+		t.Fatal("route of foo should not exist before reconciliation")
+	}
+
+	m.ReconcileRoutes(ctx)
+	if !rp.Exists("foo") {
+		t.Error("route of foo is not rebuilt")
+	}
+	if rp.Exists("gone") {
+		t.Error("orphaned route of gone is not removed")
+	}
+}
@@ -0,0 +1,220 @@
+package mirageecs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53Types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/samber/lo"
+)
+
+const DefaultRecordTTL = 60
+
+// Records configures DNS records of environments managed by mirage-ecs.
+// A record of <subdomain><reverse_proxy_suffix> is created when the environment becomes available,
+// and deleted when the environment is gone. So a wildcard record is not required.
+type Records struct {
+	// HostedZoneID is an ID of the Route 53 hosted zone which contains records of environments.
+	HostedZoneID string `yaml:"hosted_zone_id"`
+	// Type is a type of records. CNAME (default) or A.
+	Type string `yaml:"type"`
+	// Target is a value of CNAME records, or values of A records. e.g. the DNS name of the load balancer in front of mirage-ecs.
+	Target []string `yaml:"target"`
+	// TTL is TTL of records in seconds.
+	TTL int64 `yaml:"ttl"`
+	// Failover creates failover records. Target is the primary and Failover.Target is the secondary.
+	Failover *RecordsFailover `yaml:"failover"`
+}
+
+// RecordsFailover configures health-checked failover records.
+type RecordsFailover struct {
+	// HealthCheckID is an ID of the Route 53 health check of the primary.
+	HealthCheckID string `yaml:"health_check_id"`
+	// Target is the secondary target.
+	Target []string `yaml:"target"`
+}
+
+func (r *Records) validate() error {
+	if r == nil {
+		return nil
+	}
+	if r.HostedZoneID == "" {
+		return fmt.Errorf("records.hosted_zone_id is required")
+	}
+	if r.Type == "" {
+		r.Type = string(r53Types.RRTypeCname)
+	}
+	if r.TTL == 0 {
+		r.TTL = DefaultRecordTTL
+	}
+	if err := validateRecordTarget(r.Type, r.Target); err != nil {
+		return fmt.Errorf("records.target: %w", err)
+	}
+	if f := r.Failover; f != nil {
+		if f.HealthCheckID == "" {
+			return fmt.Errorf("records.failover.health_check_id is required")
+		}
+		if err := validateRecordTarget(r.Type, f.Target); err != nil {
+			return fmt.Errorf("records.failover.target: %w", err)
+		}
+	}
+	return nil
+}
+
+func validateRecordTarget(typ string, target []string) error {
+	switch r53Types.RRType(typ) {
+	case r53Types.RRTypeCname:
+		if len(target) != 1 {
+			return fmt.Errorf("CNAME record requires exactly one target")
+		}
+	case r53Types.RRTypeA:
+		if len(target) == 0 {
+			return fmt.Errorf("A record requires targets")
+		}
+	default:
+		return fmt.Errorf("unsupported record type: %s", typ)
+	}
+	return nil
+}
+
+// recordName returns a record name of the subdomain. Subdomains with wildcards have no records.
+func recordName(subdomain, suffix string) (string, bool) {
+	if strings.ContainsAny(subdomain, "*?[]") {
+		return "", false
+	}
+	return subdomain + "." + strings.TrimPrefix(suffix, "."), true
+}
+
+// changes returns changes of record sets of the name.
+func (r *Records) changes(name string, action r53Types.ChangeAction) []r53Types.Change {
+	if r.Failover == nil {
+		return []r53Types.Change{{Action: action, ResourceRecordSet: r.recordSet(name, r.Target)}}
+	}
+	primary := r.recordSet(name, r.Target)
+	primary.SetIdentifier = aws.String(name + "-primary")
+	primary.Failover = r53Types.ResourceRecordSetFailoverPrimary
+	primary.HealthCheckId = aws.String(r.Failover.HealthCheckID)
+	secondary := r.recordSet(name, r.Failover.Target)
+	secondary.SetIdentifier = aws.String(name + "-secondary")
+	secondary.Failover = r53Types.ResourceRecordSetFailoverSecondary
+	return []r53Types.Change{
+		{Action: action, ResourceRecordSet: primary},
+		{Action: action, ResourceRecordSet: secondary},
+	}
+}
+
+func (r *Records) recordSet(name string, target []string) *r53Types.ResourceRecordSet {
+	rs := &r53Types.ResourceRecordSet{
+		Name: aws.String(name),
+		Type: r53Types.RRType(r.Type),
+		TTL:  aws.Int64(r.TTL),
+	}
+	for _, v := range target {
+		rs.ResourceRecords = append(rs.ResourceRecords, r53Types.ResourceRecord{Value: aws.String(v)})
+	}
+	return rs
+}
+
+// RecordManager creates and deletes DNS records of environments.
+type RecordManager struct {
+	svc     *route53.Client
+	cfg     *Records
+	suffix  string
+	created map[string]struct{}              // subdomains which have records
+	pending map[string]r53Types.ChangeAction // subdomain -> action to apply
+}
+
+func NewRecordManager(cfg *Config) *RecordManager {
+	return newRecordManager(cfg, cfg.Host.Records)
+}
+
+// NewPrivateRecordManager returns a manager of records of private environments in the private hosted zone.
+func NewPrivateRecordManager(cfg *Config) *RecordManager {
+	if cfg.Network.Private == nil {
+		return nil
+	}
+	return newRecordManager(cfg, cfg.Network.Private.Records)
+}
+
+func newRecordManager(cfg *Config, records *Records) *RecordManager {
+	if records == nil {
+		return nil
+	}
+	return &RecordManager{
+		svc:     route53.NewFromConfig(*cfg.awscfg),
+		cfg:     records,
+		suffix:  cfg.Host.ReverseProxySuffix,
+		created: make(map[string]struct{}),
+		pending: make(map[string]r53Types.ChangeAction),
+	}
+}
+
+// Add queues creation of the record of the subdomain.
+func (m *RecordManager) Add(subdomain string) {
+	if m == nil {
+		return
+	}
+	if _, ok := m.created[subdomain]; ok {
+		return
+	}
+	if _, ok := recordName(subdomain, m.suffix); !ok {
+		return
+	}
+	m.created[subdomain] = struct{}{}
+	m.pending[subdomain] = r53Types.ChangeActionUpsert
+}
+
+// Delete queues deletion of the record of the subdomain.
+func (m *RecordManager) Delete(subdomain string) {
+	if m == nil {
+		return
+	}
+	if _, ok := m.created[subdomain]; !ok {
+		return
+	}
+	delete(m.created, subdomain)
+	m.pending[subdomain] = r53Types.ChangeActionDelete
+}
+
+// Subdomains returns subdomains which have records.
+func (m *RecordManager) Subdomains() []string {
+	if m == nil {
+		return nil
+	}
+	return lo.Keys(m.created)
+}
+
+// Apply applies queued changes per subdomain, so a failure doesn't block changes of other subdomains.
+func (m *RecordManager) Apply(ctx context.Context) error {
+	if m == nil || len(m.pending) == 0 {
+		return nil
+	}
+	var errs []error
+	for _, subdomain := range lo.Keys(m.pending) {
+		action := m.pending[subdomain]
+		delete(m.pending, subdomain)
+		name, _ := recordName(subdomain, m.suffix)
+		_, err := m.svc.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+			HostedZoneId: aws.String(m.cfg.HostedZoneID),
+			ChangeBatch: &r53Types.ChangeBatch{
+				Changes: m.cfg.changes(name, action),
+				Comment: aws.String("managed by mirage-ecs"),
+			},
+		})
+		if err != nil {
+			if action == r53Types.ChangeActionUpsert {
+				// retry in the next sync
+				delete(m.created, subdomain)
+			}
+			errs = append(errs, fmt.Errorf("failed to %s the record %s: %w", action, name, err))
+			continue
+		}
+		slog.Info(f("record change: %s %s", action, name))
+	}
+	return errors.Join(errs...)
+}
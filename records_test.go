@@ -0,0 +1,87 @@
+package mirageecs_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	r53Types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestRecordsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		r       *mirageecs.Records
+		wantErr bool
+	}{
+		{name: "nil", r: nil},
+		{name: "cname", r: &mirageecs.Records{HostedZoneID: "Z123", Target: []string{"mirage-alb.example.com"}}},
+		{name: "a", r: &mirageecs.Records{HostedZoneID: "Z123", Type: "A", Target: []string{"192.0.2.1", "192.0.2.2"}}},
+		{name: "no zone", r: &mirageecs.Records{Target: []string{"mirage-alb.example.com"}}, wantErr: true},
+		{name: "multiple cname targets", r: &mirageecs.Records{HostedZoneID: "Z123", Target: []string{"a.example.com", "b.example.com"}}, wantErr: true},
+		{name: "unsupported type", r: &mirageecs.Records{HostedZoneID: "Z123", Type: "TXT", Target: []string{"x"}}, wantErr: true},
+		{
+			name: "failover without health check",
+			r: &mirageecs.Records{
+				HostedZoneID: "Z123",
+				Target:       []string{"primary.example.com"},
+				Failover:     &mirageecs.RecordsFailover{Target: []string{"secondary.example.com"}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.r.Validate()
+			if tt.wantErr && err == nil {
+				t.Error("expected error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestRecordName(t *testing.T) {
+	if name, ok := mirageecs.RecordName("pr123", ".dev.example.net"); !ok || name != "pr123.dev.example.net" {
+		t.Errorf("unexpected record name: %s %v", name, ok)
+	}
+	if _, ok := mirageecs.RecordName("feature-*", ".dev.example.net"); ok {
+		t.Error("wildcard subdomains must not have records")
+	}
+}
+
+func TestRecordsChanges(t *testing.T) {
+	r := &mirageecs.Records{HostedZoneID: "Z123", Target: []string{"mirage-alb.example.com"}}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	changes := r.Changes("pr123.dev.example.net", r53Types.ChangeActionUpsert)
+	if len(changes) != 1 {
+		t.Fatalf("unexpected changes: %#v", changes)
+	}
+	rs := changes[0].ResourceRecordSet
+	if rs.Type != r53Types.RRTypeCname || aws.ToInt64(rs.TTL) != 60 || aws.ToString(rs.ResourceRecords[0].Value) != "mirage-alb.example.com" {
+		t.Errorf("unexpected record set: %#v", rs)
+	}
+
+	r.Failover = &mirageecs.RecordsFailover{HealthCheckID: "hc-1", Target: []string{"maintenance.example.com"}}
+	changes = r.Changes("pr123.dev.example.net", r53Types.ChangeActionDelete)
+	if len(changes) != 2 {
+		t.Fatalf("unexpected changes: %#v", changes)
+	}
+	primary, secondary := changes[0].ResourceRecordSet, changes[1].ResourceRecordSet
+	if primary.Failover != r53Types.ResourceRecordSetFailoverPrimary || aws.ToString(primary.HealthCheckId) != "hc-1" || aws.ToString(primary.SetIdentifier) != "pr123.dev.example.net-primary" {
+		t.Errorf("unexpected primary: %#v", primary)
+	}
+	if secondary.Failover != r53Types.ResourceRecordSetFailoverSecondary || aws.ToString(secondary.ResourceRecords[0].Value) != "maintenance.example.com" {
+		t.Errorf("unexpected secondary: %#v", secondary)
+	}
+	for _, c := range changes {
+		if c.Action != r53Types.ChangeActionDelete {
+			t.Errorf("unexpected action: %s", c.Action)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+)
+
+const defaultImageTag = "latest"
+
+// AutoRedeploy relaunches environments when images of their task definitions are pushed to ECR with the same tags.
+type AutoRedeploy struct {
+	// ImageTags are image tags to watch. Default is ["latest"].
+	ImageTags []string `yaml:"image_tags"`
+	// Tag is a key of the tag (or a name of the parameter) to select environments. Empty selects all environments.
+	Tag string `yaml:"tag"`
+	// Value is a value of the tag. Empty matches any value.
+	Value string `yaml:"value"`
+}
+
+func (r *AutoRedeploy) validate() error {
+	if r == nil {
+		return nil
+	}
+	if len(r.ImageTags) == 0 {
+		r.ImageTags = []string{defaultImageTag}
+	}
+	for _, tag := range r.ImageTags {
+		if tag == "" || strings.ContainsAny(tag, ":@/") {
+			return fmt.Errorf("invalid auto_redeploy.image_tags: %q", tag)
+		}
+	}
+	if r.Tag == "" && r.Value != "" {
+		return fmt.Errorf("auto_redeploy.tag is required with value")
+	}
+	return nil
+}
+
+// ContainerImage is an image of the container in the task.
+type ContainerImage struct {
+	Name   string `json:"name"`
+	Image  string `json:"image"`
+	Digest string `json:"digest,omitempty"`
+}
+
+func containerImagesOfTask(task *types.Task) []ContainerImage {
+	var images []ContainerImage
+	for _, c := range task.Containers {
+		images = append(images, ContainerImage{
+			Name:   aws.ToString(c.Name),
+			Image:  aws.ToString(c.Image),
+			Digest: aws.ToString(c.ImageDigest),
+		})
+	}
+	return images
+}
+
+// APIECREventRequest is an EventBridge event of an ECR image action, sent to /api/ecr_event by an API destination.
+type APIECREventRequest struct {
+	DetailType string `json:"detail-type"`
+	Source     string `json:"source"`
+	Account    string `json:"account"`
+	Region     string `json:"region"`
+	Detail     struct {
+		ActionType     string `json:"action-type"`
+		Result         string `json:"result"`
+		RepositoryName string `json:"repository-name"`
+		ImageDigest    string `json:"image-digest"`
+		ImageTag       string `json:"image-tag"`
+	} `json:"detail"`
+}
+
+// APIECREventResponse is a response of /api/ecr_event
+type APIECREventResponse struct {
+	Result     string   `json:"result"`
+	Subdomains []string `json:"subdomains,omitempty"`
+}
+
+// pushed reports whether the event is a successful push of the image.
+func (ev *APIECREventRequest) pushed() bool {
+	return ev.Source == "aws.ecr" &&
+		ev.DetailType == "ECR Image Action" &&
+		ev.Detail.ActionType == "PUSH" &&
+		ev.Detail.Result == "SUCCESS"
+}
+
+// refers reports whether the image refers the repository and the tag of the event.
+// Images pinned by digests never refer tags.
+func (ev *APIECREventRequest) refers(image string) bool {
+	if strings.Contains(image, "@") {
+		return false
+	}
+	host, name, ok := strings.Cut(image, "/")
+	if !ok || !strings.HasPrefix(host, fmt.Sprintf("%s.dkr.ecr.%s.", ev.Account, ev.Region)) {
+		return false
+	}
+	tag := defaultImageTag
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		name, tag = name[:i], name[i+1:]
+	}
+	return name == ev.Detail.RepositoryName && tag == ev.Detail.ImageTag
+}
+
+// targets returns subdomains of running environments whose containers refer the pushed image, except ones running the pushed digest already.
+func (r *AutoRedeploy) targets(running []*Information, ev *APIECREventRequest) []string {
+	if !lo.Contains(r.ImageTags, ev.Detail.ImageTag) {
+		return nil
+	}
+	subdomains := make(map[string]struct{})
+	for _, info := range running {
+		if r.Tag != "" {
+			v := getTag(info.Tags, r.Tag)
+			if v == "" || (r.Value != "" && v != r.Value) {
+				continue
+			}
+		}
+		for _, img := range info.Images {
+			if ev.refers(img.Image) && img.Digest != ev.Detail.ImageDigest {
+				subdomains[info.SubDomain] = struct{}{}
+			}
+		}
+	}
+	targets := lo.Keys(subdomains)
+	sort.Strings(targets)
+	return targets
+}
+
+// redeployer keeps digests which environments are redeployed for, so duplicated events do not relaunch them again.
+type redeployer struct {
+	mu      sync.Mutex
+	digests map[string]string // subdomain -> image digest
+}
+
+func newRedeployer() *redeployer {
+	return &redeployer{digests: make(map[string]string)}
+}
+
+// start reports whether the subdomain should be redeployed for the digest.
+func (r *redeployer) start(subdomain, digest string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.digests[subdomain] == digest {
+		return false
+	}
+	r.digests[subdomain] = digest
+	return true
+}
+
+// ApiECREvent relaunches environments which refer the image pushed to ECR.
+func (api *WebApi) ApiECREvent(c echo.Context) error {
+	r := api.cfg.ECS.AutoRedeploy
+	if r == nil {
+		return c.JSON(http.StatusNotFound, APICommonResponse{Result: "ecs.auto_redeploy is not configured"})
+	}
+	var ev APIECREventRequest
+	if err := c.Bind(&ev); err != nil {
+		return c.JSON(http.StatusBadRequest, APICommonResponse{Result: err.Error()})
+	}
+	if !ev.pushed() {
+		return c.JSON(http.StatusOK, APIECREventResponse{Result: "ignored"})
+	}
+	running, err := api.runner.List(c.Request().Context(), statusRunning)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, APICommonResponse{Result: err.Error()})
+	}
+	targets := lo.Filter(r.targets(running, &ev), func(subdomain string, _ int) bool {
+		return api.redeployer.start(subdomain, ev.Detail.ImageDigest)
+	})
+	image := fmt.Sprintf("%s:%s", ev.Detail.RepositoryName, ev.Detail.ImageTag)
+	for _, subdomain := range targets {
+		infos := lo.Filter(running, func(info *Information, _ int) bool {
+			return info.SubDomain == subdomain
+		})
+		// events should be acknowledged before relaunches complete
+		go api.redeploy(context.Background(), subdomain, infos, image)
+	}
+	return c.JSON(http.StatusAccepted, APIECREventResponse{Result: "accepted", Subdomains: targets})
+}
+
+// redeploy relaunches the environment with the same task definitions, parameters and options.
+func (api *WebApi) redeploy(ctx context.Context, subdomain string, infos []*Information, image string) {
+	ctx, cancel := context.WithTimeout(ctx, APICallTimeout)
+	defer cancel()
+	slog.Info(f("redeploying subdomain %s for image %s", subdomain, image))
+	if err := api.relaunchInPlace(ctx, subdomain, infos); err != nil {
+		slog.Warn(f("failed to redeploy subdomain %s: %s", subdomain, err))
+	}
+}
+
+// relaunchInPlace launches the subdomain again with the same task definitions, parameters and options as the running tasks.
+func (api *WebApi) relaunchInPlace(ctx context.Context, subdomain string, infos []*Information) error {
+	param := taskParameterFromTags(infos[0].Tags, api.cfg.Parameter)
+	return api.runner.Launch(ctx, subdomain, param, infos[0].Option, baseTaskDefs(infos)...)
+}
+
+// APIRelaunchRequest is a request of /api/relaunch
+type APIRelaunchRequest struct {
+	Subdomain string `json:"subdomain" form:"subdomain"`
+}
+
+func (api *WebApi) ApiRelaunch(c echo.Context) error {
+	code, err := api.relaunch(c)
+	if err != nil {
+		return c.JSON(code, APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(code, APICommonResponse{Result: "ok"})
+}
+
+// Relaunch relaunches the environment by the button of the web interface.
+func (api *WebApi) Relaunch(c echo.Context) error {
+	code, err := api.relaunch(c)
+	if err != nil {
+		return c.String(code, translate(api.cfg.requestLocale(c), err.Error()))
+	}
+	return c.String(code, "ok")
+}
+
+// relaunch restarts the running environment in place, replacing its tasks by new tasks.
+func (api *WebApi) relaunch(c echo.Context) (int, error) {
+	r := APIRelaunchRequest{}
+	if err := c.Bind(&r); err != nil {
+		return http.StatusBadRequest, err
+	}
+	subdomain := strings.ToLower(r.Subdomain)
+	if subdomain == "" {
+		return http.StatusBadRequest, fmt.Errorf("parameter required: subdomain")
+	}
+	ctx := withLogAttrs(c.Request().Context(), slog.String("subdomain", subdomain))
+	ctx, cancel := context.WithTimeout(ctx, APICallTimeout)
+	defer cancel()
+	running, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	infos := lo.Filter(running, func(info *Information, _ int) bool {
+		return info.SubDomain == subdomain
+	})
+	if len(infos) == 0 {
+		return http.StatusNotFound, fmt.Errorf("subdomain %s is not running", subdomain)
+	}
+	slog.InfoContext(ctx, f("relaunching subdomain %s", subdomain))
+	if err := api.relaunchInPlace(ctx, subdomain, infos); err != nil {
+		slog.ErrorContext(ctx, f("relaunch failed: %s", err))
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,160 @@
+package mirageecs_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/labstack/echo/v4"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestAutoRedeployTargets(t *testing.T) {
+	const registry = "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com"
+	running := []*mirageecs.Information{
+		{
+			SubDomain: "latest",
+			Images: []mirageecs.ContainerImage{
+				{Name: "app", Image: registry + "/myapp:latest", Digest: "sha256:old"},
+				{Name: "nginx", Image: "nginx:latest"},
+			},
+		},
+		{
+			SubDomain: "implicit",
+			Images:    []mirageecs.ContainerImage{{Name: "app", Image: registry + "/myapp", Digest: "sha256:old"}},
+		},
+		{
+			SubDomain: "current",
+			Images:    []mirageecs.ContainerImage{{Name: "app", Image: registry + "/myapp:latest", Digest: "sha256:new"}},
+		},
+		{
+			SubDomain: "other-tag",
+			Images:    []mirageecs.ContainerImage{{Name: "app", Image: registry + "/myapp:v1.0.0", Digest: "sha256:old"}},
+		},
+		{
+			SubDomain: "pinned",
+			Images:    []mirageecs.ContainerImage{{Name: "app", Image: registry + "/myapp@sha256:old", Digest: "sha256:old"}},
+		},
+		{
+			SubDomain: "other-repo",
+			Images:    []mirageecs.ContainerImage{{Name: "app", Image: registry + "/team/myapp:latest", Digest: "sha256:old"}},
+		},
+		{
+			SubDomain: "other-region",
+			Images:    []mirageecs.ContainerImage{{Name: "app", Image: "123456789012.dkr.ecr.us-east-1.amazonaws.com/myapp:latest"}},
+		},
+		{
+			SubDomain: "tagged",
+			Tags:      []types.Tag{{Key: aws.String("track"), Value: aws.String("latest")}},
+			Images:    []mirageecs.ContainerImage{{Name: "app", Image: registry + "/myapp:latest"}},
+		},
+	}
+	ev := &mirageecs.APIECREventRequest{
+		DetailType: "ECR Image Action",
+		Source:     "aws.ecr",
+		Account:    "123456789012",
+		Region:     "ap-northeast-1",
+	}
+	ev.Detail.ActionType = "PUSH"
+	ev.Detail.Result = "SUCCESS"
+	ev.Detail.RepositoryName = "myapp"
+	ev.Detail.ImageTag = "latest"
+	ev.Detail.ImageDigest = "sha256:new"
+
+	r := &mirageecs.AutoRedeploy{}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"implicit", "latest", "tagged"}, r.Targets(running, ev)); diff != "" {
+		t.Errorf("unexpected targets (-want +got):\n%s", diff)
+	}
+
+	r = &mirageecs.AutoRedeploy{Tag: "track", Value: "latest"}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"tagged"}, r.Targets(running, ev)); diff != "" {
+		t.Errorf("unexpected targets by tag (-want +got):\n%s", diff)
+	}
+
+	r = &mirageecs.AutoRedeploy{ImageTags: []string{"stable"}}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if targets := r.Targets(running, ev); len(targets) != 0 {
+		t.Errorf("unwatched tags must not redeploy: %v", targets)
+	}
+}
+
+func TestAutoRedeployValidate(t *testing.T) {
+	for _, r := range []*mirageecs.AutoRedeploy{
+		{ImageTags: []string{"myapp:latest"}},
+		{ImageTags: []string{""}},
+		{Value: "latest"},
+	} {
+		if err := r.Validate(); err == nil {
+			t.Errorf("expected error: %#v", r)
+		}
+	}
+}
+
+func TestRelaunch(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	opt := &mirageecs.LaunchOption{Protected: true}
+	if err := m.Runner().Launch(ctx, "foo", mirageecs.TaskParameter{"branch": "develop"}, opt, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := m.Runner().List(ctx, "RUNNING")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := infos[0].ShortID
+
+	e := echo.New()
+	for _, c := range []struct {
+		body   string
+		status int
+	}{
+		{`{"subdomain":"foo"}`, http.StatusOK},
+		{`{"subdomain":"unknown"}`, http.StatusNotFound},
+		{`{}`, http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/relaunch", strings.NewReader(c.body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		if err := m.WebApi.ApiRelaunch(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != c.status {
+			t.Errorf("relaunch %s: wanted status %d, got %d: %s", c.body, c.status, rec.Code, rec.Body.String())
+		}
+	}
+
+	infos, err = m.Runner().List(ctx, "RUNNING")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("unexpected tasks: %#v", infos)
+	}
+	info := infos[0]
+	if info.ShortID == before {
+		t.Error("task is not replaced")
+	}
+	if info.SubDomain != "foo" || info.TaskDef != "app:1" || info.GitBranch != "develop" || !info.Protected {
+		t.Errorf("unexpected relaunched task: %#v", info)
+	}
+}
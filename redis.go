@@ -0,0 +1,317 @@
+package mirageecs
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DefaultRedisKeyPrefix = "mirage:"
+
+	redisTimeout  = 5 * time.Second
+	redisMaxIdle  = 4
+	redisLockTTL  = 30 * time.Second
+	redisPurgeKey = "lock:purge"
+
+	// redisUnlockScript deletes the lock only when it is held by the token.
+	redisUnlockScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`
+	// redisExtendScript extends the lock only when it is held by the token.
+	redisExtendScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) else return 0 end`
+)
+
+// Redis shares runtime state (access counters, purge locks and confirmation tokens) between replicas of mirage-ecs.
+type Redis struct {
+	// Address is host:port of the Redis server. Redis 6.2 or later is required.
+	Address  string `yaml:"address"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	TLS      bool   `yaml:"tls"`
+	// KeyPrefix is a prefix of keys. Default is "mirage:". Replicas of the same mirage-ecs must have the same prefix.
+	KeyPrefix string `yaml:"key_prefix"`
+}
+
+func (r *Redis) validate() error {
+	if r == nil {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(r.Address); err != nil {
+		return fmt.Errorf("redis.address must be host:port: %s", r.Address)
+	}
+	if r.DB < 0 {
+		return fmt.Errorf("redis.db must not be negative: %d", r.DB)
+	}
+	if r.KeyPrefix == "" {
+		r.KeyPrefix = DefaultRedisKeyPrefix
+	}
+	return nil
+}
+
+// redisClient is a client of Redis to share state between replicas.
+type redisClient struct {
+	cfg *Redis
+	// instance identifies this process in shared state.
+	instance string
+
+	rdb *redis.Client
+}
+
+func (c *Config) openRedis() error {
+	r := c.Redis
+	if r == nil {
+		return nil
+	}
+	c.redis = newRedisClient(r)
+	c.cleanups = append(c.cleanups, c.redis.close)
+	return nil
+}
+
+func newRedisClient(cfg *Redis) *redisClient {
+	hostname, _ := os.Hostname()
+	opt := &redis.Options{
+		Addr:            cfg.Address,
+		Username:        cfg.Username,
+		Password:        cfg.Password,
+		DB:              cfg.DB,
+		DialTimeout:     redisTimeout,
+		ReadTimeout:     redisTimeout,
+		WriteTimeout:    redisTimeout,
+		MaxIdleConns:    redisMaxIdle,
+		DisableIdentity: true,
+	}
+	if cfg.TLS {
+		host, _, _ := net.SplitHostPort(cfg.Address)
+		opt.TLSConfig = &tls.Config{ServerName: host}
+	}
+	return &redisClient{
+		cfg:      cfg,
+		instance: hostname + "-" + generateRandomHexID(8),
+		rdb:      redis.NewClient(opt),
+	}
+}
+
+func (c *redisClient) key(name string) string {
+	return c.cfg.KeyPrefix + name
+}
+
+func (c *redisClient) close() error {
+	return c.rdb.Close()
+}
+
+// redisLock is a lock held by this process. The lock is extended in background until released.
+type redisLock struct {
+	c     *redisClient
+	key   string
+	token string
+	ttl   time.Duration
+
+	stop chan struct{}
+	lost chan struct{} // closed when the lock is lost (e.g. failures to extend)
+	done chan struct{}
+}
+
+// tryLock acquires the lock. It returns nil without errors when another process holds the lock.
+func (c *redisClient) tryLock(ctx context.Context, name string, ttl time.Duration) (*redisLock, error) {
+	token := c.instance + "-" + generateRandomHexID(16)
+	key := c.key(name)
+	if ok, err := c.setLock(ctx, key, token, ttl); err != nil || !ok {
+		return nil, err
+	}
+	l := &redisLock{
+		c:     c,
+		key:   key,
+		token: token,
+		ttl:   ttl,
+		stop:  make(chan struct{}),
+		lost:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go l.keep()
+	return l, nil
+}
+
+// setLock sets the key to the token if the key doesn't exist.
+func (c *redisClient) setLock(ctx context.Context, key, token string, ttl time.Duration) (bool, error) {
+	return c.rdb.SetNX(ctx, key, token, ttl).Result()
+}
+
+// extendLock extends the key if it is set to the token.
+func (c *redisClient) extendLock(ctx context.Context, key, token string, ttl time.Duration) (bool, error) {
+	n, err := c.rdb.Eval(ctx, redisExtendScript, []string{key}, token, ttl.Milliseconds()).Int64()
+	return n == 1, err
+}
+
+// unlock deletes the key if it is set to the token.
+func (c *redisClient) unlock(ctx context.Context, key, token string) error {
+	return c.rdb.Eval(ctx, redisUnlockScript, []string{key}, token).Err()
+}
+
+func (l *redisLock) keep() {
+	defer close(l.done)
+	tk := time.NewTicker(l.ttl / 3)
+	defer tk.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-tk.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		ok, err := l.c.extendLock(ctx, l.key, l.token, l.ttl)
+		cancel()
+		if err != nil {
+			slog.Warn(f("failed to extend redis lock %s: %s", l.key, err))
+			continue
+		}
+		if !ok {
+			slog.Warn(f("redis lock %s is lost", l.key))
+			close(l.lost)
+			return
+		}
+	}
+}
+
+// release releases the lock if it is still held.
+func (l *redisLock) release() {
+	close(l.stop)
+	<-l.done
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := l.c.unlock(ctx, l.key, l.token); err != nil {
+		slog.Warn(f("failed to release redis lock %s: %s", l.key, err))
+	}
+}
+
+// putConfirmation stores subdomains of the confirmation token of /api/terminate_all.
+func (c *redisClient) putConfirmation(ctx context.Context, token string, subdomains []string, ttl time.Duration) error {
+	b, err := json.Marshal(subdomains)
+	if err != nil {
+		return err
+	}
+	return c.rdb.Set(ctx, c.key("terminate_all:"+token), b, ttl).Err()
+}
+
+// consumeConfirmation returns and removes subdomains of the confirmation token. Expired tokens are removed by Redis.
+func (c *redisClient) consumeConfirmation(ctx context.Context, token string) ([]string, bool, error) {
+	b, err := c.rdb.GetDel(ctx, c.key("terminate_all:"+token)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	var subdomains []string
+	if err := json.Unmarshal(b, &subdomains); err != nil {
+		return nil, false, err
+	}
+	return subdomains, true, nil
+}
+
+// publishAccess stores the last access and changed statistics of the subdomain by this process.
+// Fields of the hash are "<instance>/last" and "<instance>/<unix time of the bucket>".
+func (c *redisClient) publishAccess(ctx context.Context, subdomain string, s *accessCounterSnapshot, retention time.Duration) error {
+	key := c.key("access:" + subdomain)
+	values := []string{c.instance + "/last", s.Last.Format(time.RFC3339Nano)}
+	for _, st := range s.Stats {
+		b, err := json.Marshal(st)
+		if err != nil {
+			return err
+		}
+		values = append(values, c.instance+"/"+strconv.FormatInt(st.Time.Unix(), 10), string(b))
+	}
+	if err := c.rdb.HSet(ctx, key, values).Err(); err != nil {
+		return err
+	}
+	return c.rdb.PExpire(ctx, key, retention).Err()
+}
+
+// fetchAccess returns snapshots of access counters of the subdomain by other processes.
+// Statistics older than the retention are removed.
+func (c *redisClient) fetchAccess(ctx context.Context, subdomain string, retention time.Duration) ([]*accessCounterSnapshot, error) {
+	key := c.key("access:" + subdomain)
+	values, err := c.rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make(map[string]*accessCounterSnapshot)
+	var expired []string
+	for field, value := range values {
+		instance, name, ok := strings.Cut(field, "/")
+		if !ok || instance == c.instance {
+			continue
+		}
+		s, ok := snapshots[instance]
+		if !ok {
+			s = &accessCounterSnapshot{}
+			snapshots[instance] = s
+		}
+		if name == "last" {
+			s.Last, _ = time.Parse(time.RFC3339Nano, value)
+			continue
+		}
+		var st accessStatsSnapshot
+		if err := json.Unmarshal([]byte(value), &st); err != nil {
+			slog.Warn(f("invalid access stats %s of %s: %s", field, key, err))
+			continue
+		}
+		if time.Since(st.Time) > retention {
+			expired = append(expired, field)
+			continue
+		}
+		s.Stats = append(s.Stats, &st)
+	}
+	if len(expired) > 0 {
+		if err := c.rdb.HDel(ctx, key, expired...).Err(); err != nil {
+			slog.Warn(f("failed to remove expired access stats of %s: %s", key, err))
+		}
+	}
+	r := make([]*accessCounterSnapshot, 0, len(snapshots))
+	for _, s := range snapshots {
+		r = append(r, s)
+	}
+	return r, nil
+}
+
+// shareAccess publishes access counters of this process, and fetches counters of other processes.
+func (m *Mirage) shareAccess(ctx context.Context) {
+	c := m.Config.redis
+	rp := m.ReverseProxy
+	retention := m.Config.Network.AccessCounter.retention()
+	subdomains := make(map[string]struct{})
+	for subdomain, s := range rp.drainAccess() {
+		subdomains[subdomain] = struct{}{}
+		if err := c.publishAccess(ctx, subdomain, s, retention); err != nil {
+			slog.Warn(f("failed to publish access counter of %s to redis: %s", subdomain, err))
+		}
+	}
+	for _, subdomain := range rp.Subdomains() {
+		subdomains[subdomain] = struct{}{}
+	}
+	remote := make(map[string]*AccessCounter, len(subdomains))
+	for subdomain := range subdomains {
+		snapshots, err := c.fetchAccess(ctx, subdomain, retention)
+		if err != nil {
+			slog.Warn(f("failed to fetch access counters of %s from redis: %s", subdomain, err))
+			continue
+		}
+		if len(snapshots) == 0 {
+			continue
+		}
+		counter := rp.newAccessCounter()
+		for _, s := range snapshots {
+			counter.add(s)
+		}
+		remote[subdomain] = counter
+	}
+	rp.setRemoteAccess(remote)
+}
@@ -0,0 +1,297 @@
+package mirageecs_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+// fakeRedis is a Redis server which supports only commands used by mirage-ecs.
+type fakeRedis struct {
+	mu      sync.Mutex
+	strings map[string]string
+	expires map[string]time.Time
+	hashes  map[string]map[string]string
+}
+
+func newFakeRedis(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &fakeRedis{
+		strings: map[string]string{},
+		expires: map[string]time.Time{},
+		hashes:  map[string]map[string]string{},
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		var n int
+		if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+			return
+		}
+		args := make([]string, n)
+		for i := range args {
+			var size int
+			if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+				return
+			}
+			b := make([]byte, size+2)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return
+			}
+			args[i] = string(b[:size])
+		}
+		io.WriteString(conn, s.do(args))
+	}
+}
+
+func bulk(v string, ok bool) string {
+	if !ok {
+		return "$-1\r\n"
+	}
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+}
+
+func (s *fakeRedis) get(key string) (string, bool) {
+	if t, ok := s.expires[key]; ok && time.Now().After(t) {
+		delete(s.strings, key)
+		delete(s.expires, key)
+	}
+	v, ok := s.strings[key]
+	return v, ok
+}
+
+func (s *fakeRedis) do(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "SET":
+		key, value := args[1], args[2]
+		var nx bool
+		var ttl time.Duration
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "PX":
+				ms, _ := strconv.Atoi(args[i+1])
+				ttl = time.Duration(ms) * time.Millisecond
+				i++
+			}
+		}
+		if _, ok := s.get(key); ok && nx {
+			return "$-1\r\n"
+		}
+		s.strings[key] = value
+		delete(s.expires, key)
+		if ttl > 0 {
+			s.expires[key] = time.Now().Add(ttl)
+		}
+		return "+OK\r\n"
+	case "GETDEL":
+		v, ok := s.get(args[1])
+		delete(s.strings, args[1])
+		return bulk(v, ok)
+	case "EVAL":
+		key, token := args[3], args[4]
+		if v, ok := s.get(key); !ok || v != token {
+			return ":0\r\n"
+		}
+		switch args[1] {
+		case mirageecs.RedisUnlockScript:
+			delete(s.strings, key)
+		case mirageecs.RedisExtendScript:
+			ms, _ := strconv.Atoi(args[5])
+			s.expires[key] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		default:
+			return "-ERR unknown script\r\n"
+		}
+		return ":1\r\n"
+	case "HSET":
+		h, ok := s.hashes[args[1]]
+		if !ok {
+			h = map[string]string{}
+			s.hashes[args[1]] = h
+		}
+		for i := 2; i+1 < len(args); i += 2 {
+			h[args[i]] = args[i+1]
+		}
+		return ":1\r\n"
+	case "HGETALL":
+		h := s.hashes[args[1]]
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%d\r\n", len(h)*2)
+		for k, v := range h {
+			b.WriteString(bulk(k, true) + bulk(v, true))
+		}
+		return b.String()
+	case "HDEL":
+		for _, field := range args[2:] {
+			delete(s.hashes[args[1]], field)
+		}
+		return ":1\r\n"
+	case "PEXPIRE":
+		return ":1\r\n"
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+func TestRedisValidate(t *testing.T) {
+	for _, r := range []*mirageecs.Redis{
+		{},
+		{Address: "localhost"},
+		{Address: "localhost:6379", DB: -1},
+	} {
+		if err := r.Validate(); err == nil {
+			t.Errorf("%#v should be invalid", r)
+		}
+	}
+	r := &mirageecs.Redis{Address: "localhost:6379"}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if r.KeyPrefix != mirageecs.DefaultRedisKeyPrefix {
+		t.Errorf("unexpected default key prefix: %s", r.KeyPrefix)
+	}
+}
+
+func newRedisMirage(t *testing.T, addr string) *mirageecs.Mirage {
+	t.Helper()
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cfg.Cleanup)
+	cfg.Auth = &mirageecs.Auth{
+		Token: &mirageecs.AuthMethodToken{Header: "x-mirage-token", Token: "user"},
+		Admin: &mirageecs.AuthMethodToken{Header: "x-mirage-admin-token", Token: "admin"},
+	}
+	cfg.Redis = &mirageecs.Redis{Address: addr}
+	if err := cfg.OpenRedis(); err != nil {
+		t.Fatal(err)
+	}
+	return mirageecs.New(ctx, cfg)
+}
+
+func TestRedisLock(t *testing.T) {
+	ctx := context.Background()
+	addr := newFakeRedis(t)
+	m1, m2 := newRedisMirage(t, addr), newRedisMirage(t, addr)
+
+	release, ok, err := m1.Config.TryRedisLock(ctx, "lock:test", time.Second)
+	if err != nil || !ok {
+		t.Fatalf("failed to lock: %v", err)
+	}
+	if _, ok, err := m2.Config.TryRedisLock(ctx, "lock:test", time.Second); err != nil || ok {
+		t.Fatalf("the lock is held by another replica: %v %v", ok, err)
+	}
+	// the lock is extended while it is held
+	time.Sleep(1500 * time.Millisecond)
+	if _, ok, _ := m2.Config.TryRedisLock(ctx, "lock:test", time.Second); ok {
+		t.Fatal("the lock should be extended")
+	}
+	release()
+	release2, ok, err := m2.Config.TryRedisLock(ctx, "lock:test", time.Second)
+	if err != nil || !ok {
+		t.Fatalf("failed to lock after the release: %v", err)
+	}
+	release2()
+}
+
+func TestRedisTerminateAll(t *testing.T) {
+	ctx := context.Background()
+	addr := newFakeRedis(t)
+	var servers []*httptest.Server
+	for i := 0; i < 2; i++ {
+		m := newRedisMirage(t, addr)
+		if err := m.Runner().Launch(ctx, "pr-1", mirageecs.TaskParameter{"branch": "develop"}, nil, "app:1"); err != nil {
+			t.Fatal(err)
+		}
+		ts := httptest.NewServer(m.WebApi)
+		defer ts.Close()
+		servers = append(servers, ts)
+	}
+	post := func(ts *httptest.Server, body string) (int, *mirageecs.APITerminateAllResponse) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/terminate_all", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("x-mirage-token", "user")
+		req.Header.Set("x-mirage-admin-token", "admin")
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var r mirageecs.APITerminateAllResponse
+		json.NewDecoder(res.Body).Decode(&r)
+		return res.StatusCode, &r
+	}
+
+	code, r := post(servers[0], `{}`)
+	if code != http.StatusOK || r.Token == "" {
+		t.Fatalf("unexpected confirmation: %d %#v", code, r)
+	}
+	// the confirmation is accepted by another replica
+	code, r2 := post(servers[1], `{"token":"`+r.Token+`"}`)
+	if code != http.StatusOK || len(r2.Subdomains) != 1 || r2.Subdomains[0] != "pr-1" {
+		t.Errorf("unexpected response of the confirmation: %d %#v", code, r2)
+	}
+	if code, _ := post(servers[0], `{"token":"`+r.Token+`"}`); code != http.StatusBadRequest {
+		t.Errorf("tokens can be used only once: %d", code)
+	}
+}
+
+func TestRedisSharedAccess(t *testing.T) {
+	ctx := context.Background()
+	addr := newFakeRedis(t)
+	m1, m2 := newRedisMirage(t, addr), newRedisMirage(t, addr)
+
+	m1.ReverseProxy.CountAccess("foo")
+	m1.ReverseProxy.AccessCounterOf("foo").Record(http.StatusOK, 30*time.Millisecond, false)
+	m2.ReverseProxy.AccessCounterOf("foo").Record(http.StatusBadGateway, 3*time.Millisecond, false)
+	last := m1.ReverseProxy.LastAccess("foo")
+	m1.ShareAccess(ctx)
+	m2.ShareAccess(ctx)
+	m1.ShareAccess(ctx)
+
+	for i, m := range []*mirageecs.Mirage{m1, m2} {
+		if got := m.ReverseProxy.LastAccess("foo"); !got.Equal(last) {
+			t.Errorf("replica %d: unexpected last access: %s, want %s", i, got, last)
+		}
+		stats := m.ReverseProxy.AccessStats("foo", time.Now().Add(-time.Hour))
+		if len(stats) != 1 || stats[0].Count != 2 || stats[0].Status["2xx"] != 1 || stats[0].Status["5xx"] != 1 {
+			t.Errorf("replica %d: unexpected access stats: %#v", i, stats)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// Refresh relaunches environments at the time of days, so they pick up the newest images of their task definitions.
+type Refresh struct {
+	// Tag is a key of the tag (or a name of the parameter). e.g. auto_refresh
+	Tag string `yaml:"tag"`
+	// Value is a value of the tag. Empty matches any value. e.g. nightly
+	Value string `yaml:"value"`
+	// Timezone is a timezone of At. Default is the local timezone.
+	Timezone string `yaml:"timezone"`
+	// At is the time to relaunch environments. e.g. "03:00"
+	At string `yaml:"at"`
+	// Weekdays are days to relaunch environments. Default is every day.
+	Weekdays []string `yaml:"weekdays"`
+	// LatestRevision relaunches environments with the latest revision of the family of their task definitions.
+	LatestRevision bool `yaml:"latest_revision"`
+
+	loc      *time.Location
+	at       time.Duration
+	weekdays map[time.Weekday]bool
+}
+
+func (r *Refresh) validate() error {
+	if r.Tag == "" {
+		return fmt.Errorf("refreshes[].tag is required")
+	}
+	var err error
+	r.loc = time.Local
+	if r.Timezone != "" {
+		if r.loc, err = time.LoadLocation(r.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone of refreshes[]: %w", err)
+		}
+	}
+	if r.at, err = parseClock(r.At); err != nil {
+		return fmt.Errorf("invalid at of refreshes[]: %w", err)
+	}
+	weekdays := r.Weekdays
+	if len(weekdays) == 0 {
+		weekdays = lo.Keys(weekdayNames)
+	}
+	r.weekdays = make(map[time.Weekday]bool, len(weekdays))
+	for _, d := range weekdays {
+		wd, ok := weekdayNames[strings.ToLower(d)]
+		if !ok {
+			return fmt.Errorf("invalid weekday of refreshes[]: %s", d)
+		}
+		r.weekdays[wd] = true
+	}
+	return nil
+}
+
+// lastRun returns the last time to relaunch environments at or before t.
+func (r *Refresh) lastRun(t time.Time) time.Time {
+	for i := 0; i <= 7; i++ {
+		day := t.In(r.loc).AddDate(0, 0, -i)
+		if !r.weekdays[day.Weekday()] {
+			continue
+		}
+		run := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, r.loc).Add(r.at)
+		if !run.After(t) {
+			return run
+		}
+	}
+	return time.Time{}
+}
+
+func (r *Refresh) match(info *Information) bool {
+	v := getTag(info.Tags, r.Tag)
+	if r.Value == "" {
+		return v != ""
+	}
+	return v == r.Value
+}
+
+// refreshCandidates returns environments to relaunch, which were launched before the last run of the matched rule.
+// Environments which are not ready (e.g. just relaunched) are skipped.
+func (c ECSCfg) refreshCandidates(running []*Information, now time.Time) map[string]*Refresh {
+	candidates := make(map[string]*Refresh)
+	skipped := make(map[string]struct{})
+	for _, info := range running {
+		if _, ok := skipped[info.SubDomain]; ok {
+			continue
+		}
+		r, ok := lo.Find(c.Refreshes, func(r *Refresh) bool { return r.match(info) })
+		if !ok || !info.Ready || !info.Created.Before(r.lastRun(now)) {
+			skipped[info.SubDomain] = struct{}{}
+			delete(candidates, info.SubDomain)
+			continue
+		}
+		candidates[info.SubDomain] = r
+	}
+	return candidates
+}
+
+// taskdefs returns task definitions to relaunch the environment.
+func (r *Refresh) taskdefs(infos []*Information) []string {
+	taskdefs := make([]string, 0, len(infos))
+	for _, info := range infos {
+		// the family of a registered revision may have revisions registered for other subdomains
+		td := info.baseTaskDef()
+		if r.LatestRevision {
+			// a family without revisions means the latest ACTIVE revision
+			td, _, _ = strings.Cut(td, ":")
+		}
+		taskdefs = append(taskdefs, td)
+	}
+	return lo.Uniq(taskdefs)
+}
+
+// applyRefreshes relaunches environments by refreshes with the same parameters and options.
+func (app *Mirage) applyRefreshes(ctx context.Context, running []*Information) {
+	cfg := app.Config.ECS
+	if len(cfg.Refreshes) == 0 {
+		return
+	}
+	now := time.Now()
+	for subdomain, r := range cfg.refreshCandidates(running, now) {
+		if t, ok := app.refreshed[subdomain]; ok && !t.Before(r.lastRun(now)) {
+			// tried already. failures are retried at the next run
+			continue
+		}
+		app.refreshed[subdomain] = now
+		infos := lo.Filter(running, func(info *Information, _ int) bool {
+			return info.SubDomain == subdomain
+		})
+		taskdefs := r.taskdefs(infos)
+		param := taskParameterFromTags(infos[0].Tags, app.Config.Parameter)
+		slog.Info(f("refreshing subdomain %s with taskdefs %v", subdomain, taskdefs))
+		if err := app.runner.Launch(ctx, subdomain, param, infos[0].Option, taskdefs...); err != nil {
+			slog.Warn(f("failed to refresh subdomain %s: %s", subdomain, err))
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package mirageecs_test
+
+import (
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRefreshValidate(t *testing.T) {
+	for _, r := range []*mirageecs.Refresh{
+		{At: "03:00"},
+		{Tag: "auto_refresh", At: "3am"},
+		{Tag: "auto_refresh", At: "03:00", Timezone: "Nowhere/Unknown"},
+		{Tag: "auto_refresh", At: "03:00", Weekdays: []string{"someday"}},
+	} {
+		if err := r.Validate(); err == nil {
+			t.Errorf("expected error: %#v", r)
+		}
+	}
+}
+
+func TestRefreshLastRun(t *testing.T) {
+	r := &mirageecs.Refresh{Tag: "auto_refresh", At: "03:00", Timezone: "Asia/Tokyo", Weekdays: []string{"mon", "wed"}}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	// 2024-01-03 is Wednesday
+	for _, c := range []struct {
+		now, expected time.Time
+	}{
+		{time.Date(2024, 1, 3, 3, 0, 0, 0, tokyo), time.Date(2024, 1, 3, 3, 0, 0, 0, tokyo)},
+		{time.Date(2024, 1, 3, 2, 59, 0, 0, tokyo), time.Date(2024, 1, 1, 3, 0, 0, 0, tokyo)},
+		{time.Date(2024, 1, 7, 12, 0, 0, 0, tokyo), time.Date(2024, 1, 3, 3, 0, 0, 0, tokyo)},
+	} {
+		if got := r.LastRun(c.now); !got.Equal(c.expected) {
+			t.Errorf("LastRun(%s) = %s, want %s", c.now, got, c.expected)
+		}
+	}
+}
+
+func TestRefreshCandidates(t *testing.T) {
+	r := &mirageecs.Refresh{Tag: "auto_refresh", Value: "nightly", At: "03:00", Timezone: "UTC"}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := mirageecs.ECSCfg{Refreshes: []*mirageecs.Refresh{r}}
+	now := time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC)
+	tags := []types.Tag{{Key: aws.String("auto_refresh"), Value: aws.String("nightly")}}
+	running := []*mirageecs.Information{
+		{SubDomain: "staging", TaskDef: "app:3", Tags: tags, Ready: true, Created: now.Add(-48 * time.Hour)},
+		{SubDomain: "staging", TaskDef: "worker:5", Tags: tags, Ready: true, Created: now.Add(-48 * time.Hour)},
+		{SubDomain: "refreshed", Tags: tags, Ready: true, Created: now.Add(-time.Hour)},
+		{SubDomain: "not-ready", Tags: tags, Ready: false, Created: now.Add(-48 * time.Hour)},
+		{SubDomain: "untagged", Ready: true, Created: now.Add(-48 * time.Hour)},
+	}
+	candidates := cfg.RefreshCandidates(running, now)
+	if len(candidates) != 1 || candidates["staging"] != r {
+		t.Errorf("unexpected candidates: %v", candidates)
+	}
+
+	if diff := cmp.Diff([]string{"app:3", "worker:5"}, r.Taskdefs(running[:2])); diff != "" {
+		t.Errorf("unexpected taskdefs (-want +got):\n%s", diff)
+	}
+	r.LatestRevision = true
+	if diff := cmp.Diff([]string{"app", "worker"}, r.Taskdefs(running[:2])); diff != "" {
+		t.Errorf("unexpected taskdefs (-want +got):\n%s", diff)
+	}
+
+	// registered revisions are refreshed by the task definitions specified at launch
+	registered := []*mirageecs.Information{
+		{SubDomain: "pr-1", TaskDef: "app-pr-1:2", Tags: append(tags, types.Tag{Key: aws.String(mirageecs.TagBaseTaskDefinition), Value: aws.String("")})},
+		{SubDomain: "pr-1", TaskDef: "app:8", Tags: append(tags, types.Tag{Key: aws.String(mirageecs.TagBaseTaskDefinition), Value: aws.String("app:3")})},
+	}
+	if diff := cmp.Diff([]string{"", "app"}, r.Taskdefs(registered)); diff != "" {
+		t.Errorf("unexpected taskdefs (-want +got):\n%s", diff)
+	}
+	r.LatestRevision = false
+	if diff := cmp.Diff([]string{"", "app:3"}, r.Taskdefs(registered)); diff != "" {
+		t.Errorf("unexpected taskdefs (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,181 @@
+package mirageecs
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+)
+
+const (
+	TagRelaunchHistory = "MirageRelaunchHistory"
+	TagRelaunchCount   = "MirageRelaunchCount"
+	TagRelaunchedFrom  = "MirageRelaunchedFrom"
+
+	DefaultRelaunchMaxRetries      = 3
+	DefaultRelaunchInitialInterval = 10 * time.Second
+	DefaultRelaunchMaxInterval     = 5 * time.Minute
+)
+
+// RelaunchOnFailure configures relaunching tasks which stopped unexpectedly.
+type RelaunchOnFailure struct {
+	MaxRetries      int           `yaml:"max_retries"`
+	InitialInterval time.Duration `yaml:"initial_interval"`
+	MaxInterval     time.Duration `yaml:"max_interval"`
+}
+
+func (r *RelaunchOnFailure) fillDefaults() {
+	if r == nil {
+		return
+	}
+	if r.MaxRetries <= 0 {
+		r.MaxRetries = DefaultRelaunchMaxRetries
+	}
+	if r.InitialInterval <= 0 {
+		r.InitialInterval = DefaultRelaunchInitialInterval
+	}
+	if r.MaxInterval <= 0 {
+		r.MaxInterval = DefaultRelaunchMaxInterval
+	}
+}
+
+func (r *RelaunchOnFailure) maxInterval() time.Duration {
+	if r == nil {
+		return 0
+	}
+	return r.MaxInterval
+}
+
+// backoff returns an interval before the retry-th relaunch (0 origin).
+func (r *RelaunchOnFailure) backoff(retry int) time.Duration {
+	d := r.InitialInterval
+	for i := 0; i < retry; i++ {
+		d *= 2
+		if d >= r.MaxInterval {
+			return r.MaxInterval
+		}
+	}
+	return min(d, r.MaxInterval)
+}
+
+// RelaunchRecord is a record of a relaunch caused by a task failure.
+type RelaunchRecord struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+}
+
+// encodeRelaunchHistory encodes the history to a tag value.
+// Old records are dropped to fit the limit of the tag value length.
+func encodeRelaunchHistory(history []RelaunchRecord) string {
+	var entries []string
+	length := 0
+	for i := len(history) - 1; i >= 0; i-- {
+		e := strconv.FormatInt(history[i].Time.Unix(), 10) + ":" + history[i].Reason
+		if length+len(e)+1 > maxTagValueLength {
+			break
+		}
+		length += len(e) + 1
+		entries = append([]string{e}, entries...)
+	}
+	return strings.Join(entries, " ")
+}
+
+func decodeRelaunchHistory(s string) []RelaunchRecord {
+	var history []RelaunchRecord
+	for _, e := range strings.Fields(s) {
+		ts, reason, ok := strings.Cut(e, ":")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			slog.Warn(f("invalid relaunch history %s", e))
+			continue
+		}
+		history = append(history, RelaunchRecord{Time: time.Unix(n, 0), Reason: reason})
+	}
+	return history
+}
+
+// failureReason returns a reason why the task stopped unexpectedly.
+// It returns an empty string when the task stopped normally (e.g. terminated by users).
+func failureReason(task *types.Task) string {
+	if task == nil {
+		return ""
+	}
+	switch task.StopCode {
+	case types.TaskStopCodeTaskFailedToStart:
+		return string(task.StopCode)
+	case types.TaskStopCodeEssentialContainerExited:
+	default:
+		return ""
+	}
+	for _, c := range task.Containers {
+		name := aws.ToString(c.Name)
+		if strings.Contains(aws.ToString(c.Reason), "OutOfMemory") {
+			return name + ":OutOfMemory"
+		}
+		if c.ExitCode != nil && *c.ExitCode != 0 {
+			return fmt.Sprintf("%s:exit=%d", name, *c.ExitCode)
+		}
+	}
+	return ""
+}
+
+// relaunchCountOf returns the number of relaunches of the task.
+// The history may be truncated, so the count is stored in a separate tag.
+func relaunchCountOf(tags []types.Tag, history []RelaunchRecord) int {
+	s := getTag(tags, TagRelaunchCount)
+	if s == "" {
+		return len(history)
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		slog.Warn(f("invalid relaunch count %s", s))
+		return len(history)
+	}
+	return n
+}
+
+// withRelaunchHistory returns tags of the task with a new relaunch record and the relaunch count.
+func withRelaunchHistory(tags []types.Tag, history []RelaunchRecord, count int) []types.Tag {
+	return withTags(tags, types.Tag{
+		Key:   aws.String(TagRelaunchHistory),
+		Value: aws.String(encodeRelaunchHistory(history)),
+	}, types.Tag{
+		Key:   aws.String(TagRelaunchCount),
+		Value: aws.String(strconv.Itoa(count)),
+	})
+}
+
+// withRelaunchedFrom returns tags of the task with a marker of the relaunched task.
+// The marker survives restarts of mirage-ecs, unlike the in-memory record.
+func withRelaunchedFrom(tags []types.Tag, shortID string) []types.Tag {
+	return withTags(tags, types.Tag{
+		Key:   aws.String(TagRelaunchedFrom),
+		Value: aws.String(shortID),
+	})
+}
+
+// withTags returns tags replaced or appended by the given tags.
+func withTags(tags []types.Tag, add ...types.Tag) []types.Tag {
+	newTags := make([]types.Tag, 0, len(tags)+len(add))
+	for _, t := range tags {
+		if lo.ContainsBy(add, func(a types.Tag) bool { return aws.ToString(a.Key) == aws.ToString(t.Key) }) {
+			continue
+		}
+		newTags = append(newTags, t)
+	}
+	return append(newTags, add...)
+}
+
+// relaunchKey identifies tasks which relaunch each other: the same task definition family of the subdomain.
+func (info *Information) relaunchKey() string {
+	family, _, _ := strings.Cut(info.TaskDef, ":")
+	return info.SubDomain + "/" + family
+}
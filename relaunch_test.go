@@ -0,0 +1,154 @@
+package mirageecs_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestRelaunchHistory(t *testing.T) {
+	history := []mirageecs.RelaunchRecord{
+		{Time: time.Unix(1700000000, 0), Reason: "app:exit=1"},
+		{Time: time.Unix(1700000100, 0), Reason: "app:OutOfMemory"},
+	}
+	s := mirageecs.EncodeRelaunchHistory(history)
+	if s != "1700000000:app:exit=1 1700000100:app:OutOfMemory" {
+		t.Errorf("unexpected encoded history: %s", s)
+	}
+	if diff := cmp.Diff(history, mirageecs.DecodeRelaunchHistory(s)); diff != "" {
+		t.Errorf("unexpected decoded history (-want +got):\n%s", diff)
+	}
+
+	var long []mirageecs.RelaunchRecord
+	for i := 0; i < 20; i++ {
+		long = append(long, mirageecs.RelaunchRecord{Time: time.Unix(1700000000+int64(i), 0), Reason: "application:exit=137"})
+	}
+	s = mirageecs.EncodeRelaunchHistory(long)
+	if len(s) > 256 {
+		t.Errorf("encoded history is too long: %d", len(s))
+	}
+	if !strings.HasSuffix(s, "1700000019:application:exit=137") {
+		t.Errorf("the last record must be kept: %s", s)
+	}
+}
+
+func TestRelaunchCount(t *testing.T) {
+	var long []mirageecs.RelaunchRecord
+	for i := 0; i < 20; i++ {
+		long = append(long, mirageecs.RelaunchRecord{Time: time.Unix(1700000000+int64(i), 0), Reason: "application:exit=137"})
+	}
+	tags := []types.Tag{{Key: aws.String("Subdomain"), Value: aws.String("foo")}}
+	tags = mirageecs.WithRelaunchHistory(tags, long, len(long))
+	history := mirageecs.DecodeRelaunchHistory(aws.ToString(tags[1].Value))
+	if len(history) >= len(long) {
+		t.Fatalf("history should be truncated: %d", len(history))
+	}
+	if got := mirageecs.RelaunchCountOf(tags, history); got != 20 {
+		t.Errorf("RelaunchCountOf() = %d, want 20", got)
+	}
+	// tasks relaunched before the count tag was introduced
+	if got := mirageecs.RelaunchCountOf(tags[:2], history); got != len(history) {
+		t.Errorf("RelaunchCountOf() without the count tag = %d, want %d", got, len(history))
+	}
+}
+
+func TestRelaunchedFrom(t *testing.T) {
+	tags := []types.Tag{
+		{Key: aws.String("Subdomain"), Value: aws.String("foo")},
+		{Key: aws.String(mirageecs.TagRelaunchedFrom), Value: aws.String("first")},
+	}
+	tags = mirageecs.WithRelaunchedFrom(tags, "second")
+	want := []types.Tag{
+		{Key: aws.String("Subdomain"), Value: aws.String("foo")},
+		{Key: aws.String(mirageecs.TagRelaunchedFrom), Value: aws.String("second")},
+	}
+	if diff := cmp.Diff(want, tags, cmpopts.IgnoreUnexported(types.Tag{})); diff != "" {
+		t.Errorf("unexpected tags (-want +got):\n%s", diff)
+	}
+}
+
+func TestRelaunchKey(t *testing.T) {
+	web := &mirageecs.Information{SubDomain: "foo", TaskDef: "web:12"}
+	relaunched := &mirageecs.Information{SubDomain: "foo", TaskDef: "web:13"}
+	worker := &mirageecs.Information{SubDomain: "foo", TaskDef: "worker:3"}
+	if web.RelaunchKey() != relaunched.RelaunchKey() {
+		t.Errorf("revisions of the same family should have the same key: %s %s", web.RelaunchKey(), relaunched.RelaunchKey())
+	}
+	if web.RelaunchKey() == worker.RelaunchKey() {
+		t.Errorf("sibling task definitions should have different keys: %s", web.RelaunchKey())
+	}
+}
+
+func TestRelaunchBackoff(t *testing.T) {
+	r := &mirageecs.RelaunchOnFailure{
+		MaxRetries:      5,
+		InitialInterval: 10 * time.Second,
+		MaxInterval:     time.Minute,
+	}
+	for i, want := range []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute} {
+		if got := r.Backoff(i); got != want {
+			t.Errorf("Backoff(%d) = %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	tests := []struct {
+		name string
+		task *types.Task
+		want string
+	}{
+		{
+			name: "terminated by user",
+			task: &types.Task{StopCode: types.TaskStopCodeUserInitiated},
+			want: "",
+		},
+		{
+			name: "exited normally",
+			task: &types.Task{
+				StopCode:   types.TaskStopCodeEssentialContainerExited,
+				Containers: []types.Container{{Name: aws.String("app"), ExitCode: aws.Int32(0)}},
+			},
+			want: "",
+		},
+		{
+			name: "exited with error",
+			task: &types.Task{
+				StopCode:   types.TaskStopCodeEssentialContainerExited,
+				Containers: []types.Container{{Name: aws.String("app"), ExitCode: aws.Int32(1)}},
+			},
+			want: "app:exit=1",
+		},
+		{
+			name: "out of memory",
+			task: &types.Task{
+				StopCode: types.TaskStopCodeEssentialContainerExited,
+				Containers: []types.Container{{
+					Name:     aws.String("app"),
+					ExitCode: aws.Int32(137),
+					Reason:   aws.String("OutOfMemoryError: Container killed due to memory usage"),
+				}},
+			},
+			want: "app:OutOfMemory",
+		},
+		{
+			name: "failed to start",
+			task: &types.Task{StopCode: types.TaskStopCodeTaskFailedToStart},
+			want: "TaskFailedToStart",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mirageecs.FailureReason(tt.task); got != tt.want {
+				t.Errorf("FailureReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package mirageecs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxRequestIDLength is the maximum length of X-Request-Id honored from clients.
+const maxRequestIDLength = 128
+
+// validRequestID matches request IDs which are safe to be logged and passed to environments.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:/=+\-]+$`)
+
+// newRequestID returns a random request ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand never fails on supported platforms
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// withRequestID honors X-Request-Id of the request, or generates a new one.
+// The ID is passed to environments by the request header, returned by the response header, and added to logs of the request.
+func withRequestID(w http.ResponseWriter, req *http.Request) *http.Request {
+	id := req.Header.Get(echo.HeaderXRequestID)
+	if len(id) > maxRequestIDLength || !validRequestID.MatchString(id) {
+		id = newRequestID()
+	}
+	req.Header.Set(echo.HeaderXRequestID, id)
+	w.Header().Set(echo.HeaderXRequestID, id)
+	return req.WithContext(withLogAttrs(req.Context(), slog.String("request_id", id)))
+}
+
+// withRequestIDMessage appends the request ID to the error message, so users can report it.
+func withRequestIDMessage(req *http.Request, msg string) string {
+	if id := req.Header.Get(echo.HeaderXRequestID); id != "" {
+		return fmt.Sprintf("%s\nrequest id: %s", msg, id)
+	}
+	return msg
+}
+
+// httpError responds the error message with the request ID.
+func httpError(w http.ResponseWriter, req *http.Request, msg string, code int) {
+	http.Error(w, withRequestIDMessage(req, msg), code)
+}
@@ -0,0 +1,103 @@
+package mirageecs_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestRequestID(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	tests := []struct {
+		name   string
+		url    string
+		header string
+		want   string // empty means a generated ID
+	}{
+		{name: "generated", url: "http://unknown.localtest.me/"},
+		{name: "honored", url: "http://unknown.localtest.me/", header: "req-0123:abc", want: "req-0123:abc"},
+		{name: "invalid", url: "http://unknown.localtest.me/", header: "<script>"},
+		{name: "too long", url: "http://unknown.localtest.me/", header: strings.Repeat("a", 129)},
+		{name: "web api", url: "http://mirage.localtest.me/api/list", header: "api-request", want: "api-request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.header != "" {
+				req.Header.Set("X-Request-Id", tt.header)
+			}
+			w := httptest.NewRecorder()
+			m.ServeHTTPWithPort(w, req, 80)
+			ids := w.Result().Header.Values("X-Request-Id")
+			if len(ids) != 1 {
+				t.Fatalf("unexpected X-Request-Id: %v", ids)
+			}
+			id := ids[0]
+			if tt.want != "" && id != tt.want {
+				t.Errorf("wanted request id %s, got %s", tt.want, id)
+			}
+			if tt.want == "" && !generated.MatchString(id) {
+				t.Errorf("request id should be generated: %s", id)
+			}
+			if w.Code == http.StatusNotFound && !strings.Contains(w.Body.String(), "request id: "+id) {
+				t.Errorf("error pages should have the request id: %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRoundTripRequestID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		// echo the request ID as applications do
+		w.Header().Set("X-Request-Id", r.Header.Get("X-Request-Id"))
+		io.WriteString(w, r.Header.Get("X-Request-Id"))
+	}))
+	defer server.Close()
+
+	tr := &mirageecs.Transport{
+		Counter:   mirageecs.NewAccessCounter(time.Second),
+		Transport: mirageecs.NewHTTPTransport(100 * time.Millisecond),
+		Subdomain: "test-subdomain",
+		AccessLog: true,
+	}
+	for _, p := range []string{"/", "/slow"} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+p, nil)
+		req.Header.Set("X-Request-Id", "req-1")
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		switch p {
+		case "/":
+			if string(body) != "req-1" {
+				t.Errorf("request id should be passed upstream: %q", body)
+			}
+			if v := resp.Header.Values("X-Request-Id"); len(v) != 0 {
+				t.Errorf("request id of upstream should be removed not to be duplicated: %v", v)
+			}
+		case "/slow":
+			if resp.StatusCode != http.StatusGatewayTimeout || !strings.Contains(string(body), "request id: req-1") {
+				t.Errorf("timeout response should have the request id: %d %q", resp.StatusCode, body)
+			}
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"path"
 	"strconv"
@@ -14,6 +15,7 @@ import (
 	"time"
 
 	//	"github.com/acidlemon/go-dumper"
+	"github.com/labstack/echo/v4"
 	"github.com/methane/rproxy"
 )
 
@@ -31,6 +33,7 @@ type proxyControl struct {
 	Subdomain string
 	IPAddress string
 	Port      int
+	Option    *LaunchOption
 }
 
 type ReverseProxy struct {
@@ -38,34 +41,43 @@ type ReverseProxy struct {
 	cfg               *Config
 	domains           []string
 	domainMap         map[string]proxyHandlers
+	portRoutes        map[string]string // port route -> subdomain
+	aliases           map[string]string // alias hostname -> subdomain
 	accessCounters    map[string]*AccessCounter
+	remoteAccess      map[string]*AccessCounter // access counters of other processes shared by redis
 	accessCounterUnit time.Duration
+	accessLogs        *accessLogExporter
 }
 
 func NewReverseProxy(cfg *Config) *ReverseProxy {
-	unit := time.Minute
+	unit := cfg.Network.AccessCounter.resolution(cfg.localMode)
 	if cfg.localMode {
-		unit = time.Second * 10
 		proxyHandlerLifetime = time.Hour * 24 * 365 * 10 // not expire
 		slog.Debug(f("local mode: access counter unit=%s", unit))
 	}
 	return &ReverseProxy{
 		cfg:               cfg,
 		domainMap:         make(map[string]proxyHandlers),
+		portRoutes:        make(map[string]string),
+		aliases:           make(map[string]string),
 		accessCounters:    make(map[string]*AccessCounter),
 		accessCounterUnit: unit,
+		accessLogs:        newAccessLogExporter(cfg),
 	}
 }
 
 func (r *ReverseProxy) ServeHTTPWithPort(w http.ResponseWriter, req *http.Request, port int) {
 	subdomain := strings.ToLower(strings.Split(req.Host, ".")[0])
+	r.serveSubdomain(w, req, subdomain, port)
+}
 
+func (r *ReverseProxy) serveSubdomain(w http.ResponseWriter, req *http.Request, subdomain string, port int) {
 	if handler := r.FindHandler(subdomain, port); handler != nil {
 		slog.Debug(f("proxy handler found for subdomain %s", subdomain))
 		handler.ServeHTTP(w, req)
 	} else {
-		slog.Debug(f("proxy handler not found for subdomain %s", subdomain))
-		http.NotFound(w, req)
+		slog.DebugContext(req.Context(), f("proxy handler not found for subdomain %s", subdomain))
+		httpError(w, req, "404 page not found", http.StatusNotFound)
 	}
 }
 
@@ -81,7 +93,8 @@ func (r *ReverseProxy) Exists(subdomain string) bool {
 			return true
 		}
 	}
-	return false
+	_, exists = r.matchPortRoute(subdomain)
+	return exists
 }
 
 func (r *ReverseProxy) Subdomains() []string {
@@ -105,6 +118,11 @@ func (r *ReverseProxy) FindHandler(subdomain string, port int) http.Handler {
 				break
 			}
 		}
+		if proxyHandlers == nil {
+			if route, ok := r.matchPortRoute(subdomain); ok {
+				proxyHandlers = r.domainMap[route]
+			}
+		}
 		if proxyHandlers == nil {
 			return nil
 		}
@@ -186,26 +204,24 @@ func (ph proxyHandlers) add(port int, ipaddress string, h http.Handler) {
 	ph[port][ipaddress] = newProxyHandler(h)
 }
 
-func (r *ReverseProxy) AddSubdomain(subdomain string, ipaddress string, targetPort int) {
+func (r *ReverseProxy) AddSubdomain(subdomain string, ipaddress string, targetPort int, opt *LaunchOption) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	addr := net.JoinHostPort(ipaddress, strconv.Itoa(targetPort))
 	slog.Debug(f("AddSubdomain %s -> %s", subdomain, addr))
 	var ph proxyHandlers
+	if _, exists := r.portRoutes[subdomain]; exists {
+		// subdomains are prior to port routes
+		slog.Warn(f("subdomain %s conflicts with the port route. the port route is removed", subdomain))
+		delete(r.portRoutes, subdomain)
+		delete(r.domainMap, subdomain)
+	}
 	if _ph, exists := r.domainMap[subdomain]; exists {
 		ph = _ph
 	} else {
 		ph = make(proxyHandlers)
 	}
 
-	var counter *AccessCounter
-	if c, exists := r.accessCounters[subdomain]; exists {
-		counter = c
-	} else {
-		counter = NewAccessCounter(r.accessCounterUnit)
-		r.accessCounters[subdomain] = counter
-	}
-
 	// create reverse proxy
 	proxy := false
 	for _, v := range r.cfg.Listen.HTTP {
@@ -217,22 +233,11 @@ func (r *ReverseProxy) AddSubdomain(subdomain string, ipaddress string, targetPo
 			proxy = true
 			continue
 		}
-		destUrlString := "http://" + addr
-		destUrl, err := url.Parse(destUrlString)
+		handler, err := r.newHandler(subdomain, addr, v, opt)
 		if err != nil {
-			slog.Error(f("invalid destination url: %s %s", destUrlString, err))
+			slog.Error(err.Error())
 			continue
 		}
-		handler := rproxy.NewSingleHostReverseProxy(destUrl)
-		tp := &Transport{
-			Transport: newHTTPTransport(r.cfg.Network.ProxyTimeout),
-			Counter:   counter,
-			Subdomain: subdomain,
-		}
-		if v.RequireAuthCookie {
-			tp.AuthCookieValidateFunc = r.cfg.Auth.ValidateAuthCookie
-		}
-		handler.Transport = tp
 		ph.add(v.ListenPort, addr, handler)
 		proxy = true
 		slog.Info(f("add subdomain: %s:%d -> %s", subdomain, v.ListenPort, addr))
@@ -251,12 +256,73 @@ func (r *ReverseProxy) AddSubdomain(subdomain string, ipaddress string, targetPo
 	r.domains = append(r.domains, subdomain)
 }
 
+// CountAccess counts an access to the subdomain which is not proxied by HTTP (e.g. TLS passthrough).
+func (r *ReverseProxy) CountAccess(subdomain string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.accessCounterFor(subdomain).Add()
+}
+
+// accessCounterFor returns the access counter of the subdomain. r.mu must be locked.
+func (r *ReverseProxy) accessCounterFor(subdomain string) *AccessCounter {
+	if c, exists := r.accessCounters[subdomain]; exists {
+		return c
+	}
+	c := r.newAccessCounter()
+	r.accessCounters[subdomain] = c
+	return c
+}
+
+func (r *ReverseProxy) newAccessCounter() *AccessCounter {
+	c := NewAccessCounter(r.accessCounterUnit)
+	c.retention = r.cfg.Network.AccessCounter.retention()
+	return c
+}
+
+// newHandler creates a proxy handler to addr for the subdomain listening on v. r.mu must be locked.
+func (r *ReverseProxy) newHandler(subdomain string, addr string, v PortMap, opt *LaunchOption) (http.Handler, error) {
+	destUrlString := "http://" + addr
+	destUrl, err := url.Parse(destUrlString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination url: %s %s", destUrlString, err)
+	}
+	var compressionEnabled *bool
+	if opt != nil {
+		compressionEnabled = opt.Compression
+	}
+	handler := rproxy.NewSingleHostReverseProxy(destUrl)
+	st := newStreaming(r.cfg.Network, v)
+	tp := &Transport{
+		Transport:        newHTTPTransport(r.cfg.Network.ProxyTimeout),
+		Counter:          r.accessCounterFor(subdomain),
+		Subdomain:        subdomain,
+		Compression:      r.cfg.Network.Compression.compressionFor(compressionEnabled),
+		Streaming:        st,
+		HealthCheckPaths: r.cfg.Network.HealthCheckPaths,
+		AccessLog:        r.cfg.Network.AccessLog,
+		AccessLogs:       r.accessLogs,
+		TrustedProxies:   r.cfg.Network.trustedProxies(),
+	}
+	if v.RequireAuthCookie {
+		tp.AuthCookieValidateFunc = r.cfg.Auth.ValidateAuthCookie
+	}
+	handler.Transport = tp
+	rewrites := r.cfg.Network.rewriteRulesFor(subdomain, opt)
+	h := newRewriteHandler(rewrites, newStreamingHandler(st, handler))
+	if opt.private() {
+		return r.cfg.Network.Private.handler(subdomain, h), nil
+	}
+	return h, nil
+}
+
 func (r *ReverseProxy) RemoveSubdomain(subdomain string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	slog.Info(f("removing subdomain: %s", subdomain))
 	delete(r.domainMap, subdomain)
 	delete(r.accessCounters, subdomain)
+	r.removePortRoutes(subdomain)
+	r.removeAliases(subdomain)
 	for i, name := range r.domains {
 		if name == subdomain {
 			r.domains = append(r.domains[:i], r.domains[i+1:]...)
@@ -268,7 +334,7 @@ func (r *ReverseProxy) RemoveSubdomain(subdomain string) {
 func (r *ReverseProxy) Modify(action *proxyControl) {
 	switch action.Action {
 	case proxyAdd:
-		r.AddSubdomain(action.Subdomain, action.IPAddress, action.Port)
+		r.AddSubdomain(action.Subdomain, action.IPAddress, action.Port, action.Option)
 	case proxyRemove:
 		r.RemoveSubdomain(action.Subdomain)
 	default:
@@ -276,6 +342,40 @@ func (r *ReverseProxy) Modify(action *proxyControl) {
 	}
 }
 
+// LastAccess returns the time of the last access to the subdomain by this process and other processes shared by redis.
+func (r *ReverseProxy) LastAccess(subdomain string) time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var last time.Time
+	if c, exists := r.accessCounters[subdomain]; exists {
+		last = c.Last()
+	}
+	if c, exists := r.remoteAccess[subdomain]; exists && c.Last().After(last) {
+		last = c.Last()
+	}
+	return last
+}
+
+// AccessStats returns statistics of responses of the subdomain by this process and other processes shared by redis since the time.
+func (r *ReverseProxy) AccessStats(subdomain string, since time.Time) []*AccessStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	local, exists := r.accessCounters[subdomain]
+	remote, shared := r.remoteAccess[subdomain]
+	switch {
+	case !shared && !exists:
+		return nil
+	case !shared:
+		return local.Stats(since)
+	case !exists:
+		return remote.Stats(since)
+	}
+	c := r.newAccessCounter()
+	c.add(local.snapshot())
+	c.add(remote.snapshot())
+	return c.Stats(since)
+}
+
 func (r *ReverseProxy) CollectAccessCounts() map[string]accessCount {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -302,48 +402,162 @@ type Transport struct {
 	Transport              http.RoundTripper
 	Subdomain              string
 	AuthCookieValidateFunc func(*http.Cookie) error
+	Compression            *Compression
+	Streaming              *streaming
+	HealthCheckPaths       []string
+	AccessLog              bool
+	// AccessLogs exports access logs by network.access_log_export.
+	AccessLogs *accessLogExporter
+	// TrustedProxies are CIDRs of proxies whose X-Forwarded-For entries are used for client addresses of access logs.
+	TrustedProxies []netip.Prefix
 }
 
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
-	t.Counter.Add()
-
-	slog.Debug(f("subdomain %s %s roundtrip", t.Subdomain, req.URL))
-	// OPTIONS request is not authenticated because it is preflighted.
-	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Access_control_CORS#Preflighted_requests
-	if t.AuthCookieValidateFunc != nil && req.Method != http.MethodOptions {
-		slog.Debug(f("subdomain %s %s roundtrip: require auth cookie", t.Subdomain, req.URL))
-		cookie, err := req.Cookie(AuthCookieName)
-		if err != nil || cookie == nil {
-			slog.Warn(f("subdomain %s %s roundtrip failed: %s", t.Subdomain, req.URL, err))
-			return newForbiddenResponse(), nil
-		}
-		if err := t.AuthCookieValidateFunc(cookie); err != nil {
-			slog.Warn(f("subdomain %s %s roundtrip failed: %s", t.Subdomain, req.URL, err))
-			return newForbiddenResponse(), nil
+	start := time.Now()
+	// health checks (e.g. by monitoring) are not activities of users
+	healthCheck := isHealthCheckPath(t.HealthCheckPaths, req.URL.Path)
+	if !healthCheck {
+		t.Counter.Add()
+	}
+	resp, err := t.roundTrip(req)
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	latency := time.Since(start)
+	t.Counter.Record(status, latency, healthCheck)
+	if t.AccessLog {
+		t.logAccess(req, status, latency, err)
+	}
+	if t.AccessLogs != nil {
+		t.AccessLogs.put(newAccessLogRecord(t.Subdomain, req, remoteIP(req, t.TrustedProxies), status, start, latency, healthCheck, err))
+	}
+	return resp, err
+}
+
+// logAccess logs the request proxied to the environment, in the same format as access logs of the API.
+func (t *Transport) logAccess(req *http.Request, status int, latency time.Duration, err error) {
+	level := slog.LevelInfo
+	if status == 0 || status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	attrs := []slog.Attr{
+		slog.String("subdomain", t.Subdomain),
+		slog.String("method", req.Method),
+		slog.String("uri", req.URL.RequestURI()),
+		slog.Int("status", status),
+		slog.Duration("latency", latency),
+		slog.String("remote_ip", remoteIP(req, t.TrustedProxies)),
+		slog.String("user_agent", req.UserAgent()),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	slog.LogAttrs(req.Context(), level, "access", attrs...)
+}
+
+func (t *Transport) roundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	slog.DebugContext(ctx, f("subdomain %s %s roundtrip", t.Subdomain, req.URL))
+	if t.AuthCookieValidateFunc != nil {
+		slog.DebugContext(ctx, f("subdomain %s %s roundtrip: require auth cookie", t.Subdomain, req.URL))
+		if err := validateAuthCookie(req, t.AuthCookieValidateFunc); err != nil {
+			slog.WarnContext(ctx, f("subdomain %s %s roundtrip failed: %s", t.Subdomain, req.URL, err))
+			return newForbiddenResponse(req), nil
 		}
 	}
 	resp, err := t.Transport.RoundTrip(req)
 	if err != nil {
-		slog.Warn(f("subdomain %s %s roundtrip failed: %s", t.Subdomain, req.URL, err))
+		slog.WarnContext(ctx, f("subdomain %s %s roundtrip failed: %s", t.Subdomain, req.URL, err))
 		if strings.Contains(err.Error(), "timeout") {
-			return newTimeoutResponse(t.Subdomain, req.URL.String(), err), nil
+			return newTimeoutResponse(t.Subdomain, req, err), nil
 		}
 		return nil, err
 	}
-	return resp, nil
+	// the request ID of mirage-ecs is in the response already
+	resp.Header.Del(echo.HeaderXRequestID)
+	if t.Streaming.match(resp.Header) {
+		// streaming responses must not be buffered by compression
+		return resp, nil
+	}
+	return t.Compression.Compress(req, resp), nil
 }
 
-func newTimeoutResponse(subdomain string, u string, err error) *http.Response {
+// validateAuthCookie validates the auth cookie of the request.
+// OPTIONS request is not authenticated because it is preflighted.
+// https://developer.mozilla.org/en-US/docs/Web/HTTP/Access_control_CORS#Preflighted_requests
+func validateAuthCookie(req *http.Request, validate func(*http.Cookie) error) error {
+	if req.Method == http.MethodOptions {
+		return nil
+	}
+	cookie, err := req.Cookie(AuthCookieName)
+	if err != nil {
+		return err
+	}
+	return validate(cookie)
+}
+
+func newTimeoutResponse(subdomain string, req *http.Request, err error) *http.Response {
 	resp := new(http.Response)
 	resp.StatusCode = http.StatusGatewayTimeout
-	msg := fmt.Sprintf("%s upstream timeout: %s %s", subdomain, u, err.Error())
-	resp.Body = io.NopCloser(strings.NewReader(msg))
+	msg := fmt.Sprintf("%s upstream timeout: %s %s", subdomain, req.URL, err.Error())
+	resp.Body = io.NopCloser(strings.NewReader(withRequestIDMessage(req, msg)))
 	return resp
 }
 
-func newForbiddenResponse() *http.Response {
+func newForbiddenResponse(req *http.Request) *http.Response {
 	resp := new(http.Response)
 	resp.StatusCode = http.StatusForbidden
-	resp.Body = io.NopCloser(strings.NewReader("Forbidden"))
+	resp.Body = io.NopCloser(strings.NewReader(withRequestIDMessage(req, "Forbidden")))
 	return resp
 }
+
+// accessSnapshots returns snapshots of access counters of all subdomains.
+func (r *ReverseProxy) accessSnapshots() map[string]*accessCounterSnapshot {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	snapshots := make(map[string]*accessCounterSnapshot, len(r.accessCounters))
+	for subdomain, counter := range r.accessCounters {
+		snapshots[subdomain] = counter.snapshot()
+	}
+	return snapshots
+}
+
+// restoreAccess restores the access counter of the subdomain from the snapshot.
+func (r *ReverseProxy) restoreAccess(subdomain string, s *accessCounterSnapshot) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.accessCounterFor(subdomain).restore(s)
+}
+
+// pruneAccessCounters removes access counters of subdomains which are not available,
+// e.g. restored from the persistence for environments which have gone.
+func (r *ReverseProxy) pruneAccessCounters(available map[string]bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for subdomain := range r.accessCounters {
+		if !available[subdomain] {
+			delete(r.accessCounters, subdomain)
+		}
+	}
+}
+
+// drainAccess returns changes of access counters since the last drain, to share them with other processes.
+func (r *ReverseProxy) drainAccess() map[string]*accessCounterSnapshot {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	snapshots := make(map[string]*accessCounterSnapshot, len(r.accessCounters))
+	for subdomain, counter := range r.accessCounters {
+		if s := counter.drain(); s != nil {
+			snapshots[subdomain] = s
+		}
+	}
+	return snapshots
+}
+
+// setRemoteAccess replaces access counters of other processes.
+func (r *ReverseProxy) setRemoteAccess(counters map[string]*AccessCounter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remoteAccess = counters
+}
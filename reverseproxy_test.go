@@ -26,9 +26,9 @@ func TestReverseProxy(t *testing.T) {
 	if ds := rp.Subdomains(); len(ds) != 0 {
 		t.Errorf("invalid subdomains %#v", ds)
 	}
-	rp.AddSubdomain("bbb", "192.168.1.2", 80)
-	rp.AddSubdomain("aaa", "192.168.1.1", 80)
-	rp.AddSubdomain("ccc", "192.168.1.3", 80)
+	rp.AddSubdomain("bbb", "192.168.1.2", 80, nil)
+	rp.AddSubdomain("aaa", "192.168.1.1", 80, nil)
+	rp.AddSubdomain("ccc", "192.168.1.3", 80, nil)
 	if diff := cmp.Diff(rp.Subdomains(), []string{"bbb", "aaa", "ccc"}); diff != "" {
 		t.Errorf("invalid subdomains %s", diff)
 	}
@@ -39,13 +39,13 @@ func TestReverseProxy(t *testing.T) {
 	}
 
 	// add same subdomain
-	rp.AddSubdomain("aaa", "192.168.1.1", 80)
+	rp.AddSubdomain("aaa", "192.168.1.1", 80, nil)
 	if diff := cmp.Diff(rp.Subdomains(), []string{"bbb", "aaa", "ccc"}); diff != "" {
 		t.Errorf("after added same: invalid subdomains %s", diff)
 	}
 
 	// add same subdomain with different port
-	rp.AddSubdomain("aaa", "192.168.1.1", 8080)
+	rp.AddSubdomain("aaa", "192.168.1.1", 8080, nil)
 	if diff := cmp.Diff(rp.Subdomains(), []string{"bbb", "aaa", "ccc"}); diff != "" {
 		t.Errorf("after added same with different port: invalid subdomains %s", diff)
 	}
@@ -64,9 +64,9 @@ func TestReverseProxy(t *testing.T) {
 	}
 
 	// wildcard
-	rp.AddSubdomain("foo-*", "10.0.0.1", 80)
-	rp.AddSubdomain("foo-bar-*", "10.0.0.2", 80)
-	rp.AddSubdomain("*-baz", "10.0.0.3", 80)
+	rp.AddSubdomain("foo-*", "10.0.0.1", 80, nil)
+	rp.AddSubdomain("foo-bar-*", "10.0.0.2", 80, nil)
+	rp.AddSubdomain("*-baz", "10.0.0.3", 80, nil)
 	for _, name := range []string{"foo-111", "foo-bar-222", "111-baz"} {
 		if !rp.Exists(name) {
 			t.Errorf("subdomain %s not found", name)
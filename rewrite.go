@@ -0,0 +1,124 @@
+package mirageecs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"regexp"
+)
+
+// RewriteRule rewrites or redirects a request path before forwarding to the task.
+type RewriteRule struct {
+	Match    string `yaml:"match" json:"match"`
+	Replace  string `yaml:"replace" json:"replace"`
+	Redirect int    `yaml:"redirect,omitempty" json:"redirect,omitempty"`
+
+	re *regexp.Regexp
+}
+
+func (r *RewriteRule) compile() error {
+	re, err := regexp.Compile(r.Match)
+	if err != nil {
+		return fmt.Errorf("invalid rewrite match %s: %w", r.Match, err)
+	}
+	if r.Redirect != 0 && (r.Redirect < 300 || r.Redirect > 399) {
+		return fmt.Errorf("invalid rewrite redirect status %d", r.Redirect)
+	}
+	r.re = re
+	return nil
+}
+
+type RewriteRules []*RewriteRule
+
+func (rs RewriteRules) compile() error {
+	for _, r := range rs {
+		if err := r.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SubdomainRewrite is a set of rewrite rules applied to subdomains matched with the pattern.
+type SubdomainRewrite struct {
+	Subdomain string       `yaml:"subdomain"`
+	Rules     RewriteRules `yaml:"rules"`
+}
+
+// rewriteRulesFor returns rewrite rules for the subdomain.
+// Rules specified at launch are prior to rules in the config.
+func (n Network) rewriteRulesFor(subdomain string, opt *LaunchOption) RewriteRules {
+	var rules RewriteRules
+	if opt != nil {
+		rules = append(rules, opt.Rewrites...)
+	}
+	for _, r := range n.Rewrites {
+		if m, _ := path.Match(r.Subdomain, subdomain); m {
+			rules = append(rules, r.Rules...)
+		}
+	}
+	return rules
+}
+
+func encodeRewriteRules(rs RewriteRules) (string, error) {
+	b, err := json.Marshal(rs)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+func decodeRewriteRules(s string) (RewriteRules, error) {
+	b, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var rs RewriteRules
+	if err := json.Unmarshal(b, &rs); err != nil {
+		return nil, err
+	}
+	if err := rs.compile(); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+type rewriteHandler struct {
+	rules RewriteRules
+	next  http.Handler
+}
+
+func newRewriteHandler(rules RewriteRules, next http.Handler) http.Handler {
+	if len(rules) == 0 {
+		return next
+	}
+	return &rewriteHandler{rules: rules, next: next}
+}
+
+func (h *rewriteHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, r := range h.rules {
+		if r.re == nil || !r.re.MatchString(req.URL.Path) {
+			continue
+		}
+		p := r.re.ReplaceAllString(req.URL.Path, r.Replace)
+		if p == "" {
+			p = "/"
+		}
+		if r.Redirect != 0 {
+			u := *req.URL
+			u.Path = p
+			u.RawPath = ""
+			slog.Debug(f("redirect %s -> %s", req.URL.Path, u.RequestURI()))
+			http.Redirect(w, req, u.RequestURI(), r.Redirect)
+			return
+		}
+		slog.Debug(f("rewrite %s -> %s", req.URL.Path, p))
+		req.URL.Path = p
+		req.URL.RawPath = ""
+		break
+	}
+	h.next.ServeHTTP(w, req)
+}
@@ -0,0 +1,81 @@
+package mirageecs_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestRewrite(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.RequestURI())
+	}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	cfg, err := mirageecs.NewConfig(context.Background(), &mirageecs.ConfigParams{
+		Domain: "example.net",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Listen.HTTP = []mirageecs.PortMap{
+		{ListenPort: 80, TargetPort: port},
+	}
+	configRules := mirageecs.RewriteRules{
+		{Match: "^/$", Replace: "/app/", Redirect: http.StatusFound},
+	}
+	if err := configRules.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Network.Rewrites = []*mirageecs.SubdomainRewrite{
+		{Subdomain: "app-*", Rules: configRules},
+	}
+	launchRules := mirageecs.RewriteRules{
+		{Match: "^/api(/.*)?$", Replace: "$1"},
+	}
+	if err := launchRules.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	rp := mirageecs.NewReverseProxy(cfg)
+	rp.AddSubdomain("app-1", "127.0.0.1", port, &mirageecs.LaunchOption{Rewrites: launchRules})
+
+	tests := []struct {
+		path         string
+		wantStatus   int
+		wantBody     string
+		wantLocation string
+	}{
+		{path: "/api/users?id=1", wantStatus: http.StatusOK, wantBody: "/users?id=1"},
+		{path: "/api", wantStatus: http.StatusOK, wantBody: "/"},
+		{path: "/", wantStatus: http.StatusFound, wantLocation: "/app/"},
+		{path: "/other", wantStatus: http.StatusOK, wantBody: "/other"},
+	}
+	h := rp.FindHandler("app-1", 80)
+	if h == nil {
+		t.Fatal("handler not found")
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://app-1.example.net"+tt.path, nil)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("wanted status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("wanted body %q, got %q", tt.wantBody, w.Body.String())
+			}
+			if loc := w.Header().Get("Location"); loc != tt.wantLocation {
+				t.Errorf("wanted location %q, got %q", tt.wantLocation, loc)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net/netip"
 	"time"
 
 	ttlcache "github.com/ReneKroon/ttlcache/v2"
@@ -20,6 +21,11 @@ type Route53 struct {
 	cache        *ttlcache.Cache
 }
 
+type route53RecordKey struct {
+	name string
+	typ  types.RRType
+}
+
 type route53Change struct {
 	name   string
 	value  string
@@ -30,6 +36,14 @@ func (c *route53Change) String() string {
 	return fmt.Sprintf("%s %s %s", c.action(), c.name, c.value)
 }
 
+// recordType returns AAAA for IPv6 addresses, A for others.
+func (c *route53Change) recordType() types.RRType {
+	if addr, err := netip.ParseAddr(c.value); err == nil && addr.Is6() && !addr.Is4In6() {
+		return types.RRTypeAaaa
+	}
+	return types.RRTypeA
+}
+
 func (c *route53Change) action() string {
 	if c.delete {
 		return "DELTETE"
@@ -109,23 +123,24 @@ func (r *Route53) Apply(ctx context.Context) error {
 		r.changes = r.changes[0:0]
 	}()
 
-	addes := make(map[string][]*route53Change)
-	deletes := make(map[string][]*route53Change)
+	addes := make(map[route53RecordKey][]*route53Change)
+	deletes := make(map[route53RecordKey][]*route53Change)
 	for _, c := range r.changes {
+		key := route53RecordKey{name: c.name, typ: c.recordType()}
 		if c.delete {
-			deletes[c.name] = append(deletes[c.name], c)
+			deletes[key] = append(deletes[key], c)
 		} else {
-			addes[c.name] = append(addes[c.name], c)
+			addes[key] = append(addes[key], c)
 		}
 	}
 
-	// sum by name
+	// sum by name and type
 	var changes []types.Change
 DELETES:
-	for name, cs := range deletes {
+	for key, cs := range deletes {
 		var records []types.ResourceRecord
 		for _, c := range cs {
-			if len(addes[c.name]) > 0 {
+			if len(addes[key]) > 0 {
 				continue DELETES // skip delete when adds exists
 			}
 			records = append(records, types.ResourceRecord{Value: &c.value})
@@ -133,16 +148,16 @@ DELETES:
 		change := types.Change{
 			Action: "DELETE",
 			ResourceRecordSet: &types.ResourceRecordSet{
-				Name:            aws.String(name),
+				Name:            aws.String(key.name),
 				ResourceRecords: records,
 				TTL:             aws.Int64(60),
-				Type:            types.RRTypeA,
+				Type:            key.typ,
 			},
 		}
 		changes = append(changes, change)
 		slog.Info(f("route53 change: %v", change))
 	}
-	for name, cs := range addes {
+	for key, cs := range addes {
 		var records []types.ResourceRecord
 		for _, c := range cs {
 			records = append(records, types.ResourceRecord{Value: &c.value})
@@ -150,10 +165,10 @@ DELETES:
 		change := types.Change{
 			Action: "UPSERT",
 			ResourceRecordSet: &types.ResourceRecordSet{
-				Name:            aws.String(name),
+				Name:            aws.String(key.name),
 				ResourceRecords: records,
 				TTL:             aws.Int64(60),
-				Type:            types.RRTypeA,
+				Type:            key.typ,
 			},
 		}
 		changes = append(changes, change)
@@ -0,0 +1,254 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+const (
+	TagSchedule = "MirageSchedule"
+
+	// ScheduleNone is a schedule option to opt out of schedules matched by tags.
+	ScheduleNone = "none"
+
+	scheduleReasonPrefix = "schedule:"
+)
+
+var DefaultScheduleWeekdays = []string{"mon", "tue", "wed", "thu", "fri"}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Schedule stops environments outside working hours and resumes them with the same parameters in working hours.
+// Environments are matched by the tag (or the parameter), or by the schedule option at launch.
+type Schedule struct {
+	// Name is a name of the schedule.
+	Name string `yaml:"name"`
+	// Tag is a key of the tag (or a name of the parameter). Empty matches only environments launched with schedule=<name>.
+	Tag string `yaml:"tag"`
+	// Value is a value of the tag. Empty matches any value.
+	Value string `yaml:"value"`
+	// Timezone is a timezone of working hours. e.g. Asia/Tokyo. Default is the local timezone.
+	Timezone string `yaml:"timezone"`
+	// Start is the time to resume environments. e.g. "09:00"
+	Start string `yaml:"start"`
+	// Stop is the time to stop environments. e.g. "20:00"
+	Stop string `yaml:"stop"`
+	// Weekdays are working days. Default is mon to fri.
+	Weekdays []string `yaml:"weekdays"`
+
+	loc      *time.Location
+	start    time.Duration
+	stop     time.Duration
+	weekdays map[time.Weekday]bool
+}
+
+func (s *Schedule) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("schedules[].name is required")
+	}
+	if s.Name == ScheduleNone {
+		return fmt.Errorf("schedule name %s is reserved", ScheduleNone)
+	}
+	var err error
+	s.loc = time.Local
+	if s.Timezone != "" {
+		if s.loc, err = time.LoadLocation(s.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone of schedule %s: %w", s.Name, err)
+		}
+	}
+	if s.start, err = parseClock(s.Start); err != nil {
+		return fmt.Errorf("invalid start of schedule %s: %w", s.Name, err)
+	}
+	if s.stop, err = parseClock(s.Stop); err != nil {
+		return fmt.Errorf("invalid stop of schedule %s: %w", s.Name, err)
+	}
+	if s.start >= s.stop {
+		return fmt.Errorf("start of schedule %s must be before stop: %s - %s", s.Name, s.Start, s.Stop)
+	}
+	if len(s.Weekdays) == 0 {
+		s.Weekdays = DefaultScheduleWeekdays
+	}
+	s.weekdays = make(map[time.Weekday]bool, len(s.Weekdays))
+	for _, d := range s.Weekdays {
+		wd, ok := weekdayNames[strings.ToLower(d)]
+		if !ok {
+			return fmt.Errorf("invalid weekday of schedule %s: %s", s.Name, d)
+		}
+		s.weekdays[wd] = true
+	}
+	return nil
+}
+
+// parseClock parses "15:04" as a duration from midnight.
+func parseClock(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func validateSchedules(schedules []*Schedule) error {
+	names := make(map[string]struct{}, len(schedules))
+	for _, s := range schedules {
+		if err := s.validate(); err != nil {
+			return err
+		}
+		if _, ok := names[s.Name]; ok {
+			return fmt.Errorf("duplicated schedule name: %s", s.Name)
+		}
+		names[s.Name] = struct{}{}
+	}
+	return nil
+}
+
+// at returns the time of the clock on the day of t.
+func (s *Schedule) at(t time.Time, clock time.Duration) time.Time {
+	t = t.In(s.loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.loc).Add(clock)
+}
+
+// active reports whether t is in working hours.
+func (s *Schedule) active(t time.Time) bool {
+	t = t.In(s.loc)
+	if !s.weekdays[t.Weekday()] {
+		return false
+	}
+	return !t.Before(s.at(t, s.start)) && t.Before(s.at(t, s.stop))
+}
+
+// lastStop returns the last time environments were stopped at or before t.
+func (s *Schedule) lastStop(t time.Time) time.Time {
+	for i := 0; i <= 7; i++ {
+		day := t.In(s.loc).AddDate(0, 0, -i)
+		if !s.weekdays[day.Weekday()] {
+			continue
+		}
+		if stop := s.at(day, s.stop); !stop.After(t) {
+			return stop
+		}
+	}
+	return time.Time{}
+}
+
+// nextStart returns the next time environments are resumed after t.
+func (s *Schedule) nextStart(t time.Time) time.Time {
+	for i := 0; i <= 7; i++ {
+		day := t.In(s.loc).AddDate(0, 0, i)
+		if !s.weekdays[day.Weekday()] {
+			continue
+		}
+		if start := s.at(day, s.start); start.After(t) {
+			return start
+		}
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) reason() string {
+	return scheduleReasonPrefix + s.Name
+}
+
+func (s *Schedule) match(tags []types.Tag, opt *LaunchOption) bool {
+	if opt != nil && opt.Schedule != "" {
+		return opt.Schedule == s.Name
+	}
+	if s.Tag == "" {
+		return false
+	}
+	v := getTag(tags, s.Tag)
+	if s.Value == "" {
+		return v != ""
+	}
+	return v == s.Value
+}
+
+// scheduleFor returns the first schedule matched with the environment.
+func (c ECSCfg) scheduleFor(info *Information) (*Schedule, bool) {
+	for _, s := range c.Schedules {
+		if s.match(info.Tags, info.Option) {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// scheduleOf returns the schedule which put the environment to sleep for the reason.
+func (c ECSCfg) scheduleOf(reason string) (*Schedule, bool) {
+	if !strings.HasPrefix(reason, scheduleReasonPrefix) {
+		return nil, false
+	}
+	name := strings.TrimPrefix(reason, scheduleReasonPrefix)
+	for _, s := range c.Schedules {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// validateScheduleOption validates the schedule specified at launch. "none" opts out of schedules matched by tags.
+func (c ECSCfg) validateScheduleOption(name string) error {
+	if name == "" || name == ScheduleNone {
+		return nil
+	}
+	for _, s := range c.Schedules {
+		if s.Name == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("schedule %s is not found", name)
+}
+
+// applySchedules puts environments to sleep when working hours end, and wakes them when working hours start.
+// Environments launched after working hours are kept running until the next end of working hours.
+func (app *Mirage) applySchedules(ctx context.Context, running, sleeping []*Information) {
+	cfg := app.Config.ECS
+	if len(cfg.Schedules) == 0 {
+		return
+	}
+	now := time.Now()
+	done := make(map[string]struct{})
+	for _, info := range running {
+		if _, ok := done[info.SubDomain]; ok || info.Protected || !info.Ready {
+			continue
+		}
+		s, ok := cfg.scheduleFor(info)
+		if !ok || s.active(now) || info.Created.After(s.lastStop(now)) {
+			continue
+		}
+		done[info.SubDomain] = struct{}{}
+		if err := app.runner.Sleep(ctx, info.SubDomain, s.reason()); err != nil {
+			slog.Warn(f("failed to stop subdomain %s by schedule %s: %s", info.SubDomain, s.Name, err))
+		}
+	}
+	for _, info := range sleeping {
+		if _, ok := done[info.SubDomain]; ok {
+			continue
+		}
+		if !strings.HasPrefix(info.SleepReason, scheduleReasonPrefix) {
+			continue
+		}
+		// environments of removed schedules are resumed
+		if s, ok := cfg.scheduleOf(info.SleepReason); ok && !s.active(now) {
+			continue
+		}
+		done[info.SubDomain] = struct{}{}
+		if err := app.runner.Wake(ctx, info.SubDomain); err != nil {
+			slog.Warn(f("failed to resume subdomain %s (%s): %s", info.SubDomain, info.SleepReason, err))
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package mirageecs_test
+
+import (
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestScheduleValidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		schedule mirageecs.Schedule
+		ok       bool
+	}{
+		{"valid", mirageecs.Schedule{Name: "office-hours", Start: "09:00", Stop: "20:00"}, true},
+		{"weekdays", mirageecs.Schedule{Name: "office-hours", Start: "09:00", Stop: "20:00", Weekdays: []string{"Mon", "sat"}}, true},
+		{"no name", mirageecs.Schedule{Start: "09:00", Stop: "20:00"}, false},
+		{"reserved name", mirageecs.Schedule{Name: "none", Start: "09:00", Stop: "20:00"}, false},
+		{"invalid start", mirageecs.Schedule{Name: "office-hours", Start: "9am", Stop: "20:00"}, false},
+		{"invalid stop", mirageecs.Schedule{Name: "office-hours", Start: "09:00", Stop: "25:00"}, false},
+		{"start after stop", mirageecs.Schedule{Name: "office-hours", Start: "20:00", Stop: "09:00"}, false},
+		{"invalid weekday", mirageecs.Schedule{Name: "office-hours", Start: "09:00", Stop: "20:00", Weekdays: []string{"monday"}}, false},
+		{"invalid timezone", mirageecs.Schedule{Name: "office-hours", Start: "09:00", Stop: "20:00", Timezone: "Mars/Olympus"}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.schedule.Validate()
+			if c.ok && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if !c.ok && err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestValidateSchedulesDuplicated(t *testing.T) {
+	err := mirageecs.ValidateSchedules([]*mirageecs.Schedule{
+		{Name: "office-hours", Start: "09:00", Stop: "20:00"},
+		{Name: "office-hours", Start: "10:00", Stop: "19:00"},
+	})
+	if err == nil {
+		t.Error("expected error for duplicated names")
+	}
+}
+
+func TestScheduleHours(t *testing.T) {
+	s := &mirageecs.Schedule{Name: "office-hours", Timezone: "Asia/Tokyo", Start: "09:00", Stop: "20:00"}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	jst, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2024-01-05 is Friday
+	cases := []struct {
+		name      string
+		now       time.Time
+		active    bool
+		lastStop  time.Time
+		nextStart time.Time
+	}{
+		{
+			name:      "friday working hours",
+			now:       time.Date(2024, 1, 5, 10, 0, 0, 0, jst),
+			active:    true,
+			lastStop:  time.Date(2024, 1, 4, 20, 0, 0, 0, jst),
+			nextStart: time.Date(2024, 1, 8, 9, 0, 0, 0, jst),
+		},
+		{
+			name:      "friday night",
+			now:       time.Date(2024, 1, 5, 21, 0, 0, 0, jst),
+			active:    false,
+			lastStop:  time.Date(2024, 1, 5, 20, 0, 0, 0, jst),
+			nextStart: time.Date(2024, 1, 8, 9, 0, 0, 0, jst),
+		},
+		{
+			name:      "saturday noon",
+			now:       time.Date(2024, 1, 6, 12, 0, 0, 0, jst),
+			active:    false,
+			lastStop:  time.Date(2024, 1, 5, 20, 0, 0, 0, jst),
+			nextStart: time.Date(2024, 1, 8, 9, 0, 0, 0, jst),
+		},
+		{
+			name:      "monday early morning in UTC",
+			now:       time.Date(2024, 1, 7, 23, 0, 0, 0, time.UTC), // 08:00 JST on monday
+			active:    false,
+			lastStop:  time.Date(2024, 1, 5, 20, 0, 0, 0, jst),
+			nextStart: time.Date(2024, 1, 8, 9, 0, 0, 0, jst),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := s.Active(c.now); got != c.active {
+				t.Errorf("Active() = %v, want %v", got, c.active)
+			}
+			if got := s.LastStop(c.now); !got.Equal(c.lastStop) {
+				t.Errorf("LastStop() = %s, want %s", got, c.lastStop)
+			}
+			if got := s.NextStart(c.now); !got.Equal(c.nextStart) {
+				t.Errorf("NextStart() = %s, want %s", got, c.nextStart)
+			}
+		})
+	}
+}
+
+func TestScheduleMatch(t *testing.T) {
+	tags := []types.Tag{
+		{Key: aws.String("Env"), Value: aws.String("dev")},
+	}
+	cases := []struct {
+		name     string
+		schedule mirageecs.Schedule
+		tags     []types.Tag
+		opt      *mirageecs.LaunchOption
+		expected bool
+	}{
+		{"tag and value", mirageecs.Schedule{Name: "s", Tag: "Env", Value: "dev"}, tags, nil, true},
+		{"tag only", mirageecs.Schedule{Name: "s", Tag: "Env"}, tags, nil, true},
+		{"value mismatch", mirageecs.Schedule{Name: "s", Tag: "Env", Value: "stg"}, tags, nil, false},
+		{"tag not found", mirageecs.Schedule{Name: "s", Tag: "Team"}, tags, nil, false},
+		{"no tag", mirageecs.Schedule{Name: "s"}, tags, nil, false},
+		{"option", mirageecs.Schedule{Name: "s"}, nil, &mirageecs.LaunchOption{Schedule: "s"}, true},
+		{"other option", mirageecs.Schedule{Name: "s", Tag: "Env"}, tags, &mirageecs.LaunchOption{Schedule: "t"}, false},
+		{"opt out", mirageecs.Schedule{Name: "s", Tag: "Env"}, tags, &mirageecs.LaunchOption{Schedule: mirageecs.ScheduleNone}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.schedule.Match(c.tags, c.opt); got != c.expected {
+				t.Errorf("Match() = %v, want %v", got, c.expected)
+			}
+		})
+	}
+}
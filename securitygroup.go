@@ -0,0 +1,71 @@
+package mirageecs
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+)
+
+// maxSecurityGroupsPerENI is the limit of security groups of a network interface.
+const maxSecurityGroupsPerENI = 5
+
+// SecurityGroupRule attaches security groups to tasks which have the matched tag.
+// Parameters are tagged by their names, so rules can match parameters at launch.
+type SecurityGroupRule struct {
+	// Tag is a key of the tag (or a name of the parameter).
+	Tag string `yaml:"tag"`
+	// Value is a value of the tag. Empty matches any value.
+	Value string `yaml:"value"`
+	// SecurityGroups are security groups added to the network configuration of tasks.
+	SecurityGroups []string `yaml:"security_groups"`
+}
+
+func (r *SecurityGroupRule) validate() error {
+	if r.Tag == "" {
+		return fmt.Errorf("security_group_rules[].tag is required")
+	}
+	if len(r.SecurityGroups) == 0 {
+		return fmt.Errorf("security_group_rules[].security_groups is required (tag %s)", r.Tag)
+	}
+	return nil
+}
+
+func (r *SecurityGroupRule) match(tags []types.Tag) bool {
+	v := getTag(tags, r.Tag)
+	if r.Value == "" {
+		return v != ""
+	}
+	return v == r.Value
+}
+
+// securityGroupsFor returns security groups of rules matched with the tags.
+func securityGroupsFor(rules []*SecurityGroupRule, tags []types.Tag) []string {
+	var sgs []string
+	for _, r := range rules {
+		if r.match(tags) {
+			sgs = append(sgs, r.SecurityGroups...)
+		}
+	}
+	return lo.Uniq(sgs)
+}
+
+// networkConfigurationFor returns the network configuration of the task with the tags and the launch option.
+func (c *Config) networkConfigurationFor(tags []types.Tag, opt *LaunchOption) (*types.NetworkConfiguration, error) {
+	nc := c.Network.Private.networkConfigurationFor(c.ECS.networkConfiguration, opt)
+	sgs := securityGroupsFor(c.ECS.SecurityGroupRules, tags)
+	if len(sgs) == 0 {
+		return nc, nil
+	}
+	if nc == nil || nc.AwsvpcConfiguration == nil {
+		return nil, fmt.Errorf("security_group_rules require ecs.network_configuration of the awsvpc network mode")
+	}
+	vpc := *nc.AwsvpcConfiguration
+	vpc.SecurityGroups = lo.Uniq(append(append([]string{}, vpc.SecurityGroups...), sgs...))
+	if len(vpc.SecurityGroups) > maxSecurityGroupsPerENI {
+		return nil, fmt.Errorf("too many security groups (max %d): %v", maxSecurityGroupsPerENI, vpc.SecurityGroups)
+	}
+	slog.Info(f("security groups of the task: %v", vpc.SecurityGroups))
+	return &types.NetworkConfiguration{AwsvpcConfiguration: &vpc}, nil
+}
@@ -0,0 +1,99 @@
+package mirageecs_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func tagsOf(kv ...string) []types.Tag {
+	var tags []types.Tag
+	for i := 0; i < len(kv); i += 2 {
+		tags = append(tags, types.Tag{Key: aws.String(kv[i]), Value: aws.String(kv[i+1])})
+	}
+	return tags
+}
+
+func TestSecurityGroupRuleValidate(t *testing.T) {
+	if err := (&mirageecs.SecurityGroupRule{Tag: "needs_db", SecurityGroups: []string{"sg-rds"}}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (&mirageecs.SecurityGroupRule{SecurityGroups: []string{"sg-rds"}}).Validate(); err == nil {
+		t.Error("expected error without tag")
+	}
+	if err := (&mirageecs.SecurityGroupRule{Tag: "needs_db"}).Validate(); err == nil {
+		t.Error("expected error without security groups")
+	}
+}
+
+func TestSecurityGroupsFor(t *testing.T) {
+	rules := []*mirageecs.SecurityGroupRule{
+		{Tag: "needs_db", Value: "true", SecurityGroups: []string{"sg-rds"}},
+		{Tag: "needs_cache", SecurityGroups: []string{"sg-redis", "sg-rds"}},
+	}
+	cases := []struct {
+		name string
+		tags []types.Tag
+		want []string
+	}{
+		{"no tags", nil, nil},
+		{"matched value", tagsOf("needs_db", "true"), []string{"sg-rds"}},
+		{"unmatched value", tagsOf("needs_db", "false"), nil},
+		{"any value", tagsOf("needs_cache", "yes"), []string{"sg-redis", "sg-rds"}},
+		{"deduplicated", tagsOf("needs_db", "true", "needs_cache", "1"), []string{"sg-rds", "sg-redis"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, mirageecs.SecurityGroupsFor(rules, tc.tags), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected security groups (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNetworkConfigurationForSecurityGroupRules(t *testing.T) {
+	cfg, err := mirageecs.NewConfig(context.Background(), &mirageecs.ConfigParams{Domain: "example.net"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ECS.SecurityGroupRules = []*mirageecs.SecurityGroupRule{
+		{Tag: "needs_db", Value: "true", SecurityGroups: []string{"sg-rds"}},
+		{Tag: "many", SecurityGroups: []string{"sg-1", "sg-2", "sg-3", "sg-4", "sg-5"}},
+	}
+
+	if _, err := cfg.NetworkConfigurationFor(tagsOf("needs_db", "true"), nil); err == nil {
+		t.Error("expected error without awsvpc network configuration")
+	}
+
+	base := &types.NetworkConfiguration{AwsvpcConfiguration: &types.AwsVpcConfiguration{
+		Subnets:        []string{"subnet-1"},
+		SecurityGroups: []string{"sg-default"},
+	}}
+	cfg.SetNetworkConfiguration(base)
+	nc, err := cfg.NetworkConfigurationFor(tagsOf("needs_db", "false"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nc != base {
+		t.Error("network configuration must not be modified without matched rules")
+	}
+	nc, err = cfg.NetworkConfigurationFor(tagsOf("needs_db", "true"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"sg-default", "sg-rds"}, nc.AwsvpcConfiguration.SecurityGroups); diff != "" {
+		t.Errorf("unexpected security groups (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"sg-default"}, base.AwsvpcConfiguration.SecurityGroups); diff != "" {
+		t.Errorf("the base network configuration must not be modified (-want +got):\n%s", diff)
+	}
+	if _, err := cfg.NetworkConfigurationFor(tagsOf("many", "true"), nil); err == nil {
+		t.Error("expected error for too many security groups")
+	}
+}
@@ -0,0 +1,253 @@
+package mirageecs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	serviceNamePrefix  = "mirage-"
+	serviceGroupPrefix = "service:"
+
+	maxServiceNameLength = 255
+)
+
+var invalidServiceNameChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// serviceName returns a name of the service for the subdomain and the task definition family.
+// A timestamp is appended because a deleted service remains as DRAINING for a while.
+// A too long name is truncated and suffixed by its hash, keeping the prefix to find the service later.
+func serviceName(subdomain, family string, now time.Time) string {
+	body := invalidServiceNameChars.ReplaceAllString(subdomain, "_") +
+		"-" + invalidServiceNameChars.ReplaceAllString(family, "_")
+	suffix := "-" + strconv.FormatInt(now.Unix(), 36)
+	if n := maxServiceNameLength - len(serviceNamePrefix) - len(suffix); len(body) > n {
+		sum := sha256.Sum256([]byte(body))
+		hash := "-" + hex.EncodeToString(sum[:4])
+		body = body[:n-len(hash)] + hash
+	}
+	return serviceNamePrefix + body + suffix
+}
+
+// mergeEnvironment returns environment variables of the container definition merged with the override.
+// Variables in the override take precedence.
+func mergeEnvironment(env []types.KeyValuePair, override []types.KeyValuePair) []types.KeyValuePair {
+	merged := make([]types.KeyValuePair, 0, len(env)+len(override))
+	overridden := make(map[string]struct{}, len(override))
+	for _, kv := range override {
+		overridden[aws.ToString(kv.Name)] = struct{}{}
+	}
+	for _, kv := range env {
+		if _, ok := overridden[aws.ToString(kv.Name)]; ok {
+			continue
+		}
+		merged = append(merged, kv)
+	}
+	return append(merged, override...)
+}
+
+// registerTaskDefinitionWithOverrides registers a new revision of the task definition with the overrides applied.
+// ECS services can't override task definitions like RunTask.
+func (e *ECS) registerTaskDefinitionWithOverrides(ctx context.Context, subdomain string, td *types.TaskDefinition, tdTags []types.Tag, ov *types.TaskOverride) (*types.TaskDefinition, error) {
+	in := newRegisterTaskDefinitionInput(td, tdTags)
+	containers := make([]types.ContainerDefinition, 0, len(td.ContainerDefinitions))
+	for _, c := range td.ContainerDefinitions {
+		for _, co := range ov.ContainerOverrides {
+			if aws.ToString(co.Name) == aws.ToString(c.Name) {
+				c.Environment = mergeEnvironment(c.Environment, co.Environment)
+				c.ResourceRequirements = mergeResourceRequirements(c.ResourceRequirements, co.ResourceRequirements)
+			}
+		}
+		containers = append(containers, c)
+	}
+	in.ContainerDefinitions = containers
+	if ov.Cpu != nil {
+		in.Cpu = ov.Cpu
+	}
+	if ov.Memory != nil {
+		in.Memory = ov.Memory
+	}
+	in.InferenceAccelerators = append([]types.InferenceAccelerator(nil), in.InferenceAccelerators...)
+	for _, ia := range ov.InferenceAcceleratorOverrides {
+		for i := range in.InferenceAccelerators {
+			if aws.ToString(in.InferenceAccelerators[i].DeviceName) == aws.ToString(ia.DeviceName) {
+				in.InferenceAccelerators[i].DeviceType = ia.DeviceType
+			}
+		}
+	}
+	return e.registerTaskDefinition(ctx, subdomain, in)
+}
+
+// createService creates an ECS service which runs a task for the subdomain.
+func (e *ECS) createService(ctx context.Context, subdomain string, td *types.TaskDefinition, tdTags []types.Tag, ov *types.TaskOverride, tags []types.Tag, opt *LaunchOption) error {
+	cfg := e.cfg
+	nc, err := cfg.networkConfigurationFor(tags, opt)
+	if err != nil {
+		return err
+	}
+	registered, err := e.registerTaskDefinitionWithOverrides(ctx, subdomain, td, tdTags, ov)
+	if err != nil {
+		return err
+	}
+	name := serviceName(subdomain, aws.ToString(td.Family), time.Now())
+	in := &ecs.CreateServiceInput{
+		ServiceName:              aws.String(name),
+		Cluster:                  aws.String(cfg.ECS.Cluster),
+		TaskDefinition:           registered.TaskDefinitionArn,
+		DesiredCount:             aws.Int32(1),
+		CapacityProviderStrategy: cfg.ECS.capacityProviderStrategy,
+		NetworkConfiguration:     nc,
+		EnableExecuteCommand:     cfg.ECS.enableExecuteCommandFor(opt),
+		EnableECSManagedTags:     true,
+		PropagateTags:            types.PropagateTagsService,
+		Tags:                     tags,
+	}
+	if lt := cfg.ECS.LaunchType; lt != nil {
+		in.LaunchType = types.LaunchType(*lt)
+	}
+	if opt != nil && len(opt.CapacityProviderStrategy) > 0 {
+		in.CapacityProviderStrategy = opt.CapacityProviderStrategy.toSDK()
+		in.LaunchType = ""
+	}
+	slog.Debug(f("CreateServiceInput: %v", in))
+	out, err := e.svc.CreateService(ctx, in)
+	if err != nil {
+		e.deregisterTaskDefinition(ctx, aws.ToString(registered.TaskDefinitionArn))
+		return fmt.Errorf("failed to create service %s: %w", name, err)
+	}
+	slog.Info(f("created service ARN: %s", aws.ToString(out.Service.ServiceArn)))
+	return nil
+}
+
+// findServices returns active services for the subdomain.
+func (e *ECS) findServices(ctx context.Context, subdomain string) ([]types.Service, error) {
+	services, err := e.listServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return lo.Filter(services, func(s types.Service, _ int) bool {
+		return decodeTagValue(getTag(s.Tags, TagSubdomain)) == subdomain
+	}), nil
+}
+
+// listServices returns active services managed by mirage-ecs.
+func (e *ECS) listServices(ctx context.Context) ([]types.Service, error) {
+	cluster := aws.String(e.cfg.ECS.Cluster)
+	var services []types.Service
+	p := ecs.NewListServicesPaginator(e.svc, &ecs.ListServicesInput{Cluster: cluster})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list services: %w", err)
+		}
+		var arns []string
+		for _, arn := range out.ServiceArns {
+			if strings.HasPrefix(shortenArn(arn), serviceNamePrefix) {
+				arns = append(arns, arn)
+			}
+		}
+		// DescribeServices accepts up to 10 services
+		for len(arns) > 0 {
+			n := min(len(arns), 10)
+			desc, err := e.svc.DescribeServices(ctx, &ecs.DescribeServicesInput{
+				Cluster:  cluster,
+				Services: arns[:n],
+				Include:  []types.ServiceField{types.ServiceFieldTags},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe services: %w", err)
+			}
+			arns = arns[n:]
+			for _, s := range desc.Services {
+				if aws.ToString(s.Status) != "ACTIVE" {
+					continue
+				}
+				if getTag(s.Tags, TagManagedBy) != TagValueMirage {
+					continue
+				}
+				services = append(services, s)
+			}
+		}
+	}
+	return services, nil
+}
+
+// deleteServicesBySubdomain deletes services for the subdomain. Tasks of the services are stopped by ECS.
+func (e *ECS) deleteServicesBySubdomain(ctx context.Context, subdomain string) error {
+	services, err := e.findServices(ctx, subdomain)
+	if err != nil {
+		return err
+	}
+	var eg errgroup.Group
+	for _, s := range services {
+		s := s
+		eg.Go(func() error {
+			slog.Info(f("delete service %s", aws.ToString(s.ServiceName)))
+			_, err := e.svc.DeleteService(ctx, &ecs.DeleteServiceInput{
+				Cluster: aws.String(e.cfg.ECS.Cluster),
+				Service: s.ServiceArn,
+				Force:   aws.Bool(true),
+			})
+			return err
+		})
+	}
+	return eg.Wait()
+}
+
+// deleteServiceOfTask deletes the service which started the task.
+// It returns false if the task is not started by a service.
+func (e *ECS) deleteServiceOfTask(ctx context.Context, task *types.Task) (bool, error) {
+	name := serviceNameOfTask(task)
+	if name == "" {
+		return false, nil
+	}
+	slog.Info(f("delete service %s of task %s", name, aws.ToString(task.TaskArn)))
+	_, err := e.svc.DeleteService(ctx, &ecs.DeleteServiceInput{
+		Cluster: aws.String(e.cfg.ECS.Cluster),
+		Service: aws.String(name),
+		Force:   aws.Bool(true),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete service %s: %w", name, err)
+	}
+	return true, nil
+}
+
+func getTag(tags []types.Tag, name string) string {
+	for _, t := range tags {
+		if aws.ToString(t.Key) == name {
+			return aws.ToString(t.Value)
+		}
+	}
+	return ""
+}
+
+// serviceNameOfTask returns the name of the service which started the task.
+func serviceNameOfTask(task *types.Task) string {
+	group := aws.ToString(task.Group)
+	if !strings.HasPrefix(group, serviceGroupPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(group, serviceGroupPrefix)
+}
+
+func environmentsInContainerDefinition(c *types.ContainerDefinition) map[string]string {
+	env := make(map[string]string, len(c.Environment))
+	for _, e := range c.Environment {
+		env[aws.ToString(e.Name)] = aws.ToString(e.Value)
+	}
+	return env
+}
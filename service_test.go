@@ -0,0 +1,61 @@
+package mirageecs_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestServiceName(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		subdomain string
+		family    string
+		want      string
+	}{
+		{"feature", "app", "mirage-feature-app-s44we8"},
+		{"feature-*", "app.web", "mirage-feature-_-app_web-s44we8"},
+	}
+	for _, tt := range tests {
+		if got := mirageecs.ServiceName(tt.subdomain, tt.family, now); got != tt.want {
+			t.Errorf("ServiceName(%q, %q) = %q, want %q", tt.subdomain, tt.family, got, tt.want)
+		}
+	}
+
+	long := mirageecs.ServiceName(strings.Repeat("a", 200), strings.Repeat("b", 100), now)
+	if len(long) != 255 {
+		t.Errorf("long service name should be truncated to 255 characters: %d", len(long))
+	}
+	if !strings.HasPrefix(long, "mirage-aaa") || !strings.HasSuffix(long, "-s44we8") {
+		t.Errorf("long service name should keep the prefix and the timestamp: %s", long)
+	}
+	other := mirageecs.ServiceName(strings.Repeat("a", 200), strings.Repeat("b", 99)+"c", now)
+	if long == other {
+		t.Errorf("truncated service names should be unique: %s", long)
+	}
+}
+
+func TestMergeEnvironment(t *testing.T) {
+	env := []types.KeyValuePair{
+		{Name: aws.String("FOO"), Value: aws.String("foo")},
+		{Name: aws.String("SUBDOMAIN"), Value: aws.String("old")},
+	}
+	override := []types.KeyValuePair{
+		{Name: aws.String("SUBDOMAIN"), Value: aws.String("new")},
+	}
+	got := mirageecs.MergeEnvironment(env, override)
+	want := []types.KeyValuePair{
+		{Name: aws.String("FOO"), Value: aws.String("foo")},
+		{Name: aws.String("SUBDOMAIN"), Value: aws.String("new")},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreUnexported(types.KeyValuePair{})); diff != "" {
+		t.Errorf("unexpected environment (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,157 @@
+package mirageecs
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// Sidecar is a container injected into every launched task.
+type Sidecar struct {
+	Name              string            `yaml:"name"`
+	Image             string            `yaml:"image"`
+	Essential         *bool             `yaml:"essential"`
+	Cpu               int32             `yaml:"cpu"`
+	MemoryReservation int32             `yaml:"memory_reservation"`
+	Command           []string          `yaml:"command"`
+	Environment       map[string]string `yaml:"environment"`
+	Secrets           map[string]string `yaml:"secrets"` // name -> valueFrom
+	PortMappings      []int32           `yaml:"port_mappings"`
+	Proxy             *SidecarProxy     `yaml:"proxy"`
+}
+
+// SidecarProxy puts the sidecar in front of a container.
+// The sidecar listens on Port, and the port of the container is remapped to UpstreamPort.
+type SidecarProxy struct {
+	Container       string `yaml:"container"`
+	Port            int32  `yaml:"port"`
+	UpstreamPort    int32  `yaml:"upstream_port"`
+	UpstreamPortEnv string `yaml:"upstream_port_env"`
+}
+
+func (s *Sidecar) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("sidecar name is required")
+	}
+	if s.Image == "" {
+		return fmt.Errorf("sidecar %s: image is required", s.Name)
+	}
+	if p := s.Proxy; p != nil {
+		if p.Container == "" {
+			return fmt.Errorf("sidecar %s: proxy.container is required", s.Name)
+		}
+		if p.Port <= 0 || p.UpstreamPort <= 0 {
+			return fmt.Errorf("sidecar %s: proxy.port and proxy.upstream_port are required", s.Name)
+		}
+		if p.Port == p.UpstreamPort {
+			return fmt.Errorf("sidecar %s: proxy.port and proxy.upstream_port must be different", s.Name)
+		}
+	}
+	return nil
+}
+
+func keyValuePairs(m map[string]string) []types.KeyValuePair {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]types.KeyValuePair, 0, len(m))
+	for _, k := range keys {
+		kvs = append(kvs, types.KeyValuePair{Name: aws.String(k), Value: aws.String(m[k])})
+	}
+	return kvs
+}
+
+func (s *Sidecar) containerDefinition(networkMode types.NetworkMode) types.ContainerDefinition {
+	cd := types.ContainerDefinition{
+		Name:        aws.String(s.Name),
+		Image:       aws.String(s.Image),
+		Essential:   s.Essential,
+		Cpu:         s.Cpu,
+		Command:     s.Command,
+		Environment: keyValuePairs(s.Environment),
+	}
+	if cd.Essential == nil {
+		cd.Essential = aws.Bool(true)
+	}
+	if s.MemoryReservation > 0 {
+		cd.MemoryReservation = aws.Int32(s.MemoryReservation)
+	}
+	for _, kv := range keyValuePairs(s.Secrets) {
+		cd.Secrets = append(cd.Secrets, types.Secret{Name: kv.Name, ValueFrom: kv.Value})
+	}
+	ports := s.PortMappings
+	if s.Proxy != nil {
+		ports = append([]int32{s.Proxy.Port}, ports...)
+	}
+	for _, port := range ports {
+		cd.PortMappings = append(cd.PortMappings, portMapping(port, networkMode))
+	}
+	return cd
+}
+
+func portMapping(port int32, networkMode types.NetworkMode) types.PortMapping {
+	m := types.PortMapping{
+		ContainerPort: aws.Int32(port),
+		Protocol:      types.TransportProtocolTcp,
+	}
+	if networkMode == types.NetworkModeAwsvpc || networkMode == types.NetworkModeHost {
+		// host port must be the same as the container port
+		m.HostPort = aws.Int32(port)
+	}
+	return m
+}
+
+// remapPort remaps the port of the container proxied by the sidecar.
+func (p *SidecarProxy) remapPort(cd types.ContainerDefinition, networkMode types.NetworkMode) types.ContainerDefinition {
+	mappings := make([]types.PortMapping, 0, len(cd.PortMappings))
+	for _, m := range cd.PortMappings {
+		if aws.ToInt32(m.ContainerPort) == p.Port {
+			slog.Debug(f("remap port of container %s: %d -> %d", aws.ToString(cd.Name), p.Port, p.UpstreamPort))
+			m = portMapping(p.UpstreamPort, networkMode)
+		}
+		mappings = append(mappings, m)
+	}
+	cd.PortMappings = mappings
+	if p.UpstreamPortEnv != "" {
+		cd.Environment = mergeEnvironment(cd.Environment, []types.KeyValuePair{
+			{Name: aws.String(p.UpstreamPortEnv), Value: aws.String(strconv.Itoa(int(p.UpstreamPort)))},
+		})
+	}
+	return cd
+}
+
+// sidecarModifier injects sidecars into the task definition.
+// Sidecars which have the same name as a container in the task definition are not injected.
+func sidecarModifier(sidecars []*Sidecar) taskDefinitionModifier {
+	return func(in *ecs.RegisterTaskDefinitionInput) {
+		exists := make(map[string]bool, len(in.ContainerDefinitions))
+		for _, cd := range in.ContainerDefinitions {
+			exists[aws.ToString(cd.Name)] = true
+		}
+		containers := make([]types.ContainerDefinition, 0, len(in.ContainerDefinitions)+len(sidecars))
+		for _, cd := range in.ContainerDefinitions {
+			for _, s := range sidecars {
+				if s.Proxy != nil && s.Proxy.Container == aws.ToString(cd.Name) && !exists[s.Name] {
+					cd = s.Proxy.remapPort(cd, in.NetworkMode)
+				}
+			}
+			containers = append(containers, cd)
+		}
+		for _, s := range sidecars {
+			if exists[s.Name] {
+				slog.Warn(f("sidecar %s is not injected. a container of the same name exists in the task definition", s.Name))
+				continue
+			}
+			slog.Info(f("inject sidecar %s", s.Name))
+			containers = append(containers, s.containerDefinition(in.NetworkMode))
+		}
+		in.ContainerDefinitions = containers
+	}
+}
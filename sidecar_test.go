@@ -0,0 +1,96 @@
+package mirageecs_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestSidecarValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		sidecar *mirageecs.Sidecar
+		wantErr bool
+	}{
+		{"valid", &mirageecs.Sidecar{Name: "otel", Image: "otel/collector"}, false},
+		{"no name", &mirageecs.Sidecar{Image: "otel/collector"}, true},
+		{"no image", &mirageecs.Sidecar{Name: "otel"}, true},
+		{"proxy without ports", &mirageecs.Sidecar{Name: "auth", Image: "auth", Proxy: &mirageecs.SidecarProxy{Container: "app"}}, true},
+		{"proxy same ports", &mirageecs.Sidecar{Name: "auth", Image: "auth", Proxy: &mirageecs.SidecarProxy{Container: "app", Port: 80, UpstreamPort: 80}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.sidecar.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInjectSidecars(t *testing.T) {
+	in := &ecs.RegisterTaskDefinitionInput{
+		NetworkMode: types.NetworkModeAwsvpc,
+		ContainerDefinitions: []types.ContainerDefinition{
+			{
+				Name:         aws.String("app"),
+				Image:        aws.String("app:latest"),
+				PortMappings: []types.PortMapping{{ContainerPort: aws.Int32(80), HostPort: aws.Int32(80)}},
+			},
+			{
+				Name:  aws.String("otel"),
+				Image: aws.String("my-otel"),
+			},
+		},
+	}
+	mirageecs.InjectSidecars(in, []*mirageecs.Sidecar{
+		{
+			Name:        "auth-proxy",
+			Image:       "auth-proxy:latest",
+			Environment: map[string]string{"UPSTREAM": "http://localhost:8080"},
+			Proxy: &mirageecs.SidecarProxy{
+				Container:       "app",
+				Port:            80,
+				UpstreamPort:    8080,
+				UpstreamPortEnv: "PORT",
+			},
+		},
+		{
+			Name:  "otel",
+			Image: "otel/collector",
+		},
+	})
+
+	want := []types.ContainerDefinition{
+		{
+			Name:  aws.String("app"),
+			Image: aws.String("app:latest"),
+			PortMappings: []types.PortMapping{
+				{ContainerPort: aws.Int32(8080), HostPort: aws.Int32(8080), Protocol: types.TransportProtocolTcp},
+			},
+			Environment: []types.KeyValuePair{{Name: aws.String("PORT"), Value: aws.String("8080")}},
+		},
+		{
+			Name:  aws.String("otel"),
+			Image: aws.String("my-otel"),
+		},
+		{
+			Name:      aws.String("auth-proxy"),
+			Image:     aws.String("auth-proxy:latest"),
+			Essential: aws.Bool(true),
+			PortMappings: []types.PortMapping{
+				{ContainerPort: aws.Int32(80), HostPort: aws.Int32(80), Protocol: types.TransportProtocolTcp},
+			},
+			Environment: []types.KeyValuePair{{Name: aws.String("UPSTREAM"), Value: aws.String("http://localhost:8080")}},
+		},
+	}
+	opt := cmpopts.IgnoreUnexported(types.ContainerDefinition{}, types.PortMapping{}, types.KeyValuePair{})
+	if diff := cmp.Diff(want, in.ContainerDefinitions, opt, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("unexpected container definitions (-want +got):\n%s", diff)
+	}
+}
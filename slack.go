@@ -0,0 +1,407 @@
+package mirageecs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+)
+
+const (
+	// slackRequestTolerance is the maximum age of requests from Slack, to reject replayed requests.
+	slackRequestTolerance = 5 * time.Minute
+
+	slackActionLaunch    = "mirage_launch"
+	slackActionTerminate = "mirage_terminate"
+	slackActionCancel    = "mirage_cancel"
+)
+
+// Slack receives slash commands (e.g. /mirage launch pr-123 app branch=foo) of a Slack app at /hooks/slack/command,
+// and clicks of confirmation buttons at /hooks/slack/interaction.
+type Slack struct {
+	// SigningSecret is a signing secret of the Slack app to verify signatures of requests.
+	SigningSecret string `yaml:"signing_secret"`
+	// Users are IDs or names of Slack users who can run commands. Empty allows all users of the workspace.
+	Users []string `yaml:"users"`
+	// Channels are IDs or names of Slack channels where commands can be run. Empty allows all channels.
+	Channels []string `yaml:"channels"`
+}
+
+func (s *Slack) validate() error {
+	if s == nil {
+		return nil
+	}
+	if s.SigningSecret == "" {
+		return fmt.Errorf("slack.signing_secret is required")
+	}
+	return nil
+}
+
+// allows reports whether the user can run commands in the channel.
+func (s *Slack) allows(user, userName, channel, channelName string) bool {
+	match := func(list []string, id, name string) bool {
+		return len(list) == 0 || lo.ContainsBy(list, func(v string) bool {
+			return v == id || strings.EqualFold(strings.TrimPrefix(v, "#"), name)
+		})
+	}
+	return match(s.Users, user, userName) && match(s.Channels, channel, channelName)
+}
+
+// verifySlackSignature verifies the X-Slack-Signature header of the request body signed at the timestamp.
+func verifySlackSignature(secret string, body []byte, timestamp, signature string, now time.Time) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > slackRequestTolerance || d < -slackRequestTolerance {
+		return false
+	}
+	sig, ok := strings.CutPrefix(signature, "v0=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:", timestamp)
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// slackCommand is a command of the slash command. It is also the value of confirmation buttons.
+type slackCommand struct {
+	Action     string            `json:"action"`
+	Subdomain  string            `json:"subdomain,omitempty"`
+	Taskdef    []string          `json:"taskdef,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+const slackUsage = "Usage:\n" +
+	"• `launch <subdomain> [taskdef ...] [name=value ...]` launches an environment\n" +
+	"• `list` lists running environments\n" +
+	"• `terminate <subdomain>` terminates an environment"
+
+// parseSlackCommand parses the text of the slash command.
+// Arguments of launch after the subdomain are task definitions, or parameters in the form of name=value.
+func parseSlackCommand(text string) (*slackCommand, error) {
+	args := strings.Fields(text)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("command is required")
+	}
+	cmd := &slackCommand{Action: strings.ToLower(args[0])}
+	switch cmd.Action {
+	case "list":
+		return cmd, nil
+	case "launch", "terminate":
+	default:
+		return nil, fmt.Errorf("unknown command: %s", args[0])
+	}
+	if len(args) < 2 {
+		return nil, fmt.Errorf("subdomain is required")
+	}
+	cmd.Subdomain = strings.ToLower(args[1])
+	if err := validateSubdomain(cmd.Subdomain); err != nil {
+		return nil, err
+	}
+	if cmd.Action == "terminate" {
+		if len(args) > 2 {
+			return nil, fmt.Errorf("too many arguments: %s", strings.Join(args[2:], " "))
+		}
+		return cmd, nil
+	}
+	for _, arg := range args[2:] {
+		if name, value, ok := strings.Cut(arg, "="); ok {
+			if cmd.Parameters == nil {
+				cmd.Parameters = make(map[string]string)
+			}
+			cmd.Parameters[name] = value
+		} else {
+			cmd.Taskdef = append(cmd.Taskdef, arg)
+		}
+	}
+	return cmd, nil
+}
+
+// describe describes the command for messages.
+func (cmd *slackCommand) describe() string {
+	s := fmt.Sprintf("%s `%s`", cmd.Action, cmd.Subdomain)
+	var args []string
+	args = append(args, cmd.Taskdef...)
+	names := lo.Keys(cmd.Parameters)
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, name+"="+cmd.Parameters[name])
+	}
+	if len(args) > 0 {
+		s += " (" + strings.Join(args, ", ") + ")"
+	}
+	return s
+}
+
+// slackMessage is a message of responses of Slack. See https://api.slack.com/interactivity/handling#message_responses
+type slackMessage struct {
+	ResponseType    string       `json:"response_type,omitempty"`
+	Text            string       `json:"text,omitempty"`
+	Blocks          []slackBlock `json:"blocks,omitempty"`
+	ReplaceOriginal bool         `json:"replace_original,omitempty"`
+	DeleteOriginal  bool         `json:"delete_original,omitempty"`
+}
+
+type slackBlock struct {
+	Type     string        `json:"type"`
+	Text     *slackText    `json:"text,omitempty"`
+	Elements []slackButton `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackButton struct {
+	Type     string    `json:"type"`
+	Text     slackText `json:"text"`
+	ActionID string    `json:"action_id"`
+	Value    string    `json:"value,omitempty"`
+	Style    string    `json:"style,omitempty"`
+}
+
+// confirmation returns an ephemeral message to confirm the command by buttons.
+func (cmd *slackCommand) confirmation() (*slackMessage, error) {
+	value, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	action, label, style := slackActionLaunch, "Launch", "primary"
+	if cmd.Action == "terminate" {
+		action, label, style = slackActionTerminate, "Terminate", "danger"
+	}
+	text := "Are you sure to " + cmd.describe() + "?"
+	return &slackMessage{
+		ResponseType: "ephemeral",
+		Text:         text,
+		Blocks: []slackBlock{
+			{Type: "section", Text: &slackText{Type: "mrkdwn", Text: text}},
+			{Type: "actions", Elements: []slackButton{
+				{Type: "button", Text: slackText{Type: "plain_text", Text: label}, ActionID: action, Value: string(value), Style: style},
+				{Type: "button", Text: slackText{Type: "plain_text", Text: "Cancel"}, ActionID: slackActionCancel},
+			}},
+		},
+	}, nil
+}
+
+// slackInteraction is a payload of interactions (clicks of buttons) of Slack.
+type slackInteraction struct {
+	Type string `json:"type"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Channel struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"channel"`
+	ResponseURL string `json:"response_url"`
+	Actions     []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+// readSlackRequest reads and verifies the form of the request from Slack.
+func (api *WebApi) readSlackRequest(c echo.Context) (url.Values, error) {
+	body, err := readWebhookPayload(c)
+	if err != nil {
+		return nil, err
+	}
+	req := c.Request()
+	if !verifySlackSignature(api.cfg.Slack.SigningSecret, body, req.Header.Get("X-Slack-Request-Timestamp"), req.Header.Get("X-Slack-Signature"), time.Now()) {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "invalid signature")
+	}
+	return url.ParseQuery(string(body))
+}
+
+// HookSlackCommand responds to slash commands of Slack.
+// launch and terminate are confirmed by buttons, and list is posted in the channel.
+func (api *WebApi) HookSlackCommand(c echo.Context) error {
+	s := api.cfg.Slack
+	if s == nil {
+		return c.JSON(http.StatusNotFound, APIHookResponse{Result: "slack is not configured"})
+	}
+	form, err := api.readSlackRequest(c)
+	if err != nil {
+		return slackError(c, err)
+	}
+	if !s.allows(form.Get("user_id"), form.Get("user_name"), form.Get("channel_id"), form.Get("channel_name")) {
+		return c.JSON(http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: "You are not allowed to run mirage commands here."})
+	}
+	cmd, err := parseSlackCommand(form.Get("text"))
+	if err != nil {
+		return c.JSON(http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: err.Error() + "\n" + slackUsage})
+	}
+	switch cmd.Action {
+	case "list":
+		ctx := withActor(context.Background(), "slack:"+form.Get("user_name"))
+		go api.slackList(ctx, form.Get("response_url"))
+		// shows the command in the channel
+		return c.JSON(http.StatusOK, slackMessage{ResponseType: "in_channel"})
+	case "launch":
+		if err := api.validateSlackLaunch(cmd); err != nil {
+			return c.JSON(http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: err.Error()})
+		}
+	}
+	msg, err := cmd.confirmation()
+	if err != nil {
+		return c.JSON(http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: err.Error()})
+	}
+	return c.JSON(http.StatusOK, msg)
+}
+
+// validateSlackLaunch validates task definitions and parameters of the launch before the confirmation.
+func (api *WebApi) validateSlackLaunch(cmd *slackCommand) error {
+	if len(cmd.Taskdef) == 0 && api.cfg.ECS.TaskDefinitionTemplate == "" {
+		return fmt.Errorf("taskdef is required")
+	}
+	for name := range cmd.Parameters {
+		if !lo.ContainsBy(api.cfg.Parameter, func(p *Parameter) bool { return p.Name == name }) {
+			return fmt.Errorf("unknown parameter: %s", name)
+		}
+	}
+	taskdefs := cmd.Taskdef
+	if len(taskdefs) == 0 {
+		taskdefs = []string{""}
+	}
+	_, err := api.LoadParameter(func(name string) string {
+		return cmd.Parameters[name]
+	}, taskdefs...)
+	return err
+}
+
+// HookSlackInteraction runs the command confirmed by the button, and posts the result in the channel.
+func (api *WebApi) HookSlackInteraction(c echo.Context) error {
+	s := api.cfg.Slack
+	if s == nil {
+		return c.JSON(http.StatusNotFound, APIHookResponse{Result: "slack is not configured"})
+	}
+	form, err := api.readSlackRequest(c)
+	if err != nil {
+		return slackError(c, err)
+	}
+	var in slackInteraction
+	if err := json.Unmarshal([]byte(form.Get("payload")), &in); err != nil {
+		return c.JSON(http.StatusBadRequest, APIHookResponse{Result: err.Error()})
+	}
+	if in.Type != "block_actions" || len(in.Actions) == 0 {
+		return c.NoContent(http.StatusOK)
+	}
+	if !s.allows(in.User.ID, in.User.Username, in.Channel.ID, in.Channel.Name) {
+		return c.JSON(http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: "You are not allowed to run mirage commands here."})
+	}
+	action := in.Actions[0]
+	var cmd slackCommand
+	switch action.ActionID {
+	case slackActionCancel:
+		go postSlackResponse(context.Background(), in.ResponseURL, &slackMessage{DeleteOriginal: true})
+		return c.NoContent(http.StatusOK)
+	case slackActionLaunch, slackActionTerminate:
+		if err := json.Unmarshal([]byte(action.Value), &cmd); err != nil {
+			return c.JSON(http.StatusBadRequest, APIHookResponse{Result: err.Error()})
+		}
+	default:
+		return c.NoContent(http.StatusOK)
+	}
+	ctx := withActor(context.Background(), "slack:"+in.User.Username)
+	ctx = withLogAttrs(ctx, slog.String("subdomain", cmd.Subdomain))
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, APICallTimeout)
+		defer cancel()
+		postSlackResponse(ctx, in.ResponseURL, &slackMessage{DeleteOriginal: true})
+		slog.InfoContext(ctx, f("%s by slack user %s", cmd.describe(), in.User.Username))
+		var err error
+		if action.ActionID == slackActionLaunch {
+			err = api.launchWith(ctx, cmd.Subdomain, cmd.Taskdef, cmd.Parameters, &LaunchOption{})
+		} else {
+			api.launchQueue.cancel(cmd.Subdomain)
+			err = api.runner.TerminateBySubdomain(ctx, cmd.Subdomain)
+		}
+		text := fmt.Sprintf("<@%s> %s: done", in.User.ID, cmd.describe())
+		if err != nil {
+			slog.WarnContext(ctx, f("failed to %s: %s", cmd.describe(), err))
+			text = fmt.Sprintf("<@%s> %s: failed: %s", in.User.ID, cmd.describe(), err)
+		} else if action.ActionID == slackActionLaunch {
+			text += fmt.Sprintf(". It will be available at %s%s soon.", cmd.Subdomain, api.cfg.Host.ReverseProxySuffix)
+		}
+		postSlackResponse(ctx, in.ResponseURL, &slackMessage{ResponseType: "in_channel", Text: text})
+	}()
+	return c.NoContent(http.StatusOK)
+}
+
+// slackList posts running environments in the channel.
+func (api *WebApi) slackList(ctx context.Context, responseURL string) {
+	ctx, cancel := context.WithTimeout(ctx, APICallTimeout)
+	defer cancel()
+	infos, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		slog.WarnContext(ctx, f("failed to list environments for slack: %s", err))
+		postSlackResponse(ctx, responseURL, &slackMessage{ResponseType: "ephemeral", Text: "failed to list environments: " + err.Error()})
+		return
+	}
+	setStates(infos)
+	sort.SliceStable(infos, func(i, j int) bool { return infos[i].SubDomain < infos[j].SubDomain })
+	var b strings.Builder
+	subdomains := lo.Uniq(lo.Map(infos, func(info *Information, _ int) string { return info.SubDomain }))
+	fmt.Fprintf(&b, "%d environments are running.", len(subdomains))
+	for _, info := range infos {
+		fmt.Fprintf(&b, "\n• `%s` %s%s %s %s", info.SubDomain, info.SubDomain, api.cfg.Host.ReverseProxySuffix, info.TaskDef, info.State)
+		if info.GitBranch != "" {
+			fmt.Fprintf(&b, " (branch=%s)", info.GitBranch)
+		}
+	}
+	postSlackResponse(ctx, responseURL, &slackMessage{ResponseType: "in_channel", Text: b.String()})
+}
+
+// postSlackResponse posts the message to the response URL of the command or the interaction.
+func postSlackResponse(ctx context.Context, responseURL string, msg *slackMessage) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		slog.WarnContext(ctx, f("failed to encode a slack message: %s", err))
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, responseURL, bytes.NewReader(b))
+	if err != nil {
+		slog.WarnContext(ctx, f("invalid response url of slack: %s", err))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		slog.WarnContext(ctx, f("failed to post a slack message: %s", err))
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		slog.WarnContext(ctx, f("failed to post a slack message: %s", res.Status))
+	}
+}
+
+func slackError(c echo.Context, err error) error {
+	if he, ok := err.(*echo.HTTPError); ok {
+		return c.JSON(he.Code, APIHookResponse{Result: fmt.Sprint(he.Message)})
+	}
+	return c.JSON(http.StatusBadRequest, APIHookResponse{Result: err.Error()})
+}
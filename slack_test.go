@@ -0,0 +1,221 @@
+package mirageecs_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestSlackValidate(t *testing.T) {
+	if err := (&mirageecs.Slack{}).Validate(); err == nil {
+		t.Error("slack without signing_secret should be invalid")
+	}
+	if err := (&mirageecs.Slack{SigningSecret: "secret"}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+type slackTestMessage struct {
+	ResponseType    string `json:"response_type"`
+	Text            string `json:"text"`
+	DeleteOriginal  bool   `json:"delete_original"`
+	ReplaceOriginal bool   `json:"replace_original"`
+	Blocks          []struct {
+		Type     string `json:"type"`
+		Elements []struct {
+			ActionID string `json:"action_id"`
+			Value    string `json:"value"`
+		} `json:"elements"`
+	} `json:"blocks"`
+}
+
+// button returns the value of the button of the action in the message.
+func (msg *slackTestMessage) button(actionID string) string {
+	for _, b := range msg.Blocks {
+		for _, e := range b.Elements {
+			if e.ActionID == actionID {
+				return e.Value
+			}
+		}
+	}
+	return ""
+}
+
+type slackTest struct {
+	t        *testing.T
+	m        *mirageecs.Mirage
+	ts       *httptest.Server
+	response *httptest.Server
+
+	mu       sync.Mutex
+	messages []slackTestMessage
+}
+
+func newSlackTest(t *testing.T, s *mirageecs.Slack) *slackTest {
+	t.Helper()
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cfg.Cleanup)
+	cfg.Slack = s
+	if err := cfg.Slack.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	st := &slackTest{t: t, m: mirageecs.New(ctx, cfg)}
+	st.ts = httptest.NewServer(st.m.WebApi)
+	t.Cleanup(st.ts.Close)
+	st.response = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg slackTestMessage
+		json.NewDecoder(r.Body).Decode(&msg)
+		st.mu.Lock()
+		st.messages = append(st.messages, msg)
+		st.mu.Unlock()
+	}))
+	t.Cleanup(st.response.Close)
+	return st
+}
+
+// post posts the form signed at the time, and returns the status and the message of the response.
+func (st *slackTest) post(path string, form url.Values, signedAt time.Time) (int, slackTestMessage) {
+	st.t.Helper()
+	body := form.Encode()
+	ts := strconv.FormatInt(signedAt.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	fmt.Fprintf(mac, "v0:%s:%s", ts, body)
+	req, _ := http.NewRequest(http.MethodPost, st.ts.URL+path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	res, err := st.ts.Client().Do(req)
+	if err != nil {
+		st.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var msg slackTestMessage
+	b, _ := io.ReadAll(res.Body)
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &msg); err != nil {
+			st.t.Fatal(err)
+		}
+	}
+	return res.StatusCode, msg
+}
+
+func (st *slackTest) command(text string) (int, slackTestMessage) {
+	st.t.Helper()
+	return st.post("/hooks/slack/command", url.Values{
+		"command":      {"/mirage"},
+		"text":         {text},
+		"user_id":      {"U123"},
+		"user_name":    {"octocat"},
+		"channel_id":   {"C123"},
+		"channel_name": {"dev"},
+		"response_url": {st.response.URL},
+	}, time.Now())
+}
+
+func (st *slackTest) click(actionID, value string) int {
+	st.t.Helper()
+	payload := fmt.Sprintf(`{"type":"block_actions","user":{"id":"U123","username":"octocat"},"channel":{"id":"C123","name":"dev"},"response_url":%q,"actions":[{"action_id":%q,"value":%q}]}`,
+		st.response.URL, actionID, value)
+	code, _ := st.post("/hooks/slack/interaction", url.Values{"payload": {payload}}, time.Now())
+	return code
+}
+
+// waitMessage waits for the message posted in the channel.
+func (st *slackTest) waitMessage() slackTestMessage {
+	st.t.Helper()
+	for i := 0; i < 50; i++ {
+		st.mu.Lock()
+		for j, msg := range st.messages {
+			if msg.ResponseType == "in_channel" {
+				st.messages = append(st.messages[:j:j], st.messages[j+1:]...)
+				st.mu.Unlock()
+				return msg
+			}
+		}
+		st.mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+	}
+	st.t.Fatal("no messages are posted in the channel")
+	return slackTestMessage{}
+}
+
+func TestHookSlackCommand(t *testing.T) {
+	st := newSlackTest(t, &mirageecs.Slack{SigningSecret: "secret"})
+
+	// invalid signatures
+	if code, _ := st.post("/hooks/slack/command", url.Values{"text": {"list"}}, time.Now().Add(-10*time.Minute)); code != http.StatusUnauthorized {
+		t.Errorf("stale requests should be rejected: %d", code)
+	}
+
+	for _, text := range []string{"", "deploy foo", "launch", "launch foo app:1 unknown=1", "terminate foo bar"} {
+		code, msg := st.command(text)
+		if code != http.StatusOK || msg.ResponseType != "ephemeral" || msg.button("mirage_launch") != "" {
+			t.Errorf("%q should be rejected: %d %#v", text, code, msg)
+		}
+	}
+
+	code, msg := st.command("launch Foo app:1 branch=develop")
+	if code != http.StatusOK || msg.ResponseType != "ephemeral" {
+		t.Fatalf("unexpected response: %d %#v", code, msg)
+	}
+	value := msg.button("mirage_launch")
+	if value == "" {
+		t.Fatalf("no launch button: %#v", msg)
+	}
+	if code := st.click("mirage_launch", value); code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", code)
+	}
+	if msg := st.waitMessage(); !strings.Contains(msg.Text, "<@U123> launch `foo` (app:1, branch=develop): done") {
+		t.Errorf("unexpected message: %#v", msg)
+	}
+	info := waitRunning(t, st.m, "foo", true)
+	if info.GitBranch != "develop" {
+		t.Errorf("unexpected branch: %s", info.GitBranch)
+	}
+
+	if code, msg := st.command("list"); code != http.StatusOK || msg.ResponseType != "in_channel" {
+		t.Errorf("unexpected response: %d %#v", code, msg)
+	}
+	if msg := st.waitMessage(); !strings.Contains(msg.Text, "1 environments are running.") || !strings.Contains(msg.Text, "`foo`") {
+		t.Errorf("unexpected message: %#v", msg)
+	}
+
+	_, msg = st.command("terminate foo")
+	value = msg.button("mirage_terminate")
+	if value == "" {
+		t.Fatalf("no terminate button: %#v", msg)
+	}
+	st.click("mirage_terminate", value)
+	if msg := st.waitMessage(); !strings.Contains(msg.Text, "terminate `foo`: done") {
+		t.Errorf("unexpected message: %#v", msg)
+	}
+	waitRunning(t, st.m, "foo", false)
+}
+
+func TestHookSlackCommandNotAllowed(t *testing.T) {
+	st := newSlackTest(t, &mirageecs.Slack{SigningSecret: "secret", Channels: []string{"#release"}})
+	if _, msg := st.command("launch foo app:1"); msg.ResponseType != "ephemeral" || !strings.Contains(msg.Text, "not allowed") {
+		t.Errorf("unexpected response: %#v", msg)
+	}
+	st.click("mirage_launch", `{"action":"launch","subdomain":"foo","taskdef":["app:1"]}`)
+	time.Sleep(100 * time.Millisecond)
+	waitRunning(t, st.m, "foo", false)
+}
@@ -0,0 +1,289 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// TagSleeping is a tag of services of sleeping environments. The value is the reason. e.g. "schedule:office-hours"
+	TagSleeping = "MirageSleeping"
+
+	statusSleeping = "SLEEPING"
+)
+
+// sleepingStore keeps tasks of sleeping environments to relaunch them on wake in the task mode.
+// Services of sleeping environments are kept by ECS with the desired count 0, but stopped tasks are not.
+// They are kept in the persistence if configured, to wake them after restarts of mirage-ecs. Otherwise they are kept in memory.
+type sleepingStore struct {
+	store persistenceStore
+	mu    sync.Mutex
+	envs  map[string]*sleepingEnv // subdomain -> env
+}
+
+type sleepingEnv struct {
+	reason string
+	tasks  []*Information
+}
+
+func (s *sleepingStore) add(ctx context.Context, subdomain, reason string, tasks []*Information) error {
+	if s.store != nil {
+		return s.store.saveSleeping(ctx, &persistedSleeping{
+			Subdomain: subdomain,
+			Reason:    reason,
+			Tasks:     lo.Map(tasks, func(info *Information, _ int) *persistedSleepingTask { return newPersistedSleepingTask(info) }),
+			SleptAt:   time.Now(),
+		})
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.envs == nil {
+		s.envs = make(map[string]*sleepingEnv)
+	}
+	s.envs[subdomain] = &sleepingEnv{reason: reason, tasks: tasks}
+	return nil
+}
+
+func (s *sleepingStore) remove(ctx context.Context, subdomain string) (*sleepingEnv, bool, error) {
+	if s.store != nil {
+		p, err := s.store.deleteSleeping(ctx, subdomain)
+		if err != nil || p == nil {
+			return nil, false, err
+		}
+		return p.env(), true, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	env, ok := s.envs[subdomain]
+	delete(s.envs, subdomain)
+	return env, ok, nil
+}
+
+func (s *sleepingStore) list(ctx context.Context) ([]*Information, error) {
+	var envs []*sleepingEnv
+	if s.store != nil {
+		ps, err := s.store.listSleeping(ctx)
+		if err != nil {
+			return nil, err
+		}
+		envs = lo.Map(ps, func(p *persistedSleeping, _ int) *sleepingEnv { return p.env() })
+	} else {
+		s.mu.Lock()
+		envs = lo.Values(s.envs)
+		s.mu.Unlock()
+	}
+	var infos []*Information
+	for _, env := range envs {
+		for _, task := range env.tasks {
+			infos = append(infos, sleepingInformation(task, env.reason))
+		}
+	}
+	return infos, nil
+}
+
+func (p *persistedSleeping) env() *sleepingEnv {
+	return &sleepingEnv{
+		reason: p.Reason,
+		tasks:  lo.Map(p.Tasks, func(t *persistedSleepingTask, _ int) *Information { return t.information() }),
+	}
+}
+
+// sleepingInformation returns a snapshot of the task of the sleeping environment to list.
+func sleepingInformation(task *Information, reason string) *Information {
+	info := *task
+	info.LastStatus = statusSleeping
+	info.SleepReason = reason
+	info.IPAddress = ""
+	info.IPv6Address = ""
+	info.Ready = false
+	info.HealthStatus = ""
+	info.task = nil
+	return &info
+}
+
+// Sleep stops environments of the subdomain keeping their launch parameters, to resume them by Wake.
+func (e *ECS) Sleep(ctx context.Context, subdomain string, reason string) error {
+	infos, err := e.find(ctx, subdomain)
+	if err != nil {
+		return err
+	}
+	if len(infos) == 0 {
+		return fmt.Errorf("subdomain %s is not found", subdomain)
+	}
+	if e.cfg.ECS.ServiceMode {
+		services, err := e.findServices(ctx, subdomain)
+		if err != nil {
+			return err
+		}
+		for _, s := range services {
+			if err := e.scaleService(ctx, s, 0, reason); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := e.sleeping.add(ctx, subdomain, reason, infos); err != nil {
+			return fmt.Errorf("failed to keep sleeping subdomain %s: %w", subdomain, err)
+		}
+		var eg errgroup.Group
+		for _, info := range infos {
+			info := info
+			eg.Go(func() error {
+				return e.stopTask(ctx, info.ID)
+			})
+		}
+		if err := eg.Wait(); err != nil {
+			return err
+		}
+	}
+	slog.Info(f("subdomain %s is sleeping: %s", subdomain, reason))
+	return nil
+}
+
+// Wake resumes the sleeping environment of the subdomain with the same launch parameters.
+func (e *ECS) Wake(ctx context.Context, subdomain string) error {
+	if e.cfg.ECS.ServiceMode {
+		services, err := e.findServices(ctx, subdomain)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, s := range services {
+			if getTag(s.Tags, TagSleeping) == "" {
+				continue
+			}
+			found = true
+			if err := e.scaleService(ctx, s, 1, ""); err != nil {
+				return err
+			}
+		}
+		if !found {
+			return fmt.Errorf("subdomain %s is not sleeping", subdomain)
+		}
+	} else {
+		env, ok, err := e.sleeping.remove(ctx, subdomain)
+		if err != nil {
+			return fmt.Errorf("failed to get sleeping subdomain %s: %w", subdomain, err)
+		}
+		if !ok {
+			return fmt.Errorf("subdomain %s is not sleeping", subdomain)
+		}
+		var eg errgroup.Group
+		for _, info := range env.tasks {
+			info := info
+			eg.Go(func() error {
+				return e.Relaunch(ctx, info)
+			})
+		}
+		if err := eg.Wait(); err != nil {
+			return err
+		}
+	}
+	slog.Info(f("subdomain %s is waking up", subdomain))
+	return nil
+}
+
+// scaleService updates the desired count of the service, and tags the reason of sleeping.
+func (e *ECS) scaleService(ctx context.Context, s types.Service, count int32, reason string) error {
+	_, err := e.svc.UpdateService(ctx, &ecs.UpdateServiceInput{
+		Cluster:      aws.String(e.cfg.ECS.Cluster),
+		Service:      s.ServiceArn,
+		DesiredCount: aws.Int32(count),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update the desired count of service %s: %w", aws.ToString(s.ServiceName), err)
+	}
+	if reason != "" {
+		_, err = e.svc.TagResource(ctx, &ecs.TagResourceInput{
+			ResourceArn: s.ServiceArn,
+			Tags:        []types.Tag{{Key: aws.String(TagSleeping), Value: aws.String(reason)}},
+		})
+	} else {
+		_, err = e.svc.UntagResource(ctx, &ecs.UntagResourceInput{
+			ResourceArn: s.ServiceArn,
+			TagKeys:     []string{TagSleeping},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to tag service %s: %w", aws.ToString(s.ServiceName), err)
+	}
+	return nil
+}
+
+// ListSleeping returns sleeping environments.
+func (e *ECS) ListSleeping(ctx context.Context) ([]*Information, error) {
+	if !e.cfg.ECS.ServiceMode {
+		return e.sleeping.list(ctx)
+	}
+	services, err := e.listServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var infos []*Information
+	for _, s := range services {
+		reason := getTag(s.Tags, TagSleeping)
+		if reason == "" {
+			continue
+		}
+		infos = append(infos, &Information{
+			ID:          aws.ToString(s.ServiceArn),
+			ShortID:     aws.ToString(s.ServiceName),
+			SubDomain:   decodeTagValue(getTag(s.Tags, TagSubdomain)),
+			TaskDef:     shortenArn(aws.ToString(s.TaskDefinition)),
+			LastStatus:  statusSleeping,
+			Tags:        s.Tags,
+			Option:      launchOptionFromTags(s.Tags),
+			Service:     aws.ToString(s.ServiceName),
+			Protected:   getTag(s.Tags, TagProtected) == "true",
+			SleepReason: reason,
+		})
+	}
+	return infos, nil
+}
+
+// sleepingSubdomains returns the reasons of sleeping subdomains.
+func sleepingSubdomains(sleeping []*Information) map[string]string {
+	m := make(map[string]string, len(sleeping))
+	for _, info := range sleeping {
+		m[info.SubDomain] = info.SleepReason
+	}
+	return m
+}
+
+// setSleeping replaces sleeping subdomains to respond to requests for them.
+func (m *Mirage) setSleeping(sleeping map[string]string) {
+	m.sleepingMu.Lock()
+	defer m.sleepingMu.Unlock()
+	m.sleeping = sleeping
+}
+
+func (m *Mirage) sleepReason(subdomain string) (string, bool) {
+	m.sleepingMu.RLock()
+	defer m.sleepingMu.RUnlock()
+	reason, ok := m.sleeping[subdomain]
+	return reason, ok
+}
+
+// serveSleeping responds to requests for the sleeping environment.
+func (m *Mirage) serveSleeping(w http.ResponseWriter, req *http.Request, subdomain, reason string) {
+	msg := fmt.Sprintf("%s is sleeping (%s).", subdomain, reason)
+	if s, ok := m.Config.ECS.scheduleOf(reason); ok {
+		msg += fmt.Sprintf(" it will be resumed at %s.", s.nextStart(time.Now()).Format("2006-01-02 15:04 MST"))
+	}
+	httpError(w, req, msg, http.StatusServiceUnavailable)
+}
+
+// subdomainOf returns the subdomain of the host.
+func subdomainOf(host string) string {
+	return strings.ToLower(strings.Split(host, ".")[0])
+}
@@ -0,0 +1,84 @@
+package mirageecs
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// States of environments by last statuses of ECS tasks and health checks, shown as badges in the web interface.
+const (
+	StateProvisioning = "PROVISIONING"
+	StateStarting     = "STARTING"
+	StateHealthy      = "HEALTHY"
+	StateUnhealthy    = "UNHEALTHY"
+	StateStopping     = "STOPPING"
+	StateStopped      = "STOPPED"
+	StateSleeping     = statusSleeping
+)
+
+// StateRefreshInterval is an interval to refresh the list of environments in the web interface while environments are in transition
+// (PROVISIONING, STARTING or STOPPING).
+const StateRefreshInterval = 3 * time.Second
+
+// state returns the state of the environment.
+func (info *Information) state() string {
+	switch info.LastStatus {
+	case statusSleeping:
+		return StateSleeping
+	case statusStopped, "DELETED":
+		return StateStopped
+	case "DEACTIVATING", "STOPPING", "DEPROVISIONING":
+		return StateStopping
+	case "PROVISIONING", "PENDING":
+		if info.stopRequested() {
+			return StateStopping
+		}
+		return StateProvisioning
+	}
+	if info.stopRequested() {
+		return StateStopping
+	}
+	if info.LastStatus != statusRunning {
+		// ACTIVATING
+		return StateStarting
+	}
+	if info.HealthStatus == string(types.HealthStatusUnhealthy) {
+		return StateUnhealthy
+	}
+	if !info.Ready {
+		return StateStarting
+	}
+	return StateHealthy
+}
+
+// stopRequested reports whether the task is requested to stop but not stopped yet.
+func (info *Information) stopRequested() bool {
+	return info.task != nil && aws.ToString(info.task.DesiredStatus) == statusStopped
+}
+
+// setStates sets states of environments.
+func setStates(infos []*Information) {
+	for _, info := range infos {
+		info.State = info.state()
+	}
+}
+
+// StateColor returns the color class of the badge of the state in the web interface.
+func (info *Information) StateColor() string {
+	switch info.State {
+	case StateProvisioning:
+		return "bg-secondary"
+	case StateStarting:
+		return "bg-info text-dark"
+	case StateHealthy:
+		return "bg-success"
+	case StateUnhealthy:
+		return "bg-danger"
+	case StateStopping:
+		return "bg-warning text-dark"
+	default:
+		return "bg-dark"
+	}
+}
@@ -0,0 +1,104 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestInformationState(t *testing.T) {
+	for _, c := range []struct {
+		name          string
+		info          mirageecs.Information
+		desiredStatus string
+		want          string
+	}{
+		{"provisioning", mirageecs.Information{LastStatus: "PROVISIONING"}, "RUNNING", mirageecs.StateProvisioning},
+		{"pending", mirageecs.Information{LastStatus: "PENDING"}, "RUNNING", mirageecs.StateProvisioning},
+		{"activating", mirageecs.Information{LastStatus: "ACTIVATING"}, "RUNNING", mirageecs.StateStarting},
+		{"waiting for health checks", mirageecs.Information{LastStatus: "RUNNING", HealthStatus: "UNKNOWN"}, "RUNNING", mirageecs.StateStarting},
+		{"healthy", mirageecs.Information{LastStatus: "RUNNING", HealthStatus: "HEALTHY", Ready: true}, "RUNNING", mirageecs.StateHealthy},
+		{"without health checks", mirageecs.Information{LastStatus: "RUNNING", Ready: true}, "", mirageecs.StateHealthy},
+		{"unhealthy", mirageecs.Information{LastStatus: "RUNNING", HealthStatus: "UNHEALTHY"}, "RUNNING", mirageecs.StateUnhealthy},
+		{"unhealthy after the timeout", mirageecs.Information{LastStatus: "RUNNING", HealthStatus: "UNHEALTHY", Ready: true}, "RUNNING", mirageecs.StateUnhealthy},
+		{"stop requested", mirageecs.Information{LastStatus: "RUNNING", Ready: true}, "STOPPED", mirageecs.StateStopping},
+		{"stop requested before running", mirageecs.Information{LastStatus: "PENDING"}, "STOPPED", mirageecs.StateStopping},
+		{"deactivating", mirageecs.Information{LastStatus: "DEACTIVATING"}, "STOPPED", mirageecs.StateStopping},
+		{"stopped", mirageecs.Information{LastStatus: "STOPPED"}, "STOPPED", mirageecs.StateStopped},
+		{"sleeping", mirageecs.Information{LastStatus: "SLEEPING"}, "", mirageecs.StateSleeping},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			info := c.info
+			if c.desiredStatus != "" {
+				info.SetTask(&types.Task{DesiredStatus: aws.String(c.desiredStatus)})
+			}
+			if got := info.StateOf(); got != c.want {
+				t.Errorf("wanted %s, got %s", c.want, got)
+			}
+		})
+	}
+}
+
+func TestListStates(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	for _, subdomain := range []string{"foo", "bar"} {
+		if err := m.Runner().Launch(ctx, subdomain, mirageecs.TaskParameter{"branch": "develop"}, &mirageecs.LaunchOption{}, "app:1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Runner().TerminateBySubdomain(ctx, "bar"); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	res, err := ts.Client().Get(ts.URL + "/api/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var r mirageecs.APIListResponse
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || len(r.Result) == 0 {
+		t.Fatalf("unexpected list: %d %#v", res.StatusCode, r)
+	}
+	for _, info := range r.Result {
+		want := mirageecs.StateHealthy
+		if info.SubDomain == "bar" {
+			want = mirageecs.StateStopped
+		}
+		if info.State != want {
+			t.Errorf("state of %s: wanted %s, got %s", info.SubDomain, want, info.State)
+		}
+	}
+
+	// stopped environments are shown only in the web interface by default
+	res, err = ts.Client().Get(ts.URL + "/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, _ := io.ReadAll(res.Body)
+	for _, s := range []string{`data-subdomain="foo" data-state="HEALTHY"`, `data-subdomain="bar" data-state="STOPPED"`} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("list doesn't have %s: %s", s, b)
+		}
+	}
+}
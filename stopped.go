@@ -0,0 +1,41 @@
+package mirageecs
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/labstack/echo/v4"
+)
+
+// recentlyStopped returns stopped tasks within the retention, recently stopped first. Zero retention keeps all.
+func recentlyStopped(stopped []*Information, retention time.Duration) []*Information {
+	infos := make([]*Information, 0, len(stopped))
+	for _, info := range stopped {
+		if retention > 0 && (info.StoppedAt == nil || time.Since(*info.StoppedAt) > retention) {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return stoppedAt(infos[i]).After(stoppedAt(infos[j]))
+	})
+	return infos
+}
+
+// FailedToStart reports whether the task stopped before it started.
+func (info *Information) FailedToStart() bool {
+	return info.StopCode == string(types.TaskStopCodeTaskFailedToStart) || info.Created.IsZero()
+}
+
+// Stopped shows recently stopped tasks with reasons, to diagnose failures of launches.
+func (api *WebApi) Stopped(c echo.Context) error {
+	stopped, err := api.runner.List(c.Request().Context(), statusStopped)
+	if err != nil {
+		return c.Render(http.StatusOK, "stopped.html", map[string]interface{}{"error": err})
+	}
+	return c.Render(http.StatusOK, "stopped.html", map[string]interface{}{
+		"info": recentlyStopped(stopped, api.cfg.ECS.StoppedTaskRetention),
+	})
+}
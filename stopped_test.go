@@ -0,0 +1,100 @@
+package mirageecs_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRecentlyStopped(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(-d)
+		return &t
+	}
+	stopped := []*mirageecs.Information{
+		{ID: "crash-1", SubDomain: "crash", StoppedAt: at(20 * time.Minute)},
+		{ID: "alive-old", SubDomain: "alive", StoppedAt: at(10 * time.Minute)},
+		{ID: "crash-2", SubDomain: "crash", StoppedAt: at(5 * time.Minute)},
+		{ID: "expired", SubDomain: "expired", StoppedAt: at(2 * time.Hour)},
+	}
+	ids := func(infos []*mirageecs.Information) []string {
+		var ids []string
+		for _, info := range infos {
+			ids = append(ids, info.ID)
+		}
+		return ids
+	}
+	if diff := cmp.Diff([]string{"crash-2", "alive-old", "crash-1"}, ids(mirageecs.RecentlyStopped(stopped, time.Hour))); diff != "" {
+		t.Errorf("unexpected stopped tasks (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"crash-2", "alive-old", "crash-1", "expired"}, ids(mirageecs.RecentlyStopped(stopped, 0))); diff != "" {
+		t.Errorf("unexpected stopped tasks without retention (-want +got):\n%s", diff)
+	}
+}
+
+func TestFailedToStart(t *testing.T) {
+	for _, c := range []struct {
+		info mirageecs.Information
+		want bool
+	}{
+		{mirageecs.Information{StopCode: "TaskFailedToStart", Created: time.Now()}, true},
+		{mirageecs.Information{StopCode: "EssentialContainerExited"}, true},
+		{mirageecs.Information{StopCode: "EssentialContainerExited", Created: time.Now()}, false},
+		{mirageecs.Information{StopCode: "UserInitiated", Created: time.Now()}, false},
+	} {
+		if got := c.info.FailedToStart(); got != c.want {
+			t.Errorf("FailedToStart of %#v: wanted %t, got %t", c.info, c.want, got)
+		}
+	}
+}
+
+func TestStoppedTab(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	get := func() string {
+		t.Helper()
+		res, err := ts.Client().Get(ts.URL + "/stopped")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status: %d", res.StatusCode)
+		}
+		b, _ := io.ReadAll(res.Body)
+		return string(b)
+	}
+	if body := get(); !strings.Contains(body, "No environments have stopped recently.") {
+		t.Errorf("unexpected empty tab: %s", body)
+	}
+
+	if err := m.Runner().Launch(ctx, "foo", mirageecs.TaskParameter{"branch": "develop"}, &mirageecs.LaunchOption{}, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Runner().TerminateBySubdomain(ctx, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	body := get()
+	for _, s := range []string{`data-subdomain="foo"`, "Terminate requested by Mirage", `href="/logs/foo"`} {
+		if !strings.Contains(body, s) {
+			t.Errorf("stopped tab doesn't have %s: %s", s, body)
+		}
+	}
+}
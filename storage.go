@@ -0,0 +1,109 @@
+package mirageecs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const storageMetaBucket = "meta"
+
+// storageSchemaVersionKey is a key of the version of the schema in the meta bucket.
+var storageSchemaVersionKey = []byte("schema_version")
+
+// Storage keeps the history and the state of mirage-ecs in a local database file, without AWS services.
+// It is for single-instance deployments. history and persistence are stored in the file unless they have their own backends.
+type Storage struct {
+	// File is a path of the database file. It should be on a persistent volume, e.g. EFS.
+	File string `yaml:"file"`
+
+	// HtmlDir and DataDir are the storage section of mirage v1. Deprecated: use htmldir at the top level.
+	HtmlDir string `yaml:"htmldir" schema:"deprecated"`
+	DataDir string `yaml:"datadir" schema:"deprecated"`
+}
+
+func (s *Storage) validate() error {
+	if s == nil {
+		return nil
+	}
+	if s.File == "" {
+		return fmt.Errorf("storage.file is required")
+	}
+	return nil
+}
+
+// storageMigrations migrate the schema of the storage. The schema version is the number of applied migrations.
+// Migrations must not be changed or removed once released. Add a new migration to change the schema.
+var storageMigrations = []func(tx *bolt.Tx) error{
+	// 1: buckets of the history and the persistence
+	func(tx *bolt.Tx) error {
+		for _, name := range []string{historyBucket, persistenceBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 2: a bucket of sleeping environments
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(sleepingBucket))
+		return err
+	},
+}
+
+// applyDefaults enables the history and the persistence in the storage, unless they are configured.
+func (s *Storage) applyDefaults(c *Config) {
+	if s == nil {
+		return
+	}
+	if c.History == nil {
+		c.History = &History{}
+	}
+	if c.Persistence == nil {
+		c.Persistence = &Persistence{}
+	}
+}
+
+func (c *Config) openStorage() error {
+	s := c.Storage
+	if s == nil {
+		return nil
+	}
+	db, err := bolt.Open(s.File, 0600, &bolt.Options{Timeout: historyOpenLimit})
+	if err != nil {
+		return fmt.Errorf("failed to open storage file %s: %w", s.File, err)
+	}
+	if err := migrateStorage(db); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to migrate storage file %s: %w", s.File, err)
+	}
+	c.storage = db
+	c.cleanups = append(c.cleanups, db.Close)
+	return nil
+}
+
+// migrateStorage applies migrations which have not been applied to the database.
+func migrateStorage(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(storageMetaBucket))
+		if err != nil {
+			return err
+		}
+		var version uint64
+		if v := meta.Get(storageSchemaVersionKey); v != nil {
+			version = binary.BigEndian.Uint64(v)
+		}
+		if version > uint64(len(storageMigrations)) {
+			return fmt.Errorf("schema version %d is newer than this mirage-ecs (%d)", version, len(storageMigrations))
+		}
+		for i := version; i < uint64(len(storageMigrations)); i++ {
+			if err := storageMigrations[i](tx); err != nil {
+				return fmt.Errorf("migration %d failed: %w", i+1, err)
+			}
+			slog.Info(f("storage schema is migrated to version %d", i+1))
+		}
+		return meta.Put(storageSchemaVersionKey, binary.BigEndian.AppendUint64(nil, uint64(len(storageMigrations))))
+	})
+}
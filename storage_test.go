@@ -0,0 +1,126 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestStorageValidate(t *testing.T) {
+	if err := (&mirageecs.Storage{}).Validate(); err == nil {
+		t.Error("storage without file should be invalid")
+	}
+	if err := (&mirageecs.Storage{File: "/tmp/mirage.db"}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func newStorageMirage(t *testing.T, file string) *mirageecs.Mirage {
+	t.Helper()
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Storage = &mirageecs.Storage{File: file}
+	if err := cfg.OpenStorage(); err != nil {
+		t.Fatal(err)
+	}
+	return mirageecs.New(ctx, cfg)
+}
+
+func storageSchemaVersion(t *testing.T, file string) uint64 {
+	t.Helper()
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var version uint64
+	if err := db.View(func(tx *bolt.Tx) error {
+		version = binary.BigEndian.Uint64(tx.Bucket([]byte("meta")).Get([]byte("schema_version")))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "mirage.db")
+
+	m := newStorageMirage(t, file)
+	if m.Config.History == nil || m.Config.Persistence == nil {
+		t.Fatal("history and persistence should be enabled by the storage")
+	}
+	if err := m.Runner().Launch(ctx, "foo", mirageecs.TaskParameter{"branch": "develop"}, nil, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+	m.ReverseProxy.CountAccess("foo")
+	if err := m.SaveState(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m.Config.Cleanup()
+
+	if v := storageSchemaVersion(t, file); v != uint64(mirageecs.StorageSchemaVersion) {
+		t.Errorf("unexpected schema version: %d", v)
+	}
+
+	// restarted
+	m = newStorageMirage(t, file)
+	defer m.Config.Cleanup()
+	m.RestoreState(ctx)
+	if !m.ReverseProxy.Exists("foo") {
+		t.Error("route of foo is not restored")
+	}
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+	res, err := ts.Client().Get(ts.URL + "/api/history?subdomain=foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var r mirageecs.APIHistoryResponse
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if events := r.Result; len(events) != 1 || events[0].Event != mirageecs.HistoryLaunched {
+		t.Errorf("unexpected events: %#v", r.Result)
+	}
+}
+
+func TestStorageNewerSchema(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mirage.db")
+	m := newStorageMirage(t, file)
+	m.Config.Cleanup()
+
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		v := binary.BigEndian.AppendUint64(nil, uint64(mirageecs.StorageSchemaVersion+1))
+		return tx.Bucket([]byte("meta")).Put([]byte("schema_version"), v)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	cfg, err := mirageecs.NewConfig(context.Background(), &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cfg.Cleanup()
+	cfg.Storage = &mirageecs.Storage{File: file}
+	if err := cfg.OpenStorage(); err == nil {
+		t.Error("storage of a newer schema should not be opened")
+	}
+}
@@ -0,0 +1,108 @@
+package mirageecs
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+var DefaultStreamingContentTypes = []string{
+	"text/event-stream",
+	"application/x-ndjson",
+}
+
+// streaming decides whether a response should be streamed to the client without buffering.
+type streaming struct {
+	contentTypes []string
+	always       bool
+}
+
+func newStreaming(n Network, port PortMap) *streaming {
+	s := &streaming{
+		contentTypes: n.StreamingContentTypes,
+		always:       port.Streaming,
+	}
+	if len(s.contentTypes) == 0 {
+		s.contentTypes = DefaultStreamingContentTypes
+	}
+	return s
+}
+
+func (s *streaming) match(h http.Header) bool {
+	if s == nil {
+		return false
+	}
+	return s.always || matchContentType(s.contentTypes, h.Get("Content-Type"))
+}
+
+type streamingHandler struct {
+	streaming *streaming
+	next      http.Handler
+}
+
+func newStreamingHandler(s *streaming, next http.Handler) http.Handler {
+	return &streamingHandler{streaming: s, next: next}
+}
+
+func (h *streamingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.next.ServeHTTP(w, req)
+		return
+	}
+	h.next.ServeHTTP(&streamingWriter{
+		ResponseWriter: w,
+		flusher:        flusher,
+		streaming:      h.streaming,
+	}, req)
+}
+
+// streamingWriter flushes each write to the client when the response is streaming.
+type streamingWriter struct {
+	http.ResponseWriter
+	flusher     http.Flusher
+	streaming   *streaming
+	wroteHeader bool
+	flush       bool
+}
+
+func (w *streamingWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.flush = w.streaming.match(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(code)
+	if w.flush {
+		w.flusher.Flush()
+	}
+}
+
+func (w *streamingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	if w.flush {
+		w.flusher.Flush()
+	}
+	return n, err
+}
+
+func (w *streamingWriter) Flush() {
+	w.flusher.Flush()
+}
+
+// Hijack lets the reverse proxy take over the connection for upgrade requests (e.g. WebSocket).
+func (w *streamingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not implement http.Hijacker", w.ResponseWriter)
+	}
+	return hj.Hijack()
+}
+
+// Unwrap returns the original ResponseWriter for http.ResponseController.
+func (w *streamingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
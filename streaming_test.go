@@ -0,0 +1,139 @@
+package mirageecs_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestStreaming(t *testing.T) {
+	done := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: hello\n\n")
+		w.(http.Flusher).Flush()
+		<-done // hold the connection until the client receives the first event
+	}))
+	defer backend.Close()
+	defer close(done)
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	cfg, err := mirageecs.NewConfig(context.Background(), &mirageecs.ConfigParams{
+		Domain: "example.net",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Listen.HTTP = []mirageecs.PortMap{
+		{ListenPort: 80, TargetPort: port},
+	}
+	cfg.Network.Compression = &mirageecs.Compression{Enabled: true}
+	rp := mirageecs.NewReverseProxy(cfg)
+	rp.AddSubdomain("sse", "127.0.0.1", port, nil)
+	h := rp.FindHandler("sse", 80)
+	if h == nil {
+		t.Fatal("handler not found")
+	}
+	front := httptest.NewServer(h)
+	defer front.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, front.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" {
+		t.Errorf("streaming response should not be compressed: %s", enc)
+	}
+
+	lines := make(chan string)
+	go func() {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		if line != "data: hello\n" {
+			t.Errorf("unexpected line %q", line)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("the event was not flushed")
+	}
+}
+
+func TestStreamingUpgrade(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		fmt.Fprint(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		io.Copy(conn, br) // echo
+	}()
+	port := backend.Addr().(*net.TCPAddr).Port
+
+	cfg, err := mirageecs.NewConfig(context.Background(), &mirageecs.ConfigParams{
+		Domain: "example.net",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Listen.HTTP = []mirageecs.PortMap{
+		{ListenPort: 80, TargetPort: port},
+	}
+	rp := mirageecs.NewReverseProxy(cfg)
+	rp.AddSubdomain("ws", "127.0.0.1", port, nil)
+	h := rp.FindHandler("ws", 80)
+	if h == nil {
+		t.Fatal("handler not found")
+	}
+	front := httptest.NewServer(h)
+	defer front.Close()
+
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: ws.example.net\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	fmt.Fprint(conn, "ping\n")
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "ping\n" {
+		t.Errorf("unexpected line %q", line)
+	}
+}
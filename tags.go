@@ -0,0 +1,62 @@
+package mirageecs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// DefaultParameterTagPrefix is a prefix of tag keys which launch parameters are propagated to.
+const DefaultParameterTagPrefix = "mirage-"
+
+const maxTagKeyLength = 128
+
+// invalidTagChars matches characters which are not allowed in keys and values of tags.
+var invalidTagChars = regexp.MustCompile(`[^\p{L}\p{Z}\p{N}_.:/=+\-@]`)
+
+func validateParameterTagPrefix(prefix string, params Parameters) error {
+	if prefix == "" {
+		return fmt.Errorf("parameter_tag_prefix must not be empty")
+	}
+	if strings.HasPrefix(strings.ToLower(prefix), "aws:") {
+		return fmt.Errorf("parameter_tag_prefix must not start with aws: %s", prefix)
+	}
+	for _, p := range params {
+		if !p.PropagateTag {
+			continue
+		}
+		key := prefix + p.Name
+		if len(key) > maxTagKeyLength || invalidTagChars.MatchString(key) {
+			return fmt.Errorf("invalid tag key for parameter %s: %s", p.Name, key)
+		}
+	}
+	return nil
+}
+
+// ToPropagatedTags returns tags of the parameters which have propagate_tag.
+// Keys of the tags are prefixed, so they can be distinguished in cost allocation reports.
+func (p TaskParameter) ToPropagatedTags(configParams Parameters, prefix string) []types.Tag {
+	var tags []types.Tag
+	for _, v := range configParams {
+		if !v.PropagateTag || p[v.Name] == "" {
+			continue
+		}
+		tags = append(tags, types.Tag{
+			Key:   aws.String(prefix + v.Name),
+			Value: aws.String(sanitizeTagValue(p[v.Name])),
+		})
+	}
+	return tags
+}
+
+// sanitizeTagValue replaces characters which are not allowed in tag values and truncates it.
+func sanitizeTagValue(s string) string {
+	s = invalidTagChars.ReplaceAllString(s, "_")
+	if r := []rune(s); len(r) > maxTagValueLength {
+		s = string(r[:maxTagValueLength])
+	}
+	return s
+}
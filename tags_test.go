@@ -0,0 +1,57 @@
+package mirageecs_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestToPropagatedTags(t *testing.T) {
+	params := mirageecs.Parameters{
+		{Name: "branch", Env: "GIT_BRANCH", PropagateTag: true},
+		{Name: "ticket", Env: "TICKET", PropagateTag: true},
+		{Name: "owner", Env: "OWNER", PropagateTag: true},
+		{Name: "secret", Env: "SECRET"},
+	}
+	p := mirageecs.TaskParameter{
+		"branch": "feature/foo",
+		"ticket": "PROJ-123",
+		"secret": "xxx",
+		"owner":  "alice <alice@example.com>",
+	}
+	tags := p.ToPropagatedTags(params, "mirage-")
+	expected := []types.Tag{
+		{Key: aws.String("mirage-branch"), Value: aws.String("feature/foo")},
+		{Key: aws.String("mirage-ticket"), Value: aws.String("PROJ-123")},
+		{Key: aws.String("mirage-owner"), Value: aws.String("alice _alice@example.com_")},
+	}
+	if diff := cmp.Diff(expected, tags, cmpopts.IgnoreUnexported(types.Tag{})); diff != "" {
+		t.Errorf("Mismatch in Tags (-want +got):\n%s", diff)
+	}
+
+	long := mirageecs.TaskParameter{"ticket": strings.Repeat("x", 300)}
+	tags = long.ToPropagatedTags(params, "mirage-")
+	if len(tags) != 1 || len(aws.ToString(tags[0].Value)) != 256 {
+		t.Errorf("tag value must be truncated: %#v", tags)
+	}
+}
+
+func TestValidateParameterTagPrefix(t *testing.T) {
+	params := mirageecs.Parameters{{Name: "ticket", PropagateTag: true}}
+	for _, prefix := range []string{"mirage-", "Mirage", "cost/"} {
+		if err := mirageecs.ValidateParameterTagPrefix(prefix, params); err != nil {
+			t.Errorf("unexpected error for %s: %v", prefix, err)
+		}
+	}
+	for _, prefix := range []string{"", "aws:mirage-", "mirage#"} {
+		if err := mirageecs.ValidateParameterTagPrefix(prefix, params); err == nil {
+			t.Errorf("expected error for %q", prefix)
+		}
+	}
+}
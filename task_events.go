@@ -0,0 +1,173 @@
+package mirageecs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// DefaultSyncInterval is an interval to sync routes with running tasks.
+	DefaultSyncInterval = 10 * time.Second
+	// DefaultTaskEventsSyncInterval is an interval to sync routes when task state change events are received.
+	DefaultTaskEventsSyncInterval = time.Minute
+
+	// taskEventsWaitTime is a time of long polling of the SQS queue.
+	taskEventsWaitTime = 20
+)
+
+// TaskEvents syncs routes of environments on ECS Task State Change events, instead of frequent polling.
+// Events are received from an SQS queue (a target of an EventBridge rule) and (or) POST /api/ecs_event (an API destination).
+type TaskEvents struct {
+	// QueueURL is a URL of the SQS queue of events. Empty disables polling the queue.
+	QueueURL string `yaml:"queue_url"`
+	// SyncInterval is an interval to sync routes with running tasks, in case of lost events. Default is 1m.
+	SyncInterval time.Duration `yaml:"sync_interval"`
+}
+
+func (e *TaskEvents) validate() error {
+	if e == nil {
+		return nil
+	}
+	if e.SyncInterval == 0 {
+		e.SyncInterval = DefaultTaskEventsSyncInterval
+	}
+	if e.SyncInterval < DefaultSyncInterval {
+		return fmt.Errorf("ecs.task_events.sync_interval must be at least %s: %s", DefaultSyncInterval, e.SyncInterval)
+	}
+	return nil
+}
+
+// syncInterval returns an interval to sync routes.
+func (e *TaskEvents) syncInterval() time.Duration {
+	if e == nil {
+		return DefaultSyncInterval
+	}
+	return e.SyncInterval
+}
+
+// APIECSEventRequest is an EventBridge event of ECS Task State Change, sent to /api/ecs_event by an API destination.
+type APIECSEventRequest struct {
+	DetailType string `json:"detail-type"`
+	Source     string `json:"source"`
+	Detail     struct {
+		ClusterArn    string `json:"clusterArn"`
+		TaskArn       string `json:"taskArn"`
+		LastStatus    string `json:"lastStatus"`
+		DesiredStatus string `json:"desiredStatus"`
+		StoppedReason string `json:"stoppedReason"`
+	} `json:"detail"`
+}
+
+// of reports whether the event is a task state change of the cluster.
+func (ev *APIECSEventRequest) of(cluster string) bool {
+	if ev.Source != "aws.ecs" || ev.DetailType != "ECS Task State Change" {
+		return false
+	}
+	return ev.Detail.ClusterArn == cluster || strings.HasSuffix(ev.Detail.ClusterArn, ":cluster/"+cluster)
+}
+
+// taskEventNotifier notifies the sync loop of task state changes.
+// Notifications while a sync is pending are merged into it.
+type taskEventNotifier struct {
+	cluster string
+	ch      chan struct{}
+}
+
+func newTaskEventNotifier(cfg *Config) *taskEventNotifier {
+	if cfg.ECS.TaskEvents == nil {
+		return nil
+	}
+	return &taskEventNotifier{
+		cluster: cfg.ECS.Cluster,
+		ch:      make(chan struct{}, 1),
+	}
+}
+
+// C returns a channel to receive notifications. It is nil (never receives) when task events are not configured.
+func (n *taskEventNotifier) C() <-chan struct{} {
+	if n == nil {
+		return nil
+	}
+	return n.ch
+}
+
+// handle notifies the event, and reports whether the event is of the cluster.
+func (n *taskEventNotifier) handle(ctx context.Context, ev *APIECSEventRequest) bool {
+	if !ev.of(n.cluster) {
+		return false
+	}
+	slog.DebugContext(ctx, f("task %s is %s (desired %s) %s", ev.Detail.TaskArn, ev.Detail.LastStatus, ev.Detail.DesiredStatus, ev.Detail.StoppedReason))
+	select {
+	case n.ch <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// RunTaskEventsReceiver receives task state change events from the SQS queue.
+func (m *Mirage) RunTaskEventsReceiver(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	queueURL := m.Config.ECS.TaskEvents.QueueURL
+	svc := sqs.NewFromConfig(*m.Config.awscfg)
+	n := m.WebApi.taskEvents
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Debug("RunTaskEventsReceiver() is done")
+			return
+		default:
+		}
+		out, err := svc.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queueURL),
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     taskEventsWaitTime,
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Warn(f("failed to receive task events from %s: %s", queueURL, err))
+				time.Sleep(DefaultSyncInterval)
+			}
+			continue
+		}
+		for _, msg := range out.Messages {
+			var ev APIECSEventRequest
+			if err := json.Unmarshal([]byte(aws.ToString(msg.Body)), &ev); err != nil {
+				slog.Warn(f("invalid task event %s: %s", aws.ToString(msg.MessageId), err))
+			} else {
+				n.handle(ctx, &ev)
+			}
+			// invalid messages are deleted too, not to be received again
+			if _, err := svc.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(queueURL),
+				ReceiptHandle: msg.ReceiptHandle,
+			}); err != nil {
+				slog.Warn(f("failed to delete task event %s: %s", aws.ToString(msg.MessageId), err))
+			}
+		}
+	}
+}
+
+// ApiECSEvent syncs routes of environments by the task state change event.
+func (api *WebApi) ApiECSEvent(c echo.Context) error {
+	if api.taskEvents == nil {
+		return c.JSON(http.StatusNotFound, APICommonResponse{Result: "ecs.task_events is not configured"})
+	}
+	var ev APIECSEventRequest
+	if err := c.Bind(&ev); err != nil {
+		return c.JSON(http.StatusBadRequest, APICommonResponse{Result: err.Error()})
+	}
+	if !api.taskEvents.handle(c.Request().Context(), &ev) {
+		return c.JSON(http.StatusOK, APICommonResponse{Result: "ignored"})
+	}
+	return c.JSON(http.StatusAccepted, APICommonResponse{Result: "accepted"})
+}
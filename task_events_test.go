@@ -0,0 +1,101 @@
+package mirageecs_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestTaskEventsValidate(t *testing.T) {
+	e := &mirageecs.TaskEvents{}
+	if err := e.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if e.SyncInterval != mirageecs.DefaultTaskEventsSyncInterval {
+		t.Errorf("unexpected default sync_interval: %s", e.SyncInterval)
+	}
+	if err := (&mirageecs.TaskEvents{SyncInterval: time.Second}).Validate(); err == nil {
+		t.Error("sync_interval shorter than the default interval should be invalid")
+	}
+}
+
+func taskStateChangeEvent(cluster string) string {
+	return `{
+  "detail-type": "ECS Task State Change",
+  "source": "aws.ecs",
+  "detail": {
+    "clusterArn": "arn:aws:ecs:ap-northeast-1:123456789012:cluster/` + cluster + `",
+    "taskArn": "arn:aws:ecs:ap-northeast-1:123456789012:task/` + cluster + `/af8e7a6dad6e44d4862696002f41c2dc",
+    "lastStatus": "STOPPED",
+    "desiredStatus": "STOPPED",
+    "stoppedReason": "Essential container in task exited"
+  }
+}`
+}
+
+func TestApiECSEvent(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{LocalMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ECS.Cluster = "dev"
+
+	post := func(ts *httptest.Server, body string) int {
+		t.Helper()
+		res, err := ts.Client().Post(ts.URL+"/api/ecs_event", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	ts := httptest.NewServer(mirageecs.New(ctx, cfg).WebApi)
+	if code := post(ts, taskStateChangeEvent("dev")); code != http.StatusNotFound {
+		t.Errorf("unexpected status without task_events: %d", code)
+	}
+	ts.Close()
+
+	cfg.ECS.TaskEvents = &mirageecs.TaskEvents{}
+	if err := cfg.ECS.TaskEvents.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts = httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	if code := post(ts, taskStateChangeEvent("prod")); code != http.StatusOK {
+		t.Errorf("events of other clusters should be ignored: %d", code)
+	}
+	if code := post(ts, `{"detail-type":"ECS Container Instance State Change","source":"aws.ecs"}`); code != http.StatusOK {
+		t.Errorf("other events should be ignored: %d", code)
+	}
+	select {
+	case <-m.WebApi.TaskEventsC():
+		t.Error("ignored events must not notify")
+	default:
+	}
+
+	// notifications are merged while a sync is pending
+	for i := 0; i < 3; i++ {
+		if code := post(ts, taskStateChangeEvent("dev")); code != http.StatusAccepted {
+			t.Errorf("unexpected status: %d", code)
+		}
+	}
+	select {
+	case <-m.WebApi.TaskEventsC():
+	default:
+		t.Error("events should notify")
+	}
+	select {
+	case <-m.WebApi.TaskEventsC():
+		t.Error("notifications should be merged")
+	default:
+	}
+}
@@ -0,0 +1,267 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+)
+
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// replaceImageTag replaces a tag (or a digest) of the image.
+// e.g. replaceImageTag("123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/app:latest", "sha-abc")
+// returns "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/app:sha-abc"
+func replaceImageTag(image, tag string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	slash := strings.LastIndex(image, "/")
+	if colon := strings.LastIndex(image, ":"); colon > slash {
+		image = image[:colon]
+	}
+	return image + ":" + tag
+}
+
+// newRegisterTaskDefinitionInput returns a input to register a new revision of the task definition.
+func newRegisterTaskDefinitionInput(td *types.TaskDefinition, tags []types.Tag) *ecs.RegisterTaskDefinitionInput {
+	return &ecs.RegisterTaskDefinitionInput{
+		Family:                  td.Family,
+		ContainerDefinitions:    td.ContainerDefinitions,
+		Cpu:                     td.Cpu,
+		Memory:                  td.Memory,
+		EphemeralStorage:        td.EphemeralStorage,
+		ExecutionRoleArn:        td.ExecutionRoleArn,
+		TaskRoleArn:             td.TaskRoleArn,
+		InferenceAccelerators:   td.InferenceAccelerators,
+		IpcMode:                 td.IpcMode,
+		PidMode:                 td.PidMode,
+		NetworkMode:             td.NetworkMode,
+		PlacementConstraints:    td.PlacementConstraints,
+		ProxyConfiguration:      td.ProxyConfiguration,
+		RequiresCompatibilities: td.RequiresCompatibilities,
+		RuntimePlatform:         td.RuntimePlatform,
+		Volumes:                 td.Volumes,
+		Tags:                    tags,
+	}
+}
+
+// TagRegisteredFor is a tag of task definition revisions registered by mirage-ecs at launch.
+// The value is the subdomain, and the revision is deregistered when the subdomain is terminated.
+const TagRegisteredFor = "MirageRegisteredFor"
+
+// registerTaskDefinition registers a new revision of the task definition for the subdomain.
+func (e *ECS) registerTaskDefinition(ctx context.Context, subdomain string, in *ecs.RegisterTaskDefinitionInput) (*types.TaskDefinition, error) {
+	in.Tags = withTags(in.Tags, types.Tag{
+		Key:   aws.String(TagRegisteredFor),
+		Value: aws.String(encodeTagValue(subdomain)),
+	})
+	out, err := e.svc.RegisterTaskDefinition(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register task definition: %w", err)
+	}
+	slog.Info(f("registered task definition %s", aws.ToString(out.TaskDefinition.TaskDefinitionArn)))
+	return out.TaskDefinition, nil
+}
+
+// registeredFor reports whether the task definition is registered by mirage-ecs for the subdomain.
+func registeredFor(tags []types.Tag, subdomain string) bool {
+	v := getTag(tags, TagRegisteredFor)
+	return v != "" && decodeTagValue(v) == subdomain
+}
+
+// deregisterTaskDefinition deregisters the revision.
+// Failures are not fatal, because the revision is not used anymore.
+func (e *ECS) deregisterTaskDefinition(ctx context.Context, taskdef string) {
+	if _, err := e.svc.DeregisterTaskDefinition(ctx, &ecs.DeregisterTaskDefinitionInput{
+		TaskDefinition: aws.String(taskdef),
+	}); err != nil {
+		slog.Warn(f("failed to deregister task definition %s: %s", taskdef, err))
+		return
+	}
+	slog.Info(f("deregistered task definition %s", taskdef))
+}
+
+// deregisterTaskDefinitionFor deregisters the revision if it is registered for the subdomain.
+// Revisions which are not registered by mirage-ecs (e.g. specified by users) are kept.
+func (e *ECS) deregisterTaskDefinitionFor(ctx context.Context, taskdef string, subdomain string) {
+	out, err := e.svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(taskdef),
+		Include:        []types.TaskDefinitionField{types.TaskDefinitionFieldTags},
+	})
+	if err != nil {
+		slog.Warn(f("failed to describe task definition %s: %s", taskdef, err))
+		return
+	}
+	if !registeredFor(out.Tags, subdomain) || out.TaskDefinition.Status != types.TaskDefinitionStatusActive {
+		return
+	}
+	e.deregisterTaskDefinition(ctx, taskdef)
+}
+
+// TagBaseTaskDefinition is a tag of tasks running revisions registered by mirage-ecs at launch.
+// The value is the task definition specified at launch, and empty means the task definition template.
+const TagBaseTaskDefinition = "MirageBaseTaskDefinition"
+
+// baseTaskDef returns the task definition to launch the task again.
+// Revisions registered for the subdomain are deregistered by the termination before the launch, so the task definition specified at launch is returned for them.
+func (info *Information) baseTaskDef() string {
+	for _, t := range info.Tags {
+		if aws.ToString(t.Key) == TagBaseTaskDefinition {
+			return aws.ToString(t.Value)
+		}
+	}
+	return info.TaskDef
+}
+
+// baseTaskDefs returns task definitions to launch the tasks again.
+func baseTaskDefs(infos []*Information) []string {
+	return lo.Uniq(lo.Map(infos, func(info *Information, _ int) string { return info.baseTaskDef() }))
+}
+
+// deregistrableTaskDefs returns revisions used by the tasks, except ones to be launched.
+func deregistrableTaskDefs(infos []*Information, launching []string) []string {
+	keep := lo.Map(launching, func(td string, _ int) string {
+		if strings.HasPrefix(td, "arn:") {
+			return shortenArn(td)
+		}
+		return td
+	})
+	taskdefs := lo.Uniq(lo.Map(infos, func(info *Information, _ int) string { return info.TaskDef }))
+	return lo.Without(taskdefs, keep...)
+}
+
+// imageTagFor returns the image tag for the container specified at launch.
+func (o *LaunchOption) imageTagFor(container string) string {
+	if o == nil {
+		return ""
+	}
+	if tag := o.ImageTags[container]; tag != "" {
+		return tag
+	}
+	return o.ImageTag
+}
+
+func (o *LaunchOption) hasImageTags() bool {
+	return o != nil && (o.ImageTag != "" || len(o.ImageTags) > 0)
+}
+
+// taskDefinitionModifier modifies a task definition to be registered at launch.
+type taskDefinitionModifier func(in *ecs.RegisterTaskDefinitionInput)
+
+// imageTagModifier replaces images by the tags specified at launch.
+func imageTagModifier(opt *LaunchOption) taskDefinitionModifier {
+	return func(in *ecs.RegisterTaskDefinitionInput) {
+		containers := make([]types.ContainerDefinition, 0, len(in.ContainerDefinitions))
+		for _, c := range in.ContainerDefinitions {
+			if tag := opt.imageTagFor(aws.ToString(c.Name)); tag != "" {
+				image := replaceImageTag(aws.ToString(c.Image), tag)
+				slog.Info(f("override image of container %s: %s", aws.ToString(c.Name), image))
+				c.Image = aws.String(image)
+			}
+			containers = append(containers, c)
+		}
+		in.ContainerDefinitions = containers
+	}
+}
+
+// taskDefinitionModifiers returns modifiers to be applied to the task definition at launch.
+func (e *ECS) taskDefinitionModifiers(ctx context.Context, subdomain string, td *types.TaskDefinition, opt *LaunchOption) ([]taskDefinitionModifier, error) {
+	var mods []taskDefinitionModifier
+	if opt.hasImageTags() {
+		mods = append(mods, imageTagModifier(opt))
+	}
+	if opt != nil && len(opt.EntryPoint) > 0 {
+		mods = append(mods, entryPointModifier(opt))
+	}
+	family := osFamilyOf(td)
+	if rp := e.cfg.ECS.RuntimePlatform.runtimePlatformFor(opt); rp != nil && rp.needsModification(td) {
+		if err := rp.validateFor(td); err != nil {
+			return nil, err
+		}
+		mods = append(mods, runtimePlatformModifier(rp))
+		if rp.OperatingSystemFamily != "" {
+			family = rp.OperatingSystemFamily
+		}
+	}
+	if w := e.cfg.ECS.Windows; w != nil && w.LogConfiguration != nil && isWindowsFamily(family) {
+		mods = append(mods, windowsModifier(w))
+	}
+	if len(e.cfg.ECS.Sidecars) > 0 {
+		mods = append(mods, sidecarModifier(e.cfg.ECS.Sidecars))
+	}
+	if e.cfg.ECS.EFS != nil {
+		mod, err := e.efsModifier(ctx, subdomain)
+		if err != nil {
+			return nil, err
+		}
+		mods = append(mods, mod)
+	}
+	return mods, nil
+}
+
+// registerModifiedTaskDefinition registers a new revision of the task definition modified by mods.
+func (e *ECS) registerModifiedTaskDefinition(ctx context.Context, subdomain string, td *types.TaskDefinition, tags []types.Tag, mods []taskDefinitionModifier) (*types.TaskDefinition, error) {
+	in := newRegisterTaskDefinitionInput(td, tags)
+	for _, mod := range mods {
+		mod(in)
+	}
+	return e.registerTaskDefinition(ctx, subdomain, in)
+}
+
+func (o *LaunchOption) validateOverrides() error {
+	if o == nil {
+		return nil
+	}
+	for name := range o.Environment {
+		if !envNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name: %s", name)
+		}
+		if name == EnvSubdomain || name == EnvSubdomainRaw {
+			return fmt.Errorf("environment variable %s is reserved", name)
+		}
+	}
+	for _, s := range []string{o.Cpu, o.Memory} {
+		if s == "" {
+			continue
+		}
+		if strings.Trim(s, "0123456789") != "" {
+			return fmt.Errorf("cpu and memory must be integer: %s", s)
+		}
+	}
+	return nil
+}
+
+// applyOverrides applies cpu, memory and environment variables specified at launch to the task override.
+func (o *LaunchOption) applyOverrides(ov *types.TaskOverride) {
+	if o == nil {
+		return
+	}
+	if o.Cpu != "" {
+		ov.Cpu = aws.String(o.Cpu)
+	}
+	if o.Memory != "" {
+		ov.Memory = aws.String(o.Memory)
+	}
+	if len(o.Environment) == 0 {
+		return
+	}
+	extra := make([]types.KeyValuePair, 0, len(o.Environment))
+	for name, value := range o.Environment {
+		extra = append(extra, types.KeyValuePair{
+			Name:  aws.String(name),
+			Value: aws.String(value),
+		})
+	}
+	for i := range ov.ContainerOverrides {
+		c := &ov.ContainerOverrides[i]
+		// environment slices may be shared between containers, so copy before appending
+		c.Environment = append(c.Environment[:len(c.Environment):len(c.Environment)], extra...)
+	}
+}
@@ -0,0 +1,105 @@
+package mirageecs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+)
+
+// TaskDefinitionTemplateData is passed to the task definition template at launch.
+type TaskDefinitionTemplateData struct {
+	Subdomain string
+	Parameter TaskParameter
+	ImageTag  string
+}
+
+var taskDefinitionTemplateFuncs = template.FuncMap{
+	"env": func(key string, defaults ...string) string {
+		if v, ok := os.LookupEnv(key); ok {
+			return v
+		}
+		if len(defaults) > 0 {
+			return defaults[0]
+		}
+		return ""
+	},
+	"must_env": func(key string) (string, error) {
+		if v, ok := os.LookupEnv(key); ok {
+			return v, nil
+		}
+		return "", fmt.Errorf("environment variable %s is not defined", key)
+	},
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func (c *Config) loadTaskDefinitionTemplate(ctx context.Context) error {
+	p := c.ECS.TaskDefinitionTemplate
+	if p == "" {
+		return nil
+	}
+	var content []byte
+	var err error
+	if strings.HasPrefix(p, "s3://") {
+		content, err = loadFromS3(ctx, c.awscfg, p)
+	} else {
+		content, err = loadFromFile(p)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot load task definition template: %s: %w", p, err)
+	}
+	tmpl, err := template.New(p).Option("missingkey=zero").Funcs(taskDefinitionTemplateFuncs).Parse(string(content))
+	if err != nil {
+		return fmt.Errorf("invalid task definition template: %s: %w", p, err)
+	}
+	c.ECS.taskDefinitionTemplate = tmpl
+	return nil
+}
+
+// renderTaskDefinition renders the task definition template to an input of RegisterTaskDefinition.
+func renderTaskDefinition(tmpl *template.Template, data TaskDefinitionTemplateData) (*ecs.RegisterTaskDefinitionInput, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render task definition template: %w", err)
+	}
+	var in ecs.RegisterTaskDefinitionInput
+	if err := json.Unmarshal(buf.Bytes(), &in); err != nil {
+		return nil, fmt.Errorf("failed to parse rendered task definition: %w", err)
+	}
+	if aws.ToString(in.Family) == "" {
+		return nil, fmt.Errorf("family is required in the task definition template")
+	}
+	if len(in.ContainerDefinitions) == 0 {
+		return nil, fmt.Errorf("containerDefinitions is required in the task definition template")
+	}
+	return &in, nil
+}
+
+// registerTaskDefinitionFromTemplate registers a new revision rendered from the template and returns its ARN.
+func (e *ECS) registerTaskDefinitionFromTemplate(ctx context.Context, subdomain string, param TaskParameter, opt *LaunchOption) (string, error) {
+	data := TaskDefinitionTemplateData{
+		Subdomain: subdomain,
+		Parameter: param,
+	}
+	if opt != nil {
+		data.ImageTag = opt.ImageTag
+	}
+	in, err := renderTaskDefinition(e.cfg.ECS.taskDefinitionTemplate, data)
+	if err != nil {
+		return "", err
+	}
+	td, err := e.registerTaskDefinition(ctx, subdomain, in)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(td.TaskDefinitionArn), nil
+}
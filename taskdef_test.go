@@ -0,0 +1,182 @@
+package mirageecs_test
+
+import (
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestReplaceImageTag(t *testing.T) {
+	tests := []struct {
+		image string
+		tag   string
+		want  string
+	}{
+		{"nginx", "1.25", "nginx:1.25"},
+		{"nginx:latest", "1.25", "nginx:1.25"},
+		{"123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/app:latest", "sha-abc", "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/app:sha-abc"},
+		{"localhost:5000/app", "v1", "localhost:5000/app:v1"},
+		{"localhost:5000/app:v0", "v1", "localhost:5000/app:v1"},
+		{"public.ecr.aws/app@sha256:0123456789abcdef", "v1", "public.ecr.aws/app:v1"},
+	}
+	for _, tt := range tests {
+		if got := mirageecs.ReplaceImageTag(tt.image, tt.tag); got != tt.want {
+			t.Errorf("ReplaceImageTag(%q, %q) = %q, want %q", tt.image, tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestLaunchRequestOverrides(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     mirageecs.APILaunchRequest
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			req: mirageecs.APILaunchRequest{
+				ImageTag: "v1",
+				Cpu:      "1024",
+				Memory:   "2048",
+				Env:      map[string]string{"FOO": "bar"},
+			},
+		},
+		{
+			name:    "invalid env name",
+			req:     mirageecs.APILaunchRequest{Env: map[string]string{"FOO-BAR": "baz"}},
+			wantErr: true,
+		},
+		{
+			name:    "reserved env name",
+			req:     mirageecs.APILaunchRequest{Env: map[string]string{"SUBDOMAIN": "baz"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid cpu",
+			req:     mirageecs.APILaunchRequest{Cpu: "1vCPU"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt, err := tt.req.LaunchOption()
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if opt.ImageTag != tt.req.ImageTag || opt.Cpu != tt.req.Cpu || opt.Memory != tt.req.Memory {
+				t.Errorf("unexpected option: %#v", opt)
+			}
+		})
+	}
+}
+
+func TestRenderTaskDefinition(t *testing.T) {
+	t.Setenv("MIRAGE_TEST_ROLE", "arn:aws:iam::123456789012:role/ecsTaskExecutionRole")
+	tmpl := `{
+  "family": "app-{{ .Subdomain }}",
+  "executionRoleArn": "{{ must_env "MIRAGE_TEST_ROLE" }}",
+  "containerDefinitions": [
+    {
+      "name": "app",
+      "image": "app:{{ or .ImageTag .Parameter.branch }}",
+      "environment": [{"name": "BRANCH", "value": {{ json .Parameter.branch }}}]
+    }
+  ]
+}`
+	in, err := mirageecs.RenderTaskDefinition(tmpl, mirageecs.TaskDefinitionTemplateData{
+		Subdomain: "feature",
+		Parameter: mirageecs.TaskParameter{"branch": "feature/x"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := aws.ToString(in.Family); got != "app-feature" {
+		t.Errorf("unexpected family: %s", got)
+	}
+	if got := aws.ToString(in.ExecutionRoleArn); got != "arn:aws:iam::123456789012:role/ecsTaskExecutionRole" {
+		t.Errorf("unexpected execution role: %s", got)
+	}
+	c := in.ContainerDefinitions[0]
+	if got := aws.ToString(c.Image); got != "app:feature/x" {
+		t.Errorf("unexpected image: %s", got)
+	}
+	if got := aws.ToString(c.Environment[0].Value); got != "feature/x" {
+		t.Errorf("unexpected environment: %s", got)
+	}
+
+	if _, err := mirageecs.RenderTaskDefinition(`{"containerDefinitions":[]}`, mirageecs.TaskDefinitionTemplateData{}); err == nil {
+		t.Error("expected error for template without family")
+	}
+}
+
+func TestRegisteredFor(t *testing.T) {
+	tags := []types.Tag{
+		{Key: aws.String("env"), Value: aws.String("dev")},
+		{Key: aws.String(mirageecs.TagRegisteredFor), Value: aws.String(mirageecs.EncodeTagValue("feature-*"))},
+	}
+	if !mirageecs.RegisteredFor(tags, "feature-*") {
+		t.Error("the revision must be registered for feature-*")
+	}
+	if mirageecs.RegisteredFor(tags, "bench") {
+		t.Error("the revision must not be registered for bench")
+	}
+	if mirageecs.RegisteredFor(tags[:1], "feature-*") {
+		t.Error("the revision without the tag must not be registered by mirage-ecs")
+	}
+}
+
+func TestTerminateThenRelaunch(t *testing.T) {
+	fromTemplate := []types.Tag{{Key: aws.String(mirageecs.TagBaseTaskDefinition), Value: aws.String("")}}
+	withImageTag := []types.Tag{{Key: aws.String(mirageecs.TagBaseTaskDefinition), Value: aws.String("app:3")}}
+	tests := []struct {
+		name         string
+		infos        []*mirageecs.Information
+		relaunch     []string
+		deregistered []string
+	}{
+		{
+			name:         "template",
+			infos:        []*mirageecs.Information{{TaskDef: "app-pr-1:2", Tags: fromTemplate}},
+			relaunch:     []string{""},
+			deregistered: []string{"app-pr-1:2"},
+		},
+		{
+			name:         "image tag",
+			infos:        []*mirageecs.Information{{TaskDef: "app:7", Tags: withImageTag}, {TaskDef: "worker:5"}},
+			relaunch:     []string{"app:3", "worker:5"},
+			deregistered: []string{"app:7"},
+		},
+		{
+			name:     "registered revision specified at launch",
+			infos:    []*mirageecs.Information{{TaskDef: "app:7"}},
+			relaunch: []string{"app:7"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taskdefs := mirageecs.BaseTaskDefs(tt.infos)
+			if diff := cmp.Diff(tt.relaunch, taskdefs); diff != "" {
+				t.Errorf("unexpected taskdefs to relaunch (-want +got):\n%s", diff)
+			}
+			// the termination before the launch must not deregister revisions to be launched
+			deregistered := mirageecs.DeregistrableTaskDefs(tt.infos, taskdefs)
+			if diff := cmp.Diff(tt.deregistered, deregistered, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected deregistered revisions (-want +got):\n%s", diff)
+			}
+		})
+	}
+	arn := "arn:aws:ecs:ap-northeast-1:123456789012:task-definition/app:7"
+	if got := mirageecs.DeregistrableTaskDefs([]*mirageecs.Information{{TaskDef: "app:7"}}, []string{arn}); len(got) != 0 {
+		t.Errorf("the revision to be launched by ARN must be kept: %v", got)
+	}
+}
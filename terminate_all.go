@@ -0,0 +1,200 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+)
+
+// terminateAllConfirmationTTL is a lifetime of confirmation tokens of /api/terminate_all.
+const terminateAllConfirmationTTL = 5 * time.Minute
+
+// APITerminateAllRequest is a request of /api/terminate_all
+type APITerminateAllRequest struct {
+	// Excludes are subdomains (or patterns) not to terminate.
+	Excludes []string `json:"excludes" form:"excludes"`
+	// IncludeProtected terminates protected environments, too.
+	IncludeProtected bool `json:"include_protected" form:"include_protected"`
+	// Token is the confirmation token returned by the first request.
+	Token string `json:"token" form:"token"`
+}
+
+// APITerminateAllResponse is a response of /api/terminate_all
+type APITerminateAllResponse struct {
+	Result     string     `json:"result"`
+	Subdomains []string   `json:"subdomains"`
+	Token      string     `json:"token,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// terminateAllConfirmation is subdomains to terminate which are waiting for the confirmation.
+type terminateAllConfirmation struct {
+	subdomains []string
+	expiresAt  time.Time
+}
+
+// terminateAllConfirmations keeps confirmation tokens of /api/terminate_all.
+// Tokens are kept in redis if configured, so the confirmation can be requested to any replica.
+type terminateAllConfirmations struct {
+	mu     sync.Mutex
+	tokens map[string]*terminateAllConfirmation
+	redis  *redisClient
+}
+
+func newTerminateAllConfirmations(redis *redisClient) *terminateAllConfirmations {
+	return &terminateAllConfirmations{tokens: make(map[string]*terminateAllConfirmation), redis: redis}
+}
+
+func (c *terminateAllConfirmations) issue(ctx context.Context, subdomains []string, now time.Time) (string, time.Time, error) {
+	token := generateRandomHexID(32)
+	expiresAt := now.Add(terminateAllConfirmationTTL).Truncate(time.Second)
+	if c.redis != nil {
+		if err := c.redis.putConfirmation(ctx, token, subdomains, expiresAt.Sub(now)); err != nil {
+			return "", time.Time{}, err
+		}
+		return token, expiresAt, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for token, conf := range c.tokens {
+		if now.After(conf.expiresAt) {
+			delete(c.tokens, token)
+		}
+	}
+	c.tokens[token] = &terminateAllConfirmation{subdomains: subdomains, expiresAt: expiresAt}
+	return token, expiresAt, nil
+}
+
+// consume returns subdomains of the token. Tokens can be used only once.
+func (c *terminateAllConfirmations) consume(ctx context.Context, token string, now time.Time) ([]string, bool, error) {
+	if c.redis != nil {
+		return c.redis.consumeConfirmation(ctx, token)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conf, ok := c.tokens[token]
+	if !ok {
+		return nil, false, nil
+	}
+	delete(c.tokens, token)
+	if now.After(conf.expiresAt) {
+		return nil, false, nil
+	}
+	return conf.subdomains, true, nil
+}
+
+// terminateAllTargets returns subdomains of running and sleeping environments to terminate.
+func terminateAllTargets(infos []*Information, excludes *subdomainMatcher, includeProtected bool) []string {
+	targets := make(map[string]bool)
+	for _, info := range infos {
+		if excludes.match(info.SubDomain) || info.Protected && !includeProtected {
+			targets[info.SubDomain] = false
+			continue
+		}
+		if _, ok := targets[info.SubDomain]; !ok {
+			targets[info.SubDomain] = true
+		}
+	}
+	subdomains := lo.Keys(lo.PickBy(targets, func(_ string, ok bool) bool { return ok }))
+	sort.Strings(subdomains)
+	return subdomains
+}
+
+// ApiTerminateAll terminates all environments by two requests.
+// The first request returns subdomains to terminate and the confirmation token, and the second request with the token terminates them.
+func (api *WebApi) ApiTerminateAll(c echo.Context) error {
+	r := APITerminateAllRequest{}
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, APICommonResponse{Result: err.Error()})
+	}
+	now := time.Now()
+	if r.Token != "" {
+		subdomains, ok, err := api.terminateAllConfirmations.consume(c.Request().Context(), r.Token, now)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, APICommonResponse{Result: err.Error()})
+		}
+		if !ok {
+			return c.JSON(http.StatusBadRequest, APICommonResponse{Result: "invalid or expired token"})
+		}
+		slog.Info(f("terminate all %d subdomains (requested from %s): %v", len(subdomains), c.RealIP(), subdomains))
+		// running in background. Don't cancel by client context.
+		go api.terminateSubdomains(context.Background(), subdomains)
+		return c.JSON(http.StatusOK, APITerminateAllResponse{Result: "accepted", Subdomains: subdomains})
+	}
+
+	excludes, err := newSubdomainMatcher(r.Excludes)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APICommonResponse{Result: fmt.Sprintf("invalid excludes: %s", err)})
+	}
+	ctx := c.Request().Context()
+	infos, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, APICommonResponse{Result: err.Error()})
+	}
+	sleeping, err := api.runner.ListSleeping(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, APICommonResponse{Result: err.Error()})
+	}
+	subdomains := terminateAllTargets(append(infos, sleeping...), excludes, r.IncludeProtected)
+	token, expiresAt, err := api.terminateAllConfirmations.issue(ctx, subdomains, now)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(http.StatusOK, APITerminateAllResponse{
+		Result:     "confirmation required",
+		Subdomains: subdomains,
+		Token:      token,
+		ExpiresAt:  &expiresAt,
+	})
+}
+
+func (api *WebApi) terminateSubdomains(ctx context.Context, subdomains []string) {
+	terminated := 0
+	for _, subdomain := range subdomains {
+		if err := api.runner.TerminateBySubdomain(ctx, subdomain); err != nil {
+			slog.Warn(f("terminate failed %s %s", subdomain, err))
+			continue
+		}
+		terminated++
+		slog.Info(f("terminated %s", subdomain))
+	}
+	slog.Info(f("terminate %d subdomains completed", terminated))
+}
+
+// TerminateSelected terminates environments selected in the web interface in background.
+// Protected environments are not terminated, and subdomains which are not running or sleeping are ignored.
+func (api *WebApi) TerminateSelected(c echo.Context) error {
+	params, err := c.FormParams()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APICommonResponse{Result: err.Error()})
+	}
+	selected := lo.Uniq(params["subdomain"])
+	if len(selected) == 0 {
+		return c.JSON(http.StatusBadRequest, APICommonResponse{Result: "parameter required: subdomain"})
+	}
+	ctx := c.Request().Context()
+	infos, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, APICommonResponse{Result: err.Error()})
+	}
+	sleeping, err := api.runner.ListSleeping(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, APICommonResponse{Result: err.Error()})
+	}
+	infos = lo.Filter(append(infos, sleeping...), func(info *Information, _ int) bool {
+		return lo.Contains(selected, info.SubDomain)
+	})
+	excludes, _ := newSubdomainMatcher(nil)
+	subdomains := terminateAllTargets(infos, excludes, false)
+	slog.Info(f("terminate %d selected subdomains (requested from %s): %v", len(subdomains), c.RealIP(), subdomains))
+	// running in background. Don't cancel by client context.
+	go api.terminateSubdomains(context.Background(), subdomains)
+	return c.JSON(http.StatusOK, APITerminateAllResponse{Result: "accepted", Subdomains: subdomains})
+}
@@ -0,0 +1,192 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTerminateAll(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Auth = &mirageecs.Auth{
+		Token: &mirageecs.AuthMethodToken{Header: "x-mirage-token", Token: "user"},
+		Admin: &mirageecs.AuthMethodToken{Header: "x-mirage-admin-token", Token: "admin"},
+	}
+	m := mirageecs.New(ctx, cfg)
+	for _, subdomain := range []string{"main", "pr-1", "pr-2", "demo-1", "protected"} {
+		opt := &mirageecs.LaunchOption{Protected: subdomain == "protected"}
+		if err := m.Runner().Launch(ctx, subdomain, mirageecs.TaskParameter{"branch": "develop"}, opt, "app:1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	post := func(body string, adminToken string) (int, *mirageecs.APITerminateAllResponse) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/terminate_all", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("x-mirage-token", "user")
+		if adminToken != "" {
+			req.Header.Set("x-mirage-admin-token", adminToken)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var r mirageecs.APITerminateAllResponse
+		json.NewDecoder(res.Body).Decode(&r)
+		return res.StatusCode, &r
+	}
+
+	if code, _ := post(`{}`, ""); code != http.StatusForbidden {
+		t.Errorf("without the admin token: wanted 403, got %d", code)
+	}
+	if code, _ := post(`{}`, "wrong"); code != http.StatusForbidden {
+		t.Errorf("with a wrong admin token: wanted 403, got %d", code)
+	}
+
+	code, r := post(`{"excludes":["main","demo-*"]}`, "admin")
+	if code != http.StatusOK || r.Token == "" {
+		t.Fatalf("unexpected confirmation: %d %#v", code, r)
+	}
+	if diff := cmp.Diff([]string{"pr-1", "pr-2"}, r.Subdomains); diff != "" {
+		t.Errorf("unexpected subdomains (-want +got):\n%s", diff)
+	}
+	// nothing is terminated before the confirmation
+	infos, _ := m.Runner().List(ctx, "RUNNING")
+	if len(infos) != 5 {
+		t.Errorf("unexpected running tasks: %d", len(infos))
+	}
+
+	if code, _ := post(`{"token":"unknown"}`, "admin"); code != http.StatusBadRequest {
+		t.Errorf("with an unknown token: wanted 400, got %d", code)
+	}
+	code, r2 := post(`{"token":"`+r.Token+`"}`, "admin")
+	if code != http.StatusOK || r2.Result != "accepted" {
+		t.Fatalf("unexpected response: %d %#v", code, r2)
+	}
+	// tokens can be used only once
+	if code, _ := post(`{"token":"`+r.Token+`"}`, "admin"); code != http.StatusBadRequest {
+		t.Errorf("with a used token: wanted 400, got %d", code)
+	}
+
+	var running []string
+	for i := 0; i < 20; i++ {
+		time.Sleep(100 * time.Millisecond)
+		infos, _ := m.Runner().List(ctx, "RUNNING")
+		running = nil
+		for _, info := range infos {
+			running = append(running, info.SubDomain)
+		}
+		if len(running) == 3 {
+			break
+		}
+	}
+	sort.Strings(running)
+	if diff := cmp.Diff([]string{"demo-1", "main", "protected"}, running); diff != "" {
+		t.Errorf("unexpected running subdomains (-want +got):\n%s", diff)
+	}
+}
+
+func TestTerminateAllDisabled(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/terminate_all", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("without auth.admin: wanted 403, got %d", res.StatusCode)
+	}
+}
+
+func TestTerminateSelected(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	for _, subdomain := range []string{"main", "pr-1", "pr-2", "protected"} {
+		opt := &mirageecs.LaunchOption{Protected: subdomain == "protected"}
+		if err := m.Runner().Launch(ctx, subdomain, mirageecs.TaskParameter{"branch": "develop"}, opt, "app:1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts := httptest.NewServer(m.WebApi)
+	defer ts.Close()
+
+	post := func(form url.Values) (int, *mirageecs.APITerminateAllResponse) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/terminate_selected", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "http://"+cfg.Host.WebApi)
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var r mirageecs.APITerminateAllResponse
+		json.NewDecoder(res.Body).Decode(&r)
+		return res.StatusCode, &r
+	}
+
+	if code, _ := post(url.Values{}); code != http.StatusBadRequest {
+		t.Errorf("without subdomains: wanted 400, got %d", code)
+	}
+	code, r := post(url.Values{"subdomain": {"pr-1", "pr-2", "pr-2", "protected", "unknown"}})
+	if code != http.StatusOK || r.Result != "accepted" {
+		t.Fatalf("unexpected response: %d %#v", code, r)
+	}
+	// protected and unknown subdomains are not terminated
+	if diff := cmp.Diff([]string{"pr-1", "pr-2"}, r.Subdomains); diff != "" {
+		t.Errorf("unexpected subdomains (-want +got):\n%s", diff)
+	}
+
+	var running []string
+	for i := 0; i < 20; i++ {
+		time.Sleep(100 * time.Millisecond)
+		infos, _ := m.Runner().List(ctx, "RUNNING")
+		running = nil
+		for _, info := range infos {
+			running = append(running, info.SubDomain)
+		}
+		if len(running) == 2 {
+			break
+		}
+	}
+	sort.Strings(running)
+	if diff := cmp.Diff([]string{"main", "protected"}, running); diff != "" {
+		t.Errorf("unexpected running subdomains (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,318 @@
+package mirageecs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// TagTraceParent is a tag of tasks which keeps the trace context of the launch, to trace the task until it becomes ready.
+	TagTraceParent = "MirageTraceParent"
+
+	DefaultTracingServiceName = "mirage-ecs"
+
+	tracerName           = "github.com/acidlemon/mirage-ecs"
+	tracingShutdownLimit = 10 * time.Second
+)
+
+// otelTracer is a no-op until tracing is configured.
+var otelTracer = otel.Tracer(tracerName)
+
+// Tracing exports OpenTelemetry traces of requests, launches and AWS API calls by OTLP/HTTP.
+type Tracing struct {
+	// Endpoint is an URL of the OTLP/HTTP endpoint. e.g. http://localhost:4318
+	// Default is OTEL_EXPORTER_OTLP_ENDPOINT (or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) or http://localhost:4318.
+	Endpoint string `yaml:"endpoint"`
+	// Headers are HTTP headers of requests to the endpoint. e.g. API keys of tracing services
+	Headers map[string]string `yaml:"headers"`
+	// ServiceName is a name of the service of spans. Default is mirage-ecs.
+	ServiceName string `yaml:"service_name"`
+	// SampleRatio is a ratio of traces to sample, unless incoming requests are sampled already. Default is 1.0.
+	SampleRatio *float64 `yaml:"sample_ratio"`
+}
+
+func (t *Tracing) validate() error {
+	if t == nil {
+		return nil
+	}
+	if t.ServiceName == "" {
+		t.ServiceName = DefaultTracingServiceName
+	}
+	if t.SampleRatio == nil {
+		t.SampleRatio = aws.Float64(1.0)
+	}
+	if r := *t.SampleRatio; r < 0 || r > 1 {
+		return fmt.Errorf("tracing.sample_ratio must be between 0 and 1: %f", r)
+	}
+	return nil
+}
+
+// setupTracing sets the global tracer provider which exports spans to the endpoint, and traces AWS API calls.
+func (c *Config) setupTracing(ctx context.Context) error {
+	t := c.Tracing
+	if t == nil {
+		return nil
+	}
+	opts := []otlptracehttp.Option{}
+	if t.Endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpointURL(t.Endpoint))
+	}
+	if len(t.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(t.Headers))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", t.ServiceName),
+		attribute.String("service.version", Version),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to create resource of traces: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*t.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otelTracer = tp.Tracer(tracerName)
+	c.awscfg.APIOptions = append(c.awscfg.APIOptions, traceAWSCalls)
+	c.cleanups = append(c.cleanups, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownLimit)
+		defer cancel()
+		return tp.Shutdown(ctx)
+	})
+	return nil
+}
+
+// traceAWSCalls adds a middleware which starts a span for each AWS API call.
+func traceAWSCalls(stack *middleware.Stack) error {
+	// after the middleware which sets the service ID and the operation name
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("MirageTracing", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		service, operation := awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx)
+		ctx, span := otelTracer.Start(ctx, service+"."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("rpc.system", "aws-api"),
+				attribute.String("rpc.service", service),
+				attribute.String("rpc.method", operation),
+				attribute.String("cloud.region", awsmiddleware.GetRegion(ctx)),
+			),
+		)
+		defer span.End()
+		out, md, err := next.HandleInitialize(ctx, in)
+		endSpan(span, err)
+		return out, md, err
+	}), middleware.After)
+}
+
+// endSpan records the error of the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// startRequestSpan starts a server span of the request, continued from the trace context of the incoming headers.
+// The trace context of the span is set to the headers to be propagated to upstream tasks.
+func startRequestSpan(w http.ResponseWriter, req *http.Request) (*tracingResponseWriter, *http.Request, trace.Span) {
+	prop := otel.GetTextMapPropagator()
+	ctx := prop.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	ctx, span := otelTracer.Start(ctx, req.Method+" "+hostOnly(req.Host),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", hostOnly(req.Host)),
+			attribute.String("url.path", req.URL.Path),
+			attribute.String("user_agent.original", req.UserAgent()),
+		),
+	)
+	prop.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return &tracingResponseWriter{ResponseWriter: w, status: http.StatusOK}, req.WithContext(ctx), span
+}
+
+// endRequestSpan records the status of the response and ends the span.
+func endRequestSpan(w *tracingResponseWriter, span trace.Span) {
+	span.SetAttributes(attribute.Int("http.response.status_code", w.status))
+	if w.status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(w.status))
+	}
+	span.End()
+}
+
+// tracingResponseWriter records the status of the response. It keeps flushing and hijacking of the underlying writer for streaming and upgrades.
+type tracingResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *tracingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *tracingResponseWriter) Flush() {
+	if fl, ok := w.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+func (w *tracingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not implement http.Hijacker", w.ResponseWriter)
+	}
+	w.status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
+
+func (w *tracingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// traceParentTags returns the tag of the trace context of the launch.
+func traceParentTags(ctx context.Context) []types.Tag {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	tp := carrier.Get("traceparent")
+	if tp == "" {
+		return nil
+	}
+	return []types.Tag{{Key: aws.String(TagTraceParent), Value: aws.String(tp)}}
+}
+
+// tracingRunner is a TaskRunner which starts spans of launches, terminations and logs.
+type tracingRunner struct {
+	TaskRunner
+}
+
+func (r *tracingRunner) Launch(ctx context.Context, subdomain string, param TaskParameter, opt *LaunchOption, taskdefs ...string) error {
+	ctx, span := otelTracer.Start(ctx, "Launch", trace.WithAttributes(
+		attribute.String("mirage.subdomain", subdomain),
+		attribute.StringSlice("mirage.taskdefs", taskdefs),
+	))
+	defer span.End()
+	err := r.TaskRunner.Launch(ctx, subdomain, param, opt, taskdefs...)
+	endSpan(span, err)
+	return err
+}
+
+func (r *tracingRunner) TerminateBySubdomain(ctx context.Context, subdomain string) error {
+	ctx, span := otelTracer.Start(ctx, "TerminateBySubdomain", trace.WithAttributes(
+		attribute.String("mirage.subdomain", subdomain),
+	))
+	defer span.End()
+	err := r.TaskRunner.TerminateBySubdomain(ctx, subdomain)
+	endSpan(span, err)
+	return err
+}
+
+func (r *tracingRunner) Logs(ctx context.Context, subdomain string, since time.Time, tail int, filter *LogsFilter) ([]string, error) {
+	ctx, span := otelTracer.Start(ctx, "Logs", trace.WithAttributes(
+		attribute.String("mirage.subdomain", subdomain),
+	))
+	defer span.End()
+	logs, err := r.TaskRunner.Logs(ctx, subdomain, since, tail, filter)
+	endSpan(span, err)
+	return logs, err
+}
+
+func (r *tracingRunner) DownloadLogs(ctx context.Context, subdomain string, since time.Time, fn func(*LogLine) error) error {
+	ctx, span := otelTracer.Start(ctx, "DownloadLogs", trace.WithAttributes(
+		attribute.String("mirage.subdomain", subdomain),
+	))
+	defer span.End()
+	err := r.TaskRunner.DownloadLogs(ctx, subdomain, since, fn)
+	endSpan(span, err)
+	return err
+}
+
+func (r *tracingRunner) QueryLogs(ctx context.Context, subdomain string, query string, since, until time.Time, limit int32) ([]map[string]string, error) {
+	ctx, span := otelTracer.Start(ctx, "QueryLogs", trace.WithAttributes(
+		attribute.String("mirage.subdomain", subdomain),
+	))
+	defer span.End()
+	results, err := r.TaskRunner.QueryLogs(ctx, subdomain, query, since, until, limit)
+	endSpan(span, err)
+	return results, err
+}
+
+// traceTaskLifecycle records spans of the task from its creation until it becomes ready, in the trace of the launch.
+// ECS does not report when the health check passed, so the end of the health check is the time when mirage-ecs found the task ready.
+func traceTaskLifecycle(info *Information, readyAt time.Time) {
+	task := info.task
+	if task == nil || task.CreatedAt == nil {
+		return
+	}
+	tp := getTag(info.Tags, TagTraceParent)
+	if tp == "" {
+		return
+	}
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier{"traceparent": tp})
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+	ctx, span := otelTracer.Start(ctx, "Task "+info.ShortID,
+		trace.WithTimestamp(*task.CreatedAt),
+		trace.WithAttributes(
+			attribute.String("mirage.subdomain", info.SubDomain),
+			attribute.String("mirage.taskdef", info.TaskDef),
+			attribute.String("aws.ecs.task.arn", info.ID),
+		),
+	)
+	phases := []struct {
+		name       string
+		start, end *time.Time
+	}{
+		{"Provisioning", task.CreatedAt, task.PullStartedAt},
+		{"Pulling images", task.PullStartedAt, task.PullStoppedAt},
+		{"Starting containers", task.PullStoppedAt, task.StartedAt},
+		{"Health check", task.StartedAt, &readyAt},
+	}
+	for _, p := range phases {
+		if p.start == nil || p.end == nil || p.end.Before(*p.start) {
+			continue
+		}
+		_, s := otelTracer.Start(ctx, p.name, trace.WithTimestamp(*p.start))
+		s.End(trace.WithTimestamp(*p.end))
+	}
+	span.End(trace.WithTimestamp(readyAt))
+}
+
+// traceReadyTasks traces lifecycles of tasks which became ready since the last sync.
+// Tasks which were ready before mirage-ecs started are not traced, because the time when they became ready is unknown.
+func (app *Mirage) traceReadyTasks(running []*Information) {
+	if app.Config.Tracing == nil {
+		return
+	}
+	now := time.Now()
+	ready := make(map[string]bool, len(running))
+	for _, info := range running {
+		if wasReady, ok := app.readyTasks[info.ID]; ok && !wasReady && info.Ready {
+			traceTaskLifecycle(info, now)
+		}
+		ready[info.ID] = info.Ready
+	}
+	app.readyTasks = ready
+}
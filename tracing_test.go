@@ -0,0 +1,131 @@
+package mirageecs_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func newSpanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	sr := tracetest.NewSpanRecorder()
+	restore := mirageecs.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	t.Cleanup(restore)
+	return sr
+}
+
+func TestTraceRequest(t *testing.T) {
+	sr := newSpanRecorder(t)
+	const incoming = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+	var upstream string
+	h := mirageecs.TraceRequest(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		upstream = req.Header.Get("traceparent")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "http://foo.example.net/bar", nil)
+	req.Header.Set("traceparent", incoming)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := sr.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("unexpected spans: %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "GET foo.example.net" || span.SpanKind() != trace.SpanKindServer {
+		t.Errorf("unexpected span: %s %s", span.Name(), span.SpanKind())
+	}
+	if got := span.Parent().TraceID().String(); got != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("span is not continued from the incoming trace: %s", got)
+	}
+	// the upstream task receives the trace context of the span of mirage-ecs
+	expected := "00-0af7651916cd43dd8448eb211c80319c-" + span.SpanContext().SpanID().String() + "-01"
+	if upstream != expected {
+		t.Errorf("unexpected traceparent to upstream: %s, want %s", upstream, expected)
+	}
+	if !hasAttribute(span.Attributes(), attribute.Int("http.response.status_code", http.StatusBadGateway)) {
+		t.Errorf("status is not recorded: %v", span.Attributes())
+	}
+}
+
+func TestTraceTaskLifecycle(t *testing.T) {
+	sr := newSpanRecorder(t)
+	if tags := mirageecs.TraceParentTags(context.Background()); len(tags) != 0 {
+		t.Errorf("tags without spans: %v", tags)
+	}
+
+	ctx, launch := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "launch")
+	tags := mirageecs.TraceParentTags(ctx)
+	launch.End()
+	if len(tags) != 1 || aws.ToString(tags[0].Key) != mirageecs.TagTraceParent {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+
+	created := time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)
+	info := &mirageecs.Information{
+		ID:        "arn:aws:ecs:ap-northeast-1:123456789012:task/mirage/0123456789abcdef",
+		ShortID:   "0123456789abcdef",
+		SubDomain: "foo",
+		Tags:      tags,
+	}
+	info.SetTask(&types.Task{
+		CreatedAt:     aws.Time(created),
+		PullStartedAt: aws.Time(created.Add(30 * time.Second)),
+		PullStoppedAt: aws.Time(created.Add(70 * time.Second)),
+		StartedAt:     aws.Time(created.Add(75 * time.Second)),
+	})
+	mirageecs.TraceTaskLifecycle(info, created.Add(90*time.Second))
+
+	durations := make(map[string]time.Duration)
+	for _, s := range sr.Ended() {
+		if s.SpanContext().TraceID() != launch.SpanContext().TraceID() {
+			t.Errorf("span %s is not in the trace of the launch", s.Name())
+		}
+		durations[s.Name()] = s.EndTime().Sub(s.StartTime())
+	}
+	expected := map[string]time.Duration{
+		"Task 0123456789abcdef": 90 * time.Second,
+		"Provisioning":          30 * time.Second,
+		"Pulling images":        40 * time.Second,
+		"Starting containers":   5 * time.Second,
+		"Health check":          15 * time.Second,
+	}
+	if diff := cmp.Diff(expected, durations); diff != "" {
+		t.Errorf("unexpected spans (-want +got):\n%s", diff)
+	}
+}
+
+func hasAttribute(attrs []attribute.KeyValue, kv attribute.KeyValue) bool {
+	for _, a := range attrs {
+		if a == kv {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTracingConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("tracing:\n  sample_ratio: 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := mirageecs.NewConfig(context.Background(), &mirageecs.ConfigParams{Path: path, LocalMode: true})
+	if err == nil || !strings.Contains(err.Error(), "sample_ratio") {
+		t.Errorf("expected error of sample_ratio: %v", err)
+	}
+}
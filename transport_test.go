@@ -124,3 +124,43 @@ func TestRoundTrip(t *testing.T) {
 		})
 	}
 }
+
+func TestRoundTripHealthCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	counter := mirageecs.NewAccessCounter(time.Minute)
+	tr := &mirageecs.Transport{
+		Counter:          counter,
+		Transport:        mirageecs.NewHTTPTransport(time.Second),
+		Subdomain:        "test-subdomain",
+		HealthCheckPaths: []string{"/healthz", "/health/*"},
+	}
+	for _, p := range []string{"/healthz", "/health/db", "/", "/missing"} {
+		req, _ := http.NewRequest("GET", server.URL+p, nil)
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	var sum int64
+	for _, v := range counter.Collect() {
+		sum += v
+	}
+	if sum != 2 {
+		t.Errorf("health checks must not be counted as access: %d", sum)
+	}
+	stats := counter.Stats(time.Now().Add(-time.Minute))
+	if len(stats) != 1 {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+	if s := stats[0]; s.Count != 4 || s.HealthChecks != 2 || s.Status["2xx"] != 3 || s.Status["4xx"] != 1 {
+		t.Errorf("unexpected stats: %#v", s)
+	}
+}
@@ -0,0 +1,126 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+)
+
+const (
+	TagExpiresAt = "MirageExpiresAt"
+
+	// DefaultTTLExtension is the duration to extend the expiry by when it is not specified.
+	DefaultTTLExtension = 24 * time.Hour
+)
+
+// parseTTL parses a TTL of environments. e.g. "24h"
+func parseTTL(name, v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s", name, v)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be positive: %s", name, v)
+	}
+	return d, nil
+}
+
+func formatExpiresAt(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func parseExpiresAt(v string) (time.Time, error) {
+	return time.Parse(time.RFC3339, v)
+}
+
+// expiresAt returns the time when the environment expires. Environments without TTL never expire.
+func (o *LaunchOption) expiresAt() (time.Time, bool) {
+	if o == nil || o.ExpiresAt == nil {
+		return time.Time{}, false
+	}
+	return *o.ExpiresAt, true
+}
+
+// extendExpiresAt returns the expiry pushed out by d. An expired environment is extended from now.
+func extendExpiresAt(current, now time.Time, d time.Duration) time.Time {
+	if current.Before(now) {
+		current = now
+	}
+	return current.Add(d).Truncate(time.Second)
+}
+
+// SetExpiresAt updates the expiry of environments of the subdomain.
+// The expiry is stored in tags of tasks and services, so tasks replaced by services inherit it.
+func (e *ECS) SetExpiresAt(ctx context.Context, subdomain string, t time.Time) error {
+	arns, err := e.resourceArns(ctx, subdomain)
+	if err != nil {
+		return err
+	}
+	for _, arn := range arns {
+		_, err := e.svc.TagResource(ctx, &ecs.TagResourceInput{
+			ResourceArn: aws.String(arn),
+			Tags:        []types.Tag{{Key: aws.String(TagExpiresAt), Value: aws.String(formatExpiresAt(t))}},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to tag the expiry of %s: %w", arn, err)
+		}
+	}
+	return nil
+}
+
+// resourceArns returns ARNs of tasks of the subdomain, and services in service mode.
+func (e *ECS) resourceArns(ctx context.Context, subdomain string) ([]string, error) {
+	infos, err := e.find(ctx, subdomain)
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("subdomain %s is not found", subdomain)
+	}
+	arns := lo.Map(infos, func(info *Information, _ int) string { return info.ID })
+	if e.cfg.ECS.ServiceMode {
+		services, err := e.findServices(ctx, subdomain)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range services {
+			arns = append(arns, aws.ToString(s.ServiceArn))
+		}
+	}
+	return arns, nil
+}
+
+// terminateExpired terminates environments which have expired. Protected environments are kept.
+func (app *Mirage) terminateExpired(ctx context.Context, running []*Information) {
+	now := time.Now()
+	alive := make(map[string]bool, len(running))
+	for _, info := range running {
+		alive[info.SubDomain] = true
+		expiresAt, ok := info.Option.expiresAt()
+		if !ok || now.Before(expiresAt) || info.Protected {
+			continue
+		}
+		if _, ok := app.expired[info.SubDomain]; ok {
+			continue
+		}
+		app.expired[info.SubDomain] = struct{}{}
+		slog.Info(f("subdomain %s has expired at %s. terminating", info.SubDomain, formatExpiresAt(expiresAt)))
+		// terminate in background, because it sends a proxy control message to the sync loop
+		go func(subdomain string) {
+			if err := app.runner.TerminateBySubdomain(ctx, subdomain); err != nil {
+				slog.Warn(f("failed to terminate expired subdomain %s: %s", subdomain, err))
+			}
+		}(info.SubDomain)
+	}
+	for subdomain := range app.expired {
+		if !alive[subdomain] {
+			delete(app.expired, subdomain)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package mirageecs_test
+
+import (
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestExtendExpiresAt(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		current  time.Time
+		expected time.Time
+	}{
+		{"not expired", now.Add(time.Hour), now.Add(25 * time.Hour)},
+		{"expired", now.Add(-time.Hour), now.Add(24 * time.Hour)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := mirageecs.ExtendExpiresAt(c.current, now, 24*time.Hour); !got.Equal(c.expected) {
+				t.Errorf("ExtendExpiresAt() = %s, want %s", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestLaunchOptionTTL(t *testing.T) {
+	before := time.Now()
+	opt, err := (&mirageecs.APILaunchRequest{TTL: "72h"}).LaunchOption()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.ExpiresAt == nil {
+		t.Fatal("ExpiresAt must be set")
+	}
+	if d := opt.ExpiresAt.Sub(before); d < 72*time.Hour-time.Second || d > 72*time.Hour+time.Second {
+		t.Errorf("unexpected expiry: %s", opt.ExpiresAt)
+	}
+
+	opt, err = (&mirageecs.APILaunchRequest{}).LaunchOption()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.ExpiresAt != nil {
+		t.Errorf("ExpiresAt must not be set without ttl: %s", opt.ExpiresAt)
+	}
+
+	for _, ttl := range []string{"3d", "-1h", "0s"} {
+		if _, err := (&mirageecs.APILaunchRequest{TTL: ttl}).LaunchOption(); err == nil {
+			t.Errorf("expected error of ttl %s", ttl)
+		}
+	}
+}
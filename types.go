@@ -2,7 +2,10 @@ package mirageecs
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/url"
+	"strconv"
+	"time"
 )
 
 // APIListResponse is a response of /api/list
@@ -17,22 +20,116 @@ type APICommonResponse struct {
 	Result string `json:"result"`
 }
 
+// APILaunchWaitResponse is a response of /api/launch with wait=true
+type APILaunchWaitResponse struct {
+	Result string `json:"result"`
+	// URL is the URL of the environment.
+	URL string `json:"url"`
+	// Status is the state of the environment. HEALTHY when the environment is routable.
+	Status string         `json:"status"`
+	Tasks  []*APITaskInfo `json:"tasks"`
+}
+
 type APILogsResponse struct {
 	Result []string `json:"result"`
 }
 
+// APILogsQueryResponse is a response of /api/logs/query
+type APILogsQueryResponse struct {
+	Result []map[string]string `json:"result"`
+}
+
+// APIExecResponse is a response of /api/exec
+type APIExecResponse struct {
+	Result  string `json:"result"`
+	Command string `json:"command"`
+}
+
 // APIAccessResponse is a response of /api/access
 type APIAccessResponse struct {
 	Result   string `json:"result"`
 	Duration int64  `json:"duration"`
 	Sum      int64  `json:"sum"`
+	// Stats are statistics of responses by this process. Old statistics are kept up to 24 hours.
+	Stats []*AccessStats `json:"stats,omitempty"`
 }
 
 type APILaunchRequest struct {
-	Subdomain  string            `json:"subdomain" form:"subdomain"`
-	Branch     string            `json:"branch" form:"branch"`
-	Taskdef    []string          `json:"taskdef" form:"taskdef"`
-	Parameters map[string]string `json:"parameters" form:"parameters"`
+	Subdomain   string            `json:"subdomain" form:"subdomain"`
+	Branch      string            `json:"branch" form:"branch"`
+	Taskdef     []string          `json:"taskdef" form:"taskdef"`
+	Parameters  map[string]string `json:"parameters" form:"parameters"`
+	Compression string            `json:"compression" form:"compression"`
+	Rewrites    RewriteRules      `json:"rewrites" form:"-"`
+
+	CapacityProviderStrategy CapacityProviderStrategy `json:"capacity_provider_strategy" form:"-"`
+
+	ImageTag  string            `json:"image_tag" form:"image_tag"`
+	ImageTags map[string]string `json:"image_tags" form:"-"`
+	Cpu       string            `json:"cpu" form:"cpu"`
+	Memory    string            `json:"memory" form:"memory"`
+	Env       map[string]string `json:"env" form:"-"`
+
+	Command    map[string][]string `json:"command" form:"-"`
+	EntryPoint map[string][]string `json:"entry_point" form:"-"`
+
+	CpuArchitecture       string `json:"cpu_architecture" form:"cpu_architecture"`
+	OperatingSystemFamily string `json:"operating_system_family" form:"operating_system_family"`
+
+	GPU                   string            `json:"gpu" form:"gpu"`
+	GPUContainers         []string          `json:"gpu_containers" form:"-"`
+	InferenceAccelerators map[string]string `json:"inference_accelerators" form:"-"`
+
+	Protected string `json:"protected" form:"protected"`
+
+	EnableExecuteCommand string `json:"enable_execute_command" form:"enable_execute_command"`
+
+	PortRoutes PortRoutes `json:"port_routes" form:"-"`
+
+	Visibility string `json:"visibility" form:"visibility"`
+
+	TTL string `json:"ttl" form:"ttl"`
+
+	Schedule string `json:"schedule" form:"schedule"`
+
+	// Wait holds the response until the environment is routable, up to Timeout seconds.
+	Wait    string `json:"wait" form:"wait"`
+	Timeout string `json:"timeout" form:"timeout"`
+}
+
+// launchRequestKeys are form keys of APILaunchRequest which are not extra parameters.
+// JSON only keys (form:"-") are not included because they may conflict with extra parameters.
+// Parameters named after these keys are rejected by validateParameterName.
+var launchRequestKeys = map[string]struct{}{
+	"branch":      {},
+	"subdomain":   {},
+	"taskdef":     {},
+	"compression": {},
+	"image_tag":   {},
+	"cpu":         {},
+	"memory":      {},
+
+	"cpu_architecture":        {},
+	"operating_system_family": {},
+	"gpu":                     {},
+	"protected":               {},
+	"enable_execute_command":  {},
+	"visibility":              {},
+	"ttl":                     {},
+	"schedule":                {},
+	"wait":                    {},
+	"timeout":                 {},
+}
+
+// validateParameterName rejects parameters which would be shadowed by the keys of launch requests.
+func validateParameterName(name string) error {
+	if name == DefaultParameter.Name {
+		return nil
+	}
+	if _, ok := launchRequestKeys[name]; ok {
+		return fmt.Errorf("parameter name %s is reserved by launch requests", name)
+	}
+	return nil
 }
 
 func (r *APILaunchRequest) GetParameter(key string) string {
@@ -47,17 +144,220 @@ func (r *APILaunchRequest) MergeForm(form url.Values) {
 		r.Parameters = make(map[string]string, len(form))
 	}
 	for key, values := range form {
-		if key == "branch" || key == "subdomain" || key == "taskdef" {
+		if _, ok := launchRequestKeys[key]; ok {
 			continue
 		}
 		r.Parameters[key] = values[0]
 	}
 }
 
+// WaitTimeout returns the timeout to wait for the environment to be routable. It is 0 without wait.
+func (r *APILaunchRequest) WaitTimeout() (time.Duration, error) {
+	if r.Wait == "" {
+		return 0, nil
+	}
+	wait, err := strconv.ParseBool(r.Wait)
+	if err != nil {
+		return 0, fmt.Errorf("invalid wait: %s", r.Wait)
+	}
+	if !wait {
+		return 0, nil
+	}
+	if r.Timeout == "" {
+		return DefaultLaunchWaitTimeout, nil
+	}
+	sec, err := strconv.Atoi(r.Timeout)
+	if err != nil || sec <= 0 {
+		return 0, fmt.Errorf("invalid timeout: %s", r.Timeout)
+	}
+	if d := time.Duration(sec) * time.Second; d <= MaxLaunchWaitTimeout {
+		return d, nil
+	}
+	return 0, fmt.Errorf("timeout must be at most %d seconds", int(MaxLaunchWaitTimeout.Seconds()))
+}
+
+func (r *APILaunchRequest) LaunchOption() (*LaunchOption, error) {
+	opt := &LaunchOption{}
+	if r.Compression != "" {
+		b, err := strconv.ParseBool(r.Compression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid compression: %s", r.Compression)
+		}
+		opt.Compression = &b
+	}
+	if r.Protected != "" {
+		b, err := strconv.ParseBool(r.Protected)
+		if err != nil {
+			return nil, fmt.Errorf("invalid protected: %s", r.Protected)
+		}
+		opt.Protected = b
+	}
+	if r.EnableExecuteCommand != "" {
+		b, err := strconv.ParseBool(r.EnableExecuteCommand)
+		if err != nil {
+			return nil, fmt.Errorf("invalid enable_execute_command: %s", r.EnableExecuteCommand)
+		}
+		opt.EnableExecuteCommand = &b
+	}
+	if err := validateVisibility(r.Visibility); err != nil {
+		return nil, err
+	}
+	opt.Visibility = r.Visibility
+	opt.Schedule = r.Schedule
+	if r.TTL != "" {
+		d, err := parseTTL("ttl", r.TTL)
+		if err != nil {
+			return nil, err
+		}
+		t := time.Now().Add(d).Truncate(time.Second)
+		opt.ExpiresAt = &t
+	}
+	if len(r.PortRoutes) > 0 {
+		if err := r.PortRoutes.validate(); err != nil {
+			return nil, err
+		}
+		if len(r.PortRoutes.String()) > maxTagValueLength {
+			return nil, fmt.Errorf("port_routes are too long to store in a tag")
+		}
+		opt.PortRoutes = r.PortRoutes
+	}
+	if len(r.Rewrites) > 0 {
+		if err := r.Rewrites.compile(); err != nil {
+			return nil, err
+		}
+		if v, err := encodeRewriteRules(r.Rewrites); err != nil {
+			return nil, err
+		} else if len(v) > maxTagValueLength {
+			return nil, fmt.Errorf("rewrites are too long to store in a tag (max %d bytes in base64 encoded)", maxTagValueLength)
+		}
+		opt.Rewrites = r.Rewrites
+	}
+	if len(r.CapacityProviderStrategy) > 0 {
+		if err := r.CapacityProviderStrategy.validate(); err != nil {
+			return nil, err
+		}
+		if len(r.CapacityProviderStrategy.String()) > maxTagValueLength {
+			return nil, fmt.Errorf("capacity_provider_strategy is too long to store in a tag")
+		}
+		opt.CapacityProviderStrategy = r.CapacityProviderStrategy
+	}
+	opt.ImageTag = r.ImageTag
+	opt.ImageTags = r.ImageTags
+	opt.Cpu = r.Cpu
+	opt.Memory = r.Memory
+	opt.Environment = r.Env
+	opt.Command = r.Command
+	opt.EntryPoint = r.EntryPoint
+	if r.CpuArchitecture != "" || r.OperatingSystemFamily != "" {
+		opt.RuntimePlatform = &RuntimePlatform{
+			CpuArchitecture:       r.CpuArchitecture,
+			OperatingSystemFamily: r.OperatingSystemFamily,
+		}
+		if err := opt.RuntimePlatform.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if r.GPU != "" || len(r.GPUContainers) > 0 || len(r.InferenceAccelerators) > 0 {
+		opt.GPU = &GPU{
+			Containers:            r.GPUContainers,
+			InferenceAccelerators: r.InferenceAccelerators,
+		}
+		if r.GPU != "" {
+			n, err := strconv.ParseInt(r.GPU, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid gpu: %s", r.GPU)
+			}
+			opt.GPU.Count = int32(n)
+			opt.GPU.countSet = true
+		}
+		if err := opt.GPU.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if err := opt.validateOverrides(); err != nil {
+		return nil, err
+	}
+	return opt, nil
+}
+
 type APIPurgeRequest struct {
 	Duration    json.Number `json:"duration" form:"duration"`
 	Excludes    []string    `json:"excludes" form:"excludes"`
 	ExcludeTags []string    `json:"exclude_tags" form:"exclude_tags"`
+	Only        []string    `json:"only" form:"only"`
+	IncludeTags []string    `json:"include_tags" form:"include_tags"`
+	// DryRun returns candidates of the purge without terminating them.
+	DryRun bool `json:"dry_run" form:"dry_run"`
+}
+
+type APIPurgeResponse struct {
+	Result     string            `json:"result"`
+	Candidates []*PurgeCandidate `json:"candidates"`
+}
+
+type APIProtectRequest struct {
+	Subdomain string `json:"subdomain" form:"subdomain"`
+}
+
+type APIExtendRequest struct {
+	Subdomain string `json:"subdomain" form:"subdomain"`
+	Duration  string `json:"duration" form:"duration"`
+}
+
+type APIExtendResponse struct {
+	Result    string    `json:"result"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// APIQuotasResponse is a response of /api/quotas
+type APIQuotasResponse struct {
+	Result []*QuotaUsage `json:"result"`
+}
+
+// APICostResponse is a response of /api/cost
+type APICostResponse struct {
+	Result   []*EnvironmentCost `json:"result"`
+	Currency string             `json:"currency"`
+}
+
+// APIQueueResponse is a response of /api/queue
+type APIQueueResponse struct {
+	Result []LaunchJob `json:"result"`
+}
+
+// APIHealthResponse is a response of /api/health
+type APIHealthResponse struct {
+	Result string         `json:"result"`
+	Checks []*HealthCheck `json:"checks"`
+}
+
+// APIRuntimeResponse is a response of /api/debug/runtime
+type APIRuntimeResponse struct {
+	Result    string `json:"result"`
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	// Uptime is a time since mirage-ecs started in seconds.
+	Uptime     int64 `json:"uptime"`
+	Goroutines int   `json:"goroutines"`
+	// Routes is the number of subdomains in the route table at the last sync.
+	Routes int `json:"routes"`
+	// Metrics are values of Go runtime metrics by names. e.g. /memory/classes/heap/objects:bytes
+	Metrics map[string]float64 `json:"metrics"`
+}
+
+// APIHistoryResponse is a response of /api/history
+type APIHistoryResponse struct {
+	Result []*HistoryEvent `json:"result"`
+}
+
+type APIEnvResponse struct {
+	Result string            `json:"result"`
+	Env    map[string]string `json:"env"`
+}
+
+type APIAliasRequest struct {
+	Subdomain string `json:"subdomain" form:"subdomain"`
+	Hostname  string `json:"hostname" form:"hostname"`
 }
 
 type APITerminateRequest struct {
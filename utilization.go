@@ -0,0 +1,217 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cw "github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+const (
+	DefaultUtilizationCacheTTL = time.Minute
+
+	containerInsightsNamespace = "ECS/ContainerInsights"
+	// maxMetricDataQueries is the maximum number of queries of a GetMetricData call.
+	maxMetricDataQueries = 500
+	// utilizationWindow is a time range to find the latest datapoints.
+	utilizationWindow = 5 * time.Minute
+)
+
+// Utilization shows CPU and memory utilization of tasks by task level metrics of Container Insights.
+// Container Insights with enhanced observability must be enabled on the cluster.
+type Utilization struct {
+	// CacheTTL is a time to keep metrics of tasks. Default is 1m.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+}
+
+func (u *Utilization) validate() error {
+	if u == nil {
+		return nil
+	}
+	if u.CacheTTL == 0 {
+		u.CacheTTL = DefaultUtilizationCacheTTL
+	}
+	if u.CacheTTL < 0 {
+		return fmt.Errorf("ecs.utilization.cache_ttl must be positive: %s", u.CacheTTL)
+	}
+	return nil
+}
+
+// TaskUtilization is CPU and memory utilization of the task in percent of the reserved size.
+type TaskUtilization struct {
+	CPU    float64   `json:"cpu"`
+	Memory float64   `json:"memory"`
+	Time   time.Time `json:"time"`
+}
+
+// utilizationMetrics are names of metrics to calculate utilization. utilized / reserved * 100
+var utilizationMetrics = []string{"CpuUtilized", "CpuReserved", "MemoryUtilized", "MemoryReserved"}
+
+// utilizationQueries returns queries of metrics of the tasks. IDs of queries are "m{index of the task}_{index of the metric}".
+func utilizationQueries(cluster string, infos []*Information) []cwTypes.MetricDataQuery {
+	queries := make([]cwTypes.MetricDataQuery, 0, len(infos)*len(utilizationMetrics))
+	for i, info := range infos {
+		family, _, _ := strings.Cut(info.TaskDef, ":")
+		for j, name := range utilizationMetrics {
+			queries = append(queries, cwTypes.MetricDataQuery{
+				Id: aws.String(fmt.Sprintf("m%d_%d", i, j)),
+				MetricStat: &cwTypes.MetricStat{
+					Metric: &cwTypes.Metric{
+						Namespace:  aws.String(containerInsightsNamespace),
+						MetricName: aws.String(name),
+						Dimensions: []cwTypes.Dimension{
+							{Name: aws.String("ClusterName"), Value: aws.String(cluster)},
+							{Name: aws.String("TaskDefinitionFamily"), Value: aws.String(family)},
+							{Name: aws.String("TaskId"), Value: aws.String(info.ShortID)},
+						},
+					},
+					Period: aws.Int32(60),
+					Stat:   aws.String("Average"),
+				},
+			})
+		}
+	}
+	return queries
+}
+
+// utilizationsOf returns utilization of the tasks by results of utilizationQueries. Tasks without datapoints are omitted.
+func utilizationsOf(infos []*Information, results []cwTypes.MetricDataResult) map[string]*TaskUtilization {
+	type datapoint struct {
+		value float64
+		time  time.Time
+	}
+	latest := make(map[string]datapoint, len(results))
+	for _, r := range results {
+		id := aws.ToString(r.Id)
+		if _, ok := latest[id]; ok || len(r.Values) == 0 {
+			// older values are in the next pages
+			continue
+		}
+		// values are sorted by timestamps descending by default
+		latest[id] = datapoint{value: r.Values[0], time: r.Timestamps[0]}
+	}
+	percent := func(i, utilized, reserved int) (float64, time.Time, bool) {
+		u, ok1 := latest[fmt.Sprintf("m%d_%d", i, utilized)]
+		r, ok2 := latest[fmt.Sprintf("m%d_%d", i, reserved)]
+		if !ok1 || !ok2 || r.value == 0 {
+			return 0, time.Time{}, false
+		}
+		return math.Round(u.value/r.value*1000) / 10, u.time, true
+	}
+	utilizations := make(map[string]*TaskUtilization)
+	for i, info := range infos {
+		cpu, t1, ok1 := percent(i, 0, 1)
+		mem, t2, ok2 := percent(i, 2, 3)
+		if !ok1 && !ok2 {
+			continue
+		}
+		utilizations[info.ID] = &TaskUtilization{CPU: cpu, Memory: mem, Time: maxTime(t1, t2)}
+	}
+	return utilizations
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+type cachedUtilization struct {
+	utilization *TaskUtilization
+	expiresAt   time.Time
+}
+
+// utilizationCache caches utilization of tasks, not to call GetMetricData on every listing.
+type utilizationCache struct {
+	cfg     *Utilization
+	cluster string
+	svc     *cw.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedUtilization // task ARN -> utilization
+}
+
+func newUtilizationCache(cfg *Config) *utilizationCache {
+	if cfg.ECS.Utilization == nil || cfg.localMode {
+		return nil
+	}
+	return &utilizationCache{
+		cfg:     cfg.ECS.Utilization,
+		cluster: cfg.ECS.Cluster,
+		svc:     cw.NewFromConfig(*cfg.awscfg),
+		cache:   make(map[string]cachedUtilization),
+	}
+}
+
+// apply sets utilization of running tasks. Failures to get metrics are only logged.
+func (c *utilizationCache) apply(ctx context.Context, infos []*Information) {
+	if c == nil {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var stale []*Information
+	for _, info := range infos {
+		if info.LastStatus != statusRunning {
+			continue
+		}
+		if cached, ok := c.cache[info.ID]; ok && now.Before(cached.expiresAt) {
+			info.Utilization = cached.utilization
+			continue
+		}
+		stale = append(stale, info)
+	}
+	for id, cached := range c.cache {
+		if now.After(cached.expiresAt) {
+			delete(c.cache, id)
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, APICallTimeout)
+	defer cancel()
+	tasksPerCall := maxMetricDataQueries / len(utilizationMetrics)
+	for len(stale) > 0 {
+		n := min(len(stale), tasksPerCall)
+		chunk := stale[:n]
+		stale = stale[n:]
+		results, err := c.getMetricData(ctx, utilizationQueries(c.cluster, chunk), now)
+		if err != nil {
+			slog.WarnContext(ctx, f("failed to get utilization of tasks: %s", err))
+			return
+		}
+		utilizations := utilizationsOf(chunk, results)
+		for _, info := range chunk {
+			// tasks without datapoints are cached too, not to query them again until the cache expires
+			info.Utilization = utilizations[info.ID]
+			c.cache[info.ID] = cachedUtilization{utilization: info.Utilization, expiresAt: now.Add(c.cfg.CacheTTL)}
+		}
+	}
+}
+
+func (c *utilizationCache) getMetricData(ctx context.Context, queries []cwTypes.MetricDataQuery, now time.Time) ([]cwTypes.MetricDataResult, error) {
+	var results []cwTypes.MetricDataResult
+	p := cw.NewGetMetricDataPaginator(c.svc, &cw.GetMetricDataInput{
+		StartTime:         aws.Time(now.Add(-utilizationWindow)),
+		EndTime:           aws.Time(now),
+		MetricDataQueries: queries,
+	})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, out.MetricDataResults...)
+	}
+	return results, nil
+}
@@ -0,0 +1,71 @@
+package mirageecs_test
+
+import (
+	"testing"
+	"time"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cwTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUtilizationQueries(t *testing.T) {
+	infos := []*mirageecs.Information{
+		{ID: "arn:aws:ecs:ap-northeast-1:123456789012:task/default/aaa", ShortID: "aaa", TaskDef: "myapp:12"},
+	}
+	queries := mirageecs.UtilizationQueries("default", infos)
+	if len(queries) != 4 {
+		t.Fatalf("unexpected number of queries: %d", len(queries))
+	}
+	q := queries[2]
+	if aws.ToString(q.Id) != "m0_2" || aws.ToString(q.MetricStat.Metric.MetricName) != "MemoryUtilized" {
+		t.Errorf("unexpected query: %s %s", aws.ToString(q.Id), aws.ToString(q.MetricStat.Metric.MetricName))
+	}
+	dims := map[string]string{}
+	for _, d := range q.MetricStat.Metric.Dimensions {
+		dims[aws.ToString(d.Name)] = aws.ToString(d.Value)
+	}
+	if diff := cmp.Diff(map[string]string{"ClusterName": "default", "TaskDefinitionFamily": "myapp", "TaskId": "aaa"}, dims); diff != "" {
+		t.Errorf("unexpected dimensions (-want +got):\n%s", diff)
+	}
+}
+
+func TestUtilizationsOf(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	infos := []*mirageecs.Information{{ID: "task-a"}, {ID: "task-b"}}
+	result := func(id string, values ...float64) cwTypes.MetricDataResult {
+		ts := make([]time.Time, len(values))
+		for i := range values {
+			ts[i] = now.Add(-time.Duration(i) * time.Minute)
+		}
+		return cwTypes.MetricDataResult{Id: aws.String(id), Values: values, Timestamps: ts}
+	}
+	utilizations := mirageecs.UtilizationsOf(infos, []cwTypes.MetricDataResult{
+		result("m0_0", 128, 10),
+		result("m0_1", 256, 256),
+		result("m0_2", 900, 100),
+		result("m0_3", 1024),
+		result("m0_0", 1), // older values in the next page
+		result("m1_0"),
+	})
+	expected := map[string]*mirageecs.TaskUtilization{
+		"task-a": {CPU: 50, Memory: 87.9, Time: now},
+	}
+	if diff := cmp.Diff(expected, utilizations); diff != "" {
+		t.Errorf("unexpected utilizations (-want +got):\n%s", diff)
+	}
+}
+
+func TestUtilizationValidate(t *testing.T) {
+	u := &mirageecs.Utilization{}
+	if err := u.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if u.CacheTTL != mirageecs.DefaultUtilizationCacheTTL {
+		t.Errorf("unexpected default cache_ttl: %s", u.CacheTTL)
+	}
+	if err := (&mirageecs.Utilization{CacheTTL: -time.Second}).Validate(); err == nil {
+		t.Error("negative cache_ttl should be invalid")
+	}
+}
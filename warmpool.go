@@ -0,0 +1,254 @@
+package mirageecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// TagWarmPool is a tag of tasks in the warm pool. The value is the task definition of the pool.
+	TagWarmPool = "MirageWarmPool"
+
+	// EnvWarmPool is set to tasks in the warm pool. They should fetch their environment variables by /api/env after claimed.
+	EnvWarmPool = "MIRAGE_WARM_POOL"
+
+	warmPoolInterval = 30 * time.Second
+)
+
+// WarmPool keeps tasks of the task definition running without routes, so launches claim them instead of waiting for new tasks.
+type WarmPool struct {
+	// Taskdef is a task definition of tasks in the pool. Launches with the same taskdef claim them.
+	Taskdef string `yaml:"taskdef"`
+	// Size is the number of tasks in the pool.
+	Size int `yaml:"size"`
+}
+
+func (p *WarmPool) validate(cfg ECSCfg) error {
+	if p == nil {
+		return nil
+	}
+	if p.Taskdef == "" {
+		return fmt.Errorf("warm_pool.taskdef is required")
+	}
+	if p.Size <= 0 {
+		return fmt.Errorf("warm_pool.size must be positive: %d", p.Size)
+	}
+	if cfg.ServiceMode {
+		return fmt.Errorf("warm_pool is not supported in service_mode")
+	}
+	if cfg.EFS != nil {
+		// access points are created for each subdomain
+		return fmt.Errorf("warm_pool is not supported with efs")
+	}
+	return nil
+}
+
+// claimable reports whether the launch can claim a task in the pool.
+// Launches which need other task definitions or other overrides than environment variables of parameters launch new tasks.
+func (p *WarmPool) claimable(taskdefs []string, opt *LaunchOption) bool {
+	if p == nil || len(taskdefs) != 1 || taskdefs[0] != p.Taskdef {
+		return false
+	}
+	if opt == nil {
+		return true
+	}
+	return len(opt.CapacityProviderStrategy) == 0 &&
+		!opt.private() &&
+		!opt.hasImageTags() &&
+		opt.Cpu == "" && opt.Memory == "" &&
+		len(opt.Environment) == 0 &&
+		opt.RuntimePlatform == nil &&
+		opt.GPU == nil &&
+		len(opt.Command) == 0 && len(opt.EntryPoint) == 0 &&
+		opt.EnableExecuteCommand == nil
+}
+
+// claimWarmTask claims a task in the warm pool for the subdomain by tagging it. It returns false when no task is claimed.
+func (e *ECS) claimWarmTask(ctx context.Context, subdomain string, taskdefs []string, option TaskParameter, opt *LaunchOption) (bool, error) {
+	cfg := e.cfg
+	pool := cfg.ECS.WarmPool
+	if !pool.claimable(taskdefs, opt) {
+		return false, nil
+	}
+	tdOut, err := e.svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(pool.Taskdef),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to describe task definition: %w", err)
+	}
+	if mods, err := e.taskDefinitionModifiers(ctx, subdomain, tdOut.TaskDefinition, opt); err != nil {
+		return false, err
+	} else if len(mods) > 0 {
+		return false, nil
+	}
+	tags := option.ToECSTags(subdomain, cfg.Parameter)
+	tags = append(tags, option.ToPropagatedTags(cfg.Parameter, cfg.ECS.ParameterTagPrefix)...)
+	tags = append(tags, opt.ToECSTags()...)
+	if len(securityGroupsFor(cfg.ECS.SecurityGroupRules, tags)) > 0 {
+		return false, nil
+	}
+
+	e.warmPoolMu.Lock()
+	defer e.warmPoolMu.Unlock()
+	tasks, err := e.listWarmTasks(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, task := range tasks {
+		if getTagsFromTask(&task, TagWarmPool) != pool.Taskdef || aws.ToString(task.LastStatus) != statusRunning {
+			continue
+		}
+		_, err := e.svc.TagResource(ctx, &ecs.TagResourceInput{
+			ResourceArn: task.TaskArn,
+			Tags:        tags,
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to claim task %s: %w", aws.ToString(task.TaskArn), err)
+		}
+		slog.Info(f("subdomain %s claimed task %s in the warm pool", subdomain, shortenArn(aws.ToString(task.TaskArn))))
+		return true, nil
+	}
+	slog.Info(f("no task in the warm pool for subdomain %s. launching a new task", subdomain))
+	return false, nil
+}
+
+// listWarmTasks returns tasks in the warm pool which are not claimed yet.
+func (e *ECS) listWarmTasks(ctx context.Context) ([]types.Task, error) {
+	var tasks []types.Task
+	cluster := aws.String(e.cfg.ECS.Cluster)
+	p := ecs.NewListTasksPaginator(e.svc, &ecs.ListTasksInput{
+		Cluster:       cluster,
+		DesiredStatus: types.DesiredStatusRunning,
+	})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tasks: %w", err)
+		}
+		if len(out.TaskArns) == 0 {
+			break
+		}
+		res, err := e.svc.DescribeTasks(ctx, &ecs.DescribeTasksInput{
+			Cluster: cluster,
+			Tasks:   out.TaskArns,
+			Include: []types.TaskField{types.TaskFieldTags},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe tasks: %w", err)
+		}
+		for _, task := range res.Tasks {
+			// claimed tasks are managed as environments
+			if getTagsFromTask(&task, TagWarmPool) != "" && getTagsFromTask(&task, TagManagedBy) != TagValueMirage {
+				tasks = append(tasks, task)
+			}
+		}
+	}
+	return tasks, nil
+}
+
+// FillWarmPool runs tasks to keep the size of the warm pool, and stops tasks of the task definition which is not configured anymore.
+func (e *ECS) FillWarmPool(ctx context.Context) error {
+	pool := e.cfg.ECS.WarmPool
+	e.warmPoolMu.Lock()
+	defer e.warmPoolMu.Unlock()
+	tasks, err := e.listWarmTasks(ctx)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, task := range tasks {
+		if pool != nil && getTagsFromTask(&task, TagWarmPool) == pool.Taskdef {
+			n++
+			continue
+		}
+		slog.Info(f("stopping task %s in the warm pool of %s", shortenArn(aws.ToString(task.TaskArn)), getTagsFromTask(&task, TagWarmPool)))
+		if err := e.stopTask(ctx, aws.ToString(task.TaskArn)); err != nil {
+			return err
+		}
+	}
+	if pool == nil || n >= pool.Size {
+		return nil
+	}
+	tdOut, err := e.svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(pool.Taskdef),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe task definition: %w", err)
+	}
+	ov := &types.TaskOverride{}
+	for _, c := range tdOut.TaskDefinition.ContainerDefinitions {
+		ov.ContainerOverrides = append(ov.ContainerOverrides, types.ContainerOverride{
+			Name:        c.Name,
+			Environment: []types.KeyValuePair{{Name: aws.String(EnvWarmPool), Value: aws.String("true")}},
+		})
+	}
+	tags := []types.Tag{{Key: aws.String(TagWarmPool), Value: aws.String(pool.Taskdef)}}
+	for i := n; i < pool.Size; i++ {
+		slog.Info(f("launching a task in the warm pool of %s (%d/%d)", pool.Taskdef, i+1, pool.Size))
+		if err := e.runTask(ctx, pool.Taskdef, ov, tags, nil); err != nil {
+			return fmt.Errorf("failed to launch a task in the warm pool: %w", err)
+		}
+	}
+	return nil
+}
+
+// RunWarmPool keeps the size of the warm pool.
+func (m *Mirage) RunWarmPool(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	tk := time.NewTicker(warmPoolInterval)
+	defer tk.Stop()
+	for {
+		if err := m.runner.FillWarmPool(ctx); err != nil {
+			slog.Warn(f("failed to fill the warm pool: %s", err))
+		}
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			slog.Debug("RunWarmPool() is done")
+			return
+		}
+	}
+}
+
+// taskParameterFromTags returns parameters of the environment stored in tags.
+func taskParameterFromTags(tags []types.Tag, configParams Parameters) TaskParameter {
+	param := make(TaskParameter)
+	for _, p := range configParams {
+		if v := getTag(tags, p.Name); v != "" {
+			param[p.Name] = v
+		}
+	}
+	return param
+}
+
+// ApiEnv returns environment variables of the environment which claimed the task in the warm pool.
+func (api *WebApi) ApiEnv(c echo.Context) error {
+	id := c.QueryParam("id")
+	if id == "" {
+		return c.JSON(http.StatusBadRequest, APICommonResponse{Result: "parameter required: id"})
+	}
+	infos, err := api.runner.List(c.Request().Context(), statusRunning)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, APICommonResponse{Result: err.Error()})
+	}
+	for _, info := range infos {
+		if info.ID != id && info.ShortID != id {
+			continue
+		}
+		param := taskParameterFromTags(info.Tags, api.cfg.Parameter)
+		return c.JSON(http.StatusOK, APIEnvResponse{
+			Result: "ok",
+			Env:    param.ToEnv(info.SubDomain, api.cfg.Parameter, api.cfg.EncodeSubdomain),
+		})
+	}
+	return c.JSON(http.StatusNotFound, APICommonResponse{Result: fmt.Sprintf("task %s is not claimed", id)})
+}
@@ -0,0 +1,117 @@
+package mirageecs_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+	"github.com/labstack/echo/v4"
+)
+
+func TestWarmPoolValidate(t *testing.T) {
+	cases := []struct {
+		name string
+		pool *mirageecs.WarmPool
+		cfg  mirageecs.ECSCfg
+		ok   bool
+	}{
+		{"nil", nil, mirageecs.ECSCfg{}, true},
+		{"valid", &mirageecs.WarmPool{Taskdef: "app:1", Size: 2}, mirageecs.ECSCfg{}, true},
+		{"no taskdef", &mirageecs.WarmPool{Size: 2}, mirageecs.ECSCfg{}, false},
+		{"no size", &mirageecs.WarmPool{Taskdef: "app:1"}, mirageecs.ECSCfg{}, false},
+		{"service mode", &mirageecs.WarmPool{Taskdef: "app:1", Size: 2}, mirageecs.ECSCfg{ServiceMode: true}, false},
+		{"efs", &mirageecs.WarmPool{Taskdef: "app:1", Size: 2}, mirageecs.ECSCfg{EFS: &mirageecs.EFSCfg{}}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.pool.Validate(c.cfg)
+			if c.ok && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if !c.ok && err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestWarmPoolClaimable(t *testing.T) {
+	pool := &mirageecs.WarmPool{Taskdef: "app:1", Size: 1}
+	protected := &mirageecs.LaunchOption{Protected: true, Aliases: []string{"demo.example.com"}}
+	cases := []struct {
+		name     string
+		pool     *mirageecs.WarmPool
+		taskdefs []string
+		opt      *mirageecs.LaunchOption
+		expected bool
+	}{
+		{"no pool", nil, []string{"app:1"}, nil, false},
+		{"same taskdef", pool, []string{"app:1"}, nil, true},
+		{"options stored in tags", pool, []string{"app:1"}, protected, true},
+		{"other taskdef", pool, []string{"app:2"}, nil, false},
+		{"multiple taskdefs", pool, []string{"app:1", "worker:1"}, nil, false},
+		{"image tag", pool, []string{"app:1"}, &mirageecs.LaunchOption{ImageTag: "v2"}, false},
+		{"environment", pool, []string{"app:1"}, &mirageecs.LaunchOption{Environment: map[string]string{"FOO": "bar"}}, false},
+		{"private", pool, []string{"app:1"}, &mirageecs.LaunchOption{Visibility: mirageecs.VisibilityPrivate}, false},
+		{"command", pool, []string{"app:1"}, &mirageecs.LaunchOption{Command: map[string][]string{"app": {"true"}}}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.pool.Claimable(c.taskdefs, c.opt); got != c.expected {
+				t.Errorf("Claimable() = %v, want %v", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestApiEnv(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := mirageecs.NewConfig(ctx, &mirageecs.ConfigParams{
+		LocalMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mirageecs.New(ctx, cfg)
+	if err := m.Runner().Launch(ctx, "foo", mirageecs.TaskParameter{"branch": "develop"}, nil, "app:1"); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := m.Runner().List(ctx, "RUNNING")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	for _, c := range []struct {
+		id     string
+		status int
+	}{
+		{infos[0].ShortID, http.StatusOK},
+		{infos[0].ID, http.StatusOK},
+		{"unknown", http.StatusNotFound},
+		{"", http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/env?id="+c.id, nil)
+		rec := httptest.NewRecorder()
+		if err := m.WebApi.ApiEnv(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != c.status {
+			t.Errorf("id %q: wanted status %d, got %d", c.id, c.status, rec.Code)
+			continue
+		}
+		if c.status != http.StatusOK {
+			continue
+		}
+		var res mirageecs.APIEnvResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Env["SUBDOMAIN"] == "" || res.Env["GIT_BRANCH"] != "develop" {
+			t.Errorf("unexpected env %v", res.Env)
+		}
+	}
+}
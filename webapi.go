@@ -2,15 +2,16 @@ package mirageecs
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"path"
 	"regexp"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -28,21 +29,43 @@ const PurgeMinimumDuration = 5 * time.Minute
 
 const APICallTimeout = 30 * time.Second
 
+// ListRefreshInterval is an interval to refresh the list of environments in the web interface.
+const ListRefreshInterval = 10 * time.Second
+
 type WebApi struct {
 	*echo.Echo
 
 	cfg    *Config
 	runner TaskRunner
 	mu     *sync.Mutex
+
+	purgeWarner               *purgeWarner
+	terminateAllConfirmations *terminateAllConfirmations
+	launchQueue               *launchQueue
+	redeployer                *redeployer
+	health                    *healthState
+	election                  *leaderElection
+	utilization               *utilizationCache
+	taskEvents                *taskEventNotifier
+	errorAlerts               *errorAlerter
+	// accessStats returns statistics of responses of the subdomain by the reverse proxy.
+	accessStats func(subdomain string, since time.Time) []*AccessStats
+	// routable reports whether the subdomain is routed to the IP address by the reverse proxy.
+	routable func(subdomain string, ipaddress string) bool
 }
 
 type Template struct {
 	templates *template.Template
+	cfg       *Config
 }
 
 func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
 	if m, ok := data.(map[string]interface{}); ok {
 		m["Version"] = Version
+		m["Branding"] = t.cfg.Branding
+		if _, ok := m["Locale"]; !ok {
+			m["Locale"] = t.cfg.requestLocale(c)
+		}
 		return t.templates.ExecuteTemplate(w, name, m)
 	} else {
 		return t.templates.ExecuteTemplate(w, name, data)
@@ -55,18 +78,49 @@ func NewWebApi(cfg *Config, runner TaskRunner) *WebApi {
 		runner: runner,
 	}
 	app.cfg = cfg
+	app.purgeWarner = newPurgeWarner(cfg)
+	app.terminateAllConfirmations = newTerminateAllConfirmations(cfg.redis)
+	app.launchQueue = newLaunchQueue(cfg.ECS.LaunchQueue, runner)
+	app.redeployer = newRedeployer()
+	app.health = newHealthState(cfg.ECS.TaskEvents.syncInterval())
+	app.utilization = newUtilizationCache(cfg)
+	app.taskEvents = newTaskEventNotifier(cfg)
+	app.errorAlerts = newErrorAlerter(cfg)
 
 	e := echo.New()
-	e.Use(middleware.Logger())
+	e.Use(middleware.RequestID())
+	e.Use(logContextMiddleware)
+	e.Use(cfg.actorMiddleware)
+	e.Use(middleware.RequestLoggerWithConfig(accessLogConfig))
 
 	web := e.Group("")
 	web.Use(cfg.AuthMiddlewareForWeb)
 	web.GET("/", app.Top)
 	web.GET("/list", app.List)
+	web.GET("/stopped", app.Stopped)
 	web.GET("/launcher", app.Launcher)
 	web.GET("/trace/:taskid", app.Trace)
+	web.GET("/logs/:subdomain", app.LogsViewer)
+	web.GET("/logs/:subdomain/stream", app.LogsStream)
+	web.GET("/access/:subdomain", app.Access)
 	web.POST("/launch", app.Launch)
 	web.POST("/terminate", app.Terminate)
+	web.POST("/terminate_selected", app.TerminateSelected)
+	web.POST("/protect", app.Protect)
+	web.POST("/unprotect", app.Unprotect)
+	web.POST("/extend", app.Extend)
+	web.POST("/relaunch", app.Relaunch)
+	web.GET("/purge", app.PurgePreview, cfg.AuthMiddlewareForAdmin)
+	web.POST("/purge", app.PurgeSelected, cfg.AuthMiddlewareForAdmin)
+
+	// health checks of load balancers can not be authorized
+	e.GET("/api/health", app.ApiHealth)
+	// webhooks are authorized by their signatures
+	e.POST("/hooks/github", app.HookGitHub)
+	e.POST("/hooks/bitbucket", app.HookBitbucket)
+	e.POST("/hooks/slack/command", app.HookSlackCommand)
+	e.POST("/hooks/slack/interaction", app.HookSlackInteraction)
+	e.StaticFS("/static/", staticFS(cfg.HtmlDir))
 
 	api := e.Group("/api")
 	api.Use(cfg.CompatMiddlewareForAPI)
@@ -74,20 +128,48 @@ func NewWebApi(cfg *Config, runner TaskRunner) *WebApi {
 	api.GET("/list", app.ApiList)
 	api.GET("/access", app.ApiAccess)
 	api.GET("/logs", app.ApiLogs)
+	api.GET("/logs/query", app.ApiLogsQuery)
+	api.GET("/logs/download", app.ApiLogsDownload)
+	api.GET("/exec", app.ApiExec)
 	api.POST("/launch", app.ApiLaunch)
 	api.POST("/terminate", app.ApiTerminate)
 	api.POST("/purge", app.ApiPurge)
+	api.POST("/protect", app.ApiProtect)
+	api.POST("/unprotect", app.ApiUnprotect)
+	api.POST("/alias", app.ApiAlias)
+	api.POST("/unalias", app.ApiUnalias)
+	api.POST("/extend", app.ApiExtend)
+	api.GET("/dnsendpoint", app.ApiDNSEndpoint)
+	api.GET("/env", app.ApiEnv)
+	api.GET("/quotas", app.ApiQuotas)
+	api.GET("/cost", app.ApiCost)
+	api.GET("/queue", app.ApiQueue)
+	api.POST("/clone", app.ApiClone)
+	api.POST("/relaunch", app.ApiRelaunch)
+	api.POST("/ecr_event", app.ApiECREvent)
+	api.POST("/ecs_event", app.ApiECSEvent)
+	api.GET("/history", app.ApiHistory)
+	api.POST("/terminate_all", app.ApiTerminateAll, cfg.AuthMiddlewareForAdmin)
+	api.GET("/debug/pprof/", app.ApiPprof, cfg.AuthMiddlewareForAdmin)
+	api.GET("/debug/pprof/:name", app.ApiPprof, cfg.AuthMiddlewareForAdmin)
+	api.GET("/debug/runtime", app.ApiRuntime, cfg.AuthMiddlewareForAdmin)
 
 	e.Renderer = &Template{
-		templates: template.Must(template.ParseGlob(cfg.HtmlDir + "/*")),
+		templates: template.Must(loadTemplates(cfg.HtmlDir)),
+		cfg:       cfg,
 	}
+	e.JSONSerializer = &localizedJSONSerializer{cfg: cfg}
 	app.Echo = e
 
 	return app
 }
 
 func (api *WebApi) Top(c echo.Context) error {
-	return c.Render(http.StatusOK, "layout.html", map[string]interface{}{})
+	return c.Render(http.StatusOK, "layout.html", map[string]interface{}{
+		"Launch":               c.QueryParam("launch"),
+		"RefreshInterval":      int(ListRefreshInterval.Seconds()),
+		"StateRefreshInterval": int(StateRefreshInterval.Seconds()),
+	})
 }
 
 func (api *WebApi) List(c echo.Context) error {
@@ -96,50 +178,92 @@ func (api *WebApi) List(c echo.Context) error {
 	if err != nil {
 		return c.String(http.StatusInternalServerError, err.Error())
 	}
+	infoSleeping, err := api.runner.ListSleeping(ctx)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	quotas := api.cfg.ECS.quotaUsages(infoRunning)
+	infoRunning = append(infoRunning, infoSleeping...)
+	api.setDashboardURLs(infoRunning)
+	api.setAccessGraphs(infoRunning, time.Now())
 	infoStopped, err := api.runner.List(ctx, statusStopped)
 	if err != nil {
 		return c.String(http.StatusInternalServerError, err.Error())
 	}
-	sort.Slice(infoStopped, func(i, j int) bool {
-		return infoStopped[i].Created.Before(infoStopped[j].Created)
-	})
-	// stopped subdomains shows only one
-	stoppedSubdomains := make(map[string]struct{}, len(infoStopped))
-	infoStopped = lo.Filter(infoStopped, func(info *Information, _ int) bool {
-		if _, ok := stoppedSubdomains[info.SubDomain]; ok {
-			// already seen
-			return false
-		}
-		stoppedSubdomains[info.SubDomain] = struct{}{}
-		return true
-	})
+	// stopped subdomains shows only the last one
+	infoStopped = lastStoppedBySubdomain(infoStopped, infoRunning, api.cfg.ECS.StoppedTaskRetention)
+	for _, info := range infoStopped {
+		info.LogsURL = logsURL(info.SubDomain)
+	}
 	info := append(infoRunning, infoStopped...)
+	api.cfg.ECS.Cost.estimate(info, time.Now())
+	api.utilization.apply(ctx, info)
+	api.errorAlerts.apply(info)
+	setStates(info)
 	value := map[string]interface{}{
-		"info":  info,
-		"error": err,
+		"info":   info,
+		"quotas": quotas,
+		"error":  err,
+	}
+	if cost := api.cfg.ECS.Cost; cost != nil {
+		value["currency"] = cost.Currency
 	}
 	return c.Render(http.StatusOK, "list.html", value)
 }
 
 func (api *WebApi) Launcher(c echo.Context) error {
+	req := c.Request()
+	if req.Header.Get("Hx-Request") != "true" && strings.Contains(req.Header.Get("Accept"), "text/html") && c.QueryParam("clone") == "" {
+		// navigated by browsers (e.g. links of the launcher page of catch_all). the launcher is a modal of the top page
+		return c.Redirect(http.StatusSeeOther, "/?"+url.Values{"launch": {c.QueryParam("subdomain")}}.Encode())
+	}
 	var taskdefs []string
 	if api.cfg.Link.DefaultTaskDefinitions != nil {
 		taskdefs = api.cfg.Link.DefaultTaskDefinitions
 	} else {
 		taskdefs = []string{api.cfg.ECS.DefaultTaskDefinition}
 	}
+	// initial values of parameters, which are pre-filled by the source of the clone
+	values := make(map[string]string, len(api.cfg.Parameter))
+	for _, p := range api.cfg.Parameter {
+		values[p.Name] = p.Default
+		if p.Type == ParameterTypeBoolean {
+			values[p.Name] = strconv.FormatBool(p.Checked())
+		}
+	}
+	var cloneErr string
+	source := c.QueryParam("clone")
+	if source != "" {
+		if sourceTaskdefs, params, err := api.cloneSource(c.Request().Context(), source); err != nil {
+			cloneErr = err.Error()
+		} else {
+			taskdefs = sourceTaskdefs
+			for k, v := range params {
+				values[k] = v
+			}
+		}
+	}
 	return c.Render(http.StatusOK, "launcher.html", map[string]interface{}{
 		"DefaultTaskDefinitions": taskdefs,
 		"Parameters":             api.cfg.Parameter,
+		"Values":                 values,
+		"Clone":                  source,
+		"Error":                  cloneErr,
+		"Subdomain":              c.QueryParam("subdomain"),
+		"CpuArchitecture":        cpuArchitecture(api.cfg.ECS.RuntimePlatform),
+		"EnableExecuteCommand":   api.cfg.ECS.enableExecuteCommandFor(nil),
 	})
 }
 
 func (api *WebApi) Launch(c echo.Context) error {
-	code, err := api.launch(c)
+	code, _, err := api.launch(c)
 	if err != nil {
-		return c.String(code, err.Error())
+		return c.String(code, translate(api.cfg.requestLocale(c), err.Error()))
 	}
 	if c.Request().Header.Get("Hx-Request") == "true" {
+		if code == http.StatusAccepted {
+			return c.String(code, LaunchJobQueued)
+		}
 		return c.String(code, "ok")
 	}
 	return c.Redirect(http.StatusSeeOther, "/")
@@ -153,6 +277,30 @@ func (api *WebApi) Terminate(c echo.Context) error {
 	return c.Redirect(http.StatusSeeOther, "/")
 }
 
+func (api *WebApi) Protect(c echo.Context) error {
+	code, err := api.protect(c, true)
+	if err != nil {
+		c.String(code, err.Error())
+	}
+	return c.Redirect(http.StatusSeeOther, "/")
+}
+
+func (api *WebApi) Unprotect(c echo.Context) error {
+	code, err := api.protect(c, false)
+	if err != nil {
+		c.String(code, err.Error())
+	}
+	return c.Redirect(http.StatusSeeOther, "/")
+}
+
+func (api *WebApi) Extend(c echo.Context) error {
+	code, _, err := api.extend(c)
+	if err != nil {
+		c.String(code, err.Error())
+	}
+	return c.Redirect(http.StatusSeeOther, "/")
+}
+
 func (api *WebApi) Trace(c echo.Context) error {
 	taskID := c.Param("taskid")
 	if taskID == "" {
@@ -166,54 +314,155 @@ func (api *WebApi) Trace(c echo.Context) error {
 }
 
 func (api *WebApi) ApiList(c echo.Context) error {
-	info, err := api.runner.List(c.Request().Context(), statusRunning)
+	ctx := c.Request().Context()
+	info, err := api.runner.List(ctx, statusRunning)
 	if err != nil {
 		return c.JSON(500, APIListResponse{})
 	}
+	sleeping, err := api.runner.ListSleeping(ctx)
+	if err != nil {
+		return c.JSON(500, APIListResponse{})
+	}
+	info = append(info, sleeping...)
+	api.setDashboardURLs(info)
+	if retention := api.cfg.ECS.StoppedTaskRetention; retention > 0 {
+		stopped, err := api.runner.List(ctx, statusStopped)
+		if err != nil {
+			return c.JSON(500, APIListResponse{})
+		}
+		for _, s := range lastStoppedBySubdomain(stopped, info, retention) {
+			s.LogsURL = logsURL(s.SubDomain)
+			info = append(info, s)
+		}
+	}
+	api.cfg.ECS.Cost.estimate(info, time.Now())
+	api.utilization.apply(ctx, info)
+	api.errorAlerts.apply(info)
+	setStates(info)
 	return c.JSON(200, APIListResponse{Result: info})
 }
 
+func logsURL(subdomain string) string {
+	return "/api/logs?" + url.Values{"subdomain": []string{subdomain}}.Encode()
+}
+
+// setDashboardURLs sets links to dashboards of running and sleeping environments.
+func (api *WebApi) setDashboardURLs(infos []*Information) {
+	for _, info := range infos {
+		info.DashboardURL = api.cfg.ECS.Dashboard.dashboardURL(api.cfg.ECS.Region, info.SubDomain)
+	}
+}
+
 func (api *WebApi) ApiLaunch(c echo.Context) error {
-	code, err := api.launch(c)
+	code, r, err := api.launch(c)
 	if err != nil {
 		return c.JSON(code, APICommonResponse{Result: err.Error()})
 	}
+	if timeout, _ := r.WaitTimeout(); timeout > 0 {
+		return api.waitLaunch(c, r.Subdomain, timeout)
+	}
+	if code == http.StatusAccepted {
+		return c.JSON(code, APICommonResponse{Result: LaunchJobQueued})
+	}
 	return c.JSON(code, APICommonResponse{Result: "ok"})
 }
 
-func (api *WebApi) launch(c echo.Context) (int, error) {
+func (api *WebApi) launch(c echo.Context) (int, *APILaunchRequest, error) {
 	r := APILaunchRequest{}
 	ps, _ := c.FormParams()
 	r.MergeForm(ps)
 	if err := c.Bind(&r); err != nil {
-		return http.StatusBadRequest, err
+		return http.StatusBadRequest, nil, err
+	}
+	// wait and timeout are also accepted as query parameters of JSON requests
+	if r.Wait == "" {
+		r.Wait = c.QueryParam("wait")
+	}
+	if r.Timeout == "" {
+		r.Timeout = c.QueryParam("timeout")
+	}
+	if _, err := r.WaitTimeout(); err != nil {
+		return http.StatusBadRequest, nil, err
 	}
 
 	subdomain := r.Subdomain
 	subdomain = strings.ToLower(subdomain)
+	r.Subdomain = subdomain
+	ctx := withLogAttrs(c.Request().Context(), slog.String("subdomain", subdomain))
 	if err := validateSubdomain(subdomain); err != nil {
-		slog.Error(f("launch failed: %s", err))
-		return http.StatusBadRequest, err
+		slog.ErrorContext(ctx, f("launch failed: %s", err))
+		return http.StatusBadRequest, nil, err
 	}
 	taskdefs := r.Taskdef
-	parameter, err := api.LoadParameter(r.GetParameter)
+	if len(taskdefs) == 0 && api.cfg.ECS.TaskDefinitionTemplate != "" {
+		// an empty taskdef means a task definition rendered from the template
+		taskdefs = []string{""}
+	}
+	parameter, err := api.LoadParameter(r.GetParameter, taskdefs...)
 	if err != nil {
-		slog.Error(f("failed to load parameter: %s", err))
-		return http.StatusBadRequest, err
+		slog.ErrorContext(ctx, f("failed to load parameter: %s", err))
+		return http.StatusBadRequest, nil, err
+	}
+	opt, err := r.LaunchOption()
+	if err != nil {
+		slog.ErrorContext(ctx, f("failed to load launch option: %s", err))
+		return http.StatusBadRequest, nil, err
+	}
+	if err := api.cfg.ECS.validateScheduleOption(opt.Schedule); err != nil {
+		return http.StatusBadRequest, nil, err
 	}
 
 	if subdomain == "" || len(taskdefs) == 0 {
-		return http.StatusBadRequest, fmt.Errorf("parameter required: subdomain=%s, taskdef=%v", subdomain, taskdefs)
+		return http.StatusBadRequest, nil, fmt.Errorf("parameter required: subdomain=%s, taskdef=%v", subdomain, taskdefs)
 	} else {
-		ctx, cancel := context.WithTimeout(c.Request().Context(), APICallTimeout)
+		ctx, cancel := context.WithTimeout(ctx, APICallTimeout)
 		defer cancel()
-		err := api.runner.Launch(ctx, subdomain, parameter, taskdefs...)
-		if err != nil {
-			slog.Error(f("launch failed: %s", err))
-			return http.StatusInternalServerError, err
+		tags := parameter.ToECSTags(subdomain, api.cfg.Parameter)
+		tags = append(tags, parameter.ToPropagatedTags(api.cfg.Parameter, api.cfg.ECS.ParameterTagPrefix)...)
+		if err := checkLaunchQuotas(ctx, api.cfg, api.runner, subdomain, len(taskdefs), tags); err != nil {
+			slog.ErrorContext(ctx, f("launch failed: %s", err))
+			if isQuotaExceeded(err) {
+				return http.StatusTooManyRequests, nil, err
+			}
+			return http.StatusInternalServerError, nil, err
+		}
+		api.launchQueue.cancel(subdomain)
+		err := api.runner.Launch(ctx, subdomain, parameter, opt, taskdefs...)
+		if err != nil && api.launchQueue != nil && isCapacityError(err) {
+			api.launchQueue.enqueue(subdomain, parameter, opt, taskdefs, err)
+			return http.StatusAccepted, &r, nil
+		} else if err != nil {
+			slog.ErrorContext(ctx, f("launch failed: %s", err))
+			return http.StatusInternalServerError, nil, err
 		}
 	}
-	return http.StatusOK, nil
+	return http.StatusOK, &r, nil
+}
+
+// launchWith launches the environment by webhooks and chat commands. Running tasks of the subdomain are replaced.
+func (api *WebApi) launchWith(ctx context.Context, subdomain string, taskdefs []string, params map[string]string, opt *LaunchOption) error {
+	if len(taskdefs) == 0 {
+		// an empty taskdef means a task definition rendered from the template
+		taskdefs = []string{""}
+	}
+	param, err := api.LoadParameter(func(name string) string {
+		return params[name]
+	}, taskdefs...)
+	if err != nil {
+		return err
+	}
+	tags := param.ToECSTags(subdomain, api.cfg.Parameter)
+	tags = append(tags, param.ToPropagatedTags(api.cfg.Parameter, api.cfg.ECS.ParameterTagPrefix)...)
+	if err := checkLaunchQuotas(ctx, api.cfg, api.runner, subdomain, len(taskdefs), tags); err != nil {
+		return err
+	}
+	api.launchQueue.cancel(subdomain)
+	err = api.runner.Launch(ctx, subdomain, param, opt, taskdefs...)
+	if err != nil && api.launchQueue != nil && isCapacityError(err) {
+		api.launchQueue.enqueue(subdomain, param, opt, taskdefs, err)
+		return nil
+	}
+	return err
 }
 
 func (api *WebApi) ApiLogs(c echo.Context) error {
@@ -224,6 +473,14 @@ func (api *WebApi) ApiLogs(c echo.Context) error {
 	return c.JSON(code, APILogsResponse{Result: logs})
 }
 
+func (api *WebApi) ApiExec(c echo.Context) error {
+	code, command, err := api.exec(c)
+	if err != nil {
+		return c.JSON(code, APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(code, APIExecResponse{Result: "ok", Command: command})
+}
+
 func (api *WebApi) ApiTerminate(c echo.Context) error {
 	code, err := api.terminate(c)
 	if err != nil {
@@ -237,21 +494,73 @@ func (api *WebApi) ApiAccess(c echo.Context) error {
 	if err != nil {
 		return c.JSON(code, APICommonResponse{Result: err.Error()})
 	}
-	return c.JSON(code, APIAccessResponse{Result: "ok", Sum: sum, Duration: duration})
+	res := APIAccessResponse{Result: "ok", Sum: sum, Duration: duration}
+	if api.accessStats != nil {
+		since := time.Now().Add(-time.Duration(duration) * time.Second)
+		res.Stats = api.accessStats(c.QueryParam("subdomain"), since)
+	}
+	return c.JSON(code, res)
 }
 
 func (api *WebApi) ApiPurge(c echo.Context) error {
-	code, err := api.purge(c)
+	code, candidates, err := api.purge(c)
 	if err != nil {
 		return c.JSON(code, APICommonResponse{Result: err.Error()})
 	}
+	if candidates != nil {
+		return c.JSON(code, APIPurgeResponse{Result: "ok", Candidates: candidates})
+	}
 	return c.JSON(code, APICommonResponse{Result: "accepted"})
 }
 
+func (api *WebApi) ApiProtect(c echo.Context) error {
+	code, err := api.protect(c, true)
+	if err != nil {
+		return c.JSON(code, APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(code, APICommonResponse{Result: "ok"})
+}
+
+func (api *WebApi) ApiUnprotect(c echo.Context) error {
+	code, err := api.protect(c, false)
+	if err != nil {
+		return c.JSON(code, APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(code, APICommonResponse{Result: "ok"})
+}
+
+func (api *WebApi) ApiAlias(c echo.Context) error {
+	code, err := api.alias(c, true)
+	if err != nil {
+		return c.JSON(code, APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(code, APICommonResponse{Result: "ok"})
+}
+
+func (api *WebApi) ApiUnalias(c echo.Context) error {
+	code, err := api.alias(c, false)
+	if err != nil {
+		return c.JSON(code, APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(code, APICommonResponse{Result: "ok"})
+}
+
+func (api *WebApi) ApiExtend(c echo.Context) error {
+	code, expiresAt, err := api.extend(c)
+	if err != nil {
+		return c.JSON(code, APICommonResponse{Result: err.Error()})
+	}
+	return c.JSON(code, APIExtendResponse{Result: "ok", ExpiresAt: expiresAt})
+}
+
 func (api *WebApi) logs(c echo.Context) (int, []string, error) {
 	subdomain := c.QueryParam("subdomain")
 	since := c.QueryParam("since")
 	tail := c.QueryParam("tail")
+	filter := &LogsFilter{
+		Pattern:   c.QueryParam("filter"),
+		Container: c.QueryParam("container"),
+	}
 
 	if subdomain == "" {
 		return http.StatusBadRequest, nil, fmt.Errorf("parameter required: subdomain")
@@ -275,10 +584,17 @@ func (api *WebApi) logs(c echo.Context) (int, []string, error) {
 			tailN = n
 		}
 	}
+	if re := c.QueryParam("regexp"); re != "" {
+		var err error
+		filter.Regexp, err = regexp.Compile(re)
+		if err != nil {
+			return http.StatusBadRequest, nil, fmt.Errorf("cannot parse regexp: %s", err)
+		}
+	}
 
 	ctx, cancel := context.WithTimeout(c.Request().Context(), APICallTimeout)
 	defer cancel()
-	logs, err := api.runner.Logs(ctx, subdomain, sinceTime, tailN)
+	logs, err := api.runner.Logs(ctx, subdomain, sinceTime, tailN, filter)
 	if err != nil {
 		return http.StatusInternalServerError, nil, err
 	}
@@ -300,6 +616,7 @@ func (api *WebApi) terminate(c echo.Context) (int, error) {
 			return http.StatusInternalServerError, err
 		}
 	} else if subdomain != "" {
+		api.launchQueue.cancel(subdomain)
 		if err := api.runner.TerminateBySubdomain(ctx, subdomain); err != nil {
 			return http.StatusInternalServerError, err
 		}
@@ -309,6 +626,125 @@ func (api *WebApi) terminate(c echo.Context) (int, error) {
 	return http.StatusOK, nil
 }
 
+func (api *WebApi) exec(c echo.Context) (int, string, error) {
+	subdomain := c.QueryParam("subdomain")
+	if subdomain == "" {
+		return http.StatusBadRequest, "", fmt.Errorf("parameter required: subdomain")
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), APICallTimeout)
+	defer cancel()
+	infos, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		return http.StatusInternalServerError, "", err
+	}
+	for _, info := range infos {
+		if info.SubDomain != subdomain {
+			continue
+		}
+		if !info.ExecuteCommandEnabled {
+			return http.StatusBadRequest, "", fmt.Errorf("ECS Exec is not enabled for subdomain %s", subdomain)
+		}
+		return http.StatusOK, execCommandLine(api.cfg.ECS.Cluster, info, c.QueryParam("container"), c.QueryParam("command")), nil
+	}
+	return http.StatusNotFound, "", fmt.Errorf("subdomain %s is not found", subdomain)
+}
+
+func (api *WebApi) protect(c echo.Context, protected bool) (int, error) {
+	r := APIProtectRequest{}
+	if err := c.Bind(&r); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if r.Subdomain == "" {
+		return http.StatusBadRequest, fmt.Errorf("parameter required: subdomain")
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), APICallTimeout)
+	defer cancel()
+	if err := api.runner.Protect(ctx, r.Subdomain, protected); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func (api *WebApi) extend(c echo.Context) (int, time.Time, error) {
+	r := APIExtendRequest{}
+	if err := c.Bind(&r); err != nil {
+		return http.StatusBadRequest, time.Time{}, err
+	}
+	if r.Subdomain == "" {
+		return http.StatusBadRequest, time.Time{}, fmt.Errorf("parameter required: subdomain")
+	}
+	d := DefaultTTLExtension
+	if r.Duration != "" {
+		var err error
+		if d, err = parseTTL("duration", r.Duration); err != nil {
+			return http.StatusBadRequest, time.Time{}, err
+		}
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), APICallTimeout)
+	defer cancel()
+	infos, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		return http.StatusInternalServerError, time.Time{}, err
+	}
+	info, found := lo.Find(infos, func(info *Information) bool { return info.SubDomain == r.Subdomain })
+	if !found {
+		return http.StatusNotFound, time.Time{}, fmt.Errorf("subdomain %s is not found", r.Subdomain)
+	}
+	current, ok := info.Option.expiresAt()
+	if !ok {
+		return http.StatusBadRequest, time.Time{}, fmt.Errorf("subdomain %s has no ttl", r.Subdomain)
+	}
+	expiresAt := extendExpiresAt(current, time.Now(), d)
+	if err := api.runner.SetExpiresAt(ctx, r.Subdomain, expiresAt); err != nil {
+		return http.StatusInternalServerError, time.Time{}, err
+	}
+	slog.Info(f("subdomain %s expiry extended by %s: %s -> %s (requested from %s)",
+		r.Subdomain, d, formatExpiresAt(current), formatExpiresAt(expiresAt), c.RealIP()))
+	return http.StatusOK, expiresAt, nil
+}
+
+func (api *WebApi) alias(c echo.Context, add bool) (int, error) {
+	r := APIAliasRequest{}
+	if err := c.Bind(&r); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if r.Subdomain == "" || r.Hostname == "" {
+		return http.StatusBadRequest, fmt.Errorf("parameter required: subdomain and hostname")
+	}
+	hostname := strings.ToLower(strings.TrimSuffix(r.Hostname, "."))
+	if add {
+		if err := api.cfg.Host.Aliases.validateHostname(hostname, api.cfg.Host); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), APICallTimeout)
+	defer cancel()
+	infos, err := api.runner.List(ctx, statusRunning)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	var aliases []string
+	found := false
+	for _, info := range infos {
+		if info.SubDomain == r.Subdomain {
+			if add && info.Option.private() {
+				return http.StatusBadRequest, fmt.Errorf("aliases are not allowed for private environments")
+			}
+			found = true
+			aliases = info.Option.aliases()
+		} else if add && lo.Contains(info.Option.aliases(), hostname) {
+			return http.StatusConflict, fmt.Errorf("hostname %s is an alias of subdomain %s", hostname, info.SubDomain)
+		}
+	}
+	if !found {
+		return http.StatusNotFound, fmt.Errorf("subdomain %s is not found", r.Subdomain)
+	}
+	if err := api.runner.SetAliases(ctx, r.Subdomain, withAlias(aliases, hostname, add)); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
 func (api *WebApi) accessCounter(c echo.Context) (int, int64, int64, error) {
 	subdomain := c.QueryParam("subdomain")
 	duration := c.QueryParam("duration")
@@ -325,10 +761,14 @@ func (api *WebApi) accessCounter(c echo.Context) (int, int64, int64, error) {
 	return http.StatusOK, sum, durationInt, nil
 }
 
-func (api *WebApi) LoadParameter(getFunc func(string) string) (TaskParameter, error) {
+// LoadParameter loads parameters used by the task definitions. Parameters used by other task definitions are ignored.
+func (api *WebApi) LoadParameter(getFunc func(string) string, taskdefs ...string) (TaskParameter, error) {
 	parameter := make(TaskParameter)
 
 	for _, v := range api.cfg.Parameter {
+		if !v.usedBy(taskdefs) {
+			continue
+		}
 		param := getFunc(v.Name)
 		if param == "" && v.Default != "" {
 			param = v.Default
@@ -339,6 +779,10 @@ func (api *WebApi) LoadParameter(getFunc func(string) string) (TaskParameter, er
 			continue
 		}
 
+		param, err := v.normalize(param)
+		if err != nil {
+			return nil, err
+		}
 		if v.Rule != "" {
 			if !v.Regexp.MatchString(param) {
 				return nil, fmt.Errorf("parameter %s value is rule error", v.Name)
@@ -372,29 +816,65 @@ func validateSubdomain(s string) error {
 	return nil
 }
 
-func (api *WebApi) purge(c echo.Context) (int, error) {
+func (api *WebApi) purge(c echo.Context) (int, []*PurgeCandidate, error) {
 	r := APIPurgeRequest{}
 	if err := c.Bind(&r); err != nil {
-		return http.StatusBadRequest, err
+		return http.StatusBadRequest, nil, err
+	}
+	if r.DryRun {
+		return api.purgeCandidates(c.Request().Context(), &r)
 	}
+	code, err := api.purgeBy(c.Request().Context(), &r)
+	return code, nil, err
+}
+
+// purgeBy purges environments by the request. Omitted duration is the default of purge in the config.
+func (api *WebApi) purgeBy(ctx context.Context, r *APIPurgeRequest) (int, error) {
+	duration, infos, code, err := api.purgeTargets(ctx, r)
+	if err != nil {
+		return code, err
+	}
+	terminates := lo.Map(infos, func(info *Information, _ int) string {
+		return info.SubDomain
+	})
+	if len(terminates) > 0 {
+		// running in background. Don't cancel by client context.
+		go api.purgeSubdomains(context.Background(), terminates, duration)
+	}
+
+	return http.StatusOK, nil
+}
+
+// purgeTargets returns the duration and environments to be purged by the request, one per subdomain.
+// Environments which have been accessed in the duration are skipped later by purgeSubdomains.
+func (api *WebApi) purgeTargets(ctx context.Context, r *APIPurgeRequest) (time.Duration, []*Information, int, error) {
 	excludes := r.Excludes
 	excludeTags := r.ExcludeTags
+	if r.Duration == "" && api.cfg.Purge.defaultDuration() > 0 {
+		r.Duration = json.Number(strconv.FormatInt(int64(api.cfg.Purge.defaultDuration().Seconds()), 10))
+	}
 	di, err := r.Duration.Int64()
 	if err != nil {
 		msg := fmt.Sprintf("invalid duration %s", r.Duration)
 		slog.Error(msg)
-		return http.StatusBadRequest, errors.New(msg)
+		return 0, nil, http.StatusBadRequest, errors.New(msg)
 	}
-	mininum := int64(PurgeMinimumDuration.Seconds())
+	mininum := int64(api.cfg.Purge.minimumDuration().Seconds())
 	if di < mininum {
 		msg := fmt.Sprintf("invalid duration %d (at least %d)", di, mininum)
 		slog.Error(msg)
-		return http.StatusBadRequest, errors.New(msg)
+		return 0, nil, http.StatusBadRequest, errors.New(msg)
 	}
 
-	excludesMap := make(map[string]struct{}, len(excludes))
-	for _, exclude := range excludes {
-		excludesMap[exclude] = struct{}{}
+	excludesMatcher, err := newSubdomainMatcher(excludes)
+	if err != nil {
+		slog.Error(f("invalid excludes: %s", err))
+		return 0, nil, http.StatusBadRequest, err
+	}
+	onlyMatcher, err := newSubdomainMatcher(r.Only)
+	if err != nil {
+		slog.Error(f("invalid only: %s", err))
+		return 0, nil, http.StatusBadRequest, err
 	}
 	excludeTagsMap := make(map[string]string, len(excludeTags))
 	for _, excludeTag := range excludeTags {
@@ -402,32 +882,48 @@ func (api *WebApi) purge(c echo.Context) (int, error) {
 		if len(p) != 2 {
 			msg := fmt.Sprintf("invalid exclude_tags format %s", excludeTag)
 			slog.Error(msg)
-			return http.StatusBadRequest, errors.New(msg)
+			return 0, nil, http.StatusBadRequest, errors.New(msg)
 		}
 		k, v := p[0], p[1]
 		excludeTagsMap[k] = v
 	}
+	includeTags, err := parseIncludeTags(r.IncludeTags)
+	if err != nil {
+		slog.Error(err.Error())
+		return 0, nil, http.StatusBadRequest, err
+	}
 	duration := time.Duration(di) * time.Second
 
-	infos, err := api.runner.List(c.Request().Context(), statusRunning)
+	infos, err := api.runner.List(ctx, statusRunning)
 	if err != nil {
 		slog.Error(f("list ecs failed: %s", err))
-		return http.StatusInternalServerError, err
+		return 0, nil, http.StatusInternalServerError, err
 	}
-	slog.Info(f("purge subdomains: duration=%s, excludes=%v, exclude_tags=%v", duration, excludes, excludeTags))
+	slog.Info(f("purge subdomains: duration=%s, excludes=%v, exclude_tags=%v, only=%v, include_tags=%v, dry_run=%t", duration, excludes, excludeTags, r.Only, r.IncludeTags, r.DryRun))
 	tm := make(map[string]struct{}, len(infos))
+	targets := make([]*Information, 0, len(infos))
 	for _, info := range infos {
-		if info.ShouldBePurged(duration, excludesMap, excludeTagsMap) {
+		if excludesMatcher.match(info.SubDomain) || api.cfg.Purge.excluded(info) {
+			slog.Info(f("skip exclude subdomain: %s", info.SubDomain))
+			continue
+		}
+		if !onlyMatcher.empty() && !onlyMatcher.match(info.SubDomain) {
+			slog.Info(f("skip subdomain not in only: %s", info.SubDomain))
+			continue
+		}
+		if len(includeTags) > 0 && !hasAnyTag(info.Tags, includeTags) {
+			slog.Info(f("skip subdomain without include tags: %s", info.SubDomain))
+			continue
+		}
+		if _, ok := tm[info.SubDomain]; ok {
+			continue
+		}
+		if info.ShouldBePurged(duration, nil, excludeTagsMap) {
 			tm[info.SubDomain] = struct{}{}
+			targets = append(targets, info)
 		}
 	}
-	terminates := lo.Keys(tm)
-	if len(terminates) > 0 {
-		// running in background. Don't cancel by client context.
-		go api.purgeSubdomains(context.Background(), terminates, duration)
-	}
-
-	return http.StatusOK, nil
+	return duration, targets, http.StatusOK, nil
 }
 
 func (api *WebApi) purgeSubdomains(ctx context.Context, subdomains []string, duration time.Duration) {
@@ -437,6 +933,21 @@ func (api *WebApi) purgeSubdomains(ctx context.Context, subdomains []string, dur
 		slog.Info("skip purge subdomains, another purge is running")
 		return
 	}
+	if c := api.cfg.redis; c != nil {
+		// purge by only one of replicas
+		l, err := c.tryLock(ctx, redisPurgeKey, redisLockTTL)
+		if err != nil {
+			slog.Warn(f("failed to lock purge by redis: %s", err))
+			return
+		}
+		if l == nil {
+			slog.Info("skip purge subdomains, another replica is purging")
+			return
+		}
+		defer l.release()
+	}
+	api.health.purging(time.Now())
+	defer api.health.purging(time.Time{})
 	slog.Info(f("start purge subdomains %d", len(subdomains)))
 	purged := 0
 	for _, subdomain := range subdomains {
@@ -449,7 +960,16 @@ func (api *WebApi) purgeSubdomains(ctx context.Context, subdomains []string, dur
 			slog.Info(f("skip purge %s %d access", subdomain, sum))
 			continue
 		}
-		if err := api.runner.TerminateBySubdomain(ctx, subdomain); err != nil {
+		// accesses which are not put to CloudWatch yet, or restored from the persistence
+		if last := api.lastAccess(subdomain, time.Now().Add(-duration)); last != nil {
+			slog.Info(f("skip purge %s last access at %s", subdomain, last.Format(time.RFC3339)))
+			continue
+		}
+		if api.purgeWarner != nil {
+			api.warnPurge(ctx, subdomain)
+			continue
+		}
+		if err := api.runner.TerminateBySubdomain(withPurge(ctx), subdomain); err != nil {
 			slog.Warn(f("terminate failed %s %s", subdomain, err))
 		} else {
 			purged++
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"strings"
@@ -177,3 +178,40 @@ func TestValidateSubdomain(t *testing.T) {
 		}
 	}
 }
+
+func TestMergeForm(t *testing.T) {
+	r := &mirageecs.APILaunchRequest{}
+	r.MergeForm(url.Values{
+		"subdomain":   {"foo"},
+		"compression": {"true"},
+		"nick":        {"mirageman"},
+	})
+	if _, ok := r.Parameters["compression"]; ok {
+		t.Error("compression is an option of the launch request, not a parameter")
+	}
+	if r.Parameters["nick"] != "mirageman" {
+		t.Errorf("unexpected parameters: %v", r.Parameters)
+	}
+	// a parameter named compression would be swallowed, so it is rejected at startup
+	if err := mirageecs.ValidateParameterName("compression"); err == nil {
+		t.Error("parameter name compression should be rejected")
+	}
+}
+
+func TestTopAutoRefresh(t *testing.T) {
+	cfg, err := newConfigFromYAML(t, "htmldir: ./html\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := mirageecs.NewWebApi(cfg, &mirageecs.LocalTaskRunner{})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", rec.Code)
+	}
+	expected := fmt.Sprintf(`hx-trigger="load, every %ds [autoRefresh()]"`, int(mirageecs.ListRefreshInterval.Seconds()))
+	if !strings.Contains(rec.Body.String(), expected) {
+		t.Errorf("%s is not found in %s", expected, rec.Body.String())
+	}
+}
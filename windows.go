@@ -0,0 +1,97 @@
+package mirageecs
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/samber/lo"
+)
+
+// DefaultWindowsStartupTimeout is a grace period for starting up Windows tasks.
+// Windows container images are large, so it takes a long time to pull them.
+const DefaultWindowsStartupTimeout = 20 * time.Minute
+
+// Windows configures tasks of Windows task definitions.
+type Windows struct {
+	// StartupTimeout is used as the health check timeout and the relaunch timeout of Windows tasks
+	// when it is longer than them.
+	StartupTimeout time.Duration `yaml:"startup_timeout" json:"startup_timeout,omitempty"`
+	// LogConfiguration replaces log configurations of containers in Windows task definitions.
+	LogConfiguration *LogConfiguration `yaml:"log_configuration" json:"log_configuration,omitempty"`
+}
+
+// LogConfiguration is a log configuration of containers.
+type LogConfiguration struct {
+	LogDriver string            `yaml:"log_driver" json:"log_driver"`
+	Options   map[string]string `yaml:"options" json:"options,omitempty"`
+}
+
+func (w *Windows) validate() error {
+	if w == nil {
+		return nil
+	}
+	if w.StartupTimeout < 0 {
+		return fmt.Errorf("windows.startup_timeout must not be negative: %s", w.StartupTimeout)
+	}
+	if lc := w.LogConfiguration; lc != nil {
+		if !lo.Contains(types.LogDriver("").Values(), types.LogDriver(lc.LogDriver)) {
+			return fmt.Errorf("invalid windows.log_configuration.log_driver: %s", lc.LogDriver)
+		}
+		if types.LogDriver(lc.LogDriver) == types.LogDriverAwsfirelens {
+			return fmt.Errorf("awsfirelens is not supported by Windows containers")
+		}
+		if types.LogDriver(lc.LogDriver) == types.LogDriverAwslogs && lc.Options["awslogs-stream-prefix"] == "" {
+			slog.Warn("windows.log_configuration has no awslogs-stream-prefix option, so logs can't be shown by mirage-ecs")
+		}
+	}
+	return nil
+}
+
+func (w *Windows) startupTimeout() time.Duration {
+	if w == nil || w.StartupTimeout == 0 {
+		return DefaultWindowsStartupTimeout
+	}
+	return w.StartupTimeout
+}
+
+// timeoutFor returns the timeout for the task which is extended for Windows tasks.
+func (w *Windows) timeoutFor(info *Information, timeout time.Duration) time.Duration {
+	if !isWindowsFamily(info.OSFamily) {
+		return timeout
+	}
+	return max(timeout, w.startupTimeout())
+}
+
+func isWindowsFamily(family string) bool {
+	return strings.HasPrefix(family, "WINDOWS")
+}
+
+// osFamilyOf returns the OS family of the task definition. Empty means Linux.
+func osFamilyOf(td *types.TaskDefinition) string {
+	if td.RuntimePlatform == nil {
+		return ""
+	}
+	return string(td.RuntimePlatform.OperatingSystemFamily)
+}
+
+// windowsModifier replaces log configurations of containers in the Windows task definition.
+func windowsModifier(w *Windows) taskDefinitionModifier {
+	return func(in *ecs.RegisterTaskDefinitionInput) {
+		lc := w.LogConfiguration
+		containers := make([]types.ContainerDefinition, 0, len(in.ContainerDefinitions))
+		for _, c := range in.ContainerDefinitions {
+			c.LogConfiguration = &types.LogConfiguration{
+				LogDriver: types.LogDriver(lc.LogDriver),
+				Options:   lc.Options,
+			}
+			slog.Info(f("log driver of the Windows container %s: %s", aws.ToString(c.Name), lc.LogDriver))
+			containers = append(containers, c)
+		}
+		in.ContainerDefinitions = containers
+	}
+}
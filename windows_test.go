@@ -0,0 +1,78 @@
+package mirageecs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+
+	mirageecs "github.com/acidlemon/mirage-ecs/v2"
+)
+
+func TestWindowsValidate(t *testing.T) {
+	w := &mirageecs.Windows{
+		LogConfiguration: &mirageecs.LogConfiguration{
+			LogDriver: "awslogs",
+			Options:   map[string]string{"awslogs-group": "/ecs/windows", "awslogs-stream-prefix": "app"},
+		},
+	}
+	if err := w.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	w.LogConfiguration.LogDriver = "awsfirelens"
+	if err := w.Validate(); err == nil {
+		t.Error("expected error for awsfirelens")
+	}
+	w.LogConfiguration.LogDriver = "invalid"
+	if err := w.Validate(); err == nil {
+		t.Error("expected error for invalid log driver")
+	}
+}
+
+func TestWindowsTimeoutFor(t *testing.T) {
+	linux := &mirageecs.Information{}
+	windows := &mirageecs.Information{OSFamily: "WINDOWS_SERVER_2022_CORE"}
+	var empty *mirageecs.Windows
+	if d := empty.TimeoutFor(linux, 5*time.Minute); d != 5*time.Minute {
+		t.Errorf("unexpected timeout for linux: %s", d)
+	}
+	if d := empty.TimeoutFor(windows, 5*time.Minute); d != mirageecs.DefaultWindowsStartupTimeout {
+		t.Errorf("unexpected timeout for windows: %s", d)
+	}
+	w := &mirageecs.Windows{StartupTimeout: 10 * time.Minute}
+	if d := w.TimeoutFor(windows, 30*time.Minute); d != 30*time.Minute {
+		t.Errorf("longer timeout must be used: %s", d)
+	}
+}
+
+func TestWindowsLogConfiguration(t *testing.T) {
+	in := &ecs.RegisterTaskDefinitionInput{
+		RuntimePlatform: &types.RuntimePlatform{OperatingSystemFamily: types.OSFamilyWindowsServer2022Core},
+		ContainerDefinitions: []types.ContainerDefinition{
+			{
+				Name:             aws.String("app"),
+				LogConfiguration: &types.LogConfiguration{LogDriver: types.LogDriverAwsfirelens},
+			},
+			{Name: aws.String("worker")},
+		},
+	}
+	w := &mirageecs.Windows{
+		LogConfiguration: &mirageecs.LogConfiguration{
+			LogDriver: "awslogs",
+			Options:   map[string]string{"awslogs-group": "/ecs/windows", "awslogs-stream-prefix": "app"},
+		},
+	}
+	original := in.ContainerDefinitions
+	mirageecs.ApplyWindowsLogConfiguration(in, w)
+	if original[0].LogConfiguration.LogDriver != types.LogDriverAwsfirelens || original[1].LogConfiguration != nil {
+		t.Error("container definitions of the original task definition are modified")
+	}
+	for _, c := range in.ContainerDefinitions {
+		lc := c.LogConfiguration
+		if lc == nil || lc.LogDriver != types.LogDriverAwslogs || lc.Options["awslogs-group"] != "/ecs/windows" {
+			t.Errorf("unexpected log configuration of %s: %#v", aws.ToString(c.Name), lc)
+		}
+	}
+}